@@ -0,0 +1,374 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// these are the ways we can send abuse reports
+const (
+	// AbuseReportDroneBL sends reports to DroneBL's RPC2 API.
+	AbuseReportDroneBL = "dronebl"
+	// AbuseReportHTTP POSTs reports as JSON to any URL.
+	AbuseReportHTTP = "http"
+)
+
+const (
+	// AbuseReportQueueLength is how many reports can be waiting to be sent before we
+	// start dropping new ones.
+	AbuseReportQueueLength = 64
+	// defaultDroneBLURL is where DroneBL's RPC2 API lives.
+	defaultDroneBLURL = "https://dronebl.org/RPC2"
+	// defaultDroneBLType is the DroneBL listing type we use, if the config doesn't say (IRC drone).
+	defaultDroneBLType = 3
+)
+
+var (
+	errAbuseReportBadMethod = errors.New("Abuse reports method must be dronebl or http")
+	errAbuseReportNeedsKey  = errors.New("Abuse reports to DroneBL need an RPC key")
+	errAbuseReportNeedsURL  = errors.New("Abuse reports over http need a url")
+	errAbuseReportRejected  = errors.New("Abuse report was rejected")
+)
+
+// AbuseReportsConfig controls submitting the IPs of drones and other abusers to a
+// blacklist, such as DroneBL.
+type AbuseReportsConfig struct {
+	Enabled bool
+	Method  string
+	URL     string
+	// Key is the RPC key used with DroneBL.
+	Key     string
+	Headers map[string]string
+	// DroneBLType is the listing type the IPs are added to DroneBL with.
+	DroneBLType int `yaml:"dronebl-type"`
+	// DryRun logs the reports we would send, without sending them.
+	DryRun             bool             `yaml:"dry-run"`
+	RawExempted        []string         `yaml:"exempted"`
+	Exempted           []net.IPNet      `yaml:"exempted-real"`
+	RawDroneSignatures []string         `yaml:"drone-signatures"`
+	DroneSignatures    []*regexp.Regexp `yaml:"drone-signatures-real"`
+	TimeoutString      string           `yaml:"timeout"`
+	Timeout            time.Duration    `yaml:"timeout-real"`
+}
+
+// parse checks the config and fills in its defaults.
+func (conf *AbuseReportsConfig) parse() (err error) {
+	conf.Exempted, err = parseNetList(conf.RawExempted)
+	if err != nil {
+		return fmt.Errorf("Could not parse exempted: %s", err.Error())
+	}
+	conf.DroneSignatures = nil
+	for _, raw := range conf.RawDroneSignatures {
+		signature, err := regexp.Compile(raw)
+		if err != nil {
+			return fmt.Errorf("Could not parse drone signature [%s]: %s", raw, err.Error())
+		}
+		conf.DroneSignatures = append(conf.DroneSignatures, signature)
+	}
+	if !conf.Enabled {
+		return nil
+	}
+
+	switch conf.Method {
+	case "", AbuseReportDroneBL:
+		conf.Method = AbuseReportDroneBL
+		if conf.Key == "" {
+			return errAbuseReportNeedsKey
+		}
+		if conf.URL == "" {
+			conf.URL = defaultDroneBLURL
+		}
+		if conf.DroneBLType == 0 {
+			conf.DroneBLType = defaultDroneBLType
+		}
+	case AbuseReportHTTP:
+		if conf.URL == "" {
+			return errAbuseReportNeedsURL
+		}
+	default:
+		return errAbuseReportBadMethod
+	}
+	err = checkWebhook(conf.URL, true)
+	if err != nil {
+		return err
+	}
+
+	conf.Timeout = defaultAbuseReportTimeout
+	if conf.TimeoutString != "" {
+		conf.Timeout, err = time.ParseDuration(conf.TimeoutString)
+		if err != nil {
+			return fmt.Errorf("Could not parse timeout: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// AbuseReport is a single IP being reported. It's what we POST (as JSON) when using
+// the http method.
+type AbuseReport struct {
+	IP      string    `json:"ip"`
+	Reason  string    `json:"reason"`
+	Source  string    `json:"source"`
+	Server  string    `json:"server"`
+	Network string    `json:"network"`
+	Time    time.Time `json:"time"`
+}
+
+// abuseReportJob is a report waiting to be sent, along with the config to send it with.
+type abuseReportJob struct {
+	config AbuseReportsConfig
+	report AbuseReport
+}
+
+// AbuseReporter sends abuse reports in the background, making sure each IP is only
+// reported once in a while.
+type AbuseReporter struct {
+	sync.Mutex
+
+	config   AbuseReportsConfig
+	reported map[string]time.Time
+	jobs     chan abuseReportJob
+	logger   *logger.Manager
+}
+
+// NewAbuseReporter returns a new AbuseReporter, with its worker already running.
+func NewAbuseReporter(config AbuseReportsConfig, logger *logger.Manager) *AbuseReporter {
+	reporter := &AbuseReporter{
+		config:   config,
+		reported: make(map[string]time.Time),
+		jobs:     make(chan abuseReportJob, AbuseReportQueueLength),
+		logger:   logger,
+	}
+	go reporter.runWorker()
+	return reporter
+}
+
+// SetConfig changes how we send abuse reports.
+func (reporter *AbuseReporter) SetConfig(config AbuseReportsConfig) {
+	reporter.Lock()
+	defer reporter.Unlock()
+	reporter.config = config
+}
+
+// Enabled returns true if abuse reports are being sent.
+func (reporter *AbuseReporter) Enabled() bool {
+	reporter.Lock()
+	defer reporter.Unlock()
+	return reporter.config.Enabled
+}
+
+// MatchesDroneSignature returns true if the given message matches one of our drone signatures.
+func (reporter *AbuseReporter) MatchesDroneSignature(message string) bool {
+	reporter.Lock()
+	signatures := reporter.config.DroneSignatures
+	enabled := reporter.config.Enabled
+	reporter.Unlock()
+
+	if !enabled || len(signatures) == 0 {
+		return false
+	}
+	// match against the stripped message, so formatting can't be used to get around them
+	stripped := stripIRCFormatting(message)
+	for _, signature := range signatures {
+		if signature.MatchString(stripped) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report queues the given report. It returns false if the IP can't be reported, such
+// as when it's exempted or has already been reported recently.
+func (reporter *AbuseReporter) Report(report AbuseReport) bool {
+	ip := net.ParseIP(report.IP)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return false
+	}
+	report.IP = ip.String()
+	if report.Time.IsZero() {
+		report.Time = time.Now().UTC()
+	}
+
+	reporter.Lock()
+	defer reporter.Unlock()
+
+	if !reporter.config.Enabled || ipInNets(ip, reporter.config.Exempted) {
+		return false
+	}
+	now := time.Now()
+	for reportedIP, reportedAt := range reporter.reported {
+		if abuseReportRepeatDelay < now.Sub(reportedAt) {
+			delete(reporter.reported, reportedIP)
+		}
+	}
+	if _, recent := reporter.reported[report.IP]; recent {
+		return false
+	}
+
+	select {
+	case reporter.jobs <- abuseReportJob{config: reporter.config, report: report}:
+		reporter.reported[report.IP] = now
+		return true
+	default:
+		reporter.logger.Warning("abuse", fmt.Sprintf("Dropped abuse report for %s, our queue is full", report.IP))
+		return false
+	}
+}
+
+// runWorker sends the reports in our queue, logging each one so there's a record of
+// everything we've reported.
+func (reporter *AbuseReporter) runWorker() {
+	for job := range reporter.jobs {
+		report := job.report
+		if job.config.DryRun {
+			reporter.logger.Info("abuse", fmt.Sprintf("Would report %s to %s (dry run): %s [%s]", report.IP, job.config.Method, report.Reason, report.Source))
+			continue
+		}
+
+		var err error
+		if job.config.Method == AbuseReportDroneBL {
+			err = sendDroneBLReport(job.config, report)
+		} else {
+			err = sendHTTPAbuseReport(job.config, report)
+		}
+		if err != nil {
+			reporter.logger.Warning("abuse", fmt.Sprintf("Could not report %s to %s: %s", report.IP, job.config.Method, err.Error()))
+			continue
+		}
+		reporter.logger.Info("abuse", fmt.Sprintf("Reported %s to %s: %s [%s]", report.IP, job.config.Method, report.Reason, report.Source))
+	}
+}
+
+// droneBLResponse is DroneBL's answer to our request.
+type droneBLResponse struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message"`
+}
+
+// sendDroneBLReport adds the report's IP to DroneBL.
+func sendDroneBLReport(config AbuseReportsConfig, report AbuseReport) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><request key="`)
+	xml.EscapeText(&body, []byte(config.Key))
+	body.WriteString(`"><add ip="`)
+	xml.EscapeText(&body, []byte(report.IP))
+	body.WriteString(fmt.Sprintf(`" type="%d" comment="`, config.DroneBLType))
+	xml.EscapeText(&body, []byte(report.Reason))
+	body.WriteString(`" /></request>`)
+
+	output, err := postAbuseReport(config, "text/xml", body.Bytes())
+	if err != nil {
+		return err
+	}
+	var response droneBLResponse
+	err = xml.Unmarshal(output, &response)
+	if err != nil {
+		return err
+	}
+	if response.Type != "success" {
+		if response.Message != "" {
+			return fmt.Errorf("%s: %s", errAbuseReportRejected.Error(), response.Message)
+		}
+		return errAbuseReportRejected
+	}
+	return nil
+}
+
+// sendHTTPAbuseReport POSTs the report as JSON to the configured URL.
+func sendHTTPAbuseReport(config AbuseReportsConfig, report AbuseReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = postAbuseReport(config, "application/json", body)
+	return err
+}
+
+// postAbuseReport POSTs the given body to the configured URL, and returns the response.
+func postAbuseReport(config AbuseReportsConfig, contentType string, body []byte) ([]byte, error) {
+	request, err := http.NewRequest("POST", config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range config.Headers {
+		request.Header.Set(name, value)
+	}
+	httpClient := http.Client{
+		Timeout: config.Timeout,
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || 299 < response.StatusCode {
+		return nil, errAbuseReportRejected
+	}
+	var output bytes.Buffer
+	_, err = output.ReadFrom(response.Body)
+	return output.Bytes(), err
+}
+
+// reportAbuse reports the given IP to our blacklist, and tells opers about it.
+func (server *Server) reportAbuse(ip net.IP, reason string, source string) bool {
+	queued := server.abuseReports.Report(AbuseReport{
+		IP:      ip.String(),
+		Reason:  reason,
+		Source:  source,
+		Server:  server.name,
+		Network: server.Config().Network.Name,
+	})
+	if queued {
+		server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("Reporting $c[grey][$r%s$c[grey]] as abusive: %s [%s]"), ip.String(), reason, source))
+	}
+	return queued
+}
+
+// checkDroneSignature reports the client if the given message matches one of our
+// drone signatures.
+func (server *Server) checkDroneSignature(client *Client, message string) {
+	if server.abuseReports.MatchesDroneSignature(message) {
+		server.reportAbuse(client.IP(), "Drone", fmt.Sprintf("drone signature matched by %s", client.nickMaskString))
+	}
+}
+
+// reportBanned reports the given IPs and those of the clients that match, for DLINE and
+// KLINE REPORT, and tells the oper how many were reported.
+func (server *Server) reportBanned(client *Client, command string, reason string, ips []net.IP, matches func(*Client) bool) {
+	server.clients.ByNickMutex.RLock()
+	for _, mcl := range server.clients.ByNick {
+		if matches(mcl) {
+			ips = append(ips, mcl.IP())
+		}
+	}
+	server.clients.ByNickMutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var reported int
+	for _, ip := range ips {
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		if server.reportAbuse(ip, reason, fmt.Sprintf("%s by %s", command, client.nick)) {
+			reported++
+		}
+	}
+	client.Notice(fmt.Sprintf("Reported %d IPs as abusive", reported))
+}
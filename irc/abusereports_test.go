@@ -0,0 +1,106 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+)
+
+func TestAbuseReports(t *testing.T) {
+	bodies := make(chan string, 10)
+	blacklist := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.Write([]byte(`<?xml version="1.0"?><response type="success"></response>`))
+	}))
+	defer blacklist.Close()
+
+	config := AbuseReportsConfig{
+		Enabled:            true,
+		URL:                blacklist.URL,
+		Key:                "testkey",
+		RawExempted:        []string{"192.168.0.0/16"},
+		RawDroneSignatures: []string{"(?i)^come watch me"},
+	}
+	err := config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	logman, _ := logger.NewManager()
+	reporter := NewAbuseReporter(config, logman)
+
+	if !reporter.Report(AbuseReport{IP: "203.0.113.5", Reason: `Drone "bot"`}) {
+		t.Fatal("expected the report to be queued")
+	}
+	select {
+	case body := <-bodies:
+		if !strings.Contains(body, `<request key="testkey">`) || !strings.Contains(body, `<add ip="203.0.113.5" type="3" comment="Drone &#34;bot&#34;" />`) {
+			t.Errorf("unexpected DroneBL request: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("report wasn't sent")
+	}
+
+	if reporter.Report(AbuseReport{IP: "203.0.113.5", Reason: "Drone"}) {
+		t.Error("expected an IP that was just reported to not be reported again")
+	}
+	for _, ip := range []string{"192.168.1.1", "127.0.0.1", "::1", "not an ip"} {
+		if reporter.Report(AbuseReport{IP: ip, Reason: "Drone"}) {
+			t.Errorf("expected %s to not be reported", ip)
+		}
+	}
+
+	if !reporter.MatchesDroneSignature("\x02Come watch me\x02 on my webcam") {
+		t.Error("expected the drone signature to match a formatted message")
+	}
+	if reporter.MatchesDroneSignature("hi, come watch me") {
+		t.Error("expected the drone signature to not match another message")
+	}
+
+	config.DryRun = true
+	reporter.SetConfig(config)
+	if !reporter.Report(AbuseReport{IP: "203.0.113.6", Reason: "Drone"}) {
+		t.Fatal("expected the dry-run report to be queued")
+	}
+	select {
+	case body := <-bodies:
+		t.Errorf("expected dry-run report to not be sent, got %s", body)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	config.Enabled = false
+	reporter.SetConfig(config)
+	if reporter.Report(AbuseReport{IP: "203.0.113.7", Reason: "Drone"}) || reporter.MatchesDroneSignature("come watch me") {
+		t.Error("expected nothing to be reported while abuse reports are disabled")
+	}
+}
+
+func TestAbuseReportsConfig(t *testing.T) {
+	bad := []AbuseReportsConfig{
+		{Enabled: true},
+		{Enabled: true, Method: "carrier-pigeon", Key: "testkey"},
+		{Enabled: true, Method: AbuseReportHTTP},
+		{Enabled: true, Method: AbuseReportHTTP, URL: "not a url"},
+		{Enabled: true, Key: "testkey", RawExempted: []string{"nonsense"}},
+		{Enabled: true, Key: "testkey", RawDroneSignatures: []string{"("}},
+	}
+	for _, config := range bad {
+		if config.parse() == nil {
+			t.Errorf("expected abuse reports config %+v to be rejected", config)
+		}
+	}
+
+	config := AbuseReportsConfig{Enabled: true, Key: "testkey"}
+	err := config.parse()
+	if err != nil || config.URL != defaultDroneBLURL || config.DroneBLType != defaultDroneBLType {
+		t.Errorf("expected DroneBL defaults to be filled in, got %+v %v", config, err)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/tidwall/buntdb"
+)
+
+// callerIDNotifyInterval is how often a +g client is told that someone's trying to message them.
+const callerIDNotifyInterval = time.Minute
+
+// Accepts returns true if the given client is on our ACCEPT list, either by their account
+// or, if they weren't logged in when we accepted them, as that connection.
+func (client *Client) Accepts(sender *Client) bool {
+	if sender.account != &NoAccount {
+		accountKey, err := CasefoldName(sender.account.Name)
+		client.acceptedMutex.RLock()
+		accepted := err == nil && client.accepted[accountKey]
+		client.acceptedMutex.RUnlock()
+		if accepted {
+			return true
+		}
+	}
+
+	client.server.acceptedClientsMutex.RLock()
+	defer client.server.acceptedClientsMutex.RUnlock()
+	return client.acceptedClients[sender]
+}
+
+// AcceptList returns the (casefolded) account names on our ACCEPT list, followed by
+// the nicknames of the clients we accepted who weren't logged in.
+func (client *Client) AcceptList() []string {
+	names := client.acceptedAccounts()
+	var nicks []string
+	client.server.acceptedClientsMutex.RLock()
+	for accepted := range client.acceptedClients {
+		nicks = append(nicks, accepted.nick)
+	}
+	client.server.acceptedClientsMutex.RUnlock()
+	sort.Strings(nicks)
+	return append(names, nicks...)
+}
+
+// acceptedAccounts returns the (casefolded) account names on our ACCEPT list.
+func (client *Client) acceptedAccounts() []string {
+	client.acceptedMutex.RLock()
+	defer client.acceptedMutex.RUnlock()
+	var names []string
+	for name := range client.accepted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// acceptCount returns how many entries are on our ACCEPT list.
+func (client *Client) acceptCount() int {
+	client.acceptedMutex.RLock()
+	count := len(client.accepted)
+	client.acceptedMutex.RUnlock()
+	client.server.acceptedClientsMutex.RLock()
+	defer client.server.acceptedClientsMutex.RUnlock()
+	return count + len(client.acceptedClients)
+}
+
+// addAccepted adds the given account names to our ACCEPT list, up to the given limit.
+func (client *Client) addAccepted(limit int, names ...string) {
+	for _, name := range names {
+		if limit <= client.acceptCount() {
+			return
+		}
+		client.acceptedMutex.Lock()
+		client.accepted[name] = true
+		client.acceptedMutex.Unlock()
+	}
+}
+
+// acceptClient adds the given connection to our ACCEPT list. It returns false if they've
+// already left the server.
+func (client *Client) acceptClient(target *Client) bool {
+	server := client.server
+	server.acceptedClientsMutex.Lock()
+	defer server.acceptedClientsMutex.Unlock()
+	if target.acceptedGone || client.acceptedGone {
+		return false
+	}
+	if client.acceptedClients == nil {
+		client.acceptedClients = make(map[*Client]bool)
+	}
+	if target.acceptedBy == nil {
+		target.acceptedBy = make(map[*Client]bool)
+	}
+	client.acceptedClients[target] = true
+	target.acceptedBy[client] = true
+	return true
+}
+
+// unacceptClient removes the given connection from our ACCEPT list, returning true if
+// they were on it.
+func (client *Client) unacceptClient(target *Client) bool {
+	server := client.server
+	server.acceptedClientsMutex.Lock()
+	defer server.acceptedClientsMutex.Unlock()
+	exists := client.acceptedClients[target]
+	delete(client.acceptedClients, target)
+	delete(target.acceptedBy, client)
+	return exists
+}
+
+// forgetAccepted removes us from everyone's ACCEPT list (and clears our own) when we
+// leave the server, so that the clients involved can be freed.
+func (client *Client) forgetAccepted() {
+	server := client.server
+	server.acceptedClientsMutex.Lock()
+	defer server.acceptedClientsMutex.Unlock()
+	for accepter := range client.acceptedBy {
+		delete(accepter.acceptedClients, client)
+	}
+	for accepted := range client.acceptedClients {
+		delete(accepted.acceptedBy, client)
+	}
+	client.acceptedBy = nil
+	client.acceptedClients = nil
+	client.acceptedGone = true
+}
+
+// callerIDBlocks returns true if our +g mode stops the given client from messaging us. If
+// notify is true, they're told about it, and so are we (at most once every so often).
+func (client *Client) callerIDBlocks(sender *Client, notify bool) bool {
+	if !client.HasMode(CallerID) || client == sender || sender.HasMode(Operator) || client.Accepts(sender) {
+		return false
+	}
+	if !notify {
+		return true
+	}
+
+	server := client.server
+	sender.Send(nil, server.name, ERR_TARGUMODEG, sender.nick, client.nick, sender.t("is in +g mode (server-side ignore)"))
+
+	client.acceptedMutex.Lock()
+	notifyClient := callerIDNotifyInterval < time.Since(client.callerIDNotified)
+	if notifyClient {
+		client.callerIDNotified = time.Now()
+	}
+	client.acceptedMutex.Unlock()
+
+	if notifyClient {
+		client.Send(nil, server.name, RPL_UMODEGMSG, client.nick, sender.nick, fmt.Sprintf("%s@%s", sender.username, sender.hostname), client.t("is messaging you, and you have user mode +g set. Use /ACCEPT <nick> to allow them to message you."))
+		sender.Send(nil, server.name, RPL_TARGNOTIFY, sender.nick, client.nick, sender.t("has been informed that you messaged them."))
+	}
+	return true
+}
+
+// saveCallerID stores whether we have caller ID (+g) set in our account, if the given
+// mode changes set or unset it. Push notifications use it while we're not connected.
+func (client *Client) saveCallerID(applied ModeChanges) {
+	account := client.account
+	if account == &NoAccount {
+		return
+	}
+	for _, change := range applied {
+		if change.mode != CallerID {
+			continue
+		}
+		enabled := change.op == Add
+		err := client.server.saveAccountSetting(account, keyAccountCallerID, enabled)
+		if err != nil {
+			client.server.logger.Error("internal", fmt.Sprintf("Could not save caller ID setting for account %s: %s", account.Name, err.Error()))
+			return
+		}
+		account.CallerID = enabled
+	}
+}
+
+// saveAcceptList stores the accounts on our ACCEPT list in our account, so we get them
+// back next time we log in.
+func (client *Client) saveAcceptList() {
+	if client.account == &NoAccount {
+		return
+	}
+
+	names := client.acceptedAccounts()
+	client.account.Accepted = names
+	accountKey, err := CasefoldName(client.account.Name)
+	if err == nil {
+		err = client.server.store.Update(func(tx *buntdb.Tx) error {
+			key := fmt.Sprintf(keyAccountAccept, accountKey)
+			if len(names) == 0 {
+				tx.Delete(key)
+				return nil
+			}
+			_, _, err := tx.Set(key, strings.Join(names, " "), nil)
+			return err
+		})
+	}
+	if err != nil {
+		client.server.logger.Error("internal", fmt.Sprintf("Could not save accept list for account %s: %s", client.account.Name, err.Error()))
+	}
+}
+
+// ACCEPT <nick>{,<nick>}
+// ACCEPT -<nick>{,-<nick>}
+// ACCEPT *
+func acceptHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if msg.Params[0] == "*" {
+		for _, name := range client.AcceptList() {
+			client.Send(nil, server.name, RPL_ACCEPTLIST, client.nick, name)
+		}
+		client.Send(nil, server.name, RPL_ENDOFACCEPT, client.nick, client.t("End of /ACCEPT list"))
+		return false
+	}
+
+	var changed bool
+	for _, target := range strings.Split(msg.Params[0], ",") {
+		remove := strings.HasPrefix(target, "-")
+		target = strings.TrimPrefix(strings.TrimPrefix(target, "-"), "+")
+		if target == "" {
+			continue
+		}
+		name, err := CasefoldName(target)
+		if err != nil {
+			client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, target, client.t("Erroneous nickname"))
+			continue
+		}
+		targetClient := server.clients.Get(name)
+
+		if remove {
+			// this can be an account on the list, or a client who's online now
+			accountKeys := []string{name}
+			if targetClient != nil && targetClient.account != &NoAccount {
+				accountKey, err := CasefoldName(targetClient.account.Name)
+				if err == nil {
+					accountKeys = append(accountKeys, accountKey)
+				}
+			}
+			var removed bool
+			client.acceptedMutex.Lock()
+			for _, accountKey := range accountKeys {
+				if client.accepted[accountKey] {
+					delete(client.accepted, accountKey)
+					removed = true
+					changed = true
+				}
+			}
+			client.acceptedMutex.Unlock()
+			if targetClient != nil && client.unacceptClient(targetClient) {
+				removed = true
+			}
+			if !removed {
+				client.Send(nil, server.name, ERR_ACCEPTNOT, client.nick, target, client.t("is not on your accept list"))
+			}
+			continue
+		}
+
+		if targetClient == nil {
+			client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, target, client.t("No such nick"))
+			continue
+		}
+		if client.Accepts(targetClient) {
+			client.Send(nil, server.name, ERR_ACCEPTEXIST, client.nick, target, client.t("is already on your accept list"))
+			continue
+		}
+		if server.Limits().AcceptEntries <= client.acceptCount() {
+			client.Send(nil, server.name, ERR_ACCEPTFULL, client.nick, strconv.Itoa(server.Limits().AcceptEntries), client.t("Accept list is full"))
+			break
+		}
+
+		// accept logged-in clients by their account, so it keeps working when they change
+		// nick or reconnect, and nobody else can pick up their nick to get through.
+		// everyone else is only accepted until they leave the server
+		if targetClient.account != &NoAccount {
+			accountKey, err := CasefoldName(targetClient.account.Name)
+			if err == nil {
+				client.acceptedMutex.Lock()
+				client.accepted[accountKey] = true
+				client.acceptedMutex.Unlock()
+				changed = true
+			}
+		} else if !client.acceptClient(targetClient) {
+			client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, target, client.t("No such nick"))
+		}
+	}
+
+	if changed {
+		client.saveAcceptList()
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestAcceptClient(t *testing.T) {
+	server := newTestServer()
+	dan := newTestClient(server, "dan")
+	alice := newTestClient(server, "alice")
+	bob := newTestClient(server, "bob")
+
+	if !dan.acceptClient(alice) || !dan.Accepts(alice) {
+		t.Error("expected alice to be accepted")
+	}
+	if dan.Accepts(bob) {
+		t.Error("expected bob not to be accepted")
+	}
+
+	// someone else taking alice's nick doesn't get them through
+	alice.forgetAccepted()
+	newAlice := newTestClient(server, "alice")
+	if dan.Accepts(alice) || dan.Accepts(newAlice) {
+		t.Error("expected alice to be forgotten after leaving")
+	}
+	if dan.acceptCount() != 0 {
+		t.Errorf("expected an empty accept list, got %v", dan.AcceptList())
+	}
+	if dan.acceptClient(alice) {
+		t.Error("expected clients who've left not to be accepted")
+	}
+}
+
+func TestAcceptAccount(t *testing.T) {
+	server := newTestServer()
+	dan := newTestClient(server, "dan")
+	dan.accepted = make(map[string]bool)
+	alice := newTestClient(server, "alice")
+	alice.account = &ClientAccount{Name: "Alice"}
+
+	dan.addAccepted(10, "alice")
+	if !dan.Accepts(alice) {
+		t.Error("expected alice to be accepted by their account")
+	}
+	alice.account = &NoAccount
+	if dan.Accepts(alice) {
+		t.Error("expected alice not to be accepted after logging out")
+	}
+}
@@ -34,7 +34,9 @@ type AccountRegistration struct {
 type AccountCredentials struct {
 	PassphraseSalt []byte
 	PassphraseHash []byte
-	Certificate    string // fingerprint
+	Certificate    string // fingerprint the account was registered with
+	// Certificates are the fingerprints added with NS CERT
+	Certificates []string `json:",omitempty"`
 }
 
 // NewAccountRegistration returns a new AccountRegistration, configured correctly.
@@ -73,9 +75,10 @@ func accHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 }
 
 // removeFailedAccRegisterData removes the data created by ACC REGISTER if the account creation fails early.
-func removeFailedAccRegisterData(store *buntdb.DB, account string) {
+func removeFailedAccRegisterData(server *Server, account string) {
+	server.accountSkeletons.Remove(account)
 	// error is ignored here, we can't do much about it anyways
-	store.Update(func(tx *buntdb.Tx) error {
+	server.store.Update(func(tx *buntdb.Tx) error {
 		tx.Delete(fmt.Sprintf(keyAccountExists, account))
 		tx.Delete(fmt.Sprintf(keyAccountRegTime, account))
 		tx.Delete(fmt.Sprintf(keyAccountCredentials, account))
@@ -87,7 +90,7 @@ func removeFailedAccRegisterData(store *buntdb.DB, account string) {
 // accRegisterHandler parses the ACC REGISTER command.
 func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// make sure reg is enabled
-	if !server.accountRegistration.Enabled {
+	if !server.AccountRegistration().Enabled {
 		client.Send(nil, server.name, ERR_REG_UNSPECIFIED_ERROR, client.nick, "*", "Account registration is disabled")
 		return false
 	}
@@ -100,6 +103,18 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		client.Send(nil, server.name, ERR_REG_UNSPECIFIED_ERROR, client.nick, account, "Account name is not valid")
 		return false
 	}
+	if server.IsReservedNickname(client, casefoldedAccount) {
+		client.Send(nil, server.name, ERR_REG_UNSPECIFIED_ERROR, client.nick, account, client.t("Account name is reserved"))
+		return false
+	}
+	err = server.checkConfusable(casefoldedAccount, "")
+	if err == errNameConfusable {
+		client.Send(nil, server.name, ERR_ACCOUNT_ALREADY_EXISTS, client.nick, account, client.t("Account name is too similar to an existing account"))
+		return false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_REG_UNSPECIFIED_ERROR, client.nick, account, client.t("Account name mixes characters from different scripts"))
+		return false
+	}
 
 	// check whether account exists
 	// do it all in one write tx to prevent races
@@ -140,14 +155,14 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		callbackValues := strings.SplitN(callback, ":", 2)
 		callbackNamespace, callbackValue = callbackValues[0], callbackValues[1]
 	} else {
-		callbackNamespace = server.accountRegistration.EnabledCallbacks[0]
+		callbackNamespace = server.AccountRegistration().EnabledCallbacks[0]
 		callbackValue = callback
 	}
 
 	// ensure the callback namespace is valid
 	// need to search callback list, maybe look at using a map later?
 	var callbackValid bool
-	for _, name := range server.accountRegistration.EnabledCallbacks {
+	for _, name := range server.AccountRegistration().EnabledCallbacks {
 		if callbackNamespace == name {
 			callbackValid = true
 		}
@@ -155,7 +170,7 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 
 	if !callbackValid {
 		client.Send(nil, server.name, ERR_REG_INVALID_CALLBACK, client.nick, account, callbackNamespace, "Callback namespace is not supported")
-		removeFailedAccRegisterData(server.store, casefoldedAccount)
+		removeFailedAccRegisterData(server, casefoldedAccount)
 		return false
 	}
 
@@ -169,27 +184,27 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		credentialType = "passphrase" // default from the spec
 		credentialValue = msg.Params[3]
 	} else {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
-		removeFailedAccRegisterData(server.store, casefoldedAccount)
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		removeFailedAccRegisterData(server, casefoldedAccount)
 		return false
 	}
 
 	// ensure the credential type is valid
 	var credentialValid bool
-	for _, name := range server.accountRegistration.EnabledCredentialTypes {
+	for _, name := range server.AccountRegistration().EnabledCredentialTypes {
 		if credentialType == name {
 			credentialValid = true
 		}
 	}
 	if credentialType == "certfp" && client.certfp == "" {
 		client.Send(nil, server.name, ERR_REG_INVALID_CRED_TYPE, client.nick, credentialType, callbackNamespace, "You are not using a TLS certificate")
-		removeFailedAccRegisterData(server.store, casefoldedAccount)
+		removeFailedAccRegisterData(server, casefoldedAccount)
 		return false
 	}
 
 	if !credentialValid {
 		client.Send(nil, server.name, ERR_REG_INVALID_CRED_TYPE, client.nick, credentialType, callbackNamespace, "Credential type is not supported")
-		removeFailedAccRegisterData(server.store, casefoldedAccount)
+		removeFailedAccRegisterData(server, casefoldedAccount)
 		return false
 	}
 
@@ -220,7 +235,7 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		if credentialType == "certfp" {
 			creds.Certificate = client.certfp
 		} else if credentialType == "passphrase" {
-			creds.PassphraseHash, err = server.passwords.GenerateFromPassword(creds.PassphraseSalt, credentialValue)
+			creds.PassphraseHash, err = server.Passwords().GenerateFromPassword(creds.PassphraseSalt, credentialValue)
 			if err != nil {
 				return fmt.Errorf("Could not hash password: %s", err)
 			}
@@ -242,9 +257,10 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		}
 		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "ACC", "REGISTER", errMsg)
 		log.Println("Could not save registration creds:", err.Error())
-		removeFailedAccRegisterData(server.store, casefoldedAccount)
+		removeFailedAccRegisterData(server, casefoldedAccount)
 		return false
 	}
+	server.accountSkeletons.Add(casefoldedAccount)
 
 	// automatically complete registration
 	if callbackNamespace == "*" {
@@ -257,20 +273,25 @@ func accRegisterHandler(server *Server, client *Client, msg ircmsg.IrcMessage) b
 				RegisteredAt: time.Now(),
 				Clients:      []*Client{client},
 			}
-			//TODO(dan): Consider creating ircd-wide account adding/removing/affecting lock for protecting access to these sorts of variables
+			server.accountsMutex.Lock()
 			server.accounts[casefoldedAccount] = &account
+			server.accountsMutex.Unlock()
 			client.account = &account
 
 			client.Send(nil, server.name, RPL_REGISTRATION_SUCCESS, client.nick, account.Name, "Account created")
 			client.Send(nil, server.name, RPL_LOGGEDIN, client.nick, client.nickMaskString, account.Name, fmt.Sprintf("You are now logged in as %s", account.Name))
 			client.Send(nil, server.name, RPL_SASLSUCCESS, client.nick, "Authentication successful")
 			server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Account registered $c[grey][$r%s$c[grey]] by $c[grey][$r%s$c[grey]]"), account.Name, client.nickMaskString))
+			server.sendWebhook(WebhookAccountRegister, fmt.Sprintf("Account %s registered by %s", account.Name, client.nickMaskString), map[string]string{
+				"account": account.Name,
+				"nick":    client.nick,
+			})
 			return nil
 		})
 		if err != nil {
 			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "ACC", "REGISTER", "Could not register")
 			log.Println("Could not save verification confirmation (*):", err.Error())
-			removeFailedAccRegisterData(server.store, casefoldedAccount)
+			removeFailedAccRegisterData(server, casefoldedAccount)
 			return false
 		}
 
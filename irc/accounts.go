@@ -25,15 +25,30 @@ const (
 	keyAccountName        = "account.name %s" // stores the 'preferred name' of the account, not casemapped
 	keyAccountRegTime     = "account.registered.time %s"
 	keyAccountCredentials = "account.credentials %s"
+	keyAccountLanguages   = "account.languages %s"
+	keyAccountAccept      = "account.accept %s"
+	keyAccountAutoAway    = "account.autoaway %s"
+	keyAccountAutoJoin    = "account.autojoin %s"
+	keyAccountCallerID    = "account.callerid %s"
+	keyAccountHideIdle    = "account.hideidle %s"
+	keyAccountNoHistory   = "account.nohistory %s"
+	keyAccountRegOnly     = "account.regonly %s"
+	keyAccountVHost       = "account.vhost %s"
+	keyAccountVHostOff    = "account.vhostoff %s"
+	keyAccountWebhook     = "account.webhook %s"
 	keyCertToAccount      = "account.creds.certfp %s"
+
+	// channel expiry warnings waiting for the founder to log in
+	keyAccountChannelWarnings = "account.channelwarnings %s"
 )
 
 var (
 	// EnabledSaslMechanisms contains the SASL mechanisms that exist and that we support.
 	// This can be moved to some other data structure/place if we need to load/unload mechs later.
 	EnabledSaslMechanisms = map[string]func(*Server, *Client, string, []byte) bool{
-		"PLAIN":    authPlainHandler,
-		"EXTERNAL": authExternalHandler,
+		"PLAIN":       authPlainHandler,
+		"EXTERNAL":    authExternalHandler,
+		"OAUTHBEARER": authOAuthBearerHandler,
 	}
 
 	// NoAccount is a placeholder which means that the user is not logged into an account.
@@ -53,6 +68,27 @@ type ClientAccount struct {
 	RegisteredAt time.Time
 	// Clients that are currently logged into this account (useful for notifications).
 	Clients []*Client
+	// Languages are the languages this account prefers, set with the LANGUAGE command.
+	Languages []string
+	// Accepted are the (casefolded) accounts this account lets message it while +g, set with the ACCEPT command.
+	Accepted []string
+	// AutoAway is true if this account's clients are marked away after being idle.
+	AutoAway bool
+	// CallerID is true if this account's clients set user mode +g, which also stops
+	// push notifications from clients not on its ACCEPT list.
+	CallerID bool
+	// AutoJoin are the channels this account's clients are joined to when they connect.
+	AutoJoin []string
+	// HideIdle is true if this account's idle and signon times are hidden from WHOIS.
+	HideIdle bool
+	// NoHistory is true if this account's direct messages aren't stored in conversation history.
+	NoHistory bool
+	// RegOnly is true if this account only takes private messages from other accounts (user mode +R).
+	RegOnly bool
+	// VHost is the vhost opers have given this account, and VHostEnabled is false if
+	// the user's turned it off.
+	VHost        string
+	VHostEnabled bool
 }
 
 // loadAccountCredentials loads an account's credentials from the store.
@@ -71,25 +107,89 @@ func loadAccountCredentials(tx *buntdb.Tx, accountKey string) (*AccountCredentia
 	return &creds, nil
 }
 
-// loadAccount loads an account from the store, note that the account must actually exist.
+// updateAccountPassphrase hashes the given passphrase with our current settings,
+// and stores it as the account's new passphrase.
+func updateAccountPassphrase(server *Server, tx *buntdb.Tx, accountKey string, creds *AccountCredentials, passphrase string) error {
+	hash, err := server.Passwords().GenerateFromPassword(creds.PassphraseSalt, passphrase)
+	if err != nil {
+		return err
+	}
+	newCreds := *creds
+	newCreds.PassphraseHash = hash
+	credText, err := json.Marshal(newCreds)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set(fmt.Sprintf(keyAccountCredentials, accountKey), string(credText), nil)
+	return err
+}
+
+// loadAccount returns the given account, loading it from the store if we haven't already.
+// Note that the account must actually exist.
 func loadAccount(server *Server, tx *buntdb.Tx, accountKey string) *ClientAccount {
+	account, exists := server.loadedAccount(accountKey)
+	if exists {
+		return account
+	}
+	accountInfo := readAccount(tx, accountKey)
+
+	server.accountsMutex.Lock()
+	defer server.accountsMutex.Unlock()
+	// someone else may have loaded it while we were
+	account, exists = server.accounts[accountKey]
+	if exists {
+		return account
+	}
+	server.accounts[accountKey] = accountInfo
+	return accountInfo
+}
+
+// readAccount reads the given account from the store without adding it to the loaded
+// accounts, for things that only want to look at it.
+func readAccount(tx *buntdb.Tx, accountKey string) *ClientAccount {
 	name, _ := tx.Get(fmt.Sprintf(keyAccountName, accountKey))
 	regTime, _ := tx.Get(fmt.Sprintf(keyAccountRegTime, accountKey))
 	regTimeInt, _ := strconv.ParseInt(regTime, 10, 64)
+	languages, _ := tx.Get(fmt.Sprintf(keyAccountLanguages, accountKey))
+	accepted, _ := tx.Get(fmt.Sprintf(keyAccountAccept, accountKey))
+	_, autoAwayErr := tx.Get(fmt.Sprintf(keyAccountAutoAway, accountKey))
+	autoJoin, _ := tx.Get(fmt.Sprintf(keyAccountAutoJoin, accountKey))
+	_, callerIDErr := tx.Get(fmt.Sprintf(keyAccountCallerID, accountKey))
+	_, hideIdleErr := tx.Get(fmt.Sprintf(keyAccountHideIdle, accountKey))
+	_, noHistoryErr := tx.Get(fmt.Sprintf(keyAccountNoHistory, accountKey))
+	_, regOnlyErr := tx.Get(fmt.Sprintf(keyAccountRegOnly, accountKey))
+	vhost, _ := tx.Get(fmt.Sprintf(keyAccountVHost, accountKey))
+	_, vhostOffErr := tx.Get(fmt.Sprintf(keyAccountVHostOff, accountKey))
 	accountInfo := ClientAccount{
 		Name:         name,
 		RegisteredAt: time.Unix(regTimeInt, 0),
 		Clients:      []*Client{},
+		Languages:    strings.Fields(languages),
+		Accepted:     strings.Fields(accepted),
+		AutoAway:     autoAwayErr == nil,
+		AutoJoin:     strings.Fields(autoJoin),
+		CallerID:     callerIDErr == nil,
+		HideIdle:     hideIdleErr == nil,
+		NoHistory:    noHistoryErr == nil,
+		RegOnly:      regOnlyErr == nil,
+		VHost:        vhost,
+		VHostEnabled: vhostOffErr != nil,
 	}
-	server.accounts[accountKey] = &accountInfo
-
 	return &accountInfo
 }
 
+// loadedAccount returns the given account, if we've loaded it.
+func (server *Server) loadedAccount(accountKey string) (*ClientAccount, bool) {
+	server.accountsMutex.RLock()
+	defer server.accountsMutex.RUnlock()
+	account, exists := server.accounts[accountKey]
+	return account, exists
+}
+
 // authenticateHandler parses the AUTHENTICATE command (for SASL authentication).
 func authenticateHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// sasl abort
-	if !server.accountAuthenticationEnabled || len(msg.Params) == 1 && msg.Params[0] == "*" {
+	if !server.Config().Accounts.AuthenticationEnabled || len(msg.Params) == 1 && msg.Params[0] == "*" {
 		client.Send(nil, server.name, ERR_SASLABORTED, client.nick, "SASL authentication aborted")
 		client.saslInProgress = false
 		client.saslMechanism = ""
@@ -101,6 +201,9 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.IrcMessage)
 	if !client.saslInProgress {
 		mechanism := strings.ToUpper(msg.Params[0])
 		_, mechanismIsEnabled := EnabledSaslMechanisms[mechanism]
+		if mechanism == "OAUTHBEARER" && !server.oauth2.Enabled() {
+			mechanismIsEnabled = false
+		}
 
 		if mechanismIsEnabled {
 			client.saslInProgress = true
@@ -124,8 +227,13 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.IrcMessage)
 		return false
 	} else if len(rawData) == 400 {
 		client.saslValue += rawData
-		// allow 4 'continuation' lines before rejecting for length
-		if len(client.saslValue) > 400*4 {
+		// allow 4 'continuation' lines before rejecting for length, or more for bearer
+		// tokens, which are often longer than that
+		maxLines := 4
+		if client.saslMechanism == "OAUTHBEARER" {
+			maxLines = 20
+		}
+		if len(client.saslValue) > 400*maxLines {
 			client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Passphrase too long")
 			client.saslInProgress = false
 			client.saslMechanism = ""
@@ -195,15 +303,32 @@ func authPlainHandler(server *Server, client *Client, mechanism string, value []
 		return false
 	}
 
+	account, err := server.checkAccountPassphrase(client, accountKey, string(splitValue[2]))
+	if err == errLoginThrottled {
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Too many failed logins, try again later")
+		return false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
+		return false
+	}
+
+	client.LoginToAccount(account)
+	client.successfulSaslAuth()
+	return false
+}
+
+// checkLocalPassphrase returns the account that the given name and passphrase log into,
+// checking them against the passphrases we've stored.
+func (server *Server) checkLocalPassphrase(name string, passphrase string) (*ClientAccount, error) {
 	// keep it the same as in the REG CREATE stage
-	accountKey, err := CasefoldName(accountKey)
+	accountKey, err := CasefoldName(name)
 	if err != nil {
-		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Bad account name")
-		return false
+		return nil, errSaslFail
 	}
 
 	// load and check acct data all in one update to prevent races.
 	// as noted elsewhere, change to proper locking for Account type later probably
+	var account *ClientAccount
 	err = server.store.Update(func(tx *buntdb.Tx) error {
 		// confirm account is verified
 		_, err = tx.Get(fmt.Sprintf(keyAccountVerified, accountKey))
@@ -217,30 +342,31 @@ func authPlainHandler(server *Server, client *Client, mechanism string, value []
 		}
 
 		// ensure creds are valid
-		password := string(splitValue[2])
-		if len(creds.PassphraseHash) < 1 || len(creds.PassphraseSalt) < 1 || len(password) < 1 {
+		if len(creds.PassphraseHash) < 1 || len(creds.PassphraseSalt) < 1 || len(passphrase) < 1 {
 			return errSaslFail
 		}
-		err = server.passwords.CompareHashAndPassword(creds.PassphraseHash, creds.PassphraseSalt, password)
-
-		// succeeded, load account info if necessary
-		account, exists := server.accounts[accountKey]
-		if !exists {
-			account = loadAccount(server, tx, accountKey)
+		passwords := server.Passwords()
+		err = passwords.CompareHashAndPassword(creds.PassphraseHash, creds.PassphraseSalt, passphrase)
+		if err != nil {
+			return err
 		}
 
-		client.LoginToAccount(account)
+		// rehash the password if our hashing settings have changed since it was stored
+		if passwords.Outdated(creds.PassphraseHash) {
+			err = updateAccountPassphrase(server, tx, accountKey, creds, passphrase)
+			if err != nil {
+				server.logger.Error("accounts", fmt.Sprintf("Could not rehash password for account %s: %s", accountKey, err.Error()))
+			}
+		}
 
-		return err
+		// succeeded, load account info if necessary
+		account = loadAccount(server, tx, accountKey)
+		return nil
 	})
-
 	if err != nil {
-		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
-		return false
+		return nil, err
 	}
-
-	client.successfulSaslAuth()
-	return false
+	return account, nil
 }
 
 // LoginToAccount logs the client into the given account.
@@ -250,28 +376,144 @@ func (client *Client) LoginToAccount(account *ClientAccount) {
 		return
 	} else if client.account != nil {
 		// logout of existing acct
-		var newClientAccounts []*Client
-		for _, c := range account.Clients {
-			if c != client {
-				newClientAccounts = append(newClientAccounts, c)
-			}
-		}
-		account.Clients = newClientAccounts
+		client.removeFromAccount()
 	}
 
+	client.server.accountsMutex.Lock()
 	account.Clients = append(account.Clients, client)
+	client.server.accountsMutex.Unlock()
 	client.account = account
+	if 0 < len(account.Languages) {
+		client.SetLanguages(client.server.Languages().Filter(account.Languages))
+	}
+	client.addAccepted(client.server.Limits().AcceptEntries, account.Accepted...)
+	if account.RegOnly {
+		client.setRegOnly(true)
+	}
+	if account.CallerID {
+		client.setModeAndTell(CallerID, true)
+	}
+	if account.AutoAway {
+		client.resetAutoAway()
+	}
+	if account.VHost != "" && account.VHostEnabled {
+		client.setVHost(account.VHost)
+	}
 	client.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] logged into account $c[grey][$r%s$c[grey]]"), client.nickMaskString, account.Name))
 }
 
+// LogoutOfAccount logs the client out of their account, undoing the modes and vhost that
+// logging in gave them.
+func (client *Client) LogoutOfAccount() {
+	account := client.account
+	if account == &NoAccount {
+		return
+	}
+
+	client.removeFromAccount()
+	client.account = &NoAccount
+	if account.RegOnly {
+		client.setRegOnly(false)
+	}
+	if account.AutoAway {
+		client.resetAutoAway()
+	}
+	if account.VHost != "" && account.VHostEnabled {
+		// falls back to their oper vhost, if they have one
+		client.setVHost(client.accountVHost())
+	}
+	client.Send(nil, client.server.name, RPL_LOGGEDOUT, client.nick, client.nickMaskString, client.t("You are now logged out"))
+	client.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] logged out of account $c[grey][$r%s$c[grey]]"), client.nickMaskString, account.Name))
+}
+
+// saveAccountSetting saves an on/off setting for the given account, stored under keyFormat.
+func (server *Server) saveAccountSetting(account *ClientAccount, keyFormat string, enabled bool) error {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return err
+	}
+	return server.store.Update(func(tx *buntdb.Tx) error {
+		key := fmt.Sprintf(keyFormat, accountKey)
+		if !enabled {
+			tx.Delete(key)
+			return nil
+		}
+		_, _, err := tx.Set(key, "1", nil)
+		return err
+	})
+}
+
+// removeFromAccount removes the client from the list of clients logged into its account.
+func (client *Client) removeFromAccount() {
+	account := client.account
+	if account == &NoAccount {
+		return
+	}
+	client.server.accountsMutex.Lock()
+	defer client.server.accountsMutex.Unlock()
+	var newClientAccounts []*Client
+	for _, c := range account.Clients {
+		if c != client {
+			newClientAccounts = append(newClientAccounts, c)
+		}
+	}
+	account.Clients = newClientAccounts
+}
+
+// accountClients returns the clients logged into the given account.
+func (server *Server) accountClients(account *ClientAccount) []*Client {
+	server.accountsMutex.RLock()
+	defer server.accountsMutex.RUnlock()
+	return append([]*Client(nil), account.Clients...)
+}
+
+// loggedInClients returns the clients logged into the account with the given
+// (casefolded) name, if it's loaded.
+func (server *Server) loggedInClients(accountKey string) []*Client {
+	server.accountsMutex.RLock()
+	defer server.accountsMutex.RUnlock()
+	account := server.accounts[accountKey]
+	if account == nil {
+		return nil
+	}
+	return append([]*Client(nil), account.Clients...)
+}
+
+// otherSessions returns the other clients logged into the same account as this one.
+func (client *Client) otherSessions() []*Client {
+	if client.account == &NoAccount {
+		return nil
+	}
+	var sessions []*Client
+	for _, session := range client.server.accountClients(client.account) {
+		if session != client {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
 // authExternalHandler parses the SASL EXTERNAL mechanism.
 func authExternalHandler(server *Server, client *Client, mechanism string, value []byte) bool {
 	if client.certfp == "" {
-		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed, you are not connecting with a caertificate")
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed, you are not connecting with a certificate")
 		return false
 	}
 
-	err := server.store.Update(func(tx *buntdb.Tx) error {
+	err := client.loginByCertfp()
+	if err != nil {
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
+		return false
+	}
+
+	client.successfulSaslAuth()
+	return false
+}
+
+// loginByCertfp logs the client into the verified account their certfp is registered to.
+func (client *Client) loginByCertfp() error {
+	server := client.server
+	return server.store.Update(func(tx *buntdb.Tx) error {
 		// certfp lookup key
 		accountKey, err := tx.Get(fmt.Sprintf(keyCertToAccount, client.certfp))
 		if err != nil {
@@ -292,37 +534,38 @@ func authExternalHandler(server *Server, client *Client, mechanism string, value
 
 		// confirm the certfp in that account's credentials
 		creds, err := loadAccountCredentials(tx, accountKey)
-		if err != nil || creds.Certificate != client.certfp {
+		if err != nil || !creds.HasCertfp(client.certfp) {
 			return errSaslFail
 		}
 
 		// succeeded, load account info if necessary
-		account, exists := server.accounts[accountKey]
-		if !exists {
-			account = loadAccount(server, tx, accountKey)
-		}
+		account := loadAccount(server, tx, accountKey)
 
 		client.LoginToAccount(account)
 
 		return nil
 	})
-
-	if err != nil {
-		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
-		return false
-	}
-
-	client.successfulSaslAuth()
-	return false
 }
 
 // successfulSaslAuth means that a SASL auth attempt completed successfully, and is used to dispatch messages.
 func (client *Client) successfulSaslAuth() {
 	client.Send(nil, client.server.name, RPL_LOGGEDIN, client.nick, client.nickMaskString, client.account.Name, fmt.Sprintf("You are now logged in as %s", client.account.Name))
 	client.Send(nil, client.server.name, RPL_SASLSUCCESS, client.nick, "SASL authentication successful")
+	client.sendAccountNotify()
+	// clients that are still registering get these once they've finished
+	if client.registered {
+		client.server.sendStoredChannelExpiryWarnings(client)
+	}
+}
 
-	// dispatch account-notify
+// sendAccountNotify tells friends who've asked for account-notify which account the
+// client is now logged into, if any.
+func (client *Client) sendAccountNotify() {
+	accountName := "*"
+	if client.account != &NoAccount {
+		accountName = client.account.Name
+	}
 	for friend := range client.Friends(AccountNotify) {
-		friend.Send(nil, client.nickMaskString, "ACCOUNT", client.account.Name)
+		friend.SendFromClient("", client, nil, "ACCOUNT", accountName)
 	}
 }
@@ -0,0 +1,249 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+var (
+	errAliasEmpty         = errors.New("Alias has no command")
+	errAliasNotEnoughArgs = errors.New("Not enough parameters")
+)
+
+// commandExists returns true if the given command is one of our Commands. It's
+// set up in init, because looking at Commands directly while loading the
+// config would make the Commands table refer to itself (through REHASH).
+var commandExists func(command string) bool
+
+func init() {
+	commandExists = func(command string) bool {
+		_, exists := Commands[command]
+		return exists
+	}
+}
+
+// CommandAlias is a config-defined shortcut for another command, such as
+// ID -> "NICKSERV IDENTIFY $*".
+//
+// Parameters in the template can use these substitutions:
+//
+//	$1, $2, ...  the given parameter
+//	$1-, $2-     the given parameter and every one after it
+//	$*           every parameter (same as $1-)
+//	$$           a literal $
+//
+// A template parameter starting with ':' is a trailing parameter, just like
+// in a normal IRC line. A template parameter made up entirely of $N- or $*
+// expands to multiple parameters, anywhere else they're joined with spaces.
+type CommandAlias struct {
+	Name    string
+	Command string
+	Params  []string
+	// MinParams is the number of parameters the alias must be given.
+	MinParams int
+}
+
+// NewCommandAlias parses the given alias template.
+func NewCommandAlias(name, template string) (*CommandAlias, error) {
+	alias := &CommandAlias{
+		Name: strings.ToUpper(name),
+	}
+
+	template = strings.TrimSpace(template)
+	var trailing string
+	var hasTrailing bool
+	if strings.HasPrefix(template, ":") {
+		return nil, errAliasEmpty
+	}
+	if i := strings.Index(template, " :"); i != -1 {
+		trailing = template[i+2:]
+		hasTrailing = true
+		template = template[:i]
+	}
+
+	words := strings.Fields(template)
+	if len(words) < 1 {
+		return nil, errAliasEmpty
+	}
+	alias.Command = strings.ToUpper(words[0])
+	alias.Params = words[1:]
+	if hasTrailing {
+		alias.Params = append(alias.Params, ":"+trailing)
+	}
+
+	for _, param := range alias.Params {
+		_, max, err := expandAliasParam(strings.TrimPrefix(param, ":"), nil)
+		if err != nil && err != errAliasNotEnoughArgs {
+			return nil, err
+		}
+		if alias.MinParams < max {
+			alias.MinParams = max
+		}
+	}
+
+	return alias, nil
+}
+
+// expandAliasParam does substitutions on a single template parameter. It
+// returns the expanded parameter and the highest parameter number it needs.
+func expandAliasParam(template string, params []string) (string, int, error) {
+	var buf bytes.Buffer
+	var max int
+	var missing bool
+
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' {
+			buf.WriteByte(template[i])
+			continue
+		}
+		if len(template) <= i+1 {
+			return "", 0, fmt.Errorf("Alias parameter [%s] ends with a lone $", template)
+		}
+
+		switch next := template[i+1]; {
+		case next == '$':
+			buf.WriteByte('$')
+			i++
+		case next == '*':
+			buf.WriteString(strings.Join(params, " "))
+			i++
+		case isDigit(next):
+			end := i + 1 + skipDigits(template[i+1:], len(template))
+			number, _ := strconv.Atoi(template[i+1 : end])
+			if number < 1 {
+				return "", 0, fmt.Errorf("Alias parameter [%s] uses $0, parameters start at $1", template)
+			}
+			i = end - 1
+
+			if end < len(template) && template[end] == '-' {
+				// this and every following param, which can be empty
+				if number <= len(params) {
+					buf.WriteString(strings.Join(params[number-1:], " "))
+				}
+				i++
+				continue
+			}
+
+			if max < number {
+				max = number
+			}
+			if number <= len(params) {
+				buf.WriteString(params[number-1])
+			} else {
+				missing = true
+			}
+		default:
+			return "", 0, fmt.Errorf("Alias parameter [%s] has an unknown substitution $%c", template, next)
+		}
+	}
+
+	if missing {
+		return buf.String(), max, errAliasNotEnoughArgs
+	}
+	return buf.String(), max, nil
+}
+
+// isAliasListParam returns true if the template parameter expands to a list
+// of parameters rather than a single one.
+func isAliasListParam(template string) bool {
+	if template == "$*" {
+		return true
+	}
+	if len(template) < 3 || template[0] != '$' || template[len(template)-1] != '-' {
+		return false
+	}
+	return skipDigits(template[1:], len(template)) == len(template)-2
+}
+
+// Expand returns the parameters to send to the real command.
+func (alias *CommandAlias) Expand(params []string) ([]string, error) {
+	if len(params) < alias.MinParams {
+		return nil, errAliasNotEnoughArgs
+	}
+
+	var newParams []string
+	for _, template := range alias.Params {
+		isTrailing := strings.HasPrefix(template, ":")
+		template = strings.TrimPrefix(template, ":")
+
+		if !isTrailing && isAliasListParam(template) {
+			start := 1
+			if template != "$*" {
+				start, _ = strconv.Atoi(template[1 : len(template)-1])
+			}
+			if start <= len(params) {
+				newParams = append(newParams, params[start-1:]...)
+			}
+			continue
+		}
+
+		param, _, err := expandAliasParam(template, params)
+		if err != nil {
+			return nil, err
+		}
+		// an empty param can only be sent as the trailing one
+		if param != "" || isTrailing {
+			newParams = append(newParams, param)
+		}
+	}
+	return newParams, nil
+}
+
+// ParseAliases returns the command aliases defined in the config.
+func (conf *Config) ParseAliases() (map[string]*CommandAlias, error) {
+	aliases := make(map[string]*CommandAlias)
+	for name, template := range conf.RawAliases {
+		alias, err := NewCommandAlias(name, template)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse alias %s: %s", name, err.Error())
+		}
+		if strings.ContainsAny(alias.Name, " :") || alias.Name == "" {
+			return nil, fmt.Errorf("Alias name [%s] is not valid", name)
+		}
+		if commandExists(alias.Name) {
+			return nil, fmt.Errorf("Alias %s has the same name as a built-in command", alias.Name)
+		}
+		if _, exists := aliases[alias.Name]; exists {
+			return nil, fmt.Errorf("Alias %s is defined more than once", alias.Name)
+		}
+		// aliases can only point at real commands, which also stops them from looping
+		if !commandExists(alias.Command) {
+			return nil, fmt.Errorf("Alias %s points to unknown command %s", alias.Name, alias.Command)
+		}
+		aliases[alias.Name] = alias
+	}
+	return aliases, nil
+}
+
+// expandAlias turns the given message into one for the command the alias
+// points to. isAlias is false if the message isn't for an alias, and ok is
+// false if the alias couldn't be expanded (the client has already been told why).
+func (server *Server) expandAlias(client *Client, msg ircmsg.IrcMessage) (newMsg ircmsg.IrcMessage, isAlias bool, ok bool) {
+	server.aliasesMutex.RLock()
+	alias, exists := server.aliases[msg.Command]
+	server.aliasesMutex.RUnlock()
+	if !exists {
+		return msg, false, false
+	}
+
+	params, err := alias.Expand(msg.Params)
+	if err == errAliasNotEnoughArgs {
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, alias.Name, client.t("Not enough parameters"))
+		return msg, true, false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, alias.Name, err.Error())
+		return msg, true, false
+	}
+
+	msg.Command = alias.Command
+	msg.Params = params
+	return msg, true, true
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandAliasExpand(t *testing.T) {
+	testCases := []struct {
+		template string
+		params   []string
+		command  string
+		expected []string
+	}{
+		{"NICKSERV IDENTIFY $*", []string{"dan", "hunter2"}, "NICKSERV", []string{"IDENTIFY", "dan", "hunter2"}},
+		{"join $1-", []string{"#a,#b", "key"}, "JOIN", []string{"#a,#b", "key"}},
+		{"JOIN $1-", []string{}, "JOIN", nil},
+		{"PRIVMSG NickServ :IDENTIFY $*", []string{"dan", "hunter2"}, "PRIVMSG", []string{"NickServ", "IDENTIFY dan hunter2"}},
+		{"PRIVMSG #$1 :$2- costs $$5", []string{"shop", "a", "hat"}, "PRIVMSG", []string{"#shop", "a hat costs $5"}},
+		{"PRIVMSG ChanServ :$*", []string{}, "PRIVMSG", []string{"ChanServ", ""}},
+	}
+
+	for _, testCase := range testCases {
+		alias, err := NewCommandAlias("test", testCase.template)
+		if err != nil {
+			t.Errorf("parsing %q: %s", testCase.template, err.Error())
+			continue
+		}
+		if alias.Command != testCase.command {
+			t.Errorf("parsing %q: expected command %s, got %s", testCase.template, testCase.command, alias.Command)
+		}
+		params, err := alias.Expand(testCase.params)
+		if err != nil {
+			t.Errorf("expanding %q with %v: %s", testCase.template, testCase.params, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(params, testCase.expected) {
+			t.Errorf("expanding %q with %v: expected %q, got %q", testCase.template, testCase.params, testCase.expected, params)
+		}
+	}
+}
+
+func TestCommandAliasErrors(t *testing.T) {
+	alias, err := NewCommandAlias("op", "MODE $1 +o $2")
+	if err != nil {
+		t.Fatalf("parsing alias: %s", err.Error())
+	}
+	if alias.MinParams != 2 {
+		t.Errorf("expected alias to need 2 params, got %d", alias.MinParams)
+	}
+	_, err = alias.Expand([]string{"#chan"})
+	if err != errAliasNotEnoughArgs {
+		t.Errorf("expected not enough params error, got %v", err)
+	}
+
+	for _, template := range []string{"", ":PRIVMSG", "JOIN $0", "JOIN $x", "JOIN $"} {
+		_, err := NewCommandAlias("bad", template)
+		if err == nil {
+			t.Errorf("expected error parsing %q", template)
+		}
+	}
+}
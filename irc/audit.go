@@ -0,0 +1,14 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// auditLog records an oper action that affects other users in the audit log, along
+// with who did it, so networks can look back at what their opers have done.
+func (server *Server) auditLog(client *Client, message string) {
+	server.logger.LogFields(logger.LogInfo, "audit", client.logFields, message)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import "time"
+
+// autoAwayEnabled returns true if this client should be marked away once they've been idle.
+func (client *Client) autoAwayEnabled() bool {
+	return client.server.Config().Accounts.AutoAway.Enabled && client.account.AutoAway
+}
+
+// resetAutoAway brings the client back if auto-away marked them as away, and restarts
+// their auto-away timer. It's run whenever the client's active.
+func (client *Client) resetAutoAway() {
+	client.timerMutex.Lock()
+	defer client.timerMutex.Unlock()
+
+	if client.isAutoAway {
+		client.isAutoAway = false
+		client.setAway(false, "")
+	}
+
+	if !client.registered || !client.autoAwayEnabled() {
+		if client.autoAwayTimer != nil {
+			client.autoAwayTimer.Stop()
+		}
+		return
+	}
+
+	idleTime := client.server.Config().Accounts.AutoAway.IdleTime
+	if client.autoAwayTimer == nil {
+		client.autoAwayTimer = time.AfterFunc(idleTime, client.autoAwayIdle)
+	} else {
+		client.autoAwayTimer.Reset(idleTime)
+	}
+}
+
+// autoAwayIdle is run when the client has been idle for the auto-away idle time, and
+// marks them as away. Clients that have already set themselves away are left alone.
+func (client *Client) autoAwayIdle() {
+	client.timerMutex.Lock()
+	defer client.timerMutex.Unlock()
+
+	if client.isDestroyed || client.HasMode(Away) || !client.autoAwayEnabled() {
+		return
+	}
+	client.isAutoAway = true
+	client.setAway(true, client.server.Config().Accounts.AutoAway.Message)
+}
+
+// setAccountAutoAway sets whether the given account's clients are marked away after being
+// idle, and starts or stops the timers of the clients logged into it.
+func (server *Server) setAccountAutoAway(account *ClientAccount, enabled bool) error {
+	err := server.saveAccountSetting(account, keyAccountAutoAway, enabled)
+	if err != nil {
+		return err
+	}
+
+	account.AutoAway = enabled
+	for _, client := range server.accountClients(account) {
+		client.resetAutoAway()
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// maxAutoJoinChannels is how many channels an account can be auto-joined to.
+	maxAutoJoinChannels = 10
+)
+
+var (
+	errAutoJoinTooMany = fmt.Errorf("You can only be auto-joined to %d channels", maxAutoJoinChannels)
+	errAutoJoinInvalid = errors.New("Invalid channel name")
+)
+
+// parseAutoJoin parses a comma-separated list of channels to auto-join, dropping
+// duplicates.
+func parseAutoJoin(list string) ([]string, error) {
+	var channels []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name == "" {
+			continue
+		}
+		casefoldedName, err := CasefoldChannel(name)
+		if err != nil {
+			return nil, errAutoJoinInvalid
+		}
+		if seen[casefoldedName] {
+			continue
+		}
+		seen[casefoldedName] = true
+		channels = append(channels, name)
+	}
+	if maxAutoJoinChannels < len(channels) {
+		return nil, errAutoJoinTooMany
+	}
+	return channels, nil
+}
+
+// autoJoin joins the client to the given channels, as if they'd sent a JOIN for them,
+// so bans, keys and limits all still apply.
+func (server *Server) autoJoin(client *Client, channels []string) {
+	if len(channels) == 0 {
+		return
+	}
+	msg := ircmsg.MakeMessage(nil, client.nickMaskString, "JOIN", strings.Join(channels, ","))
+	joinHandler(server, client, msg)
+}
+
+// autoJoinOnConnect joins a newly-registered client to the server's auto-join channels,
+// and those of their account.
+func (server *Server) autoJoinOnConnect(client *Client) {
+	var channels []string
+	channels = append(channels, server.Config().Channels.AutoJoin...)
+	channels = append(channels, client.account.AutoJoin...)
+	server.autoJoin(client, channels)
+}
+
+// setAccountAutoJoin saves the channels the given account is auto-joined to.
+func (server *Server) setAccountAutoJoin(account *ClientAccount, channels []string) error {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return err
+	}
+	err = server.store.Update(func(tx *buntdb.Tx) error {
+		key := fmt.Sprintf(keyAccountAutoJoin, accountKey)
+		if len(channels) == 0 {
+			tx.Delete(key)
+			return nil
+		}
+		_, _, err := tx.Set(key, strings.Join(channels, " "), nil)
+		return err
+	})
+	if err == nil {
+		account.AutoJoin = channels
+	}
+	return err
+}
+
+// nickservSetAutoJoin handles NS SET AUTOJOIN, which sets (or with OFF, clears) the
+// channels the client's account is joined to when it connects.
+func (server *Server) nickservSetAutoJoin(client *Client, list string) {
+	var channels []string
+	if strings.ToLower(list) != "off" {
+		var err error
+		channels, err = parseAutoJoin(list)
+		if err != nil {
+			client.NickServNotice(client.t(err.Error()))
+			return
+		}
+	}
+
+	err := server.setAccountAutoJoin(client.account, channels)
+	if err != nil {
+		client.NickServNotice(client.t("Could not save your settings"))
+		server.logger.Error("nickserv", fmt.Sprintf("Could not save AUTOJOIN setting for account %s: %s", client.account.Name, err.Error()))
+		return
+	}
+	if len(channels) == 0 {
+		client.NickServNotice(client.t("You will no longer be joined to any channels when you connect"))
+	} else {
+		client.NickServNotice(fmt.Sprintf(client.t("You will now be joined to %s when you connect"), strings.Join(channels, ", ")))
+	}
+}
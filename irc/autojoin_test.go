@@ -0,0 +1,29 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAutoJoin(t *testing.T) {
+	var tests = []struct {
+		list     string
+		channels []string
+		err      error
+	}{
+		{"#lobby", []string{"#lobby"}, nil},
+		{"#lobby,#help,", []string{"#lobby", "#help"}, nil},
+		{"#Lobby,#lobby", []string{"#Lobby"}, nil},
+		{"#lobby,help", nil, errAutoJoinInvalid},
+		{"#a,#b,#c,#d,#e,#f,#g,#h,#i,#j,#k", nil, errAutoJoinTooMany},
+	}
+	for _, test := range tests {
+		channels, err := parseAutoJoin(test.list)
+		if err != test.err || !reflect.DeepEqual(channels, test.channels) {
+			t.Errorf("expected %q to give %v (%v), got %v (%v)", test.list, test.channels, test.err, channels, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"sync"
+)
+
+const (
+	// BroadcastThreshold is how many recipients a message needs before we spread its
+	// delivery across our broadcast workers, rather than looping over them ourselves.
+	BroadcastThreshold = 500
+	// BroadcastChunkSize is how many recipients each broadcast worker delivers to at once.
+	BroadcastChunkSize = 100
+	// BroadcastWorkers is how many broadcast workers we run.
+	BroadcastWorkers = 8
+)
+
+// BroadcastPool is a pool of workers that deliver messages to the members of very
+// large channels, so the last recipients don't have to wait for everyone else.
+type BroadcastPool struct {
+	jobs chan func()
+}
+
+// NewBroadcastPool returns a new BroadcastPool, with its workers already running.
+func NewBroadcastPool(workers int) *BroadcastPool {
+	pool := &BroadcastPool{
+		jobs: make(chan func(), workers),
+	}
+	for i := 0; i < workers; i++ {
+		go pool.runWorker()
+	}
+	return pool
+}
+
+// runWorker runs delivery jobs until our job queue is closed.
+func (pool *BroadcastPool) runWorker() {
+	for job := range pool.jobs {
+		job()
+	}
+}
+
+// Deliver calls send for each of the given recipients, and returns once they've all
+// been sent to. Large recipient lists get split up and handed out to our workers.
+func (pool *BroadcastPool) Deliver(recipients []*Client, send func(member *Client)) {
+	if len(recipients) < BroadcastThreshold {
+		for _, member := range recipients {
+			send(member)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(recipients); start += BroadcastChunkSize {
+		end := start + BroadcastChunkSize
+		if len(recipients) < end {
+			end = len(recipients)
+		}
+		chunk := recipients[start:end]
+
+		wg.Add(1)
+		pool.jobs <- func() {
+			defer wg.Done()
+			for _, member := range chunk {
+				send(member)
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -20,6 +20,8 @@ const (
 	AccountTag Capability = "account-tag"
 	// AwayNotify is this IRCv3 capability: http://ircv3.net/specs/extensions/away-notify-3.1.html
 	AwayNotify Capability = "away-notify"
+	// Batch is this IRCv3 capability: http://ircv3.net/specs/extensions/batch-3.2.html
+	Batch Capability = "batch"
 	// CapNotify is this IRCv3 capability: http://ircv3.net/specs/extensions/cap-notify-3.2.html
 	CapNotify Capability = "cap-notify"
 	// ChgHost is this IRCv3 capability: http://ircv3.net/specs/extensions/chghost-3.2.html
@@ -56,6 +58,7 @@ var (
 		AccountTag:    true,
 		AccountNotify: true,
 		AwayNotify:    true,
+		Batch:         true,
 		CapNotify:     true,
 		ChgHost:       true,
 		EchoMessage:   true,
@@ -0,0 +1,237 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// maxCertfpsPerAccount is how many certificate fingerprints an account can have.
+	maxCertfpsPerAccount = 5
+)
+
+var (
+	errCertfpInvalid      = errors.New("That's not a valid certificate fingerprint")
+	errCertfpTooMany      = fmt.Errorf("Accounts can only have %d certificate fingerprints", maxCertfpsPerAccount)
+	errCertfpInUse        = errors.New("That certificate fingerprint is already used by an account")
+	errCertfpNotOnAccount = errors.New("That certificate fingerprint isn't on your account")
+)
+
+// normalizeCertfp returns the given certificate fingerprint the way we store them (as
+// lowercase hex, without colons), or an error if it isn't a SHA-256 fingerprint.
+func normalizeCertfp(certfp string) (string, error) {
+	certfp = strings.ToLower(strings.Replace(certfp, ":", "", -1))
+	decoded, err := hex.DecodeString(certfp)
+	if err != nil || len(decoded) != 32 {
+		return "", errCertfpInvalid
+	}
+	return certfp, nil
+}
+
+// Certfps returns the certificate fingerprints that can be used to log into the
+// account: the one it was registered with (if any), and the ones added since.
+func (creds *AccountCredentials) Certfps() []string {
+	var certfps []string
+	if creds.Certificate != "" {
+		certfps = append(certfps, creds.Certificate)
+	}
+	return append(certfps, creds.Certificates...)
+}
+
+// HasCertfp returns true if the given certificate fingerprint can be used to log into
+// the account.
+func (creds *AccountCredentials) HasCertfp(certfp string) bool {
+	for _, accountCertfp := range creds.Certfps() {
+		if certfp != "" && accountCertfp == certfp {
+			return true
+		}
+	}
+	return false
+}
+
+// removeCertfp removes the given certificate fingerprint from the account, returning
+// false if it wasn't on it.
+func (creds *AccountCredentials) removeCertfp(certfp string) bool {
+	if creds.Certificate == certfp {
+		creds.Certificate = ""
+		return true
+	}
+	for i, accountCertfp := range creds.Certificates {
+		if accountCertfp == certfp {
+			creds.Certificates = append(creds.Certificates[:i], creds.Certificates[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// saveAccountCredentials stores the given account's credentials.
+func saveAccountCredentials(tx *buntdb.Tx, accountKey string, creds *AccountCredentials) error {
+	credText, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set(fmt.Sprintf(keyAccountCredentials, accountKey), string(credText), nil)
+	return err
+}
+
+// addAccountCertfp lets the given certificate fingerprint be used to log into the account.
+func (server *Server) addAccountCertfp(account *ClientAccount, certfp string) error {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return err
+	}
+	return server.store.Update(func(tx *buntdb.Tx) error {
+		// each fingerprint can only log into one account
+		certKey := fmt.Sprintf(keyCertToAccount, certfp)
+		_, err := tx.Get(certKey)
+		if err != buntdb.ErrNotFound {
+			return errCertfpInUse
+		}
+
+		creds, err := loadAccountCredentials(tx, accountKey)
+		if err != nil {
+			return err
+		}
+		if maxCertfpsPerAccount <= len(creds.Certfps()) {
+			return errCertfpTooMany
+		}
+		creds.Certificates = append(creds.Certificates, certfp)
+
+		err = saveAccountCredentials(tx, accountKey, creds)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(certKey, accountKey, nil)
+		return err
+	})
+}
+
+// removeAccountCertfp stops the given certificate fingerprint being able to log into
+// the account.
+func (server *Server) removeAccountCertfp(account *ClientAccount, certfp string) error {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return err
+	}
+	return server.store.Update(func(tx *buntdb.Tx) error {
+		creds, err := loadAccountCredentials(tx, accountKey)
+		if err != nil {
+			return err
+		}
+		if !creds.removeCertfp(certfp) {
+			return errCertfpNotOnAccount
+		}
+
+		err = saveAccountCredentials(tx, accountKey, creds)
+		if err != nil {
+			return err
+		}
+		tx.Delete(fmt.Sprintf(keyCertToAccount, certfp))
+		return nil
+	})
+}
+
+// accountCertfps returns the certificate fingerprints that can log into the account.
+func (server *Server) accountCertfps(account *ClientAccount) ([]string, error) {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return nil, err
+	}
+	var certfps []string
+	err = server.store.View(func(tx *buntdb.Tx) error {
+		creds, err := loadAccountCredentials(tx, accountKey)
+		if err != nil {
+			return err
+		}
+		certfps = creds.Certfps()
+		return nil
+	})
+	return certfps, err
+}
+
+// nickservCert handles NS CERT, which manages the certificate fingerprints that can be
+// used to log into the client's account with SASL EXTERNAL.
+func (server *Server) nickservCert(client *Client, params []string) {
+	if client.account == &NoAccount {
+		client.NickServNotice(client.t("You must be logged into an account to manage its certificates"))
+		return
+	}
+	var subcommand string
+	if 1 < len(params) {
+		subcommand = strings.ToLower(params[1])
+	}
+
+	switch subcommand {
+	case "add", "del":
+		// ADD uses the certificate the client's connected with if they don't give one
+		certfp := client.certfp
+		if 2 < len(params) {
+			var err error
+			certfp, err = normalizeCertfp(params[2])
+			if err != nil {
+				client.NickServNotice(client.t(err.Error()))
+				return
+			}
+		} else if subcommand == "del" {
+			client.NickServNotice(client.t("Syntax: CERT DEL <fingerprint>"))
+			return
+		}
+		if certfp == "" {
+			client.NickServNotice(client.t("You're not connected with a TLS client certificate, so you need to give a fingerprint"))
+			return
+		}
+
+		var err error
+		if subcommand == "add" {
+			err = server.addAccountCertfp(client.account, certfp)
+		} else {
+			err = server.removeAccountCertfp(client.account, certfp)
+		}
+		switch err {
+		case nil:
+		case errCertfpTooMany, errCertfpInUse, errCertfpNotOnAccount:
+			client.NickServNotice(client.t(err.Error()))
+			return
+		default:
+			client.NickServNotice(client.t("Could not save your settings"))
+			server.logger.Error("nickserv", fmt.Sprintf("Could not update certificates of account %s: %s", client.account.Name, err.Error()))
+			return
+		}
+
+		if subcommand == "add" {
+			client.NickServNotice(fmt.Sprintf(client.t("Certificate fingerprint %s can now be used to log into your account"), certfp))
+		} else {
+			client.NickServNotice(fmt.Sprintf(client.t("Certificate fingerprint %s can no longer be used to log into your account"), certfp))
+		}
+		server.logger.LogFields(logger.LogInfo, "accounts", client.logFields, fmt.Sprintf("Client %s ran CERT %s for account %s with fingerprint %s", client.nick, strings.ToUpper(subcommand), client.account.Name, certfp))
+
+	case "list":
+		certfps, err := server.accountCertfps(client.account)
+		if err != nil {
+			client.NickServNotice(client.t("Could not load your settings"))
+			server.logger.Error("nickserv", fmt.Sprintf("Could not load certificates of account %s: %s", client.account.Name, err.Error()))
+			return
+		}
+		if len(certfps) == 0 {
+			client.NickServNotice(client.t("Your account has no certificate fingerprints"))
+			return
+		}
+		client.NickServNotice(client.t("Certificate fingerprints that can log into your account:"))
+		for _, certfp := range certfps {
+			client.NickServNotice(fmt.Sprintf("  %s", certfp))
+		}
+
+	default:
+		client.NickServNotice(client.t("Syntax: CERT <ADD [fingerprint]|DEL <fingerprint>|LIST>"))
+	}
+}
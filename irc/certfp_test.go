@@ -0,0 +1,55 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCertfp(t *testing.T) {
+	certfp := strings.Repeat("ab", 32)
+	var tests = []string{
+		certfp,
+		strings.ToUpper(certfp),
+		strings.TrimSuffix(strings.Repeat("AB:", 32), ":"),
+	}
+	for _, input := range tests {
+		result, err := normalizeCertfp(input)
+		if err != nil || result != certfp {
+			t.Errorf("expected %s to normalize to %s, got %s (%v)", input, certfp, result, err)
+		}
+	}
+
+	for _, bad := range []string{"", "abcd", strings.Repeat("zz", 32), strings.Repeat("ab", 20)} {
+		if _, err := normalizeCertfp(bad); err == nil {
+			t.Errorf("expected %s to be an invalid fingerprint", bad)
+		}
+	}
+}
+
+func TestAccountCertfps(t *testing.T) {
+	creds := AccountCredentials{
+		Certificate:  "registered",
+		Certificates: []string{"added", "another"},
+	}
+	for _, certfp := range []string{"registered", "added", "another"} {
+		if !creds.HasCertfp(certfp) {
+			t.Errorf("expected account to have certfp %s", certfp)
+		}
+	}
+	if creds.HasCertfp("") || creds.HasCertfp("unknown") {
+		t.Error("expected account not to have empty or unknown certfps")
+	}
+
+	if !creds.removeCertfp("registered") || !creds.removeCertfp("another") {
+		t.Error("expected certfps to be removed")
+	}
+	if creds.removeCertfp("unknown") {
+		t.Error("expected unknown certfp not to be removed")
+	}
+	if certfps := creds.Certfps(); len(certfps) != 1 || certfps[0] != "added" {
+		t.Errorf("expected only the added certfp to be left, got %v", certfps)
+	}
+}
@@ -6,32 +6,53 @@
 package irc
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"regexp"
+	"runtime"
 	"strconv"
 	"time"
 
 	"sync"
 
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/logger"
 	"github.com/tidwall/buntdb"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// memberReplyChunkSize is how many members we send WHO/NAMES replies for before
+	// yielding, so replies for huge channels don't monopolise the send path.
+	memberReplyChunkSize = 250
 )
 
 // Channel represents a channel that clients can join.
 type Channel struct {
-	flags          ModeSet
-	lists          map[Mode]*UserMaskSet
-	key            string
-	membersMutex   sync.RWMutex
-	members        MemberSet
-	name           string
-	nameCasefolded string
-	server         *Server
-	createdTime    time.Time
-	topic          string
-	topicSetBy     string
-	topicSetTime   time.Time
-	userLimit      uint64
+	fantasyPrefix      string
+	flags              ModeSet
+	history            *history.Buffer
+	lists              map[Mode]*UserMaskSet
+	listExpiryTimer    *time.Timer
+	key                string
+	keyHash            []byte
+	membersMutex       sync.RWMutex
+	members            MemberSet
+	name               string
+	nameCasefolded     string
+	registrationLoaded bool
+	roleplay           RoleplaySettings
+	roleplaySources    *UserMaskSet
+	server             *Server
+	createdTime        time.Time
+	topic              string
+	topicSetBy         string
+	topicSetTime       time.Time
+	userLimit          uint64
+	wordFilter         WordFilterSettings
+	wordFilterPatterns []*regexp.Regexp
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -42,9 +63,14 @@ func NewChannel(s *Server, name string, addDefaultModes bool) *Channel {
 		log.Println(fmt.Sprintf("ERROR: Channel name is bad: [%s]", name), err.Error())
 		return nil
 	}
+	// names that only differ in how their characters are composed are the same channel,
+	// so show them the same way
+	name = norm.NFC.String(name)
+	channelHistoryLength, _ := s.Config().HistoryLengths()
 
 	channel := &Channel{
-		flags: make(ModeSet),
+		flags:   make(ModeSet),
+		history: history.NewHistoryBuffer(channelHistoryLength),
 		lists: map[Mode]*UserMaskSet{
 			BanMask:    NewUserMaskSet(),
 			ExceptMask: NewUserMaskSet(),
@@ -57,7 +83,7 @@ func NewChannel(s *Server, name string, addDefaultModes bool) *Channel {
 	}
 
 	if addDefaultModes {
-		for _, mode := range DefaultChannelModes {
+		for _, mode := range s.DefaultChannelModes() {
 			channel.flags[mode] = true
 		}
 	}
@@ -79,20 +105,38 @@ func (channel *Channel) isEmptyNoMutex() bool {
 	return len(channel.members) == 0
 }
 
+// HasClient returns true if the given client is in the channel.
+func (channel *Channel) HasClient(client *Client) bool {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+
+	return channel.members.Has(client)
+}
+
 // Names sends the list of users joined to the channel to the given client.
 func (channel *Channel) Names(client *Client) {
 	channel.membersMutex.RLock()
-	defer channel.membersMutex.RUnlock()
+	currentNicks := channel.nicksNoMutex(client)
+	channel.membersMutex.RUnlock()
 
-	channel.namesNoMutex(client)
+	channel.sendNames(client, currentNicks)
 }
 
 func (channel *Channel) namesNoMutex(client *Client) {
-	currentNicks := channel.nicksNoMutex(client)
+	channel.sendNames(client, channel.nicksNoMutex(client))
+}
+
+// sendNames sends the given nicks to the client as NAMES replies, yielding between
+// chunks so that replies for huge channels don't hog the scheduler.
+func (channel *Channel) sendNames(client *Client, currentNicks []string) {
 	// assemble and send replies
 	maxNamLen := 480 - len(client.server.name) - len(client.nick)
 	var buffer string
-	for _, nick := range currentNicks {
+	for i, nick := range currentNicks {
+		if 0 < i && i%memberReplyChunkSize == 0 {
+			runtime.Gosched()
+		}
+
 		if buffer == "" {
 			buffer += nick
 			continue
@@ -109,7 +153,7 @@ func (channel *Channel) namesNoMutex(client *Client) {
 	}
 
 	client.Send(nil, client.server.name, RPL_NAMREPLY, client.nick, "=", channel.name, buffer)
-	client.Send(nil, client.server.name, RPL_ENDOFNAMES, client.nick, channel.name, "End of NAMES list")
+	client.Send(nil, client.server.name, RPL_ENDOFNAMES, client.nick, channel.name, client.t("End of NAMES list"))
 }
 
 // ClientIsAtLeast returns whether the client has at least the given channel privilege.
@@ -183,9 +227,9 @@ func (channel *Channel) nicksNoMutex(target *Client) []string {
 // <mode> <mode params>
 func (channel *Channel) modeStringNoLock(client *Client) (str string) {
 	// RLock()
-	isMember := client.flags[Operator] || channel.members.Has(client)
+	isMember := client.HasMode(Operator) || channel.members.Has(client)
 	// RUnlock()
-	showKey := isMember && (channel.key != "")
+	showKey := isMember && channel.hasKeyNoMutex()
 	showUserLimit := channel.userLimit > 0
 
 	// flags with args
@@ -205,8 +249,10 @@ func (channel *Channel) modeStringNoLock(client *Client) (str string) {
 
 	// args for flags with args: The order must match above to keep
 	// positional arguments in place.
-	if showKey {
+	if showKey && channel.key != "" && channel.canSeeKeyNoMutex(client) {
 		str += " " + channel.key
+	} else if showKey {
+		str += " " + redactedChannelKey
 	}
 	if showUserLimit {
 		str += " " + strconv.FormatUint(channel.userLimit, 10)
@@ -220,14 +266,28 @@ func (channel *Channel) IsFull() bool {
 	return (channel.userLimit > 0) && (uint64(len(channel.members)) >= channel.userLimit)
 }
 
-// CheckKey returns true if the key is not set or matches the given key.
-func (channel *Channel) CheckKey(key string) bool {
-	return (channel.key == "") || (channel.key == key)
+// CheckKey returns true if the key is not set or matches the given key. Registered
+// channels only remember the hash of their key, and checking against that is slow, so
+// checkedKeyHash is the hash that the key was already found to match (if any).
+func (channel *Channel) CheckKey(key string, checkedKeyHash []byte) bool {
+	if channel.key != "" {
+		return channel.key == key
+	}
+	if channel.keyHash != nil {
+		if key == "" || !bytes.Equal(channel.keyHash, checkedKeyHash) {
+			return false
+		}
+		// now we know the key, chanops can see it and we don't need to hash it again
+		channel.key = key
+		channel.keyHash = nil
+	}
+	return true
 }
 
-// Join joins the given client to this channel (if they can be joined).
+// Join joins the given client to this channel (if they can be joined). checkedKeyHash is
+// the hash of the channel's key that the given key matches, see checkChannelKey.
 //TODO(dan): /SAJOIN and maybe a ForceJoin function?
-func (channel *Channel) Join(client *Client, key string) {
+func (channel *Channel) Join(client *Client, key string, checkedKeyHash []byte) {
 	channel.membersMutex.Lock()
 	defer channel.membersMutex.Unlock()
 	if channel.members.Has(client) {
@@ -235,36 +295,53 @@ func (channel *Channel) Join(client *Client, key string) {
 		return
 	}
 
+	// registered channels need their key, bans and invites before we check the client
+	// against them
+	var chanReg *RegisteredChannel
+	client.server.registeredChannelsMutex.Lock()
+	defer client.server.registeredChannelsMutex.Unlock()
+	client.server.store.View(func(tx *buntdb.Tx) error {
+		chanReg = client.server.loadChannelNoMutex(tx, channel.nameCasefolded)
+		if chanReg != nil && len(channel.members) == 0 && !channel.registrationLoaded {
+			channel.loadRegistrationNoMutex(tx, chanReg)
+		}
+		return nil
+	})
+
 	if channel.IsFull() {
-		client.Send(nil, client.server.name, ERR_CHANNELISFULL, channel.name, "Cannot join channel (+l)")
+		client.Send(nil, client.server.name, ERR_CHANNELISFULL, client.nick, channel.name, client.t("Cannot join channel (+l)"))
 		return
 	}
 
-	if !channel.CheckKey(key) {
-		client.Send(nil, client.server.name, ERR_BADCHANNELKEY, channel.name, "Cannot join channel (+k)")
+	if !channel.CheckKey(key, checkedKeyHash) {
+		client.Send(nil, client.server.name, ERR_BADCHANNELKEY, client.nick, channel.name, client.t("Cannot join channel (+k)"))
 		return
 	}
 
-	isInvited := channel.lists[InviteMask].Match(client.nickMaskCasefolded)
+	hasInvite := client.invites.Has(channel.nameCasefolded)
+	isInvited := hasInvite || channel.lists[InviteMask].MatchClient(client)
 	if channel.flags[InviteOnly] && !isInvited {
-		client.Send(nil, client.server.name, ERR_INVITEONLYCHAN, channel.name, "Cannot join channel (+i)")
+		client.Send(nil, client.server.name, ERR_INVITEONLYCHAN, client.nick, channel.name, client.t("Cannot join channel (+i)"))
 		return
 	}
 
-	if channel.lists[BanMask].Match(client.nickMaskCasefolded) &&
-		!isInvited &&
-		!channel.lists[ExceptMask].Match(client.nickMaskCasefolded) {
-		client.Send(nil, client.server.name, ERR_BANNEDFROMCHAN, channel.name, "Cannot join channel (+b)")
+	if !isInvited && channel.isBannedNoMutex(client) {
+		client.Send(nil, client.server.name, ERR_BANNEDFROMCHAN, client.nick, channel.name, channel.bannedMessageNoMutex(client))
 		return
 	}
 
-	client.server.logger.Debug("join", fmt.Sprintf("%s joined channel %s", client.nick, channel.name))
+	// an invite can only be used once
+	if hasInvite {
+		client.invites.Remove(channel.nameCasefolded)
+	}
+
+	client.server.logger.LogFields(logger.LogDebug, "join", client.logFields, fmt.Sprintf("%s joined channel %s", client.nick, channel.name))
 
 	for member := range channel.members {
 		if member.capabilities[ExtendedJoin] {
-			member.Send(nil, client.nickMaskString, "JOIN", channel.name, client.account.Name, client.realname)
+			member.SendFromClient("", client, nil, "JOIN", channel.name, client.account.Name, client.realname)
 		} else {
-			member.Send(nil, client.nickMaskString, "JOIN", channel.name)
+			member.SendFromClient("", client, nil, "JOIN", channel.name)
 		}
 	}
 
@@ -273,48 +350,31 @@ func (channel *Channel) Join(client *Client, key string) {
 
 	// give channel mode if necessary
 	var givenMode *Mode
-	client.server.registeredChannelsMutex.Lock()
-	defer client.server.registeredChannelsMutex.Unlock()
-	client.server.store.Update(func(tx *buntdb.Tx) error {
-		chanReg := client.server.loadChannelNoMutex(tx, channel.nameCasefolded)
-
-		if chanReg == nil {
-			if len(channel.members) == 1 {
-				channel.createdTime = time.Now()
-				channel.members[client][ChannelOperator] = true
-				givenMode = &ChannelOperator
-			}
-		} else {
-			// we should only do this on registered channels
-			if client.account != nil && client.account.Name == chanReg.Founder {
-				channel.members[client][ChannelFounder] = true
-				givenMode = &ChannelFounder
-			}
-			if len(channel.members) == 1 {
-				// apply other details if new channel
-				channel.topic = chanReg.Topic
-				channel.topicSetBy = chanReg.TopicSetBy
-				channel.topicSetTime = chanReg.TopicSetTime
-				channel.name = chanReg.Name
-				channel.createdTime = chanReg.RegisteredAt
-				for _, mask := range chanReg.Banlist {
-					channel.lists[BanMask].Add(mask)
-				}
-				for _, mask := range chanReg.Exceptlist {
-					channel.lists[ExceptMask].Add(mask)
-				}
-				for _, mask := range chanReg.Invitelist {
-					channel.lists[InviteMask].Add(mask)
-				}
-			}
+	if chanReg == nil {
+		if len(channel.members) == 1 {
+			channel.createdTime = time.Now()
+			channel.members[client][ChannelOperator] = true
+			givenMode = &ChannelOperator
 		}
-		return nil
-	})
+	} else if client.account != nil && client.account.Name == chanReg.Founder {
+		// we should only do this on registered channels
+		channel.members[client][ChannelFounder] = true
+		givenMode = &ChannelFounder
+
+		// the founder's still using the channel, so it shouldn't expire
+		chanReg.LastUsed = time.Now()
+		chanReg.ExpiryWarned = time.Time{}
+		chanReg.ExpiryFlagged = false
+		client.server.store.Update(func(tx *buntdb.Tx) error {
+			client.server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanReg)
+			return nil
+		})
+	}
 
 	if client.capabilities[ExtendedJoin] {
-		client.Send(nil, client.nickMaskString, "JOIN", channel.name, client.account.Name, client.realname)
+		client.SendFromClient("", client, nil, "JOIN", channel.name, client.account.Name, client.realname)
 	} else {
-		client.Send(nil, client.nickMaskString, "JOIN", channel.name)
+		client.SendFromClient("", client, nil, "JOIN", channel.name)
 	}
 	channel.getTopicNoMutex(client) // we already have Lock
 	channel.namesNoMutex(client)
@@ -325,22 +385,48 @@ func (channel *Channel) Join(client *Client, key string) {
 	}
 }
 
+// loadRegistrationNoMutex gives the channel the details it was registered with.
+func (channel *Channel) loadRegistrationNoMutex(tx *buntdb.Tx, chanReg *RegisteredChannel) {
+	// the topic length may have been lowered since it was stored
+	channel.topic = truncateUTF8(chanReg.Topic, channel.server.Limits().TopicLen)
+	channel.topicSetBy = chanReg.TopicSetBy
+	channel.topicSetTime = chanReg.TopicSetTime
+	channel.name = chanReg.Name
+	channel.createdTime = chanReg.RegisteredAt
+	for _, mask := range chanReg.Banlist {
+		channel.lists[BanMask].Add(mask)
+	}
+	for _, mask := range chanReg.Exceptlist {
+		channel.lists[ExceptMask].Add(mask)
+	}
+	for _, mask := range chanReg.Invitelist {
+		channel.lists[InviteMask].Add(mask)
+	}
+	channel.loadListInfoNoMutex(chanReg.ListInfo)
+	channel.setRoleplaySettingsNoMutex(chanReg.Roleplay)
+	channel.setWordFilterSettingsNoMutex(chanReg.WordFilter)
+	channel.fantasyPrefix = chanReg.Fantasy
+	channel.keyHash = chanReg.KeyHash
+	channel.loadChannelHistoryNoMutex(tx)
+	channel.registrationLoaded = true
+}
+
 // Part parts the given client from this channel, with the given message.
 func (channel *Channel) Part(client *Client, message string) {
 	channel.membersMutex.Lock()
 	defer channel.membersMutex.Unlock()
 
 	if !channel.members.Has(client) {
-		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, "You're not on that channel")
+		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, client.t("You're not on that channel"))
 		return
 	}
 
 	for member := range channel.members {
-		member.Send(nil, client.nickMaskString, "PART", channel.name, message)
+		member.SendFromClient("", client, nil, "PART", channel.name, message)
 	}
 	channel.quitNoMutex(client)
 
-	client.server.logger.Debug("part", fmt.Sprintf("%s left channel %s", client.nick, channel.name))
+	client.server.logger.LogFields(logger.LogDebug, "part", client.logFields, fmt.Sprintf("%s left channel %s", client.nick, channel.name))
 }
 
 // GetTopic sends the channel topic to the given client.
@@ -355,12 +441,12 @@ func (channel *Channel) GetTopic(client *Client) {
 // This is required because of channel joins.
 func (channel *Channel) getTopicNoMutex(client *Client) {
 	if !channel.members.Has(client) {
-		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, client.nick, channel.name, "You're not on that channel")
+		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, client.nick, channel.name, client.t("You're not on that channel"))
 		return
 	}
 
 	if channel.topic == "" {
-		client.Send(nil, client.server.name, RPL_NOTOPIC, client.nick, channel.name, "No topic is set")
+		client.Send(nil, client.server.name, RPL_NOTOPIC, client.nick, channel.name, client.t("No topic is set"))
 		return
 	}
 
@@ -373,26 +459,24 @@ func (channel *Channel) SetTopic(client *Client, topic string) {
 	channel.membersMutex.RLock()
 	defer channel.membersMutex.RUnlock()
 
-	if !(client.flags[Operator] || channel.members.Has(client)) {
-		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, "You're not on that channel")
+	if !(client.HasMode(Operator) || channel.members.Has(client)) {
+		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, client.t("You're not on that channel"))
 		return
 	}
 
 	if channel.flags[OpOnlyTopic] && !channel.ClientIsAtLeast(client, ChannelOperator) {
-		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not a channel operator"))
 		return
 	}
 
-	if len(topic) > client.server.limits.TopicLen {
-		topic = topic[:client.server.limits.TopicLen]
-	}
+	topic = truncateUTF8(topic, client.server.Limits().TopicLen)
 
 	channel.topic = topic
 	channel.topicSetBy = client.nickMaskString
 	channel.topicSetTime = time.Now()
 
 	for member := range channel.members {
-		member.Send(nil, client.nickMaskString, "TOPIC", channel.name, channel.topic)
+		member.SendFromClient("", client, nil, "TOPIC", channel.name, channel.topic)
 	}
 
 	// update saved channel topic for registered chans
@@ -419,7 +503,7 @@ func (channel *Channel) CanSpeak(client *Client) bool {
 	channel.membersMutex.RLock()
 	defer channel.membersMutex.RUnlock()
 
-	if client.flags[Operator] {
+	if client.HasMode(Operator) {
 		return true
 	}
 	if channel.flags[NoOutside] && !channel.members.Has(client) {
@@ -431,31 +515,58 @@ func (channel *Channel) CanSpeak(client *Client) bool {
 	if channel.flags[RegisteredOnly] && client.account == &NoAccount {
 		return false
 	}
+	// voiced users can speak through bans, so chanops can let banned users explain themselves
+	if channel.isBannedNoMutex(client) && !channel.clientIsAtLeastNoMutex(client, Voice) {
+		return false
+	}
 	return true
 }
 
+// isBannedNoMutex returns true if the client matches one of the channel's bans (or
+// matched one before they changed their nickmask) and none of its ban exceptions.
+func (channel *Channel) isBannedNoMutex(client *Client) bool {
+	if channel.lists[ExceptMask].MatchClient(client) {
+		return false
+	}
+	return channel.lists[BanMask].MatchClient(client) || channel.evadedBanNoMutex(client) != ""
+}
+
 // TagMsg sends a tag message to everyone in this channel who can accept them.
 func (channel *Channel) TagMsg(msgid string, minPrefix *Mode, clientOnlyTags *map[string]ircmsg.TagValue, client *Client) {
 	channel.sendMessage(msgid, "TAGMSG", []Capability{MessageTags}, minPrefix, clientOnlyTags, client, nil)
+
+	// only keep tagmsgs in the history if they have tags worth storing
+	storedTags := channel.server.Config().Server.ClientTags.StoredTags(clientOnlyTags)
+	if storedTags != nil {
+		channel.history.Add(history.Item{
+			Type:        history.Tagmsg,
+			Nick:        client.nickMaskString,
+			AccountName: client.account.Name,
+			Msgid:       msgid,
+			MinPrefix:   statusMsgPrefix(minPrefix),
+			Tags:        storedTags,
+		})
+	}
 }
 
 // sendMessage sends a given message to everyone on this channel.
 func (channel *Channel) sendMessage(msgid, cmd string, requiredCaps []Capability, minPrefix *Mode, clientOnlyTags *map[string]ircmsg.TagValue, client *Client, message *string) {
 	if !channel.CanSpeak(client) {
-		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 		return
 	}
 
-	channel.membersMutex.RLock()
-	defer channel.membersMutex.RUnlock()
-
 	// for STATUSMSG
 	var minPrefixMode Mode
 	if minPrefix != nil {
 		minPrefixMode = *minPrefix
 	}
+	target := statusMsgTarget(channel.name, minPrefix)
+
+	channel.membersMutex.RLock()
+	var recipients []*Client
 	for member := range channel.members {
-		if minPrefix != nil && !channel.ClientIsAtLeast(member, minPrefixMode) {
+		if minPrefix != nil && !channel.clientIsAtLeastNoMutex(member, minPrefixMode) {
 			// STATUSMSG
 			continue
 		}
@@ -473,17 +584,22 @@ func (channel *Channel) sendMessage(msgid, cmd string, requiredCaps []Capability
 			continue
 		}
 
+		recipients = append(recipients, member)
+	}
+	channel.membersMutex.RUnlock()
+
+	channel.server.broadcasts.Deliver(recipients, func(member *Client) {
 		var messageTagsToUse *map[string]ircmsg.TagValue
 		if member.capabilities[MessageTags] {
 			messageTagsToUse = clientOnlyTags
 		}
 
 		if message == nil {
-			member.SendFromClient(msgid, client, messageTagsToUse, cmd, channel.name)
+			member.SendFromClient(msgid, client, messageTagsToUse, cmd, target)
 		} else {
-			member.SendFromClient(msgid, client, messageTagsToUse, cmd, channel.name, *message)
+			member.SendFromClient(msgid, client, messageTagsToUse, cmd, target, *message)
 		}
-	}
+	})
 }
 
 // SplitPrivMsg sends a private message to everyone in this channel.
@@ -498,43 +614,66 @@ func (channel *Channel) SplitNotice(msgid string, minPrefix *Mode, clientOnlyTag
 
 func (channel *Channel) sendSplitMessage(msgid, cmd string, minPrefix *Mode, clientOnlyTags *map[string]ircmsg.TagValue, client *Client, message *SplitMessage) {
 	if !channel.CanSpeak(client) {
-		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 		return
 	}
 
-	channel.membersMutex.RLock()
-	defer channel.membersMutex.RUnlock()
-
 	// for STATUSMSG
 	var minPrefixMode Mode
 	if minPrefix != nil {
 		minPrefixMode = *minPrefix
 	}
+	target := statusMsgTarget(channel.name, minPrefix)
+
+	channel.membersMutex.RLock()
+	var recipients []*Client
 	for member := range channel.members {
-		if minPrefix != nil && !channel.ClientIsAtLeast(member, minPrefixMode) {
+		if minPrefix != nil && !channel.clientIsAtLeastNoMutex(member, minPrefixMode) {
 			// STATUSMSG
 			continue
 		}
 		if member == client && !client.capabilities[EchoMessage] {
 			continue
 		}
+		recipients = append(recipients, member)
+	}
+	channel.membersMutex.RUnlock()
+
+	channel.server.broadcasts.Deliver(recipients, func(member *Client) {
 		var tagsToUse *map[string]ircmsg.TagValue
 		if member.capabilities[MessageTags] {
 			tagsToUse = clientOnlyTags
 		}
 
 		if message == nil {
-			member.SendFromClient(msgid, client, tagsToUse, cmd, channel.name)
+			member.SendFromClient(msgid, client, tagsToUse, cmd, target)
 		} else {
-			member.SendSplitMsgFromClient(msgid, client, tagsToUse, cmd, channel.name, *message)
+			member.SendSplitMsgFromClient(msgid, client, tagsToUse, cmd, target, *message)
+		}
+	})
+
+	// STATUSMSG is stored with its prefix, so only those who could see it get it replayed
+	if message != nil {
+		itemType := history.Privmsg
+		if cmd == "NOTICE" {
+			itemType = history.Notice
 		}
+		channel.history.Add(history.Item{
+			Type:        itemType,
+			Nick:        client.nickMaskString,
+			AccountName: client.account.Name,
+			Msgid:       msgid,
+			Message:     message.ForMaxLine,
+			MinPrefix:   statusMsgPrefix(minPrefix),
+			Tags:        channel.server.Config().Server.ClientTags.StoredTags(clientOnlyTags),
+		})
 	}
 }
 
 func (channel *Channel) applyModeFlag(client *Client, mode Mode,
 	op ModeOp) bool {
 	if !channel.ClientIsAtLeast(client, ChannelOperator) {
-		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not a channel operator"))
 		return false
 	}
 
@@ -562,19 +701,19 @@ func (channel *Channel) applyModeMemberNoMutex(client *Client, mode Mode,
 
 	if nick == "" {
 		//TODO(dan): shouldn't this be handled before it reaches this function?
-		client.Send(nil, client.server.name, ERR_NEEDMOREPARAMS, "MODE", "Not enough parameters")
+		client.Send(nil, client.server.name, ERR_NEEDMOREPARAMS, "MODE", client.t("Not enough parameters"))
 		return nil
 	}
 
 	casefoldedName, err := CasefoldName(nick)
 	target := channel.server.clients.Get(casefoldedName)
 	if err != nil || target == nil {
-		client.Send(nil, client.server.name, ERR_NOSUCHNICK, nick, "No such nick")
+		client.Send(nil, client.server.name, ERR_NOSUCHNICK, nick, client.t("No such nick"))
 		return nil
 	}
 
 	if !channel.members.Has(target) {
-		client.Send(nil, client.server.name, ERR_USERNOTINCHANNEL, client.nick, channel.name, "They aren't on that channel")
+		client.Send(nil, client.server.name, ERR_USERNOTINCHANNEL, client.nick, channel.name, client.t("They aren't on that channel"))
 		return nil
 	}
 
@@ -619,11 +758,16 @@ func (channel *Channel) ShowMaskList(client *Client, mode Mode) {
 		rplendoflist = RPL_ENDOFINVITELIST
 	}
 
-	// send out responses
-	for mask := range channel.lists[mode].masks {
-		client.Send(nil, client.server.name, rpllist, client.nick, channel.name, mask)
+	// send out responses, with who set each entry and when if we know
+	for _, mask := range channel.sortedMasksNoMutex(mode) {
+		info := channel.lists[mode].Info(mask)
+		if info.SetBy == "" {
+			client.Send(nil, client.server.name, rpllist, client.nick, channel.name, mask)
+			continue
+		}
+		client.Send(nil, client.server.name, rpllist, client.nick, channel.name, mask, info.SetBy, strconv.FormatInt(info.SetAt.Unix(), 10))
 	}
-	client.Send(nil, client.server.name, rplendoflist, client.nick, channel.name, "End of list")
+	client.Send(nil, client.server.name, rplendoflist, client.nick, channel.name, client.t("End of list"))
 }
 
 func (channel *Channel) applyModeMask(client *Client, mode Mode, op ModeOp, mask string) bool {
@@ -639,7 +783,7 @@ func (channel *Channel) applyModeMask(client *Client, mode Mode, op ModeOp, mask
 	}
 
 	if !channel.ClientIsAtLeast(client, ChannelOperator) {
-		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+		client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not a channel operator"))
 		return false
 	}
 
@@ -671,6 +815,10 @@ func (channel *Channel) quitNoMutex(client *Client) {
 	client.channels.Remove(channel)
 
 	if channel.isEmptyNoMutex() {
+		if channel.listExpiryTimer != nil {
+			channel.listExpiryTimer.Stop()
+		}
+		channel.saveChannelHistoryNoMutex()
 		channel.server.channels.Remove(channel)
 	}
 }
@@ -678,25 +826,23 @@ func (channel *Channel) quitNoMutex(client *Client) {
 func (channel *Channel) kickNoMutex(client *Client, target *Client, comment string) {
 	// needs a Lock()
 
-	if !(client.flags[Operator] || channel.members.Has(client)) {
-		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, "You're not on that channel")
+	if !(client.HasMode(Operator) || channel.members.Has(client)) {
+		client.Send(nil, client.server.name, ERR_NOTONCHANNEL, channel.name, client.t("You're not on that channel"))
 		return
 	}
 	if !channel.clientIsAtLeastNoMutex(client, ChannelOperator) {
-		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+		client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 		return
 	}
 	if !channel.members.Has(target) {
-		client.Send(nil, client.server.name, ERR_USERNOTINCHANNEL, client.nick, channel.name, "They aren't on that channel")
+		client.Send(nil, client.server.name, ERR_USERNOTINCHANNEL, client.nick, channel.name, client.t("They aren't on that channel"))
 		return
 	}
 
-	if len(comment) > client.server.limits.KickLen {
-		comment = comment[:client.server.limits.KickLen]
-	}
+	comment = truncateUTF8(comment, client.server.Limits().KickLen)
 
 	for member := range channel.members {
-		member.Send(nil, client.nickMaskString, "KICK", channel.name, target.nick, comment)
+		member.SendFromClient("", client, nil, "KICK", channel.name, target.nick, comment)
 	}
 	channel.quitNoMutex(target)
 }
@@ -704,7 +850,7 @@ func (channel *Channel) kickNoMutex(client *Client, target *Client, comment stri
 // Invite invites the given client to the channel, if the inviter can do so.
 func (channel *Channel) Invite(invitee *Client, inviter *Client) {
 	if channel.flags[InviteOnly] && !channel.ClientIsAtLeast(inviter, ChannelOperator) {
-		inviter.Send(nil, inviter.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+		inviter.Send(nil, inviter.server.name, ERR_CHANOPRIVSNEEDED, inviter.nick, channel.name, inviter.t("You're not a channel operator"))
 		return
 	}
 
@@ -712,26 +858,26 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client) {
 	defer channel.membersMutex.RUnlock()
 
 	if !channel.members.Has(inviter) {
-		inviter.Send(nil, inviter.server.name, ERR_NOTONCHANNEL, channel.name, "You're not on that channel")
+		inviter.Send(nil, inviter.server.name, ERR_NOTONCHANNEL, channel.name, inviter.t("You're not on that channel"))
 		return
 	}
 
-	//TODO(dan): handle this more nicely, keep a list of last X invited channels on invitee rather than explicitly modifying the invite list?
+	// invites are kept on the invitee, so they don't build up in the channel's invite list
 	if channel.flags[InviteOnly] {
-		channel.lists[InviteMask].Add(invitee.nickMaskCasefolded)
+		invitee.invites.Add(channel.nameCasefolded, channel.name, inviter.nick, invitee.server.Config().Channels.InviteExpiry)
 	}
 
 	// send invite-notify
 	for member := range channel.members {
 		if member.capabilities[InviteNotify] && member != inviter && member != invitee && channel.ClientIsAtLeast(member, Halfop) {
-			member.Send(nil, inviter.nickMaskString, "INVITE", invitee.nick, channel.name)
+			member.SendFromClient("", inviter, nil, "INVITE", invitee.nick, channel.name)
 		}
 	}
 
 	//TODO(dan): should inviter.server.name here be inviter.nickMaskString ?
 	inviter.Send(nil, inviter.server.name, RPL_INVITING, invitee.nick, channel.name)
-	invitee.Send(nil, inviter.nickMaskString, "INVITE", invitee.nick, channel.name)
-	if invitee.flags[Away] {
-		inviter.Send(nil, inviter.server.name, RPL_AWAY, invitee.nick, invitee.awayMessage)
+	invitee.SendFromClient("", inviter, nil, "INVITE", invitee.nick, channel.name)
+	if isAway, awayMessage := invitee.Away(); isAway {
+		inviter.Send(nil, inviter.server.name, RPL_AWAY, invitee.nick, awayMessage)
 	}
 }
@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/oragono/oragono/irc/languages"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+// newTestServer returns a server with just enough set up for clients to be sent lines.
+func newTestServer() *Server {
+	logman, _ := logger.NewManager()
+	store, _ := buntdb.Open(":memory:")
+	return &Server{
+		name:      "oragono.test",
+		config:    &Config{},
+		logger:    logman,
+		languages: languages.NewManager("en", nil),
+		channels:  *NewChannelNameMap(),
+		// registering channels adds them to this
+		channelSkeletons: NewSkeletons(),
+		// joining checks whether channels are registered
+		store: store,
+	}
+}
+
+// newTestClient returns a client of the given server that isn't connected to anything.
+// The lines it's sent can be read with sentLines.
+func newTestClient(server *Server, nick string) *Client {
+	conn, _ := net.Pipe()
+	socket := NewSocket(conn, 0)
+	client := &Client{
+		server:   server,
+		socket:   &socket,
+		nick:     nick,
+		username: "~" + nick,
+		hostname: "example.com",
+		account:  &NoAccount,
+		flags:    make(map[Mode]bool),
+		channels: make(ChannelSet),
+		invites:  NewInviteList(),
+	}
+	client.updateNickMask()
+	return client
+}
+
+// sentLines returns the lines sent to the given test client since it was last called.
+func sentLines(client *Client) []string {
+	socket := client.socket
+	socket.linesToSendMutex.Lock()
+	defer socket.linesToSendMutex.Unlock()
+	var lines []string
+	for _, line := range socket.linesToSend {
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+	socket.linesToSend = nil
+	return lines
+}
+
+func TestModeSetPrefixes(t *testing.T) {
+	testCases := []struct {
+		modes       ModeSet
+		multiPrefix string
+		single      string
+	}{
+		{ModeSet{}, "", ""},
+		{ModeSet{Voice: true}, "+", "+"},
+		{ModeSet{ChannelOperator: true, Voice: true}, "@+", "@"},
+		{ModeSet{Voice: true, Halfop: true, ChannelFounder: true}, "~%+", "~"},
+		{ModeSet{ChannelFounder: true, ChannelAdmin: true, ChannelOperator: true, Halfop: true, Voice: true}, "~&@%+", "~"},
+	}
+
+	for _, tt := range testCases {
+		if result := tt.modes.Prefixes(true); result != tt.multiPrefix {
+			t.Errorf("expected multi-prefix prefixes for %v to be %q, got %q", tt.modes, tt.multiPrefix, result)
+		}
+		if result := tt.modes.Prefixes(false); result != tt.single {
+			t.Errorf("expected prefix for %v to be %q, got %q", tt.modes, tt.single, result)
+		}
+	}
+}
+
+func TestJoinRefusedNumerics(t *testing.T) {
+	server := newTestServer()
+	client := newTestClient(server, "dan")
+	channel := NewChannel(server, "#test", false)
+
+	channel.userLimit = 1
+	channel.members.Add(newTestClient(server, "alice"))
+	channel.Join(client, "", nil)
+	channel.userLimit = 0
+	channel.key = "secret"
+	channel.Join(client, "wrong", nil)
+	channel.key = ""
+	channel.flags[InviteOnly] = true
+	channel.Join(client, "", nil)
+	delete(channel.flags, InviteOnly)
+	channel.lists[BanMask].Add("dan!*@*")
+	channel.Join(client, "", nil)
+
+	expected := []string{
+		":oragono.test 471 dan #test :Cannot join channel (+l)",
+		":oragono.test 475 dan #test :Cannot join channel (+k)",
+		":oragono.test 473 dan #test :Cannot join channel (+i)",
+		":oragono.test 474 dan #test :Cannot join channel (+b), you're banned by dan!*@*",
+	}
+	lines := sentLines(client)
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected join to be refused with %q, got %q", expected, lines)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/tidwall/buntdb"
+)
+
+// ChannelExpiryConfig controls the expiry of registered channels that their founders
+// haven't visited in a while.
+type ChannelExpiryConfig struct {
+	Enabled bool
+	// Action is "drop" to drop expired registrations, or "flag" to only tell opers about them.
+	Action             string
+	InactiveTimeString string        `yaml:"inactive-time"`
+	InactiveTime       time.Duration `yaml:"inactive-time-real"`
+	WarningTimeString  string        `yaml:"warning-time"`
+	WarningTime        time.Duration `yaml:"warning-time-real"`
+	// Exempted channels never expire.
+	RawExempted []string        `yaml:"exempted"`
+	Exempted    map[string]bool `yaml:"exempted-real"`
+}
+
+func (conf *ChannelExpiryConfig) parse() (err error) {
+	if conf.Action == "" {
+		conf.Action = "flag"
+	}
+	if conf.Action != "drop" && conf.Action != "flag" {
+		return fmt.Errorf("Unknown action [%s], must be drop or flag", conf.Action)
+	}
+
+	if conf.InactiveTimeString == "" {
+		conf.InactiveTimeString = "90d"
+	}
+	conf.InactiveTime, err = custime.ParseDuration(conf.InactiveTimeString)
+	if err != nil {
+		return fmt.Errorf("Could not parse inactive-time: %s", err.Error())
+	}
+	if conf.WarningTimeString == "" {
+		conf.WarningTimeString = "7d"
+	}
+	conf.WarningTime, err = custime.ParseDuration(conf.WarningTimeString)
+	if err != nil {
+		return fmt.Errorf("Could not parse warning-time: %s", err.Error())
+	}
+	if conf.InactiveTime <= conf.WarningTime {
+		return fmt.Errorf("warning-time must be shorter than inactive-time")
+	}
+
+	conf.Exempted = make(map[string]bool)
+	for _, name := range conf.RawExempted {
+		casefoldedName, err := CasefoldChannel(name)
+		if err != nil {
+			return fmt.Errorf("Invalid exempted channel name [%s]", name)
+		}
+		conf.Exempted[casefoldedName] = true
+	}
+	return nil
+}
+
+// channelExpiryAction is what needs to happen to a registered channel that's been inactive.
+type channelExpiryAction int
+
+const (
+	channelExpiryNone channelExpiryAction = iota
+	channelExpiryWarn
+	channelExpiryFlag
+	channelExpiryDrop
+)
+
+// check returns what needs to happen to the given registered channel at the given time.
+// Channels only expire once their founder has been warned for the whole warning time.
+func (conf *ChannelExpiryConfig) check(channelKey string, chanReg *RegisteredChannel, now time.Time) channelExpiryAction {
+	if conf.Exempted[channelKey] {
+		return channelExpiryNone
+	}
+
+	inactive := now.Sub(chanReg.lastUsed())
+	if inactive < conf.InactiveTime-conf.WarningTime {
+		return channelExpiryNone
+	}
+	if chanReg.ExpiryWarned.IsZero() {
+		return channelExpiryWarn
+	}
+	if inactive < conf.InactiveTime || now.Sub(chanReg.ExpiryWarned) < conf.WarningTime {
+		return channelExpiryNone
+	}
+
+	if conf.Action == "drop" {
+		return channelExpiryDrop
+	} else if !chanReg.ExpiryFlagged {
+		return channelExpiryFlag
+	}
+	return channelExpiryNone
+}
+
+// expires returns when the given registered channel will expire, if its founder is
+// warned at the given time.
+func (conf *ChannelExpiryConfig) expires(chanReg *RegisteredChannel, now time.Time) time.Time {
+	expires := chanReg.lastUsed().Add(conf.InactiveTime)
+	if expires.Before(now.Add(conf.WarningTime)) {
+		expires = now.Add(conf.WarningTime)
+	}
+	return expires
+}
+
+// lastUsed returns the last time the channel's founder was seen in it, or when it was
+// registered if they haven't been seen yet.
+func (chanReg *RegisteredChannel) lastUsed() time.Time {
+	if chanReg.LastUsed.IsZero() {
+		return chanReg.RegisteredAt
+	}
+	return chanReg.LastUsed
+}
+
+// channelExpiryLoop regularly expires inactive registered channels.
+func (server *Server) channelExpiryLoop() {
+	for range time.Tick(channelExpiryCheckInterval) {
+		server.expireChannels(time.Now())
+	}
+}
+
+// founderPresent returns true if the founder of the given registered channel is in it.
+func (server *Server) founderPresent(channelKey string, founder string) bool {
+	channel := server.channels.Get(channelKey)
+	if channel == nil {
+		return false
+	}
+
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+	for member := range channel.members {
+		if member.account != nil && member.account.Name == founder {
+			return true
+		}
+	}
+	return false
+}
+
+// expireChannels warns the founders of registered channels that are about to expire,
+// and drops or flags the channels that have.
+func (server *Server) expireChannels(now time.Time) {
+	config := server.Config().Channels.Registration.Expiry
+	if !config.Enabled {
+		return
+	}
+
+	// find our registered channels first. joining a channel locks its members before
+	// the registered channels, so we can't check for founders while holding that lock
+	founders := make(map[string]string)
+	server.registeredChannelsMutex.Lock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		var channelKeys []string
+		tx.AscendKeys("channel.exists *", func(key, value string) bool {
+			channelKeys = append(channelKeys, key[len("channel.exists "):])
+			return true
+		})
+		for _, channelKey := range channelKeys {
+			chanReg := server.loadChannelNoMutex(tx, channelKey)
+			if chanReg != nil {
+				founders[channelKey] = chanReg.Founder
+			}
+		}
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+
+	present := make(map[string]bool)
+	for channelKey, founder := range founders {
+		present[channelKey] = server.founderPresent(channelKey, founder)
+	}
+
+	server.registeredChannelsMutex.Lock()
+	defer server.registeredChannelsMutex.Unlock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		for channelKey := range founders {
+			chanReg := server.loadChannelNoMutex(tx, channelKey)
+			if chanReg == nil {
+				continue
+			}
+
+			// founders who are in their channel are still using it
+			if present[channelKey] {
+				chanReg.LastUsed = now
+				chanReg.ExpiryWarned = time.Time{}
+				chanReg.ExpiryFlagged = false
+				server.saveChannelNoMutex(tx, channelKey, *chanReg)
+				continue
+			}
+
+			lastUsed := chanReg.lastUsed()
+			switch config.check(channelKey, chanReg, now) {
+			case channelExpiryWarn:
+				server.warnChannelExpiry(tx, chanReg, config.expires(chanReg, now))
+				chanReg.ExpiryWarned = now
+				server.saveChannelNoMutex(tx, channelKey, *chanReg)
+			case channelExpiryFlag:
+				chanReg.ExpiryFlagged = true
+				server.saveChannelNoMutex(tx, channelKey, *chanReg)
+				server.logger.Info("chanserv", fmt.Sprintf("Channel %s has expired and is flagged for review", chanReg.Name))
+				server.snomasks.Send(sno.LocalChannels, fmt.Sprintf("Channel %s has expired, its founder %s hasn't visited it since %s. Drop it if it's no longer needed", chanReg.Name, chanReg.Founder, lastUsed.Format(time.RFC1123)))
+			case channelExpiryDrop:
+				server.deleteChannelNoMutex(tx, channelKey)
+				server.logger.Info("chanserv", fmt.Sprintf("Dropped expired channel %s", chanReg.Name))
+				server.snomasks.Send(sno.LocalChannels, fmt.Sprintf("Channel %s has expired and was dropped, its founder %s hadn't visited it since %s", chanReg.Name, chanReg.Founder, lastUsed.Format(time.RFC1123)))
+			}
+		}
+		return nil
+	})
+}
+
+// warnChannelExpiry tells the founder of the given channel that it's about to expire.
+// Founders who aren't online are told the next time they log in.
+func (server *Server) warnChannelExpiry(tx *buntdb.Tx, chanReg *RegisteredChannel, expires time.Time) {
+	accountKey, err := CasefoldName(chanReg.Founder)
+	if err != nil {
+		return
+	}
+	clients := server.loggedInClients(accountKey)
+	for _, client := range clients {
+		sendChannelExpiryWarning(client, chanReg.Name, expires)
+	}
+	if 0 < len(clients) {
+		return
+	}
+
+	// warnings maps channel name -> when it expires
+	warnings := make(map[string]int64)
+	warningsKey := fmt.Sprintf(keyAccountChannelWarnings, accountKey)
+	warningsString, _ := tx.Get(warningsKey)
+	_ = json.Unmarshal([]byte(warningsString), &warnings)
+	warnings[chanReg.Name] = expires.Unix()
+	warningsBytes, _ := json.Marshal(warnings)
+	tx.Set(warningsKey, string(warningsBytes), nil)
+}
+
+// sendChannelExpiryWarning tells the given client that their channel will expire.
+func sendChannelExpiryWarning(client *Client, channelName string, expires time.Time) {
+	client.ChanServNotice(fmt.Sprintf(client.t("The registration of %[1]s will expire on %[2]s, because you haven't visited it in a while. Join it to keep it registered"), channelName, expires.Format(time.RFC1123)))
+}
+
+// sendStoredChannelExpiryWarnings gives the client the expiry warnings that were stored
+// for their account while they weren't online, for channels that are still expiring.
+func (server *Server) sendStoredChannelExpiryWarnings(client *Client) {
+	account := client.account
+	if account == nil || account == &NoAccount {
+		return
+	}
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return
+	}
+
+	// warnings maps channel name -> when it expires
+	warnings := make(map[string]int64)
+	var channelNames []string
+	server.registeredChannelsMutex.Lock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		warningsKey := fmt.Sprintf(keyAccountChannelWarnings, accountKey)
+		warningsString, err := tx.Get(warningsKey)
+		if err != nil {
+			return nil
+		}
+		tx.Delete(warningsKey)
+		_ = json.Unmarshal([]byte(warningsString), &warnings)
+
+		for channelName := range warnings {
+			channelKey, err := CasefoldChannel(channelName)
+			if err != nil {
+				continue
+			}
+			// the founder might have visited or dropped the channel since
+			chanReg := server.loadChannelNoMutex(tx, channelKey)
+			if chanReg != nil && chanReg.Founder == account.Name && !chanReg.ExpiryWarned.IsZero() {
+				channelNames = append(channelNames, channelName)
+			}
+		}
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+
+	sort.Strings(channelNames)
+	for _, channelName := range channelNames {
+		sendChannelExpiryWarning(client, channelName, time.Unix(warnings[channelName], 0))
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestChannelExpiryCheck(t *testing.T) {
+	config := ChannelExpiryConfig{
+		Enabled:            true,
+		InactiveTimeString: "30d",
+		WarningTimeString:  "7d",
+		RawExempted:        []string{"#Oragono"},
+	}
+	err := config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	testCases := []struct {
+		channelKey string
+		chanReg    RegisteredChannel
+		action     channelExpiryAction
+	}{
+		{"#new", RegisteredChannel{RegisteredAt: now.Add(-day)}, channelExpiryNone},
+		{"#quiet", RegisteredChannel{RegisteredAt: now.Add(-60 * day), LastUsed: now.Add(-25 * day)}, channelExpiryWarn},
+		{"#quiet", RegisteredChannel{RegisteredAt: now.Add(-60 * day), LastUsed: now.Add(-25 * day), ExpiryWarned: now.Add(-day)}, channelExpiryNone},
+		// founders are always warned before their channel expires
+		{"#old", RegisteredChannel{RegisteredAt: now.Add(-40 * day)}, channelExpiryWarn},
+		{"#old", RegisteredChannel{RegisteredAt: now.Add(-40 * day), ExpiryWarned: now.Add(-day)}, channelExpiryNone},
+		{"#old", RegisteredChannel{RegisteredAt: now.Add(-40 * day), ExpiryWarned: now.Add(-8 * day)}, channelExpiryFlag},
+		{"#old", RegisteredChannel{RegisteredAt: now.Add(-40 * day), ExpiryWarned: now.Add(-8 * day), ExpiryFlagged: true}, channelExpiryNone},
+		{"#oragono", RegisteredChannel{RegisteredAt: now.Add(-400 * day)}, channelExpiryNone},
+	}
+	for _, testCase := range testCases {
+		action := config.check(testCase.channelKey, &testCase.chanReg, now)
+		if action != testCase.action {
+			t.Errorf("expected %s %+v to give action %d, got %d", testCase.channelKey, testCase.chanReg, testCase.action, action)
+		}
+	}
+
+	config.Action = "drop"
+	if config.check("#old", &RegisteredChannel{RegisteredAt: now.Add(-40 * day), ExpiryWarned: now.Add(-8 * day), ExpiryFlagged: true}, now) != channelExpiryDrop {
+		t.Error("expected expired channels to be dropped")
+	}
+	if config.check("#old", &RegisteredChannel{RegisteredAt: now.Add(-40 * day), ExpiryWarned: now.Add(-day)}, now) != channelExpiryNone {
+		t.Error("expected expired channels to not be dropped until the founder has been warned for long enough")
+	}
+	late := &RegisteredChannel{RegisteredAt: now.Add(-40 * day)}
+	if !config.expires(late, now).Equal(now.Add(config.WarningTime)) {
+		t.Error("expected founders warned late to get the whole warning time")
+	}
+
+	config = ChannelExpiryConfig{InactiveTimeString: "1d", WarningTimeString: "2d"}
+	if config.parse() == nil {
+		t.Error("expected a warning time longer than the inactive time to be invalid")
+	}
+}
+
+func TestStoredChannelExpiryWarnings(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatalf("could not open datastore: %s", err.Error())
+	}
+	server := newTestServer()
+	server.store = store
+	server.accounts = make(map[string]*ClientAccount)
+	server.registeredChannels = make(map[string]*RegisteredChannel)
+
+	now := time.Now()
+	expires := now.Add(7 * 24 * time.Hour)
+	chanReg := RegisteredChannel{Name: "#Quiet", Founder: "Alice", RegisteredAt: now.Add(-60 * 24 * time.Hour)}
+	server.registeredChannelsMutex.Lock()
+	store.Update(func(tx *buntdb.Tx) error {
+		// alice isn't online, so the warning waits for them to log in
+		server.warnChannelExpiry(tx, &chanReg, expires)
+		chanReg.ExpiryWarned = now
+		server.saveChannelNoMutex(tx, "#quiet", chanReg)
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+
+	alice := newTestClient(server, "alice")
+	alice.account = &ClientAccount{Name: "Alice"}
+	server.sendStoredChannelExpiryWarnings(alice)
+	lines := sentLines(alice)
+	if len(lines) != 1 || !strings.Contains(lines[0], "The registration of #Quiet will expire on "+expires.Format(time.RFC1123)) {
+		t.Errorf("expected alice to be warned when logging in, got %q", lines)
+	}
+
+	server.sendStoredChannelExpiryWarnings(alice)
+	if lines := sentLines(alice); len(lines) != 0 {
+		t.Errorf("expected stored warnings to only be sent once, got %q", lines)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/oragono/oragono/irc/history"
+	"github.com/tidwall/buntdb"
+)
+
+// saveChannelHistoryNoMutex stores the channel's history in the datastore if it's
+// registered and history is persistent, so it can be replayed after the channel's been
+// empty or the server's restarted. It's called when the last member leaves (which
+// includes everyone being disconnected at shutdown).
+func (channel *Channel) saveChannelHistoryNoMutex() {
+	server := channel.server
+	if !server.Config().History.Persistent {
+		return
+	}
+	items := channel.history.Latest(0)
+
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(fmt.Sprintf(keyChannelExists, channel.nameCasefolded))
+		if err != nil {
+			// unregistered channels lose their history when they empty
+			return nil
+		}
+		key := fmt.Sprintf(keyChannelHistory, channel.nameCasefolded)
+		if len(items) == 0 {
+			tx.Delete(key)
+			return nil
+		}
+		itemsString, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(itemsString), nil)
+		return err
+	})
+	if err != nil {
+		server.logger.Error("internal", fmt.Sprintf("Could not save history of channel %s: %s", channel.name, err.Error()))
+	}
+}
+
+// loadChannelHistoryNoMutex adds the channel's stored history to its history buffer.
+func (channel *Channel) loadChannelHistoryNoMutex(tx *buntdb.Tx) {
+	if !channel.server.Config().History.Persistent {
+		return
+	}
+	itemsString, err := tx.Get(fmt.Sprintf(keyChannelHistory, channel.nameCasefolded))
+	if err != nil {
+		return
+	}
+	var items []history.Item
+	err = json.Unmarshal([]byte(itemsString), &items)
+	if err != nil {
+		channel.server.logger.Error("internal", fmt.Sprintf("Could not load history of channel %s: %s", channel.name, err.Error()))
+		return
+	}
+	for _, item := range items {
+		channel.history.Add(item)
+	}
+}
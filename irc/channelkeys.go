@@ -0,0 +1,200 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// redactedChannelKey is shown instead of a channel's key to clients who aren't chanops.
+	redactedChannelKey = "*"
+	// rotatedChannelKeyBytes is how many random bytes go into keys made by CS SET KEY ROTATE.
+	rotatedChannelKeyBytes = 12
+	// channelKeyAttempts is how many wrong keys for registered channels each IP gets in
+	// channelKeyPeriod, so the keys we only have the hashes of can't be brute-forced.
+	channelKeyAttempts = 5
+	channelKeyPeriod   = 5 * time.Minute
+)
+
+// newChannelKeyThrottle returns the throttle for wrong registered channel keys.
+func newChannelKeyThrottle() *LoginThrottle {
+	return NewLoginThrottle(LoginThrottleConfig{
+		Enabled:     true,
+		Duration:    channelKeyPeriod,
+		MaxAttempts: channelKeyAttempts,
+	})
+}
+
+// hashChannelKey returns the hash of the given channel key that we store for registered
+// channels, or nil if there's no key.
+func (server *Server) hashChannelKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	hash, err := hashPassword(server.Config().PasswordHashing, []byte(key))
+	if err != nil {
+		server.logger.Error("chanserv", fmt.Sprintf("Could not hash channel key: %s", err.Error()))
+		return nil
+	}
+	return hash
+}
+
+// channelKeyHash returns the hash of the given channel's key, if it's registered and we
+// don't know its key yet.
+func (server *Server) channelKeyHash(casefoldedName string) []byte {
+	channel := server.channels.Get(casefoldedName)
+	if channel != nil {
+		channel.membersMutex.RLock()
+		loaded, keyHash := channel.registrationLoaded, channel.keyHash
+		channel.membersMutex.RUnlock()
+		if loaded {
+			return keyHash
+		}
+	}
+	var keyHash []byte
+	server.store.View(func(tx *buntdb.Tx) error {
+		keyHashString, err := tx.Get(fmt.Sprintf(keyChannelKeyHash, casefoldedName))
+		if err == nil {
+			keyHash, _ = DecodePasswordHash(keyHashString)
+		}
+		return nil
+	})
+	return keyHash
+}
+
+// checkChannelKey returns true if the given key matches the given hash of a registered
+// channel's key. Hashes are slow to check, so this is done before any locks are taken.
+func (server *Server) checkChannelKey(client *Client, keyHash []byte, key string) bool {
+	ip := client.IP().String()
+	if !server.channelKeyThrottle.Allowed(ip) {
+		return false
+	}
+	if ComparePassword(keyHash, []byte(key)) != nil {
+		server.channelKeyThrottle.Failed(ip)
+		return false
+	}
+	return true
+}
+
+// hasKeyNoMutex returns true if the channel has a key (+k), even if we only know its hash.
+func (channel *Channel) hasKeyNoMutex() bool {
+	return channel.key != "" || channel.keyHash != nil
+}
+
+// canSeeKeyNoMutex returns true if the client can see the channel's key.
+func (channel *Channel) canSeeKeyNoMutex(client *Client) bool {
+	return client.HasMode(Operator) || channel.clientIsAtLeastNoMutex(client, ChannelOperator)
+}
+
+// redactKeys returns the mode changes with the channel keys they set hidden.
+func (changes ModeChanges) redactKeys() ModeChanges {
+	redacted := make(ModeChanges, len(changes))
+	copy(redacted, changes)
+	for i, change := range redacted {
+		if change.mode == Key && change.arg != "" {
+			redacted[i].arg = redactedChannelKey
+		}
+	}
+	return redacted
+}
+
+// sendModeChangesNoMutex sends the given mode changes to the channel's members, only
+// showing the channel's key to those who can see it.
+func (channel *Channel) sendModeChangesNoMutex(send func(member *Client, args []string), changes ModeChanges) {
+	//TODO(dan): we should change the name of String and make it return a slice here
+	args := append([]string{channel.name}, strings.Split(changes.String(), " ")...)
+	redactedArgs := append([]string{channel.name}, strings.Split(changes.redactKeys().String(), " ")...)
+	for member := range channel.members {
+		if channel.canSeeKeyNoMutex(member) {
+			send(member, args)
+		} else {
+			send(member, redactedArgs)
+		}
+	}
+}
+
+// generateChannelKey returns a new random channel key.
+func generateChannelKey() (string, error) {
+	keyBytes := make([]byte, rotatedChannelKeyBytes)
+	_, err := rand.Read(keyBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(keyBytes), nil
+}
+
+// chanservSetKey handles CS SET <channel> KEY [ROTATE|OFF], which shows the channel's
+// key, replaces it with a new random one, or removes it.
+func (server *Server) chanservSetKey(client *Client, channel *Channel, params []string) {
+	if len(params) < 1 {
+		channel.membersMutex.RLock()
+		key, hasKey := channel.key, channel.hasKeyNoMutex()
+		channel.membersMutex.RUnlock()
+		if !hasKey {
+			client.ChanServNotice(fmt.Sprintf(client.t("%s doesn't have a key"), channel.name))
+		} else if key == "" {
+			client.ChanServNotice(fmt.Sprintf(client.t("%s has a key, but it isn't known until someone joins with it. Use SET KEY ROTATE to replace it"), channel.name))
+		} else {
+			client.ChanServNotice(fmt.Sprintf(client.t("The key of %[1]s is %[2]s"), channel.name, key))
+		}
+		return
+	}
+
+	var key string
+	var err error
+	change := ModeChange{mode: Key}
+	switch strings.ToLower(params[0]) {
+	case "rotate":
+		key, err = generateChannelKey()
+		if err != nil {
+			client.ChanServNotice(client.t("Could not make a new key"))
+			server.logger.Error("chanserv", fmt.Sprintf("Could not make a channel key: %s", err.Error()))
+			return
+		}
+		change.op = Add
+		change.arg = key
+	case "off":
+		change.op = Remove
+	default:
+		client.ChanServNotice(client.t("Syntax: SET <channel> KEY [ROTATE|OFF]"))
+		return
+	}
+	keyHash := server.hashChannelKey(key)
+
+	channel.membersMutex.Lock()
+	channel.key = key
+	channel.keyHash = nil
+	channel.sendModeChangesNoMutex(func(member *Client, args []string) {
+		member.Send(nil, fmt.Sprintf("ChanServ!services@%s", server.name), "MODE", args...)
+	}, ModeChanges{change})
+	channel.membersMutex.Unlock()
+
+	// registered channels remember their key, but only its hash
+	server.registeredChannelsMutex.Lock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channel.nameCasefolded)
+		if chanInfo != nil {
+			chanInfo.KeyHash = keyHash
+			server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanInfo)
+		}
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+
+	if key == "" {
+		server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s removed the key of channel %s", client.nick, channel.name))
+		client.ChanServNotice(fmt.Sprintf(client.t("Removed the key of %s"), channel.name))
+	} else {
+		server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s rotated the key of channel %s", client.nick, channel.name))
+		client.ChanServNotice(fmt.Sprintf(client.t("The key of %[1]s is now %[2]s"), channel.name, key))
+	}
+}
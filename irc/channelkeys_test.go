@@ -0,0 +1,127 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChannelKeyHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	channel := &Channel{keyHash: hash}
+
+	if !channel.hasKeyNoMutex() {
+		t.Error("channels with a key hash should have a key")
+	}
+	if channel.CheckKey("", nil) || channel.CheckKey("wrong", nil) {
+		t.Error("keys that haven't been checked against the hash shouldn't match")
+	}
+	if channel.CheckKey("secret", []byte("some other hash")) {
+		t.Error("keys checked against a different hash shouldn't match")
+	}
+	if channel.key != "" {
+		t.Error("wrong keys shouldn't be remembered")
+	}
+	if !channel.CheckKey("secret", hash) {
+		t.Error("the right key should match the hash")
+	}
+	if channel.key != "secret" || channel.keyHash != nil {
+		t.Error("the right key should be remembered once it's been used")
+	}
+	if !channel.CheckKey("secret", nil) || channel.CheckKey("wrong", nil) {
+		t.Error("the remembered key should be checked")
+	}
+}
+
+func TestRedactKeys(t *testing.T) {
+	changes := ModeChanges{
+		{mode: Key, op: Add, arg: "secret"},
+		{mode: UserLimit, op: Add, arg: "10"},
+	}
+	if changes.redactKeys().String() != "+kl * 10" {
+		t.Errorf("unexpected redacted changes: %s", changes.redactKeys().String())
+	}
+	if changes.String() != "+kl secret 10" {
+		t.Errorf("redacting keys shouldn't change the original changes: %s", changes.String())
+	}
+}
+
+func TestCheckChannelKey(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := newTestServer()
+	server.channelKeyThrottle = newChannelKeyThrottle()
+	client := newTestClient(server, "dan")
+
+	if !server.checkChannelKey(client, hash, "secret") {
+		t.Error("the right key should match the hash")
+	}
+	for i := 0; i < channelKeyAttempts; i++ {
+		if server.checkChannelKey(client, hash, "wrong") {
+			t.Error("wrong keys shouldn't match the hash")
+		}
+	}
+	if server.checkChannelKey(client, hash, "secret") {
+		t.Error("expected keys to be refused after too many wrong ones")
+	}
+}
+
+func TestJoinLoadsRegisteredKey(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := newTestServer()
+	server.config = &Config{}
+	server.channelKeyThrottle = newChannelKeyThrottle()
+	server.registeredChannels = make(map[string]*RegisteredChannel)
+	server.store.Update(func(tx *buntdb.Tx) error {
+		server.saveChannelNoMutex(tx, "#test", RegisteredChannel{
+			Name:         "#test",
+			RegisteredAt: time.Now(),
+			Founder:      "alice",
+			Banlist:      []string{"eve!*@*"},
+			KeyHash:      hash,
+		})
+		return nil
+	})
+
+	// the first client to join an empty registered channel still needs its key
+	channel := NewChannel(server, "#test", false)
+	dan := newTestClient(server, "dan")
+	channel.Join(dan, "", nil)
+	lines := sentLines(dan)
+	if len(lines) != 1 || !strings.Contains(lines[0], " 475 ") {
+		t.Errorf("expected joining without the key to be refused, got %q", lines)
+	}
+
+	keyHash := server.channelKeyHash("#test")
+	if keyHash == nil {
+		t.Fatal("expected the channel to have a key hash")
+	}
+	eve := newTestClient(server, "eve")
+	if !server.checkChannelKey(eve, keyHash, "secret") {
+		t.Error("the right key should match the hash")
+	}
+	channel.Join(eve, "secret", keyHash)
+	lines = sentLines(eve)
+	if len(lines) != 1 || !strings.Contains(lines[0], " 474 ") {
+		t.Errorf("expected banned clients to be refused, got %q", lines)
+	}
+
+	channel.Join(dan, "secret", keyHash)
+	if !channel.HasClient(dan) {
+		t.Error("expected joining with the key to work")
+	}
+}
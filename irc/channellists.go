@@ -0,0 +1,198 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+var (
+	// listModes are the channel modes that hold lists of masks.
+	listModes = []Mode{BanMask, ExceptMask, InviteMask}
+)
+
+// listsToRegistrationNoMutex copies the channel's ban, exception and invite exception
+// lists, and what we know about their entries, into the given registration.
+func (channel *Channel) listsToRegistrationNoMutex(chanInfo *RegisteredChannel) {
+	var banlist, exceptlist, invitelist []string
+	for mask := range channel.lists[BanMask].masks {
+		banlist = append(banlist, mask)
+	}
+	for mask := range channel.lists[ExceptMask].masks {
+		exceptlist = append(exceptlist, mask)
+	}
+	for mask := range channel.lists[InviteMask].masks {
+		invitelist = append(invitelist, mask)
+	}
+	chanInfo.Banlist = banlist
+	chanInfo.Exceptlist = exceptlist
+	chanInfo.Invitelist = invitelist
+
+	chanInfo.ListInfo = make(map[string]map[string]MaskInfo)
+	for _, mode := range listModes {
+		info := channel.lists[mode].AllInfo()
+		if 0 < len(info) {
+			chanInfo.ListInfo[mode.String()] = info
+		}
+	}
+}
+
+// loadListInfoNoMutex sets what we know about the channel's list entries, from its
+// registration.
+func (channel *Channel) loadListInfoNoMutex(listInfo map[string]map[string]MaskInfo) {
+	for _, mode := range listModes {
+		for mask, info := range listInfo[mode.String()] {
+			channel.lists[mode].SetInfo(mask, info)
+		}
+	}
+	channel.scheduleListExpiryNoMutex()
+}
+
+// saveChannelListsNoMutex saves the lists of the given channel, if it's registered. The
+// caller must hold the channel's membersMutex.
+func (server *Server) saveChannelListsNoMutex(channel *Channel) {
+	server.registeredChannelsMutex.Lock()
+	defer server.registeredChannelsMutex.Unlock()
+	if server.registeredChannels[channel.nameCasefolded] == nil {
+		return
+	}
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channel.nameCasefolded)
+		channel.listsToRegistrationNoMutex(chanInfo)
+		server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanInfo)
+		return nil
+	})
+}
+
+// MaskInfo returns whether the given casefolded mask is in the given list, and what we
+// know about it.
+func (channel *Channel) MaskInfo(mode Mode, mask string) (exists bool, info MaskInfo) {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+	list := channel.lists[mode]
+	return list.masks[mask], list.Info(mask)
+}
+
+// sortedMasksNoMutex returns the masks in the given list in the order they were set.
+// Masks we don't know the age of (which were set before we kept track) come first.
+func (channel *Channel) sortedMasksNoMutex(mode Mode) []string {
+	list := channel.lists[mode]
+	masks := make([]string, 0, len(list.masks))
+	for mask := range list.masks {
+		masks = append(masks, mask)
+	}
+	sort.Slice(masks, func(i, j int) bool {
+		first, second := list.Info(masks[i]).SetAt, list.Info(masks[j]).SetAt
+		if first.Equal(second) {
+			return masks[i] < masks[j]
+		}
+		return first.Before(second)
+	})
+	return masks
+}
+
+// listFullNoMutex returns true if adding the given casefolded mask to the given list
+// would take it over our list length limit. Masks that are already on the list can
+// still be set again, to change when they expire.
+func (channel *Channel) listFullNoMutex(mode Mode, mask string) bool {
+	list := channel.lists[mode]
+	return !list.masks[mask] && channel.server.Limits().ChanListModes <= len(list.masks)
+}
+
+// timeLeft returns how long is left until the given expiry time, to the second.
+func timeLeft(expires time.Time) time.Duration {
+	left := time.Until(expires).Round(time.Second)
+	if left < time.Second {
+		// it's about to be removed
+		left = time.Second
+	}
+	return left
+}
+
+// matchingBanNoMutex returns the ban that matches the given client, unless they're
+// exempted from it.
+func (channel *Channel) matchingBanNoMutex(client *Client) (mask string, info MaskInfo, banned bool) {
+	if channel.lists[ExceptMask].MatchClient(client) {
+		return "", info, false
+	}
+	mask, banned = channel.lists[BanMask].MatchingMask(client)
+	if !banned {
+		mask = channel.evadedBanNoMutex(client)
+		if mask == "" {
+			return "", info, false
+		}
+	}
+	return mask, channel.lists[BanMask].Info(mask), true
+}
+
+// evadedBanNoMutex returns the ban that the client matched before they changed their
+// nickmask, if it's still set.
+func (channel *Channel) evadedBanNoMutex(client *Client) string {
+	client.banEvasionsMutex.Lock()
+	mask := client.banEvasions[channel.nameCasefolded]
+	client.banEvasionsMutex.Unlock()
+	if mask == "" || !channel.lists[BanMask].masks[mask] {
+		return ""
+	}
+	return mask
+}
+
+// matchingBans returns the bans that match the client in the channels they're in.
+func (client *Client) matchingBans() map[*Channel]string {
+	client.server.channelJoinPartMutex.Lock()
+	channels := make([]*Channel, 0, len(client.channels))
+	for channel := range client.channels {
+		channels = append(channels, channel)
+	}
+	client.server.channelJoinPartMutex.Unlock()
+
+	bans := make(map[*Channel]string)
+	for _, channel := range channels {
+		channel.membersMutex.RLock()
+		if channel.isBannedNoMutex(client) {
+			bans[channel], _, _ = channel.matchingBanNoMutex(client)
+		}
+		channel.membersMutex.RUnlock()
+	}
+	return bans
+}
+
+// noteBanEvasions remembers the given bans of the client that don't match their new
+// nickmask, so that changing nick or host (like with a vhost) doesn't dodge them. Only
+// this client is affected, unlike banning their new host, which might be shared.
+func (client *Client) noteBanEvasions(bans map[*Channel]string) {
+	for channel, mask := range bans {
+		channel.membersMutex.RLock()
+		evaded := !channel.isBannedNoMutex(client)
+		channel.membersMutex.RUnlock()
+		if !evaded {
+			continue
+		}
+
+		client.banEvasionsMutex.Lock()
+		if client.banEvasions == nil {
+			client.banEvasions = make(map[string]string)
+		}
+		client.banEvasions[channel.nameCasefolded] = mask
+		client.banEvasionsMutex.Unlock()
+		client.server.logger.Debug("channels", fmt.Sprintf("%s is still banned from %s by %s after changing their nickmask", client.nick, channel.name, mask))
+	}
+}
+
+// bannedMessageNoMutex returns the reason the client is told they can't join the channel
+// because they're banned, including the ban that matched them and when it expires.
+func (channel *Channel) bannedMessageNoMutex(client *Client) string {
+	mask, info, banned := channel.matchingBanNoMutex(client)
+	if !banned {
+		return client.t("Cannot join channel (+b)")
+	}
+	if info.Expires.IsZero() {
+		return fmt.Sprintf(client.t("Cannot join channel (+b), you're banned by %s"), mask)
+	}
+	return fmt.Sprintf(client.t("Cannot join channel (+b), you're banned by %[1]s for another %[2]s"), mask, timeLeft(info.Expires).String())
+}
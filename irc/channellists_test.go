@@ -0,0 +1,154 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/languages"
+)
+
+func TestMatchingMask(t *testing.T) {
+	client := &Client{account: &ClientAccount{Name: "Dan"}, nickMaskCasefolded: "dan!~d@example.com"}
+
+	var tests = []struct {
+		masks   []string
+		matched string
+	}{
+		{[]string{"*!*@example.com"}, "*!*@example.com"},
+		{[]string{"bob!*@*", "*!*@other.com"}, ""},
+		{[]string{"bob!*@*", "d?n!*@*", "*!*@other.com"}, "d?n!*@*"},
+		{[]string{"$a:someone", "$a:dan"}, "$a:dan"},
+		// masks in the middle of the set have to match the whole nickmask too
+		{[]string{"a!*@*", "*@example", "z!*@*"}, ""},
+	}
+	for _, test := range tests {
+		set := NewUserMaskSet()
+		for _, mask := range test.masks {
+			set.Add(mask)
+		}
+		mask, matched := set.MatchingMask(client)
+		if mask != test.matched || matched != (test.matched != "") {
+			t.Errorf("expected %v to match with %q, got %q", test.masks, test.matched, mask)
+		}
+		if set.MatchClient(client) != matched {
+			t.Errorf("expected MatchClient and MatchingMask to agree for %v", test.masks)
+		}
+	}
+}
+
+func TestMaskMatchersReused(t *testing.T) {
+	set := NewUserMaskSet()
+	set.Add("b!*@*")
+	compiled := set.matchers[0].regexp
+	set.Add("a!*@*")
+	set.Add("$a:dan")
+	set.Remove("a!*@*")
+	if len(set.matchers) != 2 || set.matchers[1].mask != "b!*@*" || set.matchers[1].regexp != compiled {
+		t.Errorf("expected existing masks to keep their compiled matchers, got %v", set.matchers)
+	}
+}
+
+func TestBannedMessage(t *testing.T) {
+	server := &Server{languages: languages.NewManager("en", nil)}
+	client := &Client{account: &NoAccount, nickMaskCasefolded: "dan!~d@example.com", server: server}
+	channel := &Channel{
+		lists: map[Mode]*UserMaskSet{
+			BanMask:    NewUserMaskSet(),
+			ExceptMask: NewUserMaskSet(),
+			InviteMask: NewUserMaskSet(),
+		},
+	}
+
+	bans := channel.lists[BanMask]
+	bans.Add("*!*@example.com")
+	message := channel.bannedMessageNoMutex(client)
+	if message != "Cannot join channel (+b), you're banned by *!*@example.com" {
+		t.Errorf("unexpected message for a permanent ban: %s", message)
+	}
+
+	bans.SetInfo("*!*@example.com", MaskInfo{SetBy: "op!~o@example.com", SetAt: time.Now(), Expires: time.Now().Add(2 * time.Hour)})
+	message = channel.bannedMessageNoMutex(client)
+	if message != "Cannot join channel (+b), you're banned by *!*@example.com for another 2h0m0s" {
+		t.Errorf("unexpected message for a timed ban: %s", message)
+	}
+
+	channel.lists[ExceptMask].Add("dan!*@*")
+	if _, _, banned := channel.matchingBanNoMutex(client); banned {
+		t.Error("expected exempted clients not to match any ban")
+	}
+}
+
+func TestMaskListOrderAndLimit(t *testing.T) {
+	server := &Server{limits: Limits{ChanListModes: 3}}
+	channel := &Channel{
+		lists: map[Mode]*UserMaskSet{
+			BanMask:    NewUserMaskSet(),
+			ExceptMask: NewUserMaskSet(),
+			InviteMask: NewUserMaskSet(),
+		},
+		server: server,
+	}
+
+	bans := channel.lists[BanMask]
+	start := time.Now()
+	bans.Add("c!*@*")
+	bans.SetInfo("c!*@*", MaskInfo{SetBy: "op", SetAt: start})
+	bans.Add("b!*@*")
+	bans.SetInfo("b!*@*", MaskInfo{SetBy: "op", SetAt: start.Add(-time.Minute)})
+	// masks set before we kept track of who set them come first
+	bans.Add("z!*@*")
+
+	masks := channel.sortedMasksNoMutex(BanMask)
+	if len(masks) != 3 || masks[0] != "z!*@*" || masks[1] != "b!*@*" || masks[2] != "c!*@*" {
+		t.Errorf("expected bans in the order they were set, got %v", masks)
+	}
+
+	if !channel.listFullNoMutex(BanMask, "d!*@*") {
+		t.Error("expected the ban list to be full")
+	}
+	if channel.listFullNoMutex(BanMask, "b!*@*") {
+		t.Error("expected masks already on a full list to be allowed")
+	}
+	if channel.listFullNoMutex(ExceptMask, "d!*@*") {
+		t.Error("expected each list to have its own limit")
+	}
+}
+
+func TestBanEvasion(t *testing.T) {
+	server := newTestServer()
+	channel := NewChannel(server, "#test", false)
+	dan := newTestClient(server, "dan")
+	alice := newTestClient(server, "alice")
+	for _, client := range []*Client{dan, alice} {
+		client.registered = true
+		channel.members.Add(client)
+		client.channels.Add(channel)
+	}
+	channel.lists[BanMask].Add("dan!*@*")
+
+	// both of them end up sharing a vhost, but only dan was banned
+	dan.setVHost("shared.vhost")
+	dan.nick = "notdan"
+	dan.updateNickMask()
+	alice.setVHost("shared.vhost")
+	if !channel.isBannedNoMutex(dan) || channel.CanSpeak(dan) {
+		t.Error("expected changing nick and host not to dodge the ban")
+	}
+	if mask, _, _ := channel.matchingBanNoMutex(dan); mask != "dan!*@*" {
+		t.Errorf("expected the original ban to be named, got %q", mask)
+	}
+	if channel.isBannedNoMutex(alice) {
+		t.Error("expected clients sharing the new host not to be banned")
+	}
+	if len(channel.lists[BanMask].masks) != 1 {
+		t.Error("expected the ban list to be left alone")
+	}
+
+	channel.lists[BanMask].Remove("dan!*@*")
+	if channel.isBannedNoMutex(dan) {
+		t.Error("expected removing the original ban to unban the client")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"time"
 
+	"encoding/base64"
 	"encoding/json"
 
 	"github.com/tidwall/buntdb"
@@ -25,6 +26,15 @@ const (
 	keyChannelBanlist      = "channel.banlist %s"
 	keyChannelExceptlist   = "channel.exceptlist %s"
 	keyChannelInvitelist   = "channel.invitelist %s"
+	keyChannelRoleplay     = "channel.roleplay %s"
+	keyChannelWordFilter   = "channel.wordfilter %s"
+	keyChannelLastUsed     = "channel.lastused %s"
+	keyChannelExpiry       = "channel.expiry %s"
+	keyChannelExpiryWarned = "channel.expirywarned %s"
+	keyChannelFantasy      = "channel.fantasy %s"
+	keyChannelKeyHash      = "channel.keyhash %s"
+	keyChannelListInfo     = "channel.listinfo %s"
+	keyChannelHistory      = "channel.history %s"
 )
 
 var (
@@ -51,12 +61,33 @@ type RegisteredChannel struct {
 	Exceptlist []string
 	// Invitelist represents the invite exceptions set on the channel.
 	Invitelist []string
+	// ListInfo holds who set the entries of the above lists, when, and when they
+	// expire, by mode character and then mask.
+	ListInfo map[string]map[string]MaskInfo
+	// Roleplay holds the channel's roleplaying settings.
+	Roleplay RoleplaySettings
+	// WordFilter holds the channel's word filter.
+	WordFilter WordFilterSettings
+	// LastUsed is the last time the founder was seen in the channel.
+	LastUsed time.Time
+	// ExpiryWarned is when the founder was warned that the registration is expiring, or
+	// zero if they haven't been.
+	ExpiryWarned time.Time
+	// ExpiryFlagged is true if opers have been told that the registration has expired.
+	ExpiryFlagged bool
+	// Fantasy is the prefix of the channel's fantasy commands, or empty if they're disabled.
+	Fantasy string
+	// KeyHash is the hash of the channel's key (+k), if it has one. Keys aren't stored
+	// in plaintext.
+	KeyHash []byte
 }
 
 // deleteChannelNoMutex deletes a given channel from our store.
 func (server *Server) deleteChannelNoMutex(tx *buntdb.Tx, channelKey string) {
 	tx.Delete(fmt.Sprintf(keyChannelExists, channelKey))
+	tx.Delete(fmt.Sprintf(keyChannelHistory, channelKey))
 	server.registeredChannels[channelKey] = nil
+	server.channelSkeletons.Remove(channelKey)
 }
 
 // loadChannelNoMutex loads a channel from the store.
@@ -83,6 +114,14 @@ func (server *Server) loadChannelNoMutex(tx *buntdb.Tx, channelKey string) *Regi
 	banlistString, _ := tx.Get(fmt.Sprintf(keyChannelBanlist, channelKey))
 	exceptlistString, _ := tx.Get(fmt.Sprintf(keyChannelExceptlist, channelKey))
 	invitelistString, _ := tx.Get(fmt.Sprintf(keyChannelInvitelist, channelKey))
+	listInfoString, _ := tx.Get(fmt.Sprintf(keyChannelListInfo, channelKey))
+	roleplayString, _ := tx.Get(fmt.Sprintf(keyChannelRoleplay, channelKey))
+	wordFilterString, _ := tx.Get(fmt.Sprintf(keyChannelWordFilter, channelKey))
+	lastUsed, _ := tx.Get(fmt.Sprintf(keyChannelLastUsed, channelKey))
+	expiry, _ := tx.Get(fmt.Sprintf(keyChannelExpiry, channelKey))
+	expiryWarned, _ := tx.Get(fmt.Sprintf(keyChannelExpiryWarned, channelKey))
+	fantasy, _ := tx.Get(fmt.Sprintf(keyChannelFantasy, channelKey))
+	keyHashString, _ := tx.Get(fmt.Sprintf(keyChannelKeyHash, channelKey))
 
 	var banlist []string
 	_ = json.Unmarshal([]byte(banlistString), &banlist)
@@ -90,17 +129,42 @@ func (server *Server) loadChannelNoMutex(tx *buntdb.Tx, channelKey string) *Regi
 	_ = json.Unmarshal([]byte(exceptlistString), &exceptlist)
 	var invitelist []string
 	_ = json.Unmarshal([]byte(invitelistString), &invitelist)
+	var listInfo map[string]map[string]MaskInfo
+	_ = json.Unmarshal([]byte(listInfoString), &listInfo)
+	var roleplay RoleplaySettings
+	_ = json.Unmarshal([]byte(roleplayString), &roleplay)
+	var wordFilter WordFilterSettings
+	_ = json.Unmarshal([]byte(wordFilterString), &wordFilter)
+	keyHash, _ := DecodePasswordHash(keyHashString)
+	var lastUsedTime time.Time
+	lastUsedInt, err := strconv.ParseInt(lastUsed, 10, 64)
+	if err == nil {
+		lastUsedTime = time.Unix(lastUsedInt, 0)
+	}
+	var expiryWarnedTime time.Time
+	expiryWarnedInt, err := strconv.ParseInt(expiryWarned, 10, 64)
+	if err == nil {
+		expiryWarnedTime = time.Unix(expiryWarnedInt, 0)
+	}
 
 	chanInfo := RegisteredChannel{
-		Name:         name,
-		RegisteredAt: time.Unix(regTimeInt, 0),
-		Founder:      founder,
-		Topic:        topic,
-		TopicSetBy:   topicSetBy,
-		TopicSetTime: time.Unix(topicSetTimeInt, 0),
-		Banlist:      banlist,
-		Exceptlist:   exceptlist,
-		Invitelist:   invitelist,
+		Name:          name,
+		RegisteredAt:  time.Unix(regTimeInt, 0),
+		Founder:       founder,
+		Topic:         topic,
+		TopicSetBy:    topicSetBy,
+		TopicSetTime:  time.Unix(topicSetTimeInt, 0),
+		Banlist:       banlist,
+		Exceptlist:    exceptlist,
+		Invitelist:    invitelist,
+		ListInfo:      listInfo,
+		Roleplay:      roleplay,
+		WordFilter:    wordFilter,
+		LastUsed:      lastUsedTime,
+		ExpiryWarned:  expiryWarnedTime,
+		ExpiryFlagged: expiry == "flagged",
+		Fantasy:       fantasy,
+		KeyHash:       keyHash,
 	}
 	server.registeredChannels[channelKey] = &chanInfo
 
@@ -123,6 +187,42 @@ func (server *Server) saveChannelNoMutex(tx *buntdb.Tx, channelKey string, chann
 	tx.Set(fmt.Sprintf(keyChannelExceptlist, channelKey), string(exceptlistString), nil)
 	invitelistString, _ := json.Marshal(channelInfo.Invitelist)
 	tx.Set(fmt.Sprintf(keyChannelInvitelist, channelKey), string(invitelistString), nil)
+	if len(channelInfo.ListInfo) == 0 {
+		tx.Delete(fmt.Sprintf(keyChannelListInfo, channelKey))
+	} else {
+		listInfoString, _ := json.Marshal(channelInfo.ListInfo)
+		tx.Set(fmt.Sprintf(keyChannelListInfo, channelKey), string(listInfoString), nil)
+	}
+	roleplayString, _ := json.Marshal(channelInfo.Roleplay)
+	tx.Set(fmt.Sprintf(keyChannelRoleplay, channelKey), string(roleplayString), nil)
+	wordFilterString, _ := json.Marshal(channelInfo.WordFilter)
+	tx.Set(fmt.Sprintf(keyChannelWordFilter, channelKey), string(wordFilterString), nil)
+	if channelInfo.LastUsed.IsZero() {
+		tx.Delete(fmt.Sprintf(keyChannelLastUsed, channelKey))
+	} else {
+		tx.Set(fmt.Sprintf(keyChannelLastUsed, channelKey), strconv.FormatInt(channelInfo.LastUsed.Unix(), 10), nil)
+	}
+	if channelInfo.ExpiryFlagged {
+		tx.Set(fmt.Sprintf(keyChannelExpiry, channelKey), "flagged", nil)
+	} else {
+		tx.Delete(fmt.Sprintf(keyChannelExpiry, channelKey))
+	}
+	if channelInfo.ExpiryWarned.IsZero() {
+		tx.Delete(fmt.Sprintf(keyChannelExpiryWarned, channelKey))
+	} else {
+		tx.Set(fmt.Sprintf(keyChannelExpiryWarned, channelKey), strconv.FormatInt(channelInfo.ExpiryWarned.Unix(), 10), nil)
+	}
+	if channelInfo.KeyHash == nil {
+		tx.Delete(fmt.Sprintf(keyChannelKeyHash, channelKey))
+	} else {
+		tx.Set(fmt.Sprintf(keyChannelKeyHash, channelKey), base64.StdEncoding.EncodeToString(channelInfo.KeyHash), nil)
+	}
+	if channelInfo.Fantasy == "" {
+		tx.Delete(fmt.Sprintf(keyChannelFantasy, channelKey))
+	} else {
+		tx.Set(fmt.Sprintf(keyChannelFantasy, channelKey), channelInfo.Fantasy, nil)
+	}
 
 	server.registeredChannels[channelKey] = &channelInfo
+	server.channelSkeletons.Add(channelKey)
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
 	"github.com/oragono/oragono/irc/sno"
 	"github.com/tidwall/buntdb"
 )
@@ -37,13 +38,12 @@ func (server *Server) chanservReceivePrivmsg(client *Client, message string) {
 		}
 	}
 	if len(params) < 1 {
-		client.ChanServNotice("You need to run a command")
-		//TODO(dan): dump CS help here
+		client.serviceHelp("chanserv", params, client.ChanServNotice)
 		return
 	}
 
 	command := strings.ToLower(params[0])
-	server.logger.Debug("chanserv", fmt.Sprintf("Client %s ran command %s", client.nick, command))
+	server.logger.LogFields(logger.LogDebug, "chanserv", client.logFields, fmt.Sprintf("Client %s ran command %s", client.nick, command))
 
 	if command == "register" {
 		if len(params) < 2 {
@@ -51,7 +51,7 @@ func (server *Server) chanservReceivePrivmsg(client *Client, message string) {
 			return
 		}
 
-		if !server.channelRegistrationEnabled {
+		if !server.ChannelRegistrationEnabled() {
 			client.ChanServNotice("Channel registration is not enabled")
 			return
 		}
@@ -97,12 +97,15 @@ func (server *Server) chanservReceivePrivmsg(client *Client, message string) {
 				Topic:        channelInfo.topic,
 				TopicSetBy:   channelInfo.topicSetBy,
 				TopicSetTime: channelInfo.topicSetTime,
+				Roleplay:     channelInfo.RoleplaySettings(),
+				WordFilter:   channelInfo.WordFilterSettings(),
+				KeyHash:      server.hashChannelKey(channelInfo.key),
 			}
 			server.saveChannelNoMutex(tx, channelKey, chanRegInfo)
 
 			client.ChanServNotice(fmt.Sprintf("Channel %s successfully registered", channelName))
 
-			server.logger.Info("chanserv", fmt.Sprintf("Client %s registered channel %s", client.nick, channelName))
+			server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s registered channel %s", client.nick, channelName))
 			server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Channel registered $c[grey][$r%s$c[grey]] by $c[grey][$r%s$c[grey]]"), channelName, client.nickMaskString))
 
 			channelInfo.membersMutex.Lock()
@@ -121,7 +124,192 @@ func (server *Server) chanservReceivePrivmsg(client *Client, message string) {
 
 			return nil
 		})
+	} else if command == "help" {
+		client.serviceHelp("chanserv", params, client.ChanServNotice)
+	} else if command == "info" {
+		server.chanservInfo(client, params[1:])
+	} else if command == "set" {
+		server.chanservSet(client, params[1:])
+	} else if command == "ban" {
+		server.chanservBan(client, params[1:])
 	} else {
-		client.ChanServNotice("Sorry, I don't know that command")
+		client.ChanServNotice(client.t("Sorry, I don't know that command. Use /CS HELP for a list of commands"))
 	}
 }
+
+// chanservInfo handles CS INFO <channel>, which shows details about the given registered
+// channel. Its settings and lists are only shown to the founder, chanops and opers.
+func (server *Server) chanservInfo(client *Client, params []string) {
+	if len(params) < 1 {
+		client.ChanServNotice(client.t("Syntax: INFO <channel>"))
+		return
+	}
+
+	channelKey, err := CasefoldChannel(params[0])
+	if err != nil {
+		client.ChanServNotice(client.t("Channel name is not valid"))
+		return
+	}
+	var info RegisteredChannel
+	var registered bool
+	server.registeredChannelsMutex.Lock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channelKey)
+		if chanInfo != nil {
+			info = *chanInfo
+			registered = true
+		}
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+	if !registered {
+		client.ChanServNotice(fmt.Sprintf(client.t("Channel %s is not registered"), params[0]))
+		return
+	}
+
+	channel := server.channels.Get(channelKey)
+	authorized := client.HasMode(Operator) || (channel != nil && channel.ClientIsAtLeast(client, ChannelOperator))
+	if client.account != &NoAccount {
+		founderKey, _ := CasefoldName(info.Founder)
+		accountKey, _ := CasefoldName(client.account.Name)
+		authorized = authorized || accountKey == founderKey
+	}
+
+	lines := []serviceInfoLine{
+		{client.t("Channel"), info.Name},
+		{client.t("Registered"), serviceInfoTime(info.RegisteredAt)},
+		{client.t("Founder"), info.Founder},
+		{client.t("Last used"), serviceInfoTime(info.LastUsed)},
+	}
+	// the topic of a secret channel is secret too
+	if authorized || channel == nil || !channel.flags[Secret] {
+		lines = append(lines, serviceInfoLine{client.t("Topic"), info.Topic})
+	}
+	if authorized {
+		sources := "*"
+		if 0 < len(info.Roleplay.Sources) {
+			sources = strings.Join(info.Roleplay.Sources, ",")
+		}
+		lines = append(lines, serviceInfoLine{"RP-SOURCES", sources})
+		lines = append(lines, serviceInfoLine{"RP-NPC", roleplayLevelName(info.Roleplay.NPCLevel)})
+		history := client.t("off")
+		if info.Roleplay.History {
+			history = client.t("on")
+		}
+		lines = append(lines, serviceInfoLine{"RP-HISTORY", history})
+		lines = append(lines, serviceInfoLine{"FANTASY", info.Fantasy})
+		if 0 < len(info.WordFilter.Patterns) {
+			lines = append(lines, serviceInfoLine{"FILTER", fmt.Sprintf(client.t("%[1]d words (%[2]s)"), len(info.WordFilter.Patterns), wordFilterActionName(info.WordFilter))})
+		}
+
+		if info.ExpiryFlagged {
+			lines = append(lines, serviceInfoLine{client.t("Expiry"), client.t("expired, waiting for an oper")})
+		} else if !info.ExpiryWarned.IsZero() {
+			lines = append(lines, serviceInfoLine{client.t("Expiry"), client.t("founder has been warned")})
+		}
+
+		lines = append(lines, serviceInfoList(client.t("Bans"), info.Banlist)...)
+		lines = append(lines, serviceInfoList(client.t("Exceptions"), info.Exceptlist)...)
+		lines = append(lines, serviceInfoList(client.t("Invite exceptions"), info.Invitelist)...)
+	}
+
+	header := fmt.Sprintf(client.t("Information on channel %s:"), info.Name)
+	for _, line := range formatServiceInfo(header, lines, client.t("End of info")) {
+		client.ChanServNotice(line)
+	}
+}
+
+// chanservSet handles CS SET <channel> <setting> [value], which shows or changes the
+// given channel setting.
+func (server *Server) chanservSet(client *Client, params []string) {
+	if len(params) < 2 {
+		client.ChanServNotice(client.t("Syntax: SET <channel> <setting> [value]"))
+		return
+	}
+
+	channelKey, err := CasefoldChannel(params[0])
+	channel := server.channels.Get(channelKey)
+	if err != nil || channel == nil {
+		client.ChanServNotice(client.t("No such channel"))
+		return
+	}
+	if !channel.ClientIsAtLeast(client, ChannelOperator) {
+		client.ChanServNotice(client.t("You must be an oper on the channel to change its settings"))
+		return
+	}
+	if strings.ToLower(params[1]) == "filter" {
+		server.chanservSetFilter(client, channel, params[2:])
+		return
+	}
+	if strings.ToLower(params[1]) == "fantasy" {
+		server.chanservSetFantasy(client, channel, params[2:])
+		return
+	}
+	if strings.ToLower(params[1]) == "key" {
+		server.chanservSetKey(client, channel, params[2:])
+		return
+	}
+
+	settings := channel.RoleplaySettings()
+	setting := strings.ToLower(params[1])
+	if len(params) < 3 {
+		switch setting {
+		case "rp-sources":
+			if len(settings.Sources) == 0 {
+				client.ChanServNotice(fmt.Sprintf(client.t("NPC names allowed in %s: *"), channel.name))
+			} else {
+				client.ChanServNotice(fmt.Sprintf(client.t("NPC names allowed in %[1]s: %[2]s"), channel.name, strings.Join(settings.Sources, ",")))
+			}
+		case "rp-npc":
+			client.ChanServNotice(fmt.Sprintf(client.t("NPC can be used in %[1]s by: %[2]s"), channel.name, roleplayLevelName(settings.NPCLevel)))
+		case "rp-history":
+			if settings.History {
+				client.ChanServNotice(fmt.Sprintf(client.t("Roleplay messages in %s are stored in its history"), channel.name))
+			} else {
+				client.ChanServNotice(fmt.Sprintf(client.t("Roleplay messages in %s are not stored in its history"), channel.name))
+			}
+		default:
+			client.ChanServNotice(client.t("Unknown setting. Settings are RP-SOURCES, RP-NPC, RP-HISTORY, FILTER, FANTASY and KEY"))
+		}
+		return
+	}
+
+	value := strings.Join(params[2:], " ")
+	switch setting {
+	case "rp-sources":
+		settings.Sources = parseRoleplaySources(value)
+	case "rp-npc":
+		level, exists := roleplayLevels[strings.ToLower(value)]
+		if !exists {
+			client.ChanServNotice(client.t("Syntax: SET <channel> RP-NPC <all|voice|halfop|op|admin|founder>"))
+			return
+		}
+		settings.NPCLevel = level
+	case "rp-history":
+		settings.History, err = parseConfigBool(value)
+		if err != nil {
+			client.ChanServNotice(client.t("Syntax: SET <channel> RP-HISTORY <ON|OFF>"))
+			return
+		}
+	default:
+		client.ChanServNotice(client.t("Unknown setting. Settings are RP-SOURCES, RP-NPC, RP-HISTORY, FILTER, FANTASY and KEY"))
+		return
+	}
+	channel.SetRoleplaySettings(settings)
+
+	// save the settings for registered channels
+	server.registeredChannelsMutex.Lock()
+	defer server.registeredChannelsMutex.Unlock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channelKey)
+		if chanInfo == nil {
+			return nil
+		}
+		chanInfo.Roleplay = settings
+		server.saveChannelNoMutex(tx, channelKey, *chanInfo)
+		return nil
+	})
+
+	server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s set %s on channel %s to %s", client.nick, strings.ToUpper(setting), channel.name, value))
+	client.ChanServNotice(fmt.Sprintf(client.t("Set %[1]s on %[2]s to %[3]s"), strings.ToUpper(setting), channel.name, value))
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+)
+
+// chathistoryTimeFormat is how timestamps are written in CHATHISTORY replies.
+const chathistoryTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// parseChathistoryTimestamp parses a CHATHISTORY selector like timestamp=2017-01-02T03:04:05.000Z.
+func parseChathistoryTimestamp(selector string) (time.Time, bool) {
+	if !strings.HasPrefix(selector, "timestamp=") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(selector, "timestamp="))
+	return t, err == nil
+}
+
+// chathistorySelector is a point in a target's history, given either as the msgid of a
+// message or as a timestamp. The `*` selector (only used with LATEST) is the zero value.
+type chathistorySelector struct {
+	msgid string
+	time  time.Time
+}
+
+// parseChathistorySelector parses a msgid=..., timestamp=... or * selector.
+func parseChathistorySelector(selector string, allowStar bool) (chathistorySelector, bool) {
+	if selector == "*" {
+		return chathistorySelector{}, allowStar
+	}
+	if strings.HasPrefix(selector, "msgid=") {
+		msgid := strings.TrimPrefix(selector, "msgid=")
+		return chathistorySelector{msgid: msgid}, msgid != ""
+	}
+	t, ok := parseChathistoryTimestamp(selector)
+	return chathistorySelector{time: t}, ok
+}
+
+// resolve returns the time the selector points to in the given items. It returns false
+// if the selector is a msgid that isn't in them.
+func (selector chathistorySelector) resolve(items []history.Item) (time.Time, bool) {
+	if selector.msgid == "" {
+		return selector.time, true
+	}
+	for _, item := range items {
+		if item.Msgid == selector.msgid {
+			return item.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// itemsBetween returns the given items sent after `after` and before `before`. Zero
+// times mean that side is unbounded.
+func itemsBetween(items []history.Item, after, before time.Time) []history.Item {
+	var results []history.Item
+	for _, item := range items {
+		if !after.IsZero() && !item.Time.After(after) {
+			continue
+		}
+		if !before.IsZero() && !item.Time.Before(before) {
+			continue
+		}
+		results = append(results, item)
+	}
+	return results
+}
+
+// firstItems and lastItems return up to limit of the oldest or newest of the given items.
+func firstItems(items []history.Item, limit int) []history.Item {
+	if limit < len(items) {
+		return items[:limit]
+	}
+	return items
+}
+
+func lastItems(items []history.Item, limit int) []history.Item {
+	if limit < len(items) {
+		return items[len(items)-limit:]
+	}
+	return items
+}
+
+// selectChathistory returns up to limit of the given items (which are oldest first) that
+// a CHATHISTORY subcommand asks for, oldest first. BEFORE and LATEST count back from
+// their selector, AFTER counts forwards, and BETWEEN counts from whichever end it's given
+// first.
+func selectChathistory(subcommand string, items []history.Item, first, second time.Time, limit int) []history.Item {
+	switch subcommand {
+	case "BEFORE":
+		return lastItems(itemsBetween(items, time.Time{}, first), limit)
+	case "AFTER":
+		return firstItems(itemsBetween(items, first, time.Time{}), limit)
+	case "LATEST":
+		return lastItems(itemsBetween(items, first, time.Time{}), limit)
+	case "BETWEEN":
+		if second.Before(first) {
+			return lastItems(itemsBetween(items, second, first), limit)
+		}
+		return firstItems(itemsBetween(items, first, second), limit)
+	}
+	return nil
+}
+
+// historyNick returns the nick of the client who sent the given history item.
+func historyNick(item history.Item) string {
+	return strings.SplitN(item.Nick, "!", 2)[0]
+}
+
+// directHistory returns the direct messages between the client and the given nick that
+// we have, oldest first. Clients logged into accounts get their account's conversation
+// with the target's account, so it's still there after they reconnect. Others only get
+// the messages sent since they connected.
+func (server *Server) directHistory(client *Client, nick string) []history.Item {
+	targetKey, err := CasefoldName(nick)
+	if err != nil {
+		return nil
+	}
+	target := server.clients.Get(nick)
+
+	if client.account != &NoAccount && (target == nil || target.account != &NoAccount) {
+		accountKey, err := CasefoldName(client.account.Name)
+		if err != nil {
+			return nil
+		}
+		otherKey := targetKey
+		if target != nil {
+			otherKey, err = CasefoldName(target.account.Name)
+			if err != nil {
+				return nil
+			}
+		}
+		return server.conversations.Get(accountKey, otherKey, time.Time{}, time.Time{})
+	}
+
+	var results []history.Item
+	for _, item := range client.history.Latest(0) {
+		sender, _ := CasefoldName(historyNick(item))
+		recipient, _ := CasefoldName(item.Target)
+		if sender == targetKey || recipient == targetKey {
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
+// sendChathistory replays the given history items to the client in a chathistory
+// batch, with each message's original time and msgid.
+func (server *Server) sendChathistory(client *Client, target string, items []history.Item) {
+	var batchID string
+	if client.capabilities[Batch] {
+		batchID = server.generateMessageID()
+		client.Send(nil, server.name, "BATCH", "+"+batchID, "chathistory", target)
+	}
+
+	_, maxlenRest := client.maxlens()
+	for _, item := range items {
+		var command string
+		switch item.Type {
+		case history.Privmsg:
+			command = "PRIVMSG"
+		case history.Notice:
+			command = "NOTICE"
+		case history.Tagmsg:
+			command = "TAGMSG"
+			if !client.capabilities[MessageTags] {
+				continue
+			}
+		default:
+			continue
+		}
+
+		tags := make(map[string]ircmsg.TagValue)
+		if batchID != "" {
+			tags["batch"] = ircmsg.MakeTagValue(batchID)
+		}
+		if client.capabilities[ServerTime] {
+			tags["time"] = ircmsg.MakeTagValue(item.Time.UTC().Format(chathistoryTimeFormat))
+		}
+		if client.capabilities[MessageIDs] && item.Msgid != "" {
+			tags["draft/msgid"] = ircmsg.MakeTagValue(item.Msgid)
+		}
+		if client.capabilities[AccountTag] && item.AccountName != "" && item.AccountName != NoAccount.Name {
+			tags["account"] = ircmsg.MakeTagValue(item.AccountName)
+		}
+		if client.capabilities[MessageTags] {
+			for name, value := range item.Tags {
+				tags[name] = ircmsg.MakeTagValue(value)
+			}
+		}
+
+		// direct messages we sent are shown as sent to their recipient, and the ones we
+		// received as sent to our current nick
+		messageTarget := item.MinPrefix + target
+		if item.Target != "" {
+			messageTarget = client.nick
+			sentByUs := client.account != &NoAccount && item.AccountName == client.account.Name
+			if sentByUs || historyNick(item) == client.nick {
+				messageTarget = item.Target
+			}
+		}
+
+		if item.Type == history.Tagmsg {
+			client.Send(&tags, item.Nick, command, messageTarget)
+			continue
+		}
+		// leave room for the prefix, command, target and the final space and CRLF
+		width := maxlenRest - len(fmt.Sprintf(":%s %s %s : \r\n", item.Nick, command, messageTarget))
+		message := server.splitMessage(item.Message)
+		for _, line := range message.Lines(width) {
+			// Send adds to the tags, so each line needs its own
+			lineTags := make(map[string]ircmsg.TagValue, len(tags))
+			for name, value := range tags {
+				lineTags[name] = value
+			}
+			client.Send(&lineTags, item.Nick, command, messageTarget, line)
+		}
+	}
+
+	if batchID != "" {
+		client.Send(nil, server.name, "BATCH", "-"+batchID)
+	}
+}
+
+// CHATHISTORY BEFORE <target> <msgid=...|timestamp=...> <limit>
+// CHATHISTORY AFTER <target> <msgid=...|timestamp=...> <limit>
+// CHATHISTORY LATEST <target> <*|msgid=...|timestamp=...> <limit>
+// CHATHISTORY BETWEEN <target> <msgid=...|timestamp=...> <msgid=...|timestamp=...> <limit>
+// CHATHISTORY TARGETS <timestamp=...> <timestamp=...> <limit>
+func chathistoryHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	subcommand := strings.ToUpper(msg.Params[0])
+	switch subcommand {
+	case "TARGETS":
+		return chathistoryTargets(server, client, msg)
+	case "BEFORE", "AFTER", "LATEST", "BETWEEN":
+	default:
+		client.Fail("CHATHISTORY", "INVALID_PARAMS", client.t("Unknown subcommand"), msg.Params[0])
+		return false
+	}
+
+	paramCount := 4
+	if subcommand == "BETWEEN" {
+		paramCount = 5
+	}
+	if len(msg.Params) < paramCount {
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+
+	target := msg.Params[1]
+	firstSelector, firstOk := parseChathistorySelector(msg.Params[2], subcommand == "LATEST")
+	secondSelector, secondOk := firstSelector, true
+	if subcommand == "BETWEEN" {
+		secondSelector, secondOk = parseChathistorySelector(msg.Params[3], false)
+	}
+	limit, err := strconv.Atoi(msg.Params[paramCount-1])
+	if !firstOk || !secondOk || err != nil || limit < 1 {
+		client.Fail("CHATHISTORY", "INVALID_PARAMS", client.t("Invalid parameters"), subcommand)
+		return false
+	}
+	if server.Config().History.ChathistoryMax < limit {
+		limit = server.Config().History.ChathistoryMax
+	}
+
+	var items []history.Item
+	if strings.HasPrefix(target, "#") {
+		channel := server.channels.Get(target)
+		// only members can read a channel's history
+		if channel == nil || !channel.HasClient(client) {
+			client.Fail("CHATHISTORY", "INVALID_TARGET", client.t("You're not on that channel"), subcommand, target)
+			return false
+		}
+		target = channel.name
+		items = channel.VisibleHistory(client, channel.history.Latest(0))
+	} else {
+		items = server.directHistory(client, target)
+	}
+
+	first, firstOk := firstSelector.resolve(items)
+	second, secondOk := secondSelector.resolve(items)
+	if !firstOk || !secondOk {
+		client.Fail("CHATHISTORY", "INVALID_MSGREFID", client.t("No message with that msgid"), subcommand, target)
+		return false
+	}
+
+	server.sendChathistory(client, target, selectChathistory(subcommand, items, first, second, limit))
+	return false
+}
+
+// chathistoryTargets handles CHATHISTORY TARGETS, which lists the accounts the client
+// has had direct message conversations with.
+func chathistoryTargets(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if len(msg.Params) < 4 {
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+
+	// the timestamps can be given either way around
+	start, startOk := parseChathistoryTimestamp(msg.Params[1])
+	end, endOk := parseChathistoryTimestamp(msg.Params[2])
+	limit, err := strconv.Atoi(msg.Params[3])
+	if !startOk || !endOk || err != nil || limit < 1 {
+		client.Fail("CHATHISTORY", "INVALID_PARAMS", client.t("Invalid parameters"), "TARGETS")
+		return false
+	}
+	if end.Before(start) {
+		start, end = end, start
+	}
+
+	var targets []ConversationTarget
+	if client.account != &NoAccount {
+		accountKey, err := CasefoldName(client.account.Name)
+		if err == nil {
+			targets = server.conversations.Targets(accountKey, start, end, limit)
+		}
+	}
+
+	var batchID string
+	if client.capabilities[Batch] {
+		batchID = server.generateMessageID()
+		client.Send(nil, server.name, "BATCH", "+"+batchID, "draft/chathistory-targets")
+	}
+	for _, target := range targets {
+		// Send adds to the tags, so each line needs its own
+		var tags *map[string]ircmsg.TagValue
+		if batchID != "" {
+			tags = ircmsg.MakeTags("batch", batchID)
+		}
+		client.Send(tags, server.name, "CHATHISTORY", "TARGETS", target.Name, target.Latest.UTC().Format(chathistoryTimeFormat))
+	}
+	if batchID != "" {
+		client.Send(nil, server.name, "BATCH", "-"+batchID)
+	}
+	return false
+}
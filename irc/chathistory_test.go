@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+)
+
+func TestParseChathistorySelector(t *testing.T) {
+	selector, ok := parseChathistorySelector("msgid=abc", false)
+	if !ok || selector.msgid != "abc" {
+		t.Errorf("expected msgid selector, got %v", selector)
+	}
+	selector, ok = parseChathistorySelector("timestamp=2018-01-02T03:04:05.000Z", false)
+	if !ok || !selector.time.Equal(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("expected timestamp selector, got %v", selector)
+	}
+	for _, bad := range []string{"msgid=", "timestamp=yesterday", "abc", "*"} {
+		if _, ok := parseChathistorySelector(bad, false); ok {
+			t.Errorf("expected selector %s to be invalid", bad)
+		}
+	}
+	if _, ok := parseChathistorySelector("*", true); !ok {
+		t.Error("expected * to be allowed for LATEST")
+	}
+}
+
+func TestSelectChathistory(t *testing.T) {
+	start := time.Now()
+	var items []history.Item
+	for i, msgid := range []string{"a", "b", "c", "d", "e"} {
+		items = append(items, history.Item{
+			Msgid: msgid,
+			Time:  start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	at := func(msgid string) time.Time {
+		t, _ := chathistorySelector{msgid: msgid}.resolve(items)
+		return t
+	}
+	msgids := func(items []history.Item) string {
+		var result []string
+		for _, item := range items {
+			result = append(result, item.Msgid)
+		}
+		return strings.Join(result, "")
+	}
+
+	var tests = []struct {
+		subcommand string
+		first      time.Time
+		second     time.Time
+		limit      int
+		expected   string
+	}{
+		{"BEFORE", at("d"), time.Time{}, 2, "bc"},
+		{"BEFORE", at("a"), time.Time{}, 2, ""},
+		{"AFTER", at("b"), time.Time{}, 2, "cd"},
+		{"AFTER", at("d"), time.Time{}, 5, "e"},
+		{"LATEST", time.Time{}, time.Time{}, 3, "cde"},
+		{"LATEST", at("b"), time.Time{}, 5, "cde"},
+		{"BETWEEN", at("a"), at("e"), 2, "bc"},
+		{"BETWEEN", at("e"), at("a"), 2, "cd"},
+		{"BETWEEN", at("b"), at("c"), 5, ""},
+	}
+	for _, test := range tests {
+		result := msgids(selectChathistory(test.subcommand, items, test.first, test.second, test.limit))
+		if result != test.expected {
+			t.Errorf("%s with limit %d: expected [%s], got [%s]", test.subcommand, test.limit, test.expected, result)
+		}
+	}
+
+	if _, ok := (chathistorySelector{msgid: "z"}).resolve(items); ok {
+		t.Error("expected unknown msgids not to resolve")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"net"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,7 +19,9 @@ import (
 
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/goshuirc/irc-go/ircmsg"
-	ident "github.com/oragono/go-ident"
+	"github.com/oragono/oragono/irc/geoip"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/logger"
 	"github.com/oragono/oragono/irc/sno"
 )
 
@@ -40,64 +43,99 @@ var (
 
 // Client is an IRC client.
 type Client struct {
+	accepted           map[string]bool // casefolded account names, under acceptedMutex
+	acceptedBy         map[*Client]bool
+	acceptedClients    map[*Client]bool // these three are under server.acceptedClientsMutex
+	acceptedGone       bool
+	acceptedMutex      sync.RWMutex
 	account            *ClientAccount
+	actions            []func()
+	actionsMutex       sync.Mutex
+	actionsReady       chan struct{}
 	atime              time.Time
 	authorized         bool
+	autoAwayTimer      *time.Timer
 	awayMessage        string
+	banEvasions        map[string]string // casefolded channel names to bans, under banEvasionsMutex
+	banEvasionsMutex   sync.Mutex
 	capabilities       CapabilitySet
 	capState           CapState
 	capVersion         CapVersion
+	callerIDNotified   time.Time
 	certfp             string
 	channels           ChannelSet
+	ctcpFlood          ctcpFloodState
 	class              *OperClass
 	ctime              time.Time
 	destroyMutex       sync.Mutex
 	exitedSnomaskSent  bool
 	flags              map[Mode]bool
+	flagsMutex         sync.RWMutex
 	hasQuit            bool
 	hops               int
+	history            *history.Buffer
 	hostname           string
 	idleTimer          *time.Timer
+	invites            *InviteList
+	isAutoAway         bool
 	isDestroyed        bool
 	isQuitting         bool
+	languages          []string
+	languagesMutex     sync.RWMutex
+	listener           string
+	location           geoip.Record
 	monitoring         map[string]bool
 	nick               string
 	nickCasefolded     string
+	nickChanges        eventWindow
 	nickMaskCasefolded string
 	nickMaskString     string // cache for nickmask string since it's used with lots of replies
+	opActions          map[string]*eventWindow
 	operName           string
+	quitMessage        string
 	quitMessageSent    bool
 	quitMutex          sync.Mutex
 	quitTimer          *time.Timer
 	rawHostname        string
 	realname           string
 	registered         bool
+	registrationTimer  *time.Timer
 	saslInProgress     bool
 	saslMechanism      string
 	saslValue          string
 	server             *Server
 	socket             *Socket
 	timerMutex         sync.Mutex
+	unregisteredOnce   sync.Once
 	username           string
 	vhost              string
 	whoisLine          string
 }
 
 // NewClient returns a client with all the appropriate info setup.
-func NewClient(server *Server, conn net.Conn, isTLS bool) *Client {
+func NewClient(server *Server, conn net.Conn, isTLS bool, listener string, location geoip.Record) *Client {
 	now := time.Now()
+	config := server.Config()
+	_, clientHistoryLength := config.HistoryLengths()
 	socket := NewSocket(conn, server.MaxSendQBytes)
 	go socket.RunSocketWriter()
 	client := &Client{
+		accepted:       make(map[string]bool),
+		actionsReady:   make(chan struct{}, 1),
 		atime:          now,
-		authorized:     server.password == nil,
+		authorized:     server.connectionPassword(listener) == nil,
 		capabilities:   make(CapabilitySet),
 		capState:       CapNone,
 		capVersion:     Cap301,
 		channels:       make(ChannelSet),
 		ctime:          now,
 		flags:          make(map[Mode]bool),
+		history:        history.NewHistoryBuffer(clientHistoryLength),
+		invites:        NewInviteList(),
+		listener:       listener,
+		location:       location,
 		monitoring:     make(map[string]bool),
+		opActions:      make(map[string]*eventWindow),
 		server:         server,
 		socket:         &socket,
 		account:        &NoAccount,
@@ -106,27 +144,15 @@ func NewClient(server *Server, conn net.Conn, isTLS bool) *Client {
 		nickMaskString: "*", // * is used until actual nick is given
 	}
 	if isTLS {
-		client.flags[TLS] = true
+		client.SetMode(TLS, true)
 
 		// error is not useful to us here anyways so we can ignore it
 		client.certfp, _ = client.socket.CertFP()
 	}
-	if server.checkIdent {
-		_, serverPortString, err := net.SplitHostPort(conn.LocalAddr().String())
-		serverPort, _ := strconv.Atoi(serverPortString)
-		if err != nil {
-			log.Fatal(err)
-		}
-		clientHost, clientPortString, err := net.SplitHostPort(conn.RemoteAddr().String())
-		clientPort, _ := strconv.Atoi(clientPortString)
-		if err != nil {
-			log.Fatal(err)
-		}
-
+	if config.Server.CheckIdent {
 		client.Notice("*** Looking up your username")
-		resp, err := ident.Query(clientHost, serverPort, clientPort, IdentTimeoutSeconds)
+		username, err := server.lookups.Ident(conn)
 		if err == nil {
-			username := resp.Identifier
 			_, err := CasefoldName(username) // ensure it's a valid username
 			if err == nil {
 				client.Notice("*** Found your username")
@@ -139,6 +165,9 @@ func NewClient(server *Server, conn net.Conn, isTLS bool) *Client {
 			client.Notice("*** Could not find your username")
 		}
 	}
+	if config.Server.Unregistered.Timeout != 0 {
+		client.registrationTimer = time.AfterFunc(config.Server.Unregistered.Timeout, client.registrationTimedOut)
+	}
 	client.Touch()
 	go client.run()
 
@@ -170,33 +199,101 @@ func (client *Client) maxlens() (int, int) {
 		maxlenTags = 4096
 	}
 	if client.capabilities[MaxLine] {
-		if client.server.limits.LineLen.Tags > maxlenTags {
-			maxlenTags = client.server.limits.LineLen.Tags
+		if client.server.Limits().LineLen.Tags > maxlenTags {
+			maxlenTags = client.server.Limits().LineLen.Tags
 		}
-		maxlenRest = client.server.limits.LineLen.Rest
+		maxlenRest = client.server.Limits().LineLen.Rest
 	}
 	return maxlenTags, maxlenRest
 }
 
+// logFields returns the details that identify this client in structured logs.
+func (client *Client) logFields() logger.Fields {
+	fields := logger.Fields{
+		"client": client.nick,
+	}
+	if client.account != nil && client.account != &NoAccount {
+		fields["account"] = client.account.Name
+	}
+	if client.operName != "" {
+		fields["oper"] = client.operName
+	}
+	return fields
+}
+
+// runOnClient queues f to run on the client's own goroutine, which is the only one that
+// changes things like their nickmask. It runs once any command they're in the middle of
+// has finished.
+func (client *Client) runOnClient(f func()) {
+	client.actionsMutex.Lock()
+	client.actions = append(client.actions, f)
+	client.actionsMutex.Unlock()
+	select {
+	case client.actionsReady <- struct{}{}:
+	default:
+	}
+}
+
+// runActions runs the functions queued with runOnClient.
+func (client *Client) runActions() {
+	client.actionsMutex.Lock()
+	actions := client.actions
+	client.actions = nil
+	client.actionsMutex.Unlock()
+	for _, f := range actions {
+		f()
+	}
+}
+
+// readLines reads lines from the client's socket and hands them to run, until the socket
+// fails or stop is closed.
+func (client *Client) readLines(lines chan<- string, stop <-chan struct{}) {
+	defer close(lines)
+	for {
+		line, err := client.socket.Read()
+		if err != nil {
+			return
+		}
+		select {
+		case lines <- line:
+		case <-stop:
+			return
+		}
+	}
+}
+
 func (client *Client) run() {
 	var err error
 	var isExiting bool
 	var line string
+	var ok bool
 	var msg ircmsg.IrcMessage
 
 	// Set the hostname for this client
-	client.rawHostname = AddrLookupHostname(client.socket.conn.RemoteAddr())
+	client.rawHostname = client.server.lookups.Hostname(client.socket.conn.RemoteAddr())
+
+	// lines are read on their own goroutine, so that we can also run things other
+	// goroutines hand us while we're waiting for them
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+	go client.readLines(lines, stop)
 
 	for {
-		line, err = client.socket.Read()
-		if err != nil {
+		select {
+		case line, ok = <-lines:
+		case <-client.actionsReady:
+			client.runActions()
+			continue
+		}
+		if !ok {
 			client.Quit("connection closed")
 			break
 		}
 
 		maxlenTags, maxlenRest := client.maxlens()
 
-		client.server.logger.Debug("userinput ", client.nick, "<- ", line)
+		client.server.logger.LogFields(logger.LogDebug, "userinput ", client.logFields, client.nick, "<- ", line)
 
 		msg, err = ircmsg.ParseLineMaxLen(line, maxlenTags, maxlenRest)
 		if err == ircmsg.ErrorLineIsEmpty {
@@ -207,15 +304,24 @@ func (client *Client) run() {
 		}
 
 		cmd, exists := Commands[msg.Command]
+		if !exists {
+			var isAlias, expanded bool
+			msg, isAlias, expanded = client.server.expandAlias(client, msg)
+			if isAlias && !expanded {
+				continue
+			}
+			cmd, exists = Commands[msg.Command]
+		}
 		if !exists {
 			if len(msg.Command) > 0 {
-				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, msg.Command, "Unknown command")
+				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, msg.Command, client.t("Unknown command"))
 			} else {
-				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, "lastcmd", "No command given")
+				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, "lastcmd", client.t("No command given"))
 			}
 			continue
 		}
 
+		client.server.commandStats.Add(msg.Command)
 		isExiting = cmd.Run(client.server, client, msg)
 		if isExiting || client.isQuitting {
 			break
@@ -233,6 +339,7 @@ func (client *Client) run() {
 // Active updates when the client was last 'active' (i.e. the user should be sitting in front of their client).
 func (client *Client) Active() {
 	client.atime = time.Now()
+	client.resetAutoAway()
 }
 
 // Touch marks the client as alive (as it it has a connection to us and we
@@ -276,6 +383,26 @@ func (client *Client) connectionTimeout() {
 	client.isQuitting = true
 }
 
+// registrationTimedOut is called when the client takes too long to register.
+func (client *Client) registrationTimedOut() {
+	client.Quit("Registration timed out")
+	client.isQuitting = true
+}
+
+// releaseUnregistered stops counting this client against its IP's unregistered
+// connection limit, and stops the registration timer.
+func (client *Client) releaseUnregistered() {
+	client.unregisteredOnce.Do(func() {
+		if client.registrationTimer != nil {
+			client.registrationTimer.Stop()
+		}
+		ipaddr := client.IP()
+		if ipaddr != nil {
+			client.server.unregisteredLimits.Remove(ipaddr)
+		}
+	})
+}
+
 //
 // server goroutine
 //
@@ -286,6 +413,7 @@ func (client *Client) Register() {
 		return
 	}
 	client.registered = true
+	client.releaseUnregistered()
 	client.Touch()
 
 	client.updateNickMask()
@@ -317,6 +445,22 @@ func (client *Client) HasUsername() bool {
 	return client.username != "" && client.username != "*"
 }
 
+// removeOper takes away the client's oper class, snomasks and vhost once they don't have
+// an oper mode any more, like when it's removed with MODE or SAMODE.
+func (client *Client) removeOper() {
+	if client.HasMode(Operator) || client.HasMode(LocalOperator) {
+		return
+	}
+	client.class = nil
+	client.operName = ""
+	client.whoisLine = ""
+	client.server.snomasks.RemoveClient(client)
+	client.server.currentOpersMutex.Lock()
+	delete(client.server.currentOpers, client)
+	client.server.currentOpersMutex.Unlock()
+	client.updateVHost()
+}
+
 // HasCapabs returns true if client has the given (role) capabilities.
 func (client *Client) HasCapabs(capabs ...string) bool {
 	if client.class == nil {
@@ -332,15 +476,47 @@ func (client *Client) HasCapabs(capabs ...string) bool {
 	return true
 }
 
-// ModeString returns the mode string for this client.
-func (client *Client) ModeString() (str string) {
-	str = "+"
+// HasMode returns true if the client has the given user mode set.
+func (client *Client) HasMode(mode Mode) bool {
+	client.flagsMutex.RLock()
+	defer client.flagsMutex.RUnlock()
+	return client.flags[mode]
+}
+
+// SetMode sets or unsets the given user mode, returning true if that changed anything.
+func (client *Client) SetMode(mode Mode, on bool) bool {
+	client.flagsMutex.Lock()
+	defer client.flagsMutex.Unlock()
+	if client.flags[mode] == on {
+		return false
+	}
+	if on {
+		client.flags[mode] = true
+	} else {
+		delete(client.flags, mode)
+	}
+	return true
+}
+
+// Away returns whether the client is away, and their away message. The message can be
+// set from other goroutines (such as by auto-away), so it's kept under the modes lock.
+func (client *Client) Away() (bool, string) {
+	client.flagsMutex.RLock()
+	defer client.flagsMutex.RUnlock()
+	return client.flags[Away], client.awayMessage
+}
 
+// ModeString returns the mode string for this client.
+func (client *Client) ModeString() string {
+	var flags []string
+	client.flagsMutex.RLock()
 	for flag := range client.flags {
-		str += flag.String()
+		flags = append(flags, flag.String())
 	}
+	client.flagsMutex.RUnlock()
+	sort.Strings(flags)
 
-	return
+	return "+" + strings.Join(flags, "")
 }
 
 // Friends refers to clients that share a channel with this client.
@@ -387,6 +563,10 @@ func (client *Client) updateNick() {
 
 // updateNickMask updates the casefolded nickname and nickmask.
 func (client *Client) updateNickMask() {
+	// bans that matched the old nickmask still apply to the new one
+	bans := client.matchingBans()
+	defer client.noteBanEvasions(bans)
+
 	client.updateNick()
 
 	if len(client.vhost) > 0 {
@@ -451,13 +631,13 @@ func (client *Client) ChangeNickname(nickname string) error {
 	origNickMask := client.nickMaskString
 	err := client.server.clients.Replace(client.nick, nickname, client)
 	if err == nil {
-		client.server.logger.Debug("nick", fmt.Sprintf("%s changed nickname to %s", client.nick, nickname))
+		client.server.logger.LogFields(logger.LogDebug, "nick", client.logFields, fmt.Sprintf("%s changed nickname to %s", client.nick, nickname))
 		client.server.snomasks.Send(sno.LocalNicks, fmt.Sprintf(ircfmt.Unescape("$%s$r changed nickname to %s"), client.nick, nickname))
 		client.server.whoWas.Append(client)
 		client.nick = nickname
 		client.updateNickMask()
 		for friend := range client.Friends() {
-			friend.Send(nil, origNickMask, "NICK", nickname)
+			friend.sendFromClientWithPrefix("", client, origNickMask, nil, "NICK", nickname)
 		}
 	}
 	return err
@@ -476,6 +656,18 @@ func (client *Client) Quit(message string) {
 
 		client.socket.SetFinalData(quitLine + errorLine)
 		client.quitMessageSent = true
+		client.quitMessage = message
+	}
+}
+
+// QuitWithFinalData is like Quit, but sends the client the given lines instead of the
+// usual QUIT and ERROR.
+func (client *Client) QuitWithFinalData(data string) {
+	client.quitMutex.Lock()
+	defer client.quitMutex.Unlock()
+	if !client.quitMessageSent {
+		client.socket.SetFinalData(data)
+		client.quitMessageSent = true
 	}
 }
 
@@ -487,7 +679,7 @@ func (client *Client) destroy() {
 		return
 	}
 
-	client.server.logger.Debug("quit", fmt.Sprintf("%s is no longer on the server", client.nick))
+	client.server.logger.LogFields(logger.LogDebug, "quit", client.logFields, fmt.Sprintf("%s is no longer on the server", client.nick))
 
 	// send quit/error message to client if they haven't been sent already
 	client.Quit("Connection closed")
@@ -505,12 +697,12 @@ func (client *Client) destroy() {
 		client.server.connectionLimits.RemoveClient(ipaddr)
 		client.server.connectionLimitsMutex.Unlock()
 	}
+	client.releaseUnregistered()
 
 	// remove from opers list
-	_, exists := client.server.currentOpers[client]
-	if exists {
-		delete(client.server.currentOpers, client)
-	}
+	client.server.currentOpersMutex.Lock()
+	delete(client.server.currentOpers, client)
+	client.server.currentOpersMutex.Unlock()
 
 	// alert monitors
 	for _, mClient := range client.server.monitoring[client.nickCasefolded] {
@@ -529,6 +721,8 @@ func (client *Client) destroy() {
 
 	// clean up server
 	client.server.clients.Remove(client)
+	client.removeFromAccount()
+	client.forgetAccepted()
 
 	// clean up self
 	if client.idleTimer != nil {
@@ -537,34 +731,55 @@ func (client *Client) destroy() {
 	if client.quitTimer != nil {
 		client.quitTimer.Stop()
 	}
+	if client.autoAwayTimer != nil {
+		client.autoAwayTimer.Stop()
+	}
 
 	client.socket.Close()
 
 	// send quit messages to friends
+	quitMessage := client.quitMessage
+	if quitMessage == "" {
+		quitMessage = "Exited"
+	}
 	for friend := range friends {
-		//TODO(dan): store quit message in user, if exists use that instead here
-		friend.Send(nil, client.nickMaskString, "QUIT", "Exited")
+		friend.SendFromClient("", client, nil, "QUIT", quitMessage)
 	}
 	if !client.exitedSnomaskSent {
 		client.server.snomasks.Send(sno.LocalQuits, fmt.Sprintf(ircfmt.Unescape("%s$r exited the network"), client.nick))
 	}
 }
 
-// SendSplitMsgFromClient sends an IRC PRIVMSG/NOTICE coming from a specific client.
-// Adds account-tag to the line as well.
+// SendSplitMsgFromClient sends an IRC PRIVMSG/NOTICE coming from a specific client,
+// split over as many lines as our line length needs. Adds account-tag to the line as well.
 func (client *Client) SendSplitMsgFromClient(msgid string, from *Client, tags *map[string]ircmsg.TagValue, command, target string, message SplitMessage) {
-	if client.capabilities[MaxLine] {
-		client.SendFromClient(msgid, from, tags, command, target, message.ForMaxLine)
-	} else {
-		for _, str := range message.For512 {
-			client.SendFromClient(msgid, from, tags, command, target, str)
-		}
+	_, maxlenRest := client.maxlens()
+	// leave room for the prefix, command, target and the final space and CRLF
+	width := maxlenRest - len(fmt.Sprintf(":%s %s %s : \r\n", from.nickMaskString, command, target))
+	for _, line := range message.Lines(width) {
+		client.SendFromClient(msgid, from, tags, command, target, line)
 	}
 }
 
 // SendFromClient sends an IRC line coming from a specific client.
-// Adds account-tag to the line as well.
+// Adds account-tag (and the bot tag, for bots) to the line as well.
 func (client *Client) SendFromClient(msgid string, from *Client, tags *map[string]ircmsg.TagValue, command string, params ...string) error {
+	return client.sendFromClientWithPrefix(msgid, from, from.nickMaskString, tags, command, params...)
+}
+
+// sendFromClientWithPrefix is SendFromClient, but uses the given prefix instead of the
+// client's current nickmask (such as when they've just changed nick).
+func (client *Client) sendFromClientWithPrefix(msgid string, from *Client, prefix string, tags *map[string]ircmsg.TagValue, command string, params ...string) error {
+	// the same tags are usually sent to lots of clients, so copy them rather than
+	// adding the ones below to everyone's
+	if tags != nil {
+		newTags := make(map[string]ircmsg.TagValue, len(*tags))
+		for name, value := range *tags {
+			newTags[name] = value
+		}
+		tags = &newTags
+	}
+
 	// attach account-tag
 	if client.capabilities[AccountTag] && from.account != &NoAccount {
 		if tags == nil {
@@ -573,6 +788,14 @@ func (client *Client) SendFromClient(msgid string, from *Client, tags *map[strin
 			(*tags)["account"] = ircmsg.MakeTagValue(from.account.Name)
 		}
 	}
+	// attach bot tag
+	if client.capabilities[MessageTags] && from.HasMode(Bot) {
+		if tags == nil {
+			tags = ircmsg.MakeTags("bot", ircmsg.NoTagValue())
+		} else {
+			(*tags)["bot"] = ircmsg.NoTagValue()
+		}
+	}
 	// attach message-id
 	if len(msgid) > 0 && client.capabilities[MessageIDs] {
 		if tags == nil {
@@ -582,7 +805,7 @@ func (client *Client) SendFromClient(msgid string, from *Client, tags *map[strin
 		}
 	}
 
-	return client.Send(tags, from.nickMaskString, command, params...)
+	return client.Send(tags, prefix, command, params...)
 }
 
 var (
@@ -600,12 +823,12 @@ var (
 
 // Send sends an IRC line to the client.
 func (client *Client) Send(tags *map[string]ircmsg.TagValue, prefix string, command string, params ...string) error {
-	// attach server-time
+	// attach server-time, unless we're replaying history and it's already been set
 	if client.capabilities[ServerTime] {
 		t := time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
 		if tags == nil {
 			tags = ircmsg.MakeTags("time", t)
-		} else {
+		} else if _, exists := (*tags)["time"]; !exists {
 			(*tags)["time"] = ircmsg.MakeTagValue(t)
 		}
 	}
@@ -642,17 +865,76 @@ func (client *Client) Send(tags *map[string]ircmsg.TagValue, prefix string, comm
 		line = line[:len(line)-3] + "\r\n"
 	}
 
-	client.server.logger.Debug("useroutput", client.nick, " ->", strings.TrimRight(line, "\r\n"))
+	client.server.logger.LogFields(logger.LogDebug, "useroutput", client.logFields, client.nick, " ->", strings.TrimRight(line, "\r\n"))
 
 	client.socket.Write(line)
 	return nil
 }
 
+// maxChannels returns how many channels this client can be in at once, 0 meaning unlimited.
+func (client *Client) maxChannels() int {
+	if client.class != nil && client.class.MaxChannels != 0 {
+		return client.class.MaxChannels
+	}
+	return client.server.Limits().MaxChannels
+}
+
+// recordHistory stores a direct message sent by this client to target in both of
+// their histories, and in the histories of the other clients logged into our account.
+func (client *Client) recordHistory(target *Client, itemType history.ItemType, msgid string, message string, tags map[string]string) {
+	item := history.Item{
+		Type:        itemType,
+		Nick:        client.nickMaskString,
+		AccountName: client.account.Name,
+		Msgid:       msgid,
+		Message:     message,
+		Target:      target.nick,
+		Tags:        tags,
+	}
+	target.history.Add(item)
+	// conversations are between accounts, and either of them can opt out
+	if client.account != &NoAccount && target.account != &NoAccount && !client.account.NoHistory && !target.account.NoHistory {
+		client.server.conversations.Add(client.account, target.account, item)
+	}
+	if target != client {
+		client.history.Add(item)
+	}
+	for _, session := range client.otherSessions() {
+		if session != target {
+			session.history.Add(item)
+		}
+	}
+}
+
+// sendSelfMessage sends a copy of a direct message this client sent to target to the
+// other clients logged into our account, so each of them sees both sides of the
+// conversation. message is nil for TAGMSG.
+func (client *Client) sendSelfMessage(msgid string, tags *map[string]ircmsg.TagValue, command string, target *Client, message *SplitMessage) {
+	for _, session := range client.otherSessions() {
+		// the target already has their own copy
+		if session == target {
+			continue
+		}
+		sessionTags := tags
+		if !session.capabilities[MessageTags] {
+			if message == nil {
+				continue
+			}
+			sessionTags = nil
+		}
+		if message == nil {
+			session.SendFromClient(msgid, client, sessionTags, command, target.nick)
+		} else {
+			session.SendSplitMsgFromClient(msgid, client, sessionTags, command, target.nick, *message)
+		}
+	}
+}
+
 // Notice sends the client a notice from the server.
 func (client *Client) Notice(text string) {
 	limit := 400
 	if client.capabilities[MaxLine] {
-		limit = client.server.limits.LineLen.Rest - 110
+		limit = client.server.Limits().LineLen.Rest - 110
 	}
 	lines := wordWrap(text, limit)
 
@@ -660,3 +942,49 @@ func (client *Client) Notice(text string) {
 		client.Send(nil, client.server.name, "NOTICE", client.nick, line)
 	}
 }
+
+// Fail sends the client a FAIL standard reply for the given command. context holds
+// any parameters that go between the code and the description.
+func (client *Client) Fail(command, code, description string, context ...string) {
+	params := append([]string{command, code}, context...)
+	params = append(params, description)
+	client.Send(nil, client.server.name, "FAIL", params...)
+}
+
+// setAway marks the client as away with the given message, or as back, and tells
+// them and any friends who've asked for away-notify.
+func (client *Client) setAway(isAway bool, text string) {
+	message := truncateUTF8(text, client.server.Limits().AwayLen)
+	client.flagsMutex.Lock()
+	if isAway {
+		client.flags[Away] = true
+	} else {
+		delete(client.flags, Away)
+	}
+	client.awayMessage = message
+	client.flagsMutex.Unlock()
+
+	var op ModeOp
+	if isAway {
+		op = Add
+		client.Send(nil, client.server.name, RPL_NOWAWAY, client.nick, "You have been marked as being away")
+	} else {
+		op = Remove
+		client.Send(nil, client.server.name, RPL_UNAWAY, client.nick, "You are no longer marked as being away")
+	}
+	//TODO(dan): Should this be sent automagically as part of setting the flag/mode?
+	modech := ModeChanges{ModeChange{
+		mode: Away,
+		op:   op,
+	}}
+	client.Send(nil, client.server.name, "MODE", client.nick, client.nick, modech.String())
+
+	// dispatch away-notify
+	for friend := range client.Friends(AwayNotify) {
+		if isAway {
+			friend.SendFromClient("", client, nil, "AWAY", message)
+		} else {
+			friend.SendFromClient("", client, nil, "AWAY")
+		}
+	}
+}
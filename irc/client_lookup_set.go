@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircmatch"
 
@@ -154,6 +156,19 @@ func (clients *ClientLookupSet) Replace(oldNick, newNick string, client *Client)
 	return nil
 }
 
+// All returns all of our clients.
+func (clients *ClientLookupSet) All() (set ClientSet) {
+	set = make(ClientSet)
+
+	clients.ByNickMutex.RLock()
+	defer clients.ByNickMutex.RUnlock()
+	for _, client := range clients.ByNick {
+		set.Add(client)
+	}
+
+	return set
+}
+
 // AllWithCaps returns all clients with the given capabilities.
 func (clients *ClientLookupSet) AllWithCaps(caps ...Capability) (set ClientSet) {
 	set = make(ClientSet)
@@ -221,16 +236,34 @@ func (clients *ClientLookupSet) Find(userhost string) *Client {
 // usermask to regexp
 //
 
+// MaskInfo is what we know about an entry in a ban, exception or invite exception list.
+type MaskInfo struct {
+	// SetBy is the nickmask of the client who set the entry, or the server's name.
+	SetBy string
+	SetAt time.Time
+	// Expires is zero if the entry doesn't expire.
+	Expires time.Time
+}
+
 //TODO(dan): move this over to generally using glob syntax instead?
 // kinda more expected in normal ban/etc masks, though regex is useful (probably as an extban?)
 type UserMaskSet struct {
 	masks  map[string]bool
 	regexp *regexp.Regexp
+	// extbans are the entries in masks that start with extbanPrefix, which aren't
+	// matched by regexp.
+	extbans []extban
+	// matchers match each of the masks on their own, in sorted order.
+	matchers []maskMatcher
+	// info holds who set the masks, when, and when they expire. Masks we don't know
+	// anything about (like those stored before we kept track) have no info.
+	info map[string]MaskInfo
 }
 
 func NewUserMaskSet() *UserMaskSet {
 	return &UserMaskSet{
 		masks: make(map[string]bool),
+		info:  make(map[string]MaskInfo),
 	}
 }
 
@@ -264,10 +297,53 @@ func (set *UserMaskSet) Remove(mask string) bool {
 		return false
 	}
 	delete(set.masks, mask)
+	delete(set.info, mask)
 	set.setRegexp()
 	return true
 }
 
+// SetInfo sets what we know about the given casefolded mask, if it's in the set.
+func (set *UserMaskSet) SetInfo(mask string, info MaskInfo) {
+	if set.masks[mask] {
+		set.info[mask] = info
+	}
+}
+
+// Info returns what we know about the given casefolded mask.
+func (set *UserMaskSet) Info(mask string) MaskInfo {
+	return set.info[mask]
+}
+
+// AllInfo returns a copy of what we know about each of the set's masks.
+func (set *UserMaskSet) AllInfo() map[string]MaskInfo {
+	info := make(map[string]MaskInfo, len(set.info))
+	for mask, maskInfo := range set.info {
+		info[mask] = maskInfo
+	}
+	return info
+}
+
+// Expired returns the masks that have expired by the given time.
+func (set *UserMaskSet) Expired(now time.Time) (expired []string) {
+	for mask, info := range set.info {
+		if !info.Expires.IsZero() && !now.Before(info.Expires) {
+			expired = append(expired, mask)
+		}
+	}
+	sort.Strings(expired)
+	return
+}
+
+// NextExpiry returns when the first of the set's masks expires, or zero if none of them do.
+func (set *UserMaskSet) NextExpiry() (next time.Time) {
+	for _, info := range set.info {
+		if !info.Expires.IsZero() && (next.IsZero() || info.Expires.Before(next)) {
+			next = info.Expires
+		}
+	}
+	return
+}
+
 func (set *UserMaskSet) Match(userhost string) bool {
 	if set.regexp == nil {
 		return false
@@ -275,6 +351,48 @@ func (set *UserMaskSet) Match(userhost string) bool {
 	return set.regexp.MatchString(userhost)
 }
 
+// MatchClient returns true if the given client's nickmask or one of the set's extbans
+// matches them.
+func (set *UserMaskSet) MatchClient(client *Client) bool {
+	if set.Match(client.nickMaskCasefolded) {
+		return true
+	}
+	for _, ban := range set.extbans {
+		if ban.Match(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchingMask returns the first of the set's masks (in sorted order) that matches the
+// given client. It's slower than MatchClient, so it's only used to tell clients which
+// entry matched them.
+func (set *UserMaskSet) MatchingMask(client *Client) (mask string, matched bool) {
+	for _, matcher := range set.matchers {
+		if matcher.Match(client) {
+			return matcher.mask, true
+		}
+	}
+	return "", false
+}
+
+// maskMatcher matches a single mask of a UserMaskSet.
+type maskMatcher struct {
+	mask string
+	// regexp is nil for extbans.
+	regexp *regexp.Regexp
+	extban extban
+}
+
+// Match returns true if the mask matches the given client.
+func (matcher maskMatcher) Match(client *Client) bool {
+	if matcher.regexp == nil {
+		return matcher.extban.Match(client)
+	}
+	return matcher.regexp.MatchString(client.nickMaskCasefolded)
+}
+
 func (set *UserMaskSet) String() string {
 	masks := make([]string, len(set.masks))
 	index := 0
@@ -285,33 +403,83 @@ func (set *UserMaskSet) String() string {
 	return strings.Join(masks, " ")
 }
 
-// Generate a regular expression from the set of user mask
-// strings. Masks are split at the two types of wildcards, `*` and
-// `?`. All the pieces are meta-escaped. `*` is replaced with `.*`,
-// the regexp equivalent. Likewise, `?` is replaced with `.`. The
-// parts are re-joined and finally all masks are joined into a big
-// or-expression.
-func (set *UserMaskSet) setRegexp() {
-	if len(set.masks) == 0 {
-		set.regexp = nil
-		return
+// Generate a regular expression from a user mask string. Masks are
+// split at the two types of wildcards, `*` and `?`. All the pieces
+// are meta-escaped. `*` is replaced with `.*`, the regexp equivalent.
+// Likewise, `?` is replaced with `.`. The parts are then re-joined.
+func maskExpr(mask string) string {
+	manyParts := strings.Split(mask, "*")
+	manyExprs := make([]string, len(manyParts))
+	for mindex, manyPart := range manyParts {
+		oneParts := strings.Split(manyPart, "?")
+		oneExprs := make([]string, len(oneParts))
+		for oindex, onePart := range oneParts {
+			oneExprs[oindex] = regexp.QuoteMeta(onePart)
+		}
+		manyExprs[mindex] = strings.Join(oneExprs, ".")
+	}
+	return strings.Join(manyExprs, ".*")
+}
+
+// setMatchers sets up the matchers for the set's masks, keeping those we already have
+// so each mask is only compiled once.
+func (set *UserMaskSet) setMatchers() {
+	existing := make(map[string]maskMatcher, len(set.matchers))
+	for _, matcher := range set.matchers {
+		existing[matcher.mask] = matcher
 	}
 
-	maskExprs := make([]string, len(set.masks))
-	index := 0
+	masks := make([]string, 0, len(set.masks))
 	for mask := range set.masks {
-		manyParts := strings.Split(mask, "*")
-		manyExprs := make([]string, len(manyParts))
-		for mindex, manyPart := range manyParts {
-			oneParts := strings.Split(manyPart, "?")
-			oneExprs := make([]string, len(oneParts))
-			for oindex, onePart := range oneParts {
-				oneExprs[oindex] = regexp.QuoteMeta(onePart)
+		masks = append(masks, mask)
+	}
+	sort.Strings(masks)
+
+	set.matchers = make([]maskMatcher, 0, len(masks))
+	for _, mask := range masks {
+		matcher, exists := existing[mask]
+		if !exists {
+			matcher.mask = mask
+			if strings.HasPrefix(mask, extbanPrefix) {
+				var valid bool
+				matcher.extban, valid = parseExtban(mask)
+				if !valid {
+					continue
+				}
+			} else {
+				var err error
+				matcher.regexp, err = regexp.Compile("^" + maskExpr(mask) + "$")
+				if err != nil {
+					continue
+				}
 			}
-			manyExprs[mindex] = strings.Join(oneExprs, ".")
 		}
-		maskExprs[index] = strings.Join(manyExprs, ".*")
+		set.matchers = append(set.matchers, matcher)
+	}
+}
+
+// Generate a regular expression from the set of user mask strings,
+// joining the expression of each mask into a big or-expression.
+func (set *UserMaskSet) setRegexp() {
+	set.extbans = nil
+	var maskExprs []string
+	for mask := range set.masks {
+		if strings.HasPrefix(mask, extbanPrefix) {
+			ban, valid := parseExtban(mask)
+			if valid {
+				set.extbans = append(set.extbans, ban)
+			}
+			continue
+		}
+
+		maskExprs = append(maskExprs, maskExpr(mask))
+	}
+	set.setMatchers()
+	if len(maskExprs) == 0 {
+		set.regexp = nil
+		return
 	}
-	expr := "^" + strings.Join(maskExprs, "|") + "$"
+	// group the masks, so each of them has to match the whole nickmask
+	expr := "^(?:" + strings.Join(maskExprs, "|") + ")$"
 	set.regexp, _ = regexp.Compile(expr)
 }
@@ -0,0 +1,49 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestUserMaskSetMatch(t *testing.T) {
+	set := NewUserMaskSet()
+	if set.Match("dan!~d@example.com") {
+		t.Error("expected an empty set not to match anyone")
+	}
+
+	set.Add("dan!*@*")
+	set.Add("*!*@example.org")
+	set.Add("?ob!*@*")
+
+	for _, nickmask := range []string{"dan!~d@example.com", "alice!~a@example.org", "bob!~b@example.net"} {
+		if !set.Match(nickmask) {
+			t.Errorf("expected %s to match", nickmask)
+		}
+	}
+	// each mask has to be in the regexp, not just the first one
+	for _, nickmask := range []string{"alice!~a@example.com", "robert!~r@example.net"} {
+		if set.Match(nickmask) {
+			t.Errorf("expected %s not to match", nickmask)
+		}
+	}
+}
+
+func TestUserMaskSetMatchWholeNickmask(t *testing.T) {
+	// whichever of these ends up in the middle of the regexp still has to match the
+	// whole nickmask, not just part of it
+	set := NewUserMaskSet()
+	set.Add("aaa!*@*")
+	set.Add("bbb!*@*")
+	set.Add("ccc!*@*")
+
+	if !set.Match("bbb!~b@example.com") {
+		t.Error("expected bbb to match")
+	}
+	for _, nickmask := range []string{"xaaa!~a@example.com", "xbbb!~b@example.com", "xccc!~c@example.com"} {
+		if set.Match(nickmask) {
+			t.Errorf("expected %s not to match", nickmask)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+// ClientTagPolicy says what we do with a client-only tag.
+type ClientTagPolicy string
+
+const (
+	// ClientTagDeny strips the tag from messages.
+	ClientTagDeny ClientTagPolicy = "deny"
+	// ClientTagRelay passes the tag on to recipients, but doesn't keep it in history.
+	ClientTagRelay ClientTagPolicy = "relay"
+	// ClientTagStore passes the tag on to recipients and keeps it in history.
+	ClientTagStore ClientTagPolicy = "store"
+)
+
+// ClientTagsConfig controls which client-only tags (like +typing) we accept from clients.
+type ClientTagsConfig struct {
+	// Default is used for tags that aren't listed in Tags.
+	Default ClientTagPolicy
+	Tags    map[string]ClientTagPolicy
+}
+
+func (conf *ClientTagsConfig) parse() error {
+	checkPolicy := func(policy ClientTagPolicy) error {
+		switch policy {
+		case ClientTagDeny, ClientTagRelay, ClientTagStore:
+			return nil
+		default:
+			return fmt.Errorf("Unknown policy [%s], must be deny, relay or store", policy)
+		}
+	}
+
+	if conf.Default == "" {
+		conf.Default = ClientTagRelay
+	}
+	err := checkPolicy(conf.Default)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]ClientTagPolicy)
+	for name, policy := range conf.Tags {
+		err = checkPolicy(policy)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+		if !strings.HasPrefix(name, "+") {
+			name = "+" + name
+		}
+		if len(name) < 2 {
+			return fmt.Errorf("Tag names can't be empty")
+		}
+		tags[name] = policy
+	}
+	conf.Tags = tags
+	return nil
+}
+
+// Policy returns what we do with the given client-only tag.
+func (conf *ClientTagsConfig) Policy(name string) ClientTagPolicy {
+	policy, exists := conf.Tags[name]
+	if exists {
+		return policy
+	}
+	if conf.Default == "" {
+		return ClientTagRelay
+	}
+	return conf.Default
+}
+
+// ISupportValue returns the value of our CLIENTTAGDENY token, or an empty string if we
+// don't deny any tags.
+func (conf *ClientTagsConfig) ISupportValue() string {
+	// names are given without their leading '+'
+	var names []string
+	denyByDefault := conf.Policy("") == ClientTagDeny
+	for name, policy := range conf.Tags {
+		if denyByDefault && policy != ClientTagDeny {
+			names = append(names, "-"+name[1:])
+		} else if !denyByDefault && policy == ClientTagDeny {
+			names = append(names, name[1:])
+		}
+	}
+	sort.Strings(names)
+
+	if denyByDefault {
+		return strings.Join(append([]string{"*"}, names...), ",")
+	}
+	return strings.Join(names, ",")
+}
+
+// FilterTags returns the client-only tags from the given tags that we relay.
+func (conf *ClientTagsConfig) FilterTags(tags map[string]ircmsg.TagValue) *map[string]ircmsg.TagValue {
+	clientOnlyTags := GetClientOnlyTags(tags)
+	if clientOnlyTags == nil {
+		return nil
+	}
+
+	for name := range *clientOnlyTags {
+		if conf.Policy(name) == ClientTagDeny {
+			delete(*clientOnlyTags, name)
+		}
+	}
+
+	if len(*clientOnlyTags) < 1 {
+		return nil
+	}
+	return clientOnlyTags
+}
+
+// StoredTags returns the given client-only tags that we keep in history.
+func (conf *ClientTagsConfig) StoredTags(clientOnlyTags *map[string]ircmsg.TagValue) map[string]string {
+	if clientOnlyTags == nil {
+		return nil
+	}
+
+	var stored map[string]string
+	for name, value := range *clientOnlyTags {
+		if conf.Policy(name) == ClientTagStore {
+			if stored == nil {
+				stored = make(map[string]string)
+			}
+			stored[name] = value.Value
+		}
+	}
+	return stored
+}
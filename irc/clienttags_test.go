@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+func TestClientTagsConfig(t *testing.T) {
+	conf := ClientTagsConfig{
+		Default: ClientTagDeny,
+		Tags: map[string]ClientTagPolicy{
+			"typing":       ClientTagRelay,
+			"+draft/react": ClientTagStore,
+		},
+	}
+	err := conf.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	if conf.ISupportValue() != "*,-draft/react,-typing" {
+		t.Errorf("unexpected CLIENTTAGDENY value: %s", conf.ISupportValue())
+	}
+
+	relayed := conf.FilterTags(map[string]ircmsg.TagValue{
+		"+typing":      ircmsg.MakeTagValue("active"),
+		"+draft/react": ircmsg.MakeTagValue("lol"),
+		"+unknown":     ircmsg.MakeTagValue("x"),
+		"time":         ircmsg.MakeTagValue("now"),
+	})
+	if relayed == nil || len(*relayed) != 2 || (*relayed)["+typing"].Value != "active" || (*relayed)["+draft/react"].Value != "lol" {
+		t.Errorf("unexpected relayed tags: %v", relayed)
+	}
+	stored := conf.StoredTags(relayed)
+	if !reflect.DeepEqual(stored, map[string]string{"+draft/react": "lol"}) {
+		t.Errorf("unexpected stored tags: %v", stored)
+	}
+	if conf.FilterTags(map[string]ircmsg.TagValue{"+unknown": ircmsg.MakeTagValue("x")}) != nil {
+		t.Error("expected a message with only denied tags to have no client-only tags")
+	}
+
+	conf = ClientTagsConfig{Tags: map[string]ClientTagPolicy{"+secret": ClientTagDeny}}
+	err = conf.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	if conf.Default != ClientTagRelay || conf.ISupportValue() != "secret" {
+		t.Errorf("unexpected config: %+v, %s", conf, conf.ISupportValue())
+	}
+
+	conf = ClientTagsConfig{Default: "maybe"}
+	if conf.parse() == nil {
+		t.Error("expected an unknown policy to be invalid")
+	}
+}
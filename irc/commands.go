@@ -21,19 +21,22 @@ type Command struct {
 // Run runs this command with the given client/message.
 func (cmd *Command) Run(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	if !client.registered && !cmd.usablePreReg {
-		client.Send(nil, server.name, ERR_NOTREGISTERED, client.nick, "You need to register before you can use that command")
+		client.Send(nil, server.name, ERR_NOTREGISTERED, client.nick, client.t("You need to register before you can use that command"))
 		return false
 	}
-	if cmd.oper && !client.flags[Operator] {
-		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, "Permission Denied - You're not an IRC operator")
+	if cmd.oper && !client.HasMode(Operator) {
+		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied - You're not an IRC operator"))
 		return false
 	}
 	if len(cmd.capabs) > 0 && !client.HasCapabs(cmd.capabs...) {
-		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, "Permission Denied")
+		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied"))
 		return false
 	}
 	if len(msg.Params) < cmd.minParams {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+	if !server.checkCommandHook(client, msg) {
 		return false
 	}
 	if !cmd.leaveClientActive {
@@ -59,6 +62,14 @@ var Commands = map[string]Command{
 		handler:   accHandler,
 		minParams: 3,
 	},
+	"ACCEPT": {
+		handler:   acceptHandler,
+		minParams: 1,
+	},
+	"ADMIN": {
+		handler:   adminHandler,
+		minParams: 0,
+	},
 	"AMBIANCE": {
 		handler:   sceneHandler,
 		minParams: 2,
@@ -81,6 +92,29 @@ var Commands = map[string]Command{
 		handler:   csHandler,
 		minParams: 1,
 	},
+	"CHATHISTORY": {
+		handler:   chathistoryHandler,
+		minParams: 1,
+	},
+	"CNOTICE": {
+		handler:   cmessageHandler,
+		minParams: 3,
+	},
+	"CONFIG": {
+		handler:   configHandler,
+		minParams: 1,
+		oper:      true,
+		capabs:    []string{"oper:rehash"},
+	},
+	"CONNLIST": {
+		handler:   connlistHandler,
+		minParams: 0,
+		oper:      true,
+	},
+	"CPRIVMSG": {
+		handler:   cmessageHandler,
+		minParams: 3,
+	},
 	"CS": {
 		handler:   csHandler,
 		minParams: 1,
@@ -94,6 +128,17 @@ var Commands = map[string]Command{
 		minParams: 1,
 		oper:      true,
 	},
+	"GLOBALNOTICE": {
+		handler:   globalnoticeHandler,
+		minParams: 2,
+		oper:      true,
+		capabs:    []string{"oper:globalnotice"},
+	},
+	"HEALTH": {
+		handler:      healthHandler,
+		usablePreReg: true,
+		minParams:    0,
+	},
 	"HELP": {
 		handler:   helpHandler,
 		minParams: 0,
@@ -102,9 +147,21 @@ var Commands = map[string]Command{
 		handler:   helpHandler,
 		minParams: 0,
 	},
+	"HOSTSERV": {
+		handler:   hsHandler,
+		minParams: 1,
+	},
+	"HS": {
+		handler:   hsHandler,
+		minParams: 1,
+	},
+	"INFO": {
+		handler:   infoHandler,
+		minParams: 0,
+	},
 	"INVITE": {
 		handler:   inviteHandler,
-		minParams: 2,
+		minParams: 0,
 	},
 	"ISON": {
 		handler:   isonHandler,
@@ -129,10 +186,21 @@ var Commands = map[string]Command{
 		minParams: 1,
 		oper:      true,
 	},
+	"LANGUAGE": {
+		handler:      languageHandler,
+		usablePreReg: true,
+		minParams:    1,
+	},
 	"LIST": {
 		handler:   listHandler,
 		minParams: 0,
 	},
+	"LOGLEVEL": {
+		handler:   loglevelHandler,
+		minParams: 0,
+		oper:      true,
+		capabs:    []string{"oper:loglevel"},
+	},
 	"LUSERS": {
 		handler:   lusersHandler,
 		minParams: 0,
@@ -225,6 +293,12 @@ var Commands = map[string]Command{
 		handler:   sceneHandler,
 		minParams: 2,
 	},
+	"SHUTDOWN": {
+		handler:   shutdownHandler,
+		minParams: 0,
+		oper:      true,
+		capabs:    []string{"oper:die"},
+	},
 	"TAGMSG": {
 		handler:   tagmsgHandler,
 		minParams: 1,
@@ -240,6 +314,12 @@ var Commands = map[string]Command{
 		oper:      true,
 		capabs:    []string{"oper:rehash"},
 	},
+	"RELOADMOTD": {
+		handler:   reloadmotdHandler,
+		minParams: 0,
+		oper:      true,
+		capabs:    []string{"oper:rehash"},
+	},
 	"TIME": {
 		handler:   timeHandler,
 		minParams: 0,
@@ -271,6 +351,10 @@ var Commands = map[string]Command{
 		handler:   versionHandler,
 		minParams: 0,
 	},
+	"WALLCHOPS": {
+		handler:   wallchopsHandler,
+		minParams: 2,
+	},
 	"WHO": {
 		handler:   whoHandler,
 		minParams: 0,
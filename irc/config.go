@@ -9,15 +9,19 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/languages"
 	"github.com/oragono/oragono/irc/logger"
 
 	"code.cloudfoundry.org/bytefmt"
+	"golang.org/x/crypto/bcrypt"
 
 	"gopkg.in/yaml.v2"
 )
@@ -76,9 +80,27 @@ type AccountRegistrationConfig struct {
 	}
 }
 
+// AutoAwayConfig controls marking clients as away once they've been idle for a while.
+type AutoAwayConfig struct {
+	Enabled        bool
+	IdleTimeString string        `yaml:"idle-time"`
+	IdleTime       time.Duration `yaml:"idle-time-real"`
+	Message        string
+}
+
+// PushConfig controls the push notifications sent to accounts' webhooks.
+type PushConfig struct {
+	Enabled       bool
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+	AllowHTTP     bool          `yaml:"allow-http"`
+	AllowPrivate  bool          `yaml:"allow-private"`
+}
+
 // ChannelRegistrationConfig controls channel registration.
 type ChannelRegistrationConfig struct {
 	Enabled bool
+	Expiry  ChannelExpiryConfig
 }
 
 // OperClassConfig defines a specific operator class.
@@ -87,6 +109,7 @@ type OperClassConfig struct {
 	WhoisLine    string
 	Extends      string
 	Capabilities []string
+	MaxChannels  int `yaml:"max-channels"`
 }
 
 // OperConfig defines a specific operator's configuration.
@@ -109,8 +132,19 @@ func (conf *OperConfig) PasswordBytes() []byte {
 
 // RestAPIConfig controls the integrated REST API.
 type RestAPIConfig struct {
-	Enabled bool
-	Listen  string
+	Enabled   bool
+	Listen    string
+	Dashboard DashboardConfig
+}
+
+// HealthCheckConfig controls the health check endpoint and IRC probe.
+type HealthCheckConfig struct {
+	Enabled         bool
+	Listen          string
+	IRCProbe        bool   `yaml:"irc-probe"`
+	MaxGoroutines   int    `yaml:"max-goroutines"`
+	MaxMemoryString string `yaml:"max-memory"`
+	MaxMemory       uint64 `yaml:"max-memory-bytes"`
 }
 
 // ConnectionLimitsConfig controls the automated connection limits.
@@ -120,6 +154,9 @@ type ConnectionLimitsConfig struct {
 	CidrLenIPv6 int `yaml:"cidr-len-ipv6"`
 	IPsPerCidr  int `yaml:"ips-per-subnet"`
 	Exempted    []string
+	// ExemptedCountries and ExemptedASNs need GeoIP lookups to be enabled.
+	ExemptedCountries []string `yaml:"exempted-countries"`
+	ExemptedASNs      []uint   `yaml:"exempted-asns"`
 }
 
 // ConnectionThrottleConfig controls the automated connection throttling.
@@ -134,20 +171,76 @@ type ConnectionThrottleConfig struct {
 	BanDuration        time.Duration
 	BanMessage         string `yaml:"ban-message"`
 	Exempted           []string
+	ExemptedCountries  []string `yaml:"exempted-countries"`
+	ExemptedASNs       []uint   `yaml:"exempted-asns"`
+}
+
+// UnregisteredConfig controls the limits on connections that haven't registered yet.
+type UnregisteredConfig struct {
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+	MaxPerIP      int           `yaml:"max-per-ip"`
+}
+
+// RequireSaslConfig controls which connections have to log in with SASL before they
+// can register.
+type RequireSaslConfig struct {
+	Enabled     bool
+	Listeners   []string
+	RawNetworks []string    `yaml:"networks"`
+	Networks    []net.IPNet `yaml:"networks-real"`
+	RawExempted []string    `yaml:"exempted"`
+	Exempted    []net.IPNet `yaml:"exempted-real"`
+}
+
+// ShutdownConfig controls how the server shuts down.
+type ShutdownConfig struct {
+	Message         string
+	DrainTimeString string        `yaml:"drain-time"`
+	DrainTime       time.Duration `yaml:"drain-time-real"`
+}
+
+// WhoisChannelsConfig controls which of a client's channels are shown in WHOIS replies.
+type WhoisChannelsConfig struct {
+	// ShowSecret shows secret (+s) channels to clients who aren't in them.
+	ShowSecret bool `yaml:"show-secret"`
+	// SharedOnly only shows the channels the requesting client is also in.
+	SharedOnly bool `yaml:"shared-only"`
+	// HideFromOpers applies the rules above to opers too, who normally see every channel.
+	HideFromOpers bool `yaml:"hide-from-opers"`
+}
+
+// QuitFilterConfig controls how QUIT and PART messages are filtered, since they're often
+// used to spam (and get around channel modes while doing it).
+type QuitFilterConfig struct {
+	StripFormatting bool             `yaml:"strip-formatting"`
+	BlockURLs       bool             `yaml:"block-urls"`
+	RawSpamfilters  []string         `yaml:"spamfilters"`
+	Spamfilters     []*regexp.Regexp `yaml:"spamfilters-real"`
+	// Replacement is sent instead of messages that are blocked.
+	Replacement string
 }
 
 // LoggingConfig controls a single logging method.
 type LoggingConfig struct {
-	Method        string
-	MethodStdout  bool
-	MethodStderr  bool
-	MethodFile    bool
-	Filename      string
-	TypeString    string       `yaml:"type"`
-	Types         []string     `yaml:"real-types"`
-	ExcludedTypes []string     `yaml:"real-excluded-types"`
-	LevelString   string       `yaml:"level"`
-	Level         logger.Level `yaml:"level-real"`
+	Method         string
+	MethodStdout   bool
+	MethodStderr   bool
+	MethodFile     bool
+	Filename       string
+	MethodSyslog   bool
+	SyslogNetwork  string `yaml:"syslog-network"`
+	SyslogAddress  string `yaml:"syslog-address"`
+	MethodJournald bool
+	MethodEventlog bool
+	Tag            string
+	Format         string
+	FormatJSON     bool         `yaml:"format-json"`
+	TypeString     string       `yaml:"type"`
+	Types          []string     `yaml:"real-types"`
+	ExcludedTypes  []string     `yaml:"real-excluded-types"`
+	LevelString    string       `yaml:"level"`
+	Level          logger.Level `yaml:"level-real"`
 }
 
 // LineLenConfig controls line lengths.
@@ -177,6 +270,70 @@ func (sts *STSConfig) Value() string {
 	return val
 }
 
+// PasswordHashingConfig controls how we hash account and oper passwords.
+type PasswordHashingConfig struct {
+	Algorithm  string
+	BcryptCost int `yaml:"bcrypt-cost"`
+	Argon2     struct {
+		Time         uint32
+		MemoryString string `yaml:"memory"`
+		// Memory is in KiB, which is what argon2 works with.
+		Memory  uint32 `yaml:"memory-kib"`
+		Threads uint8
+	}
+}
+
+// argon2Params returns the argon2id settings to hash new passwords with.
+func (conf *PasswordHashingConfig) argon2Params() argon2Params {
+	return argon2Params{
+		Memory:  conf.Argon2.Memory,
+		Time:    conf.Argon2.Time,
+		Threads: conf.Argon2.Threads,
+	}
+}
+
+// parse checks the password hashing settings and fills in the defaults.
+func (conf *PasswordHashingConfig) parse() error {
+	conf.Algorithm = strings.ToLower(conf.Algorithm)
+	if conf.Algorithm == "" {
+		conf.Algorithm = passwordAlgorithmBcrypt
+	}
+
+	switch conf.Algorithm {
+	case passwordAlgorithmBcrypt:
+		if conf.BcryptCost == 0 {
+			conf.BcryptCost = defaultPasswordCost
+		}
+		if conf.BcryptCost < bcrypt.MinCost || bcrypt.MaxCost < conf.BcryptCost {
+			return fmt.Errorf("bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case passwordAlgorithmArgon2id:
+		if conf.Argon2.Time == 0 {
+			conf.Argon2.Time = 1
+		}
+		if argon2MaxTime < conf.Argon2.Time {
+			return fmt.Errorf("argon2 time must be at most %d", argon2MaxTime)
+		}
+		if conf.Argon2.Threads == 0 {
+			conf.Argon2.Threads = 4
+		}
+		if conf.Argon2.MemoryString == "" {
+			conf.Argon2.MemoryString = "64M"
+		}
+		memory, err := bytefmt.ToBytes(conf.Argon2.MemoryString)
+		if err != nil {
+			return fmt.Errorf("Could not parse argon2 memory: %s", err.Error())
+		}
+		if memory/1024 < 8*uint64(conf.Argon2.Threads) || argon2MaxMemory < memory/1024 {
+			return fmt.Errorf("argon2 memory must be at least 8KiB per thread, and at most 1GiB")
+		}
+		conf.Argon2.Memory = uint32(memory / 1024)
+	default:
+		return fmt.Errorf("Unknown algorithm [%s], it should be bcrypt or argon2id", conf.Algorithm)
+	}
+	return nil
+}
+
 // StackImpactConfig is the config used for StackImpact's profiling.
 type StackImpactConfig struct {
 	Enabled  bool
@@ -184,57 +341,160 @@ type StackImpactConfig struct {
 	AppName  string `yaml:"app-name"`
 }
 
+// DumpDir returns the directory that state dumps are written to. Unless one is
+// configured, this is the directory of the first log file.
+func (conf *Config) DumpDir() string {
+	if conf.Debug.DumpDir != "" {
+		return conf.Debug.DumpDir
+	}
+	for _, logConfig := range conf.Logging {
+		if logConfig.MethodFile {
+			return filepath.Dir(logConfig.Filename)
+		}
+	}
+	return "."
+}
+
 // Config defines the overall configuration.
 type Config struct {
 	Network struct {
-		Name string
+		Name        string
+		Description string
+	}
+
+	Admin struct {
+		Name     string
+		Location string
+		Email    string
 	}
 
 	Server struct {
 		PassConfig
-		Password           string
-		Name               string
-		Listen             []string
-		Wslisten           string                      `yaml:"ws-listen"`
-		TLSListeners       map[string]*TLSListenConfig `yaml:"tls-listeners"`
-		STS                STSConfig
-		RestAPI            RestAPIConfig `yaml:"rest-api"`
-		CheckIdent         bool          `yaml:"check-ident"`
-		MOTD               string
-		MaxSendQString     string `yaml:"max-sendq"`
-		MaxSendQBytes      uint64
-		ConnectionLimits   ConnectionLimitsConfig   `yaml:"connection-limits"`
-		ConnectionThrottle ConnectionThrottleConfig `yaml:"connection-throttling"`
+		Password            string
+		Name                string
+		Listen              []string
+		ListenerPasswords   map[string]string                 `yaml:"listener-passwords"`
+		ListenerHashes      map[string][]byte                 `yaml:"listener-passwords-real"`
+		Wslisten            string                            `yaml:"ws-listen"`
+		TLSListeners        map[string]*TLSListenConfig       `yaml:"tls-listeners"`
+		WebSocketListeners  map[string]*WebSocketListenConfig `yaml:"websocket-listeners"`
+		STS                 STSConfig
+		RestAPI             RestAPIConfig     `yaml:"rest-api"`
+		HealthCheck         HealthCheckConfig `yaml:"health-check"`
+		CheckIdent          bool              `yaml:"check-ident"`
+		MOTD                string
+		ExtraMOTDs          []string `yaml:"motd-files"`
+		MOTDFormatting      bool     `yaml:"motd-formatting"`
+		MOTDStripFormatting bool     `yaml:"motd-strip-formatting"`
+		HelpPageLines       int      `yaml:"help-page-lines"`
+		MaxSendQString      string   `yaml:"max-sendq"`
+		MaxSendQBytes       uint64
+		ConnectionLimits    ConnectionLimitsConfig   `yaml:"connection-limits"`
+		ConnectionThrottle  ConnectionThrottleConfig `yaml:"connection-throttling"`
+		GeoIP               GeoIPConfig              `yaml:"geoip"`
+		Unregistered        UnregisteredConfig       `yaml:"unregistered-connections"`
+		Shutdown            ShutdownConfig
+		QuitFilter          QuitFilterConfig        `yaml:"quit-filter"`
+		NickFlood           NickFloodConfig         `yaml:"nick-flood"`
+		CTCPFlood           CTCPFloodConfig         `yaml:"ctcp-flood"`
+		ClientTags          ClientTagsConfig        `yaml:"client-tags"`
+		WhoisChannels       WhoisChannelsConfig     `yaml:"whois-channels"`
+		UserCountPrivacy    UserCountPrivacyConfig  `yaml:"user-count-privacy"`
+		ConnectionClasses   []ConnectionClassConfig `yaml:"connection-classes"`
+		RawDefaultUserModes *string                 `yaml:"default-user-modes"`
+		DefaultUserModes    Modes                   `yaml:"default-user-modes-real"`
+		RawISupport         map[string]*string      `yaml:"isupport"`
+		ISupport            map[string]*string      `yaml:"isupport-real"`
 	}
 
 	Datastore struct {
 		Path string
 	}
 
+	Languages struct {
+		Enabled bool
+		Path    string
+		Default string
+		Data    map[string]*languages.LangData `yaml:"data-real"`
+	}
+
 	Accounts struct {
 		Registration          AccountRegistrationConfig
-		AuthenticationEnabled bool `yaml:"authentication-enabled"`
+		AuthenticationEnabled bool           `yaml:"authentication-enabled"`
+		AutoAway              AutoAwayConfig `yaml:"auto-away"`
+		CertfpAutoLogin       bool           `yaml:"certfp-auto-login"`
+		Confusables           ConfusablesConfig
+		ExternalAuth          ExternalAuthConfig  `yaml:"external-auth"`
+		LoginThrottling       LoginThrottleConfig `yaml:"login-throttling"`
+		OAuth2                OAuth2Config
+		Push                  PushConfig
+		RequireSasl           RequireSaslConfig `yaml:"require-sasl"`
 	}
 
 	Channels struct {
-		Registration ChannelRegistrationConfig
+		RawDefaultModes *string       `yaml:"default-modes"`
+		DefaultModes    Modes         `yaml:"default-modes-real"`
+		RawInviteExpiry string        `yaml:"invite-expiry"`
+		InviteExpiry    time.Duration `yaml:"invite-expiry-real"`
+		AutoJoin        []string      `yaml:"auto-join"`
+		Registration    ChannelRegistrationConfig
+		OpFlood         OpFloodConfig `yaml:"op-flood"`
+		Confusables     ChannelConfusablesConfig
+	}
+
+	Roleplay struct {
+		RawEnabled *bool `yaml:"enabled"`
+		Enabled    bool  `yaml:"enabled-real"`
 	}
 
 	OperClasses map[string]*OperClassConfig `yaml:"oper-classes"`
 
 	Opers map[string]*OperConfig
 
+	PasswordHashing PasswordHashingConfig `yaml:"password-hashing"`
+
+	ReservedNames ReservedNamesConfig `yaml:"reserved-names"`
+
+	RawAliases map[string]string        `yaml:"aliases"`
+	Aliases    map[string]*CommandAlias `yaml:"aliases-real"`
+
+	HelpTopics map[string]string `yaml:"help-topics"`
+
+	AbuseReports AbuseReportsConfig `yaml:"abuse-reports"`
+
+	Hooks []HookConfig
+
 	Logging []LoggingConfig
 
+	Webhooks []WebhookConfig
+
 	Debug struct {
 		StackImpact StackImpactConfig
+		DumpDir     string `yaml:"dump-dir"`
+	}
+
+	History struct {
+		Enabled            bool
+		ChannelLength      int `yaml:"channel-length"`
+		ClientLength       int `yaml:"client-length"`
+		ConversationLength int `yaml:"conversation-length"`
+		// ConversationsPerAccount is how many conversations we keep for each account.
+		ConversationsPerAccount int `yaml:"conversations-per-account"`
+		// ChathistoryMax is the most messages a client can ask for in one CHATHISTORY request.
+		ChathistoryMax int `yaml:"chathistory-maxmessages"`
+		// Persistent is true if registered channels' history is kept in the datastore
+		// while they're empty and across restarts.
+		Persistent bool
 	}
 
 	Limits struct {
+		AcceptEntries  uint          `yaml:"accept-entries"`
 		AwayLen        uint          `yaml:"awaylen"`
 		ChanListModes  uint          `yaml:"chan-list-modes"`
 		ChannelLen     uint          `yaml:"channellen"`
 		KickLen        uint          `yaml:"kicklen"`
+		MaxChannels    uint          `yaml:"max-channels-per-client"`
+		MaxTargets     uint          `yaml:"maxtargets"`
 		MonitorEntries uint          `yaml:"monitor-entries"`
 		NickLen        uint          `yaml:"nicklen"`
 		TopicLen       uint          `yaml:"topiclen"`
@@ -248,6 +508,25 @@ type OperClass struct {
 	Title        string
 	WhoisLine    string          `yaml:"whois-line"`
 	Capabilities map[string]bool // map to make lookups much easier
+	MaxChannels  int             // 0 means use the server's default
+}
+
+// HistoryLengths returns how many history items we keep for each channel and client.
+// These are both zero if history is disabled.
+func (conf *Config) HistoryLengths() (channelLength int, clientLength int) {
+	if !conf.History.Enabled {
+		return 0, 0
+	}
+	return conf.History.ChannelLength, conf.History.ClientLength
+}
+
+// ConversationLength returns how many direct messages we keep for each pair of accounts,
+// which is zero if history is disabled.
+func (conf *Config) ConversationLength() int {
+	if !conf.History.Enabled {
+		return 0
+	}
+	return conf.History.ConversationLength
 }
 
 // OperatorClasses returns a map of assembled operator classes from the given config.
@@ -290,6 +569,7 @@ func (conf *Config) OperatorClasses() (*map[string]OperClass, error) {
 				for capab := range einfo.Capabilities {
 					oc.Capabilities[capab] = true
 				}
+				oc.MaxChannels = einfo.MaxChannels
 			}
 
 			// add our own info
@@ -297,6 +577,9 @@ func (conf *Config) OperatorClasses() (*map[string]OperClass, error) {
 			for _, capab := range info.Capabilities {
 				oc.Capabilities[capab] = true
 			}
+			if info.MaxChannels != 0 {
+				oc.MaxChannels = info.MaxChannels
+			}
 			if len(info.WhoisLine) > 0 {
 				oc.WhoisLine = info.WhoisLine
 			} else {
@@ -376,7 +659,7 @@ func (conf *Config) TLSListeners() map[string]*tls.Config {
 
 // LoadConfig loads the given YAML configuration file.
 func LoadConfig(filename string) (config *Config, err error) {
-	data, err := ioutil.ReadFile(filename)
+	data, err := loadConfigData(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -391,9 +674,24 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Server.PassConfig.Password = config.Server.Password
 	}
 
+	// these can change the network name and limits, so apply them before checking those
+	err = config.applyISupportOverrides()
+	if err != nil {
+		return nil, err
+	}
+	if config.Limits.MaxTargets == 0 {
+		config.Limits.MaxTargets = uint(defaultMaxTargets)
+	}
+	if config.Limits.AcceptEntries == 0 {
+		config.Limits.AcceptEntries = uint(defaultAcceptEntries)
+	}
+
 	if config.Network.Name == "" {
 		return nil, errors.New("Network name missing")
 	}
+	if strings.Contains(config.Network.Name, " ") {
+		return nil, errors.New("Network name can't contain spaces")
+	}
 	if config.Server.Name == "" {
 		return nil, errors.New("Server name missing")
 	}
@@ -403,6 +701,27 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Datastore.Path == "" {
 		return nil, errors.New("Datastore path missing")
 	}
+	// ws-listen is the old way of setting up a websocket listener
+	if config.Server.Wslisten != "" {
+		if config.Server.WebSocketListeners == nil {
+			config.Server.WebSocketListeners = make(map[string]*WebSocketListenConfig)
+		}
+		if config.Server.WebSocketListeners[config.Server.Wslisten] == nil {
+			config.Server.WebSocketListeners[config.Server.Wslisten] = &WebSocketListenConfig{}
+		}
+		var listening bool
+		for _, addr := range config.Server.Listen {
+			listening = listening || addr == config.Server.Wslisten
+		}
+		if !listening {
+			config.Server.Listen = append(config.Server.Listen, config.Server.Wslisten)
+		}
+	}
+	for addr, wsConf := range config.Server.WebSocketListeners {
+		if wsConf == nil {
+			config.Server.WebSocketListeners[addr] = &WebSocketListenConfig{}
+		}
+	}
 	if len(config.Server.Listen) == 0 {
 		return nil, errors.New("Server listening addresses missing")
 	}
@@ -418,6 +737,15 @@ func LoadConfig(filename string) (config *Config, err error) {
 			return nil, fmt.Errorf("STS port is incorrect, should be 0 if disabled: %d", config.Server.STS.Port)
 		}
 	}
+	if config.Server.HealthCheck.Enabled && config.Server.HealthCheck.Listen == "" {
+		return nil, errors.New("Health check is enabled but has no listen address")
+	}
+	if config.Server.HealthCheck.MaxMemoryString != "" {
+		config.Server.HealthCheck.MaxMemory, err = bytefmt.ToBytes(config.Server.HealthCheck.MaxMemoryString)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse health-check max-memory: %s", err.Error())
+		}
+	}
 	if config.Server.ConnectionThrottle.Enabled {
 		config.Server.ConnectionThrottle.Duration, err = time.ParseDuration(config.Server.ConnectionThrottle.DurationString)
 		if err != nil {
@@ -428,6 +756,194 @@ func LoadConfig(filename string) (config *Config, err error) {
 			return nil, fmt.Errorf("Could not parse connection-throttle ban-duration: %s", err.Error())
 		}
 	}
+	if config.Server.Unregistered.TimeoutString != "" {
+		config.Server.Unregistered.Timeout, err = time.ParseDuration(config.Server.Unregistered.TimeoutString)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse unregistered-connections timeout: %s", err.Error())
+		}
+	}
+	if config.Accounts.AutoAway.Enabled {
+		config.Accounts.AutoAway.IdleTime = defaultAutoAwayIdleTime
+		if config.Accounts.AutoAway.IdleTimeString != "" {
+			config.Accounts.AutoAway.IdleTime, err = time.ParseDuration(config.Accounts.AutoAway.IdleTimeString)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse auto-away idle-time: %s", err.Error())
+			}
+		}
+		if config.Accounts.AutoAway.Message == "" {
+			config.Accounts.AutoAway.Message = "Auto-away: idle"
+		}
+	}
+	config.Accounts.Push.Timeout = defaultPushTimeout
+	if config.Accounts.Push.TimeoutString != "" {
+		config.Accounts.Push.Timeout, err = time.ParseDuration(config.Accounts.Push.TimeoutString)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse push timeout: %s", err.Error())
+		}
+	}
+	err = config.Accounts.ExternalAuth.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse external-auth settings: %s", err.Error())
+	}
+	err = config.Accounts.LoginThrottling.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse login-throttling settings: %s", err.Error())
+	}
+	err = config.Accounts.OAuth2.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse oauth2 settings: %s", err.Error())
+	}
+	err = config.Server.QuitFilter.parseSpamfilters()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Server.ClientTags.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse client-tags settings: %s", err.Error())
+	}
+	err = config.Server.NickFlood.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse nick-flood settings: %s", err.Error())
+	}
+	err = config.parseConnectionClasses()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Server.CTCPFlood.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse ctcp-flood settings: %s", err.Error())
+	}
+	for name := range config.Server.CTCPFlood.Classes {
+		if !config.ConnectionClassExists(name) {
+			return nil, fmt.Errorf("ctcp-flood has limits for unknown connection class %s", name)
+		}
+	}
+	err = config.Server.UserCountPrivacy.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse user-count-privacy settings: %s", err.Error())
+	}
+	err = config.Channels.Confusables.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse channel confusables settings: %s", err.Error())
+	}
+	err = config.Channels.OpFlood.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse op-flood settings: %s", err.Error())
+	}
+	for _, name := range config.Channels.AutoJoin {
+		_, err = CasefoldChannel(name)
+		if err != nil {
+			return nil, fmt.Errorf("Auto-join channel name [%s] is invalid", name)
+		}
+	}
+	err = config.Channels.Registration.Expiry.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse channel expiry settings: %s", err.Error())
+	}
+	config.Server.ListenerHashes = make(map[string][]byte)
+	for addr, password := range config.Server.ListenerPasswords {
+		hash, err := DecodePasswordHash(password)
+		if err == nil {
+			err = checkPasswordHashBytes(hash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Password for listener [%s] is not a valid hash: %s", addr, err.Error())
+		}
+		config.Server.ListenerHashes[addr] = hash
+	}
+	config.Accounts.RequireSasl.Networks, err = parseNetList(config.Accounts.RequireSasl.RawNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse require-sasl networks: %s", err.Error())
+	}
+	config.Accounts.RequireSasl.Exempted, err = parseNetList(config.Accounts.RequireSasl.RawExempted)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse require-sasl exempted: %s", err.Error())
+	}
+	requireSasl := config.Accounts.RequireSasl
+	if (requireSasl.Enabled || 0 < len(requireSasl.Listeners) || 0 < len(requireSasl.Networks)) && !config.Accounts.AuthenticationEnabled {
+		return nil, errRequireSaslNoAuth
+	}
+	config.Channels.InviteExpiry = defaultInviteExpiry
+	if config.Channels.RawInviteExpiry != "" {
+		config.Channels.InviteExpiry, err = time.ParseDuration(config.Channels.RawInviteExpiry)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse channels invite-expiry: %s", err.Error())
+		}
+	}
+	// roleplaying was always enabled before it could be configured
+	config.Roleplay.Enabled = config.Roleplay.RawEnabled == nil || *config.Roleplay.RawEnabled
+	config.Server.DefaultUserModes = make(Modes, 0)
+	if config.Server.RawDefaultUserModes != nil {
+		config.Server.DefaultUserModes, err = ParseDefaultUserModes(*config.Server.RawDefaultUserModes)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse default user modes: %s", err.Error())
+		}
+	}
+	config.Channels.DefaultModes = DefaultChannelModes
+	if config.Channels.RawDefaultModes != nil {
+		config.Channels.DefaultModes, err = ParseDefaultChannelModes(*config.Channels.RawDefaultModes)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse default channel modes: %s", err.Error())
+		}
+	}
+	config.Languages.Data = make(map[string]*languages.LangData)
+	if config.Languages.Enabled {
+		if config.Languages.Path == "" {
+			return nil, errors.New("Languages are enabled but no path is given to load them from")
+		}
+		config.Languages.Data, err = languages.LoadLanguages(config.Languages.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load languages: %s", err.Error())
+		}
+	}
+	config.Languages.Default = strings.ToLower(config.Languages.Default)
+	if config.Languages.Default == "" {
+		config.Languages.Default = languages.DefaultCode
+	}
+	if _, exists := config.Languages.Data[config.Languages.Default]; !exists && config.Languages.Default != languages.DefaultCode {
+		return nil, fmt.Errorf("Default language [%s] has not been loaded", config.Languages.Default)
+	}
+	err = config.PasswordHashing.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse password-hashing settings: %s", err.Error())
+	}
+	config.Aliases, err = config.ParseAliases()
+	if err != nil {
+		return nil, err
+	}
+	err = config.checkHelpTopics()
+	if err != nil {
+		return nil, err
+	}
+	err = config.checkHelpTranslations()
+	if err != nil {
+		return nil, err
+	}
+	err = config.parseHooks()
+	if err != nil {
+		return nil, err
+	}
+	err = config.AbuseReports.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse abuse-reports settings: %s", err.Error())
+	}
+	err = config.ReservedNames.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse reserved-names settings: %s", err.Error())
+	}
+	err = config.parseWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	if config.Server.Shutdown.Message == "" {
+		config.Server.Shutdown.Message = "Server is shutting down"
+	}
+	if config.Server.Shutdown.DrainTimeString != "" {
+		config.Server.Shutdown.DrainTime, err = time.ParseDuration(config.Server.Shutdown.DrainTimeString)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse shutdown drain-time: %s", err.Error())
+		}
+	}
 	if config.Limits.LineLen.Tags < 512 || config.Limits.LineLen.Rest < 512 {
 		return nil, errors.New("Line lengths must be 512 or greater (check the linelen section under server->limits)")
 	}
@@ -446,6 +962,32 @@ func LoadConfig(filename string) (config *Config, err error) {
 		logConfig.MethodFile = methods["file"]
 		logConfig.MethodStdout = methods["stdout"]
 		logConfig.MethodStderr = methods["stderr"]
+		logConfig.MethodSyslog = methods["syslog"]
+		logConfig.MethodJournald = methods["journald"]
+		logConfig.MethodEventlog = methods["eventlog"]
+		if logConfig.MethodSyslog {
+			switch logConfig.SyslogNetwork {
+			case "", "udp", "tcp", "unix", "unixgram":
+			default:
+				return nil, fmt.Errorf("Could not translate syslog network [%s]", logConfig.SyslogNetwork)
+			}
+			if logConfig.SyslogNetwork != "" && logConfig.SyslogAddress == "" {
+				return nil, errors.New("Logging configuration specifies a 'syslog-network' but 'syslog-address' is empty")
+			}
+		}
+		if logConfig.Tag == "" {
+			logConfig.Tag = "oragono"
+		}
+
+		// format
+		switch strings.ToLower(logConfig.Format) {
+		case "", "text":
+			logConfig.FormatJSON = false
+		case "json":
+			logConfig.FormatJSON = true
+		default:
+			return nil, fmt.Errorf("Could not translate log format [%s]", logConfig.Format)
+		}
 
 		// levels
 		level, exists := logger.LogLevelNames[strings.ToLower(logConfig.LevelString)]
@@ -481,6 +1023,21 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse maximum SendQ size (make sure it only contains whole numbers): %s", err.Error())
 	}
+	if config.Server.HelpPageLines < 0 {
+		return nil, fmt.Errorf("help-page-lines can't be negative")
+	}
+	if config.History.ChannelLength < 0 || config.History.ClientLength < 0 {
+		return nil, fmt.Errorf("channel-length and client-length can't be negative")
+	}
+	if config.History.ConversationLength < 0 || config.History.ConversationsPerAccount < 0 {
+		return nil, fmt.Errorf("conversation-length and conversations-per-account can't be negative")
+	}
+	if config.History.ChathistoryMax == 0 {
+		config.History.ChathistoryMax = 100
+	}
+	if config.History.ChathistoryMax < 0 {
+		return nil, fmt.Errorf("chathistory-maxmessages can't be negative")
+	}
 
 	return config, nil
 }
@@ -0,0 +1,156 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+)
+
+// Check goes over the (already loaded) config and returns every problem it can find
+// with it, beyond the basic checks LoadConfig does. This is used by `oragono checkconf`,
+// so it tries to be thorough and return errors that are easy to act on.
+func (conf *Config) Check() []error {
+	var errs []error
+
+	// listeners
+	listeners := make(map[string]bool)
+	for _, addr := range conf.Server.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("Listener [%s] is not a valid address: %s", addr, err.Error()))
+		}
+		if listeners[addr] {
+			errs = append(errs, fmt.Errorf("Listener [%s] is listed more than once", addr))
+		}
+		listeners[addr] = true
+	}
+	for addr := range conf.Server.WebSocketListeners {
+		if !listeners[addr] {
+			errs = append(errs, fmt.Errorf("WebSocket listener [%s] is not in the list of listeners", addr))
+		}
+	}
+	for addr := range conf.Server.ListenerPasswords {
+		if !listeners[addr] {
+			errs = append(errs, fmt.Errorf("Listener [%s] has a password but is not in the list of listeners", addr))
+		}
+	}
+	for _, addr := range conf.Accounts.RequireSasl.Listeners {
+		if !listeners[addr] {
+			errs = append(errs, fmt.Errorf("require-sasl listener [%s] is not in the list of listeners", addr))
+		}
+	}
+	for _, class := range conf.Server.ConnectionClasses {
+		for _, addr := range class.Listeners {
+			if !listeners[addr] {
+				errs = append(errs, fmt.Errorf("Connection class %s listener [%s] is not in the list of listeners", class.Name, addr))
+			}
+		}
+	}
+	if conf.Server.RestAPI.Enabled && listeners[conf.Server.RestAPI.Listen] {
+		errs = append(errs, fmt.Errorf("rest-api listener [%s] is also used as a regular listener", conf.Server.RestAPI.Listen))
+	}
+
+	// tls
+	for addr, tlsConf := range conf.Server.TLSListeners {
+		if !listeners[addr] {
+			errs = append(errs, fmt.Errorf("TLS listener [%s] is not in the list of listeners", addr))
+		}
+		if _, err := os.Stat(tlsConf.Cert); err != nil {
+			errs = append(errs, fmt.Errorf("TLS listener [%s] cert could not be read: %s", addr, err.Error()))
+			continue
+		}
+		if _, err := os.Stat(tlsConf.Key); err != nil {
+			errs = append(errs, fmt.Errorf("TLS listener [%s] key could not be read: %s", addr, err.Error()))
+			continue
+		}
+		if _, err := tlsConf.Config(); err != nil {
+			errs = append(errs, fmt.Errorf("TLS listener [%s] cert and key could not be loaded: %s", addr, err.Error()))
+		}
+	}
+
+	// motd
+	for _, filename := range conf.MOTDFiles() {
+		if _, err := os.Stat(filename); err != nil {
+			errs = append(errs, fmt.Errorf("MOTD file could not be read: %s", err.Error()))
+		}
+	}
+
+	// passwords
+	if conf.Server.Password != "" {
+		if err := checkPasswordHash(conf.Server.Password); err != nil {
+			errs = append(errs, fmt.Errorf("Server password is not a valid hash: %s", err.Error()))
+		}
+	}
+	for addr, password := range conf.Server.ListenerPasswords {
+		if err := checkPasswordHash(password); err != nil {
+			errs = append(errs, fmt.Errorf("Password for listener [%s] is not a valid hash (generate one with `oragono genpasswd`): %s", addr, err.Error()))
+		}
+	}
+	passwordsOkay := true
+	for name, opConf := range conf.Opers {
+		if err := checkPasswordHash(opConf.Password); err != nil {
+			errs = append(errs, fmt.Errorf("Oper [%s] password is not a valid hash (generate one with `oragono genpasswd`): %s", name, err.Error()))
+			passwordsOkay = false
+		}
+	}
+
+	// opers and operclasses
+	operclasses, err := conf.OperatorClasses()
+	if err != nil {
+		errs = append(errs, err)
+	} else if passwordsOkay {
+		// this bails out completely on bad password hashes, so only check when they're fine
+		if _, err := conf.Operators(operclasses); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// name patterns and filters, each one compiled separately so we can list every bad one
+	for _, pattern := range conf.ReservedNames.RawNicknames {
+		if _, err := compileNamePatterns([]string{pattern}); err != nil {
+			errs = append(errs, fmt.Errorf("Reserved nickname %s", err.Error()))
+		}
+	}
+	for _, pattern := range conf.ReservedNames.RawChannels {
+		if _, err := compileNamePatterns([]string{pattern}); err != nil {
+			errs = append(errs, fmt.Errorf("Reserved channel name %s", err.Error()))
+		}
+	}
+	for _, raw := range conf.Server.QuitFilter.RawSpamfilters {
+		if _, err := regexp.Compile(raw); err != nil {
+			errs = append(errs, fmt.Errorf("quit-filter spamfilter [%s] is not valid: %s", raw, err.Error()))
+		}
+	}
+	for _, raw := range conf.AbuseReports.RawDroneSignatures {
+		if _, err := regexp.Compile(raw); err != nil {
+			errs = append(errs, fmt.Errorf("abuse-reports drone signature [%s] is not valid: %s", raw, err.Error()))
+		}
+	}
+
+	// connection limits and throttling
+	if _, err := NewConnectionLimits(conf.Server.ConnectionLimits); err != nil {
+		errs = append(errs, fmt.Errorf("connection-limits are invalid: %s", err.Error()))
+	}
+	if _, err := NewConnectionThrottle(conf.Server.ConnectionThrottle); err != nil {
+		errs = append(errs, fmt.Errorf("connection-throttling is invalid: %s", err.Error()))
+	}
+	if reader, err := openGeoIP(conf.Server.GeoIP); err != nil {
+		errs = append(errs, fmt.Errorf("geoip is invalid: %s", err.Error()))
+	} else {
+		reader.Close()
+	}
+
+	return errs
+}
+
+// checkPasswordHash confirms that the given encoded password hash is one we can use.
+func checkPasswordHash(encoded string) error {
+	hash, err := DecodePasswordHash(encoded)
+	if err != nil {
+		return err
+	}
+	return checkPasswordHashBytes(hash)
+}
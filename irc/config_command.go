@@ -0,0 +1,444 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/sno"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	errConfigKeyNotFound = errors.New("No such config key")
+	errConfigNotBool     = errors.New("Value must be true or false")
+	errConfigNotNumber   = errors.New("Value must be a whole number, 0 or greater")
+)
+
+// safeConfigKeys are the config keys whose values (along with everything under them)
+// can be shown to opers. Everything else is hidden, so that new config keys holding
+// passwords, API keys and the like aren't shown by accident.
+var safeConfigKeys = []string{
+	"network",
+	"admin",
+	"server.name",
+	"server.listen",
+	"server.ws-listen",
+	"server.sts",
+	"server.check-ident",
+	"server.motd-formatting",
+	"server.motd-strip-formatting",
+	"server.help-page-lines",
+	"server.max-sendq",
+	"server.connection-limits",
+	"server.connection-throttling",
+	"server.unregistered-connections",
+	"server.shutdown",
+	"server.quit-filter",
+	"server.nick-flood",
+	"server.ctcp-flood",
+	"server.client-tags",
+	"server.whois-channels",
+	"server.user-count-privacy",
+	"server.connection-classes",
+	"server.default-user-modes",
+	"server.isupport",
+	"languages.enabled",
+	"languages.default",
+	"accounts.registration.enabled",
+	"accounts.registration.enabled-callbacks",
+	"accounts.authentication-enabled",
+	"accounts.auto-away",
+	"accounts.certfp-auto-login",
+	"accounts.require-sasl",
+	"channels",
+	"roleplay",
+	"oper-classes",
+	"reserved-names",
+	"aliases",
+	"help-topics",
+	"history",
+	"limits",
+}
+
+// isSafeConfigKey returns true if the given config key is, or is under, a safe key.
+func isSafeConfigKey(key string) bool {
+	for _, safeKey := range safeConfigKeys {
+		if key == safeKey || strings.HasPrefix(key, safeKey+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSafeConfigKeys returns true if there are safe config keys under the given key.
+func hasSafeConfigKeys(key string) bool {
+	for _, safeKey := range safeConfigKeys {
+		if key == "" || strings.HasPrefix(safeKey, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configKeyPath returns the given dotted config key as it's checked against
+// safeConfigKeys, without any list indexes.
+func configKeyPath(key string) string {
+	var parts []string
+	for _, part := range strings.Split(strings.ToLower(key), ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// configOverride is a config key that opers can change at runtime, until the next rehash.
+type configOverride struct {
+	get func(server *Server) string
+	set func(server *Server, value string) error
+}
+
+// configOverrides are the config keys that opers can change at runtime.
+var configOverrides = map[string]configOverride{
+	"accounts.registration.enabled": {
+		get: func(server *Server) string {
+			return strconv.FormatBool(server.AccountRegistration().Enabled)
+		},
+		set: func(server *Server, value string) error {
+			enabled, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			// copy the registration settings rather than changing the ones in use
+			server.configMutex.Lock()
+			accountReg := *server.accountRegistration
+			accountReg.Enabled = enabled
+			server.accountRegistration = &accountReg
+			server.configMutex.Unlock()
+			return nil
+		},
+	},
+	"channels.default-modes": {
+		get: func(server *Server) string {
+			return "+" + server.DefaultChannelModes().String()
+		},
+		set: func(server *Server, value string) error {
+			modes, err := ParseDefaultChannelModes(value)
+			if err != nil {
+				return err
+			}
+			server.configMutex.Lock()
+			server.defaultChannelModes = modes
+			server.configMutex.Unlock()
+			return nil
+		},
+	},
+	"channels.registration.enabled": {
+		get: func(server *Server) string {
+			return strconv.FormatBool(server.ChannelRegistrationEnabled())
+		},
+		set: func(server *Server, value string) error {
+			enabled, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			server.configMutex.Lock()
+			server.channelRegistrationEnabled = enabled
+			server.configMutex.Unlock()
+			return nil
+		},
+	},
+	"limits.max-channels-per-client": {
+		get: func(server *Server) string {
+			return strconv.Itoa(server.Limits().MaxChannels)
+		},
+		set: func(server *Server, value string) error {
+			max, err := parseConfigNumber(value)
+			if err != nil {
+				return err
+			}
+			server.configMutex.Lock()
+			server.limits.MaxChannels = max
+			server.configMutex.Unlock()
+			return nil
+		},
+	},
+	"server.connection-throttling.max-connections": {
+		get: func(server *Server) string {
+			server.connectionThrottleMutex.Lock()
+			defer server.connectionThrottleMutex.Unlock()
+			return strconv.Itoa(server.connectionThrottle.subnetLimit)
+		},
+		set: func(server *Server, value string) error {
+			max, err := parseConfigNumber(value)
+			if err != nil {
+				return err
+			}
+			server.connectionThrottleMutex.Lock()
+			server.connectionThrottle.subnetLimit = max
+			server.connectionThrottleMutex.Unlock()
+			return nil
+		},
+	},
+	"server.default-user-modes": {
+		get: func(server *Server) string {
+			return "+" + server.DefaultUserModes().String()
+		},
+		set: func(server *Server, value string) error {
+			modes, err := ParseDefaultUserModes(value)
+			if err != nil {
+				return err
+			}
+			server.configMutex.Lock()
+			server.defaultUserModes = modes
+			server.configMutex.Unlock()
+			return nil
+		},
+	},
+	"server.unregistered-connections.max-per-ip": {
+		get: func(server *Server) string {
+			return strconv.Itoa(server.unregisteredLimits.Max())
+		},
+		set: func(server *Server, value string) error {
+			max, err := parseConfigNumber(value)
+			if err != nil {
+				return err
+			}
+			server.unregisteredLimits.SetMax(max)
+			return nil
+		},
+	},
+}
+
+// parseConfigBool parses a boolean config value.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	return false, errConfigNotBool
+}
+
+// parseConfigNumber parses a non-negative whole number config value.
+func parseConfigNumber(value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, errConfigNotNumber
+	}
+	return number, nil
+}
+
+// lookupConfigKey finds the value of the given dotted config key (such as
+// "server.connection-limits.enabled"), using the same names as the config file.
+func lookupConfigKey(config *Config, key string) (reflect.Value, error) {
+	value := reflect.ValueOf(config)
+	for _, name := range strings.Split(strings.ToLower(key), ".") {
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return value, errConfigKeyNotFound
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			var found bool
+			for i := 0; i < value.NumField(); i++ {
+				field := value.Type().Field(i)
+				if field.PkgPath != "" {
+					continue
+				}
+				fieldName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+				if fieldName == "" {
+					fieldName = strings.ToLower(field.Name)
+				}
+				if fieldName == name {
+					value = value.Field(i)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return value, errConfigKeyNotFound
+			}
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return value, errConfigKeyNotFound
+			}
+			var found bool
+			for _, mapKey := range value.MapKeys() {
+				if strings.ToLower(mapKey.String()) == name {
+					value = value.MapIndex(mapKey)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return value, errConfigKeyNotFound
+			}
+		case reflect.Slice:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || value.Len() <= index {
+				return value, errConfigKeyNotFound
+			}
+			value = value.Index(index)
+		default:
+			return value, errConfigKeyNotFound
+		}
+	}
+	return value, nil
+}
+
+// filterConfigTree removes everything that isn't safe to show from the given config tree,
+// which is found at the given config key.
+func filterConfigTree(tree interface{}, path string) interface{} {
+	switch tree := tree.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range tree {
+			keyString, _ := key.(string)
+			keyPath := strings.ToLower(keyString)
+			if path != "" {
+				keyPath = path + "." + keyPath
+			}
+			if isSafeConfigKey(keyPath) {
+				continue
+			} else if hasSafeConfigKeys(keyPath) {
+				tree[key] = filterConfigTree(value, keyPath)
+			} else {
+				delete(tree, key)
+			}
+		}
+	case []interface{}:
+		for i, value := range tree {
+			tree[i] = filterConfigTree(value, path)
+		}
+	}
+	return tree
+}
+
+// configKeyLines returns the value of the given config key, formatted as YAML lines.
+func configKeyLines(config *Config, key string) ([]string, error) {
+	path := configKeyPath(key)
+	if !isSafeConfigKey(path) && !hasSafeConfigKeys(path) {
+		return []string{"<redacted>"}, nil
+	}
+
+	value, err := lookupConfigKey(config, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// round-trip the value through a generic tree, so we can redact it
+	encoded, err := yaml.Marshal(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	err = yaml.Unmarshal(encoded, &tree)
+	if err != nil {
+		return nil, err
+	}
+	if !isSafeConfigKey(path) {
+		tree = filterConfigTree(tree, path)
+	}
+	encoded, err = yaml.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(encoded), "\n"), "\n"), nil
+}
+
+// CONFIG GET <key>
+// CONFIG SET <key> <value>
+// CONFIG LIST
+func configHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	// rehashes replace the config and undo our overrides, so don't race with them
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	subcommand := strings.ToUpper(msg.Params[0])
+	switch subcommand {
+	case "GET":
+		if len(msg.Params) < 2 {
+			client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, "CONFIG", client.t("Not enough parameters"))
+			return false
+		}
+		key := strings.ToLower(msg.Params[1])
+		override, isOverridable := configOverrides[key]
+		if isOverridable {
+			client.Notice(fmt.Sprintf("%s = %s", key, override.get(server)))
+			return false
+		}
+		lines, err := configKeyLines(server.Config(), key)
+		if err != nil {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CONFIG", key, err.Error())
+			return false
+		}
+		if len(lines) == 1 {
+			client.Notice(fmt.Sprintf("%s = %s", key, lines[0]))
+			return false
+		}
+		client.Notice(fmt.Sprintf("%s =", key))
+		for _, line := range lines {
+			client.Notice("  " + line)
+		}
+
+	case "SET":
+		if len(msg.Params) < 3 {
+			client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, "CONFIG", client.t("Not enough parameters"))
+			return false
+		}
+		key := strings.ToLower(msg.Params[1])
+		override, isOverridable := configOverrides[key]
+		if !isOverridable {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CONFIG", key, "This config key can't be changed at runtime")
+			return false
+		}
+		err := override.set(server, msg.Params[2])
+		if err != nil {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CONFIG", key, err.Error())
+			return false
+		}
+		value := override.get(server)
+		server.configMutex.Lock()
+		server.configOverrides[key] = value
+		server.configMutex.Unlock()
+		client.Notice(fmt.Sprintf("%s = %s (until the next rehash)", key, value))
+		server.logger.Info("opers", fmt.Sprintf("Client %s set config key %s to %s", client.nick, key, value))
+		server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] set config key $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), client.nickMaskString, key, value))
+
+	case "LIST":
+		var keys []string
+		for key := range configOverrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		client.Notice("Config keys that can be changed at runtime:")
+		for _, key := range keys {
+			value := configOverrides[key].get(server)
+			server.configMutex.RLock()
+			_, overridden := server.configOverrides[key]
+			server.configMutex.RUnlock()
+			if overridden {
+				client.Notice(fmt.Sprintf("  %s = %s (overridden)", key, value))
+			} else {
+				client.Notice(fmt.Sprintf("  %s = %s", key, value))
+			}
+		}
+
+	default:
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CONFIG", subcommand, "Unknown subcommand")
+	}
+	return false
+}
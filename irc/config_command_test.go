@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigKeyLines(t *testing.T) {
+	var config Config
+	config.Server.Name = "oragono.test"
+	config.Server.Password = "hunter2"
+	config.Server.Listen = []string{":6667"}
+	config.Datastore.Path = "ircd.db"
+	config.Limits.NickLen = 32
+
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"limits.nicklen", "32"},
+		{"server.listen.0", ":6667"},
+		{"server.password", "<redacted>"},
+		{"datastore", "<redacted>"},
+		{"datastore.path", "<redacted>"},
+	}
+	for _, tt := range testCases {
+		lines, err := configKeyLines(&config, tt.key)
+		if err != nil {
+			t.Errorf("couldn't get config key %s: %s", tt.key, err.Error())
+			continue
+		}
+		if strings.Join(lines, "\n") != tt.expected {
+			t.Errorf("expected config key %s to be %q, got %q", tt.key, tt.expected, lines)
+		}
+	}
+
+	// keys that aren't known to be safe are left out of their parents
+	lines, err := configKeyLines(&config, "server")
+	if err != nil {
+		t.Fatalf("couldn't get config key server: %s", err.Error())
+	}
+	shown := strings.Join(lines, "\n")
+	if !strings.Contains(shown, "name: oragono.test") {
+		t.Errorf("expected the server name to be shown, got %q", shown)
+	}
+	if strings.Contains(shown, "hunter2") || strings.Contains(shown, "password") {
+		t.Errorf("expected the server password to be hidden, got %q", shown)
+	}
+}
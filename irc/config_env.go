@@ -0,0 +1,69 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// envReference matches ${NAME} references to environment variables, as well as
+	// $${NAME}, which is how a literal ${NAME} is written.
+	envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// expandEnvironment replaces ${NAME} references in the given config file with the value
+// of the NAME environment variable. If NAME isn't set but NAME_FILE is, the contents of
+// that file are used instead, which is handy for secrets in container deployments.
+// Comment lines are left alone.
+func expandEnvironment(data []byte) ([]byte, error) {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+
+		var expandErr error
+		lines[i] = envReference.ReplaceAllFunc(line, func(reference []byte) []byte {
+			if bytes.HasPrefix(reference, []byte("$$")) {
+				return reference[1:]
+			}
+			name := string(reference[2 : len(reference)-1])
+			value, err := lookupEnvironment(name)
+			if err != nil && expandErr == nil {
+				expandErr = fmt.Errorf("Line %d: %s", i+1, err.Error())
+			}
+			return []byte(value)
+		})
+		if expandErr != nil {
+			return nil, expandErr
+		}
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// lookupEnvironment returns the value of the given environment variable, or the
+// contents of the file named by NAME_FILE.
+func lookupEnvironment(name string) (string, error) {
+	value, exists := os.LookupEnv(name)
+	if exists {
+		return value, nil
+	}
+
+	filename, exists := os.LookupEnv(name + "_FILE")
+	if exists {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("Could not read %s_FILE: %s", name, err.Error())
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+
+	return "", fmt.Errorf("Environment variable %s is not set", name)
+}
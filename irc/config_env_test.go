@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExpandEnvironment(t *testing.T) {
+	secretFile, err := ioutil.TempFile("", "oragono-env-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secretFile.Name())
+	secretFile.WriteString("hunter2\n")
+	secretFile.Close()
+
+	os.Setenv("ORAGONO_TEST_NAME", "irc.example.com")
+	os.Setenv("ORAGONO_TEST_SECRET_FILE", secretFile.Name())
+	os.Unsetenv("ORAGONO_TEST_MISSING")
+	os.Unsetenv("ORAGONO_TEST_MISSING_FILE")
+	defer os.Unsetenv("ORAGONO_TEST_NAME")
+	defer os.Unsetenv("ORAGONO_TEST_SECRET_FILE")
+
+	testCases := []struct {
+		input    string
+		expected string
+		err      bool
+	}{
+		{"name: ${ORAGONO_TEST_NAME}", "name: irc.example.com", false},
+		{"password: \"${ORAGONO_TEST_SECRET}\"", "password: \"hunter2\"", false},
+		{"literal: $${ORAGONO_TEST_NAME}", "literal: ${ORAGONO_TEST_NAME}", false},
+		{"money: $5 and $NAME", "money: $5 and $NAME", false},
+		{"    # ${ORAGONO_TEST_MISSING} in a comment", "    # ${ORAGONO_TEST_MISSING} in a comment", false},
+		{"name: ${ORAGONO_TEST_MISSING}", "", true},
+	}
+
+	for _, testCase := range testCases {
+		result, err := expandEnvironment([]byte(testCase.input))
+		if testCase.err {
+			if err == nil {
+				t.Errorf("expected an error expanding %q, got %q", testCase.input, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error expanding %q: %s", testCase.input, err.Error())
+		} else if string(result) != testCase.expected {
+			t.Errorf("expanding %q: expected %q, got %q", testCase.input, testCase.expected, result)
+		}
+	}
+}
+
+func TestGenerateEnvConfig(t *testing.T) {
+	os.Setenv("ORAGONO_SERVER_NAME", "irc.example.com")
+	os.Setenv("ORAGONO_LISTEN", ":6667, 127.0.0.1:6668")
+	os.Setenv("ORAGONO_TLS_LISTEN", ":6697")
+	defer os.Unsetenv("ORAGONO_SERVER_NAME")
+	defer os.Unsetenv("ORAGONO_LISTEN")
+	defer os.Unsetenv("ORAGONO_TLS_LISTEN")
+
+	config, err := LoadConfig(EnvConfigFilename)
+	if err != nil {
+		t.Fatalf("loading config from the environment: %s", err.Error())
+	}
+	if config.Server.Name != "irc.example.com" {
+		t.Errorf("expected server name irc.example.com, got %s", config.Server.Name)
+	}
+	if len(config.Server.Listen) != 3 || config.Server.Listen[2] != ":6697" {
+		t.Errorf("expected three listeners ending with the TLS one, got %v", config.Server.Listen)
+	}
+	if config.Server.TLSListeners[":6697"] == nil {
+		t.Error("expected a TLS listener on :6697")
+	}
+	if config.Datastore.Path != EnvSettings["DATASTORE"] {
+		t.Errorf("expected the default datastore path, got %s", config.Datastore.Path)
+	}
+	if len(config.Opers) != 0 {
+		t.Errorf("expected no opers without an oper password, got %d", len(config.Opers))
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvConfigFilename is used in place of a config filename when the config is built
+// from environment variables, rather than being loaded from a file.
+const EnvConfigFilename = "<environment>"
+
+// envSettingPrefix starts the names of the environment variables we build configs from.
+const envSettingPrefix = "ORAGONO_"
+
+// EnvSettings are the settings that can be given when building the config from the
+// environment, along with their defaults. Each one is read from ORAGONO_<NAME> (or
+// ORAGONO_<NAME>_FILE), such as ORAGONO_SERVER_NAME.
+var EnvSettings = map[string]string{
+	"DATASTORE":     "ircd.db",
+	"LISTEN":        ":6667",
+	"LOG_LEVEL":     "info",
+	"MOTD":          "",
+	"NETWORK_NAME":  "Oragono",
+	"OPER_NAME":     "admin",
+	"OPER_PASSWORD": "",
+	"PASSWORD":      "",
+	"SERVER_NAME":   "oragono.test",
+	"TLS_CERT":      "tls.crt",
+	"TLS_KEY":       "tls.key",
+	"TLS_LISTEN":    "",
+}
+
+// EnvSettingName returns the environment variable for the given setting, which can be
+// written the same way as its command-line flag (such as server-name).
+func EnvSettingName(setting string) string {
+	name := strings.ToUpper(strings.Replace(setting, "-", "_", -1))
+	return envSettingPrefix + strings.TrimPrefix(name, envSettingPrefix)
+}
+
+// envSetting returns the value of the given setting, or its default if it isn't set.
+func envSetting(setting string) (string, error) {
+	name := EnvSettingName(setting)
+	_, isSet := os.LookupEnv(name)
+	_, fileIsSet := os.LookupEnv(name + "_FILE")
+	if !isSet && !fileIsSet {
+		return EnvSettings[setting], nil
+	}
+	value, err := lookupEnvironment(name)
+	return strings.TrimSpace(value), err
+}
+
+// envList splits a setting that holds several values, such as the listeners.
+func envList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// generateEnvConfig builds a config file from the ORAGONO_* environment variables, filling
+// in sane defaults for everything else. This lets us run without a config file at all,
+// which makes Docker and one-off test servers simple to set up.
+func generateEnvConfig() ([]byte, error) {
+	settings := make(map[string]string)
+	for setting := range EnvSettings {
+		value, err := envSetting(setting)
+		if err != nil {
+			return nil, err
+		}
+		settings[setting] = value
+	}
+
+	server := map[string]interface{}{
+		"name":      settings["SERVER_NAME"],
+		"listen":    envList(settings["LISTEN"]),
+		"max-sendq": "16k",
+	}
+	tlsListeners := make(map[string]interface{})
+	for _, address := range envList(settings["TLS_LISTEN"]) {
+		tlsListeners[address] = map[string]string{
+			"cert": settings["TLS_CERT"],
+			"key":  settings["TLS_KEY"],
+		}
+		server["listen"] = append(server["listen"].([]string), address)
+	}
+	server["tls-listeners"] = tlsListeners
+	if settings["MOTD"] != "" {
+		server["motd"] = settings["MOTD"]
+	}
+
+	config := map[string]interface{}{
+		"network": map[string]interface{}{
+			"name": settings["NETWORK_NAME"],
+		},
+		"server": server,
+		"datastore": map[string]interface{}{
+			"path": settings["DATASTORE"],
+		},
+		"accounts": map[string]interface{}{
+			"registration": map[string]interface{}{
+				"enabled":           true,
+				"verify-timeout":    "120h",
+				"enabled-callbacks": []string{"none"},
+			},
+			"authentication-enabled": true,
+		},
+		"channels": map[string]interface{}{
+			"registration": map[string]interface{}{
+				"enabled": true,
+			},
+		},
+		"logging": []interface{}{
+			map[string]interface{}{
+				"method": "stderr",
+				"type":   "* -userinput -useroutput",
+				"level":  settings["LOG_LEVEL"],
+			},
+		},
+		"limits": map[string]interface{}{
+			"accept-entries":          100,
+			"nicklen":                 32,
+			"channellen":              64,
+			"awaylen":                 500,
+			"kicklen":                 1000,
+			"topiclen":                1000,
+			"max-channels-per-client": 100,
+			"monitor-entries":         100,
+			"whowas-entries":          100,
+			"chan-list-modes":         60,
+			"linelen": map[string]interface{}{
+				"tags": 2048,
+				"rest": 2048,
+			},
+		},
+	}
+
+	// passwords are given in plain text, so they're easy to set
+	var hashing PasswordHashingConfig
+	err := hashing.parse()
+	if err != nil {
+		return nil, err
+	}
+	if settings["PASSWORD"] != "" {
+		hash, err := GenerateEncodedPassword(settings["PASSWORD"], hashing)
+		if err != nil {
+			return nil, fmt.Errorf("Could not hash the server password: %s", err.Error())
+		}
+		server["password"] = hash
+	}
+	if settings["OPER_PASSWORD"] != "" {
+		hash, err := GenerateEncodedPassword(settings["OPER_PASSWORD"], hashing)
+		if err != nil {
+			return nil, fmt.Errorf("Could not hash the oper password: %s", err.Error())
+		}
+		config["oper-classes"] = map[string]interface{}{
+			"server-admin": map[string]interface{}{
+				"title": "Server Admin",
+				"capabilities": []string{
+					"oper:local_kill", "oper:local_ban", "oper:local_unban",
+					"oper:remote_kill", "oper:remote_ban", "oper:remote_unban",
+					"oper:rehash", "oper:die", "oper:loglevel", "oper:vhosts", "samode",
+				},
+			},
+		}
+		config["opers"] = map[string]interface{}{
+			settings["OPER_NAME"]: map[string]interface{}{
+				"class":    "server-admin",
+				"password": hash,
+			},
+		}
+	}
+
+	return yaml.Marshal(config)
+}
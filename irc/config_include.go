@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// includesKey is the top-level config key that lists other config files to load.
+const includesKey = "includes"
+
+// loadConfigData reads the given config file along with every file it includes, and
+// returns them merged into a single YAML document. Included files are merged on top of
+// the file that includes them, in the order they're listed, so values in later files
+// win. Relative include paths are relative to the including file.
+//
+// If the filename is EnvConfigFilename, the config is built from the environment instead.
+func loadConfigData(filename string) ([]byte, error) {
+	if filename == EnvConfigFilename {
+		return generateEnvConfig()
+	}
+
+	data, tree, err := readConfigFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// only round-trip the config through the YAML encoder if we have to
+	_, hasIncludes := tree[includesKey]
+	if !hasIncludes {
+		return data, nil
+	}
+
+	err = includeConfigFiles(filename, tree, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+// readConfigFile reads and parses the given config file, substituting environment
+// variables as it goes.
+func readConfigFile(filename string) ([]byte, map[interface{}]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err = expandEnvironment(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not substitute environment variables in %s: %s", filename, err.Error())
+	}
+
+	tree := make(map[interface{}]interface{})
+	err = yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not parse %s: %s", filename, err.Error())
+	}
+	return data, tree, nil
+}
+
+// includeConfigFiles merges the files included by the given config file into its tree.
+// including holds the files currently being loaded, so we can catch include loops.
+func includeConfigFiles(filename string, tree map[interface{}]interface{}, including map[string]bool) error {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if including[absFilename] {
+		return fmt.Errorf("Config file %s includes itself", filename)
+	}
+	including[absFilename] = true
+	defer delete(including, absFilename)
+
+	includesValue, exists := tree[includesKey]
+	if !exists {
+		return nil
+	}
+	delete(tree, includesKey)
+
+	includes, isList := includesValue.([]interface{})
+	if !isList {
+		return fmt.Errorf("%s: %s must be a list of filenames", filename, includesKey)
+	}
+	for _, includeValue := range includes {
+		includeName, isString := includeValue.(string)
+		if !isString {
+			return fmt.Errorf("%s: %s must be a list of filenames", filename, includesKey)
+		}
+		if !filepath.IsAbs(includeName) {
+			includeName = filepath.Join(filepath.Dir(filename), includeName)
+		}
+
+		_, included, err := readConfigFile(includeName)
+		if err != nil {
+			return err
+		}
+		err = includeConfigFiles(includeName, included, including)
+		if err != nil {
+			return err
+		}
+		mergeConfigTrees(tree, included)
+	}
+
+	return nil
+}
+
+// mergeConfigTrees merges src into dst. Sections that appear in both are merged key by
+// key, and anything else (including lists) in src replaces what's in dst.
+func mergeConfigTrees(dst, src map[interface{}]interface{}) {
+	for key, srcValue := range src {
+		srcSection, srcIsSection := srcValue.(map[interface{}]interface{})
+		dstSection, dstIsSection := dst[key].(map[interface{}]interface{})
+		if srcIsSection && dstIsSection {
+			mergeConfigTrees(dstSection, srcSection)
+		} else {
+			dst[key] = srcValue
+		}
+	}
+}
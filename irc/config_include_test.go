@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadConfigData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-include-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"ircd.yaml": `
+includes:
+    - listeners.yaml
+    - conf.d/opers.yaml
+server:
+    name: irc.example.com
+    listen:
+        - ":6667"
+opers:
+    alice:
+        class: server-admin
+`,
+		"listeners.yaml": `
+server:
+    listen:
+        - ":6697"
+`,
+		"conf.d/opers.yaml": `
+opers:
+    bob:
+        class: local-oper
+`,
+		"loop.yaml": `
+includes:
+    - loop.yaml
+`,
+	}
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	for name, contents := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := loadConfigData(filepath.Join(dir, "ircd.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Includes []string
+		Server   struct {
+			Name   string
+			Listen []string
+		}
+		Opers map[string]struct {
+			Class string
+		}
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Name != "irc.example.com" {
+		t.Errorf("expected server name from the main file to be kept, got %q", config.Server.Name)
+	}
+	if len(config.Server.Listen) != 1 || config.Server.Listen[0] != ":6697" {
+		t.Errorf("expected listeners to be replaced by the included file, got %v", config.Server.Listen)
+	}
+	if config.Opers["alice"].Class != "server-admin" || config.Opers["bob"].Class != "local-oper" {
+		t.Errorf("expected opers from both files to be merged, got %v", config.Opers)
+	}
+	if len(config.Includes) != 0 {
+		t.Errorf("expected includes to be removed from the merged config, got %v", config.Includes)
+	}
+
+	_, err = loadConfigData(filepath.Join(dir, "loop.yaml"))
+	if err == nil {
+		t.Error("expected an error for a config file that includes itself")
+	}
+}
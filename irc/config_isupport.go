@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	errISupportNeedsValue   = errors.New("Token needs a value")
+	errISupportBadNumber    = errors.New("Value must be a whole number, 1 or greater")
+	errISupportBadValue     = errors.New("Value can't contain spaces")
+	errISupportBadChanLimit = errors.New("Value must look like #:<number>")
+	errISupportBadMaxList   = errors.New("Value must look like beI:<number>")
+)
+
+// isupportLimitTokens are the ISUPPORT tokens that can be set in the isupport config
+// section, along with how to apply each one to the limits we actually enforce.
+var isupportLimitTokens = map[string]func(config *Config, value string) error{
+	"AWAYLEN": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.AwayLen)
+	},
+	"CHANLIMIT": func(config *Config, value string) error {
+		if !strings.HasPrefix(value, "#:") {
+			return errISupportBadChanLimit
+		}
+		// no number means unlimited
+		if value == "#:" {
+			config.Limits.MaxChannels = 0
+			return nil
+		}
+		return parseISupportNumber(value[2:], &config.Limits.MaxChannels)
+	},
+	"CHANNELLEN": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.ChannelLen)
+	},
+	"KICKLEN": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.KickLen)
+	},
+	"MAXLIST": func(config *Config, value string) error {
+		if !strings.HasPrefix(value, "beI:") {
+			return errISupportBadMaxList
+		}
+		return parseISupportNumber(value[4:], &config.Limits.ChanListModes)
+	},
+	"MAXTARGETS": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.MaxTargets)
+	},
+	"MONITOR": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.MonitorEntries)
+	},
+	"NETWORK": func(config *Config, value string) error {
+		config.Network.Name = value
+		return nil
+	},
+	"NICKLEN": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.NickLen)
+	},
+	"TOPICLEN": func(config *Config, value string) error {
+		return parseISupportNumber(value, &config.Limits.TopicLen)
+	},
+}
+
+// isupportFixedTokens describe how the server itself works, so they can't be
+// changed from the config.
+var isupportFixedTokens = map[string]bool{
+	"BOT":          true,
+	"CALLERID":     true,
+	"CASEMAPPING":  true,
+	"CHANMODES":    true,
+	"CHANTYPES":    true,
+	"CNOTICE":      true,
+	"CPRIVMSG":     true,
+	"ELIST":        true,
+	"EXCEPTS":      true,
+	"EXTBAN":       true,
+	"INVEX":        true,
+	"LANGUAGE":     true,
+	"MODES":        true,
+	"PREFIX":       true,
+	"REGCALLBACKS": true,
+	"REGCOMMANDS":  true,
+	"REGCREDTYPES": true,
+	"RPCHAN":       true,
+	"RPUSER":       true,
+	"STATUSMSG":    true,
+	"TARGMAX":      true,
+	"WALLCHOPS":    true,
+}
+
+// parseISupportNumber parses a positive whole number ISUPPORT value into the given limit.
+func parseISupportNumber(value string, limit *uint) error {
+	number, err := strconv.ParseUint(value, 10, 0)
+	if err != nil || number < 1 {
+		return errISupportBadNumber
+	}
+	*limit = uint(number)
+	return nil
+}
+
+// isValidISupportName returns true if the given string can be used as an ISUPPORT token name.
+func isValidISupportName(name string) bool {
+	if name == "" || 20 < len(name) {
+		return false
+	}
+	for _, char := range name {
+		if !('A' <= char && char <= 'Z') && !('0' <= char && char <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyISupportOverrides applies the tokens in the isupport config section. Tokens that
+// match one of our limits change that limit, and any others are advertised as they are.
+func (conf *Config) applyISupportOverrides() error {
+	conf.Server.ISupport = make(map[string]*string)
+	seen := make(map[string]bool)
+
+	for name, value := range conf.Server.RawISupport {
+		token := strings.ToUpper(name)
+		if !isValidISupportName(token) {
+			return fmt.Errorf("ISUPPORT token name [%s] is not valid", name)
+		}
+		if seen[token] {
+			return fmt.Errorf("ISUPPORT token %s is given more than once", token)
+		}
+		seen[token] = true
+		if isupportFixedTokens[token] {
+			return fmt.Errorf("ISUPPORT token %s describes how the server works, so it can't be changed", token)
+		}
+		if value != nil && strings.ContainsAny(*value, " \r\n\x00") {
+			return fmt.Errorf("Could not use ISUPPORT token %s: %s", token, errISupportBadValue.Error())
+		}
+
+		apply, isLimit := isupportLimitTokens[token]
+		if !isLimit {
+			conf.Server.ISupport[token] = value
+			continue
+		}
+		if value == nil || *value == "" {
+			return fmt.Errorf("Could not use ISUPPORT token %s: %s", token, errISupportNeedsValue.Error())
+		}
+		err := apply(conf, *value)
+		if err != nil {
+			return fmt.Errorf("Could not use ISUPPORT token %s: %s", token, err.Error())
+		}
+	}
+
+	return nil
+}
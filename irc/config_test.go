@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadShippedConfig(t *testing.T) {
+	_, err := LoadConfig("../oragono.yaml")
+	if err != nil {
+		t.Errorf("couldn't load the example config: %s", err.Error())
+	}
+}
+
+func TestCheckListenerKeys(t *testing.T) {
+	var config Config
+	config.Server.Listen = []string{":6667", ":6697"}
+	config.Server.ListenerPasswords = map[string]string{
+		":6697": "",
+		":6668": "",
+	}
+	config.Server.WebSocketListeners = map[string]*WebSocketListenConfig{
+		":8097": {},
+	}
+	config.Accounts.RequireSasl.Listeners = []string{":6697", ":6669"}
+	config.Server.ConnectionClasses = []ConnectionClassConfig{
+		{Name: "web", Listeners: []string{":8097"}},
+	}
+
+	var listenerErrs []string
+	for _, err := range config.Check() {
+		if strings.Contains(err.Error(), "is not in the list of listeners") {
+			listenerErrs = append(listenerErrs, err.Error())
+		}
+	}
+	expected := []string{
+		"WebSocket listener [:8097] is not in the list of listeners",
+		"Listener [:6668] has a password but is not in the list of listeners",
+		"require-sasl listener [:6669] is not in the list of listeners",
+		"Connection class web listener [:8097] is not in the list of listeners",
+	}
+	if strings.Join(listenerErrs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected listener errors %q, got %q", expected, listenerErrs)
+	}
+}
+
+func TestCheckPatterns(t *testing.T) {
+	var config Config
+	config.ReservedNames.RawNicknames = []string{"staff-*", "/[/", "/(/"}
+	config.ReservedNames.RawChannels = []string{"#staff"}
+	config.Server.QuitFilter.RawSpamfilters = []string{"spam", "a**"}
+
+	var patternErrs []string
+	for _, err := range config.Check() {
+		if strings.Contains(err.Error(), "is not valid") {
+			patternErrs = append(patternErrs, err.Error())
+		}
+	}
+	if len(patternErrs) != 3 {
+		t.Errorf("expected each bad pattern to be reported, got %q", patternErrs)
+	}
+}
+
+func TestNegativeHistoryLengths(t *testing.T) {
+	shipped, err := ioutil.ReadFile("../oragono.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "oragono-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, setting := range []string{"channel-length: 256", "client-length: 64", "conversation-length: 128", "conversations-per-account: 32"} {
+		name := strings.Split(setting, ":")[0]
+		data := strings.Replace(string(shipped), setting, name+": -1", 1)
+		filename := filepath.Join(dir, "ircd.yaml")
+		err = ioutil.WriteFile(filename, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadConfig(filename)
+		if err == nil || !strings.Contains(err.Error(), "can't be negative") {
+			t.Errorf("expected a negative %s to be refused, got %v", name, err)
+		}
+	}
+}
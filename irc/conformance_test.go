@@ -0,0 +1,304 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build conformance
+// +build conformance
+
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// these are a few of the irctest conformance checks, run against a real server over a
+// real connection. They're run with `make conformance`, and the full suite is run with
+// `make irctest`.
+
+// conformanceTimeout is how long we wait for the server to answer us.
+const conformanceTimeout = 5 * time.Second
+
+// conformanceServer is shared between the tests, since it takes a little while to start.
+var conformanceServer struct {
+	sync.Once
+	server  *Server
+	dir     string
+	stopped chan bool
+	addr    string
+	err     error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopConformanceServer()
+	os.Exit(code)
+}
+
+// stopConformanceServer shuts down the conformance server, if it was started, and
+// cleans up its datastore.
+func stopConformanceServer() {
+	if conformanceServer.server != nil {
+		conformanceServer.server.RequestShutdown("")
+		<-conformanceServer.stopped
+	}
+	if conformanceServer.dir != "" {
+		os.RemoveAll(conformanceServer.dir)
+	}
+}
+
+// startConformanceServer starts a server configured the same way irctest runs it, and
+// returns the address it's listening on.
+func startConformanceServer(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping conformance tests in short mode")
+	}
+
+	conformanceServer.Do(func() {
+		dir, err := ioutil.TempDir("", "oragono-conformance")
+		if err != nil {
+			conformanceServer.err = err
+			return
+		}
+		conformanceServer.dir = dir
+		settings := map[string]string{
+			"ORAGONO_SERVER_NAME": "My.Little.Server",
+			"ORAGONO_LISTEN":      "127.0.0.1:0",
+			"ORAGONO_DATASTORE":   filepath.Join(dir, "ircd.db"),
+		}
+		for name, value := range settings {
+			os.Setenv(name, value)
+			defer os.Unsetenv(name)
+		}
+
+		config, err := LoadConfig(EnvConfigFilename)
+		if err != nil {
+			conformanceServer.err = err
+			return
+		}
+		InitDB(config.Datastore.Path)
+		logman, _ := logger.NewManager()
+		server, err := NewServer(EnvConfigFilename, config, logman)
+		if err != nil {
+			conformanceServer.err = err
+			return
+		}
+		conformanceServer.server = server
+		conformanceServer.stopped = make(chan bool)
+		go func() {
+			server.Run()
+			close(conformanceServer.stopped)
+		}()
+
+		server.listenerUpdateMutex.Lock()
+		conformanceServer.addr = server.listeners["127.0.0.1:0"].Listener.Addr().String()
+		server.listenerUpdateMutex.Unlock()
+	})
+
+	if conformanceServer.err != nil {
+		t.Fatalf("could not start the conformance server: %s", conformanceServer.err.Error())
+	}
+	return conformanceServer.addr
+}
+
+// conformanceClient is a simple client that talks to the conformance server.
+type conformanceClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newConformanceClient(t *testing.T) *conformanceClient {
+	conn, err := net.Dial("tcp", startConformanceServer(t))
+	if err != nil {
+		t.Fatalf("could not connect to the conformance server: %s", err.Error())
+	}
+	return &conformanceClient{
+		t:      t,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (cc *conformanceClient) send(line string) {
+	cc.conn.SetWriteDeadline(time.Now().Add(conformanceTimeout))
+	_, err := fmt.Fprintf(cc.conn, "%s\r\n", line)
+	if err != nil {
+		cc.t.Fatalf("could not send %q: %s", line, err.Error())
+	}
+}
+
+// expect reads lines until it gets one with the given command, and returns it.
+func (cc *conformanceClient) expect(command string) ircmsg.IrcMessage {
+	for {
+		cc.conn.SetReadDeadline(time.Now().Add(conformanceTimeout))
+		line, err := cc.reader.ReadString('\n')
+		if err != nil {
+			cc.t.Fatalf("expected %s, but could not read a line: %s", command, err.Error())
+		}
+		msg, err := ircmsg.ParseLine(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			cc.t.Fatalf("could not parse line %q: %s", line, err.Error())
+		}
+		if msg.Command == command {
+			return msg
+		}
+	}
+}
+
+// register connects with the given nick and waits for the end of the welcome burst.
+func (cc *conformanceClient) register(nick string) {
+	cc.send("NICK " + nick)
+	cc.send("USER username * * :Realname")
+	cc.expect(RPL_WELCOME)
+	cc.expect(ERR_NOMOTD)
+}
+
+func (cc *conformanceClient) close() {
+	cc.conn.Close()
+}
+
+func TestConformanceRegistration(t *testing.T) {
+	client := newConformanceClient(t)
+	defer client.close()
+
+	client.send("NICK conformreg")
+	client.send("USER username * * :Realname")
+	welcome := client.expect(RPL_WELCOME)
+	if welcome.Prefix != "My.Little.Server" || welcome.Params[0] != "conformreg" {
+		t.Errorf("expected a welcome to conformreg from My.Little.Server, got %v", welcome)
+	}
+	client.expect(RPL_YOURHOST)
+	client.expect(RPL_CREATED)
+	client.expect(RPL_MYINFO)
+	client.expect(RPL_ISUPPORT)
+}
+
+func TestConformanceCapNegotiation(t *testing.T) {
+	client := newConformanceClient(t)
+	defer client.close()
+
+	// registration waits for CAP END
+	client.send("CAP LS 302")
+	client.send("NICK conformcap")
+	client.send("USER username * * :Realname")
+	ls := client.expect("CAP")
+	if ls.Params[1] != "LS" || !strings.Contains(ls.Params[len(ls.Params)-1], "multi-prefix") {
+		t.Errorf("expected CAP LS to list multi-prefix, got %v", ls)
+	}
+	client.send("CAP REQ :multi-prefix")
+	ack := client.expect("CAP")
+	if ack.Params[1] != "ACK" || strings.TrimSpace(ack.Params[2]) != "multi-prefix" {
+		t.Errorf("expected multi-prefix to be ACKed, got %v", ack)
+	}
+	client.send("CAP END")
+	client.expect(RPL_WELCOME)
+}
+
+func TestConformancePing(t *testing.T) {
+	client := newConformanceClient(t)
+	defer client.close()
+	client.register("conformping")
+
+	client.send("PING :abcdef")
+	pong := client.expect("PONG")
+	if pong.Params[len(pong.Params)-1] != "abcdef" {
+		t.Errorf("expected PONG to give back our token, got %v", pong)
+	}
+}
+
+func TestConformanceNickInUse(t *testing.T) {
+	first := newConformanceClient(t)
+	defer first.close()
+	first.register("conformnick")
+
+	second := newConformanceClient(t)
+	defer second.close()
+	second.send("NICK conformnick")
+	second.expect(ERR_NICKNAMEINUSE)
+}
+
+func TestConformanceChannelMessage(t *testing.T) {
+	alice := newConformanceClient(t)
+	defer alice.close()
+	alice.register("conformalice")
+	bob := newConformanceClient(t)
+	defer bob.close()
+	bob.register("conformbob")
+
+	alice.send("JOIN #conformance")
+	alice.expect(RPL_ENDOFNAMES)
+	bob.send("JOIN #conformance")
+	join := alice.expect("JOIN")
+	if !strings.HasPrefix(join.Prefix, "conformbob!") || join.Params[0] != "#conformance" {
+		t.Errorf("expected to see conformbob join, got %v", join)
+	}
+
+	bob.send("PRIVMSG #conformance :hello world")
+	privmsg := alice.expect("PRIVMSG")
+	if !strings.HasPrefix(privmsg.Prefix, "conformbob!") || privmsg.Params[1] != "hello world" {
+		t.Errorf("expected conformbob's message, got %v", privmsg)
+	}
+
+	bob.send("PART #conformance :bye")
+	part := alice.expect("PART")
+	if !strings.HasPrefix(part.Prefix, "conformbob!") || part.Params[0] != "#conformance" {
+		t.Errorf("expected to see conformbob part, got %v", part)
+	}
+}
+
+func TestConformanceLusers(t *testing.T) {
+	alice := newConformanceClient(t)
+	defer alice.close()
+	alice.register("lusersalice")
+	bob := newConformanceClient(t)
+	defer bob.close()
+	bob.register("lusersbob")
+
+	alice.send("LUSERS")
+	opers := alice.expect(RPL_LUSEROP)
+	if len(opers.Params) != 3 || opers.Params[1] != "0" {
+		t.Errorf("expected no opers, got %v", opers)
+	}
+	local := alice.expect(RPL_LOCALUSERS)
+	if len(local.Params) != 4 {
+		t.Fatalf("expected current and max local users, got %v", local)
+	}
+	current, _ := strconv.Atoi(local.Params[1])
+	max, _ := strconv.Atoi(local.Params[2])
+	if current < 2 || max < current {
+		t.Errorf("expected at least 2 local users and a max of at least that, got %v", local)
+	}
+	alice.expect(RPL_GLOBALUSERS)
+	alice.expect(RPL_STATSCONN)
+}
+
+func TestConformanceTargetServer(t *testing.T) {
+	client := newConformanceClient(t)
+	defer client.close()
+	client.register("targetserver")
+
+	client.send("TIME")
+	client.expect(RPL_TIME)
+	client.send("TIME *.Little.Server")
+	client.expect(RPL_TIME)
+	client.send("VERSION targetserver")
+	client.expect(RPL_VERSION)
+
+	client.send("MOTD irc.example.com")
+	noSuchServer := client.expect(ERR_NOSUCHSERVER)
+	if len(noSuchServer.Params) < 2 || noSuchServer.Params[1] != "irc.example.com" {
+		t.Errorf("expected ERR_NOSUCHSERVER for irc.example.com, got %v", noSuchServer)
+	}
+}
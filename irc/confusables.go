@@ -0,0 +1,372 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/tidwall/buntdb"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	errNameConfusable  = errors.New("Name is too similar to a registered account")
+	errNameMixedScript = errors.New("Name mixes characters from different scripts")
+)
+
+// ConfusablesConfig controls protecting registered account names from lookalikes.
+type ConfusablesConfig struct {
+	// Enabled stops nicks and new accounts that look the same as an existing account
+	// name (such as with a Cyrillic 'а' in place of a Latin 'a') from being used.
+	Enabled bool
+	// RejectMixedScripts stops nicks and account names that mix characters from
+	// different scripts, which is the usual way lookalike names are made.
+	RejectMixedScripts bool `yaml:"reject-mixed-scripts"`
+}
+
+// ChannelConfusablesConfig controls protecting channel names from lookalikes.
+type ChannelConfusablesConfig struct {
+	// Action says what happens when a new channel's name looks like an existing
+	// channel's: "allow" creates it anyway, "reject" refuses to create it, and "redirect"
+	// joins the existing channel instead.
+	Action string
+	// RejectMixedScripts stops new channel names that mix characters from different scripts.
+	RejectMixedScripts bool `yaml:"reject-mixed-scripts"`
+}
+
+func (conf *ChannelConfusablesConfig) parse() error {
+	if conf.Action == "" {
+		conf.Action = "allow"
+	}
+	if conf.Action != "allow" && conf.Action != "reject" && conf.Action != "redirect" {
+		return fmt.Errorf("Unknown action [%s], must be allow, reject or redirect", conf.Action)
+	}
+	return nil
+}
+
+// confusables maps characters to the (lowercase, mostly Latin) characters they can be
+// mistaken for. It's a hand-picked subset of the Unicode confusables list (UTS #39),
+// covering the Cyrillic, Greek, Armenian, Cherokee and small capital letters that pass
+// for Latin ones. Fullwidth, mathematical and other compatibility forms are handled by
+// NFKD instead. Lookalikes from outside this table won't be caught.
+var confusables = map[rune]string{
+	// digits and punctuation
+	'0': "o",
+	'1': "l",
+	'|': "l",
+	// latin
+	'ı': "i",
+	'ɩ': "i",
+	'ɑ': "a",
+	'ɡ': "g",
+	'ʋ': "u",
+	'ƅ': "b",
+	'ɵ': "o",
+	'm': "rn",
+	// small capitals
+	'ᴀ': "a",
+	'ʙ': "b",
+	'ᴄ': "c",
+	'ᴅ': "d",
+	'ᴇ': "e",
+	'ɢ': "g",
+	'ʜ': "h",
+	'ɪ': "i",
+	'ᴊ': "j",
+	'ᴋ': "k",
+	'ʟ': "l",
+	'ᴍ': "rn",
+	'ɴ': "n",
+	'ᴏ': "o",
+	'ᴘ': "p",
+	'ʀ': "r",
+	'ꜱ': "s",
+	'ᴛ': "t",
+	'ᴜ': "u",
+	'ᴠ': "v",
+	'ᴡ': "w",
+	'ʏ': "y",
+	'ᴢ': "z",
+	// cyrillic
+	'а': "a",
+	'в': "b",
+	'с': "c",
+	'ԁ': "d",
+	'е': "e",
+	'ɛ': "e",
+	'һ': "h",
+	'і': "i",
+	'ј': "j",
+	'к': "k",
+	'ӏ': "l",
+	'м': "rn",
+	'о': "o",
+	'р': "p",
+	'ԛ': "q",
+	'г': "r",
+	'ѕ': "s",
+	'ѵ': "v",
+	'ԝ': "w",
+	'ѡ': "w",
+	'х': "x",
+	'у': "y",
+	'ү': "y",
+	'з': "3",
+	'ӡ': "3",
+	'ҽ': "e",
+	'ь': "b",
+	// greek
+	'α': "a",
+	'β': "b",
+	'ε': "e",
+	'η': "n",
+	'ι': "i",
+	'κ': "k",
+	'ν': "v",
+	'ο': "o",
+	'ρ': "p",
+	'ϲ': "c",
+	'ϳ': "j",
+	'τ': "t",
+	'υ': "u",
+	'χ': "x",
+	'γ': "y",
+	'ω': "w",
+	// armenian
+	'օ': "o",
+	'ս': "u",
+	'ց': "g",
+	'հ': "h",
+	'ո': "n",
+	'զ': "q",
+	// cherokee (which casefolds to uppercase)
+	'Ꭺ': "a",
+	'Ᏼ': "b",
+	'Ꮯ': "c",
+	'Ꭼ': "e",
+	'Ꮋ': "h",
+	'Ꭻ': "j",
+	'Ꮶ': "k",
+	'Ꮮ': "l",
+	'Ꮇ': "rn",
+	'Ꮲ': "p",
+	'Ꮪ': "s",
+	'Ꭲ': "t",
+	'Ꮩ': "v",
+	'Ꮃ': "w",
+	'Ꮓ': "z",
+}
+
+// Skeleton returns the 'skeleton' of the given casefolded name, where characters that
+// look alike are mapped to the same thing. Two names that look the same have the same
+// skeleton, as described in UTS #39.
+func Skeleton(name string) string {
+	var buf bytes.Buffer
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.Is(unicode.Other_Default_Ignorable_Code_Point, r) || unicode.In(r, unicode.Cf) {
+			continue
+		}
+		replacement, exists := confusables[r]
+		if exists {
+			buf.WriteString(replacement)
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+	return norm.NFD.String(buf.String())
+}
+
+// scriptsAllowedTogether are sets of scripts that are normally written together, so
+// mixing them isn't suspicious. Latin can also be used alongside any of these.
+var scriptsAllowedTogether = [][]*unicode.RangeTable{
+	{unicode.Han, unicode.Hiragana, unicode.Katakana},
+	{unicode.Han, unicode.Hangul},
+	{unicode.Han, unicode.Bopomofo},
+}
+
+// scriptsOf returns the scripts the characters of the given name are written in, not
+// counting characters shared between scripts (such as digits and punctuation).
+func scriptsOf(name string) map[*unicode.RangeTable]bool {
+	scripts := make(map[*unicode.RangeTable]bool)
+	for _, r := range name {
+		if unicode.In(r, unicode.Common, unicode.Inherited) {
+			continue
+		}
+		for _, script := range unicode.Scripts {
+			if unicode.Is(script, r) {
+				scripts[script] = true
+				break
+			}
+		}
+	}
+	return scripts
+}
+
+// IsMixedScript returns true if the given name uses characters from scripts that
+// aren't normally written together.
+func IsMixedScript(name string) bool {
+	scripts := scriptsOf(name)
+	if len(scripts) < 2 {
+		return false
+	}
+
+	for _, allowed := range scriptsAllowedTogether {
+		allowedSet := map[*unicode.RangeTable]bool{unicode.Latin: true}
+		for _, script := range allowed {
+			allowedSet[script] = true
+		}
+		inSet := true
+		for script := range scripts {
+			if !allowedSet[script] {
+				inSet = false
+				break
+			}
+		}
+		if inSet {
+			return false
+		}
+	}
+	return true
+}
+
+// Skeletons keeps the skeletons of a set of names (such as all registered accounts),
+// so we can quickly find out if a name looks like one of them.
+type Skeletons struct {
+	sync.RWMutex
+
+	// skeletons maps skeleton -> casefolded names with that skeleton. names added
+	// before lookalikes were checked can share one.
+	skeletons map[string]map[string]bool
+}
+
+// NewSkeletons returns a new Skeletons.
+func NewSkeletons() *Skeletons {
+	return &Skeletons{
+		skeletons: make(map[string]map[string]bool),
+	}
+}
+
+// Add adds the given casefolded name.
+func (as *Skeletons) Add(accountKey string) {
+	as.Lock()
+	defer as.Unlock()
+	skeleton := Skeleton(accountKey)
+	if as.skeletons[skeleton] == nil {
+		as.skeletons[skeleton] = make(map[string]bool)
+	}
+	as.skeletons[skeleton][accountKey] = true
+}
+
+// Remove removes the given casefolded name.
+func (as *Skeletons) Remove(accountKey string) {
+	as.Lock()
+	defer as.Unlock()
+	skeleton := Skeleton(accountKey)
+	delete(as.skeletons[skeleton], accountKey)
+	if len(as.skeletons[skeleton]) == 0 {
+		delete(as.skeletons, skeleton)
+	}
+}
+
+// Has returns true if the given casefolded name has been added.
+func (as *Skeletons) Has(name string) bool {
+	as.RLock()
+	defer as.RUnlock()
+	return as.skeletons[Skeleton(name)][name]
+}
+
+// Lookalike returns a name that the given casefolded name looks like, or "" if there
+// isn't one. A name that has been added itself doesn't look like anything, and neither
+// does a name that looks like the given casefolded account (the one using the name, or
+// "" if there isn't one).
+func (as *Skeletons) Lookalike(name string, account string) string {
+	as.RLock()
+	defer as.RUnlock()
+	accounts := as.skeletons[Skeleton(name)]
+	if accounts[name] || (account != "" && accounts[account]) {
+		return ""
+	}
+	for accountKey := range accounts {
+		return accountKey
+	}
+	return ""
+}
+
+// loadAccountSkeletons fills in the skeletons of all registered accounts.
+func (server *Server) loadAccountSkeletons() {
+	server.store.View(func(tx *buntdb.Tx) error {
+		tx.AscendKeys("account.exists *", func(key, value string) bool {
+			server.accountSkeletons.Add(strings.TrimPrefix(key, "account.exists "))
+			return true
+		})
+		return nil
+	})
+}
+
+// loadChannelSkeletons fills in the skeletons of all registered channels.
+func (server *Server) loadChannelSkeletons() {
+	server.store.View(func(tx *buntdb.Tx) error {
+		tx.AscendKeys("channel.exists *", func(key, value string) bool {
+			server.channelSkeletons.Add(strings.TrimPrefix(key, "channel.exists "))
+			return true
+		})
+		return nil
+	})
+}
+
+// channelLookalike returns the casefolded name of an existing or registered channel
+// that the given casefolded channel name looks like, or "" if there isn't one. Existing
+// and registered channels don't look like anything.
+func (server *Server) channelLookalike(name string) string {
+	if server.channels.skeletons.Has(name) || server.channelSkeletons.Has(name) {
+		return ""
+	}
+	lookalike := server.channels.skeletons.Lookalike(name, "")
+	if lookalike == "" {
+		lookalike = server.channelSkeletons.Lookalike(name, "")
+	}
+	return lookalike
+}
+
+// checkChannelConfusable returns an error if the given casefolded channel name can't be
+// used for a new channel because it mixes scripts, and otherwise returns the existing
+// channel it looks like (if any, and if we're checking for lookalikes).
+func (server *Server) checkChannelConfusable(name string) (string, error) {
+	config := server.Config().Channels.Confusables
+	if config.RejectMixedScripts && IsMixedScript(name) {
+		return "", errNameMixedScript
+	}
+	if config.Action == "reject" || config.Action == "redirect" {
+		lookalike := server.channelLookalike(name)
+		if lookalike != "" {
+			server.logger.Debug("channels", fmt.Sprintf("Channel name %s looks like channel %s", name, lookalike))
+		}
+		return lookalike, nil
+	}
+	return "", nil
+}
+
+// checkConfusable returns an error if the given casefolded nick or account name
+// shouldn't be used because it looks like a registered account, or mixes scripts.
+// account is the casefolded account of the client using the name, which is allowed to
+// use names that look like it.
+func (server *Server) checkConfusable(name string, account string) error {
+	config := server.Config().Accounts.Confusables
+	if config.RejectMixedScripts && IsMixedScript(name) {
+		return errNameMixedScript
+	}
+	if config.Enabled {
+		lookalike := server.accountSkeletons.Lookalike(name, account)
+		if lookalike != "" {
+			server.logger.Debug("accounts", fmt.Sprintf("Name %s rejected, it looks like account %s", name, lookalike))
+			return errNameConfusable
+		}
+	}
+	return nil
+}
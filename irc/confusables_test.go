@@ -0,0 +1,146 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestSkeleton(t *testing.T) {
+	same := [][2]string{
+		{"alice", "аlice"}, // cyrillic a
+		{"alice", "a1ice"}, // digit one
+		{"bob", "b0b"},     // digit zero
+		{"dan", "ԁаn"},     // cyrillic d and a
+		{"emma", "ernrna"}, // rn for m
+		{"poe", "ροе"},     // greek rho and omicron, cyrillic e
+		{"zoë", "zoё"},     // latin and cyrillic e with diaeresis
+		{"slingamn", "ѕlingаmn"},
+		{"casey", "сᴀsey"}, // cyrillic c and small capital a
+		{"jack", "ｊａｃｋ"},   // fullwidth
+		{"tom", "Ꭲom"},     // cherokee t
+		{"alice", "𝖺lice"}, // mathematical sans-serif a
+	}
+	for _, pair := range same {
+		if Skeleton(pair[0]) != Skeleton(pair[1]) {
+			t.Errorf("%s and %s should have the same skeleton, got %s and %s", pair[0], pair[1], Skeleton(pair[0]), Skeleton(pair[1]))
+		}
+	}
+
+	different := [][2]string{
+		{"alice", "alicia"},
+		{"dan", "don"},
+		{"zoe", "zoë"},
+	}
+	for _, pair := range different {
+		if Skeleton(pair[0]) == Skeleton(pair[1]) {
+			t.Errorf("%s and %s shouldn't have the same skeleton", pair[0], pair[1])
+		}
+	}
+}
+
+func TestIsMixedScript(t *testing.T) {
+	tests := map[string]bool{
+		"alice":      false,
+		"alice123":   false,
+		"alice_[]":   false,
+		"аlice":      true,  // cyrillic a
+		"ροe":        true,  // greek and latin
+		"иван":       false, // all cyrillic
+		"δημήτρης":   false, // all greek
+		"山田たろう":      false, // han and hiragana
+		"tarō山田":     false, // latin and han
+		"김minjun":    false, // hangul and latin
+		"иванtaro":   true,
+		"ひらがなхорошо": true,
+	}
+	for name, expected := range tests {
+		if IsMixedScript(name) != expected {
+			t.Errorf("IsMixedScript(%s) should be %t", name, expected)
+		}
+	}
+}
+
+func TestSkeletons(t *testing.T) {
+	as := NewSkeletons()
+	as.Add("alice")
+	// registered before lookalikes were checked, so shares alice's skeleton
+	as.Add("a1ice")
+
+	if as.Lookalike("alice", "") != "" {
+		t.Error("An account shouldn't look like itself")
+	}
+	if as.Lookalike("a1ice", "") != "" {
+		t.Error("An account sharing a skeleton shouldn't look like anything")
+	}
+	if lookalike := as.Lookalike("а1ice", ""); lookalike != "alice" && lookalike != "a1ice" {
+		t.Errorf("a1ice with a cyrillic a should look like alice or a1ice, got %q", lookalike)
+	}
+	if as.Lookalike("а1ice", "a1ice") != "" {
+		t.Error("The owner of a1ice should be able to use its lookalikes")
+	}
+	if as.Lookalike("bob", "") != "" {
+		t.Error("bob shouldn't look like anything")
+	}
+
+	as.Remove("alice")
+	if as.Lookalike("а1ice", "") != "a1ice" {
+		t.Error("Removing alice shouldn't remove a1ice")
+	}
+	as.Remove("a1ice")
+	if as.Lookalike("а1ice", "") != "" {
+		t.Error("Removed accounts shouldn't have lookalikes")
+	}
+	if len(as.skeletons) != 0 {
+		t.Errorf("Removed accounts should have their skeletons cleaned up, got %v", as.skeletons)
+	}
+}
+
+func TestChannelLookalike(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatalf("could not open datastore: %s", err.Error())
+	}
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set("channel.exists #registered", "1", nil)
+		return nil
+	})
+	server := &Server{
+		channels:           *NewChannelNameMap(),
+		channelSkeletons:   NewSkeletons(),
+		registeredChannels: make(map[string]*RegisteredChannel),
+		store:              store,
+	}
+	server.loadChannelSkeletons()
+	server.channels.Add(&Channel{name: "#oragono", nameCasefolded: "#oragono"})
+
+	testCases := map[string]string{
+		"#oragono":    "",
+		"#оragono":    "#oragono",
+		"#0ragono":    "#oragono",
+		"#registered": "",
+		"#reg1stered": "",
+		"#regіstered": "#registered",
+		"#other":      "",
+	}
+	for name, expected := range testCases {
+		if lookalike := server.channelLookalike(name); lookalike != expected {
+			t.Errorf("expected %s to look like %q, got %q", name, expected, lookalike)
+		}
+	}
+
+	// the index follows channels being removed and registrations being dropped
+	server.channels.Remove(server.channels.Get("#oragono"))
+	store.Update(func(tx *buntdb.Tx) error {
+		server.deleteChannelNoMutex(tx, "#registered")
+		return nil
+	})
+	for _, name := range []string{"#оragono", "#regіstered"} {
+		if lookalike := server.channelLookalike(name); lookalike != "" {
+			t.Errorf("expected %s to not look like anything once the others are gone, got %q", name, lookalike)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oragono/oragono/irc/geoip"
+)
+
+const (
+	// defaultConnectionClass is the class of connections that don't match any other.
+	defaultConnectionClass = "default"
+)
+
+// ConnectionClassConfig is a named group of connections, made up of the ones to any of
+// its listeners, or from any of its networks, countries or ASNs. Other settings (like
+// CTCP limits) and commands (like GLOBALNOTICE) refer to classes by name.
+type ConnectionClassConfig struct {
+	Name        string
+	Listeners   []string
+	RawNetworks []string    `yaml:"networks"`
+	Networks    []net.IPNet `yaml:"networks-real"`
+	// Countries and ASNs need GeoIP lookups to be enabled.
+	Countries []string
+	ASNs      []uint `yaml:"asns"`
+	locations LocationExemptions
+}
+
+// parseConnectionClasses checks the config's connection classes and parses their networks.
+func (conf *Config) parseConnectionClasses() (err error) {
+	seen := map[string]bool{defaultConnectionClass: true}
+	for i := range conf.Server.ConnectionClasses {
+		class := &conf.Server.ConnectionClasses[i]
+		if class.Name == "" {
+			return fmt.Errorf("Connection classes need a name")
+		}
+		if seen[class.Name] {
+			return fmt.Errorf("Connection class %s is defined more than once, or uses a reserved name", class.Name)
+		}
+		seen[class.Name] = true
+		class.Networks, err = parseNetList(class.RawNetworks)
+		if err != nil {
+			return fmt.Errorf("Could not parse networks of connection class %s: %s", class.Name, err.Error())
+		}
+		class.locations, err = NewLocationExemptions(class.Countries, class.ASNs)
+		if err != nil {
+			return fmt.Errorf("Could not parse locations of connection class %s: %s", class.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// ConnectionClassExists returns true if there's a connection class with the given name.
+func (conf *Config) ConnectionClassExists(name string) bool {
+	if name == defaultConnectionClass {
+		return true
+	}
+	for _, class := range conf.Server.ConnectionClasses {
+		if class.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectionClass returns the name of the connection class of a client connecting from
+// the given IP and location to the given listener. The first class that matches is
+// used, and clients that don't match any are in the default class.
+func (conf *Config) ConnectionClass(listener string, ip net.IP, location geoip.Record) string {
+	for _, class := range conf.Server.ConnectionClasses {
+		if ip != nil && ipInNets(ip, class.Networks) {
+			return class.Name
+		}
+		if class.locations.Contains(location) {
+			return class.Name
+		}
+		for _, addr := range class.Listeners {
+			if addr == listener {
+				return class.Name
+			}
+		}
+	}
+	return defaultConnectionClass
+}
+
+// ConnectionClass returns the name of the client's connection class.
+func (client *Client) ConnectionClass() string {
+	return client.server.Config().ConnectionClass(client.listener, client.IP(), client.location)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/oragono/oragono/irc/geoip"
+)
+
+func TestConnectionClass(t *testing.T) {
+	config := &Config{}
+	config.Server.ConnectionClasses = []ConnectionClassConfig{
+		{Name: "trusted", RawNetworks: []string{"10.0.0.0/8"}},
+		{Name: "tor", Listeners: []string{"127.0.0.2:6667"}},
+		{Name: "local", Countries: []string{"nz"}, ASNs: []uint{64512}},
+	}
+	err := config.parseConnectionClasses()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	var tests = []struct {
+		listener string
+		ip       string
+		location geoip.Record
+		class    string
+	}{
+		{":6667", "10.1.2.3", geoip.Record{CountryCode: "NZ"}, "trusted"},
+		{"127.0.0.2:6667", "10.1.2.3", geoip.Record{}, "trusted"},
+		{"127.0.0.2:6667", "127.0.0.1", geoip.Record{}, "tor"},
+		{":6667", "192.0.2.1", geoip.Record{CountryCode: "NZ"}, "local"},
+		{":6667", "192.0.2.1", geoip.Record{CountryCode: "DE", ASN: 64512}, "local"},
+		{":6667", "192.0.2.1", geoip.Record{CountryCode: "DE", ASN: 64513}, "default"},
+		{":6667", "192.0.2.1", geoip.Record{}, "default"},
+	}
+	for _, test := range tests {
+		class := config.ConnectionClass(test.listener, net.ParseIP(test.ip), test.location)
+		if class != test.class {
+			t.Errorf("expected %s on %s to get class %s, got %s", test.ip, test.listener, test.class, class)
+		}
+	}
+
+	if !config.ConnectionClassExists("tor") || !config.ConnectionClassExists("default") || config.ConnectionClassExists("web") {
+		t.Error("expected only our classes and the default one to exist")
+	}
+
+	for _, name := range []string{"", "default", "tor"} {
+		config.Server.ConnectionClasses = append(config.Server.ConnectionClasses[:2], ConnectionClassConfig{Name: name})
+		if config.parseConnectionClasses() == nil {
+			t.Errorf("expected a class named %q to be rejected", name)
+		}
+	}
+	config.Server.ConnectionClasses = []ConnectionClassConfig{{Name: "local", Countries: []string{"NZL"}}}
+	if config.parseConnectionClasses() == nil {
+		t.Error("expected a class with a bad country code to be rejected")
+	}
+}
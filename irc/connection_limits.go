@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+
+	"github.com/oragono/oragono/irc/geoip"
 )
 
 var (
@@ -27,6 +29,8 @@ type ConnectionLimits struct {
 	exemptedIPs map[string]bool
 	// exemptedNets holds networks that are exempt from limits
 	exemptedNets []net.IPNet
+	// exemptedLocations holds countries and ASNs that are exempt from limits
+	exemptedLocations LocationExemptions
 }
 
 // maskAddr masks the given IPv4/6 address with our cidr limit masks.
@@ -44,7 +48,7 @@ func (cl *ConnectionLimits) maskAddr(addr net.IP) net.IP {
 
 // AddClient adds a client to our population if possible. If we can't, throws an error instead.
 // 'force' is used to add already-existing clients (i.e. ones that are already on the network).
-func (cl *ConnectionLimits) AddClient(addr net.IP, force bool) error {
+func (cl *ConnectionLimits) AddClient(addr net.IP, location geoip.Record, force bool) error {
 	if !cl.enabled {
 		return nil
 	}
@@ -59,6 +63,9 @@ func (cl *ConnectionLimits) AddClient(addr net.IP, force bool) error {
 			return nil
 		}
 	}
+	if cl.exemptedLocations.Contains(location) {
+		return nil
+	}
 
 	// check population
 	cl.maskAddr(addr)
@@ -118,5 +125,11 @@ func NewConnectionLimits(config ConnectionLimitsConfig) (*ConnectionLimits, erro
 		}
 	}
 
+	var err error
+	cl.exemptedLocations, err = NewLocationExemptions(config.ExemptedCountries, config.ExemptedASNs)
+	if err != nil {
+		return nil, err
+	}
+
 	return &cl, nil
 }
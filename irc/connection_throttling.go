@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/geoip"
 )
 
 // ThrottleDetails holds the connection-throttling details for a subnet/IP.
@@ -35,6 +36,8 @@ type ConnectionThrottle struct {
 	exemptedIPs map[string]bool
 	// exemptedNets holds networks that are exempt from limits
 	exemptedNets []net.IPNet
+	// exemptedLocations holds countries and ASNs that are exempt from limits
+	exemptedLocations LocationExemptions
 }
 
 // maskAddr masks the given IPv4/6 address with our cidr limit masks.
@@ -63,7 +66,7 @@ func (ct *ConnectionThrottle) ResetFor(addr net.IP) {
 }
 
 // AddClient introduces a new client connection if possible. If we can't, throws an error instead.
-func (ct *ConnectionThrottle) AddClient(addr net.IP) error {
+func (ct *ConnectionThrottle) AddClient(addr net.IP, location geoip.Record) error {
 	if !ct.enabled {
 		return nil
 	}
@@ -77,6 +80,9 @@ func (ct *ConnectionThrottle) AddClient(addr net.IP) error {
 			return nil
 		}
 	}
+	if ct.exemptedLocations.Contains(location) {
+		return nil
+	}
 
 	// check throttle
 	ct.maskAddr(addr)
@@ -138,5 +144,10 @@ func NewConnectionThrottle(config ConnectionThrottleConfig) (*ConnectionThrottle
 		}
 	}
 
+	ct.exemptedLocations, err = NewLocationExemptions(config.ExemptedCountries, config.ExemptedASNs)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ct, nil
 }
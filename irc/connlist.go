@@ -0,0 +1,209 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmatch"
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/custime"
+)
+
+const (
+	// connListPageSize is how many connections CONNLIST shows on each page.
+	connListPageSize = 25
+)
+
+// connListFilter selects the connections that CONNLIST shows.
+type connListFilter struct {
+	networks []net.IPNet
+	accounts []ircmatch.Matcher
+	classes  []string
+	// loggedIn is 1 to only show logged-in clients, -1 to only show those who aren't
+	loggedIn int
+	// tls is 1 to only show TLS connections, -1 to only show plaintext ones
+	tls     int
+	minIdle time.Duration
+	page    int
+}
+
+// parseConnListFilter parses the parameters of a CONNLIST command.
+func parseConnListFilter(params []string) (filter connListFilter, err error) {
+	filter.page = 1
+	for i := 0; i < len(params); i += 2 {
+		name := strings.ToUpper(params[i])
+		if len(params) <= i+1 {
+			return filter, fmt.Errorf("%s needs a value", name)
+		}
+		value := params[i+1]
+
+		switch name {
+		case "IP":
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				ip := net.ParseIP(value)
+				if ip == nil {
+					return filter, fmt.Errorf("Could not parse IP address or CIDR network [%s]", value)
+				}
+				network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+			}
+			filter.networks = append(filter.networks, *network)
+		case "ACCOUNT":
+			switch value {
+			case "*":
+				filter.loggedIn = 1
+			case "-":
+				filter.loggedIn = -1
+			default:
+				filter.accounts = append(filter.accounts, ircmatch.MakeMatch(strings.ToLower(value)))
+			}
+		case "CLASS":
+			filter.classes = append(filter.classes, value)
+		case "TLS":
+			enabled, err := parseConfigBool(value)
+			if err != nil {
+				return filter, fmt.Errorf("TLS needs to be ON or OFF")
+			}
+			filter.tls = -1
+			if enabled {
+				filter.tls = 1
+			}
+		case "IDLE":
+			filter.minIdle, err = custime.ParseDuration(value)
+			if err != nil {
+				return filter, fmt.Errorf("Could not parse duration [%s]", value)
+			}
+		case "PAGE":
+			filter.page, err = strconv.Atoi(value)
+			if err != nil || filter.page < 1 {
+				return filter, fmt.Errorf("Invalid page [%s]", value)
+			}
+		default:
+			return filter, fmt.Errorf("Unknown filter [%s]", name)
+		}
+	}
+	return filter, nil
+}
+
+// Matches returns true if the given client matches the filter.
+func (filter *connListFilter) Matches(client *Client) bool {
+	if 0 < len(filter.networks) {
+		ip := client.IP()
+		var matched bool
+		for _, network := range filter.networks {
+			if ip != nil && network.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	loggedIn := client.account != nil && client.account != &NoAccount
+	if filter.loggedIn == 1 && !loggedIn || filter.loggedIn == -1 && loggedIn {
+		return false
+	}
+	if 0 < len(filter.accounts) {
+		if !loggedIn {
+			return false
+		}
+		var matched bool
+		for _, account := range filter.accounts {
+			if account.Match(strings.ToLower(client.account.Name)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if filter.tls == 1 && !client.HasMode(TLS) || filter.tls == -1 && client.HasMode(TLS) {
+		return false
+	}
+
+	if client.IdleTime() < filter.minIdle {
+		return false
+	}
+
+	if 0 < len(filter.classes) {
+		class := client.ConnectionClass()
+		for _, name := range filter.classes {
+			if name == class {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// connListLine describes the given client for CONNLIST.
+func connListLine(client *Client) string {
+	account := "*"
+	if client.account != nil && client.account != &NoAccount {
+		account = client.account.Name
+	}
+	tls := "no"
+	if client.HasMode(TLS) {
+		tls = "yes"
+	}
+	idle := client.IdleTime() / time.Second * time.Second
+	return fmt.Sprintf("%s [ip:%s] [account:%s] [class:%s] [tls:%s] [idle:%s] [signon:%s]", client.nickMaskString, client.IPString(), account, client.ConnectionClass(), tls, idle.String(), client.ctime.Format(time.RFC1123))
+}
+
+// CONNLIST [IP <ip/net>] [ACCOUNT <mask|*|->] [CLASS <class>] [TLS <on|off>] [IDLE <duration>] [PAGE <page>]
+func connlistHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	filter, err := parseConnListFilter(msg.Params)
+	if err != nil {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, err.Error())
+		return false
+	}
+	for _, class := range filter.classes {
+		if !server.Config().ConnectionClassExists(class) {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, fmt.Sprintf(client.t("Unknown connection class [%s]"), class))
+			return false
+		}
+	}
+
+	var matches []*Client
+	server.clients.ByNickMutex.RLock()
+	for _, mcl := range server.clients.ByNick {
+		if filter.Matches(mcl) {
+			matches = append(matches, mcl)
+		}
+	}
+	server.clients.ByNickMutex.RUnlock()
+
+	// sort them so that pages stay the same between commands
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].nickCasefolded < matches[j].nickCasefolded
+	})
+
+	pages := (len(matches) + connListPageSize - 1) / connListPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	start := (filter.page - 1) * connListPageSize
+	if start < len(matches) {
+		end := start + connListPageSize
+		if len(matches) < end {
+			end = len(matches)
+		}
+		for _, mcl := range matches[start:end] {
+			client.Notice(connListLine(mcl))
+		}
+	}
+	client.Notice(fmt.Sprintf(client.t("End of CONNLIST: %[1]d connections, page %[2]d of %[3]d"), len(matches), filter.page, pages))
+	return false
+}
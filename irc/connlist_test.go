@@ -0,0 +1,80 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseConnListFilter(t *testing.T) {
+	filter, err := parseConnListFilter([]string{"ip", "203.0.113.0/24", "IP", "2001:db8::1", "TLS", "on", "IDLE", "1h", "PAGE", "3"})
+	if err != nil {
+		t.Fatalf("could not parse filter: %s", err.Error())
+	}
+	if len(filter.networks) != 2 || !filter.networks[0].Contains(net.ParseIP("203.0.113.9")) || !filter.networks[1].Contains(net.ParseIP("2001:db8::1")) || filter.networks[1].Contains(net.ParseIP("2001:db8::2")) {
+		t.Errorf("unexpected networks: %v", filter.networks)
+	}
+	if filter.tls != 1 || filter.minIdle != time.Hour || filter.page != 3 {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+
+	for _, params := range [][]string{
+		{"IP"},
+		{"IP", "not an ip"},
+		{"TLS", "maybe"},
+		{"IDLE", "forever"},
+		{"PAGE", "0"},
+		{"CLASS"},
+	} {
+		_, err := parseConnListFilter(params)
+		if err == nil {
+			t.Errorf("expected %v to be an invalid filter", params)
+		}
+	}
+}
+
+func TestConnListFilterMatches(t *testing.T) {
+	server := &Server{
+		config: &Config{},
+	}
+	server.config.Server.ConnectionClasses = []ConnectionClassConfig{
+		{Name: "web", Listeners: []string{":8097"}},
+	}
+	alice := newTestClient(server, "alice")
+	alice.account = &ClientAccount{Name: "Alice"}
+	alice.atime = time.Now().Add(-2 * time.Hour)
+	alice.flags[TLS] = true
+	alice.listener = ":8097"
+	bob := newTestClient(server, "bob")
+	bob.atime = time.Now()
+
+	testCases := []struct {
+		params []string
+		alice  bool
+		bob    bool
+	}{
+		{nil, true, true},
+		{[]string{"ACCOUNT", "*"}, true, false},
+		{[]string{"ACCOUNT", "-"}, false, true},
+		{[]string{"ACCOUNT", "ali*"}, true, false},
+		{[]string{"ACCOUNT", "bob"}, false, false},
+		{[]string{"TLS", "off"}, false, true},
+		{[]string{"IDLE", "1h"}, true, false},
+		{[]string{"TLS", "on", "IDLE", "3h"}, false, false},
+		{[]string{"CLASS", "web"}, true, false},
+		{[]string{"CLASS", "default"}, false, true},
+		{[]string{"CLASS", "web", "CLASS", "default"}, true, true},
+	}
+	for _, testCase := range testCases {
+		filter, err := parseConnListFilter(testCase.params)
+		if err != nil {
+			t.Fatalf("could not parse filter %v: %s", testCase.params, err.Error())
+		}
+		if filter.Matches(alice) != testCase.alice || filter.Matches(bob) != testCase.bob {
+			t.Errorf("expected %v to match alice %t and bob %t", testCase.params, testCase.alice, testCase.bob)
+		}
+	}
+}
@@ -5,7 +5,10 @@
 
 package irc
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	// SemVer is the semantic version of Oragono.
@@ -19,6 +22,58 @@ var (
 	// maxLastArgLength is used to simply cap off the final argument when creating general messages where we need to select a limit.
 	// for instance, in MONITOR lists, RPL_ISUPPORT lists, etc.
 	maxLastArgLength = 400
-	// maxTargets is the maximum number of targets for PRIVMSG and NOTICE.
-	maxTargets = 4
+	// defaultMaxTargets is the maximum number of targets for PRIVMSG and NOTICE, if the config doesn't set one.
+	defaultMaxTargets = 4
+	// defaultAcceptEntries is the maximum number of nicknames on an ACCEPT list, if the config doesn't set one.
+	defaultAcceptEntries = 100
+	// defaultAutoAwayIdleTime is how long clients can be idle before auto-away marks them away, if the config doesn't say.
+	defaultAutoAwayIdleTime = 30 * time.Minute
+	// defaultPushTimeout is how long we wait for an account's webhook to answer, if the config doesn't say.
+	defaultPushTimeout = 10 * time.Second
+	// defaultInviteExpiry is how long invites to invite-only channels last, if the config doesn't say.
+	defaultInviteExpiry = time.Hour
+	// defaultHookTimeout is how long we wait for a hook process to answer, if the config doesn't say.
+	defaultHookTimeout = 2 * time.Second
+	// hookRestartDelay is how long we wait before starting a hook process again after it exits.
+	hookRestartDelay = 5 * time.Second
+	// hookQueueLength is how many events can be waiting to be written to a hook process.
+	hookQueueLength = 256
+	// defaultExternalAuthTimeout is how long we wait for the external auth service to answer,
+	// if the config doesn't say.
+	defaultExternalAuthTimeout = 5 * time.Second
+	// defaultExternalAuthConcurrency is how many requests we make to the external auth service
+	// at once, if the config doesn't say.
+	defaultExternalAuthConcurrency = 8
+	// defaultLoginThrottleDuration is the period failed logins are counted over, if the config doesn't say.
+	defaultLoginThrottleDuration = time.Minute
+	// defaultOAuth2Timeout is how long we wait for the OAuth2 issuer to answer, if the config doesn't say.
+	defaultOAuth2Timeout = 5 * time.Second
+	// oauth2KeyRefetchDelay is the shortest time between fetching the OAuth2 issuer's signing keys.
+	oauth2KeyRefetchDelay = time.Minute
+	// oauth2ClockSkew is how far our clock can be from the OAuth2 issuer's when checking token times.
+	oauth2ClockSkew = time.Minute
+	// defaultAbuseReportTimeout is how long we wait for the abuse blacklist to answer, if the config doesn't say.
+	defaultAbuseReportTimeout = 10 * time.Second
+	// abuseReportRepeatDelay is how long we wait before reporting the same IP again.
+	abuseReportRepeatDelay = 24 * time.Hour
+	// defaultWebhookTimeout is how long we wait for a webhook to answer, if the config doesn't say.
+	defaultWebhookTimeout = 10 * time.Second
+	// webhookRetryDelay is how long we wait before retrying a failed webhook the first time.
+	// Each retry after that waits twice as long as the one before.
+	webhookRetryDelay = 5 * time.Second
+	// webhookShutdownWait is the longest we wait for queued webhooks to be sent while shutting down.
+	webhookShutdownWait = 5 * time.Second
+	// nickHoldDuration is how long a nick recovered with NickServ GHOST is kept for its account.
+	nickHoldDuration = time.Minute
+	// maxWordFilterPatterns is the most words and regexes a channel's word filter can have.
+	maxWordFilterPatterns = 50
+
+	// minSplitLineLen is the shortest line that relayed messages are split into.
+	minSplitLineLen = 100
+
+	// maxRecentSnomasks is how many of the last snomasks we keep, for the oper dashboard.
+	maxRecentSnomasks = 100
+
+	// channelExpiryCheckInterval is how often we look for registered channels that have expired.
+	channelExpiryCheckInterval = time.Hour
 )
@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+)
+
+// conversation is the direct message history between two accounts.
+type conversation struct {
+	// accounts are the casefolded names of the two accounts, and names are how they're
+	// displayed (in the same order)
+	accounts [2]string
+	names    [2]string
+	latest   time.Time
+	buffer   *history.Buffer
+}
+
+// ConversationTarget is a conversation that has had messages sent in it, as seen by
+// one of the accounts in it.
+type ConversationTarget struct {
+	Name   string
+	Latest time.Time
+}
+
+// ConversationHistory stores the direct messages sent between each pair of accounts, so
+// clients can find out which conversations have new messages since they were last online.
+type ConversationHistory struct {
+	sync.Mutex
+
+	// length is how many messages we keep for each new conversation, 0 meaning none
+	length int
+	// perAccount is how many conversations we keep for each account, 0 meaning no limit
+	perAccount int
+	// conversations maps conversationKey() -> conversation
+	conversations map[string]*conversation
+	// byAccount maps casefolded account name -> conversationKey() -> conversation
+	byAccount map[string]map[string]*conversation
+}
+
+// NewConversationHistory returns a new ConversationHistory that keeps up to length
+// messages for each conversation, and up to perAccount conversations for each account.
+func NewConversationHistory(length, perAccount int) *ConversationHistory {
+	return &ConversationHistory{
+		length:        length,
+		perAccount:    perAccount,
+		conversations: make(map[string]*conversation),
+		byAccount:     make(map[string]map[string]*conversation),
+	}
+}
+
+// conversationKey returns the key for the conversation between the given casefolded
+// account names, which is the same whichever way around they're given.
+func conversationKey(first, second string) string {
+	if second < first {
+		first, second = second, first
+	}
+	return first + " " + second
+}
+
+// SetLength changes how many messages we keep for conversations that start from now on,
+// and how many conversations we keep for each account.
+func (ch *ConversationHistory) SetLength(length, perAccount int) {
+	ch.Lock()
+	defer ch.Unlock()
+
+	ch.length = length
+	ch.perAccount = perAccount
+}
+
+// Add stores a message sent between the given accounts.
+func (ch *ConversationHistory) Add(from, to *ClientAccount, item history.Item) {
+	fromKey, err := CasefoldName(from.Name)
+	if err != nil {
+		return
+	}
+	toKey, err := CasefoldName(to.Name)
+	// messages between the sessions of one account aren't a conversation
+	if err != nil || fromKey == toKey {
+		return
+	}
+	key := conversationKey(fromKey, toKey)
+	if item.Time.IsZero() {
+		item.Time = time.Now()
+	}
+
+	ch.Lock()
+	defer ch.Unlock()
+
+	conv, exists := ch.conversations[key]
+	if !exists {
+		if ch.length == 0 {
+			return
+		}
+		conv = &conversation{
+			accounts: [2]string{fromKey, toKey},
+			names:    [2]string{from.Name, to.Name},
+			buffer:   history.NewHistoryBuffer(ch.length),
+		}
+		for _, account := range conv.accounts {
+			ch.makeRoomNoMutex(account)
+			if ch.byAccount[account] == nil {
+				ch.byAccount[account] = make(map[string]*conversation)
+			}
+			ch.byAccount[account][key] = conv
+		}
+		ch.conversations[key] = conv
+	}
+	conv.latest = item.Time
+	conv.buffer.Add(item)
+}
+
+// makeRoomNoMutex drops the given account's least recent conversations until it has
+// room for a new one.
+func (ch *ConversationHistory) makeRoomNoMutex(account string) {
+	if ch.perAccount == 0 {
+		return
+	}
+	for ch.perAccount <= len(ch.byAccount[account]) {
+		var oldest string
+		var oldestTime time.Time
+		for key, conv := range ch.byAccount[account] {
+			if oldest == "" || conv.latest.Before(oldestTime) {
+				oldest = key
+				oldestTime = conv.latest
+			}
+		}
+		ch.removeNoMutex(oldest)
+	}
+}
+
+// removeNoMutex removes the conversation with the given key.
+func (ch *ConversationHistory) removeNoMutex(key string) {
+	conv, exists := ch.conversations[key]
+	if !exists {
+		return
+	}
+	delete(ch.conversations, key)
+	for _, account := range conv.accounts {
+		delete(ch.byAccount[account], key)
+		if len(ch.byAccount[account]) == 0 {
+			delete(ch.byAccount, account)
+		}
+	}
+}
+
+// Forget removes every conversation the given casefolded account has had, such as when
+// they opt out of conversation history.
+func (ch *ConversationHistory) Forget(account string) {
+	ch.Lock()
+	defer ch.Unlock()
+
+	for key := range ch.byAccount[account] {
+		ch.removeNoMutex(key)
+	}
+}
+
+// Get returns the messages between the given casefolded account names, sent after
+// `after` and before `before` (zero times mean that side is unbounded).
+func (ch *ConversationHistory) Get(first, second string, after, before time.Time) []history.Item {
+	ch.Lock()
+	conv, exists := ch.conversations[conversationKey(first, second)]
+	ch.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return conv.buffer.Between(after, before)
+}
+
+// Targets returns the conversations the given casefolded account has had that have
+// messages sent after `after` and before `before`, most recent first. limit is the
+// most we'll return, 0 meaning no limit.
+func (ch *ConversationHistory) Targets(account string, after, before time.Time, limit int) []ConversationTarget {
+	ch.Lock()
+	defer ch.Unlock()
+
+	var targets []ConversationTarget
+	for _, conv := range ch.byAccount[account] {
+		var other string
+		if conv.accounts[0] == account {
+			other = conv.names[1]
+		} else if conv.accounts[1] == account {
+			other = conv.names[0]
+		} else {
+			continue
+		}
+
+		// find the latest message in our bounds
+		latest := conv.latest
+		if !before.IsZero() && !latest.Before(before) {
+			items := conv.buffer.Between(after, before)
+			if len(items) == 0 {
+				continue
+			}
+			latest = items[len(items)-1].Time
+		}
+		if !after.IsZero() && !latest.After(after) {
+			continue
+		}
+		targets = append(targets, ConversationTarget{
+			Name:   other,
+			Latest: latest,
+		})
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Latest.After(targets[j].Latest)
+	})
+	if 0 < limit && limit < len(targets) {
+		targets = targets[:limit]
+	}
+	return targets
+}
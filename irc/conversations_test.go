@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+)
+
+func TestConversationHistory(t *testing.T) {
+	conversations := NewConversationHistory(8, 0)
+	dan := &ClientAccount{Name: "Dan"}
+	bob := &ClientAccount{Name: "bob"}
+	eve := &ClientAccount{Name: "eve"}
+
+	start := time.Now()
+	conversations.Add(dan, bob, history.Item{Message: "hi bob", Time: start.Add(time.Second)})
+	conversations.Add(bob, dan, history.Item{Message: "hi dan", Time: start.Add(2 * time.Second)})
+	conversations.Add(eve, dan, history.Item{Message: "hi", Time: start.Add(3 * time.Second)})
+	conversations.Add(dan, dan, history.Item{Message: "me", Time: start.Add(4 * time.Second)})
+
+	items := conversations.Get("bob", "dan", time.Time{}, time.Time{})
+	if len(items) != 2 || items[0].Message != "hi bob" || items[1].Message != "hi dan" {
+		t.Errorf("expected both sides of the conversation, got %v", items)
+	}
+
+	targets := conversations.Targets("dan", time.Time{}, time.Time{}, 0)
+	if len(targets) != 2 || targets[0].Name != "eve" || targets[1].Name != "bob" {
+		t.Errorf("expected dan's conversations most recent first, got %v", targets)
+	}
+	targets = conversations.Targets("dan", start.Add(2*time.Second), time.Time{}, 0)
+	if len(targets) != 1 || targets[0].Name != "eve" {
+		t.Errorf("expected only conversations with new messages, got %v", targets)
+	}
+	targets = conversations.Targets("dan", time.Time{}, start.Add(3*time.Second), 0)
+	if len(targets) != 1 || targets[0].Name != "bob" || !targets[0].Latest.Equal(start.Add(2*time.Second)) {
+		t.Errorf("expected the latest message before the end time, got %v", targets)
+	}
+	targets = conversations.Targets("bob", time.Time{}, time.Time{}, 1)
+	if len(targets) != 1 || targets[0].Name != "Dan" {
+		t.Errorf("expected the other account's display name, got %v", targets)
+	}
+
+	conversations.SetLength(0, 0)
+	conversations.Add(bob, eve, history.Item{Message: "hello"})
+	if len(conversations.Targets("eve", time.Time{}, time.Time{}, 0)) != 1 {
+		t.Error("expected no new conversations once history is turned off")
+	}
+}
+
+func TestConversationHistoryLimits(t *testing.T) {
+	conversations := NewConversationHistory(8, 2)
+	dan := &ClientAccount{Name: "dan"}
+	bob := &ClientAccount{Name: "bob"}
+	eve := &ClientAccount{Name: "eve"}
+	mallory := &ClientAccount{Name: "mallory"}
+
+	start := time.Now()
+	conversations.Add(dan, bob, history.Item{Message: "hi bob", Time: start.Add(time.Second)})
+	conversations.Add(dan, eve, history.Item{Message: "hi eve", Time: start.Add(2 * time.Second)})
+	conversations.Add(mallory, dan, history.Item{Message: "hi dan", Time: start.Add(3 * time.Second)})
+
+	targets := conversations.Targets("dan", time.Time{}, time.Time{}, 0)
+	if len(targets) != 2 || targets[0].Name != "mallory" || targets[1].Name != "eve" {
+		t.Errorf("expected dan's quietest conversation to be dropped, got %v", targets)
+	}
+	if len(conversations.Targets("bob", time.Time{}, time.Time{}, 0)) != 0 {
+		t.Error("expected the dropped conversation to be gone for bob too")
+	}
+
+	conversations.Forget("dan")
+	if len(conversations.Targets("dan", time.Time{}, time.Time{}, 0)) != 0 || len(conversations.Targets("eve", time.Time{}, time.Time{}, 0)) != 0 {
+		t.Error("expected dan's conversations to be forgotten")
+	}
+	if conversations.Get("dan", "mallory", time.Time{}, time.Time{}) != nil {
+		t.Error("expected dan's messages to be forgotten")
+	}
+}
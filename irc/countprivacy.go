@@ -0,0 +1,64 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+)
+
+// UserCountPrivacyConfig controls how much non-opers can learn about how many users
+// are on the network, for networks (like Tor-only ones) that don't want to give away
+// their population.
+type UserCountPrivacyConfig struct {
+	// Lusers and List are "show" to give real counts in LUSERS and LIST, "fuzz" to round
+	// them up to a multiple of Fuzz, or "hide" to give 0.
+	Lusers string
+	List   string
+	Fuzz   int
+	// HideWho limits WHO replies to the channels the client is in and the users they
+	// share a channel with.
+	HideWho bool `yaml:"hide-who"`
+}
+
+func (conf *UserCountPrivacyConfig) parse() error {
+	for _, mode := range []*string{&conf.Lusers, &conf.List} {
+		if *mode == "" {
+			*mode = "show"
+		}
+		if *mode != "show" && *mode != "fuzz" && *mode != "hide" {
+			return fmt.Errorf("Unknown mode [%s], must be show, fuzz or hide", *mode)
+		}
+	}
+	if conf.Fuzz == 0 {
+		conf.Fuzz = 10
+	}
+	if conf.Fuzz < 1 {
+		return fmt.Errorf("fuzz must be at least 1")
+	}
+	return nil
+}
+
+// privateCount returns the given count as it's shown to non-opers with the given mode.
+func (conf *UserCountPrivacyConfig) privateCount(mode string, count int) int {
+	switch mode {
+	case "fuzz":
+		// always round up, so small networks don't look empty
+		return (count + conf.Fuzz - 1) / conf.Fuzz * conf.Fuzz
+	case "hide":
+		return 0
+	}
+	return count
+}
+
+// privateLUserStats returns the given LUSERS counts as they're shown to non-opers.
+func (conf *UserCountPrivacyConfig) privateLUserStats(stats LUserStats) LUserStats {
+	if conf.Lusers == "show" {
+		return stats
+	}
+	for _, count := range []*int{&stats.Users, &stats.Invisible, &stats.Opers, &stats.Unknown, &stats.Channels, &stats.MaxLocal, &stats.MaxGlobal, &stats.MaxConnections} {
+		*count = conf.privateCount(conf.Lusers, *count)
+	}
+	stats.ConnectionsReceived = uint64(conf.privateCount(conf.Lusers, int(stats.ConnectionsReceived)))
+	return stats
+}
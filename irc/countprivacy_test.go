@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestPrivateCount(t *testing.T) {
+	conf := UserCountPrivacyConfig{Lusers: "fuzz", List: "hide"}
+	err := conf.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	var tests = []struct {
+		mode     string
+		count    int
+		expected int
+	}{
+		{"show", 7, 7},
+		{"fuzz", 0, 0},
+		{"fuzz", 1, 10},
+		{"fuzz", 10, 10},
+		{"fuzz", 11, 20},
+		{"hide", 42, 0},
+	}
+	for _, test := range tests {
+		if count := conf.privateCount(test.mode, test.count); count != test.expected {
+			t.Errorf("expected %s of %d to give %d, got %d", test.mode, test.count, test.expected, count)
+		}
+	}
+
+	stats := conf.privateLUserStats(LUserStats{Users: 13, Invisible: 3, Opers: 1, ConnectionsReceived: 25})
+	if stats.Users != 20 || stats.Invisible != 10 || stats.Opers != 10 || stats.ConnectionsReceived != 30 {
+		t.Errorf("expected LUSERS counts to be fuzzed, got %+v", stats)
+	}
+
+	conf.List = "sometimes"
+	if conf.parse() == nil {
+		t.Error("expected unknown modes to be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// CTCPLimits are how many CTCPs a client can send in a window of time.
+type CTCPLimits struct {
+	Limit        int
+	WindowString string        `yaml:"window"`
+	Window       time.Duration `yaml:"window-real"`
+}
+
+func (limits *CTCPLimits) parse() (err error) {
+	if limits.WindowString == "" {
+		limits.WindowString = "10s"
+	}
+	limits.Window, err = custime.ParseDuration(limits.WindowString)
+	if err != nil {
+		return fmt.Errorf("Could not parse window: %s", err.Error())
+	}
+	if limits.Limit < 1 {
+		return fmt.Errorf("limit must be at least 1")
+	}
+	return nil
+}
+
+// CTCPFloodConfig limits how many CTCP requests and replies clients can send, so they
+// can't be used to flood other clients with VERSION and PING requests.
+type CTCPFloodConfig struct {
+	Enabled    bool
+	CTCPLimits `yaml:",inline"`
+	// Mute is how long clients who go over their limit have their CTCPs ignored for.
+	MuteString string        `yaml:"mute"`
+	Mute       time.Duration `yaml:"mute-real"`
+	// Classes holds the limits of connection classes that don't use the default ones.
+	Classes map[string]CTCPLimits
+}
+
+func (conf *CTCPFloodConfig) parse() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	err = conf.CTCPLimits.parse()
+	if err != nil {
+		return err
+	}
+	if conf.MuteString == "" {
+		conf.MuteString = "5m"
+	}
+	conf.Mute, err = custime.ParseDuration(conf.MuteString)
+	if err != nil {
+		return fmt.Errorf("Could not parse mute: %s", err.Error())
+	}
+	for name, limits := range conf.Classes {
+		err = limits.parse()
+		if err != nil {
+			return fmt.Errorf("Could not parse limits of class %s: %s", name, err.Error())
+		}
+		conf.Classes[name] = limits
+	}
+	return nil
+}
+
+// Limits returns the CTCP limits of the given connection class.
+func (conf *CTCPFloodConfig) Limits(class string) CTCPLimits {
+	limits, exists := conf.Classes[class]
+	if !exists {
+		return conf.CTCPLimits
+	}
+	return limits
+}
+
+// ctcpFloodState tracks the CTCPs a client has sent.
+type ctcpFloodState struct {
+	sync.Mutex
+	sent       eventWindow
+	mutedUntil time.Time
+}
+
+// isCTCP returns true if the given message is a CTCP request or reply. ACTIONs are how
+// clients send /me, so they're just chatter.
+func isCTCP(message string) bool {
+	if !strings.HasPrefix(message, "\x01") {
+		return false
+	}
+	command := strings.TrimPrefix(message, "\x01")
+	if end := strings.IndexAny(command, " \x01"); end != -1 {
+		command = command[:end]
+	}
+	return strings.ToUpper(command) != "ACTION"
+}
+
+// checkCTCP records the given message if it's a CTCP, and returns true if it should be
+// relayed. Clients who go over their class's limit have their CTCPs ignored for a while.
+func (server *Server) checkCTCP(client *Client, message string) bool {
+	config := server.Config().Server.CTCPFlood
+	if !config.Enabled || client.HasMode(Operator) || !isCTCP(message) {
+		return true
+	}
+
+	// only look up the client's IP if there are classes that need it
+	className, limits := defaultConnectionClass, config.CTCPLimits
+	if 0 < len(config.Classes) {
+		className = client.ConnectionClass()
+		limits = config.Limits(className)
+	}
+	now := time.Now()
+	state := &client.ctcpFlood
+	state.Lock()
+	if now.Before(state.mutedUntil) {
+		state.Unlock()
+		return false
+	}
+	count := state.sent.Add(now, limits.Window)
+	if count <= limits.Limit {
+		state.Unlock()
+		return true
+	}
+	state.mutedUntil = now.Add(config.Mute)
+	state.Unlock()
+
+	server.logger.Warning("flood", fmt.Sprintf("Client %s sent %d CTCPs within %s, ignoring their CTCPs for %s", client.nickMaskString, count, limits.Window.String(), config.Mute.String()))
+	server.snomasks.Send(sno.LocalFloods, fmt.Sprintf(ircfmt.Unescape("$c[grey][$r%s$c[grey]] sent %d CTCPs within %s (class %s), ignoring their CTCPs for %s"), client.nickMaskString, count, limits.Window.String(), className, config.Mute.String()))
+	return false
+}
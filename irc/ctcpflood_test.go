@@ -0,0 +1,136 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+func TestIsCTCP(t *testing.T) {
+	var tests = []struct {
+		message string
+		ctcp    bool
+	}{
+		{"\x01VERSION\x01", true},
+		{"\x01PING 12345\x01", true},
+		{"\x01version", true},
+		{"\x01ACTION waves\x01", false},
+		{"\x01action\x01", false},
+		{"hello \x01VERSION\x01", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if isCTCP(test.message) != test.ctcp {
+			t.Errorf("expected isCTCP(%q) to be %t", test.message, test.ctcp)
+		}
+	}
+}
+
+func TestCTCPFloodLimits(t *testing.T) {
+	conf := CTCPFloodConfig{
+		Enabled:    true,
+		CTCPLimits: CTCPLimits{Limit: 5},
+		Classes: map[string]CTCPLimits{
+			"trusted": {Limit: 20, WindowString: "1m"},
+		},
+	}
+	err := conf.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	if limits := conf.Limits("trusted"); limits.Limit != 20 || limits.Window != time.Minute {
+		t.Errorf("expected the trusted class to get its own limits, got %+v", limits)
+	}
+	if limits := conf.Limits("web"); limits.Limit != 5 || limits.Window != 10*time.Second {
+		t.Errorf("expected other classes to get the default limits, got %+v", limits)
+	}
+
+	conf.Classes["web"] = CTCPLimits{Limit: 0}
+	if conf.parse() == nil {
+		t.Error("expected classes without a limit to be rejected")
+	}
+}
+
+func TestCheckCTCP(t *testing.T) {
+	logman, _ := logger.NewManager()
+	server := &Server{
+		config:   &Config{},
+		logger:   logman,
+		snomasks: NewSnoManager(),
+	}
+	server.config.Server.CTCPFlood = CTCPFloodConfig{
+		Enabled:    true,
+		CTCPLimits: CTCPLimits{Limit: 2},
+	}
+	err := server.config.Server.CTCPFlood.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	client := &Client{
+		flags:          map[Mode]bool{},
+		nickMaskString: "dan!~d@localhost",
+	}
+	oper := &Client{
+		flags: map[Mode]bool{Operator: true},
+	}
+
+	for i, expected := range []bool{true, true, false, false} {
+		if server.checkCTCP(client, "\x01VERSION\x01") != expected {
+			t.Errorf("expected CTCP %d to give %t", i+1, expected)
+		}
+		if !server.checkCTCP(oper, "\x01VERSION\x01") {
+			t.Error("expected opers to not be limited")
+		}
+	}
+	if !server.checkCTCP(client, "\x01ACTION waves\x01") || !server.checkCTCP(client, "hello") {
+		t.Error("expected muted clients to still be able to chat")
+	}
+
+	recent := server.snomasks.Recent()
+	if len(recent) != 1 || recent[0].Mask != sno.LocalFloods {
+		t.Errorf("expected one alert, got %v", recent)
+	}
+}
+
+func TestCTCPFloodCoversCMessages(t *testing.T) {
+	server := newTestServer()
+	server.clients = NewClientLookupSet()
+	server.snomasks = NewSnoManager()
+	server.hooks = NewHooks(nil, server.logger)
+	server.abuseReports = NewAbuseReporter(AbuseReportsConfig{}, server.logger)
+	server.config.Server.CTCPFlood = CTCPFloodConfig{
+		Enabled:    true,
+		CTCPLimits: CTCPLimits{Limit: 1},
+	}
+	err := server.config.Server.CTCPFlood.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	dan := newTestClient(server, "dan")
+	alice := newTestClient(server, "alice")
+	server.clients.Add(alice, "alice")
+	dan.history = history.NewHistoryBuffer(0)
+	alice.history = history.NewHistoryBuffer(0)
+	channel := NewChannel(server, "#test", false)
+	server.channels.Add(channel)
+	channel.members.Add(dan)
+	channel.members[dan][Voice] = true
+	channel.members.Add(alice)
+
+	for _, command := range []string{"CPRIVMSG", "CNOTICE"} {
+		cmessageHandler(server, dan, ircmsg.MakeMessage(nil, "", command, "alice", "#test", "\x01VERSION\x01"))
+	}
+	if lines := sentLines(alice); len(lines) != 1 {
+		t.Errorf("expected CTCPs over the limit to be dropped, got %q", lines)
+	}
+}
@@ -0,0 +1,523 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// maxDashboardAccounts is the most accounts an account search on the dashboard shows.
+	maxDashboardAccounts = 50
+	// dashboardTimeFormat is how times are shown on the dashboard.
+	dashboardTimeFormat = "2006-01-02 15:04:05 MST"
+	// dashboardSessionCookie is the cookie that holds an oper's dashboard session.
+	dashboardSessionCookie = "oragono-dashboard"
+	// dashboardSessionDuration is how long a dashboard session (and its form token) lasts.
+	dashboardSessionDuration = 12 * time.Hour
+	// dashboardLoginAttempts is how many failed logins each IP gets in dashboardLoginPeriod.
+	dashboardLoginAttempts = 5
+	dashboardLoginPeriod   = 5 * time.Minute
+)
+
+// DashboardConfig controls the oper web dashboard, which is served from the REST API's
+// listener. Opers log into it with their oper name and password.
+type DashboardConfig struct {
+	Enabled bool
+}
+
+// dashboard serves the oper web dashboard.
+type dashboard struct {
+	server *Server
+	// throttle limits failed logins, which are always throttled here because oper
+	// passwords are worth more than account passphrases.
+	throttle *LoginThrottle
+
+	sessionsMutex sync.Mutex
+	// sessions maps session ID -> session
+	sessions map[string]dashboardSession
+}
+
+// dashboardSession is a browser that an oper has logged into the dashboard from.
+type dashboardSession struct {
+	oper string
+	// token is sent with every form, so other sites can't make an oper's browser
+	// change bans for them.
+	token   string
+	expires time.Time
+}
+
+// dashboardOper is an oper who's logged into the dashboard.
+type dashboardOper struct {
+	Name  string
+	Class *OperClass
+	// Token is the form token of the oper's session.
+	Token string
+}
+
+type dashboardNotice struct {
+	Time    string
+	Mask    string
+	Content string
+}
+
+type dashboardBan struct {
+	Mask       string
+	Reason     string
+	OperReason string
+	Expires    string
+}
+
+type dashboardAccount struct {
+	Name         string
+	RegisteredAt string
+	Verified     bool
+	Clients      int
+}
+
+type dashboardBanList struct {
+	Title       string
+	Type        string
+	Placeholder string
+	Bans        []dashboardBan
+}
+
+type dashboardPage struct {
+	NetworkName string
+	ServerName  string
+	Version     string
+	Oper        string
+	Token       string
+	Message     string
+	Stats       LUserStats
+	Notices     []dashboardNotice
+	BanLists    []dashboardBanList
+	CanBan      bool
+	CanUnban    bool
+	Search      string
+	Searched    bool
+	Accounts    []dashboardAccount
+}
+
+// startDashboard adds the oper dashboard to the given REST API router.
+func (server *Server) startDashboard(router *mux.Router) {
+	d := &dashboard{
+		server: server,
+		throttle: NewLoginThrottle(LoginThrottleConfig{
+			Enabled:     true,
+			Duration:    dashboardLoginPeriod,
+			MaxAttempts: dashboardLoginAttempts,
+		}),
+		sessions: make(map[string]dashboardSession),
+	}
+
+	router.HandleFunc("/dashboard", d.authenticate(d.handleIndex)).Methods("GET")
+	router.HandleFunc("/dashboard/stats", d.authenticate(d.handleStats)).Methods("GET")
+	router.HandleFunc("/dashboard/bans", d.authenticate(d.handleBans)).Methods("POST")
+}
+
+// authenticate makes sure that requests to the given handler come from an oper.
+func (d *dashboard) authenticate(handler func(http.ResponseWriter, *http.Request, dashboardOper)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !d.throttle.Allowed(ip) {
+			http.Error(w, errLoginThrottled.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		name, password, ok := r.BasicAuth()
+		if ok {
+			casefoldedName, err := CasefoldName(name)
+			oper, exists := d.server.Oper(casefoldedName)
+			if err == nil && exists && ComparePassword(d.server.operPasswordHash(casefoldedName, oper), []byte(password)) == nil {
+				d.throttle.Succeeded(ip)
+				token, err := d.session(w, r, casefoldedName)
+				if err != nil {
+					d.server.logger.Error("internal", fmt.Sprintf("Could not start a dashboard session: %s", err.Error()))
+					http.Error(w, "Could not start a session", http.StatusInternalServerError)
+					return
+				}
+				handler(w, r, dashboardOper{
+					Name:  name,
+					Class: oper.Class,
+					Token: token,
+				})
+				return
+			}
+			// browsers ask without credentials first, so only count wrong ones
+			d.throttle.Failed(ip)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="oragono"`)
+		http.Error(w, "Log in with your oper name and password", http.StatusUnauthorized)
+	}
+}
+
+// session returns the form token of the given oper's session in this browser,
+// starting a new session if there isn't one.
+func (d *dashboard) session(w http.ResponseWriter, r *http.Request, oper string) (string, error) {
+	d.sessionsMutex.Lock()
+	defer d.sessionsMutex.Unlock()
+
+	now := time.Now()
+	cookie, err := r.Cookie(dashboardSessionCookie)
+	if err == nil {
+		session, exists := d.sessions[cookie.Value]
+		if exists && session.oper == oper && now.Before(session.expires) {
+			return session.token, nil
+		}
+	}
+
+	// only new sessions make the map grow, so this is when we clear out old ones
+	for id, session := range d.sessions {
+		if !now.Before(session.expires) {
+			delete(d.sessions, id)
+		}
+	}
+
+	values := make([]byte, 32)
+	_, err = rand.Read(values)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(values[:16])
+	session := dashboardSession{
+		oper:    oper,
+		token:   hex.EncodeToString(values[16:]),
+		expires: now.Add(dashboardSessionDuration),
+	}
+	d.sessions[id] = session
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardSessionCookie,
+		Value:    id,
+		Path:     "/dashboard",
+		Expires:  session.expires,
+		HttpOnly: true,
+	})
+	return session.token, nil
+}
+
+func (d *dashboard) handleStats(w http.ResponseWriter, r *http.Request, oper dashboardOper) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.server.LUserStats())
+}
+
+func (d *dashboard) handleIndex(w http.ResponseWriter, r *http.Request, oper dashboardOper) {
+	server := d.server
+	page := dashboardPage{
+		NetworkName: server.Config().Network.Name,
+		ServerName:  server.name,
+		Version:     SemVer,
+		Oper:        oper.Name,
+		Token:       oper.Token,
+		Message:     r.URL.Query().Get("message"),
+		Stats:       server.LUserStats(),
+		BanLists: []dashboardBanList{
+			{
+				Title:       "D-Lines",
+				Type:        "D-LINE",
+				Placeholder: "IP address or CIDR network",
+				Bans:        dashboardBans(server.dlines.AllBans()),
+			},
+			{
+				Title:       "K-Lines",
+				Type:        "K-LINE",
+				Placeholder: "nick!user@host mask",
+				Bans:        dashboardBans(server.klines.AllBans()),
+			},
+		},
+		CanBan:   oper.Class.Capabilities["oper:local_ban"],
+		CanUnban: oper.Class.Capabilities["oper:local_unban"],
+		Search:   r.URL.Query().Get("account"),
+	}
+
+	for _, notice := range server.snomasks.Recent() {
+		name := sno.NoticeMaskNames[notice.Mask]
+		if name == "" {
+			name = string(notice.Mask)
+		}
+		page.Notices = append(page.Notices, dashboardNotice{
+			Time:    notice.Time.Format(dashboardTimeFormat),
+			Mask:    name,
+			Content: stripIRCFormatting(notice.Content),
+		})
+	}
+
+	if page.Search != "" {
+		page.Searched = true
+		page.Accounts = d.searchAccounts(page.Search)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, page)
+	if err != nil {
+		server.logger.Error("internal", fmt.Sprintf("Could not render the oper dashboard: %s", err.Error()))
+	}
+}
+
+// dashboardBans returns the given bans, sorted by their masks.
+func dashboardBans(bans map[string]IPBanInfo) []dashboardBan {
+	var result []dashboardBan
+	for mask, info := range bans {
+		expires := "never"
+		if info.Time != nil {
+			expires = info.Time.Expires.Format(dashboardTimeFormat)
+		}
+		result = append(result, dashboardBan{
+			Mask:       mask,
+			Reason:     info.Reason,
+			OperReason: info.OperReason,
+			Expires:    expires,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Mask < result[j].Mask
+	})
+	return result
+}
+
+// searchAccounts returns the accounts whose names contain the given text.
+func (d *dashboard) searchAccounts(search string) []dashboardAccount {
+	server := d.server
+	search, err := Casefold(search)
+	if err != nil {
+		return nil
+	}
+
+	var accounts []dashboardAccount
+	server.store.View(func(tx *buntdb.Tx) error {
+		tx.AscendKeys("account.exists *", func(key, value string) bool {
+			key = strings.TrimPrefix(key, "account.exists ")
+			if !strings.Contains(key, search) {
+				return true
+			}
+
+			name, _ := tx.Get(fmt.Sprintf(keyAccountName, key))
+			regTimeStr, _ := tx.Get(fmt.Sprintf(keyAccountRegTime, key))
+			regTimeInt, _ := strconv.ParseInt(regTimeStr, 10, 64)
+			_, err := tx.Get(fmt.Sprintf(keyAccountVerified, key))
+
+			clients := len(server.loggedInClients(key))
+
+			accounts = append(accounts, dashboardAccount{
+				Name:         name,
+				RegisteredAt: time.Unix(regTimeInt, 0).Format(dashboardTimeFormat),
+				Verified:     err == nil,
+				Clients:      clients,
+			})
+			return len(accounts) < maxDashboardAccounts
+		})
+		return nil
+	})
+	return accounts
+}
+
+// handleBans adds and removes D-Lines and K-Lines.
+func (d *dashboard) handleBans(w http.ResponseWriter, r *http.Request, oper dashboardOper) {
+	r.ParseForm()
+	if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(oper.Token)) != 1 {
+		http.Error(w, "Invalid form token, reload the dashboard and try again", http.StatusForbidden)
+		return
+	}
+
+	message, err := d.changeBan(oper, r.PostForm)
+	if err != nil {
+		message = err.Error()
+	}
+	http.Redirect(w, r, "/dashboard?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// changeBan adds or removes the ban described by the given form, returning a message
+// saying what happened.
+func (d *dashboard) changeBan(oper dashboardOper, form url.Values) (string, error) {
+	server := d.server
+	action := form.Get("action")
+	banType := strings.ToUpper(form.Get("type"))
+	mask := strings.TrimSpace(form.Get("mask"))
+
+	if action == "add" && !oper.Class.Capabilities["oper:local_ban"] || action == "remove" && !oper.Class.Capabilities["oper:local_unban"] {
+		return "", fmt.Errorf("Insufficient oper privs")
+	}
+	if mask == "" {
+		return "", fmt.Errorf("No mask given")
+	}
+
+	var info IPBanInfo
+	if action == "add" {
+		info.Reason = strings.TrimSpace(form.Get("reason"))
+		if info.Reason == "" {
+			info.Reason = "No reason given"
+		}
+		info.OperReason = strings.TrimSpace(form.Get("oper-reason"))
+		if info.OperReason == "" {
+			info.OperReason = info.Reason
+		}
+		if form.Get("duration") != "" {
+			duration, err := custime.ParseDuration(form.Get("duration"))
+			if err != nil {
+				return "", fmt.Errorf("Could not parse duration: %s", err.Error())
+			}
+			info.Time = &IPRestrictTime{
+				Duration: duration,
+				Expires:  time.Now().Add(duration),
+			}
+		}
+	} else if action != "remove" {
+		return "", fmt.Errorf("Unknown action")
+	}
+
+	var err error
+	switch banType {
+	case "D-LINE":
+		var hostAddr net.IP
+		var hostNet *net.IPNet
+		mask, hostAddr, hostNet, err = parseDLineHost(mask)
+		if err != nil {
+			return "", err
+		}
+		if action == "add" {
+			err = server.addDLine(mask, hostAddr, hostNet, info)
+		} else {
+			err = server.removeDLine(mask, hostAddr, hostNet)
+		}
+	case "K-LINE":
+		mask = normalizeKLineMask(strings.ToLower(mask))
+		if action == "add" {
+			err = server.addKLine(mask, info)
+		} else {
+			err = server.removeKLine(mask)
+		}
+	default:
+		return "", fmt.Errorf("Unknown ban type")
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not change %s for %s: %s", banType, mask, err.Error())
+	}
+
+	var description string
+	if action == "add" && info.Time != nil {
+		description = fmt.Sprintf("added temporary (%s) %s for %s", info.Time.Duration.String(), banType, mask)
+	} else if action == "add" {
+		description = fmt.Sprintf("added %s for %s", banType, mask)
+	} else {
+		description = fmt.Sprintf("removed %s for %s", banType, mask)
+	}
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r %s from the web dashboard"), oper.Name, description))
+	return fmt.Sprintf("You %s", description), nil
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.NetworkName}} - {{.ServerName}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.message { background: #ffd; border: 1px solid #cc9; padding: 0.5em; }
+.stats td { font-size: 1.4em; text-align: center; }
+</style>
+</head>
+<body>
+<h1>{{.NetworkName}} <small>{{.ServerName}}, oragono {{.Version}}</small></h1>
+<p>Logged in as {{.Oper}}.</p>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+
+<h2>Users</h2>
+<table class="stats">
+<tr><th>Users</th><th>Invisible</th><th>Opers</th><th>Unregistered</th><th>Channels</th><th>Max users</th><th>Connections received</th></tr>
+<tr>
+<td data-stat="users">{{.Stats.Users}}</td>
+<td data-stat="invisible">{{.Stats.Invisible}}</td>
+<td data-stat="opers">{{.Stats.Opers}}</td>
+<td data-stat="unknown">{{.Stats.Unknown}}</td>
+<td data-stat="channels">{{.Stats.Channels}}</td>
+<td data-stat="max-local">{{.Stats.MaxLocal}}</td>
+<td data-stat="connections-received">{{.Stats.ConnectionsReceived}}</td>
+</tr>
+</table>
+
+<h2>Recent server notices</h2>
+{{if .Notices}}
+<table>
+<tr><th>Time</th><th>Type</th><th>Notice</th></tr>
+{{range .Notices}}<tr><td>{{.Time}}</td><td>{{.Mask}}</td><td>{{.Content}}</td></tr>
+{{end}}</table>
+{{else}}<p>No server notices yet.</p>{{end}}
+
+{{range .BanLists}}{{$list := .}}
+<h2>{{.Title}}</h2>
+{{if .Bans}}
+<table>
+<tr><th>Mask</th><th>Reason</th><th>Oper reason</th><th>Expires</th>{{if $.CanUnban}}<th></th>{{end}}</tr>
+{{range .Bans}}<tr><td>{{.Mask}}</td><td>{{.Reason}}</td><td>{{.OperReason}}</td><td>{{.Expires}}</td>
+{{if $.CanUnban}}<td><form method="post" action="/dashboard/bans">
+<input type="hidden" name="token" value="{{$.Token}}">
+<input type="hidden" name="action" value="remove">
+<input type="hidden" name="type" value="{{$list.Type}}">
+<input type="hidden" name="mask" value="{{.Mask}}">
+<button type="submit">Remove</button>
+</form></td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p>No {{.Title}}.</p>{{end}}
+{{if $.CanBan}}<form method="post" action="/dashboard/bans">
+<input type="hidden" name="token" value="{{$.Token}}">
+<input type="hidden" name="action" value="add">
+<input type="hidden" name="type" value="{{.Type}}">
+<input name="mask" placeholder="{{.Placeholder}}" required>
+<input name="duration" placeholder="Duration (such as 1h30m)">
+<input name="reason" placeholder="Reason">
+<input name="oper-reason" placeholder="Oper reason">
+<button type="submit">Add</button>
+</form>{{end}}
+{{end}}
+
+<h2>Accounts</h2>
+<form method="get" action="/dashboard">
+<input name="account" value="{{.Search}}" placeholder="Account name">
+<button type="submit">Search</button>
+</form>
+{{if .Searched}}{{if .Accounts}}
+<table>
+<tr><th>Account</th><th>Registered</th><th>Verified</th><th>Clients online</th></tr>
+{{range .Accounts}}<tr><td>{{.Name}}</td><td>{{.RegisteredAt}}</td><td>{{if .Verified}}yes{{else}}no{{end}}</td><td>{{.Clients}}</td></tr>
+{{end}}</table>
+{{else}}<p>No accounts found.</p>{{end}}{{end}}
+
+<script>
+setInterval(function() {
+	fetch("/dashboard/stats", {credentials: "same-origin"}).then(function(response) {
+		return response.json();
+	}).then(function(stats) {
+		document.querySelectorAll("[data-stat]").forEach(function(cell) {
+			cell.textContent = stats[cell.getAttribute("data-stat")];
+		});
+	});
+}, 5000);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,266 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/tidwall/buntdb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newDashboardTestServer(t *testing.T) (*Server, *httptest.Server) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatalf("could not open datastore: %s", err.Error())
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("operpass"), bcrypt.MinCost)
+	logman, _ := logger.NewManager()
+	server := &Server{
+		accounts: make(map[string]*ClientAccount),
+		clients:  NewClientLookupSet(),
+		channels: *NewChannelNameMap(),
+		config:   &Config{},
+		dlines:   NewDLineManager(),
+		klines:   NewKLineManager(),
+		logger:   logman,
+		operators: map[string]Oper{
+			"dan": {
+				Class: &OperClass{Capabilities: map[string]bool{"oper:local_ban": true, "oper:local_unban": true}},
+				Pass:  hash,
+			},
+			"helper": {
+				Class: &OperClass{Capabilities: map[string]bool{}},
+				Pass:  hash,
+			},
+		},
+		snomasks:           NewSnoManager(),
+		store:              store,
+		unregisteredLimits: NewUnregisteredLimits(0),
+	}
+
+	router := mux.NewRouter()
+	server.startDashboard(router)
+	return server, httptest.NewServer(router)
+}
+
+func TestDashboardLogin(t *testing.T) {
+	_, web := newDashboardTestServer(t)
+	defer web.Close()
+
+	for _, login := range []struct {
+		name, password string
+		status         int
+	}{
+		{"dan", "operpass", http.StatusOK},
+		{"Dan", "operpass", http.StatusOK},
+		{"dan", "wrong", http.StatusUnauthorized},
+		{"nobody", "operpass", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		request, _ := http.NewRequest("GET", web.URL+"/dashboard", nil)
+		if login.name != "" {
+			request.SetBasicAuth(login.name, login.password)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("could not load the dashboard: %s", err.Error())
+		}
+		response.Body.Close()
+		if response.StatusCode != login.status {
+			t.Errorf("expected logging in as %q to give status %d, got %d", login.name, login.status, response.StatusCode)
+		}
+	}
+}
+
+func TestDashboardBans(t *testing.T) {
+	server, web := newDashboardTestServer(t)
+	web.Close()
+	d := &dashboard{server: server}
+
+	dan := dashboardOper{Name: "dan", Class: server.operators["dan"].Class}
+	helper := dashboardOper{Name: "helper", Class: server.operators["helper"].Class}
+
+	_, err := d.changeBan(helper, url.Values{"action": {"add"}, "type": {"d-line"}, "mask": {"203.0.113.5"}})
+	if err == nil {
+		t.Error("expected opers without the ban capability to not be able to add bans")
+	}
+
+	message, err := d.changeBan(dan, url.Values{"action": {"add"}, "type": {"d-line"}, "mask": {"203.0.113.0/24"}, "duration": {"1h"}, "reason": {"spam"}})
+	if err != nil {
+		t.Fatalf("could not add D-Line: %s", err.Error())
+	}
+	if message != "You added temporary (1h0m0s) D-LINE for 203.0.113.0/24" {
+		t.Errorf("unexpected message: %s", message)
+	}
+	info, exists := server.dlines.AllBans()["203.0.113.0/24"]
+	if !exists || info.Reason != "spam" || info.OperReason != "spam" || info.Time == nil {
+		t.Errorf("D-Line wasn't added properly: %v", info)
+	}
+
+	_, err = d.changeBan(dan, url.Values{"action": {"add"}, "type": {"k-line"}, "mask": {"Spammer"}})
+	if err != nil {
+		t.Fatalf("could not add K-Line: %s", err.Error())
+	}
+	if _, exists := server.klines.AllBans()["spammer!*@*"]; !exists {
+		t.Error("K-Line wasn't added with a normalised mask")
+	}
+
+	_, err = d.changeBan(dan, url.Values{"action": {"remove"}, "type": {"d-line"}, "mask": {"203.0.113.0/24"}})
+	if err != nil {
+		t.Fatalf("could not remove D-Line: %s", err.Error())
+	}
+	if 0 < len(server.dlines.AllBans()) {
+		t.Error("D-Line wasn't removed")
+	}
+	_, err = d.changeBan(dan, url.Values{"action": {"remove"}, "type": {"d-line"}, "mask": {"203.0.113.0/24"}})
+	if err == nil {
+		t.Error("expected removing a D-Line that doesn't exist to fail")
+	}
+	_, err = d.changeBan(dan, url.Values{"action": {"add"}, "type": {"d-line"}, "mask": {"not an ip"}})
+	if err == nil {
+		t.Error("expected an invalid D-Line to fail")
+	}
+
+	recent := server.snomasks.Recent()
+	if len(recent) != 3 || recent[0].Mask != sno.LocalXline || !strings.Contains(recent[0].Content, "removed D-LINE for 203.0.113.0/24 from the web dashboard") {
+		t.Errorf("unexpected snomasks: %v", recent)
+	}
+}
+
+func TestDashboardFormToken(t *testing.T) {
+	server, web := newDashboardTestServer(t)
+	defer web.Close()
+
+	request, _ := http.NewRequest("POST", web.URL+"/dashboard/bans", strings.NewReader(url.Values{"action": {"add"}, "type": {"d-line"}, "mask": {"203.0.113.5"}, "token": {"wrong"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("dan", "operpass")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("could not post to the dashboard: %s", err.Error())
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("expected an invalid token to be forbidden, got status %d", response.StatusCode)
+	}
+	if 0 < len(server.dlines.AllBans()) {
+		t.Error("D-Line was added without a valid token")
+	}
+}
+
+func TestDashboardLoginThrottle(t *testing.T) {
+	_, web := newDashboardTestServer(t)
+	defer web.Close()
+
+	login := func(password string) int {
+		request, _ := http.NewRequest("GET", web.URL+"/dashboard", nil)
+		request.SetBasicAuth("dan", password)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("could not load the dashboard: %s", err.Error())
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	for i := 0; i < dashboardLoginAttempts; i++ {
+		if status := login("wrong"); status != http.StatusUnauthorized {
+			t.Errorf("expected failed login %d to give status %d, got %d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+	if status := login("operpass"); status != http.StatusTooManyRequests {
+		t.Errorf("expected logins to be throttled after too many failures, got status %d", status)
+	}
+}
+
+// dashboardFormToken returns the form token and session cookie that the dashboard
+// gives the given request.
+func dashboardFormToken(t *testing.T, request *http.Request) (string, *http.Cookie) {
+	request.SetBasicAuth("dan", "operpass")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("could not load the dashboard: %s", err.Error())
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	match := regexp.MustCompile(`name="token" value="([0-9a-f]+)"`).FindSubmatch(body)
+	if match == nil {
+		t.Fatal("dashboard has no form token")
+	}
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == dashboardSessionCookie {
+			return string(match[1]), cookie
+		}
+	}
+	return string(match[1]), nil
+}
+
+func TestDashboardSessions(t *testing.T) {
+	server, web := newDashboardTestServer(t)
+	defer web.Close()
+
+	request, _ := http.NewRequest("GET", web.URL+"/dashboard", nil)
+	token, cookie := dashboardFormToken(t, request)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("expected an HttpOnly session cookie, got %v", cookie)
+	}
+
+	request, _ = http.NewRequest("GET", web.URL+"/dashboard", nil)
+	request.AddCookie(cookie)
+	sameToken, sameCookie := dashboardFormToken(t, request)
+	if sameToken != token || sameCookie != nil {
+		t.Error("expected the same session to keep its form token")
+	}
+
+	request, _ = http.NewRequest("GET", web.URL+"/dashboard", nil)
+	otherToken, _ := dashboardFormToken(t, request)
+	if otherToken == token {
+		t.Error("expected a new session to get a new form token")
+	}
+
+	post := func(token string, cookie *http.Cookie) int {
+		request, _ := http.NewRequest("POST", web.URL+"/dashboard/bans", strings.NewReader(url.Values{"action": {"add"}, "type": {"d-line"}, "mask": {"203.0.113.5"}, "token": {token}}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth("dan", "operpass")
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		client := &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatalf("could not post to the dashboard: %s", err.Error())
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	if status := post(token, nil); status != http.StatusForbidden {
+		t.Errorf("expected a form token without its session to be forbidden, got status %d", status)
+	}
+	if status := post(otherToken, cookie); status != http.StatusForbidden {
+		t.Errorf("expected another session's form token to be forbidden, got status %d", status)
+	}
+	if 0 < len(server.dlines.AllBans()) {
+		t.Error("D-Line was added without a valid token")
+	}
+	if status := post(token, cookie); status != http.StatusSeeOther {
+		t.Errorf("expected the session's form token to be accepted, got status %d", status)
+	}
+	if len(server.dlines.AllBans()) != 1 {
+		t.Error("D-Line wasn't added with a valid token")
+	}
+}
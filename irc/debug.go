@@ -5,19 +5,23 @@
 package irc
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"runtime/pprof"
+	"sort"
 	"time"
 
+	"code.cloudfoundry.org/bytefmt"
 	"github.com/goshuirc/irc-go/ircmsg"
 )
 
 // DEBUG GCSTATS/NUMGOROUTINE/etc
 func debugHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	if !client.flags[Operator] {
+	if !client.HasMode(Operator) {
 		return false
 	}
 
@@ -38,10 +42,41 @@ func debugHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		client.Notice(fmt.Sprintf("pause quantiles 75%%:  %s", stats.PauseQuantiles[3]))
 		client.Notice(fmt.Sprintf("pause quantiles max%%: %s", stats.PauseQuantiles[4]))
 
+	case "MEMSTATS":
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+
+		client.Notice(fmt.Sprintf("alloc:          %s", bytefmt.ByteSize(stats.Alloc)))
+		client.Notice(fmt.Sprintf("total alloc:    %s", bytefmt.ByteSize(stats.TotalAlloc)))
+		client.Notice(fmt.Sprintf("sys:            %s", bytefmt.ByteSize(stats.Sys)))
+		client.Notice(fmt.Sprintf("mallocs:        %d", stats.Mallocs))
+		client.Notice(fmt.Sprintf("frees:          %d", stats.Frees))
+		client.Notice(fmt.Sprintf("heap alloc:     %s", bytefmt.ByteSize(stats.HeapAlloc)))
+		client.Notice(fmt.Sprintf("heap sys:       %s", bytefmt.ByteSize(stats.HeapSys)))
+		client.Notice(fmt.Sprintf("heap idle:      %s", bytefmt.ByteSize(stats.HeapIdle)))
+		client.Notice(fmt.Sprintf("heap in use:    %s", bytefmt.ByteSize(stats.HeapInuse)))
+		client.Notice(fmt.Sprintf("heap released:  %s", bytefmt.ByteSize(stats.HeapReleased)))
+		client.Notice(fmt.Sprintf("heap objects:   %d", stats.HeapObjects))
+		client.Notice(fmt.Sprintf("stack in use:   %s", bytefmt.ByteSize(stats.StackInuse)))
+		client.Notice(fmt.Sprintf("next GC:        %s", bytefmt.ByteSize(stats.NextGC)))
+		client.Notice(fmt.Sprintf("num GC:         %d", stats.NumGC))
+
 	case "NUMGOROUTINE":
 		count := runtime.NumGoroutine()
 		client.Notice(fmt.Sprintf("num goroutines: %d", count))
 
+	case "GOROUTINEDUMP":
+		dumpFile := "oragono.goroutines"
+		file, err := os.Create(dumpFile)
+		if err != nil {
+			client.Notice(fmt.Sprintf("error: %s", err))
+			break
+		}
+		defer file.Close()
+		// debug=2 gives us the full stack for every goroutine, same as an unrecovered panic
+		pprof.Lookup("goroutine").WriteTo(file, 2)
+		client.Notice(fmt.Sprintf("written to %s", dumpFile))
+
 	case "PROFILEHEAP":
 		profFile := "ergonomadic.mprof"
 		file, err := os.Create(profFile)
@@ -71,6 +106,123 @@ func debugHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	case "STOPCPUPROFILE":
 		pprof.StopCPUProfile()
 		client.Notice(fmt.Sprintf("CPU profiling stopped"))
+
+	case "STARTBLOCKPROFILE":
+		runtime.SetBlockProfileRate(1)
+		client.Notice("Block profiling started")
+
+	case "STOPBLOCKPROFILE":
+		writeProfile(client, "block", "oragono.bprof")
+		runtime.SetBlockProfileRate(0)
+		client.Notice("Block profiling stopped")
+
+	case "STARTMUTEXPROFILE":
+		runtime.SetMutexProfileFraction(1)
+		client.Notice("Mutex profiling started")
+
+	case "STOPMUTEXPROFILE":
+		writeProfile(client, "mutex", "oragono.mutexprof")
+		runtime.SetMutexProfileFraction(0)
+		client.Notice("Mutex profiling stopped")
 	}
 	return false
 }
+
+// writeProfile writes out the given pprof profile to profFile and tells the client about it.
+func writeProfile(client *Client, name string, profFile string) {
+	file, err := os.Create(profFile)
+	if err != nil {
+		client.Notice(fmt.Sprintf("error: %s", err))
+		return
+	}
+	defer file.Close()
+	pprof.Lookup(name).WriteTo(file, 0)
+	client.Notice(fmt.Sprintf("written to %s", profFile))
+}
+
+// writeStateDump writes the server's goroutine stacks and state out to a new file
+// in the dump directory, and returns the file's name. The stacks are written first
+// and without taking any locks, so that even if we're deadlocked we get something
+// useful out of it.
+func (server *Server) writeStateDump() (string, error) {
+	now := time.Now().UTC()
+	filename := filepath.Join(server.Config().DumpDir(), fmt.Sprintf("oragono-dump-%s.txt", now.Format("20060102-150405")))
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+
+	fmt.Fprintf(out, "state dump for %s (%s)\n", server.name, Ver)
+	fmt.Fprintf(out, "written at:  %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(out, "started at:  %s\n", server.ctime.UTC().Format(time.RFC3339))
+	fmt.Fprintf(out, "config file: %s\n", server.configFilename)
+	fmt.Fprintf(out, "goroutines:  %d\n", runtime.NumGoroutine())
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	fmt.Fprintf(out, "heap alloc:  %s\n", bytefmt.ByteSize(stats.HeapAlloc))
+	fmt.Fprintf(out, "sys:         %s\n", bytefmt.ByteSize(stats.Sys))
+	fmt.Fprintf(out, "num GC:      %d\n", stats.NumGC)
+
+	fmt.Fprint(out, "\n== goroutines ==\n")
+	// debug=2 gives us the full stack for every goroutine, same as an unrecovered panic
+	pprof.Lookup("goroutine").WriteTo(out, 2)
+	// make sure the stacks hit the disk before we try taking any locks
+	out.Flush()
+
+	fmt.Fprint(out, "\n== listeners ==\n")
+	server.listenerUpdateMutex.Lock()
+	var addrs []string
+	for addr := range server.listeners {
+		addrs = append(addrs, addr)
+	}
+	server.listenerUpdateMutex.Unlock()
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fmt.Fprintf(out, "%s\n", addr)
+	}
+	out.Flush()
+
+	fmt.Fprint(out, "\n== clients ==\n")
+	var clientLines []string
+	for client := range server.clients.All() {
+		line := fmt.Sprintf("%s idle=%s channels=%d", client.nickMaskString, client.IdleTime().String(), len(client.channels))
+		if client.account != &NoAccount {
+			line += fmt.Sprintf(" account=%s", client.account.Name)
+		}
+		if client.operName != "" {
+			line += fmt.Sprintf(" oper=%s", client.operName)
+		}
+		clientLines = append(clientLines, line)
+	}
+	sort.Strings(clientLines)
+	fmt.Fprintf(out, "%d clients\n", len(clientLines))
+	for _, line := range clientLines {
+		fmt.Fprintf(out, "%s\n", line)
+	}
+	out.Flush()
+
+	fmt.Fprint(out, "\n== channels ==\n")
+	var channels []*Channel
+	server.channels.ChansLock.RLock()
+	for _, channel := range server.channels.Chans {
+		channels = append(channels, channel)
+	}
+	server.channels.ChansLock.RUnlock()
+	var channelLines []string
+	for _, channel := range channels {
+		channel.membersMutex.RLock()
+		channelLines = append(channelLines, fmt.Sprintf("%s members=%d modes=+%s", channel.name, len(channel.members), channel.flags.String()))
+		channel.membersMutex.RUnlock()
+	}
+	sort.Strings(channelLines)
+	fmt.Fprintf(out, "%d channels\n", len(channelLines))
+	for _, line := range channelLines {
+		fmt.Fprintf(out, "%s\n", line)
+	}
+
+	return filename, nil
+}
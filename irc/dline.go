@@ -26,7 +26,8 @@ const (
 )
 
 var (
-	errNoExistingBan = errors.New("Ban does not exist")
+	errNoExistingBan    = errors.New("Ban does not exist")
+	errInvalidDLineHost = errors.New("Could not parse IP address or CIDR network")
 )
 
 // IPRestrictTime contains the expiration info about the given IP.
@@ -186,11 +187,11 @@ func (dm *DLineManager) CheckIP(addr net.IP) (isBanned bool, info *IPBanInfo) {
 	return false, nil
 }
 
-// DLINE [ANDKILL] [MYSELF] [duration] <ip>/<net> [ON <server>] [reason [| oper reason]]
+// DLINE [ANDKILL] [MYSELF] [REPORT] [duration] <ip>/<net> [ON <server>] [reason [| oper reason]]
 func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// check oper permissions
 	if !client.class.Capabilities["oper:local_ban"] {
-		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, "Insufficient oper privs")
+		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, client.t("Insufficient oper privs"))
 		return false
 	}
 
@@ -211,6 +212,17 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		currentArg++
 	}
 
+	// "REPORT" also reports the IPs the ban covers to our abuse blacklist, such as DroneBL
+	var report bool
+	if len(msg.Params) > currentArg+1 && strings.ToLower(msg.Params[currentArg]) == "report" {
+		if !server.abuseReports.Enabled() {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, "Abuse reports are not enabled")
+			return false
+		}
+		report = true
+		currentArg++
+	}
+
 	// duration
 	duration, err := custime.ParseDuration(msg.Params[currentArg])
 	durationIsUsed := err == nil
@@ -220,7 +232,7 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	// get host
 	if len(msg.Params) < currentArg+1 {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
 		return false
 	}
 	hostString := msg.Params[currentArg]
@@ -293,32 +305,12 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		Time:       banTime,
 	}
 
-	// save in datastore
-	err = server.store.Update(func(tx *buntdb.Tx) error {
-		dlineKey := fmt.Sprintf(keyDlineEntry, hostString)
-
-		// assemble json from ban info
-		b, err := json.Marshal(info)
-		if err != nil {
-			return err
-		}
-
-		tx.Set(dlineKey, string(b), nil)
-
-		return nil
-	})
-
+	err = server.addDLine(hostString, hostAddr, hostNet, info)
 	if err != nil {
 		client.Notice(fmt.Sprintf("Could not successfully save new D-LINE: %s", err.Error()))
 		return false
 	}
 
-	if hostNet == nil {
-		server.dlines.AddIP(hostAddr, banTime, reason, operReason)
-	} else {
-		server.dlines.AddNetwork(*hostNet, banTime, reason, operReason)
-	}
-
 	var snoDescription string
 	if durationIsUsed {
 		client.Notice(fmt.Sprintf("Added temporary (%s) D-Line for %s", duration.String(), hostString))
@@ -329,6 +321,16 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	}
 	server.snomasks.Send(sno.LocalXline, snoDescription)
 
+	if report {
+		var ips []net.IP
+		if hostNet == nil {
+			ips = append(ips, hostAddr)
+		}
+		server.reportBanned(client, "DLINE", reason, ips, func(mcl *Client) bool {
+			return hostNet != nil && hostNet.Contains(mcl.IP())
+		})
+	}
+
 	var killClient bool
 	if andKill {
 		var clientsToKill []*Client
@@ -372,7 +374,7 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 func unDLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// check oper permissions
 	if !client.class.Capabilities["oper:local_unban"] {
-		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, "Insufficient oper privs")
+		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, client.t("Insufficient oper privs"))
 		return false
 	}
 
@@ -399,8 +401,61 @@ func unDLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 		hostString = hostNet.String()
 	}
 
-	// save in datastore
-	err = server.store.Update(func(tx *buntdb.Tx) error {
+	err = server.removeDLine(hostString, hostAddr, hostNet)
+	if err != nil {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, fmt.Sprintf("Could not remove ban [%s]", err.Error()))
+		return false
+	}
+
+	client.Notice(fmt.Sprintf("Removed D-Line for %s", hostString))
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed D-Line for %s"), client.nick, hostString))
+	return false
+}
+
+// parseDLineHost parses the IP address or CIDR network that a D-Line covers. Exactly
+// one of the address and network is returned, along with its normalised string.
+func parseDLineHost(hostString string) (string, net.IP, *net.IPNet, error) {
+	_, hostNet, err := net.ParseCIDR(hostString)
+	if err == nil {
+		return hostNet.String(), nil, hostNet, nil
+	}
+	hostAddr := net.ParseIP(hostString)
+	if hostAddr == nil {
+		return "", nil, nil, errInvalidDLineHost
+	}
+	return hostAddr.String(), hostAddr, nil, nil
+}
+
+// addDLine saves the given D-Line and starts enforcing it.
+func (server *Server) addDLine(hostString string, hostAddr net.IP, hostNet *net.IPNet, info IPBanInfo) error {
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		dlineKey := fmt.Sprintf(keyDlineEntry, hostString)
+
+		// assemble json from ban info
+		b, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+
+		tx.Set(dlineKey, string(b), nil)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hostNet == nil {
+		server.dlines.AddIP(hostAddr, info.Time, info.Reason, info.OperReason)
+	} else {
+		server.dlines.AddNetwork(*hostNet, info.Time, info.Reason, info.OperReason)
+	}
+	return nil
+}
+
+// removeDLine stops enforcing the given D-Line and removes it from the datastore.
+func (server *Server) removeDLine(hostString string, hostAddr net.IP, hostNet *net.IPNet) error {
+	err := server.store.Update(func(tx *buntdb.Tx) error {
 		dlineKey := fmt.Sprintf(keyDlineEntry, hostString)
 
 		// check if it exists or not
@@ -414,10 +469,8 @@ func unDLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 		tx.Delete(dlineKey)
 		return nil
 	})
-
 	if err != nil {
-		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, fmt.Sprintf("Could not remove ban [%s]", err.Error()))
-		return false
+		return err
 	}
 
 	if hostNet == nil {
@@ -425,10 +478,7 @@ func unDLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 	} else {
 		server.dlines.RemoveNetwork(*hostNet)
 	}
-
-	client.Notice(fmt.Sprintf("Removed D-Line for %s", hostString))
-	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed D-Line for %s"), client.nick, hostString))
-	return false
+	return nil
 }
 
 func (s *Server) loadDLines() {
@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmatch"
+)
+
+const (
+	// extbanPrefix starts list entries that are extbans rather than nickmasks.
+	extbanPrefix = "$"
+	// extbanTypes are the extban types we support, as advertised in EXTBAN.
+	extbanTypes = "a"
+)
+
+// extban is a ban, exception or invite exception list entry that matches clients on
+// something other than their nickmask. They look like $a:dan, or $~a to negate them.
+type extban struct {
+	negated bool
+	kind    byte
+	// mask is nil if the extban matches everything of its type, like $a does.
+	mask *ircmatch.Matcher
+}
+
+// parseExtban parses the given casefolded list entry, which starts with extbanPrefix.
+func parseExtban(entry string) (ban extban, valid bool) {
+	entry = strings.TrimPrefix(entry, extbanPrefix)
+	if strings.HasPrefix(entry, "~") {
+		ban.negated = true
+		entry = entry[1:]
+	}
+	if len(entry) < 1 || !strings.Contains(extbanTypes, entry[:1]) {
+		return ban, false
+	}
+	ban.kind = entry[0]
+
+	entry = entry[1:]
+	if entry == "" {
+		return ban, true
+	}
+	if entry[0] != ':' || len(entry) < 2 {
+		return ban, false
+	}
+	matcher := ircmatch.MakeMatch(entry[1:])
+	ban.mask = &matcher
+	return ban, true
+}
+
+// Match returns true if the extban matches the given client.
+func (ban *extban) Match(client *Client) bool {
+	var matched bool
+	switch ban.kind {
+	case 'a':
+		if client.account != &NoAccount {
+			matched = true
+			if ban.mask != nil {
+				accountName, err := CasefoldName(client.account.Name)
+				matched = err == nil && ban.mask.Match(accountName)
+			}
+		}
+	}
+	return matched != ban.negated
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestExtbans(t *testing.T) {
+	loggedIn := &Client{account: &ClientAccount{Name: "Dan"}, nickMaskCasefolded: "dan!~d@example.com"}
+	loggedOut := &Client{account: &NoAccount, nickMaskCasefolded: "guest!~g@example.com"}
+
+	type extbanTest struct {
+		entry     string
+		loggedIn  bool
+		loggedOut bool
+	}
+	tests := []extbanTest{
+		{"$a", true, false},
+		{"$~a", false, true},
+		{"$a:dan", true, false},
+		{"$a:d*", true, false},
+		{"$a:someone", false, false},
+		{"$~a:someone", true, true},
+	}
+	for _, test := range tests {
+		set := NewUserMaskSet()
+		set.Add(test.entry)
+		if set.MatchClient(loggedIn) != test.loggedIn {
+			t.Errorf("%s: expected %t for a logged-in client", test.entry, test.loggedIn)
+		}
+		if set.MatchClient(loggedOut) != test.loggedOut {
+			t.Errorf("%s: expected %t for a logged-out client", test.entry, test.loggedOut)
+		}
+	}
+
+	for _, entry := range []string{"$", "$~", "$z:dan", "$a:", "$adan"} {
+		_, valid := parseExtban(entry)
+		if valid {
+			t.Errorf("%s: expected an invalid extban", entry)
+		}
+	}
+
+	// nickmasks and extbans can be mixed in the same list
+	set := NewUserMaskSet()
+	set.Add("guest!*@*")
+	set.Add("$a:dan")
+	if !set.MatchClient(loggedIn) || !set.MatchClient(loggedOut) {
+		t.Error("expected both the nickmask and the extban to match")
+	}
+	set.Remove("guest!*@*")
+	if !set.MatchClient(loggedIn) || set.MatchClient(loggedOut) {
+		t.Error("expected only the extban to match after removing the nickmask")
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+var (
+	errExternalAuthBadConfig   = errors.New("External auth needs exactly one of url or command")
+	errExternalAuthBadResponse = errors.New("External auth service returned an error")
+)
+
+// ExternalAuthConfig controls checking account passphrases with an outside service,
+// such as a network's existing user database.
+type ExternalAuthConfig struct {
+	Enabled       bool
+	URL           string
+	Command       string
+	Args          []string
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+	// MaxConcurrentRequests is how many logins can be waiting on the service at once.
+	MaxConcurrentRequests int `yaml:"max-concurrent-requests"`
+	// Autocreate creates accounts the service accepts that we don't have yet.
+	Autocreate bool
+	// LocalFallback checks the passphrases we've stored ourselves when the service
+	// doesn't accept a login (or can't be reached).
+	LocalFallback bool `yaml:"local-fallback"`
+}
+
+// parse checks the config and fills in its defaults.
+func (conf *ExternalAuthConfig) parse() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if (conf.URL == "") == (conf.Command == "") {
+		return errExternalAuthBadConfig
+	}
+	if conf.URL != "" {
+		err := checkWebhook(conf.URL, true)
+		if err != nil {
+			return err
+		}
+	}
+	conf.Timeout = defaultExternalAuthTimeout
+	if conf.TimeoutString != "" {
+		var err error
+		conf.Timeout, err = time.ParseDuration(conf.TimeoutString)
+		if err != nil {
+			return fmt.Errorf("Could not parse timeout: %s", err.Error())
+		}
+	}
+	if conf.MaxConcurrentRequests < 0 {
+		return errors.New("max-concurrent-requests can't be negative")
+	}
+	if conf.MaxConcurrentRequests == 0 {
+		conf.MaxConcurrentRequests = defaultExternalAuthConcurrency
+	}
+	return nil
+}
+
+// ExternalAuthRequest is what we send to the external auth service, either POSTed
+// (as JSON) to its URL, or written to its command's stdin.
+type ExternalAuthRequest struct {
+	Account    string `json:"account"`
+	Passphrase string `json:"passphrase"`
+	IP         string `json:"ip"`
+	Certfp     string `json:"certfp,omitempty"`
+}
+
+// ExternalAuthResponse is what the external auth service answers with. Account is the
+// name of the account to log into, if it's different from the one that was given.
+// Metadata can include a vhost, which is set on the account.
+type ExternalAuthResponse struct {
+	Success  bool              `json:"success"`
+	Account  string            `json:"account"`
+	Error    string            `json:"error"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// check asks the external auth service whether the request's passphrase is right.
+func (conf *ExternalAuthConfig) check(request ExternalAuthRequest) (response ExternalAuthResponse, err error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+
+	var output []byte
+	if conf.URL != "" {
+		output, err = conf.checkURL(body)
+	} else {
+		output, err = conf.checkCommand(body)
+	}
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(output, &response)
+	return
+}
+
+// checkURL POSTs the request to the service's URL, and returns its answer.
+func (conf *ExternalAuthConfig) checkURL(body []byte) ([]byte, error) {
+	httpClient := http.Client{
+		Timeout: conf.Timeout,
+	}
+	response, err := httpClient.Post(conf.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || 299 < response.StatusCode {
+		return nil, errExternalAuthBadResponse
+	}
+	var output bytes.Buffer
+	_, err = output.ReadFrom(response.Body)
+	return output.Bytes(), err
+}
+
+// checkCommand runs the service's command with the request on its stdin, and returns
+// what it writes to stdout.
+func (conf *ExternalAuthConfig) checkCommand(body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, conf.Command, conf.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	return cmd.Output()
+}
+
+// checkAccountPassphrase returns the account that the given name and passphrase log
+// into, checking them with the external auth service if it's enabled. Clients that
+// fail too many logins are throttled.
+func (server *Server) checkAccountPassphrase(client *Client, name string, passphrase string) (*ClientAccount, error) {
+	ip := client.IP().String()
+	if !server.loginThrottle.Allowed(ip) {
+		return nil, errLoginThrottled
+	}
+
+	account, err := server.checkAnyPassphrase(client, name, passphrase)
+	if err == nil {
+		server.loginThrottle.Succeeded(ip)
+	} else {
+		server.loginThrottle.Failed(ip)
+	}
+	return account, err
+}
+
+// checkAnyPassphrase checks the name and passphrase with the external auth service if
+// it's enabled, or against the passphrases we've stored.
+func (server *Server) checkAnyPassphrase(client *Client, name string, passphrase string) (*ClientAccount, error) {
+	config := server.Config().Accounts.ExternalAuth
+	if !config.Enabled {
+		return server.checkLocalPassphrase(name, passphrase)
+	}
+
+	account, err := server.checkExternalPassphrase(client, config, name, passphrase)
+	if err != nil && config.LocalFallback {
+		return server.checkLocalPassphrase(name, passphrase)
+	}
+	return account, err
+}
+
+// startExternalAuth returns true if another request can be made to the external auth
+// service right now. If it does, finishExternalAuth must be called once it's answered.
+func (server *Server) startExternalAuth(config ExternalAuthConfig) bool {
+	server.externalAuthMutex.Lock()
+	defer server.externalAuthMutex.Unlock()
+
+	if config.MaxConcurrentRequests <= server.externalAuthRunning {
+		return false
+	}
+	server.externalAuthRunning++
+	return true
+}
+
+// finishExternalAuth notes that a request to the external auth service has finished.
+func (server *Server) finishExternalAuth() {
+	server.externalAuthMutex.Lock()
+	defer server.externalAuthMutex.Unlock()
+
+	server.externalAuthRunning--
+}
+
+// checkExternalPassphrase checks the name and passphrase with the external auth service,
+// and returns the account it says they log into.
+func (server *Server) checkExternalPassphrase(client *Client, config ExternalAuthConfig, name string, passphrase string) (*ClientAccount, error) {
+	if name == "" || passphrase == "" {
+		return nil, errSaslFail
+	}
+
+	// a slow service shouldn't let logins pile up waiting on it
+	if !server.startExternalAuth(config) {
+		server.logger.Warning("accounts", fmt.Sprintf("Could not check passphrase for account %s with external auth: too many requests are waiting on it", name))
+		return nil, errSaslFail
+	}
+	response, err := config.check(ExternalAuthRequest{
+		Account:    name,
+		Passphrase: passphrase,
+		IP:         client.IP().String(),
+		Certfp:     client.certfp,
+	})
+	server.finishExternalAuth()
+	if err != nil {
+		server.logger.Warning("accounts", fmt.Sprintf("Could not check passphrase for account %s with external auth: %s", name, err.Error()))
+		return nil, errSaslFail
+	}
+	if !response.Success {
+		if response.Error != "" {
+			server.logger.Debug("accounts", fmt.Sprintf("External auth rejected account %s: %s", name, response.Error))
+		}
+		return nil, errSaslFail
+	}
+
+	if response.Account != "" {
+		name = response.Account
+	}
+	accountKey, err := CasefoldName(name)
+	if err != nil {
+		server.logger.Warning("accounts", fmt.Sprintf("External auth accepted invalid account name %s", name))
+		return nil, errSaslFail
+	}
+
+	account, err := server.loadExternalAccount(accountKey, name, config.Autocreate, "external auth")
+	if err != nil {
+		return nil, errSaslFail
+	}
+
+	vhost, hasVHost := response.Metadata["vhost"]
+	if hasVHost && vhost != account.VHost && (vhost == "" || IsHostname(vhost)) {
+		_, err = server.setAccountVHost(accountKey, vhost)
+		if err != nil {
+			server.logger.Error("accounts", fmt.Sprintf("Could not set vhost from external auth for account %s: %s", name, err.Error()))
+		}
+	}
+
+	return account, nil
+}
+
+// loadExternalAccount returns the verified account that an outside service has logged
+// a client into, creating it first if autocreate is true and we don't have it yet.
+func (server *Server) loadExternalAccount(accountKey string, name string, autocreate bool, source string) (*ClientAccount, error) {
+	var account *ClientAccount
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(fmt.Sprintf(keyAccountExists, accountKey))
+		if err == buntdb.ErrNotFound {
+			if !autocreate {
+				return errAccountDoesNotExist
+			}
+			err = createExternalAccount(tx, accountKey, name)
+			if err != nil {
+				return err
+			}
+			server.accountSkeletons.Add(accountKey)
+			server.logger.Info("accounts", fmt.Sprintf("Created account %s from %s", name, source))
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Get(fmt.Sprintf(keyAccountVerified, accountKey))
+		if err != nil {
+			return errSaslFail
+		}
+
+		account = loadAccount(server, tx, accountKey)
+		return nil
+	})
+	return account, err
+}
+
+// createExternalAccount stores a new, verified account for a user the external auth
+// service knows about. It has no passphrase of its own, so it can only be logged into
+// through the service (or with a certfp).
+func createExternalAccount(tx *buntdb.Tx, accountKey string, name string) error {
+	var creds AccountCredentials
+	var err error
+	creds.PassphraseSalt, err = NewSalt()
+	if err != nil {
+		return err
+	}
+	credText, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	tx.Set(fmt.Sprintf(keyAccountExists, accountKey), "1", nil)
+	tx.Set(fmt.Sprintf(keyAccountName, accountKey), name, nil)
+	tx.Set(fmt.Sprintf(keyAccountRegTime, accountKey), strconv.FormatInt(time.Now().Unix(), 10), nil)
+	tx.Set(fmt.Sprintf(keyAccountCredentials, accountKey), string(credText), nil)
+	tx.Set(fmt.Sprintf(keyAccountVerified, accountKey), "1", nil)
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// testExternalAuth accepts dan's passphrase, and logs them into the account Dan.
+func testExternalAuth(request ExternalAuthRequest) ExternalAuthResponse {
+	if request.Account == "dan" && request.Passphrase == "hunter2" {
+		return ExternalAuthResponse{
+			Success:  true,
+			Account:  "Dan",
+			Metadata: map[string]string{"vhost": "staff.example.com"},
+		}
+	}
+	return ExternalAuthResponse{Error: "wrong passphrase"}
+}
+
+func checkTestExternalAuth(t *testing.T, config ExternalAuthConfig) {
+	response, err := config.check(ExternalAuthRequest{Account: "dan", Passphrase: "hunter2", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("could not check passphrase: %s", err.Error())
+	}
+	if !response.Success || response.Account != "Dan" || response.Metadata["vhost"] != "staff.example.com" {
+		t.Errorf("expected the right passphrase to be accepted, got %+v", response)
+	}
+
+	response, err = config.check(ExternalAuthRequest{Account: "dan", Passphrase: "wrong", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("could not check passphrase: %s", err.Error())
+	}
+	if response.Success || response.Error != "wrong passphrase" {
+		t.Errorf("expected the wrong passphrase to be rejected, got %+v", response)
+	}
+}
+
+func TestExternalAuthURL(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ExternalAuthRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(testExternalAuth(request))
+	}))
+	defer service.Close()
+
+	config := ExternalAuthConfig{
+		Enabled: true,
+		URL:     service.URL,
+	}
+	err := config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	checkTestExternalAuth(t, config)
+}
+
+// testExternalAuthScript does the same as testExternalAuth.
+const testExternalAuthScript = `read line
+case "$line" in
+	*'"account":"dan","passphrase":"hunter2"'*) echo '{"success":true,"account":"Dan","metadata":{"vhost":"staff.example.com"}}';;
+	*) echo '{"success":false,"error":"wrong passphrase"}';;
+esac`
+
+func TestExternalAuthCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't available to run the test script")
+	}
+
+	config := ExternalAuthConfig{
+		Enabled: true,
+		Command: "sh",
+		Args:    []string{"-c", testExternalAuthScript},
+	}
+	err := config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	checkTestExternalAuth(t, config)
+
+	// scripts that take too long are given up on
+	config.Args = []string{"-c", "exec sleep 5"}
+	config.Timeout = 100 * time.Millisecond
+	_, err = config.check(ExternalAuthRequest{Account: "dan", Passphrase: "hunter2"})
+	if err == nil {
+		t.Error("expected a script that doesn't answer to fail")
+	}
+}
+
+func TestExternalAuthConfig(t *testing.T) {
+	bad := []ExternalAuthConfig{
+		{Enabled: true},
+		{Enabled: true, URL: "https://auth.example.com", Command: "oragono-auth"},
+		{Enabled: true, URL: "not a url"},
+		{Enabled: true, Command: "oragono-auth", TimeoutString: "soon"},
+	}
+	for _, config := range bad {
+		if config.parse() == nil {
+			t.Errorf("expected external auth config %+v to be rejected", config)
+		}
+	}
+
+	disabled := ExternalAuthConfig{}
+	if disabled.parse() != nil {
+		t.Error("expected disabled external auth to need no settings")
+	}
+}
+
+func TestExternalAuthConcurrency(t *testing.T) {
+	server := newTestServer()
+	config := ExternalAuthConfig{MaxConcurrentRequests: 2}
+
+	if !server.startExternalAuth(config) || !server.startExternalAuth(config) {
+		t.Fatal("expected requests to be allowed up to the limit")
+	}
+	if server.startExternalAuth(config) {
+		t.Error("expected requests past the limit to be refused")
+	}
+	server.finishExternalAuth()
+	if !server.startExternalAuth(config) {
+		t.Error("expected a request to be allowed once another's finished")
+	}
+}
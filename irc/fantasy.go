@@ -0,0 +1,209 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// maxFantasyPrefixLen is the longest prefix a channel can use for fantasy commands.
+	maxFantasyPrefixLen = 3
+)
+
+// fantasyCommand is a command that chanops can run by saying it in a channel, like
+// "!kick dan". It's run by the real command's handler, which checks the client's privileges.
+type fantasyCommand struct {
+	command   string
+	handler   func(server *Server, client *Client, msg ircmsg.IrcMessage) bool
+	minParams int
+	// params returns the real command's parameters, given the channel and the fantasy
+	// command's parameters.
+	params func(channel string, params []string) []string
+}
+
+// fantasyCommands are the fantasy commands we support, without their prefix.
+var fantasyCommands = map[string]fantasyCommand{
+	"ban": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			mask := fantasyBanMask(params[0])
+			if 1 < len(params) {
+				// bans can be set for a limited time, like !ban dan 1h
+				mask += "," + params[1]
+			}
+			return []string{channel, "+b", mask}
+		},
+	},
+	"deop": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, "-o", params[0]}
+		},
+	},
+	"devoice": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, "-v", params[0]}
+		},
+	},
+	"kick": {
+		command:   "KICK",
+		handler:   kickHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			kickParams := []string{channel, params[0]}
+			if 1 < len(params) {
+				kickParams = append(kickParams, strings.Join(params[1:], " "))
+			}
+			return kickParams
+		},
+	},
+	"op": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, "+o", params[0]}
+		},
+	},
+	"topic": {
+		command:   "TOPIC",
+		handler:   topicHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, strings.Join(params, " ")}
+		},
+	},
+	"unban": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, "-b", fantasyBanMask(params[0])}
+		},
+	},
+	"voice": {
+		command:   "MODE",
+		handler:   modeHandler,
+		minParams: 1,
+		params: func(channel string, params []string) []string {
+			return []string{channel, "+v", params[0]}
+		},
+	},
+}
+
+// fantasyBanMask turns a nick into a mask that bans it, and leaves masks alone.
+func fantasyBanMask(target string) string {
+	if strings.ContainsAny(target, "!@") {
+		return target
+	}
+	return target + "!*@*"
+}
+
+// FantasyPrefix returns the prefix of the channel's fantasy commands, or an empty
+// string if they're disabled.
+func (channel *Channel) FantasyPrefix() string {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+	return channel.fantasyPrefix
+}
+
+// SetFantasyPrefix changes the prefix of the channel's fantasy commands.
+func (channel *Channel) SetFantasyPrefix(prefix string) {
+	channel.membersMutex.Lock()
+	defer channel.membersMutex.Unlock()
+	channel.fantasyPrefix = prefix
+}
+
+// parseFantasy returns the fantasy command in the given message and its parameters, if
+// the message is one.
+func parseFantasy(prefix string, message string) (command fantasyCommand, params []string, exists bool) {
+	if prefix == "" || !strings.HasPrefix(message, prefix) {
+		return command, nil, false
+	}
+	fields := strings.Fields(message[len(prefix):])
+	// the command has to come straight after the prefix
+	if len(fields) < 1 || !strings.HasPrefix(message[len(prefix):], fields[0]) {
+		return command, nil, false
+	}
+	command, exists = fantasyCommands[strings.ToLower(fields[0])]
+	if !exists || len(fields)-1 < command.minParams {
+		return command, nil, false
+	}
+	return command, fields[1:], true
+}
+
+// runFantasy runs the fantasy command in the given channel message, if there is one
+// and the client is at least a halfop. Messages from other clients are just chatter.
+func (server *Server) runFantasy(client *Client, channel *Channel, message string) {
+	command, params, exists := parseFantasy(channel.FantasyPrefix(), message)
+	if !exists || !channel.ClientIsAtLeast(client, Halfop) {
+		return
+	}
+
+	msg := ircmsg.MakeMessage(nil, client.nickMaskString, command.command, command.params(channel.name, params)...)
+	server.logger.LogFields(logger.LogDebug, "chanserv", client.logFields, fmt.Sprintf("Client %s ran fantasy command %s in %s", client.nick, command.command, channel.name))
+	command.handler(server, client, msg)
+}
+
+// chanservSetFantasy handles CS SET <channel> FANTASY [prefix|OFF], which shows or
+// changes the prefix of the channel's fantasy commands.
+func (server *Server) chanservSetFantasy(client *Client, channel *Channel, params []string) {
+	if len(params) < 1 {
+		prefix := channel.FantasyPrefix()
+		if prefix == "" {
+			client.ChanServNotice(fmt.Sprintf(client.t("Fantasy commands are disabled in %s"), channel.name))
+		} else {
+			client.ChanServNotice(fmt.Sprintf(client.t("Fantasy commands in %[1]s start with %[2]s"), channel.name, prefix))
+		}
+		return
+	}
+
+	prefix := params[0]
+	if strings.ToLower(prefix) == "off" {
+		prefix = ""
+	} else if maxFantasyPrefixLen < len(prefix) {
+		client.ChanServNotice(fmt.Sprintf(client.t("Fantasy prefixes can be at most %d characters long"), maxFantasyPrefixLen))
+		return
+	}
+
+	// only registered channels remember their settings, so only they can use fantasy
+	var registered bool
+	server.registeredChannelsMutex.Lock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channel.nameCasefolded)
+		if chanInfo == nil {
+			return nil
+		}
+		registered = true
+		chanInfo.Fantasy = prefix
+		server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanInfo)
+		return nil
+	})
+	server.registeredChannelsMutex.Unlock()
+	if !registered {
+		client.ChanServNotice(client.t("Fantasy commands can only be used in registered channels"))
+		return
+	}
+	channel.SetFantasyPrefix(prefix)
+
+	server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s set FANTASY on channel %s to %s", client.nick, channel.name, params[0]))
+	if prefix == "" {
+		client.ChanServNotice(fmt.Sprintf(client.t("Fantasy commands are now disabled in %s"), channel.name))
+	} else {
+		client.ChanServNotice(fmt.Sprintf(client.t("Fantasy commands in %[1]s now start with %[2]s"), channel.name, prefix))
+	}
+}
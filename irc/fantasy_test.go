@@ -0,0 +1,51 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFantasy(t *testing.T) {
+	type fantasyTest struct {
+		prefix  string
+		message string
+		command string
+		params  []string
+	}
+	tests := []fantasyTest{
+		{"!", "!kick dan go away", "KICK", []string{"#chan", "dan", "go away"}},
+		{"!", "!KICK dan", "KICK", []string{"#chan", "dan"}},
+		{"!", "!ban dan", "MODE", []string{"#chan", "+b", "dan!*@*"}},
+		{"!", "!unban *!*@example.com", "MODE", []string{"#chan", "-b", "*!*@example.com"}},
+		{"!", "!topic  new topic", "TOPIC", []string{"#chan", "new topic"}},
+		{"!!", "!!voice dan", "MODE", []string{"#chan", "+v", "dan"}},
+	}
+	for _, test := range tests {
+		command, params, exists := parseFantasy(test.prefix, test.message)
+		if !exists {
+			t.Errorf("%s: expected a fantasy command", test.message)
+			continue
+		}
+		if command.command != test.command {
+			t.Errorf("%s: expected command %s, got %s", test.message, test.command, command.command)
+		}
+		realParams := command.params("#chan", params)
+		if !reflect.DeepEqual(realParams, test.params) {
+			t.Errorf("%s: expected params %v, got %v", test.message, test.params, realParams)
+		}
+	}
+
+	for _, message := range []string{"!kick", "!fly away", "kick dan", "! kick dan", "!"} {
+		_, _, exists := parseFantasy("!", message)
+		if exists {
+			t.Errorf("%s: didn't expect a fantasy command", message)
+		}
+	}
+	_, _, exists := parseFantasy("", "!kick dan")
+	if exists {
+		t.Error("fantasy commands shouldn't run without a prefix")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build go1.18
+// +build go1.18
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+// these fuzz the code that handles untrusted input from every connection. `go test` runs
+// them over their seeds, and they can be fuzzed properly with something like:
+//
+//	go test -run XXX -fuzz FuzzCasefold ./irc
+
+func FuzzParseLine(f *testing.F) {
+	for _, seed := range []string{
+		"PRIVMSG #channel :hello world",
+		":dan!d@localhost PRIVMSG dan :hi",
+		"@time=2017-01-01T00:00:00.000Z;+draft/typing=active :nick TAGMSG #chan",
+		"MODE #channel +ov-b dan dan *!*@*",
+		"MODE #channel :",
+		"CAP REQ :multi-prefix sasl",
+		"",
+		" ",
+		":",
+		"@",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		msg, err := ircmsg.ParseLineMaxLen(line, 4096, 512)
+		if err != nil {
+			return
+		}
+
+		// these are what commands do with the tags and params they're given
+		GetClientOnlyTags(msg.Tags)
+		ParseUserModeChanges(msg.Params...)
+		ParseChannelModeChanges(msg.Params...)
+		for _, param := range msg.Params {
+			SplitChannelMembershipPrefixes(param)
+			for _, target := range strings.Split(param, ",") {
+				CasefoldName(target)
+				CasefoldChannel(target)
+			}
+		}
+	})
+}
+
+func FuzzClientOnlyTags(f *testing.F) {
+	for _, seed := range []string{
+		"+draft/typing=active",
+		"+example.com/foo=bar\\:baz;time=now;+a",
+		"+;+=;=+",
+		"msgid=abc",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, tags string) {
+		msg, err := ircmsg.ParseLine("@" + tags + " :nick TAGMSG #chan")
+		if err != nil {
+			return
+		}
+		clientOnly := GetClientOnlyTags(msg.Tags)
+		if clientOnly == nil {
+			return
+		}
+		for name := range *clientOnly {
+			if len(name) < 2 || name[0] != '+' {
+				t.Errorf("tag %q isn't a client-only tag", name)
+			}
+		}
+	})
+}
+
+func FuzzCasefold(f *testing.F) {
+	for _, seed := range []string{
+		"Dan",
+		"#Channel",
+		"ÄÖÜ",
+		"ǅ",
+		"\xff\xfe",
+		"#",
+		"-",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, str string) {
+		folded, err := Casefold(str)
+		if err != nil {
+			return
+		}
+		if !utf8.ValidString(folded) {
+			t.Errorf("casefolding %q gave invalid UTF-8 %q", str, folded)
+		}
+		// casefolding a casefolded string shouldn't change it
+		again, err := Casefold(folded)
+		if err != nil || again != folded {
+			t.Errorf("casefolding %q isn't stable: %q then %q (%v)", str, folded, again, err)
+		}
+
+		if name, err := CasefoldName(str); err == nil && name != folded {
+			t.Errorf("CasefoldName(%q) = %q, expected %q", str, name, folded)
+		}
+		if channel, err := CasefoldChannel(str); err == nil && !strings.HasPrefix(channel, "#") {
+			t.Errorf("CasefoldChannel(%q) = %q, which isn't a channel name", str, channel)
+		}
+
+		for _, length := range []int{0, 1, 2, 3, len(str) / 2} {
+			truncated := truncateUTF8(str, length)
+			if length < len(truncated) || (utf8.ValidString(str) && !utf8.ValidString(truncated)) {
+				t.Errorf("truncateUTF8(%q, %d) = %q", str, length, truncated)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oragono/oragono/irc/geoip"
+)
+
+// GeoIPConfig controls looking up where clients are connecting from.
+type GeoIPConfig struct {
+	Enabled         bool
+	CountryDatabase string `yaml:"country-database"`
+	ASNDatabase     string `yaml:"asn-database"`
+}
+
+// openGeoIP opens the databases in the given config, returning nil if lookups are off.
+func openGeoIP(config GeoIPConfig) (*geoip.Reader, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.CountryDatabase == "" && config.ASNDatabase == "" {
+		return nil, fmt.Errorf("GeoIP is enabled, but no databases are given")
+	}
+	return geoip.Open(config.CountryDatabase, config.ASNDatabase)
+}
+
+// lookupLocation returns where the given IP is, as far as our GeoIP databases know.
+func (server *Server) lookupLocation(ip net.IP) geoip.Record {
+	server.geoipMutex.RLock()
+	defer server.geoipMutex.RUnlock()
+	return server.geoip.Lookup(ip)
+}
+
+// LocationExemptions holds the countries and ASNs exempted from a connection limit.
+type LocationExemptions struct {
+	countries map[string]bool
+	asns      map[uint]bool
+}
+
+// NewLocationExemptions returns exemptions for the given country codes and ASNs.
+func NewLocationExemptions(countries []string, asns []uint) (LocationExemptions, error) {
+	exemptions := LocationExemptions{
+		countries: make(map[string]bool),
+		asns:      make(map[uint]bool),
+	}
+	for _, country := range countries {
+		if len(country) != 2 {
+			return exemptions, fmt.Errorf("Could not parse exempted country [%s], it should be a two-letter country code", country)
+		}
+		exemptions.countries[strings.ToUpper(country)] = true
+	}
+	for _, asn := range asns {
+		exemptions.asns[asn] = true
+	}
+	return exemptions, nil
+}
+
+// Contains returns true if the given location is exempted.
+func (exemptions LocationExemptions) Contains(location geoip.Record) bool {
+	return (location.CountryCode != "" && exemptions.countries[location.CountryCode]) || (location.ASN != 0 && exemptions.asns[location.ASN])
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+// Package geoip looks up the country and network (ASN) that IPs belong to, using
+// MaxMind DB files such as the GeoLite2 databases.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Record is what we know about where an IP is.
+type Record struct {
+	// CountryCode is the two-letter ISO 3166-1 code of the country, such as "NZ".
+	CountryCode string
+	CountryName string
+	// ASN is the number of the autonomous system the IP belongs to, or 0 if unknown.
+	ASN   uint
+	ASOrg string
+}
+
+// IsEmpty returns true if we don't know anything about the IP.
+func (record Record) IsEmpty() bool {
+	return record.CountryCode == "" && record.ASN == 0
+}
+
+// String returns a short description of the record, like "NZ, AS1234 (Example Ltd)".
+func (record Record) String() string {
+	return record.describe(record.CountryCode)
+}
+
+// Description returns a longer description of the record for people to read, like
+// "New Zealand (NZ), AS1234 (Example Ltd)".
+func (record Record) Description() string {
+	if record.CountryName == "" {
+		return record.String()
+	}
+	return record.describe(fmt.Sprintf("%s (%s)", record.CountryName, record.CountryCode))
+}
+
+// describe joins the given country description with the record's ASN.
+func (record Record) describe(country string) string {
+	var parts []string
+	if country != "" {
+		parts = append(parts, country)
+	}
+	if record.ASN != 0 {
+		if record.ASOrg != "" {
+			parts = append(parts, fmt.Sprintf("AS%d (%s)", record.ASN, record.ASOrg))
+		} else {
+			parts = append(parts, fmt.Sprintf("AS%d", record.ASN))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// countryData is the part of a country database's records that we use.
+type countryData struct {
+	Country           countryInfo `maxminddb:"country"`
+	RegisteredCountry countryInfo `maxminddb:"registered_country"`
+}
+
+// countryInfo is a country in a country database's records.
+type countryInfo struct {
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+// asnData is the part of an ASN database's records that we use.
+type asnData struct {
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// Reader looks up IPs in a country database, an ASN database, or both.
+type Reader struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// Open returns a reader for the given database files. Either filename can be empty,
+// in which case we don't look up that information.
+func Open(countryFilename string, asnFilename string) (*Reader, error) {
+	var reader Reader
+	var err error
+	if countryFilename != "" {
+		reader.country, err = maxminddb.Open(countryFilename)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open country database: %s", err.Error())
+		}
+	}
+	if asnFilename != "" {
+		reader.asn, err = maxminddb.Open(asnFilename)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("Could not open ASN database: %s", err.Error())
+		}
+	}
+	return &reader, nil
+}
+
+// Close closes the reader's databases. It can't be used after this.
+func (reader *Reader) Close() {
+	if reader == nil {
+		return
+	}
+	if reader.country != nil {
+		reader.country.Close()
+	}
+	if reader.asn != nil {
+		reader.asn.Close()
+	}
+}
+
+// Lookup returns what we know about the given IP. IPs that aren't in the databases
+// return an empty record.
+func (reader *Reader) Lookup(ip net.IP) Record {
+	var record Record
+	if reader == nil || ip == nil {
+		return record
+	}
+
+	if reader.country != nil {
+		var data countryData
+		err := reader.country.Lookup(ip, &data)
+		if err == nil {
+			country := data.Country
+			if country.ISOCode == "" {
+				country = data.RegisteredCountry
+			}
+			record.CountryCode = country.ISOCode
+			record.CountryName = country.Names["en"]
+		}
+	}
+
+	if reader.asn != nil {
+		var data asnData
+		err := reader.asn.Lookup(ip, &data)
+		if err == nil {
+			record.ASN = data.ASN
+			record.ASOrg = data.ASOrg
+		}
+	}
+
+	return record
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package geoip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// these are the parts of the MaxMind DB format (https://maxmind.github.io/MaxMind-DB/)
+// we need to build test databases
+const (
+	typePointer      = 1
+	typeString       = 2
+	typeUint32       = 6
+	typeMap          = 7
+	dataSectionSpace = 16
+)
+
+var metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// testValue encodes a value the way MaxMind DB data sections store them.
+func testValue(value interface{}) []byte {
+	var buf bytes.Buffer
+	switch v := value.(type) {
+	case string:
+		if len(v) < 29 {
+			buf.WriteByte(byte(typeString<<5 | len(v)))
+		} else {
+			buf.WriteByte(byte(typeString<<5 | 29))
+			buf.WriteByte(byte(len(v) - 29))
+		}
+		buf.WriteString(v)
+	case uint:
+		var data []byte
+		for ; v != 0; v >>= 8 {
+			data = append([]byte{byte(v)}, data...)
+		}
+		buf.WriteByte(byte(typeUint32<<5 | len(data)))
+		buf.Write(data)
+	case map[string]interface{}:
+		buf.WriteByte(byte(typeMap<<5 | len(v)))
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			buf.Write(testValue(key))
+			buf.Write(testValue(v[key]))
+		}
+	case []byte:
+		// already encoded, such as a pointer
+		buf.Write(v)
+	}
+	return buf.Bytes()
+}
+
+// testNode is a node of the search tree we're building.
+type testNode struct {
+	children [2]*testNode
+	data     [2]int
+	number   uint
+}
+
+// testNetwork is a network and the data stored for it.
+type testNetwork struct {
+	network string
+	data    interface{}
+}
+
+// testDatabase builds a MaxMind DB containing the given networks.
+func testDatabase(t *testing.T, ipVersion uint, networks []testNetwork) []byte {
+	var dataSection bytes.Buffer
+	root := &testNode{data: [2]int{-1, -1}}
+	for _, network := range networks {
+		_, ipnet, err := net.ParseCIDR(network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		address := []byte(ipnet.IP.To4())
+		if address == nil {
+			address = ipnet.IP.To16()
+		} else if ipVersion == 6 {
+			// IPv4 networks live under ::/96
+			address = append(make([]byte, 12), address...)
+			ones += 96
+		}
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (address[i/8] >> uint(7-(i%8))) & 1
+			if i == ones-1 {
+				node.data[bit] = dataSection.Len()
+				break
+			}
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{data: [2]int{-1, -1}}
+			}
+			node = node.children[bit]
+		}
+		dataSection.Write(testValue(network.data))
+	}
+
+	// number the nodes, then write them out
+	var nodes []*testNode
+	queue := []*testNode{root}
+	for 0 < len(queue) {
+		node := queue[0]
+		queue = queue[1:]
+		node.number = uint(len(nodes))
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+	nodeCount := uint(len(nodes))
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for bit := range node.children {
+			record := nodeCount
+			if node.children[bit] != nil {
+				record = node.children[bit].number
+			} else if node.data[bit] != -1 {
+				record = nodeCount + uint(dataSectionSpace) + uint(node.data[bit])
+			}
+			buf.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	buf.Write(make([]byte, dataSectionSpace))
+	buf.Write(dataSection.Bytes())
+	buf.Write(metadataStart)
+	buf.Write(testValue(map[string]interface{}{
+		"node_count":                  nodeCount,
+		"record_size":                 uint(24),
+		"ip_version":                  ipVersion,
+		"database_type":               "Test",
+		"binary_format_major_version": uint(2),
+	}))
+	return buf.Bytes()
+}
+
+func TestLookup(t *testing.T) {
+	countryData := testDatabase(t, 6, []testNetwork{
+		{"10.0.0.0/8", map[string]interface{}{
+			"country": map[string]interface{}{
+				"iso_code": "NZ",
+				"names":    map[string]interface{}{"en": "New Zealand"},
+			},
+		}},
+		{"192.168.1.0/24", map[string]interface{}{
+			"registered_country": map[string]interface{}{
+				"iso_code": "DE",
+			},
+		}},
+		{"2001:db8::/32", map[string]interface{}{
+			"country": map[string]interface{}{
+				"iso_code": "JP",
+			},
+		}},
+	})
+	asnData := testDatabase(t, 4, []testNetwork{
+		{"10.1.0.0/16", map[string]interface{}{
+			"autonomous_system_number":       uint(64512),
+			"autonomous_system_organization": "Example Ltd",
+		}},
+		// the organisation is a pointer to the first key of the data section
+		{"10.2.0.0/16", map[string]interface{}{
+			"autonomous_system_number":       uint(70000),
+			"autonomous_system_organization": []byte{typePointer << 5, 1},
+		}},
+	})
+
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	countryFilename := filepath.Join(dir, "country.mmdb")
+	asnFilename := filepath.Join(dir, "asn.mmdb")
+	ioutil.WriteFile(countryFilename, countryData, 0600)
+	ioutil.WriteFile(asnFilename, asnData, 0600)
+
+	reader, err := Open(countryFilename, asnFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip     string
+		record Record
+	}{
+		{"10.1.2.3", Record{CountryCode: "NZ", CountryName: "New Zealand", ASN: 64512, ASOrg: "Example Ltd"}},
+		{"10.2.0.1", Record{CountryCode: "NZ", CountryName: "New Zealand", ASN: 70000, ASOrg: "autonomous_system_number"}},
+		{"10.3.0.1", Record{CountryCode: "NZ", CountryName: "New Zealand"}},
+		{"192.168.1.9", Record{CountryCode: "DE"}},
+		{"192.168.2.9", Record{}},
+		{"2001:db8::1", Record{CountryCode: "JP"}},
+		{"2001:db9::1", Record{}},
+	}
+	for _, test := range tests {
+		record := reader.Lookup(net.ParseIP(test.ip))
+		if record != test.record {
+			t.Errorf("Lookup(%s) = %#v, expected %#v", test.ip, record, test.record)
+		}
+	}
+
+	if reader.Lookup(net.ParseIP("10.1.2.3")).String() != "NZ, AS64512 (Example Ltd)" {
+		t.Error("Record string isn't right")
+	}
+	if reader.Lookup(net.ParseIP("10.1.2.3")).Description() != "New Zealand (NZ), AS64512 (Example Ltd)" {
+		t.Error("Record description isn't right")
+	}
+	if !reader.Lookup(net.ParseIP("192.168.2.9")).IsEmpty() {
+		t.Error("Unknown IP should have an empty record")
+	}
+
+	reader.Close()
+
+	notDatabase := filepath.Join(dir, "not-a-database.mmdb")
+	ioutil.WriteFile(notDatabase, []byte("not a database"), 0600)
+	_, err = Open(countryFilename, notDatabase)
+	if err == nil {
+		t.Error("Opening something that isn't a database should fail")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+var (
+	errGlobalNoticeTarget = errors.New("Target must be *, LISTENER:<address> or CLASS:<name>")
+	errGlobalNoticeClass  = errors.New("No such connection class")
+)
+
+// globalNoticeTarget picks out the clients a GLOBALNOTICE is sent to.
+type globalNoticeTarget struct {
+	// listener and class are empty if they don't filter the clients.
+	listener string
+	class    string
+}
+
+// parseGlobalNoticeTarget parses a GLOBALNOTICE target, which is * for everyone,
+// LISTENER:<address> for the clients on a listener, or CLASS:<name> for the clients in
+// a connection class.
+func parseGlobalNoticeTarget(config *Config, target string) (parsed globalNoticeTarget, err error) {
+	if target == "*" {
+		return parsed, nil
+	}
+	colon := strings.Index(target, ":")
+	if colon == -1 || colon == len(target)-1 {
+		return parsed, errGlobalNoticeTarget
+	}
+	value := target[colon+1:]
+	switch strings.ToLower(target[:colon]) {
+	case "listener":
+		parsed.listener = value
+	case "class":
+		if !config.ConnectionClassExists(value) {
+			return parsed, errGlobalNoticeClass
+		}
+		parsed.class = value
+	default:
+		return parsed, errGlobalNoticeTarget
+	}
+	return parsed, nil
+}
+
+// Matches returns true if the given client should get the notice.
+func (target *globalNoticeTarget) Matches(config *Config, client *Client) bool {
+	if !client.registered {
+		return false
+	}
+	if target.listener != "" && client.listener != target.listener {
+		return false
+	}
+	if target.class != "" && client.ConnectionClass() != target.class {
+		return false
+	}
+	return true
+}
+
+// GLOBALNOTICE <*|LISTENER:<address>|CLASS:<name>> <message>
+func globalnoticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	config := server.Config()
+	target, err := parseGlobalNoticeTarget(config, msg.Params[0])
+	if err != nil {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, client.t(err.Error()))
+		return false
+	}
+	message := msg.Params[1]
+
+	var recipients []*Client
+	server.clients.ByNickMutex.RLock()
+	for _, mcl := range server.clients.ByNick {
+		if target.Matches(config, mcl) {
+			recipients = append(recipients, mcl)
+		}
+	}
+	server.clients.ByNickMutex.RUnlock()
+
+	for _, mcl := range recipients {
+		mcl.Send(nil, server.name, "NOTICE", mcl.nick, message)
+	}
+
+	client.Notice(fmt.Sprintf(client.t("Sent the notice to %d users"), len(recipients)))
+	server.auditLog(client, fmt.Sprintf("Oper %s sent a global notice to %s (%d users): %s", client.nick, msg.Params[0], len(recipients), message))
+	server.snomasks.Send(sno.LocalAccouncements, fmt.Sprintf(ircfmt.Unescape("$c[grey][$r%s$c[grey]] sent a global notice to $c[grey][$r%s$c[grey]] (%d users): %s"), client.nickMaskString, msg.Params[0], len(recipients), message))
+	return false
+}
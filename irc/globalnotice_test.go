@@ -0,0 +1,38 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestParseGlobalNoticeTarget(t *testing.T) {
+	config := &Config{}
+	config.Server.ConnectionClasses = []ConnectionClassConfig{
+		{Name: "tor", Listeners: []string{"127.0.0.2:6667"}},
+	}
+
+	var tests = []struct {
+		target   string
+		expected globalNoticeTarget
+		err      error
+	}{
+		{"*", globalNoticeTarget{}, nil},
+		{"LISTENER:127.0.0.2:6667", globalNoticeTarget{listener: "127.0.0.2:6667"}, nil},
+		{"class:tor", globalNoticeTarget{class: "tor"}, nil},
+		{"class:default", globalNoticeTarget{class: "default"}, nil},
+		{"class:web", globalNoticeTarget{}, errGlobalNoticeClass},
+		{"class:", globalNoticeTarget{}, errGlobalNoticeTarget},
+		{"tor", globalNoticeTarget{}, errGlobalNoticeTarget},
+		{"server:tor", globalNoticeTarget{}, errGlobalNoticeTarget},
+	}
+	for _, test := range tests {
+		parsed, err := parseGlobalNoticeTarget(config, test.target)
+		if err != test.err {
+			t.Errorf("expected target %s to give error %v, got %v", test.target, test.err, err)
+		} else if err == nil && parsed != test.expected {
+			t.Errorf("expected target %s to parse as %+v, got %+v", test.target, test.expected, parsed)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// healthLoopTimeout is how long we wait for the main loop to answer a health check.
+	healthLoopTimeout = 2 * time.Second
+	// healthRelistenDelay is how long we wait before trying to open the health check
+	// listener again, such as when the old process still has it open during an upgrade.
+	healthRelistenDelay = 5 * time.Second
+	// healthProbeCacheTime is how long the HEALTH command reuses a health report for.
+	// unregistered clients can send it, so this stops them running the checks (which
+	// hit the datastore and main loop) as often as they like.
+	healthProbeCacheTime = 5 * time.Second
+)
+
+// healthProbeCache holds the last health report given to the HEALTH command.
+type healthProbeCache struct {
+	sync.Mutex
+	report  HealthReport
+	checked time.Time
+}
+
+// HealthReport is the result of a health check.
+type HealthReport struct {
+	Healthy    bool            `json:"healthy"`
+	Problems   []string        `json:"problems,omitempty"`
+	Listeners  map[string]bool `json:"listeners"`
+	Datastore  bool            `json:"datastore"`
+	MainLoop   bool            `json:"main-loop"`
+	Goroutines int             `json:"goroutines"`
+	Memory     uint64          `json:"memory"`
+}
+
+// checkHealth makes sure the server is in a state to serve clients.
+func (server *Server) checkHealth() HealthReport {
+	config := server.Config().Server.HealthCheck
+	report := HealthReport{
+		Listeners: make(map[string]bool),
+	}
+	var problems []string
+
+	// listeners
+	server.listenerUpdateMutex.Lock()
+	for _, addr := range server.Config().Server.Listen {
+		_, exists := server.listeners[addr]
+		report.Listeners[addr] = exists
+		if !exists {
+			problems = append(problems, fmt.Sprintf("not listening on %s", addr))
+		}
+	}
+	server.listenerUpdateMutex.Unlock()
+
+	// datastore
+	err := server.store.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(keySchemaVersion)
+		return err
+	})
+	report.Datastore = err == nil
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("datastore: %s", err.Error()))
+	}
+
+	// main loop, which stops answering while we're shutting down
+	answer := make(chan bool, 1)
+	select {
+	case server.healthChecks <- answer:
+		select {
+		case <-answer:
+			report.MainLoop = true
+		case <-time.After(healthLoopTimeout):
+		}
+	case <-time.After(healthLoopTimeout):
+	}
+	if !report.MainLoop {
+		problems = append(problems, "main loop isn't responding")
+	}
+
+	// goroutines and memory
+	report.Goroutines = runtime.NumGoroutine()
+	if 0 < config.MaxGoroutines && config.MaxGoroutines < report.Goroutines {
+		problems = append(problems, fmt.Sprintf("%d goroutines running", report.Goroutines))
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	report.Memory = memStats.Sys
+	if 0 < config.MaxMemory && config.MaxMemory < report.Memory {
+		problems = append(problems, fmt.Sprintf("using %d bytes of memory", report.Memory))
+	}
+
+	report.Healthy = len(problems) == 0
+	report.Problems = problems
+	return report
+}
+
+// probeHealth returns a recent health report for the HEALTH command. Clients that
+// probe while the checks are running wait for and share that result.
+func (server *Server) probeHealth() HealthReport {
+	cache := &server.healthProbeCache
+	cache.Lock()
+	defer cache.Unlock()
+	if time.Since(cache.checked) < healthProbeCacheTime {
+		return cache.report
+	}
+	cache.report = server.checkHealth()
+	cache.checked = time.Now()
+	return cache.report
+}
+
+// startHealthCheck starts the health check HTTP listener.
+func (server *Server) startHealthCheck(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		report := server.checkHealth()
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+
+	go func() {
+		for {
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				server.logger.Warning("listeners", fmt.Sprintf("Could not start health check on %s, trying again soon: %s", addr, err.Error()))
+				time.Sleep(healthRelistenDelay)
+				continue
+			}
+			server.logger.Info("listeners", fmt.Sprintf("health check listening on %s.", addr))
+			err = http.Serve(listener, mux)
+			server.logger.Error("listeners", fmt.Sprintf("Health check on %s stopped: %s", addr, err.Error()))
+			return
+		}
+	}()
+}
+
+// HEALTH
+func healthHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if !server.Config().Server.HealthCheck.IRCProbe {
+		client.Send(nil, server.name, ERR_UNKNOWNCOMMAND, client.nick, msg.Command, client.t("Unknown command"))
+		return false
+	}
+
+	// this can be seen by anyone, so only say which checks failed
+	report := server.probeHealth()
+	if report.Healthy {
+		client.Notice("HEALTH OK")
+		return false
+	}
+	var failed []string
+	if !report.MainLoop {
+		failed = append(failed, "main-loop")
+	}
+	if !report.Datastore {
+		failed = append(failed, "datastore")
+	}
+	for _, up := range report.Listeners {
+		if !up {
+			failed = append(failed, "listeners")
+			break
+		}
+	}
+	config := server.Config().Server.HealthCheck
+	if (0 < config.MaxGoroutines && config.MaxGoroutines < report.Goroutines) || (0 < config.MaxMemory && config.MaxMemory < report.Memory) {
+		failed = append(failed, "resources")
+	}
+	client.Notice(fmt.Sprintf("HEALTH FAILING %s", strings.Join(failed, ",")))
+	return false
+}
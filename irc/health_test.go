@@ -0,0 +1,21 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeHealthIsCached(t *testing.T) {
+	server := newTestServer()
+	server.healthProbeCache.report = HealthReport{Healthy: true, Goroutines: 42}
+	server.healthProbeCache.checked = time.Now()
+
+	// a fresh check would panic here, since the test server has no config or datastore
+	report := server.probeHealth()
+	if !report.Healthy || report.Goroutines != 42 {
+		t.Errorf("expected the cached health report, got %+v", report)
+	}
+}
@@ -6,6 +6,7 @@ package irc
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/goshuirc/irc-go/ircmsg"
@@ -21,6 +22,9 @@ const (
 	InformationHelpEntry HelpEntryType = 1
 	// ISupportHelpEntry is a help entry explaining a specific RPL_ISUPPORT token.
 	ISupportHelpEntry HelpEntryType = 2
+	// ServiceHelpEntry is a help entry explaining a services subcommand, such as
+	// "nickserv identify". They aren't listed in the index.
+	ServiceHelpEntry HelpEntryType = 3
 )
 
 // HelpEntry represents an entry in the Help map.
@@ -40,6 +44,12 @@ Oragono supports the following channel modes:
   +b  |  Client masks that are banned from the channel (e.g. *!*@127.0.0.1)
   +e  |  Client masks that are exempted from bans.
   +I  |  Client masks that are exempted from the invite-only flag.
+      |  As well as masks, +b, +e and +I take extbans: $a matches clients
+      |  logged into an account, $a:<mask> clients logged into a matching
+      |  account, and $~a or $~a:<mask> negate them.
+      |  Entries can be added for a limited time by putting a duration after
+      |  the mask, like +b *!*@example.com,24h. They're removed, and the
+      |  channel told, when they expire.
   +i  |  Invite-only mode, only invited clients can join the channel.
   +k  |  Key required when joining the channel.
   +l  |  Client join limit for the channel.
@@ -62,8 +72,11 @@ Oragono supports the following channel modes:
 Oragono supports the following user modes:
 
   +a  |  User is marked as being away. This mode is set with the /AWAY command.
+  +B  |  User is a bot, which is shown in WHOIS and WHO replies.
+  +g  |  Caller ID, only clients on the user's /ACCEPT list can message them.
   +i  |  User is marked as invisible (their channels are hidden from whois replies).
   +o  |  User is an IRC operator.
+  +R  |  Only clients logged into an account can send the user private messages.
   +s  |  Server Notice Masks (see help with /HELPOP snomasks).
   +Z  |  User is connected via TLS.`
 	snomaskHelpText = `== Server Notice Masks ==
@@ -72,6 +85,7 @@ Oragono supports the following server notice masks for operators:
 
   a  |  Local announcements.
   c  |  Local client connections.
+  f  |  Local flood protection.
   j  |  Local channel actions.
   k  |  Local kills.
   n  |  Local nick changes.
@@ -99,6 +113,29 @@ ACC VERIFY <accountname> <auth_code>
 
 Used in account registration. See the relevant specs for more info:
 http://oragono.io/specs.html`,
+	},
+	"accept": {
+		text: `ACCEPT <nick>{,<nick>}
+ACCEPT -<nick>{,-<nick>}
+ACCEPT *
+
+Manages the list of clients that can message you while you have the caller ID
+user mode (+g) set. Other clients' private messages are rejected, and you're told
+they tried to message you (at most once a minute). Nicks starting with - are
+removed from the list, and * shows the list. IRC operators can always message you.
+
+Clients who are logged into an account are accepted by their account, so they can
+still message you after changing nick or reconnecting. Other clients are accepted
+until they leave the server.
+
+If you're logged into an account, the accounts on your list and whether you have +g
+set are saved and restored when you next log in. While +g is saved, only accounts on
+your list can send you push notifications.`,
+	},
+	"admin": {
+		text: `ADMIN [server]
+
+Shows who runs this, or the given, server, and how to contact them.`,
 	},
 	"ambiance": {
 		text: `AMBIANCE <target> <text to be sent>
@@ -127,12 +164,171 @@ http://ircv3.net/specs/core/capability-negotiation-3.2.html`,
 	"chanserv": {
 		text: `CHANSERV <subcommand> [params]
 
-ChanServ controls channel registrations.`,
+ChanServ controls channel registrations. Supported subcommands:
+
+    BAN <channel> <ADD|DEL|LIST> [mask] [duration]
+        Bans or unbans a nick or mask, optionally for a limited time, or lists
+        the channel's bans. You must be a channel operator.
+    INFO <channel>
+        Shows when the given registered channel was registered and last used, and
+        who founded it. Its founder, chanops and opers also see its settings and
+        ban lists.
+    REGISTER <channel>
+        Registers the given channel to your account.
+    SET <channel> <setting> [value]
+        Shows or changes a channel setting. You must be a channel operator.
+        RP-SOURCES <mask>{,<mask>}  NPC names that can be used, or * for any.
+        RP-NPC <level>              Lowest channel privilege that can use NPC and NPCA:
+                                    all, voice, halfop, op, admin or founder.
+        RP-HISTORY <ON|OFF>         Whether roleplay messages are stored in history.
+        FILTER [ADD|DEL <word>]     Words blocked or censored in the channel. Words
+                                    can use * and ?, or be a /regex/. Chanops and
+                                    above aren't filtered. Only the founder can
+                                    change the filter.
+        FILTER ACTION <BLOCK|CENSOR>  Whether matching messages are blocked, or have
+                                    the matching words replaced with *s.
+        FILTER CLEAR                Removes all filtered words.
+        FANTASY <prefix|OFF>        Lets chanops run commands like !kick in the
+                                    channel (registered channels only).
+        KEY [ROTATE|OFF]            Shows the channel's key, replaces it with a
+                                    new random one, or removes it.
+
+Use /HELPOP CHANSERV <subcommand> or /CS HELP <subcommand> for more help.`,
+	},
+	"chanserv ban": {
+		text: `BAN <channel> ADD <nick|mask> [duration]
+BAN <channel> DEL <nick|mask>
+BAN <channel> LIST
+
+Bans or unbans the given nick or mask in the channel, or lists its bans and
+when they expire. Bans added with a duration (like 24h or 7d) are removed
+automatically once it's passed. You must be a chanop in the channel.`,
+		helpType: ServiceHelpEntry,
+	},
+	"chanserv info": {
+		text: `INFO <channel>
+
+Shows when the given registered channel was registered and last used, who
+founded it, and its topic (unless the channel is secret). The channel's
+founder, its chanops and opers also see its settings, whether it's about to
+expire, and its ban, exception and invite exception lists.`,
+		helpType: ServiceHelpEntry,
+	},
+	"chanserv register": {
+		text: `REGISTER <channel>
+
+Registers the given channel to your account, making you its founder. You must
+be logged into an account and be a chanop in the channel. Registered channels
+keep their topic, bans and settings when they're empty, and give the founder
++q when they join.`,
+		helpType: ServiceHelpEntry,
+	},
+	"chanserv set": {
+		text: `SET <channel> <setting> [value]
+
+Shows or changes a setting of the given channel. You must be a chanop in the
+channel. The settings are:
+
+    RP-SOURCES <mask>{,<mask>}    NPC names that can be used, or * for any.
+    RP-NPC <level>                Lowest channel privilege that can use NPC and
+                                  NPCA: all, voice, halfop, op, admin or founder.
+    RP-HISTORY <ON|OFF>           Whether roleplay messages are stored in history.
+    FILTER [ADD|DEL <word>]       Words blocked or censored in the channel. Words
+                                  can use * and ?, or be a /regex/. Only the
+                                  founder can change the filter.
+    FILTER ACTION <BLOCK|CENSOR>  Whether matching messages are blocked, or have
+                                  the matching words replaced with *s.
+    FILTER CLEAR                  Removes all filtered words.
+    FANTASY <prefix|OFF>          Lets halfops and above run commands by saying
+                                  them in the channel, starting with the given
+                                  prefix (like !). Only registered channels can
+                                  use fantasy commands. They are:
+                                      KICK <nick> [reason]
+                                      BAN <nick|mask> [duration]
+                                      UNBAN <nick|mask>
+                                      OP, DEOP, VOICE, DEVOICE <nick>
+                                      TOPIC <topic>
+    KEY [ROTATE|OFF]              Shows the channel's key (+k), replaces it with
+                                  a new random key, or removes it. Registered
+                                  channels only store a hash of their key, so
+                                  after a restart it's only known again once
+                                  someone joins with it.`,
+		helpType: ServiceHelpEntry,
+	},
+	"chathistory": {
+		text: `CHATHISTORY BEFORE <target> <selector> <limit>
+CHATHISTORY AFTER <target> <selector> <limit>
+CHATHISTORY LATEST <target> <*|selector> <limit>
+CHATHISTORY BETWEEN <target> <selector> <selector> <limit>
+CHATHISTORY TARGETS <timestamp=...> <timestamp=...> <limit>
+
+Replays the messages sent to a channel you're in, or between you and a user, in
+a chathistory batch. Selectors are either msgid=<msgid> or
+timestamp=<YYYY-MM-DDThh:mm:ss.sssZ>, and limit is the most messages to send
+(the CHATHISTORY token in RPL_ISUPPORT gives our maximum). BEFORE and LATEST
+send the messages closest to their selector (LATEST with * sends the newest
+ones), AFTER sends the ones just after its selector, and BETWEEN counts from
+its first selector towards its second. If you're logged in, you get your
+account's conversations with other accounts, even after reconnecting.
+
+TARGETS lists the accounts you've had direct message conversations with that have
+messages between the two timestamps, most recent first, along with the time of
+their latest message. Clients use this to find conversations that have new
+messages since they were last online.`,
+	},
+	"cnotice": {
+		text: `CNOTICE <nickname> <channel> <text to be sent>
+
+Sends the text to the given user as a NOTICE. You must be voiced or a channel
+operator in the given channel, and they must be in it too. Their +g and +R modes
+don't stop these notices.`,
+	},
+	"config": {
+		oper: true,
+		text: `CONFIG GET <key>
+CONFIG SET <key> <value>
+CONFIG LIST
+
+Inspects and changes the server config at runtime. Keys use the same names as
+the config file, separated by dots (for example, "limits.nicklen").
+
+GET shows the value currently in effect for the given key. Only settings that
+are known to be safe to show are shown, so passwords, secrets and file paths
+are always hidden.
+
+SET changes the value of the given key until the next rehash. Only some keys
+can be changed this way, and LIST shows which ones (along with their values).`,
+	},
+	"connlist": {
+		oper: true,
+		text: `CONNLIST [IP <ip>/<net>] [ACCOUNT <mask>] [CLASS <class>] [TLS <on|off>] [IDLE <duration>] [PAGE <page>]
+
+Lists the clients connected to the server, with their IP addresses, accounts,
+connection classes, whether they're using TLS, and how long they've been idle.
+The filters only show the clients that match all of them, and can be given more
+than once:
+
+IP <ip>/<net>       Clients connecting from the given IP address or network.
+ACCOUNT <mask>      Clients logged into a matching account. * means clients
+                    logged into any account, and - clients who aren't.
+CLASS <class>       Clients in the given connection class.
+TLS <on|off>        Clients that are, or aren't, connected with TLS.
+IDLE <duration>     Clients that have been idle for at least this long, such
+                    as 1h30m.
+
+Connections are shown 25 at a time, and PAGE shows the later ones.`,
+	},
+	"cprivmsg": {
+		text: `CPRIVMSG <nickname> <channel> <text to be sent>
+
+Sends the text to the given user as a PRIVMSG. You must be voiced or a channel
+operator in the given channel, and they must be in it too. Their +g and +R modes
+don't stop these messages.`,
 	},
 	"cs": {
 		text: `CS <subcommand> [params]
 
-ChanServ controls channel registrations.`,
+ChanServ controls channel registrations. See /HELPOP CHANSERV.`,
 	},
 	"debug": {
 		oper: true,
@@ -141,14 +337,20 @@ ChanServ controls channel registrations.`,
 Prints debug information about the IRCd. <option> can be one of:
 
 * GCSTATS: Garbage control statistics.
+* MEMSTATS: Detailed memory and heap statistics.
 * NUMGOROUTINE: Number of goroutines in use.
+* GOROUTINEDUMP: Writes out the full stack of every goroutine.
 * STARTCPUPROFILE: Starts the CPU profiler.
 * STOPCPUPROFILE: Stops the CPU profiler.
-* PROFILEHEAP: Writes out the CPU profiler info.`,
+* PROFILEHEAP: Writes out the CPU profiler info.
+* STARTBLOCKPROFILE: Starts the blocking profiler.
+* STOPBLOCKPROFILE: Writes out the blocking profile and stops the profiler.
+* STARTMUTEXPROFILE: Starts the mutex contention profiler.
+* STOPMUTEXPROFILE: Writes out the mutex profile and stops the profiler.`,
 	},
 	"dline": {
 		oper: true,
-		text: `DLINE [ANDKILL] [MYSELF] [duration] <ip>/<net> [ON <server>] [reason [| oper reason]]
+		text: `DLINE [ANDKILL] [MYSELF] [REPORT] [duration] <ip>/<net> [ON <server>] [reason [| oper reason]]
 
 Bans an IP address or network from connecting to the server. If the duration is
 given then only for that long. The reason is shown to the user themselves, but
@@ -162,6 +364,9 @@ Bans are saved across subsequent launches of the server.
 "MYSELF" is required when the DLINE matches the address the person applying it is connected
 from. If "MYSELF" is not given, trying to DLINE yourself will result in an error.
 
+"REPORT" also reports the IP (or for a network, the IPs of matching clients) to the
+network's abuse blacklist, such as DroneBL, if abuse reports are enabled.
+
 [duration] can be of the following forms:
 	1y 12mo 31d 10h 8m 13s
 
@@ -172,22 +377,104 @@ from. If "MYSELF" is not given, trying to DLINE yourself will result in an error
 ON <server> specifies that the ban is to be set on that specific server.
 
 [reason] and [oper reason], if they exist, are separated by a vertical bar (|).`,
+	},
+	"globalnotice": {
+		oper: true,
+		text: `GLOBALNOTICE <target> <text>
+
+Sends a server notice to the given users, such as a warning about upcoming
+maintenance. The target is one of:
+
+*                   Every user on the server.
+LISTENER:<address>  The users connected to the given listener, like
+                    LISTENER:127.0.0.1:6668.
+CLASS:<name>        The users in the given connection class.
+
+Every notice is written to the audit log. Requires the oper:globalnotice
+capability.`,
+	},
+	"health": {
+		text: `HEALTH
+
+Checks whether the server is healthy, replying with either "HEALTH OK" or "HEALTH
+FAILING" and the checks that failed. This is intended for load balancers, and can be
+used before registering. It only works if the server has enabled the IRC probe.`,
 	},
 	"help": {
-		text: `HELP <argument>
+		text: `HELP <argument> [page]
 
-Get an explanation of <argument>, or "index" for a list of help topics.`,
+Get an explanation of <argument>, or "index" for a list of help topics. Long
+explanations are split into pages, and the given page is shown.`,
 	},
 	"helpop": {
-		text: `HELPOP <argument>
+		text: `HELPOP <argument> [page]
 
-Get an explanation of <argument>, or "index" for a list of help topics.`,
+Get an explanation of <argument>, or "index" for a list of help topics. Long
+explanations are split into pages, and the given page is shown.`,
+	},
+	"hostserv": {
+		text: `HOSTSERV <subcommand> [params]
+
+HostServ controls the vhosts given to accounts. Once your account has a vhost,
+it's used whenever you log in. Supported subcommands:
+
+    ON                     Use your account's vhost.
+    OFF                    Stop using your account's vhost.
+    SET <account> <vhost>  Give an account a vhost (opers only).
+    DEL <account>          Remove an account's vhost (opers only).
+
+Use /HELPOP HOSTSERV <subcommand> or /HS HELP <subcommand> for more help.`,
+	},
+	"hostserv del": {
+		oper: true,
+		text: `DEL <account>
+
+Removes the vhost of the given account. Clients logged into the account go back
+to their normal hostname straight away.`,
+		helpType: ServiceHelpEntry,
+	},
+	"hostserv off": {
+		text: `OFF
+
+Stops using your account's vhost, until you turn it back on with ON. This is
+remembered between connections.`,
+		helpType: ServiceHelpEntry,
+	},
+	"hostserv on": {
+		text: `ON
+
+Uses your account's vhost again, after you've turned it off with OFF.`,
+		helpType: ServiceHelpEntry,
+	},
+	"hostserv set": {
+		oper: true,
+		text: `SET <account> <vhost>
+
+Gives the given account a vhost. Clients logged into the account get the vhost
+straight away (unless they've turned it off), and it's used whenever someone
+logs into the account.`,
+		helpType: ServiceHelpEntry,
+	},
+	"hs": {
+		text: `HS <subcommand> [params]
+
+HostServ controls the vhosts given to accounts. See /HELPOP HOSTSERV.`,
+	},
+	"info": {
+		text: `INFO [server]
+
+Shows the version and description of this, or the given, server, along with
+credits for the people who've worked on it.`,
 	},
 	"invite": {
 		text: `INVITE <nickname> <channel>
+INVITE
 
 Invites the given user to the given channel, so long as you have the
-appropriate channel privs.`,
+appropriate channel privs. Invites to invite-only (+i) channels can be
+used once, and expire after a while.
+
+With no parameters, lists the invites you've been given but haven't used yet.`,
 	},
 	"ison": {
 		text: `ISON <nickname>{ <nickname>}
@@ -214,7 +501,7 @@ supplied.`,
 	},
 	"kline": {
 		oper: true,
-		text: `KLINE [ANDKILL] [MYSELF] [duration] <mask> [ON <server>] [reason [| oper reason]]
+		text: `KLINE [ANDKILL] [MYSELF] [REPORT] [duration] <mask> [ON <server>] [reason [| oper reason]]
 
 Bans a mask from connecting to the server. If the duration is given then only for that
 long. The reason is shown to the user themselves, but everyone else will see a standard
@@ -227,6 +514,9 @@ Bans are saved across subsequent launches of the server.
 "MYSELF" is required when the KLINE matches the address the person applying it is connected
 from. If "MYSELF" is not given, trying to KLINE yourself will result in an error.
 
+"REPORT" also reports the IPs of matching clients to the network's abuse blacklist,
+such as DroneBL, if abuse reports are enabled.
+
 [duration] can be of the following forms:
 	1y 12mo 31d 10h 8m 13s
 
@@ -237,13 +527,43 @@ from. If "MYSELF" is not given, trying to KLINE yourself will result in an error
 ON <server> specifies that the ban is to be set on that specific server.
 
 [reason] and [oper reason], if they exist, are separated by a vertical bar (|).`,
+	},
+	"language": {
+		text: `LANGUAGE <code>{ <code>}
+
+Sets your preferred languages to the given ones, in order of preference. Our
+replies and help are sent in the first of your languages that has a translation
+for them.
+The languages we support are listed in the LANGUAGE ISUPPORT token. If you're
+logged into an account, your choice is saved and restored when you next log in.
+
+Use "LANGUAGE en" to go back to English.`,
 	},
 	"list": {
 		text: `LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
 
 Shows information on the given channels (or if none are given, then on all
-channels). <elistcond>s modify how the channels are selected.`,
-		//TODO(dan): Explain <elistcond>s in more specific detail
+channels). <elistcond>s modify how the channels are selected:
+
+    <n          Channels with fewer than n users.
+    >n          Channels with more than n users.
+    T:<text>    Channels whose topic contains <text>. If <text> has * or ?
+                wildcards, the whole topic must match it instead, and it can
+                also be a /regex/.
+
+For example, "LIST >10 T:linux" lists channels with more than 10 users that
+talk about linux.`,
+	},
+	"loglevel": {
+		oper: true,
+		text: `LOGLEVEL [<type> [<level>|DEFAULT]]
+
+Changes how much we log, without needing a rehash. <type> is a log type such as
+"localconnect" or "chanserv", or * for every type. <level> is one of debug,
+info, warn or error, and overrides the configured level of every logger that
+captures <type>. DEFAULT removes the override again.
+
+Without any parameters, shows the levels that are currently overridden.`,
 	},
 	"lusers": {
 		text: `LUSERS [<mask> [<server>]]
@@ -299,7 +619,126 @@ Sets your nickname to the new given one.`,
 	"nickserv": {
 		text: `NICKSERV <subcommand> [params]
 
-NickServ controls accounts and user registrations.`,
+NickServ controls accounts and user registrations. Supported subcommands:
+
+    IDENTIFY [account] <password>
+                           Log into the given account (by default, the account named
+                           after your current nick).
+    LOGOUT                 Log out of your account without disconnecting (unless you
+                           had to log in with SASL to connect).
+    INFO [account]         Show when the given account (by default, yours) was
+                           registered. For your own account, also shows your
+                           sessions and settings.
+    GHOST [nick]           Disconnect the client using the given nick (by default, your
+                           account name), if it's your account name or one of your
+                           other sessions. The nick is then held for you for a minute.
+    REGAIN [nick]          Same as GHOST, but you're changed to the nick straight away.
+    RELEASE [nick]         Stop holding the given nick after a GHOST.
+    SET REGONLY <ON|OFF>   Only take private messages from clients logged into an
+                           account (this sets user mode +R whenever you log in).
+    SET AUTOAWAY <ON|OFF>  Mark you as away after you've been idle for a while, and
+                           back again once you're active (if the server allows it).
+    SET HIDEIDLE <ON|OFF>  Hide your idle and signon times from WHOIS (opers can still
+                           see them).
+    SET NOHISTORY <ON|OFF> Stop your direct messages with other accounts being stored
+                           in conversation history, and forget the ones that are.
+    SET WEBHOOK <url|OFF>  POST private messages sent to you while you're not
+                           connected to the given URL, as JSON (if the server allows it),
+                           along with mentions of you in the channels you auto-join.
+    SET AUTOJOIN <channel>{,<channel>}|OFF
+                           Join you to the given channels when you connect or identify.
+    SET LANGUAGE <code>{ <code>}
+                           Set the languages our replies are sent to you in.
+    CERT ADD [fingerprint] Let the given TLS client certificate (by default, the one
+                           you're connected with) log you in with SASL EXTERNAL.
+    CERT DEL <fingerprint> Stop the given certificate logging you in.
+    CERT LIST              List the certificates that can log you in.
+
+Use /HELPOP NICKSERV <subcommand> or /NS HELP <subcommand> for more help.`,
+	},
+	"nickserv cert": {
+		text: `CERT ADD [fingerprint]
+CERT DEL <fingerprint>
+CERT LIST
+
+Manages the TLS client certificates that can log you into your account with SASL
+EXTERNAL (or automatically when you connect, if the server allows it), without a
+password. ADD adds the certificate you're connected with, or the one with the
+given SHA-256 fingerprint. Accounts can have up to 5 certificates, and each
+certificate can only be on one account. You must be logged into an account.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv ghost": {
+		text: `GHOST [nick]
+
+Disconnects the client using the given nick (by default, your account name), if
+it's your account name or the nick of one of your other sessions. The nick is
+then held for you for a minute, so you can take it with /NICK. You must be
+logged into an account.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv identify": {
+		text: `IDENTIFY [account] <password>
+
+Logs you into the given account (by default, the account named after your
+current nick). If you're already logged into another account, you're logged out
+of it first. Clients should log in with SASL when they connect instead, where
+they can.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv info": {
+		text: `INFO [account]
+
+Shows when the given account (by default, yours) was registered. For your own
+account, also shows the nicks of your sessions, your settings, your vhost and
+your languages. Opers see these for every account.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv logout": {
+		text: `LOGOUT
+
+Logs you out of your account without disconnecting you. Your account's vhost and
+the modes it set are removed.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv regain": {
+		text: `REGAIN [nick]
+
+Same as GHOST, but you're changed to the nick straight away.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv release": {
+		text: `RELEASE [nick]
+
+Stops holding the given nick for you after a GHOST, so anyone can use it.`,
+		helpType: ServiceHelpEntry,
+	},
+	"nickserv set": {
+		text: `SET <setting> <value>
+
+Changes a setting of your account. You must be logged into it. The settings are:
+
+    REGONLY <ON|OFF>    Only take private messages from clients logged into an
+                        account (this sets user mode +R whenever you log in).
+    AUTOAWAY <ON|OFF>   Mark you as away after you've been idle for a while, and
+                        back again once you're active (if the server allows it).
+    HIDEIDLE <ON|OFF>   Hide your idle and signon times from WHOIS (opers can
+                        still see them).
+    NOHISTORY <ON|OFF>  Stop your direct messages with other accounts being
+                        stored in conversation history, and forget the ones
+                        that are.
+    WEBHOOK <url|OFF>   POST private messages sent to you while you're not
+                        connected to the given URL, as JSON (if the server
+                        allows it), along with mentions of you in the
+                        channels you auto-join.
+    AUTOJOIN <channel>{,<channel>}|OFF
+                        Join you to the given channels when you connect, or
+                        when you IDENTIFY.
+    LANGUAGE <code>{ <code>}
+                        Set the languages our replies (and help) are sent to
+                        you in, in order of preference, like the LANGUAGE
+                        command does. Use "en" for English.`,
+		helpType: ServiceHelpEntry,
 	},
 	"notice": {
 		text: `NOTICE <target>{,<target>} <text to be sent>
@@ -323,7 +762,7 @@ Requires the roleplay mode (+E) to be set on the target.`,
 	"ns": {
 		text: `NS <subcommand> [params]
 
-NickServ controls accounts and user registrations.`,
+NickServ controls accounts and user registrations. See /HELPOP NICKSERV.`,
 	},
 	"oper": {
 		text: `OPER <name> <password>
@@ -376,7 +815,12 @@ Gives the given user a new nickname.`,
 
 Forcibly sets and removes modes from the given target -- only available to
 opers. For more specific information on mode characters, see the help for
-"cmode" and "umode".`,
+"cmode" and "umode".
+
+Changing (or viewing) another user's modes also needs the oper:samode_users
+capability. SAMODE can remove +o from users, but can't give it to them, since
+opers need to use OPER. Every change made with SAMODE is written to the audit
+log, along with the target's modes before and after.`,
 	},
 	"scene": {
 		text: `SCENE <target> <text to be sent>
@@ -399,6 +843,20 @@ Indicates that you're leaving the server, and shows everyone the given reason.`,
 		text: `REHASH
 
 Reloads the config file and updates TLS certificates on listeners`,
+	},
+	"reloadmotd": {
+		oper: true,
+		text: `RELOADMOTD
+
+Reloads the MOTD files, without rehashing the rest of the config.`,
+	},
+	"shutdown": {
+		oper: true,
+		text: `SHUTDOWN [reason]
+
+Gracefully shuts down the server. Clients are sent a notice (including the
+reason, if given), new connections stop being accepted, and after a short
+drain period the remaining clients are disconnected.`,
 	},
 	"time": {
 		text: `TIME [server]
@@ -446,6 +904,12 @@ Shows information about the given users. Takes up to 10 nicknames.`,
 		text: `VERSION [server]
 
 Views the version of software and the RPL_ISUPPORT tokens for the given server.`,
+	},
+	"wallchops": {
+		text: `WALLCHOPS <channel>{,<channel>} <text>
+
+Sends a notice to the operators of the given channels, the same as sending a
+NOTICE to @<channel>.`,
 	},
 	"who": {
 		text: `WHO <name> [o]
@@ -521,45 +985,36 @@ Oragono supports the following channel membership prefixes:
 	},
 }
 
-// HelpIndex contains the list of all help topics for regular users.
-var HelpIndex = "list of all help topics for regular users"
-
-// HelpIndexOpers contains the list of all help topics for opers.
-var HelpIndexOpers = "list of all help topics for opers"
-
-// GenerateHelpIndex is used to generate HelpIndex.
-func GenerateHelpIndex(forOpers bool) string {
-	newHelpIndex := `= Help Topics =
-
-Commands:
-%s
-
-RPL_ISUPPORT Tokens:
-%s
-
-Information:
-%s`
+// GenerateHelpIndex generates the list of help topics from our built-in Help entries
+// and the given extra ones, with its headings translated by the given function.
+func GenerateHelpIndex(forOpers bool, extra map[string]HelpEntry, t func(string) string) string {
+	newHelpIndex := t("= Help Topics =") + "\n\n" +
+		t("Commands:") + "\n%s\n\n" +
+		t("RPL_ISUPPORT Tokens:") + "\n%s\n\n" +
+		t("Information:") + "\n%s"
 
 	// generate them
 	var commands, isupport, information []string
 
 	var line string
-	for name, info := range Help {
-		if info.duplicate {
-			continue
-		}
-		if info.oper && !forOpers {
-			continue
-		}
-
-		line = fmt.Sprintf("   %s", name)
-
-		if info.helpType == CommandHelpEntry {
-			commands = append(commands, line)
-		} else if info.helpType == ISupportHelpEntry {
-			isupport = append(isupport, line)
-		} else if info.helpType == InformationHelpEntry {
-			information = append(information, line)
+	for _, entries := range []map[string]HelpEntry{Help, extra} {
+		for name, info := range entries {
+			if info.duplicate || info.helpType == ServiceHelpEntry {
+				continue
+			}
+			if info.oper && !forOpers {
+				continue
+			}
+
+			line = fmt.Sprintf("   %s", name)
+
+			if info.helpType == CommandHelpEntry {
+				commands = append(commands, line)
+			} else if info.helpType == ISupportHelpEntry {
+				isupport = append(isupport, line)
+			} else if info.helpType == InformationHelpEntry {
+				information = append(information, line)
+			}
 		}
 	}
 
@@ -574,10 +1029,35 @@ Information:
 	return newHelpIndex
 }
 
-// sendHelp sends the client help of the given string.
-func (client *Client) sendHelp(name string, text string) {
+// helpPage returns the lines on the given page (counting from 1) of the given help
+// text, and how many pages it has. A pageLines of 0 puts everything on one page.
+func helpPage(text string, pageLines int, page int) (lines []string, pages int) {
+	lines = strings.Split(text, "\n")
+	if pageLines < 1 {
+		pageLines = len(lines)
+	}
+	pages = (len(lines) + pageLines - 1) / pageLines
+	if page < 1 || pages < page {
+		return nil, pages
+	}
+	start := (page - 1) * pageLines
+	end := start + pageLines
+	if len(lines) < end {
+		end = len(lines)
+	}
+	return lines[start:end], pages
+}
+
+// sendHelp sends the client the given page of the help text for the given topic, which
+// they asked for with the given command (HELP or HELPOP).
+func (client *Client) sendHelp(command string, name string, topic string, text string, page int) {
 	splitName := strings.Split(name, " ")
-	textLines := strings.Split(text, "\n")
+	textLines, pages := helpPage(text, client.server.Config().Server.HelpPageLines, page)
+	if len(textLines) == 0 {
+		args := append(splitName, fmt.Sprintf(client.t("Help page not found, %[1]s has %[2]d pages"), topic, pages))
+		client.Send(nil, client.server.name, ERR_HELPNOTFOUND, args...)
+		return
+	}
 
 	for i, line := range textLines {
 		args := splitName
@@ -588,39 +1068,64 @@ func (client *Client) sendHelp(name string, text string) {
 			client.Send(nil, client.server.name, RPL_HELPTXT, args...)
 		}
 	}
+	if page < pages {
+		args := splitName
+		args = append(args, fmt.Sprintf(client.t("Use /%[1]s %[2]s %[3]d for more (page %[4]d of %[5]d)"), command, topic, page+1, page, pages))
+		client.Send(nil, client.server.name, RPL_HELPTXT, args...)
+	}
 	args := splitName
-	args = append(args, "End of /HELPOP")
+	args = append(args, client.t("End of /HELPOP"))
 	client.Send(nil, client.server.name, RPL_ENDOFHELP, args...)
 }
 
+// helpText returns the text of the given help entry in the client's language.
+func (client *Client) helpText(name string, text string) string {
+	return client.server.Languages().TranslateHelp(client.Languages(), name, text)
+}
+
 // helpHandler returns the appropriate help for the given query.
 func helpHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	argument := strings.ToLower(strings.TrimSpace(strings.Join(msg.Params, " ")))
 
 	if len(argument) < 1 {
-		client.sendHelp("HELPOP", `HELPOP <argument>
-
-Get an explanation of <argument>, or "index" for a list of help topics.`)
+		client.sendHelp(msg.Command, "HELPOP", "", client.helpText("helpop", Help["helpop"].text), 1)
 		return false
 	}
 
+	// long entries are split into pages, which are given after the topic
+	page := 1
+	fields := strings.Fields(argument)
+	if 1 < len(fields) {
+		pageNumber, err := strconv.Atoi(fields[len(fields)-1])
+		if err == nil {
+			page = pageNumber
+			fields = fields[:len(fields)-1]
+			argument = strings.Join(fields, " ")
+		}
+	}
+
 	// handle index
 	if argument == "index" {
-		if client.flags[Operator] {
-			client.sendHelp("HELP", HelpIndexOpers)
-		} else {
-			client.sendHelp("HELP", HelpIndex)
-		}
+		client.sendHelp(msg.Command, "HELP", argument, server.help.Index(client.Languages(), client.HasMode(Operator)), page)
 		return false
 	}
 
-	helpHandler, exists := Help[argument]
+	// services subcommands can be given with the short name of the service, like NS
+	if 1 < len(fields) {
+		service, isAlias := serviceAliases[fields[0]]
+		if isAlias {
+			fields[0] = service
+		}
+		argument = strings.Join(fields, " ")
+	}
+
+	helpHandler, exists := server.help.Entry(argument)
 
-	if exists && (!helpHandler.oper || (helpHandler.oper && client.flags[Operator])) {
-		client.sendHelp(strings.ToUpper(argument), helpHandler.text)
+	if exists && (!helpHandler.oper || (helpHandler.oper && client.HasMode(Operator))) {
+		client.sendHelp(msg.Command, strings.ToUpper(argument), argument, client.helpText(argument, helpHandler.text), page)
 	} else {
 		args := msg.Params
-		args = append(args, "Help not found")
+		args = append(args, client.t("Help not found"))
 		client.Send(nil, server.name, ERR_HELPNOTFOUND, args...)
 	}
 
@@ -0,0 +1,48 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelpPage(t *testing.T) {
+	text := "a\nb\nc\nd\ne"
+	var tests = []struct {
+		pageLines int
+		page      int
+		lines     []string
+		pages     int
+	}{
+		{0, 1, []string{"a", "b", "c", "d", "e"}, 1},
+		{0, 2, nil, 1},
+		{2, 1, []string{"a", "b"}, 3},
+		{2, 3, []string{"e"}, 3},
+		{2, 4, nil, 3},
+		{5, 1, []string{"a", "b", "c", "d", "e"}, 1},
+		{2, 0, nil, 3},
+	}
+	for _, test := range tests {
+		lines, pages := helpPage(text, test.pageLines, test.page)
+		if !reflect.DeepEqual(lines, test.lines) || pages != test.pages {
+			t.Errorf("expected page %d with %d lines per page to be %v of %d pages, got %v of %d", test.page, test.pageLines, test.lines, test.pages, lines, pages)
+		}
+	}
+}
+
+func TestSendHelpNextPage(t *testing.T) {
+	server := newTestServer()
+	server.config.Server.HelpPageLines = 2
+	client := newTestClient(server, "dan")
+
+	for _, command := range []string{"HELP", "HELPOP"} {
+		client.sendHelp(command, "NICK", "nick", "a\nb\nc", 1)
+		lines := sentLines(client)
+		if len(lines) != 4 || !strings.Contains(lines[2], "Use /"+command+" nick 2 for more (page 1 of 2)") {
+			t.Errorf("expected the next page hint to use %s, got %q", command, lines)
+		}
+	}
+}
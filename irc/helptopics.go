@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oragono/oragono/irc/languages"
+)
+
+// HelpManager holds the help entries made from the config (for aliases and help-topics),
+// and the help indexes generated from those and our built-in Help entries. Both are
+// rebuilt on every rehash.
+type HelpManager struct {
+	sync.RWMutex
+	entries map[string]HelpEntry
+	// indexes and operIndexes hold the help index in each of our languages.
+	indexes     map[string]string
+	operIndexes map[string]string
+}
+
+// NewHelpManager returns a new HelpManager. Rebuild must be called before it's used.
+func NewHelpManager() *HelpManager {
+	return &HelpManager{
+		entries:     make(map[string]HelpEntry),
+		indexes:     make(map[string]string),
+		operIndexes: make(map[string]string),
+	}
+}
+
+// checkHelpTopics returns an error if any of the config's help topics can't be used.
+func (conf *Config) checkHelpTopics() error {
+	for name, text := range conf.HelpTopics {
+		if name != strings.ToLower(name) || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("Help topic name [%s] must be lowercase and not empty", name)
+		}
+		if _, exists := Help[name]; exists {
+			return fmt.Errorf("Help topic %s has the same name as a built-in help entry", name)
+		}
+		if _, exists := conf.Aliases[strings.ToUpper(name)]; exists {
+			return fmt.Errorf("Help topic %s has the same name as an alias", name)
+		}
+		if strings.TrimSpace(text) == "" {
+			return fmt.Errorf("Help topic %s is empty", name)
+		}
+	}
+	return nil
+}
+
+// checkHelpTranslations returns an error if any of our languages translates a help
+// entry that doesn't exist, which is usually a typo in its name.
+func (conf *Config) checkHelpTranslations() error {
+	for code, lang := range conf.Languages.Data {
+		for name := range lang.Help {
+			_, builtin := Help[name]
+			_, topic := conf.HelpTopics[name]
+			if !builtin && !topic {
+				return fmt.Errorf("Language [%s] translates help entry %s, which doesn't exist", code, name)
+			}
+		}
+	}
+	return nil
+}
+
+// aliasHelpEntry returns a help entry explaining the given alias. It's only shown to
+// opers if the command it points to is oper-only.
+func aliasHelpEntry(alias *CommandAlias) HelpEntry {
+	command := strings.TrimSpace(alias.Command + " " + strings.Join(alias.Params, " "))
+	return HelpEntry{
+		oper: Help[strings.ToLower(alias.Command)].oper,
+		text: fmt.Sprintf("%s\n\n%s is an alias for:\n\n  %s", alias.Name, alias.Name, command),
+	}
+}
+
+// Rebuild replaces the help entries made from the config with the ones from the given
+// config, and regenerates the help index in each of the given languages.
+func (hm *HelpManager) Rebuild(config *Config, langManager *languages.Manager) {
+	entries := make(map[string]HelpEntry)
+	for _, alias := range config.Aliases {
+		entries[strings.ToLower(alias.Name)] = aliasHelpEntry(alias)
+	}
+	for name, text := range config.HelpTopics {
+		entries[name] = HelpEntry{
+			text:     strings.TrimRight(text, "\n"),
+			helpType: InformationHelpEntry,
+		}
+	}
+
+	indexes := make(map[string]string)
+	operIndexes := make(map[string]string)
+	for code := range langManager.Info {
+		codes := []string{code}
+		t := func(original string) string {
+			return langManager.Translate(codes, original)
+		}
+		indexes[code] = GenerateHelpIndex(false, entries, t)
+		operIndexes[code] = GenerateHelpIndex(true, entries, t)
+	}
+	// clients who haven't picked a language get the default one
+	indexes[""] = indexes[langManager.Default()]
+	operIndexes[""] = operIndexes[langManager.Default()]
+
+	hm.Lock()
+	defer hm.Unlock()
+	hm.entries = entries
+	hm.indexes = indexes
+	hm.operIndexes = operIndexes
+}
+
+// Entry returns the help entry with the given name, built-in or from the config.
+func (hm *HelpManager) Entry(name string) (HelpEntry, bool) {
+	entry, exists := Help[name]
+	if exists {
+		return entry, true
+	}
+
+	hm.RLock()
+	defer hm.RUnlock()
+	entry, exists = hm.entries[name]
+	return entry, exists
+}
+
+// Index returns the help index in the first of the given languages that we have.
+func (hm *HelpManager) Index(codes []string, forOpers bool) string {
+	hm.RLock()
+	defer hm.RUnlock()
+
+	indexes := hm.indexes
+	if forOpers {
+		indexes = hm.operIndexes
+	}
+	for _, code := range codes {
+		index, exists := indexes[code]
+		if exists {
+			return index
+		}
+	}
+	return indexes[""]
+}
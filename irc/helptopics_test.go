@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oragono/oragono/irc/languages"
+)
+
+func TestHelpManager(t *testing.T) {
+	alias, err := NewCommandAlias("id", "NICKSERV IDENTIFY $*")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	operAlias, err := NewCommandAlias("kl", "KLINE $*")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	config := &Config{
+		Aliases:    map[string]*CommandAlias{"ID": alias, "KL": operAlias},
+		HelpTopics: map[string]string{"rules": "Be nice.\n"},
+	}
+	err = config.checkHelpTopics()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	es := &languages.LangData{Code: "es", Translations: map[string]string{"Commands:": "Comandos:"}}
+	hm := NewHelpManager()
+	hm.Rebuild(config, languages.NewManager("en", map[string]*languages.LangData{"es": es}))
+
+	entry, exists := hm.Entry("rules")
+	if !exists || entry.text != "Be nice." || entry.helpType != InformationHelpEntry {
+		t.Errorf("expected the rules topic, got %v", entry)
+	}
+	entry, exists = hm.Entry("id")
+	if !exists || !strings.Contains(entry.text, "NICKSERV IDENTIFY $*") {
+		t.Errorf("expected help for the ID alias, got %v", entry)
+	}
+	if _, exists = hm.Entry("join"); !exists {
+		t.Error("expected built-in entries to still be found")
+	}
+
+	index := hm.Index(nil, false)
+	if !strings.Contains(index, "   rules") || !strings.Contains(index, "   id") || strings.Contains(index, "   kl") {
+		t.Errorf("expected the index to list the rules topic and the ID alias, got:\n%s", index)
+	}
+	if !strings.Contains(hm.Index(nil, true), "   kl") {
+		t.Error("expected aliases for oper commands to be listed for opers")
+	}
+	if !strings.Contains(hm.Index([]string{"es"}, false), "Comandos:") {
+		t.Error("expected the Spanish index to be translated")
+	}
+
+	// rehashing without the topic removes it
+	config.HelpTopics = nil
+	hm.Rebuild(config, languages.NewManager("en", nil))
+	if _, exists = hm.Entry("rules"); exists {
+		t.Error("expected the rules topic to be gone after rebuilding")
+	}
+
+	for _, name := range []string{"Rules", "join", "id"} {
+		config.HelpTopics = map[string]string{name: "text"}
+		if config.checkHelpTopics() == nil {
+			t.Errorf("expected help topic %s to be rejected", name)
+		}
+	}
+}
+
+func TestHelpTranslations(t *testing.T) {
+	es := &languages.LangData{
+		Code: "es",
+		Help: map[string]string{"help": "HELP <argumento>", "rules": "Sé amable."},
+	}
+	config := &Config{HelpTopics: map[string]string{"rules": "Be nice."}}
+	config.Languages.Data = map[string]*languages.LangData{"es": es}
+	if err := config.checkHelpTranslations(); err != nil {
+		t.Errorf("expected translations of built-in entries and help topics to be allowed, got %s", err.Error())
+	}
+	es.Help["nosuchentry"] = "?"
+	if err := config.checkHelpTranslations(); err == nil {
+		t.Error("expected translations of entries that don't exist to be rejected")
+	}
+
+	lm := languages.NewManager("en", config.Languages.Data)
+	if text := lm.TranslateHelp([]string{"es"}, "help", Help["help"].text); text != "HELP <argumento>" {
+		t.Errorf("expected the Spanish help text, got %s", text)
+	}
+	if text := lm.TranslateHelp([]string{"es"}, "join", Help["join"].text); text != Help["join"].text {
+		t.Errorf("expected entries without a translation to be in English, got %s", text)
+	}
+	if text := lm.TranslateHelp(nil, "help", Help["help"].text); text != Help["help"].text {
+		t.Errorf("expected clients without a language to get English, got %s", text)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// ItemType represents the type of an event stored in the history buffer.
+type ItemType uint
+
+const (
+	uninitializedItem ItemType = iota
+	// Privmsg is a PRIVMSG.
+	Privmsg
+	// Notice is a NOTICE.
+	Notice
+	// Tagmsg is a TAGMSG.
+	Tagmsg
+)
+
+// Item represents an event (e.g., a PRIVMSG) and its associated data.
+type Item struct {
+	Type ItemType
+	Time time.Time
+
+	Nick        string
+	AccountName string
+	Msgid       string
+	Message     string
+	// Target is the nick a direct message was sent to, and is empty for channel messages.
+	Target string
+	// MinPrefix is the lowest channel prefix (like @) that can see this STATUSMSG, or
+	// empty if it was sent to everyone.
+	MinPrefix string
+	// Tags holds the client-only tags that were sent with the message.
+	Tags map[string]string
+}
+
+// Buffer is a ring buffer holding message/event history for a channel or user.
+// Its entries are allocated up-front, so adding items doesn't allocate.
+type Buffer struct {
+	sync.RWMutex
+
+	buffer []Item
+	// start is the index of our oldest item, end is where our next item gets written
+	start int
+	end   int
+	full  bool
+}
+
+// NewHistoryBuffer returns a new Buffer that holds up to size items. A size of 0
+// means history is disabled, and nothing will be stored.
+func NewHistoryBuffer(size int) *Buffer {
+	return &Buffer{
+		buffer: make([]Item, size),
+	}
+}
+
+// Add adds a history item to the buffer, overwriting the oldest item if we're full.
+func (list *Buffer) Add(item Item) {
+	list.Lock()
+	defer list.Unlock()
+
+	if len(list.buffer) == 0 {
+		return
+	}
+
+	if item.Time.IsZero() {
+		item.Time = time.Now()
+	}
+
+	list.buffer[list.end] = item
+	list.end = (list.end + 1) % len(list.buffer)
+	if list.full {
+		list.start = list.end
+	} else if list.end == list.start {
+		list.full = true
+	}
+}
+
+// Len returns how many items are stored in the buffer.
+func (list *Buffer) Len() int {
+	list.RLock()
+	defer list.RUnlock()
+
+	return list.lenNoMutex()
+}
+
+func (list *Buffer) lenNoMutex() int {
+	if list.full {
+		return len(list.buffer)
+	}
+	return list.end - list.start
+}
+
+// Latest returns up to limit of the most recent items, oldest first. A limit of 0
+// returns everything we have.
+func (list *Buffer) Latest(limit int) []Item {
+	list.RLock()
+	defer list.RUnlock()
+
+	length := list.lenNoMutex()
+	if limit == 0 || length < limit {
+		limit = length
+	}
+
+	results := make([]Item, limit)
+	for i := 0; i < limit; i++ {
+		results[i] = list.buffer[(list.start+length-limit+i)%len(list.buffer)]
+	}
+	return results
+}
+
+// Between returns all the items sent after `after` and before `before`, oldest first.
+// Zero times mean that side is unbounded.
+func (list *Buffer) Between(after, before time.Time) []Item {
+	list.RLock()
+	defer list.RUnlock()
+
+	var results []Item
+	length := list.lenNoMutex()
+	for i := 0; i < length; i++ {
+		item := list.buffer[(list.start+i)%len(list.buffer)]
+		if !after.IsZero() && !item.Time.After(after) {
+			continue
+		}
+		if !before.IsZero() && !item.Time.Before(before) {
+			continue
+		}
+		results = append(results, item)
+	}
+	return results
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmptyBuffer(t *testing.T) {
+	buf := NewHistoryBuffer(0)
+	buf.Add(Item{Nick: "testnick"})
+
+	if buf.Len() != 0 {
+		t.Errorf("disabled buffer should be empty, has %d items", buf.Len())
+	}
+	if len(buf.Latest(0)) != 0 {
+		t.Error("disabled buffer should not return any items")
+	}
+}
+
+func TestBufferWraps(t *testing.T) {
+	buf := NewHistoryBuffer(3)
+	start := time.Now()
+
+	for i, nick := range []string{"one", "two", "three", "four", "five"} {
+		buf.Add(Item{
+			Nick: nick,
+			Time: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	if buf.Len() != 3 {
+		t.Errorf("expected buffer to have 3 items, has %d", buf.Len())
+	}
+
+	items := buf.Latest(0)
+	for i, nick := range []string{"three", "four", "five"} {
+		if items[i].Nick != nick {
+			t.Errorf("expected item %d to be %s, got %s", i, nick, items[i].Nick)
+		}
+	}
+
+	items = buf.Latest(2)
+	if len(items) != 2 || items[0].Nick != "four" || items[1].Nick != "five" {
+		t.Errorf("unexpected latest items: %v", items)
+	}
+
+	items = buf.Between(start.Add(2*time.Second), time.Time{})
+	if len(items) != 2 || items[0].Nick != "four" {
+		t.Errorf("unexpected items between: %v", items)
+	}
+}
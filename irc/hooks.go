@@ -0,0 +1,479 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// these are the events hooks can be told about
+const (
+	// HookCommand happens before any command a client sends is run.
+	HookCommand = "command"
+	// HookMessage happens before a message (PRIVMSG, NOTICE, TAGMSG, CPRIVMSG,
+	// CNOTICE or a roleplay message) is passed on to its target.
+	HookMessage = "message"
+	// HookJoin happens before a client joins a channel.
+	HookJoin = "join"
+	// HookRegister happens before a client finishes connecting.
+	HookRegister = "register"
+)
+
+var (
+	hookEvents = map[string]bool{
+		HookCommand:  true,
+		HookMessage:  true,
+		HookJoin:     true,
+		HookRegister: true,
+	}
+
+	errHookNotRunning = errors.New("Hook process isn't running")
+	errHookBusy       = errors.New("Hook process has too many events waiting")
+	errHookTimeout    = errors.New("Hook process took too long to answer")
+	errHookStopped    = errors.New("Hook process stopped before answering")
+)
+
+// HookConfig is an external process that's told about events as they happen, and
+// can stop them from going ahead.
+type HookConfig struct {
+	Command       string
+	Args          []string
+	Events        []string
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+	// DenyOnFailure stops events from going ahead if the process can't be asked
+	// about them, rather than letting them through.
+	DenyOnFailure bool `yaml:"deny-on-failure"`
+}
+
+// parseHooks checks the hooks in the config and fills in their defaults.
+func (conf *Config) parseHooks() error {
+	for i := range conf.Hooks {
+		hook := &conf.Hooks[i]
+		if hook.Command == "" {
+			return errors.New("Hooks need a command to run")
+		}
+		if len(hook.Events) == 0 {
+			return fmt.Errorf("Hook %s isn't given any events", hook.Command)
+		}
+		for _, event := range hook.Events {
+			if !hookEvents[event] {
+				return fmt.Errorf("Hook %s is given unknown event %s", hook.Command, event)
+			}
+		}
+		hook.Timeout = defaultHookTimeout
+		if hook.TimeoutString != "" {
+			var err error
+			hook.Timeout, err = time.ParseDuration(hook.TimeoutString)
+			if err != nil {
+				return fmt.Errorf("Could not parse timeout of hook %s: %s", hook.Command, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// HookClient is the client an event is about.
+type HookClient struct {
+	Nick     string `json:"nick"`
+	Username string `json:"username"`
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	Account  string `json:"account,omitempty"`
+}
+
+// HookRequest is what we send (as a line of JSON) to hook processes about each event.
+// Only the fields that make sense for the event are set.
+type HookRequest struct {
+	ID      uint64     `json:"id"`
+	Event   string     `json:"event"`
+	Client  HookClient `json:"client"`
+	Command string     `json:"command,omitempty"`
+	Params  []string   `json:"params,omitempty"`
+	Target  string     `json:"target,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Channel string     `json:"channel,omitempty"`
+}
+
+// HookResponse is how hook processes answer a HookRequest, again as a line of JSON.
+type HookResponse struct {
+	ID     uint64 `json:"id"`
+	Deny   bool   `json:"deny"`
+	Reason string `json:"reason"`
+}
+
+// hookProcess is a running hook process, which gets restarted if it exits.
+type hookProcess struct {
+	sync.Mutex
+
+	config HookConfig
+	events map[string]bool
+	logger *logger.Manager
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	// lines are written to the process's stdin by writeRequests, so a process that
+	// stops reading can't hold up our clients
+	lines   chan []byte
+	nextID  uint64
+	waiting map[uint64]chan HookResponse
+	stopped bool
+}
+
+// newHookProcess starts the given hook.
+func newHookProcess(config HookConfig, logger *logger.Manager) *hookProcess {
+	hp := &hookProcess{
+		config:  config,
+		events:  make(map[string]bool),
+		logger:  logger,
+		waiting: make(map[uint64]chan HookResponse),
+	}
+	for _, event := range config.Events {
+		hp.events[event] = true
+	}
+	hp.start()
+	return hp
+}
+
+// start runs the process, and restarts it later if that doesn't work.
+func (hp *hookProcess) start() {
+	hp.Lock()
+	defer hp.Unlock()
+
+	if hp.stopped {
+		return
+	}
+
+	cmd := exec.Command(hp.config.Command, hp.config.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		stdout, err = cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+			if err == nil {
+				hp.cmd = cmd
+				hp.stdin = stdin
+				hp.lines = make(chan []byte, hookQueueLength)
+				go hp.writeRequests(stdin, hp.lines)
+				go hp.readResponses(cmd, stdout)
+				hp.logger.Info("hooks", fmt.Sprintf("Started hook %s", hp.config.Command))
+				return
+			}
+		}
+	}
+
+	hp.logger.Error("hooks", fmt.Sprintf("Could not start hook %s, trying again soon: %s", hp.config.Command, err.Error()))
+	time.AfterFunc(hookRestartDelay, hp.start)
+}
+
+// writeRequests writes the given lines to the process until it exits.
+func (hp *hookProcess) writeRequests(stdin io.Writer, lines chan []byte) {
+	for line := range lines {
+		_, err := stdin.Write(line)
+		if err != nil {
+			break
+		}
+	}
+	// make sure nobody gets stuck trying to queue lines
+	for range lines {
+	}
+}
+
+// readResponses passes the process's answers on to whoever's waiting for them, until it exits.
+func (hp *hookProcess) readResponses(cmd *exec.Cmd, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var response HookResponse
+		err := json.Unmarshal(scanner.Bytes(), &response)
+		if err != nil {
+			hp.logger.Warning("hooks", fmt.Sprintf("Hook %s sent a line we don't understand: %s", hp.config.Command, err.Error()))
+			continue
+		}
+		hp.Lock()
+		answer, exists := hp.waiting[response.ID]
+		delete(hp.waiting, response.ID)
+		hp.Unlock()
+		if exists {
+			answer <- response
+		}
+	}
+	cmd.Wait()
+
+	// anyone who's still waiting isn't getting an answer
+	hp.Lock()
+	for id, answer := range hp.waiting {
+		close(answer)
+		delete(hp.waiting, id)
+	}
+	close(hp.lines)
+	hp.cmd = nil
+	hp.stdin = nil
+	hp.lines = nil
+	stopped := hp.stopped
+	hp.Unlock()
+
+	if !stopped {
+		hp.logger.Error("hooks", fmt.Sprintf("Hook %s exited, restarting it soon", hp.config.Command))
+		time.AfterFunc(hookRestartDelay, hp.start)
+	}
+}
+
+// ask sends the request to the process and waits for its answer.
+func (hp *hookProcess) ask(request HookRequest) (HookResponse, error) {
+	hp.Lock()
+	if hp.lines == nil {
+		hp.Unlock()
+		return HookResponse{}, errHookNotRunning
+	}
+	hp.nextID++
+	request.ID = hp.nextID
+	answer := make(chan HookResponse, 1)
+	line, _ := json.Marshal(request)
+	var err error
+	select {
+	case hp.lines <- append(line, '\n'):
+		hp.waiting[request.ID] = answer
+	default:
+		err = errHookBusy
+	}
+	hp.Unlock()
+
+	if err == nil {
+		timer := time.NewTimer(hp.config.Timeout)
+		defer timer.Stop()
+		select {
+		case response, ok := <-answer:
+			if ok {
+				return response, nil
+			}
+			return HookResponse{}, errHookStopped
+		case <-timer.C:
+			err = errHookTimeout
+		}
+	}
+
+	hp.Lock()
+	delete(hp.waiting, request.ID)
+	hp.Unlock()
+	return HookResponse{}, err
+}
+
+// stop kills the process, and stops it from being restarted.
+func (hp *hookProcess) stop() {
+	hp.Lock()
+	defer hp.Unlock()
+
+	hp.stopped = true
+	if hp.cmd != nil {
+		hp.stdin.Close()
+		hp.cmd.Process.Kill()
+	}
+}
+
+// Hooks are the hook processes we're running.
+type Hooks struct {
+	sync.RWMutex
+
+	configs   []HookConfig
+	processes []*hookProcess
+	logger    *logger.Manager
+}
+
+// NewHooks returns a new Hooks, running the given hook processes.
+func NewHooks(configs []HookConfig, logger *logger.Manager) *Hooks {
+	hooks := &Hooks{
+		logger: logger,
+	}
+	hooks.SetConfig(configs)
+	return hooks
+}
+
+// SetConfig restarts our hook processes with the given config, if it's changed.
+func (hooks *Hooks) SetConfig(configs []HookConfig) {
+	hooks.Lock()
+	defer hooks.Unlock()
+
+	if reflect.DeepEqual(hooks.configs, configs) {
+		return
+	}
+	for _, process := range hooks.processes {
+		process.stop()
+	}
+	hooks.configs = configs
+	hooks.processes = nil
+	for _, config := range configs {
+		hooks.processes = append(hooks.processes, newHookProcess(config, hooks.logger))
+	}
+}
+
+// Stop stops all our hook processes.
+func (hooks *Hooks) Stop() {
+	hooks.SetConfig(nil)
+}
+
+// Has returns true if any of our hooks want to be told about the given event.
+func (hooks *Hooks) Has(event string) bool {
+	hooks.RLock()
+	defer hooks.RUnlock()
+
+	for _, process := range hooks.processes {
+		if process.events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// Check asks each hook that wants to know about the request's event whether it can go
+// ahead. If one says no, it returns false and the reason that hook gave.
+func (hooks *Hooks) Check(request HookRequest) (bool, string) {
+	hooks.RLock()
+	processes := hooks.processes
+	hooks.RUnlock()
+
+	for _, process := range processes {
+		if !process.events[request.Event] {
+			continue
+		}
+		response, err := process.ask(request)
+		if err != nil {
+			hooks.logger.Warning("hooks", fmt.Sprintf("Could not ask hook %s about %s event: %s", process.config.Command, request.Event, err.Error()))
+			if process.config.DenyOnFailure {
+				return false, ""
+			}
+			continue
+		}
+		if response.Deny {
+			return false, response.Reason
+		}
+	}
+	return true, ""
+}
+
+// checkHook asks our hooks whether the given event from the client can go ahead. It
+// returns true if it can, or false and the reason to give the client if it can't.
+func (server *Server) checkHook(client *Client, request HookRequest) (bool, string) {
+	if !server.hooks.Has(request.Event) {
+		return true, ""
+	}
+
+	request.Client = HookClient{
+		Nick:     client.nick,
+		Username: client.username,
+		Hostname: client.hostname,
+		IP:       IPString(client.socket.conn.RemoteAddr()),
+	}
+	if client.account != &NoAccount {
+		request.Client.Account = client.account.Name
+	}
+
+	allowed, reason := server.hooks.Check(request)
+	if !allowed && reason == "" {
+		reason = client.t("This has been blocked by the server")
+	}
+	return allowed, reason
+}
+
+// hookRedactedCommands are the commands whose params can hold passwords, so hooks aren't
+// sent their params.
+var hookRedactedCommands = map[string]bool{
+	"ACC":          true,
+	"AUTHENTICATE": true,
+	"CHANSERV":     true,
+	"CS":           true,
+	"HOSTSERV":     true,
+	"HS":           true,
+	"NICKSERV":     true,
+	"NS":           true,
+	"OPER":         true,
+	"PASS":         true,
+}
+
+// hookServices are the (casefolded) services that messages can be sent to, and which take
+// passwords in them.
+var hookServices = map[string]bool{
+	"chanserv": true,
+	"hostserv": true,
+	"nickserv": true,
+}
+
+// hookParams returns the params of the given command that hooks can be sent, without the
+// ones that might hold passwords.
+func hookParams(msg ircmsg.IrcMessage) []string {
+	if hookRedactedCommands[msg.Command] {
+		return nil
+	}
+	if (msg.Command == "PRIVMSG" || msg.Command == "NOTICE") && 0 < len(msg.Params) {
+		for _, target := range strings.Split(msg.Params[0], ",") {
+			casefoldedTarget, err := CasefoldName(target)
+			if err == nil && hookServices[casefoldedTarget] {
+				// the targets are fine, but the message isn't
+				return msg.Params[:1]
+			}
+		}
+	}
+	return msg.Params
+}
+
+// checkCommandHook checks whether the client can run the given command. Params that might
+// hold passwords aren't sent to the hooks, see hookParams.
+func (server *Server) checkCommandHook(client *Client, msg ircmsg.IrcMessage) bool {
+	allowed, reason := server.checkHook(client, HookRequest{
+		Event:   HookCommand,
+		Command: msg.Command,
+		Params:  hookParams(msg),
+	})
+	if !allowed {
+		client.Fail(msg.Command, "DENIED", reason)
+	}
+	return allowed
+}
+
+// checkMessageHook checks whether the client can send the given message, and every
+// command that passes messages on to their targets goes through it. Blocked NOTICEs
+// are dropped silently, since we shouldn't reply to them.
+func (server *Server) checkMessageHook(client *Client, command, target, message string) bool {
+	allowed, reason := server.checkHook(client, HookRequest{
+		Event:   HookMessage,
+		Command: command,
+		Target:  target,
+		Message: message,
+	})
+	if !allowed && command != "NOTICE" && command != "CNOTICE" {
+		client.Fail(command, "DENIED", reason, target)
+	}
+	return allowed
+}
+
+// checkRegisterHook disconnects the client if our hooks won't let them connect. It
+// returns true if the client can continue registering.
+func (server *Server) checkRegisterHook(client *Client) bool {
+	allowed, reason := server.checkHook(client, HookRequest{
+		Event: HookRegister,
+	})
+	if allowed {
+		return true
+	}
+
+	// lines that are still queued get dropped when we're destroyed, so send these last
+	failMsg := ircmsg.MakeMessage(nil, server.name, "FAIL", "*", "DENIED", reason)
+	failLine, _ := failMsg.Line()
+	errorMsg := ircmsg.MakeMessage(nil, "", "ERROR", reason)
+	errorLine, _ := errorMsg.Line()
+	client.QuitWithFinalData(failLine + errorLine)
+	client.destroy()
+	return false
+}
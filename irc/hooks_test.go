@@ -0,0 +1,140 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// testHookScript denies messages containing "evil", and never answers about ones
+// containing "slow".
+const testHookScript = `while read line; do
+	id=$(echo "$line" | sed 's/^{"id":\([0-9]*\).*/\1/')
+	case "$line" in
+		*evil*) echo "{\"id\":$id,\"deny\":true,\"reason\":\"no evil\"}";;
+		*slow*) ;;
+		*) echo "{\"id\":$id}";;
+	esac
+done`
+
+func TestHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't available to run the test hook")
+	}
+
+	logman, _ := logger.NewManager()
+	config := HookConfig{
+		Command: "sh",
+		Args:    []string{"-c", testHookScript},
+		Events:  []string{HookMessage},
+		Timeout: 200 * time.Millisecond,
+	}
+	hooks := NewHooks([]HookConfig{config}, logman)
+	defer hooks.Stop()
+
+	if !hooks.Has(HookMessage) || hooks.Has(HookJoin) {
+		t.Error("expected the hook to only be told about messages")
+	}
+
+	allowed, _ := hooks.Check(HookRequest{Event: HookMessage, Message: "hello"})
+	if !allowed {
+		t.Error("expected a normal message to be allowed")
+	}
+	allowed, reason := hooks.Check(HookRequest{Event: HookMessage, Message: "something evil"})
+	if allowed || reason != "no evil" {
+		t.Errorf("expected an evil message to be denied with the hook's reason, got %v %q", allowed, reason)
+	}
+	allowed, _ = hooks.Check(HookRequest{Event: HookJoin, Channel: "#evil"})
+	if !allowed {
+		t.Error("expected events the hook doesn't want to be allowed")
+	}
+
+	// hooks that don't answer in time let things through, unless they're set not to
+	allowed, _ = hooks.Check(HookRequest{Event: HookMessage, Message: "slow"})
+	if !allowed {
+		t.Error("expected a message to be allowed when the hook doesn't answer")
+	}
+	config.DenyOnFailure = true
+	hooks.SetConfig([]HookConfig{config})
+	allowed, _ = hooks.Check(HookRequest{Event: HookMessage, Message: "slow"})
+	if allowed {
+		t.Error("expected a message to be denied when the hook doesn't answer and deny-on-failure is set")
+	}
+}
+
+func TestMessageHookCoversCMessages(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't available to run the test hook")
+	}
+
+	server := newTestServer()
+	server.config = &Config{}
+	server.clients = NewClientLookupSet()
+	server.abuseReports = NewAbuseReporter(AbuseReportsConfig{}, server.logger)
+	server.hooks = NewHooks([]HookConfig{{
+		Command: "sh",
+		Args:    []string{"-c", testHookScript},
+		Events:  []string{HookMessage},
+		Timeout: time.Second,
+	}}, server.logger)
+	defer server.hooks.Stop()
+
+	dan := newTestClient(server, "dan")
+	alice := newTestClient(server, "alice")
+	server.clients.Add(alice, "alice")
+	dan.history = history.NewHistoryBuffer(0)
+	alice.history = history.NewHistoryBuffer(0)
+	channel := NewChannel(server, "#test", false)
+	server.channels.Add(channel)
+	channel.members.Add(dan)
+	channel.members[dan][Voice] = true
+	channel.members.Add(alice)
+
+	cmessageHandler(server, dan, ircmsg.MakeMessage(nil, "", "CPRIVMSG", "alice", "#test", "something evil"))
+	if lines := sentLines(alice); len(lines) != 0 {
+		t.Errorf("expected a CPRIVMSG the hook denies not to be passed on, got %q", lines)
+	}
+	if lines := sentLines(dan); len(lines) != 1 || !strings.Contains(lines[0], "FAIL CPRIVMSG DENIED") {
+		t.Errorf("expected the sender to be told their CPRIVMSG was denied, got %q", lines)
+	}
+
+	cmessageHandler(server, dan, ircmsg.MakeMessage(nil, "", "CNOTICE", "alice", "#test", "something evil"))
+	if lines := append(sentLines(alice), sentLines(dan)...); len(lines) != 0 {
+		t.Errorf("expected a CNOTICE the hook denies to be dropped silently, got %q", lines)
+	}
+
+	cmessageHandler(server, dan, ircmsg.MakeMessage(nil, "", "CPRIVMSG", "alice", "#test", "hello"))
+	if lines := sentLines(alice); len(lines) != 1 || !strings.HasSuffix(lines[0], "PRIVMSG alice :hello") {
+		t.Errorf("expected a CPRIVMSG the hook allows to be passed on, got %q", lines)
+	}
+}
+
+func TestHookParams(t *testing.T) {
+	tests := []struct {
+		msg    ircmsg.IrcMessage
+		params []string
+	}{
+		{ircmsg.MakeMessage(nil, "", "JOIN", "#test"), []string{"#test"}},
+		{ircmsg.MakeMessage(nil, "", "PASS", "secret"), nil},
+		{ircmsg.MakeMessage(nil, "", "OPER", "dan", "secret"), nil},
+		{ircmsg.MakeMessage(nil, "", "NS", "IDENTIFY", "secret"), nil},
+		{ircmsg.MakeMessage(nil, "", "PRIVMSG", "alice", "hi"), []string{"alice", "hi"}},
+		{ircmsg.MakeMessage(nil, "", "PRIVMSG", "alice,NickServ", "REGISTER secret"), []string{"alice,NickServ"}},
+		{ircmsg.MakeMessage(nil, "", "NOTICE", "chanserv", "secret"), []string{"chanserv"}},
+	}
+	for _, test := range tests {
+		params := hookParams(test.msg)
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("expected hooks to be sent %v for %s, got %v", test.params, test.msg.Command, params)
+		}
+	}
+}
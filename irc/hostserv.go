@@ -0,0 +1,206 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/tidwall/buntdb"
+)
+
+var (
+	errAccountDoesNotExist = errors.New("Account does not exist")
+)
+
+// hsHandler handles the /HS and /HOSTSERV commands
+func hsHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	server.hostservReceivePrivmsg(client, strings.Join(msg.Params, " "))
+	return false
+}
+
+func (server *Server) hostservReceiveNotice(client *Client, message string) {
+	// do nothing
+}
+
+// HostServNotice sends the client a notice from HostServ.
+func (client *Client) HostServNotice(text string) {
+	client.Send(nil, fmt.Sprintf("HostServ!services@%s", client.server.name), "NOTICE", client.nick, text)
+}
+
+func (server *Server) hostservReceivePrivmsg(client *Client, message string) {
+	params := strings.Fields(message)
+	if len(params) < 1 {
+		client.HostServNotice(client.t("You need to run a command"))
+		return
+	}
+
+	command := strings.ToLower(params[0])
+	server.logger.LogFields(logger.LogDebug, "hostserv", client.logFields, fmt.Sprintf("Client %s ran command %s", client.nick, command))
+
+	switch command {
+	case "help":
+		client.serviceHelp("hostserv", params, client.HostServNotice)
+	case "on", "off":
+		account := client.account
+		if account == &NoAccount {
+			client.HostServNotice(client.t("You must be logged into an account to use your vhost"))
+			return
+		}
+		if account.VHost == "" {
+			client.HostServNotice(client.t("Your account doesn't have a vhost"))
+			return
+		}
+		enabled := command == "on"
+		err := server.saveAccountSetting(account, keyAccountVHostOff, !enabled)
+		if err != nil {
+			client.HostServNotice(client.t("Could not save your settings"))
+			server.logger.Error("hostserv", fmt.Sprintf("Could not save vhost setting for account %s: %s", account.Name, err.Error()))
+			return
+		}
+		account.VHostEnabled = enabled
+		for _, accountClient := range server.accountClients(account) {
+			accountClient.updateVHost()
+		}
+		if enabled {
+			client.HostServNotice(client.t("Your vhost is now enabled"))
+		} else {
+			client.HostServNotice(client.t("Your vhost is now disabled"))
+		}
+	case "set", "del":
+		if !client.HasCapabs("oper:vhosts") {
+			client.HostServNotice(client.t("Permission Denied"))
+			return
+		}
+		var vhost string
+		if command == "set" {
+			if len(params) < 3 {
+				client.HostServNotice(client.t("Syntax: SET <account> <vhost>"))
+				return
+			}
+			vhost = params[2]
+			if !IsHostname(vhost) {
+				client.HostServNotice(client.t("That vhost is not valid"))
+				return
+			}
+		} else if len(params) < 2 {
+			client.HostServNotice(client.t("Syntax: DEL <account>"))
+			return
+		}
+
+		accountName, err := server.setAccountVHost(params[1], vhost)
+		if err == errAccountDoesNotExist {
+			client.HostServNotice(client.t("Account does not exist"))
+			return
+		} else if err != nil {
+			client.HostServNotice(client.t("Could not save the vhost"))
+			server.logger.Error("hostserv", fmt.Sprintf("Could not save vhost for account %s: %s", params[1], err.Error()))
+			return
+		}
+
+		if vhost == "" {
+			client.HostServNotice(fmt.Sprintf(client.t("Removed the vhost of account %s"), accountName))
+			server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Oper $c[grey][$r%s$c[grey]] removed the vhost of account $c[grey][$r%s$c[grey]]"), client.nick, accountName))
+		} else {
+			client.HostServNotice(fmt.Sprintf(client.t("Set the vhost of account %[1]s to %[2]s"), accountName, vhost))
+			server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Oper $c[grey][$r%s$c[grey]] set the vhost of account $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), client.nick, accountName, vhost))
+		}
+	default:
+		client.HostServNotice(client.t("Unknown command. Commands are ON, OFF, HELP, and for opers, SET and DEL"))
+	}
+}
+
+// setAccountVHost sets the vhost of the given account, or removes it if vhost is empty,
+// and updates the clients logged into it. It returns the account's name.
+func (server *Server) setAccountVHost(name string, vhost string) (string, error) {
+	accountKey, err := CasefoldName(name)
+	if err != nil {
+		return "", errAccountDoesNotExist
+	}
+
+	var accountName string
+	err = server.store.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(fmt.Sprintf(keyAccountExists, accountKey))
+		if err != nil {
+			return errAccountDoesNotExist
+		}
+		accountName, _ = tx.Get(fmt.Sprintf(keyAccountName, accountKey))
+
+		key := fmt.Sprintf(keyAccountVHost, accountKey)
+		if vhost == "" {
+			tx.Delete(key)
+			return nil
+		}
+		_, _, err = tx.Set(key, vhost, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// update clients that are logged in
+	account, exists := server.loadedAccount(accountKey)
+	if exists {
+		account.VHost = vhost
+		for _, client := range server.accountClients(account) {
+			client.updateVHost()
+		}
+	}
+	return accountName, nil
+}
+
+// accountVHost returns the vhost the client should have, from their account or oper block.
+func (client *Client) accountVHost() string {
+	account := client.account
+	if account.VHost != "" && account.VHostEnabled {
+		return account.VHost
+	}
+	if client.HasMode(Operator) {
+		oper, exists := client.server.Oper(client.operName)
+		if exists {
+			return oper.Vhost
+		}
+	}
+	return ""
+}
+
+// updateVHost gives the client the vhost they should have from their account or oper
+// block. Their nickmask is only changed on their own goroutine, so this can be used for
+// clients other than the one running the current command.
+func (client *Client) updateVHost() {
+	client.runOnClient(func() {
+		client.setVHost(client.accountVHost())
+	})
+}
+
+// setVHost changes the client's vhost, or removes it if vhost is empty, and tells the
+// client and any friends who've asked for chghost.
+func (client *Client) setVHost(vhost string) {
+	if client.vhost == vhost {
+		return
+	}
+
+	// the nickmask is set up when the client registers
+	if !client.registered {
+		client.vhost = vhost
+		return
+	}
+
+	newHost := vhost
+	if newHost == "" {
+		newHost = client.rawHostname
+	}
+	// CHGHOST requires prefix nickmask to have original hostname, so do that before updating nickmask
+	for fClient := range client.Friends(ChgHost) {
+		fClient.SendFromClient("", client, nil, "CHGHOST", client.username, newHost)
+	}
+	client.vhost = vhost
+	client.updateNickMask()
+	client.Send(nil, client.server.name, RPL_HOSTHIDDEN, client.nick, client.hostname, client.t("is now your displayed host"))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxPendingInvites is how many unused invites a client can have. Past this, the
+// invites closest to expiring are dropped to make room for new ones.
+const maxPendingInvites = 64
+
+// PendingInvite is an invite to a channel that a client hasn't used yet.
+type PendingInvite struct {
+	Channel string
+	Inviter string
+	Expires time.Time
+}
+
+// InviteList holds the invites a client has been given to invite-only channels. Each
+// invite lets the client join the channel once, and only for a little while.
+type InviteList struct {
+	sync.Mutex
+
+	// invites maps casefolded channel name -> invite
+	invites map[string]PendingInvite
+}
+
+// NewInviteList returns a new InviteList.
+func NewInviteList() *InviteList {
+	return &InviteList{
+		invites: make(map[string]PendingInvite),
+	}
+}
+
+// Add records an invite to the given channel, replacing any older invite to it.
+func (il *InviteList) Add(casefoldedChannel, channelName, inviter string, duration time.Duration) {
+	il.Lock()
+	defer il.Unlock()
+
+	il.expireNoMutex(time.Now())
+	_, exists := il.invites[casefoldedChannel]
+	for !exists && maxPendingInvites <= len(il.invites) {
+		il.dropOldestNoMutex()
+	}
+	il.invites[casefoldedChannel] = PendingInvite{
+		Channel: channelName,
+		Inviter: inviter,
+		Expires: time.Now().Add(duration),
+	}
+}
+
+// Has returns true if there's an invite to the given casefolded channel that hasn't expired.
+func (il *InviteList) Has(casefoldedChannel string) bool {
+	il.Lock()
+	defer il.Unlock()
+
+	invite, exists := il.invites[casefoldedChannel]
+	if exists && time.Now().After(invite.Expires) {
+		delete(il.invites, casefoldedChannel)
+		return false
+	}
+	return exists
+}
+
+// Remove removes the invite to the given casefolded channel, such as when it's been used.
+func (il *InviteList) Remove(casefoldedChannel string) {
+	il.Lock()
+	defer il.Unlock()
+
+	delete(il.invites, casefoldedChannel)
+}
+
+// List returns the invites that haven't expired, sorted by channel name.
+func (il *InviteList) List() []PendingInvite {
+	il.Lock()
+	defer il.Unlock()
+
+	il.expireNoMutex(time.Now())
+	var invites []PendingInvite
+	for _, invite := range il.invites {
+		invites = append(invites, invite)
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].Channel < invites[j].Channel
+	})
+	return invites
+}
+
+// expireNoMutex removes the invites that have expired by the given time.
+func (il *InviteList) expireNoMutex(now time.Time) {
+	for casefoldedChannel, invite := range il.invites {
+		if now.After(invite.Expires) {
+			delete(il.invites, casefoldedChannel)
+		}
+	}
+}
+
+// dropOldestNoMutex removes the invite that's closest to expiring.
+func (il *InviteList) dropOldestNoMutex() {
+	var oldest string
+	var oldestExpires time.Time
+	for casefoldedChannel, invite := range il.invites {
+		if oldest == "" || invite.Expires.Before(oldestExpires) {
+			oldest = casefoldedChannel
+			oldestExpires = invite.Expires
+		}
+	}
+	delete(il.invites, oldest)
+}
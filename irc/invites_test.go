@@ -0,0 +1,63 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInviteList(t *testing.T) {
+	invites := NewInviteList()
+
+	invites.Add("#test", "#Test", "dan", time.Minute)
+	invites.Add("#other", "#Other", "dan", time.Minute)
+	if !invites.Has("#test") {
+		t.Error("expected an invite to be found")
+	}
+	if invites.Has("#nope") {
+		t.Error("expected channels we weren't invited to to not be found")
+	}
+
+	list := invites.List()
+	if len(list) != 2 || list[0].Channel != "#Other" || list[1].Channel != "#Test" {
+		t.Errorf("expected both invites sorted by channel, got %v", list)
+	}
+
+	invites.Remove("#test")
+	if invites.Has("#test") {
+		t.Error("expected a removed invite to be gone")
+	}
+
+	invites.Add("#old", "#old", "dan", -time.Second)
+	if invites.Has("#old") {
+		t.Error("expected an expired invite to not be found")
+	}
+	if len(invites.List()) != 1 {
+		t.Error("expected expired invites to not be listed")
+	}
+}
+
+func TestInviteListExpiresOnAdd(t *testing.T) {
+	invites := NewInviteList()
+
+	// expired invites are cleaned up even if the list's never read
+	invites.Add("#old", "#old", "dan", -time.Second)
+	invites.Add("#new", "#new", "dan", time.Minute)
+	if len(invites.invites) != 1 {
+		t.Errorf("expected the expired invite to be removed, got %v", invites.invites)
+	}
+
+	// and the list can't grow without limit
+	for i := 0; i < maxPendingInvites*2; i++ {
+		invites.Add(fmt.Sprintf("#chan%d", i), fmt.Sprintf("#chan%d", i), "dan", time.Hour+time.Duration(i)*time.Second)
+	}
+	if len(invites.invites) != maxPendingInvites {
+		t.Errorf("expected %d invites, got %d", maxPendingInvites, len(invites.invites))
+	}
+	if !invites.Has(fmt.Sprintf("#chan%d", maxPendingInvites*2-1)) || invites.Has("#new") {
+		t.Error("expected the invites closest to expiring to be dropped")
+	}
+}
@@ -43,4 +43,60 @@ func TestISUPPORT(t *testing.T) {
 	if !reflect.DeepEqual(actual, expected) {
 		t.Error("difference reply does not match expected difference reply")
 	}
+
+	// lists with the same tokens shouldn't produce any difference
+	tList3 := NewISupportList()
+	tList3.Add("SASL", "yes")
+	tList3.Add("CASEMAPPING", "ascii")
+	tList3.AddNoValue("INVEX")
+	tList3.Add("EXTBAN", "TestBah")
+	tList3.AddNoValue("STABLEKILL")
+
+	actual = tList2.GetDifference(tList3)
+	if len(actual) != 0 {
+		t.Errorf("identical lists should have no difference, got %v", actual)
+	}
+}
+
+func TestISupportOverrides(t *testing.T) {
+	topicLen := "390"
+	safelist := "yes"
+	var config Config
+	config.Network.Name = "OldNet"
+	config.Limits.TopicLen = 1000
+	config.Server.RawISupport = map[string]*string{
+		"topiclen": &topicLen,
+		"SAFELIST": &safelist,
+		"WHOX":     nil,
+	}
+
+	err := config.applyISupportOverrides()
+	if err != nil {
+		t.Fatalf("could not apply overrides: %s", err.Error())
+	}
+	if config.Limits.TopicLen != 390 {
+		t.Errorf("expected TOPICLEN to change the topic length limit, got %d", config.Limits.TopicLen)
+	}
+	expected := map[string]*string{
+		"SAFELIST": &safelist,
+		"WHOX":     nil,
+	}
+	if !reflect.DeepEqual(config.Server.ISupport, expected) {
+		t.Errorf("expected custom tokens %v, got %v", expected, config.Server.ISupport)
+	}
+
+	badValues := map[string]string{
+		"PREFIX":    "(ov)@+",
+		"NICKLEN":   "0",
+		"CHANLIMIT": "50",
+		"BAD TOKEN": "yes",
+		"CUSTOM":    "has spaces",
+	}
+	for name, value := range badValues {
+		value := value
+		config.Server.RawISupport = map[string]*string{name: &value}
+		if config.applyISupportOverrides() == nil {
+			t.Errorf("expected error for token %s=%s", name, value)
+		}
+	}
 }
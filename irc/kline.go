@@ -113,11 +113,11 @@ func (km *KLineManager) CheckMasks(masks ...string) (isBanned bool, info *IPBanI
 	return false, nil
 }
 
-// KLINE [ANDKILL] [MYSELF] [duration] <mask> [ON <server>] [reason [| oper reason]]
+// KLINE [ANDKILL] [MYSELF] [REPORT] [duration] <mask> [ON <server>] [reason [| oper reason]]
 func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// check oper permissions
 	if !client.class.Capabilities["oper:local_ban"] {
-		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, "Insufficient oper privs")
+		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, client.t("Insufficient oper privs"))
 		return false
 	}
 
@@ -138,6 +138,17 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		currentArg++
 	}
 
+	// "REPORT" also reports the IPs the ban covers to our abuse blacklist, such as DroneBL
+	var report bool
+	if len(msg.Params) > currentArg+1 && strings.ToLower(msg.Params[currentArg]) == "report" {
+		if !server.abuseReports.Enabled() {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, "Abuse reports are not enabled")
+			return false
+		}
+		report = true
+		currentArg++
+	}
+
 	// duration
 	duration, err := custime.ParseDuration(msg.Params[currentArg])
 	durationIsUsed := err == nil
@@ -147,19 +158,12 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	// get mask
 	if len(msg.Params) < currentArg+1 {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
 		return false
 	}
-	mask := strings.ToLower(msg.Params[currentArg])
+	mask := normalizeKLineMask(strings.ToLower(msg.Params[currentArg]))
 	currentArg++
 
-	// check mask
-	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
-		mask = mask + "!*@*"
-	} else if !strings.Contains(mask, "@") {
-		mask = mask + "@*"
-	}
-
 	matcher := ircmatch.MakeMatch(mask)
 
 	for _, clientMask := range client.AllNickmasks() {
@@ -208,28 +212,12 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		Time:       banTime,
 	}
 
-	// save in datastore
-	err = server.store.Update(func(tx *buntdb.Tx) error {
-		klineKey := fmt.Sprintf(keyKlineEntry, mask)
-
-		// assemble json from ban info
-		b, err := json.Marshal(info)
-		if err != nil {
-			return err
-		}
-
-		tx.Set(klineKey, string(b), nil)
-
-		return nil
-	})
-
+	err = server.addKLine(mask, info)
 	if err != nil {
 		client.Notice(fmt.Sprintf("Could not successfully save new K-LINE: %s", err.Error()))
 		return false
 	}
 
-	server.klines.AddMask(mask, banTime, reason, operReason)
-
 	var snoDescription string
 	if durationIsUsed {
 		client.Notice(fmt.Sprintf("Added temporary (%s) K-Line for %s", duration.String(), mask))
@@ -240,6 +228,17 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	}
 	server.snomasks.Send(sno.LocalXline, snoDescription)
 
+	if report {
+		server.reportBanned(client, "KLINE", reason, nil, func(mcl *Client) bool {
+			for _, clientMask := range mcl.AllNickmasks() {
+				if matcher.Match(clientMask) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
 	var killClient bool
 	if andKill {
 		var clientsToKill []*Client
@@ -278,20 +277,60 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 func unKLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// check oper permissions
 	if !client.class.Capabilities["oper:local_unban"] {
-		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, "Insufficient oper privs")
+		client.Send(nil, server.name, ERR_NOPRIVS, client.nick, msg.Command, client.t("Insufficient oper privs"))
 		return false
 	}
 
 	// get host
-	mask := msg.Params[0]
+	mask := normalizeKLineMask(msg.Params[0])
 
+	err := server.removeKLine(mask)
+	if err != nil {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, fmt.Sprintf("Could not remove ban [%s]", err.Error()))
+		return false
+	}
+
+	client.Notice(fmt.Sprintf("Removed K-Line for %s", mask))
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed K-Line for %s"), client.nick, mask))
+	return false
+}
+
+// normalizeKLineMask fills in the missing parts of the given K-Line mask, so that "nick"
+// becomes "nick!*@*" and "nick!user" becomes "nick!user@*".
+func normalizeKLineMask(mask string) string {
 	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
-		mask = mask + "!*@*"
+		return mask + "!*@*"
 	} else if !strings.Contains(mask, "@") {
-		mask = mask + "@*"
+		return mask + "@*"
 	}
+	return mask
+}
 
-	// save in datastore
+// addKLine saves the given K-Line and starts enforcing it.
+func (server *Server) addKLine(mask string, info IPBanInfo) error {
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		klineKey := fmt.Sprintf(keyKlineEntry, mask)
+
+		// assemble json from ban info
+		b, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+
+		tx.Set(klineKey, string(b), nil)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	server.klines.AddMask(mask, info.Time, info.Reason, info.OperReason)
+	return nil
+}
+
+// removeKLine stops enforcing the given K-Line and removes it from the datastore.
+func (server *Server) removeKLine(mask string) error {
 	err := server.store.Update(func(tx *buntdb.Tx) error {
 		klineKey := fmt.Sprintf(keyKlineEntry, mask)
 
@@ -306,17 +345,12 @@ func unKLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 		tx.Delete(klineKey)
 		return nil
 	})
-
 	if err != nil {
-		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, fmt.Sprintf("Could not remove ban [%s]", err.Error()))
-		return false
+		return err
 	}
 
 	server.klines.RemoveMask(mask)
-
-	client.Notice(fmt.Sprintf("Removed K-Line for %s", mask))
-	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed K-Line for %s"), client.nick, mask))
-	return false
+	return nil
 }
 
 func (s *Server) loadKLines() {
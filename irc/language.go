@@ -0,0 +1,153 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/languages"
+	"github.com/tidwall/buntdb"
+)
+
+// Languages returns the languages we currently support.
+func (server *Server) Languages() *languages.Manager {
+	server.languagesMutex.RLock()
+	defer server.languagesMutex.RUnlock()
+	return server.languages
+}
+
+// Languages returns the languages this client prefers, in order.
+func (client *Client) Languages() []string {
+	client.languagesMutex.RLock()
+	defer client.languagesMutex.RUnlock()
+	return client.languages
+}
+
+// SetLanguages sets the languages this client prefers.
+func (client *Client) SetLanguages(codes []string) {
+	client.languagesMutex.Lock()
+	defer client.languagesMutex.Unlock()
+	client.languages = codes
+}
+
+// t returns the given string translated into the client's preferred language.
+func (client *Client) t(original string) string {
+	return client.server.Languages().Translate(client.Languages(), original)
+}
+
+var (
+	errTooManyLanguages = errors.New("You specified too many languages")
+	errUnknownLanguage  = errors.New("That language is not supported by this server")
+)
+
+// parseLanguages checks the given language codes and returns the ones to use, in order.
+// An empty list means the default language. If a code isn't supported, it's returned too.
+func parseLanguages(langManager *languages.Manager, params []string) (codes []string, badCode string, err error) {
+	if langManager.Count() < len(params) {
+		return nil, "", errTooManyLanguages
+	}
+
+	alreadyAdded := make(map[string]bool)
+	for _, code := range params {
+		// clients may copy the incomplete marker from ISUPPORT
+		code = strings.TrimPrefix(strings.ToLower(code), "~")
+		if code == "" {
+			continue
+		}
+		if !langManager.Exists(code) {
+			return nil, code, errUnknownLanguage
+		}
+		if alreadyAdded[code] {
+			continue
+		}
+		alreadyAdded[code] = true
+		codes = append(codes, code)
+	}
+
+	// plain English is what clients get when they don't pick a language
+	if len(codes) == 1 && codes[0] == langManager.Default() {
+		codes = nil
+	}
+	return codes, "", nil
+}
+
+// setLanguages sets the client's preferred languages, and saves them to their account
+// (if they're logged in) for the next time they log in.
+func (server *Server) setLanguages(client *Client, codes []string) {
+	client.SetLanguages(codes)
+	if client.account == &NoAccount {
+		return
+	}
+
+	client.account.Languages = codes
+	accountKey, err := CasefoldName(client.account.Name)
+	if err == nil {
+		err = server.store.Update(func(tx *buntdb.Tx) error {
+			key := fmt.Sprintf(keyAccountLanguages, accountKey)
+			if len(codes) == 0 {
+				tx.Delete(key)
+				return nil
+			}
+			_, _, err := tx.Set(key, strings.Join(codes, " "), nil)
+			return err
+		})
+	}
+	if err != nil {
+		server.logger.Error("internal", fmt.Sprintf("Could not save languages for account %s: %s", client.account.Name, err.Error()))
+	}
+}
+
+// LANGUAGE <code>{ <code>}
+func languageHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	langManager := server.Languages()
+
+	codes, badCode, err := parseLanguages(langManager, msg.Params)
+	if err == errTooManyLanguages {
+		client.Send(nil, server.name, ERR_TOOMANYLANGUAGES, client.nick, strconv.Itoa(langManager.Count()), fmt.Sprintf(client.t("You can only pick up to %d languages"), langManager.Count()))
+		return false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_NOLANGUAGE, client.nick, badCode, fmt.Sprintf(client.t("Language %s is not supported by this server"), badCode))
+		return false
+	}
+	server.setLanguages(client, codes)
+
+	params := []string{client.nick}
+	if len(codes) == 0 {
+		params = append(params, langManager.Default())
+	} else {
+		params = append(params, codes...)
+	}
+	params = append(params, client.t("Language preferences have been set"))
+	client.Send(nil, server.name, RPL_YOURLANGUAGESARE, params...)
+
+	return false
+}
+
+// nickservSetLanguage handles NS SET LANGUAGE, which sets the languages the client's
+// account prefers, like the LANGUAGE command does.
+func (server *Server) nickservSetLanguage(client *Client, params []string) {
+	langManager := server.Languages()
+	codes, badCode, err := parseLanguages(langManager, params)
+	if err == errUnknownLanguage {
+		client.NickServNotice(fmt.Sprintf(client.t("Language %[1]s is not supported, the languages we have are: %[2]s"), badCode, strings.Join(langManager.Codes(), " ")))
+		return
+	} else if err != nil {
+		client.NickServNotice(fmt.Sprintf(client.t("You can only pick up to %d languages"), langManager.Count()))
+		return
+	}
+	server.setLanguages(client, codes)
+
+	if len(codes) == 0 {
+		codes = []string{langManager.Default()}
+	}
+	var names []string
+	for _, code := range codes {
+		names = append(names, langManager.Info[code].Name)
+	}
+	client.NickServNotice(fmt.Sprintf(client.t("Your languages are now: %s"), strings.Join(names, ", ")))
+}
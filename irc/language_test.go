@@ -0,0 +1,37 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/oragono/oragono/irc/languages"
+)
+
+func TestParseLanguages(t *testing.T) {
+	lm := languages.NewManager("en", map[string]*languages.LangData{
+		"es":    {Code: "es"},
+		"pt-br": {Code: "pt-br"},
+	})
+
+	var tests = []struct {
+		params  []string
+		codes   []string
+		badCode string
+		err     error
+	}{
+		{[]string{"es"}, []string{"es"}, "", nil},
+		{[]string{"PT-BR", "~es", "es"}, []string{"pt-br", "es"}, "", nil},
+		{[]string{"en"}, nil, "", nil},
+		{[]string{"es", "de"}, nil, "de", errUnknownLanguage},
+		{[]string{"es", "en", "pt-br", "es"}, nil, "", errTooManyLanguages},
+	}
+	for _, test := range tests {
+		codes, badCode, err := parseLanguages(lm, test.params)
+		if !reflect.DeepEqual(codes, test.codes) || badCode != test.badCode || err != test.err {
+			t.Errorf("expected %v to give %v, %q and %v, got %v, %q and %v", test.params, test.codes, test.badCode, test.err, codes, badCode, err)
+		}
+	}
+}
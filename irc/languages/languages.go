@@ -0,0 +1,184 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+// Package languages handles translating the strings we send to clients.
+package languages
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultCode is the language our strings are written in.
+const DefaultCode = "en"
+
+// LangData is the info about a single language, and its translations.
+type LangData struct {
+	Name         string
+	Code         string
+	Contributors string
+	// Incomplete is true if some of our strings aren't translated yet.
+	Incomplete   bool
+	Translations map[string]string
+	// Help holds translations of our help entries, by the name of the entry (such as
+	// "join" or "nickserv identify").
+	Help map[string]string
+}
+
+// Manager holds the languages we've loaded. It doesn't change once created,
+// rehashing makes a new one.
+type Manager struct {
+	Info        map[string]*LangData
+	defaultLang string
+}
+
+// NewManager returns a Manager for the given languages. Clients that haven't chosen a
+// language get the given default, which should be one of the given languages or DefaultCode.
+func NewManager(defaultLang string, languages map[string]*LangData) *Manager {
+	lm := Manager{
+		Info:        make(map[string]*LangData),
+		defaultLang: strings.ToLower(defaultLang),
+	}
+	if lm.defaultLang == "" {
+		lm.defaultLang = DefaultCode
+	}
+
+	// our strings are already in English
+	lm.Info[DefaultCode] = &LangData{
+		Name:         "English",
+		Code:         DefaultCode,
+		Contributors: "Oragono contributors and the IRC community",
+	}
+
+	for code, data := range languages {
+		lm.Info[strings.ToLower(code)] = data
+	}
+
+	if _, exists := lm.Info[lm.defaultLang]; !exists {
+		lm.defaultLang = DefaultCode
+	}
+
+	return &lm
+}
+
+// LoadLanguages loads the *.lang.yaml files in the given directory.
+func LoadLanguages(path string) (map[string]*LangData, error) {
+	filenames, err := filepath.Glob(filepath.Join(path, "*.lang.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	languages := make(map[string]*LangData)
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read language file %s: %s", filename, err.Error())
+		}
+		var lang LangData
+		err = yaml.Unmarshal(data, &lang)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse language file %s: %s", filename, err.Error())
+		}
+
+		lang.Code = strings.ToLower(strings.TrimSpace(lang.Code))
+		if lang.Code == "" || lang.Name == "" {
+			return nil, fmt.Errorf("Language file %s needs both a name and a code", filename)
+		}
+		if strings.ContainsAny(lang.Code, " ,~") {
+			return nil, fmt.Errorf("Language file %s has an invalid code [%s]", filename, lang.Code)
+		}
+		if lang.Code == DefaultCode {
+			return nil, fmt.Errorf("Language file %s can't replace our built-in language [%s]", filename, DefaultCode)
+		}
+		if _, exists := languages[lang.Code]; exists {
+			return nil, fmt.Errorf("Language [%s] is defined by more than one file", lang.Code)
+		}
+		languages[lang.Code] = &lang
+	}
+
+	return languages, nil
+}
+
+// Default returns the language used for clients that haven't chosen one.
+func (lm *Manager) Default() string {
+	return lm.defaultLang
+}
+
+// Count returns how many languages we have.
+func (lm *Manager) Count() int {
+	return len(lm.Info)
+}
+
+// Codes returns the codes of all our languages, with incomplete languages prefixed by '~'.
+func (lm *Manager) Codes() []string {
+	var codes []string
+	for code, info := range lm.Info {
+		if info.Incomplete {
+			codes = append(codes, "~"+code)
+		} else {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Exists returns true if we have the given language.
+func (lm *Manager) Exists(code string) bool {
+	_, exists := lm.Info[strings.ToLower(code)]
+	return exists
+}
+
+// Filter returns the given language codes that we have, in the same order.
+func (lm *Manager) Filter(codes []string) []string {
+	var filtered []string
+	for _, code := range codes {
+		if lm.Exists(code) {
+			filtered = append(filtered, strings.ToLower(code))
+		}
+	}
+	return filtered
+}
+
+// Translate returns the given string in the first of the given languages that has
+// a translation for it. If none of them do, it returns the original string.
+func (lm *Manager) Translate(languages []string, original string) string {
+	return lm.translate(languages, original, func(info *LangData) string {
+		return info.Translations[original]
+	})
+}
+
+// TranslateHelp returns the text of the given help entry in the first of the given
+// languages that has a translation for it. If none of them do, it returns the original.
+func (lm *Manager) TranslateHelp(languages []string, name string, original string) string {
+	return lm.translate(languages, original, func(info *LangData) string {
+		return info.Help[name]
+	})
+}
+
+// translate returns the first translation that lookup finds in the given languages, or
+// the original if there isn't one.
+func (lm *Manager) translate(languages []string, original string, lookup func(info *LangData) string) string {
+	if len(languages) == 0 {
+		languages = []string{lm.defaultLang}
+	}
+	for _, code := range languages {
+		if code == DefaultCode {
+			return original
+		}
+		info, exists := lm.Info[code]
+		if !exists {
+			continue
+		}
+		translation := lookup(info)
+		if translation != "" {
+			return translation
+		}
+	}
+	return original
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"errors"
+)
+
+var (
+	errEventlogUnsupported = errors.New("Logging to the event log is only supported on Windows")
+)
+
+// newEventlogWriter opens the event log under the given source name.
+func newEventlogWriter(source string) (systemWriter, error) {
+	return nil, errEventlogUnsupported
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package logger
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+const (
+	// eventID is the event ID we use for all of our log lines. We don't ship a message
+	// file, so Event Viewer shows the line itself.
+	eventID = 1
+)
+
+// eventlogWriter sends log lines to the Windows event log.
+type eventlogWriter struct {
+	log *eventlog.Log
+}
+
+// newEventlogWriter opens the event log under the given source name. The source should
+// have been registered when installing the service.
+func newEventlogWriter(source string) (systemWriter, error) {
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventlogWriter{
+		log: log,
+	}, nil
+}
+
+// Write sends the given line to the event log, with the event type matching our log level.
+func (ew *eventlogWriter) Write(level Level, logType string, fields Fields, line string) error {
+	switch level {
+	case LogDebug, LogInfo:
+		return ew.log.Info(eventID, line)
+	case LogWarning:
+		return ew.log.Warning(eventID, line)
+	default:
+		return ew.log.Error(eventID, line)
+	}
+}
@@ -5,6 +5,7 @@ package logger
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -51,14 +52,37 @@ var (
 	}
 )
 
+// Fields are extra structured details about a log line, such as the client or oper it's
+// about. They're only output by JSON loggers, as text lines already mention them.
+type Fields map[string]string
+
 // Manager is the main interface used to log debug/info/error messages.
 type Manager struct {
 	loggers         []singleLogger
 	stdoutWriteLock sync.Mutex // use one lock for both stdout and stderr
 	fileWriteLock   sync.Mutex
+	levelOverrides  levelOverrides
 	DumpingRawInOut bool
 }
 
+// levelOverrides are log levels that have been changed at runtime, keyed by log type.
+type levelOverrides struct {
+	sync.RWMutex
+	levels map[string]Level
+}
+
+// get returns the overridden level for the given log type, if there is one. An override
+// for a specific type takes precedence over one for "*".
+func (lo *levelOverrides) get(logType string) (level Level, exists bool) {
+	lo.RLock()
+	defer lo.RUnlock()
+	level, exists = lo.levels[logType]
+	if !exists {
+		level, exists = lo.levels["*"]
+	}
+	return
+}
+
 // Config represents the configuration of a single logger.
 type Config struct {
 	// logging methods
@@ -66,6 +90,15 @@ type Config struct {
 	MethodStderr bool
 	MethodFile   bool
 	Filename     string
+	// syslog and journald
+	MethodSyslog   bool
+	SyslogNetwork  string
+	SyslogAddress  string
+	MethodJournald bool
+	MethodEventlog bool
+	SystemTag      string
+	// whether to output one JSON object per line rather than text
+	JSON bool
 	// logging level
 	Level Level
 	// logging types
@@ -94,11 +127,13 @@ func NewManager(config ...Config) (*Manager, error) {
 				Enabled:  logConfig.MethodFile,
 				Filename: logConfig.Filename,
 			},
+			JSON:            logConfig.JSON,
 			Level:           logConfig.Level,
 			Types:           typeMap,
 			ExcludedTypes:   excludedTypeMap,
 			stdoutWriteLock: &logger.stdoutWriteLock,
 			fileWriteLock:   &logger.fileWriteLock,
+			levelOverrides:  &logger.levelOverrides,
 		}
 		if typeMap["userinput"] || typeMap["useroutput"] || (typeMap["*"] && !(excludedTypeMap["userinput"] && excludedTypeMap["useroutput"])) {
 			logger.DumpingRawInOut = true
@@ -112,44 +147,109 @@ func NewManager(config ...Config) (*Manager, error) {
 			sLogger.MethodFile.File = file
 			sLogger.MethodFile.Writer = writer
 		}
+		if logConfig.MethodSyslog {
+			writer, err := newSyslogWriter(logConfig.SyslogNetwork, logConfig.SyslogAddress, logConfig.SystemTag)
+			if err != nil {
+				return nil, fmt.Errorf("Could not connect to syslog [%s]", err.Error())
+			}
+			sLogger.MethodSyslog = writer
+		}
+		if logConfig.MethodJournald {
+			writer, err := newJournaldWriter(logConfig.SystemTag)
+			if err != nil {
+				return nil, fmt.Errorf("Could not connect to journald [%s]", err.Error())
+			}
+			sLogger.MethodJournald = writer
+		}
+		if logConfig.MethodEventlog {
+			writer, err := newEventlogWriter(logConfig.SystemTag)
+			if err != nil {
+				return nil, fmt.Errorf("Could not open the event log [%s]", err.Error())
+			}
+			sLogger.MethodEventlog = writer
+		}
 		logger.loggers = append(logger.loggers, sLogger)
 	}
 
 	return &logger, nil
 }
 
+// SetLevelOverride makes loggers that capture the given log type ("*" for all of them)
+// use the given level for it, instead of their configured levels.
+func (logger *Manager) SetLevelOverride(logType string, level Level) {
+	logger.levelOverrides.Lock()
+	defer logger.levelOverrides.Unlock()
+	if logger.levelOverrides.levels == nil {
+		logger.levelOverrides.levels = make(map[string]Level)
+	}
+	logger.levelOverrides.levels[strings.ToLower(logType)] = level
+}
+
+// RemoveLevelOverride makes the given log type use the configured levels again.
+func (logger *Manager) RemoveLevelOverride(logType string) {
+	logger.levelOverrides.Lock()
+	defer logger.levelOverrides.Unlock()
+	delete(logger.levelOverrides.levels, strings.ToLower(logType))
+}
+
+// LevelOverrides returns the log levels that have been changed at runtime.
+func (logger *Manager) LevelOverrides() map[string]Level {
+	logger.levelOverrides.RLock()
+	defer logger.levelOverrides.RUnlock()
+	overrides := make(map[string]Level, len(logger.levelOverrides.levels))
+	for logType, level := range logger.levelOverrides.levels {
+		overrides[logType] = level
+	}
+	return overrides
+}
+
 // Log logs the given message with the given details.
 func (logger *Manager) Log(level Level, logType string, messageParts ...string) {
 	for _, singleLogger := range logger.loggers {
-		singleLogger.Log(level, logType, messageParts...)
+		singleLogger.Log(level, logType, nil, messageParts...)
+	}
+}
+
+// LogFields logs the given message with the given details, along with some structured fields.
+// The fields are only built if one of our loggers is going to output the message.
+func (logger *Manager) LogFields(level Level, logType string, fields func() Fields, messageParts ...string) {
+	var builtFields Fields
+	for _, singleLogger := range logger.loggers {
+		if !singleLogger.isLogging(level, logType) {
+			continue
+		}
+		if builtFields == nil {
+			builtFields = fields()
+		}
+		singleLogger.Log(level, logType, builtFields, messageParts...)
 	}
 }
 
 // Debug logs the given message as a debug message.
 func (logger *Manager) Debug(logType string, messageParts ...string) {
 	for _, singleLogger := range logger.loggers {
-		singleLogger.Log(LogDebug, logType, messageParts...)
+		singleLogger.Log(LogDebug, logType, nil, messageParts...)
 	}
 }
 
 // Info logs the given message as an info message.
 func (logger *Manager) Info(logType string, messageParts ...string) {
 	for _, singleLogger := range logger.loggers {
-		singleLogger.Log(LogInfo, logType, messageParts...)
+		singleLogger.Log(LogInfo, logType, nil, messageParts...)
 	}
 }
 
 // Warning logs the given message as a warning message.
 func (logger *Manager) Warning(logType string, messageParts ...string) {
 	for _, singleLogger := range logger.loggers {
-		singleLogger.Log(LogWarning, logType, messageParts...)
+		singleLogger.Log(LogWarning, logType, nil, messageParts...)
 	}
 }
 
 // Error logs the given message as an error message.
 func (logger *Manager) Error(logType string, messageParts ...string) {
 	for _, singleLogger := range logger.loggers {
-		singleLogger.Log(LogError, logType, messageParts...)
+		singleLogger.Log(LogError, logType, nil, messageParts...)
 	}
 }
 
@@ -160,6 +260,12 @@ func (logger *Manager) Fatal(logType string, messageParts ...string) {
 	os.Exit(1)
 }
 
+// systemWriter sends log lines to a logging service provided by the system, such as
+// syslog, journald or the Windows event log. These services apply their own timestamps.
+type systemWriter interface {
+	Write(level Level, logType string, fields Fields, line string) error
+}
+
 type fileMethod struct {
 	Enabled  bool
 	Filename string
@@ -171,34 +277,73 @@ type fileMethod struct {
 type singleLogger struct {
 	stdoutWriteLock *sync.Mutex
 	fileWriteLock   *sync.Mutex
+	levelOverrides  *levelOverrides
 	MethodSTDOUT    bool
 	MethodSTDERR    bool
 	MethodFile      fileMethod
+	MethodSyslog    systemWriter
+	MethodJournald  systemWriter
+	MethodEventlog  systemWriter
+	JSON            bool
 	Level           Level
 	Types           map[string]bool
 	ExcludedTypes   map[string]bool
 }
 
-// Log logs the given message with the given details.
-func (logger *singleLogger) Log(level Level, logType string, messageParts ...string) {
+// isLogging returns true if this logger outputs messages of the given level and type.
+func (logger *singleLogger) isLogging(level Level, logType string) bool {
 	// no logging enabled
-	if !(logger.MethodSTDOUT || logger.MethodSTDERR || logger.MethodFile.Enabled) {
-		return
+	if !(logger.MethodSTDOUT || logger.MethodSTDERR || logger.MethodFile.Enabled || logger.MethodSyslog != nil || logger.MethodJournald != nil || logger.MethodEventlog != nil) {
+		return false
 	}
 
 	// ensure we're logging to the given level
-	if level < logger.Level {
-		return
+	logTypeCleaned := strings.ToLower(strings.TrimSpace(logType))
+	minLevel, overridden := logger.levelOverrides.get(logTypeCleaned)
+	if !overridden {
+		minLevel = logger.Level
+	}
+	if level < minLevel {
+		return false
 	}
 
 	// ensure we're capturing this logType
-	logTypeCleaned := strings.ToLower(strings.TrimSpace(logType))
-	capturing := (logger.Types["*"] || logger.Types[logTypeCleaned]) && !logger.ExcludedTypes["*"] && !logger.ExcludedTypes[logTypeCleaned]
-	if !capturing {
+	return (logger.Types["*"] || logger.Types[logTypeCleaned]) && !logger.ExcludedTypes["*"] && !logger.ExcludedTypes[logTypeCleaned]
+}
+
+// Log logs the given message with the given details.
+func (logger *singleLogger) Log(level Level, logType string, fields Fields, messageParts ...string) {
+	if !logger.isLogging(level, logType) {
 		return
 	}
+	logTypeCleaned := strings.ToLower(strings.TrimSpace(logType))
+
+	// system loggers
+	message := strings.Join(messageParts, " : ")
+	if logger.MethodSyslog != nil || logger.MethodEventlog != nil {
+		line := fmt.Sprintf("%s : %s", logType, message)
+		if logger.JSON {
+			line = logger.jsonLine(level, logTypeCleaned, fields, messageParts)
+		}
+		if logger.MethodSyslog != nil {
+			logger.MethodSyslog.Write(level, logTypeCleaned, fields, line)
+		}
+		if logger.MethodEventlog != nil {
+			logger.MethodEventlog.Write(level, logTypeCleaned, fields, line)
+		}
+	}
+	if logger.MethodJournald != nil {
+		// journald keeps the type and fields separately, so just send the message itself
+		logger.MethodJournald.Write(level, logTypeCleaned, fields, message)
+	}
 
 	// assemble full line
+	if logger.JSON {
+		line := logger.jsonLine(level, logTypeCleaned, fields, messageParts)
+		logger.output(line, line)
+		return
+	}
+
 	timeGrey := ansi.ColorFunc("243")
 	grey := ansi.ColorFunc("8")
 	alert := ansi.ColorFunc("232+b:red")
@@ -230,7 +375,28 @@ func (logger *singleLogger) Log(level Level, logType string, messageParts ...str
 		}
 	}
 
-	// output
+	logger.output(fullStringFormatted, fullStringRaw)
+}
+
+// jsonLine returns the given message as a single JSON object.
+func (logger *singleLogger) jsonLine(level Level, logType string, fields Fields, messageParts []string) string {
+	line := make(map[string]string, len(fields)+4)
+	for key, value := range fields {
+		line[key] = value
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = LogLevelDisplayNames[level]
+	line["type"] = logType
+	line["message"] = strings.Join(messageParts, " : ")
+
+	// marshalling a map of strings can't fail
+	encoded, _ := json.Marshal(line)
+	return string(encoded)
+}
+
+// output writes the given line to each of our enabled methods, using the formatted
+// version for the console and the raw version for files.
+func (logger *singleLogger) output(fullStringFormatted, fullStringRaw string) {
 	if logger.MethodSTDOUT {
 		logger.stdoutWriteLock.Lock()
 		fmt.Fprintln(colorable.NewColorableStdout(), fullStringFormatted)
@@ -0,0 +1,25 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package logger
+
+import (
+	"errors"
+)
+
+var (
+	errSystemLoggingUnsupported = errors.New("Logging to syslog and journald is not supported on this platform")
+)
+
+// newSyslogWriter connects to the given syslog daemon.
+func newSyslogWriter(network, address, tag string) (systemWriter, error) {
+	return nil, errSystemLoggingUnsupported
+}
+
+// newJournaldWriter connects to the local journald.
+func newJournaldWriter(tag string) (systemWriter, error) {
+	return nil, errSystemLoggingUnsupported
+}
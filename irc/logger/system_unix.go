@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/syslog"
+	"net"
+	"strings"
+)
+
+const (
+	// journaldSocket is where journald listens for native protocol messages.
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// syslogWriter sends log lines to a local or remote syslog daemon.
+type syslogWriter struct {
+	writer *syslog.Writer
+}
+
+// newSyslogWriter connects to the given syslog daemon. An empty network and address
+// means the local syslog daemon.
+func newSyslogWriter(network, address, tag string) (systemWriter, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{
+		writer: writer,
+	}, nil
+}
+
+// Write sends the given line to syslog, with the severity matching our log level.
+func (sw *syslogWriter) Write(level Level, logType string, fields Fields, line string) error {
+	switch level {
+	case LogDebug:
+		return sw.writer.Debug(line)
+	case LogInfo:
+		return sw.writer.Info(line)
+	case LogWarning:
+		return sw.writer.Warning(line)
+	default:
+		return sw.writer.Err(line)
+	}
+}
+
+// journaldWriter sends log lines to systemd-journald, using its native protocol so
+// that our log type and fields are kept as separate journal fields.
+type journaldWriter struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+// newJournaldWriter connects to the local journald.
+func newJournaldWriter(tag string) (systemWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{
+		conn: conn,
+		tag:  tag,
+	}, nil
+}
+
+// journaldPriorities are the syslog priorities journald uses for our log levels.
+var journaldPriorities = map[Level]string{
+	LogDebug:   "7",
+	LogInfo:    "6",
+	LogWarning: "4",
+	LogError:   "3",
+}
+
+// Write sends the given line to journald, with the severity matching our log level.
+func (jw *journaldWriter) Write(level Level, logType string, fields Fields, line string) error {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", line)
+	writeJournaldField(&buf, "PRIORITY", journaldPriorities[level])
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", jw.tag)
+	writeJournaldField(&buf, "ORAGONO_TYPE", logType)
+	for key, value := range fields {
+		writeJournaldField(&buf, "ORAGONO_"+journaldFieldName(key), value)
+	}
+	_, err := jw.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeJournaldField adds the given field to a native protocol message. Values that
+// contain newlines have to be sent with an explicit length instead.
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journaldFieldName turns the given field name into one journald accepts, which only
+// allows uppercase letters, digits and underscores.
+func journaldFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_' {
+			return r
+		} else if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errLoginThrottled = errors.New("Too many failed logins, try again later")
+)
+
+// LoginThrottleConfig limits how many failed logins can come from each IP in a
+// period, so passphrases (and any external auth service) can't be brute-forced.
+type LoginThrottleConfig struct {
+	Enabled        bool
+	DurationString string        `yaml:"duration"`
+	Duration       time.Duration `yaml:"duration-real"`
+	MaxAttempts    int           `yaml:"max-attempts"`
+}
+
+// parse checks the config and fills in its defaults.
+func (conf *LoginThrottleConfig) parse() error {
+	if !conf.Enabled {
+		return nil
+	}
+	conf.Duration = defaultLoginThrottleDuration
+	if conf.DurationString != "" {
+		var err error
+		conf.Duration, err = time.ParseDuration(conf.DurationString)
+		if err != nil {
+			return fmt.Errorf("Could not parse duration: %s", err.Error())
+		}
+	}
+	if conf.MaxAttempts < 1 {
+		return errors.New("max-attempts must be at least 1")
+	}
+	return nil
+}
+
+// LoginThrottle counts the failed logins from each IP (or other key), and refuses
+// more once there have been too many in the configured period.
+type LoginThrottle struct {
+	sync.Mutex
+
+	config   LoginThrottleConfig
+	failures map[string]ThrottleDetails
+}
+
+// NewLoginThrottle returns a new LoginThrottle with the given config.
+func NewLoginThrottle(config LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		config:   config,
+		failures: make(map[string]ThrottleDetails),
+	}
+}
+
+// SetConfig changes the throttle's settings, keeping the failures it's counted.
+func (lt *LoginThrottle) SetConfig(config LoginThrottleConfig) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	lt.config = config
+}
+
+// Allowed returns false if the given key has run out of login attempts for now.
+func (lt *LoginThrottle) Allowed(key string) bool {
+	lt.Lock()
+	defer lt.Unlock()
+
+	if !lt.config.Enabled {
+		return true
+	}
+	details, exists := lt.failures[key]
+	if !exists || lt.expiredNoMutex(details) {
+		return true
+	}
+	return details.ClientCount < lt.config.MaxAttempts
+}
+
+// Failed counts a failed login from the given key.
+func (lt *LoginThrottle) Failed(key string) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	if !lt.config.Enabled {
+		return
+	}
+	details, exists := lt.failures[key]
+	if !exists || lt.expiredNoMutex(details) {
+		// only new keys can make the map grow, so this is when we clear out old ones
+		lt.pruneNoMutex()
+		details = ThrottleDetails{
+			Start: time.Now(),
+		}
+	}
+	details.ClientCount++
+	lt.failures[key] = details
+}
+
+// Succeeded forgets the failed logins from the given key.
+func (lt *LoginThrottle) Succeeded(key string) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	delete(lt.failures, key)
+}
+
+// expiredNoMutex returns true if the given failures are from before our period.
+func (lt *LoginThrottle) expiredNoMutex(details ThrottleDetails) bool {
+	return details.Start.Add(lt.config.Duration).Before(time.Now())
+}
+
+// pruneNoMutex removes the failures that are from before our period.
+func (lt *LoginThrottle) pruneNoMutex() {
+	for key, details := range lt.failures {
+		if lt.expiredNoMutex(details) {
+			delete(lt.failures, key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	lt := NewLoginThrottle(LoginThrottleConfig{
+		Enabled:     true,
+		Duration:    time.Minute,
+		MaxAttempts: 2,
+	})
+
+	lt.Failed("127.0.0.1")
+	if !lt.Allowed("127.0.0.1") {
+		t.Error("expected an IP to be allowed another login after one failure")
+	}
+	lt.Failed("127.0.0.1")
+	if lt.Allowed("127.0.0.1") {
+		t.Error("expected an IP to be throttled after using up its attempts")
+	}
+	if !lt.Allowed("127.0.0.2") {
+		t.Error("expected other IPs not to be throttled")
+	}
+
+	// failures from before the period don't count
+	lt.failures["127.0.0.1"] = ThrottleDetails{Start: time.Now().Add(-2 * time.Minute), ClientCount: 2}
+	if !lt.Allowed("127.0.0.1") {
+		t.Error("expected old failures not to count")
+	}
+	lt.Failed("127.0.0.2")
+	if _, exists := lt.failures["127.0.0.1"]; exists {
+		t.Error("expected old failures to be pruned")
+	}
+
+	lt.Failed("127.0.0.2")
+	lt.Succeeded("127.0.0.2")
+	if !lt.Allowed("127.0.0.2") {
+		t.Error("expected a successful login to reset an IP's failures")
+	}
+
+	lt.SetConfig(LoginThrottleConfig{})
+	lt.Failed("127.0.0.3")
+	lt.Failed("127.0.0.3")
+	if !lt.Allowed("127.0.0.3") {
+		t.Error("expected nothing to be throttled when the throttle's disabled")
+	}
+}
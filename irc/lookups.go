@@ -0,0 +1,137 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	ident "github.com/oragono/go-ident"
+)
+
+const (
+	// LookupWorkers is how many reverse DNS and ident lookups we do at once.
+	LookupWorkers = 32
+	// LookupQueueLength is how many lookups can be waiting for a worker before we
+	// just start skipping new ones.
+	LookupQueueLength = 512
+	// LookupTimeout is how long we wait for a reverse DNS lookup to finish.
+	LookupTimeout = 5 * time.Second
+)
+
+var (
+	errLookupQueueFull = errors.New("Lookup queue is full")
+	errLookupTimedOut  = errors.New("Lookup timed out")
+)
+
+// lookupJob is a lookup waiting to be run by one of our workers.
+type lookupJob struct {
+	ctx context.Context
+	run func(ctx context.Context)
+}
+
+// LookupPool is a bounded pool of workers that do reverse DNS and ident lookups, so a
+// flood of new connections or a slow resolver can't eat all our goroutines.
+type LookupPool struct {
+	jobs chan lookupJob
+}
+
+// NewLookupPool returns a new LookupPool, with its workers already running.
+func NewLookupPool(workers int, queueLength int) *LookupPool {
+	pool := &LookupPool{
+		jobs: make(chan lookupJob, queueLength),
+	}
+	for i := 0; i < workers; i++ {
+		go pool.runWorker()
+	}
+	return pool
+}
+
+// runWorker runs lookups until our job queue is closed.
+func (pool *LookupPool) runWorker() {
+	for job := range pool.jobs {
+		// don't bother with lookups whose caller has already given up
+		if job.ctx.Err() != nil {
+			continue
+		}
+		job.run(job.ctx)
+	}
+}
+
+// do runs the given lookup in the pool and waits for it to finish or for ctx to expire.
+func (pool *LookupPool) do(ctx context.Context, run func(ctx context.Context)) error {
+	done := make(chan bool, 1)
+	job := lookupJob{
+		ctx: ctx,
+		run: func(ctx context.Context) {
+			run(ctx)
+			done <- true
+		},
+	}
+
+	select {
+	case pool.jobs <- job:
+	default:
+		return errLookupQueueFull
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errLookupTimedOut
+	}
+}
+
+// Hostname returns the hostname (if possible) or address for the given `net.Addr`.
+func (pool *LookupPool) Hostname(addr net.Addr) string {
+	ipaddr := IPString(addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), LookupTimeout)
+	defer cancel()
+
+	var hostname string
+	err := pool.do(ctx, func(ctx context.Context) {
+		names, err := net.DefaultResolver.LookupAddr(ctx, ipaddr)
+		hostname = hostnameFromLookup(ipaddr, names, err)
+	})
+	if err != nil {
+		return hostnameFromLookup(ipaddr, nil, err)
+	}
+	return hostname
+}
+
+// Ident returns the username the given connection's ident server reports.
+func (pool *LookupPool) Ident(conn net.Conn) (string, error) {
+	_, serverPortString, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return "", err
+	}
+	serverPort, _ := strconv.Atoi(serverPortString)
+	clientHost, clientPortString, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return "", err
+	}
+	clientPort, _ := strconv.Atoi(clientPortString)
+
+	// ident queries have their own timeout, this one just stops us waiting on the queue forever
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(IdentTimeoutSeconds+1)*time.Second)
+	defer cancel()
+
+	var resp ident.Response
+	var queryErr error
+	err = pool.do(ctx, func(ctx context.Context) {
+		resp, queryErr = ident.Query(clientHost, serverPort, clientPort, IdentTimeoutSeconds)
+	})
+	if err != nil {
+		return "", err
+	}
+	if queryErr != nil {
+		return "", queryErr
+	}
+	return resp.Identifier, nil
+}
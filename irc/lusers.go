@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	keyUserRecords = "stats.userrecords"
+)
+
+// UserRecords are the most users and connections we've had at once. They're kept in
+// the datastore, so they last across restarts.
+type UserRecords struct {
+	MaxLocal       int
+	MaxGlobal      int
+	MaxConnections int
+}
+
+// userRecordsManager keeps track of our UserRecords.
+type userRecordsManager struct {
+	sync.Mutex
+
+	records UserRecords
+	// connectionsReceived is how many connections we've accepted since starting up
+	connectionsReceived uint64
+}
+
+// LUserStats are the current user counts shown by LUSERS.
+type LUserStats struct {
+	Users               int    `json:"users"`
+	Invisible           int    `json:"invisible"`
+	Opers               int    `json:"opers"`
+	Unknown             int    `json:"unknown"`
+	Channels            int    `json:"channels"`
+	MaxLocal            int    `json:"max-local"`
+	MaxGlobal           int    `json:"max-global"`
+	MaxConnections      int    `json:"max-connections"`
+	ConnectionsReceived uint64 `json:"connections-received"`
+}
+
+// loadUserRecords loads our user records from the datastore.
+func (server *Server) loadUserRecords() {
+	server.store.View(func(tx *buntdb.Tx) error {
+		recordsString, err := tx.Get(keyUserRecords)
+		if err == nil {
+			_ = json.Unmarshal([]byte(recordsString), &server.userRecords.records)
+		}
+		return nil
+	})
+}
+
+// connectionReceived counts a newly-accepted connection.
+func (server *Server) connectionReceived() {
+	server.userRecords.Lock()
+	server.userRecords.connectionsReceived++
+	server.userRecords.Unlock()
+}
+
+// LUserStats returns the current user counts, updating our records if they've
+// been beaten.
+func (server *Server) LUserStats() LUserStats {
+	var stats LUserStats
+
+	server.clients.ByNickMutex.RLock()
+	for _, client := range server.clients.ByNick {
+		// clients get their nick before they finish registering
+		if !client.registered {
+			continue
+		}
+		stats.Users++
+		if client.HasMode(Invisible) {
+			stats.Invisible++
+		}
+		if client.HasMode(Operator) {
+			stats.Opers++
+		}
+	}
+	server.clients.ByNickMutex.RUnlock()
+	stats.Unknown = server.unregisteredLimits.Count()
+	stats.Channels = server.channels.Len()
+
+	server.userRecords.Lock()
+	records := &server.userRecords.records
+	oldRecords := *records
+	if records.MaxLocal < stats.Users {
+		records.MaxLocal = stats.Users
+	}
+	// we don't link to other servers, so our global count is our local one
+	if records.MaxGlobal < stats.Users {
+		records.MaxGlobal = stats.Users
+	}
+	if records.MaxConnections < stats.Users+stats.Unknown {
+		records.MaxConnections = stats.Users + stats.Unknown
+	}
+	newRecords := *records
+	stats.MaxLocal = records.MaxLocal
+	stats.MaxGlobal = records.MaxGlobal
+	stats.MaxConnections = records.MaxConnections
+	stats.ConnectionsReceived = server.userRecords.connectionsReceived
+	server.userRecords.Unlock()
+
+	if newRecords != oldRecords {
+		recordsString, _ := json.Marshal(newRecords)
+		err := server.store.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(keyUserRecords, string(recordsString), nil)
+			return err
+		})
+		if err != nil {
+			server.logger.Error("internal", fmt.Sprintf("Could not save user records: %s", err.Error()))
+		}
+	}
+
+	return stats
+}
+
+// RplLusers sends the client our LUSERS numerics.
+func (client *Client) RplLusers() {
+	server := client.server
+	stats := server.LUserStats()
+	if !client.HasMode(Operator) {
+		stats = server.Config().Server.UserCountPrivacy.privateLUserStats(stats)
+	}
+
+	client.Send(nil, server.name, RPL_LUSERCLIENT, client.nick, fmt.Sprintf(client.t("There are %[1]d users and %[2]d invisible on %[3]d server(s)"), stats.Users-stats.Invisible, stats.Invisible, 1))
+	client.Send(nil, server.name, RPL_LUSEROP, client.nick, strconv.Itoa(stats.Opers), client.t("IRC Operators online"))
+	if 0 < stats.Unknown {
+		client.Send(nil, server.name, RPL_LUSERUNKNOWN, client.nick, strconv.Itoa(stats.Unknown), client.t("unknown connection(s)"))
+	}
+	client.Send(nil, server.name, RPL_LUSERCHANNELS, client.nick, strconv.Itoa(stats.Channels), client.t("channels formed"))
+	client.Send(nil, server.name, RPL_LUSERME, client.nick, fmt.Sprintf(client.t("I have %[1]d clients and %[2]d servers"), stats.Users, 0))
+	client.Send(nil, server.name, RPL_LOCALUSERS, client.nick, strconv.Itoa(stats.Users), strconv.Itoa(stats.MaxLocal), fmt.Sprintf(client.t("Current local users %[1]d, max %[2]d"), stats.Users, stats.MaxLocal))
+	client.Send(nil, server.name, RPL_GLOBALUSERS, client.nick, strconv.Itoa(stats.Users), strconv.Itoa(stats.MaxGlobal), fmt.Sprintf(client.t("Current global users %[1]d, max %[2]d"), stats.Users, stats.MaxGlobal))
+	client.Send(nil, server.name, RPL_STATSCONN, client.nick, fmt.Sprintf(client.t("Highest connection count: %[1]d (%[2]d clients) (%[3]d connections received)"), stats.MaxConnections, stats.MaxLocal, stats.ConnectionsReceived))
+}
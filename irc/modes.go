@@ -6,8 +6,10 @@
 package irc
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircmsg"
 	"github.com/oragono/oragono/irc/sno"
@@ -30,6 +32,12 @@ const (
 	Remove ModeOp = '-'
 )
 
+const (
+	// samodeUsersCapab is the oper capability needed to change other users' modes with
+	// SAMODE.
+	samodeUsersCapab = "oper:samode_users"
+)
+
 // Mode represents a user/channel/server mode
 type Mode rune
 
@@ -96,24 +104,31 @@ func (modes Modes) String() string {
 
 // User Modes
 const (
-	Away            Mode = 'a'
-	Invisible       Mode = 'i'
-	LocalOperator   Mode = 'O'
-	Operator        Mode = 'o'
-	Restricted      Mode = 'r'
-	ServerNotice    Mode = 's'
-	TLS             Mode = 'Z'
-	UserRoleplaying Mode = 'E'
-	WallOps         Mode = 'w'
+	Away               Mode = 'a'
+	Bot                Mode = 'B'
+	CallerID           Mode = 'g'
+	Invisible          Mode = 'i'
+	LocalOperator      Mode = 'O'
+	Operator           Mode = 'o'
+	Restricted         Mode = 'r'
+	ServerNotice       Mode = 's'
+	TLS                Mode = 'Z'
+	UserRegisteredOnly Mode = 'R'
+	UserRoleplaying    Mode = 'E'
+	WallOps            Mode = 'w'
 )
 
 var (
 	// SupportedUserModes are the user modes that we actually support (modifying).
 	SupportedUserModes = Modes{
-		Away, Invisible, Operator, ServerNotice, UserRoleplaying,
+		Away, Bot, CallerID, Invisible, Operator, ServerNotice, UserRegisteredOnly, UserRoleplaying,
 	}
 	// supportedUserModesString acts as a cache for when we introduce users
 	supportedUserModesString = SupportedUserModes.String()
+	// defaultableUserModes are the user modes that can be set on new clients by default.
+	defaultableUserModes = Modes{
+		CallerID, Invisible, UserRegisteredOnly, UserRoleplaying,
+	}
 )
 
 // Channel Modes
@@ -147,10 +162,16 @@ var (
 	// supportedChannelModesString acts as a cache for when we introduce users
 	supportedChannelModesString = SupportedChannelModes.String()
 
-	// DefaultChannelModes are enabled on brand new channels when they're created.
+	// DefaultChannelModes are enabled on brand new channels when they're created, unless
+	// the config says otherwise.
 	DefaultChannelModes = Modes{
 		NoOutside, OpOnlyTopic,
 	}
+	// defaultableChannelModes are the channel modes that can be set on new channels by
+	// default. These are the modes that are simple flags.
+	defaultableChannelModes = Modes{
+		ChanRoleplaying, InviteOnly, NoOutside, OpOnlyTopic, Secret,
+	}
 
 	// ChannelPrivModes holds the list of modes that are privileged, ie founder/op/halfop, in order.
 	// voice is not in this list because it cannot perform channel operator actions.
@@ -222,7 +243,8 @@ func ParseUserModeChanges(params ...string) (ModeChanges, map[rune]bool) {
 	changes := make(ModeChanges, 0)
 	unknown := make(map[rune]bool)
 
-	if 0 < len(params) {
+	// an empty mode string (such as from `MODE #channel :`) changes nothing
+	if 0 < len(params) && params[0] != "" {
 		modeArg := params[0]
 		op := ModeOp(modeArg[0])
 		if (op == Add) || (op == Remove) {
@@ -275,35 +297,66 @@ func ParseUserModeChanges(params ...string) (ModeChanges, map[rune]bool) {
 	return changes, unknown
 }
 
+// parseDefaultModes parses a mode string such as "+nt" from the config, only allowing
+// the given modes to be added.
+func parseDefaultModes(modeString string, allowed Modes) (Modes, error) {
+	modes := make(Modes, 0)
+	modeString = strings.TrimPrefix(strings.TrimSpace(modeString), "+")
+
+	for _, char := range modeString {
+		var isAllowed bool
+		for _, mode := range allowed {
+			if Mode(char) == mode {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			return nil, fmt.Errorf("Mode %s can't be set by default", string(char))
+		}
+		modes = append(modes, Mode(char))
+	}
+	return modes, nil
+}
+
+// ParseDefaultUserModes parses the modes that new clients get when they connect.
+func ParseDefaultUserModes(modeString string) (Modes, error) {
+	return parseDefaultModes(modeString, defaultableUserModes)
+}
+
+// ParseDefaultChannelModes parses the modes that new channels get when they're created.
+func ParseDefaultChannelModes(modeString string) (Modes, error) {
+	return parseDefaultModes(modeString, defaultableChannelModes)
+}
+
 // applyUserModeChanges applies the given changes, and returns the applied changes.
 func (client *Client) applyUserModeChanges(force bool, changes ModeChanges) ModeChanges {
 	applied := make(ModeChanges, 0)
 
 	for _, change := range changes {
 		switch change.mode {
-		case Invisible, WallOps, UserRoleplaying, Operator, LocalOperator:
+		case Bot, CallerID, Invisible, UserRegisteredOnly, WallOps, UserRoleplaying, Operator, LocalOperator:
 			switch change.op {
 			case Add:
 				if !force && (change.mode == Operator || change.mode == LocalOperator) {
 					continue
 				}
 
-				if client.flags[change.mode] {
-					continue
+				if client.SetMode(change.mode, true) {
+					applied = append(applied, change)
 				}
-				client.flags[change.mode] = true
-				applied = append(applied, change)
 
 			case Remove:
-				if !client.flags[change.mode] {
-					continue
+				if client.SetMode(change.mode, false) {
+					applied = append(applied, change)
+					if change.mode == Operator || change.mode == LocalOperator {
+						client.removeOper()
+					}
 				}
-				delete(client.flags, change.mode)
-				applied = append(applied, change)
 			}
 
 		case ServerNotice:
-			if !client.flags[Operator] {
+			if !client.HasMode(Operator) {
 				continue
 			}
 			var masks []sno.Mask
@@ -336,19 +389,25 @@ func umodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	if err != nil || target == nil {
 		if len(msg.Params[0]) > 0 {
-			client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, msg.Params[0], "No such nick")
+			client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, msg.Params[0], client.t("No such nick"))
 		}
 		return false
 	}
 
-	if client != target && msg.Command != "SAMODE" {
+	isSamode := msg.Command == "SAMODE"
+	if client != target && !isSamode {
 		if len(msg.Params) > 1 {
-			client.Send(nil, server.name, ERR_USERSDONTMATCH, client.nick, "Can't change modes for other users")
+			client.Send(nil, server.name, ERR_USERSDONTMATCH, client.nick, client.t("Can't change modes for other users"))
 		} else {
-			client.Send(nil, server.name, ERR_USERSDONTMATCH, client.nick, "Can't view modes for other users")
+			client.Send(nil, server.name, ERR_USERSDONTMATCH, client.nick, client.t("Can't view modes for other users"))
 		}
 		return false
 	}
+	// other users' modes need their own capability, on top of the one SAMODE needs
+	if client != target && !client.HasCapabs(samodeUsersCapab) {
+		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied"))
+		return false
+	}
 
 	// applied mode changes
 	applied := make(ModeChanges, 0)
@@ -366,30 +425,62 @@ func umodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			return false
 		}
 
-		// apply mode changes
-		applied = target.applyUserModeChanges(msg.Command == "SAMODE", changes)
+		// apply mode changes. opers can only be made with OPER, since they need a class,
+		// so SAMODE can take +o away from other users but not give it to them
+		before := server.userModeString(target)
+		applied = target.applyUserModeChanges(isSamode && client == target, changes)
+		if isSamode {
+			server.auditLog(client, samodeAuditMessage(target.nick, applied, before, server.userModeString(target)))
+		}
+		target.saveCallerID(applied)
 	}
 
 	if len(applied) > 0 {
-		client.Send(nil, client.nickMaskString, "MODE", target.nick, applied.String())
-	} else if client == target {
+		client.SendFromClient("", client, nil, "MODE", target.nick, applied.String())
+		if client != target {
+			target.SendFromClient("", client, nil, "MODE", target.nick, applied.String())
+		}
+	} else if 1 < len(msg.Params) && client != target {
+		client.Notice(fmt.Sprintf(client.t("No modes were changed for %s"), target.nick))
+	} else if len(msg.Params) == 1 || client == target {
 		client.Send(nil, target.nickMaskString, RPL_UMODEIS, target.nick, target.ModeString())
-		if client.flags[LocalOperator] || client.flags[Operator] {
-			masks := server.snomasks.String(client)
+		if target.HasMode(LocalOperator) || target.HasMode(Operator) {
+			masks := server.snomasks.String(target)
 			if 0 < len(masks) {
-				client.Send(nil, target.nickMaskString, RPL_SNOMASKIS, target.nick, masks, "Server notice masks")
+				client.Send(nil, target.nickMaskString, RPL_SNOMASKIS, target.nick, masks, client.t("Server notice masks"))
 			}
 		}
 	}
 	return false
 }
 
+// samodeAuditMessage returns the audit log message for a use of SAMODE on the given
+// target, which had the given modes before and after the changes were applied.
+func samodeAuditMessage(target string, applied ModeChanges, before, after string) string {
+	if len(applied) == 0 {
+		return fmt.Sprintf("Used SAMODE on %s, which didn't change its modes (%s)", target, before)
+	}
+	return fmt.Sprintf("Used SAMODE on %s to apply %s, changing its modes from %s to %s", target, applied.String(), before, after)
+}
+
+// userModeString returns the client's modes along with their snomasks, as they're
+// written in the audit log.
+func (server *Server) userModeString(client *Client) string {
+	modes := client.ModeString()
+	masks := server.snomasks.String(client)
+	if masks != "" {
+		modes += " " + masks
+	}
+	return modes
+}
+
 // ParseChannelModeChanges returns the valid changes, and the list of unknown chars.
 func ParseChannelModeChanges(params ...string) (ModeChanges, map[rune]bool) {
 	changes := make(ModeChanges, 0)
 	unknown := make(map[rune]bool)
 
-	if 0 < len(params) {
+	// an empty mode string (such as from `MODE #channel :`) changes nothing
+	if 0 < len(params) && params[0] != "" {
 		modeArg := params[0]
 		op := ModeOp(modeArg[0])
 		if (op == Add) || (op == Remove) {
@@ -471,6 +562,7 @@ func ParseChannelModeChanges(params ...string) (ModeChanges, map[rune]bool) {
 func ApplyChannelModeChanges(channel *Channel, client *Client, isSamode bool, changes ModeChanges) ModeChanges {
 	// so we only output one warning for each list type when full
 	listFullWarned := make(map[Mode]bool)
+	var banFloodWarned bool
 
 	clientIsOp := channel.clientIsAtLeastNoMutex(client, ChannelOperator)
 	var alreadySentPrivError bool
@@ -478,12 +570,12 @@ func ApplyChannelModeChanges(channel *Channel, client *Client, isSamode bool, ch
 	applied := make(ModeChanges, 0)
 
 	for _, change := range changes {
-		// chan priv modes are checked specially so ignore them
-		// means regular users can't view ban/except lists... but I'm not worried about that
-		if isSamode && ChannelModePrefixes[change.mode] == "" && !clientIsOp {
+		// chan priv modes are checked specially so ignore them, and anyone can view the
+		// ban/except/invex lists
+		if !isSamode && ChannelModePrefixes[change.mode] == "" && change.op != List && !clientIsOp {
 			if !alreadySentPrivError {
 				alreadySentPrivError = true
-				client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+				client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not a channel operator"))
 			}
 			continue
 		}
@@ -503,23 +595,58 @@ func ApplyChannelModeChanges(channel *Channel, client *Client, isSamode bool, ch
 				continue
 			}
 
+			// masks can be added for a limited time, like *!*@example.com,24h
+			var duration time.Duration
+			if change.op == Add {
+				var err error
+				mask, duration, err = parseTimedMask(mask)
+				if err != nil {
+					client.Send(nil, client.server.name, ERR_UNKNOWNERROR, client.nick, "MODE", fmt.Sprintf(client.t("Invalid duration [%s]"), change.arg))
+					continue
+				}
+				change.arg = mask
+			}
+
 			// confirm mask looks valid
 			mask, err := Casefold(mask)
 			if err != nil {
 				continue
 			}
+			if strings.HasPrefix(mask, extbanPrefix) {
+				_, valid := parseExtban(mask)
+				if !valid {
+					client.Send(nil, client.server.name, ERR_UNKNOWNERROR, client.nick, "MODE", fmt.Sprintf(client.t("Invalid extban [%s]"), change.arg))
+					continue
+				}
+			}
 
 			switch change.op {
 			case Add:
-				if len(list.masks) >= client.server.limits.ChanListModes {
+				if channel.listFullNoMutex(change.mode, mask) {
 					if !listFullWarned[change.mode] {
-						client.Send(nil, client.server.name, ERR_BANLISTFULL, client.nick, channel.name, change.mode.String(), "Channel list is full")
+						client.Send(nil, client.server.name, ERR_BANLISTFULL, client.nick, channel.name, change.mode.String(), client.t("Channel list is full"))
 						listFullWarned[change.mode] = true
 					}
 					continue
 				}
+				if change.mode == BanMask && !isSamode && !client.server.checkOpAction(client, channel, "ban") {
+					if !banFloodWarned {
+						client.Send(nil, client.server.name, ERR_UNKNOWNERROR, client.nick, "MODE", fmt.Sprintf(client.t("You're setting bans too quickly in %s, slow down"), channel.name))
+						banFloodWarned = true
+					}
+					continue
+				}
 
 				list.Add(mask)
+				info := MaskInfo{
+					SetBy: client.nickMaskString,
+					SetAt: time.Now(),
+				}
+				if duration != 0 {
+					info.Expires = info.SetAt.Add(duration)
+				}
+				list.SetInfo(mask, info)
+				channel.scheduleListExpiryNoMutex()
 				applied = append(applied, change)
 
 			case Remove:
@@ -549,6 +676,8 @@ func ApplyChannelModeChanges(channel *Channel, client *Client, isSamode bool, ch
 			case Remove:
 				channel.key = ""
 			}
+			// the old key's hash is out of date now
+			channel.keyHash = nil
 			applied = append(applied, change)
 
 		case InviteOnly, Moderated, NoOutside, OpOnlyTopic, RegisteredOnly, Secret, ChanRoleplaying:
@@ -601,7 +730,7 @@ func ApplyChannelModeChanges(channel *Channel, client *Client, isSamode bool, ch
 				} else {
 					if !alreadySentPrivError {
 						alreadySentPrivError = true
-						client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, "You're not a channel operator")
+						client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not a channel operator"))
 					}
 					continue
 				}
@@ -623,7 +752,7 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	channel := server.channels.Get(channelName)
 
 	if err != nil || channel == nil {
-		client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[0], "No such channel")
+		client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[0], client.t("No such channel"))
 		return false
 	}
 
@@ -647,11 +776,15 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		}
 
 		// apply mode changes
+		before := channel.modeStringNoLock(client)
 		applied = ApplyChannelModeChanges(channel, client, msg.Command == "SAMODE", changes)
+		if msg.Command == "SAMODE" {
+			server.auditLog(client, samodeAuditMessage(channel.name, applied, before, channel.modeStringNoLock(client)))
+		}
 	}
 
-	// save changes to banlist/exceptlist/invexlist
-	var banlistUpdated, exceptlistUpdated, invexlistUpdated bool
+	// save changes to banlist/exceptlist/invexlist and key
+	var banlistUpdated, exceptlistUpdated, invexlistUpdated, keyUpdated bool
 	for _, change := range applied {
 		if change.mode == BanMask {
 			banlistUpdated = true
@@ -659,34 +792,25 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			exceptlistUpdated = true
 		} else if change.mode == InviteMask {
 			invexlistUpdated = true
+		} else if change.mode == Key {
+			keyUpdated = true
 		}
 	}
+	var keyHash []byte
+	if keyUpdated {
+		keyHash = server.hashChannelKey(channel.key)
+	}
 
 	server.registeredChannelsMutex.Lock()
-	if 0 < len(applied) && server.registeredChannels[channel.nameCasefolded] != nil && (banlistUpdated || exceptlistUpdated || invexlistUpdated) {
+	if 0 < len(applied) && server.registeredChannels[channel.nameCasefolded] != nil && (banlistUpdated || exceptlistUpdated || invexlistUpdated || keyUpdated) {
 		server.store.Update(func(tx *buntdb.Tx) error {
 			chanInfo := server.loadChannelNoMutex(tx, channel.nameCasefolded)
-
-			if banlistUpdated {
-				var banlist []string
-				for mask := range channel.lists[BanMask].masks {
-					banlist = append(banlist, mask)
-				}
-				chanInfo.Banlist = banlist
-			}
-			if exceptlistUpdated {
-				var exceptlist []string
-				for mask := range channel.lists[ExceptMask].masks {
-					exceptlist = append(exceptlist, mask)
-				}
-				chanInfo.Exceptlist = exceptlist
+			if keyUpdated {
+				chanInfo.KeyHash = keyHash
 			}
-			if invexlistUpdated {
-				var invitelist []string
-				for mask := range channel.lists[InviteMask].masks {
-					invitelist = append(invitelist, mask)
-				}
-				chanInfo.Invitelist = invitelist
+
+			if banlistUpdated || exceptlistUpdated || invexlistUpdated {
+				channel.listsToRegistrationNoMutex(chanInfo)
 			}
 
 			server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanInfo)
@@ -698,11 +822,9 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	// send out changes
 	if len(applied) > 0 {
-		//TODO(dan): we should change the name of String and make it return a slice here
-		args := append([]string{channel.name}, strings.Split(applied.String(), " ")...)
-		for member := range channel.members {
-			member.Send(nil, client.nickMaskString, "MODE", args...)
-		}
+		channel.sendModeChangesNoMutex(func(member *Client, args []string) {
+			member.SendFromClient("", client, nil, "MODE", args...)
+		}, applied)
 	} else {
 		//TODO(dan): we should just make ModeString return a slice here
 		args := append([]string{client.nick, channel.name}, strings.Split(channel.modeStringNoLock(client), " ")...)
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+func TestSamodeUserModes(t *testing.T) {
+	client := &Client{flags: make(map[Mode]bool)}
+	client.SetMode(Invisible, true)
+
+	// SAMODE on other users isn't forced, so it can't make them opers
+	changes, _ := ParseUserModeChanges("+oR")
+	applied := client.applyUserModeChanges(false, changes)
+	if applied.String() != "+R" {
+		t.Errorf("expected only +R to be applied, got %s", applied.String())
+	}
+	if client.ModeString() != "+Ri" {
+		t.Errorf("expected modes +Ri, got %s", client.ModeString())
+	}
+
+	changes, _ = ParseUserModeChanges("-R")
+	applied = client.applyUserModeChanges(false, changes)
+	message := samodeAuditMessage("dan", applied, "+Ri", client.ModeString())
+	if message != "Used SAMODE on dan to apply -R, changing its modes from +Ri to +i" {
+		t.Errorf("unexpected audit message: %s", message)
+	}
+	message = samodeAuditMessage("dan", nil, "+i", "+i")
+	if message != "Used SAMODE on dan, which didn't change its modes (+i)" {
+		t.Errorf("unexpected audit message: %s", message)
+	}
+}
+
+func TestApplyChannelModeChangesNeedsOp(t *testing.T) {
+	server := newTestServer()
+	server.limits.ChanListModes = 10
+	channel := NewChannel(server, "#test", false)
+	member := newTestClient(server, "alice")
+	channel.members.Add(member)
+
+	ban := ModeChanges{{mode: BanMask, op: Add, arg: "bob!*@*"}}
+	applied := ApplyChannelModeChanges(channel, member, false, ban)
+	if len(applied) != 0 || channel.lists[BanMask].masks["bob!*@*"] {
+		t.Errorf("expected a member who isn't an operator to not be able to set bans, got %v", applied)
+	}
+	lines := sentLines(member)
+	if len(lines) != 1 || lines[0] != ":oragono.test 482 alice #test :You're not a channel operator" {
+		t.Errorf("expected ERR_CHANOPRIVSNEEDED, got %q", lines)
+	}
+
+	// they can still view the list
+	ApplyChannelModeChanges(channel, member, false, ModeChanges{{mode: BanMask, op: List}})
+	lines = sentLines(member)
+	if len(lines) != 1 || lines[0] != ":oragono.test 368 alice #test :End of list" {
+		t.Errorf("expected to be shown the ban list, got %q", lines)
+	}
+
+	// opers using SAMODE don't need to be channel operators
+	applied = ApplyChannelModeChanges(channel, member, true, ban)
+	if len(applied) != 1 || !channel.lists[BanMask].masks["bob!*@*"] {
+		t.Errorf("expected SAMODE to set the ban, got %v", applied)
+	}
+}
+
+func TestSamodeRemovesOper(t *testing.T) {
+	server := newTestServer()
+	server.snomasks = NewSnoManager()
+	server.currentOpers = make(map[*Client]bool)
+	server.clients = NewClientLookupSet()
+	class := &OperClass{Capabilities: map[string]bool{"oper:samode": true, samodeUsersCapab: true}}
+
+	oper := newTestClient(server, "oper")
+	target := newTestClient(server, "target")
+	for _, client := range []*Client{oper, target} {
+		client.SetMode(Operator, true)
+		client.class = class
+		server.currentOpers[client] = true
+		server.clients.Add(client, client.nick)
+	}
+	server.snomasks.AddMasks(target, sno.LocalOpers)
+
+	umodeHandler(server, oper, ircmsg.MakeMessage(nil, "", "SAMODE", "target", "-o"))
+	if target.HasMode(Operator) || target.HasCapabs("oper:samode") || target.class != nil {
+		t.Error("expected SAMODE -o to take away the target's oper class")
+	}
+	if server.currentOpers[target] || server.snomasks.String(target) != "" {
+		t.Error("expected SAMODE -o to remove the target from the opers and snomasks")
+	}
+	if !oper.HasCapabs("oper:samode") {
+		t.Error("expected the oper to keep their class")
+	}
+}
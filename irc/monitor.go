@@ -62,7 +62,7 @@ func monitorHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 
 func monitorRemoveHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	if len(msg.Params) < 2 {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
 		return false
 	}
 
@@ -106,7 +106,7 @@ func monitorRemoveHandler(server *Server, client *Client, msg ircmsg.IrcMessage)
 
 func monitorAddHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	if len(msg.Params) < 2 {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
 		return false
 	}
 
@@ -116,14 +116,14 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bo
 	targets := strings.Split(msg.Params[1], ",")
 	for len(targets) > 0 {
 		// check name length
-		if len(targets[0]) < 1 || len(targets[0]) > server.limits.NickLen {
+		if len(targets[0]) < 1 || len(targets[0]) > server.Limits().NickLen {
 			targets = targets[1:]
 			continue
 		}
 
 		// check the monitor list length
-		if len(client.monitoring) >= server.limits.MonitorEntries {
-			client.Send(nil, server.name, ERR_MONLISTFULL, client.nick, strconv.Itoa(server.limits.MonitorEntries), strings.Join(targets, ","))
+		if len(client.monitoring) >= server.Limits().MonitorEntries {
+			client.Send(nil, server.name, ERR_MONLISTFULL, client.nick, strconv.Itoa(server.Limits().MonitorEntries), strings.Join(targets, ","))
 			break
 		}
 
@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+)
+
+// MOTDFiles returns the files the MOTD is built from, in order.
+func (conf *Config) MOTDFiles() []string {
+	var files []string
+	if conf.Server.MOTD != "" {
+		files = append(files, conf.Server.MOTD)
+	}
+	return append(files, conf.Server.ExtraMOTDs...)
+}
+
+// readMOTD reads the given MOTD files one after the other, and returns their lines
+// ready to be sent to clients. Files that can't be read are skipped and returned as
+// errors.
+func readMOTD(filenames []string, formatting, stripFormatting bool) (lines []string, errs []error) {
+	for _, filename := range filenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Could not read MOTD file %s: %s", filename, err.Error()))
+			continue
+		}
+
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if formatting {
+				line = ircfmt.Unescape(line)
+			}
+			if stripFormatting {
+				line = stripIRCFormatting(line)
+			}
+			// "- " is the required prefix for MOTD, we just add it here to make
+			// bursting it out to clients easier
+			line = fmt.Sprintf("- %s", line)
+
+			lines = append(lines, line)
+		}
+		file.Close()
+	}
+	return
+}
+
+// loadMOTD (re)loads the MOTD from the files given in the config.
+func (server *Server) loadMOTD(config *Config) {
+	lines, errs := readMOTD(config.MOTDFiles(), config.Server.MOTDFormatting, config.Server.MOTDStripFormatting)
+	for _, err := range errs {
+		server.logger.Warning("motd", err.Error())
+	}
+
+	server.motdMutex.Lock()
+	server.motdLines = lines
+	server.motdMutex.Unlock()
+}
+
+// stripIRCFormatting removes bold, colour and other formatting codes from the given line.
+func stripIRCFormatting(line string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\x02', '\x0f', '\x11', '\x16', '\x1d', '\x1e', '\x1f':
+			// simple toggles
+		case '\x03':
+			// colour, followed by up to two digits, then optionally a comma and up to
+			// two more digits
+			foreground := skipDigits(line[i+1:], 2)
+			i += foreground
+			if 0 < foreground && i+2 < len(line) && line[i+1] == ',' && isDigit(line[i+2]) {
+				i++
+				i += skipDigits(line[i+1:], 2)
+			}
+		default:
+			buf.WriteByte(line[i])
+		}
+	}
+	return buf.String()
+}
+
+// skipDigits returns how many digits (up to max) the given string starts with.
+func skipDigits(s string, max int) int {
+	var count int
+	for count < max && count < len(s) && isDigit(s[count]) {
+		count++
+	}
+	return count
+}
+
+// isDigit returns true if the given byte is an ASCII digit.
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestStripIRCFormatting(t *testing.T) {
+	testCases := map[string]string{
+		"plain text":                         "plain text",
+		"\x02bold\x02 and \x1funderline\x1f": "bold and underline",
+		"\x034red\x03 text":                  "red text",
+		"\x0304,12red on blue\x0f":           "red on blue",
+		"\x03,5 comma stays":                 ",5 comma stays",
+		"year \x0312019":                     "year 019",
+		"trailing colour \x03":               "trailing colour ",
+	}
+
+	for input, expected := range testCases {
+		result := stripIRCFormatting(input)
+		if result != expected {
+			t.Errorf("stripping %q: expected %q, got %q", input, expected, result)
+		}
+	}
+}
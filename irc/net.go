@@ -28,6 +28,12 @@ func AddrLookupHostname(addr net.Addr) string {
 // LookupHostname returns the hostname for `addr` if it has one. Otherwise, just returns `addr`.
 func LookupHostname(addr string) string {
 	names, err := net.LookupAddr(addr)
+	return hostnameFromLookup(addr, names, err)
+}
+
+// hostnameFromLookup returns the hostname from the given lookup results if there is a
+// valid one. Otherwise, just returns `addr`.
+func hostnameFromLookup(addr string, names []string, err error) string {
 	if err != nil || len(names) < 1 || !IsHostname(names[0]) {
 		// return original address if no hostname found
 		if len(addr) > 0 && addr[0] == ':' {
@@ -0,0 +1,107 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+)
+
+// NickFloodConfig limits how often each client can change their nick, since every
+// change is sent to all of their channels. Changes that go over the limit are refused.
+type NickFloodConfig struct {
+	Enabled bool
+	// Limit is how many times each client can change their nick in the window.
+	Limit        int
+	WindowString string        `yaml:"window"`
+	Window       time.Duration `yaml:"window-real"`
+}
+
+func (conf *NickFloodConfig) parse() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.WindowString == "" {
+		conf.WindowString = "1m"
+	}
+	conf.Window, err = custime.ParseDuration(conf.WindowString)
+	if err != nil {
+		return fmt.Errorf("Could not parse window: %s", err.Error())
+	}
+	if conf.Limit < 0 {
+		return fmt.Errorf("limit can't be negative")
+	}
+	return nil
+}
+
+// eventWindow counts events over a sliding window of time.
+type eventWindow struct {
+	sync.Mutex
+	times []time.Time
+}
+
+// Wait returns how long it'll be until another event can happen, if only limit events
+// can happen in the given window. A limit of 0 means there's no limit.
+func (w *eventWindow) Wait(now time.Time, window time.Duration, limit int) time.Duration {
+	w.Lock()
+	defer w.Unlock()
+
+	w.prune(now, window)
+	if limit == 0 || len(w.times) < limit {
+		return 0
+	}
+	return w.times[len(w.times)-limit].Add(window).Sub(now)
+}
+
+// Add records an event, returning how many events are now in the window.
+func (w *eventWindow) Add(now time.Time, window time.Duration) int {
+	w.Lock()
+	defer w.Unlock()
+
+	w.prune(now, window)
+	w.times = append(w.times, now)
+	return len(w.times)
+}
+
+// Empty returns true if no events are in the window.
+func (w *eventWindow) Empty(now time.Time, window time.Duration) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	w.prune(now, window)
+	return len(w.times) == 0
+}
+
+// prune removes the events that have left the window.
+func (w *eventWindow) prune(now time.Time, window time.Duration) {
+	var expired int
+	for expired < len(w.times) && !now.Before(w.times[expired].Add(window)) {
+		expired++
+	}
+	w.times = w.times[expired:]
+}
+
+// nickChangeWait returns how long the client has to wait before they can change
+// their nick again.
+func (server *Server) nickChangeWait(client *Client) time.Duration {
+	config := server.Config().Server.NickFlood
+	if !config.Enabled || client.HasMode(Operator) {
+		return 0
+	}
+
+	return client.nickChanges.Wait(time.Now(), config.Window, config.Limit)
+}
+
+// nickChanged records that the client has changed their nick.
+func (server *Server) nickChanged(client *Client) {
+	config := server.Config().Server.NickFlood
+	if !config.Enabled || client.HasMode(Operator) {
+		return
+	}
+
+	client.nickChanges.Add(time.Now(), config.Window)
+}
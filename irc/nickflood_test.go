@@ -0,0 +1,63 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventWindow(t *testing.T) {
+	var w eventWindow
+	start := time.Now()
+	window := time.Minute
+
+	for i := 0; i < 3; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		if wait := w.Wait(now, window, 3); wait != 0 {
+			t.Fatalf("expected event %d to be allowed, had to wait %s", i, wait)
+		}
+		w.Add(now, window)
+	}
+
+	// the first event leaves the window 60 seconds after it happened
+	if wait := w.Wait(start.Add(30*time.Second), window, 3); wait != 30*time.Second {
+		t.Errorf("expected to wait 30s, had to wait %s", wait)
+	}
+	if wait := w.Wait(start.Add(time.Minute), window, 3); wait != 0 {
+		t.Errorf("expected an event to be allowed once the first one left the window, had to wait %s", wait)
+	}
+	if wait := w.Wait(start.Add(30*time.Second), window, 1); wait != 50*time.Second {
+		t.Errorf("expected to wait 50s with a lower limit, had to wait %s", wait)
+	}
+	if wait := w.Wait(start.Add(30*time.Second), window, 0); wait != 0 {
+		t.Errorf("expected a limit of 0 to be unlimited, had to wait %s", wait)
+	}
+}
+
+func TestNickChangeWait(t *testing.T) {
+	server := newTestServer()
+	server.config = &Config{}
+	server.config.Server.NickFlood = NickFloodConfig{Enabled: true, Limit: 2, Window: time.Minute}
+	alice := newTestClient(server, "alice")
+	bob := newTestClient(server, "bob")
+
+	for i := 0; i < 2; i++ {
+		if server.nickChangeWait(alice) != 0 {
+			t.Fatalf("expected nick change %d to be allowed", i)
+		}
+		server.nickChanged(alice)
+	}
+	if server.nickChangeWait(alice) == 0 {
+		t.Error("expected alice to be limited after using up their nick changes")
+	}
+	if server.nickChangeWait(bob) != 0 {
+		t.Error("expected bob to not be limited by alice's nick changes")
+	}
+
+	alice.SetMode(Operator, true)
+	if server.nickChangeWait(alice) != 0 {
+		t.Error("expected opers to not be limited")
+	}
+}
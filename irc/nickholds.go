@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"sync"
+	"time"
+)
+
+// nickHold is a nick being kept for an account.
+type nickHold struct {
+	account string
+	expires time.Time
+}
+
+// NickHolds keeps nicks that have just been recovered with NickServ GHOST reserved for
+// their account for a little while, so a ghost that reconnects straight away doesn't
+// grab the nick back before its owner can use it.
+type NickHolds struct {
+	sync.Mutex
+
+	// holds maps casefolded nick -> hold
+	holds map[string]nickHold
+}
+
+// NewNickHolds returns a new NickHolds.
+func NewNickHolds() *NickHolds {
+	return &NickHolds{
+		holds: make(map[string]nickHold),
+	}
+}
+
+// Hold keeps the given casefolded nick for the given casefolded account name.
+func (nh *NickHolds) Hold(nick, account string, duration time.Duration) {
+	nh.Lock()
+	defer nh.Unlock()
+
+	nh.holds[nick] = nickHold{
+		account: account,
+		expires: time.Now().Add(duration),
+	}
+}
+
+// Release stops holding the given nick, if it's being held for the given account.
+// It returns true if the nick was being held.
+func (nh *NickHolds) Release(nick, account string) bool {
+	nh.Lock()
+	defer nh.Unlock()
+
+	hold, exists := nh.holds[nick]
+	if !exists || hold.account != account || time.Now().After(hold.expires) {
+		return false
+	}
+	delete(nh.holds, nick)
+	return true
+}
+
+// Allowed returns true if a client logged into the given account (or "" for none)
+// can use the given nick.
+func (nh *NickHolds) Allowed(nick, account string) bool {
+	nh.Lock()
+	defer nh.Unlock()
+
+	hold, exists := nh.holds[nick]
+	if !exists {
+		return true
+	}
+	if time.Now().After(hold.expires) {
+		delete(nh.holds, nick)
+		return true
+	}
+	return hold.account == account
+}
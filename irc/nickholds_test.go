@@ -0,0 +1,36 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNickHolds(t *testing.T) {
+	holds := NewNickHolds()
+
+	holds.Hold("dan", "dan", time.Minute)
+	if !holds.Allowed("dan", "dan") {
+		t.Error("expected the account holding a nick to be able to use it")
+	}
+	if holds.Allowed("dan", "") || holds.Allowed("dan", "bob") {
+		t.Error("expected other clients to not be able to use a held nick")
+	}
+	if !holds.Allowed("bob", "") {
+		t.Error("expected nicks that aren't held to be usable")
+	}
+
+	if holds.Release("dan", "bob") {
+		t.Error("expected only the holding account to be able to release a nick")
+	}
+	if !holds.Release("dan", "dan") || !holds.Allowed("dan", "") {
+		t.Error("expected a released nick to be usable")
+	}
+
+	holds.Hold("dan", "dan", -time.Second)
+	if !holds.Allowed("dan", "bob") {
+		t.Error("expected an expired hold to not stop anyone")
+	}
+}
@@ -7,6 +7,7 @@ package irc
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircmsg"
 )
@@ -30,12 +31,17 @@ func nickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	nickname, err := CasefoldName(nicknameRaw)
 
 	if len(nicknameRaw) < 1 {
-		client.Send(nil, server.name, ERR_NONICKNAMEGIVEN, client.nick, "No nickname given")
+		client.Send(nil, server.name, ERR_NONICKNAMEGIVEN, client.nick, client.t("No nickname given"))
 		return false
 	}
 
-	if err != nil || len(nicknameRaw) > server.limits.NickLen || restrictedNicknames[nickname] {
-		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nicknameRaw, "Erroneous nickname")
+	if err != nil || len(nicknameRaw) > server.Limits().NickLen || restrictedNicknames[nickname] {
+		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nicknameRaw, client.t("Erroneous nickname"))
+		return false
+	}
+
+	if server.IsReservedNickname(client, nickname) {
+		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nicknameRaw, client.t("Nickname is reserved"))
 		return false
 	}
 
@@ -43,6 +49,31 @@ func nickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		return false
 	}
 
+	accountName, _ := CasefoldName(client.account.Name)
+	if !server.nickHolds.Allowed(nickname, accountName) {
+		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, nicknameRaw, "Nickname is being held for its owner")
+		return false
+	}
+
+	err = server.checkConfusable(nickname, accountName)
+	if err == errNameConfusable {
+		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, nicknameRaw, client.t("Nickname is too similar to a registered account"))
+		return false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nicknameRaw, client.t("Nickname mixes characters from different scripts"))
+		return false
+	}
+
+	// nick changes are sent to all our channels, so don't let them be flooded
+	if client.registered {
+		wait := server.nickChangeWait(client)
+		if 0 < wait {
+			seconds := int((wait + time.Second - 1) / time.Second)
+			client.Send(nil, server.name, ERR_NICKTOOFAST, client.nick, nicknameRaw, fmt.Sprintf(client.t("Nick change too fast. Please wait %d seconds"), seconds))
+			return false
+		}
+	}
+
 	// bleh, this will be replaced and done below
 	if client.registered {
 		err = client.ChangeNickname(nicknameRaw)
@@ -50,13 +81,14 @@ func nickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		err = client.SetNickname(nicknameRaw)
 	}
 	if err == ErrNicknameInUse {
-		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, nicknameRaw, "Nickname is already in use")
+		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, nicknameRaw, client.t("Nickname is already in use"))
 		return false
 	} else if err != nil {
 		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "NICK", fmt.Sprintf("Could not set or change nickname: %s", err.Error()))
 		return false
 	}
 	if client.registered {
+		server.nickChanged(client)
 		client.alertMonitors()
 	}
 	server.tryRegister(client)
@@ -74,12 +106,12 @@ func sanickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	nickname, err := CasefoldName(msg.Params[1])
 
 	if len(nickname) < 1 {
-		client.Send(nil, server.name, ERR_NONICKNAMEGIVEN, client.nick, "No nickname given")
+		client.Send(nil, server.name, ERR_NONICKNAMEGIVEN, client.nick, client.t("No nickname given"))
 		return false
 	}
 
-	if oerr != nil || err != nil || len(strings.TrimSpace(msg.Params[1])) > server.limits.NickLen || restrictedNicknames[nickname] {
-		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, msg.Params[0], "Erroneous nickname")
+	if oerr != nil || err != nil || len(strings.TrimSpace(msg.Params[1])) > server.Limits().NickLen || restrictedNicknames[nickname] {
+		client.Send(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, msg.Params[0], client.t("Erroneous nickname"))
 		return false
 	}
 
@@ -89,13 +121,13 @@ func sanickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	target := server.clients.Get(oldnick)
 	if target == nil {
-		client.Send(nil, server.name, ERR_NOSUCHNICK, msg.Params[0], "No such nick")
+		client.Send(nil, server.name, ERR_NOSUCHNICK, msg.Params[0], client.t("No such nick"))
 		return false
 	}
 
 	//TODO(dan): There's probably some races here, we should be changing this in the primary server thread
 	if server.clients.Get(nickname) != nil && server.clients.Get(nickname) != target {
-		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, msg.Params[0], "Nickname is already in use")
+		client.Send(nil, server.name, ERR_NICKNAMEINUSE, client.nick, msg.Params[0], client.t("Nickname is already in use"))
 		return false
 	}
 
@@ -4,9 +4,12 @@
 package irc
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
 )
 
 // nsHandler handles the /NS and /NICKSERV commands
@@ -19,6 +22,358 @@ func (server *Server) nickservReceiveNotice(client *Client, message string) {
 	// do nothing
 }
 
+// NickServNotice sends the client a notice from NickServ.
+func (client *Client) NickServNotice(text string) {
+	client.Send(nil, fmt.Sprintf("NickServ!services@%s", client.server.name), "NOTICE", client.nick, text)
+}
+
 func (server *Server) nickservReceivePrivmsg(client *Client, message string) {
-	client.Notice("NickServ is not yet implemented, sorry! To register an account, check /HELPOP REG")
+	params := strings.Fields(message)
+	if len(params) < 1 {
+		client.NickServNotice(client.t("NickServ supports IDENTIFY, LOGOUT, INFO, SET, CERT, GHOST, REGAIN and RELEASE so far, sorry! Use /NS HELP <command> for help, and to register an account, check /HELPOP REG"))
+		return
+	}
+
+	command := strings.ToLower(params[0])
+	switch command {
+	case "help":
+		client.serviceHelp("nickserv", params, client.NickServNotice)
+	case "identify":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command identify", client.nick))
+		server.nickservIdentify(client, params)
+	case "info":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command info", client.nick))
+		server.nickservInfo(client, params)
+	case "logout":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command logout", client.nick))
+		server.nickservLogout(client)
+	case "set":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command set", client.nick))
+		server.nickservSet(client, params)
+	case "cert":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command cert", client.nick))
+		server.nickservCert(client, params)
+	case "ghost", "regain", "release":
+		server.logger.LogFields(logger.LogDebug, "nickserv", client.logFields, fmt.Sprintf("Client %s ran command %s", client.nick, command))
+		server.nickservRecover(client, command, params)
+	default:
+		client.NickServNotice(client.t("NickServ supports IDENTIFY, LOGOUT, INFO, SET, CERT, GHOST, REGAIN and RELEASE so far, sorry! Use /NS HELP <command> for help, and to register an account, check /HELPOP REG"))
+	}
+}
+
+// nickservInfo handles NS INFO, which shows details about the given account (by default,
+// the client's own). Its sessions and settings are only shown to the account and opers.
+func (server *Server) nickservInfo(client *Client, params []string) {
+	var accountName string
+	if 1 < len(params) {
+		accountName = params[1]
+	} else if client.account != &NoAccount {
+		accountName = client.account.Name
+	} else {
+		client.NickServNotice(client.t("Syntax: INFO <account>"))
+		return
+	}
+
+	accountKey, err := CasefoldName(accountName)
+	if err != nil {
+		client.NickServNotice(client.t("Account does not exist"))
+		return
+	}
+	// INFO only looks at the account, so offline accounts aren't loaded or kept around
+	var account *ClientAccount
+	err = server.store.View(func(tx *buntdb.Tx) error {
+		// unverified accounts can't be logged into, so they don't really exist yet
+		_, err := tx.Get(fmt.Sprintf(keyAccountVerified, accountKey))
+		if err != nil {
+			return errAccountDoesNotExist
+		}
+		var exists bool
+		account, exists = server.loadedAccount(accountKey)
+		if !exists {
+			account = readAccount(tx, accountKey)
+		}
+		return nil
+	})
+	if err != nil {
+		client.NickServNotice(client.t("Account does not exist"))
+		return
+	}
+
+	lines := []serviceInfoLine{
+		{client.t("Account"), account.Name},
+		{client.t("Registered"), serviceInfoTime(account.RegisteredAt)},
+	}
+	if client.account == account || client.HasMode(Operator) {
+		var nicks []string
+		for _, session := range server.accountClients(account) {
+			nicks = append(nicks, session.nick)
+		}
+		lines = append(lines, serviceInfoLine{client.t("Sessions"), strings.Join(nicks, " ")})
+
+		var settings []string
+		for _, setting := range []struct {
+			name    string
+			enabled bool
+		}{
+			{"REGONLY", account.RegOnly},
+			{"AUTOAWAY", account.AutoAway},
+			{"HIDEIDLE", account.HideIdle},
+			{"NOHISTORY", account.NoHistory},
+		} {
+			if setting.enabled {
+				settings = append(settings, setting.name)
+			}
+		}
+		if len(settings) == 0 {
+			settings = append(settings, client.t("none"))
+		}
+		lines = append(lines, serviceInfoLine{client.t("Settings"), strings.Join(settings, ", ")})
+
+		vhost := account.VHost
+		if vhost != "" && !account.VHostEnabled {
+			vhost = fmt.Sprintf(client.t("%s (disabled)"), vhost)
+		}
+		lines = append(lines, serviceInfoLine{client.t("VHost"), vhost})
+		lines = append(lines, serviceInfoLine{client.t("Languages"), strings.Join(account.Languages, ", ")})
+		lines = append(lines, serviceInfoLine{client.t("Auto-join"), strings.Join(account.AutoJoin, ", ")})
+	}
+
+	header := fmt.Sprintf(client.t("Information on account %s:"), account.Name)
+	for _, line := range formatServiceInfo(header, lines, client.t("End of info")) {
+		client.NickServNotice(line)
+	}
+}
+
+// nickservIdentify handles NS IDENTIFY, which logs the client into the given account
+// (by default, the account named after their current nick).
+func (server *Server) nickservIdentify(client *Client, params []string) {
+	if !server.Config().Accounts.AuthenticationEnabled {
+		client.NickServNotice(client.t("Account authentication is disabled"))
+		return
+	}
+
+	var accountName, passphrase string
+	switch len(params) {
+	case 2:
+		accountName = client.nick
+		passphrase = params[1]
+	case 3:
+		accountName = params[1]
+		passphrase = params[2]
+	default:
+		client.NickServNotice(client.t("Syntax: IDENTIFY [account] <password>"))
+		return
+	}
+
+	account, err := server.checkAccountPassphrase(client, accountName, passphrase)
+	if err == errLoginThrottled {
+		client.NickServNotice(client.t("Too many failed logins, try again later"))
+		return
+	} else if err != nil {
+		client.NickServNotice(client.t("Could not log into that account, check the account name and password"))
+		return
+	}
+	if client.account == account {
+		client.NickServNotice(fmt.Sprintf(client.t("You're already logged into account %s"), account.Name))
+		return
+	}
+
+	// switching accounts, so undo everything the old one set up first
+	if client.account != &NoAccount {
+		client.LogoutOfAccount()
+	}
+	client.LoginToAccount(account)
+	client.Send(nil, server.name, RPL_LOGGEDIN, client.nick, client.nickMaskString, account.Name, fmt.Sprintf(client.t("You are now logged in as %s"), account.Name))
+	client.sendAccountNotify()
+	server.autoJoin(client, account.AutoJoin)
+	server.sendStoredChannelExpiryWarnings(client)
+}
+
+// nickservLogout handles NS LOGOUT, which logs the client out of their account without
+// disconnecting them. Clients who had to log in with SASL to connect can't log out.
+func (server *Server) nickservLogout(client *Client) {
+	if client.account == &NoAccount {
+		client.NickServNotice(client.t("You're not logged into an account"))
+		return
+	}
+	if server.Config().Accounts.RequireSasl.Required(client.listener, client.IP()) {
+		client.NickServNotice(client.t("You can't log out, because this server requires you to be logged in"))
+		return
+	}
+	client.LogoutOfAccount()
+	client.sendAccountNotify()
+}
+
+// nickservSet handles NS SET, which changes the settings of the client's account.
+func (server *Server) nickservSet(client *Client, params []string) {
+	if len(params) < 3 {
+		client.NickServNotice(client.t("Syntax: SET <REGONLY|AUTOAWAY|HIDEIDLE|NOHISTORY> <ON|OFF>, SET WEBHOOK <url|OFF>, SET AUTOJOIN <channels|OFF>, or SET LANGUAGE <code>{ <code>}"))
+		return
+	}
+	if client.account == &NoAccount {
+		client.NickServNotice(client.t("You must be logged into an account to change its settings"))
+		return
+	}
+	switch strings.ToLower(params[1]) {
+	case "webhook":
+		server.nickservSetWebhook(client, params[2])
+		return
+	case "autojoin":
+		server.nickservSetAutoJoin(client, params[2])
+		return
+	case "language":
+		server.nickservSetLanguage(client, params[2:])
+		return
+	}
+	enabled, err := parseConfigBool(params[2])
+	if err != nil {
+		client.NickServNotice(client.t("Syntax: SET <REGONLY|AUTOAWAY|HIDEIDLE|NOHISTORY> <ON|OFF>, SET WEBHOOK <url|OFF>, SET AUTOJOIN <channels|OFF>, or SET LANGUAGE <code>{ <code>}"))
+		return
+	}
+
+	var enabledMessage, disabledMessage string
+	switch strings.ToLower(params[1]) {
+	case "regonly":
+		err = server.setAccountRegOnly(client.account, enabled)
+		enabledMessage = client.t("Only users logged into an account can now send you private messages")
+		disabledMessage = client.t("Anyone can now send you private messages")
+	case "autoaway":
+		if !server.Config().Accounts.AutoAway.Enabled {
+			client.NickServNotice(client.t("Auto-away is not enabled on this server"))
+			return
+		}
+		err = server.setAccountAutoAway(client.account, enabled)
+		enabledMessage = fmt.Sprintf(client.t("You will now be marked as away after being idle for %s"), server.Config().Accounts.AutoAway.IdleTime.String())
+		disabledMessage = client.t("You will no longer be marked as away when you're idle")
+	case "hideidle":
+		err = server.saveAccountSetting(client.account, keyAccountHideIdle, enabled)
+		if err == nil {
+			client.account.HideIdle = enabled
+		}
+		enabledMessage = client.t("Your idle and signon times are now hidden from WHOIS")
+		disabledMessage = client.t("Your idle and signon times are now shown in WHOIS")
+	case "nohistory":
+		err = server.saveAccountSetting(client.account, keyAccountNoHistory, enabled)
+		if err == nil {
+			client.account.NoHistory = enabled
+		}
+		// opting out also gets rid of what we've already stored
+		accountKey, casefoldErr := CasefoldName(client.account.Name)
+		if err == nil && enabled && casefoldErr == nil {
+			server.conversations.Forget(accountKey)
+		}
+		enabledMessage = client.t("Your direct messages will no longer be stored in conversation history, and the ones that were have been forgotten")
+		disabledMessage = client.t("Your direct messages will now be stored in conversation history")
+	default:
+		client.NickServNotice(client.t("Syntax: SET <REGONLY|AUTOAWAY|HIDEIDLE|NOHISTORY> <ON|OFF>, SET WEBHOOK <url|OFF>, SET AUTOJOIN <channels|OFF>, or SET LANGUAGE <code>{ <code>}"))
+		return
+	}
+
+	if err != nil {
+		client.NickServNotice(client.t("Could not save your settings"))
+		server.logger.Error("nickserv", fmt.Sprintf("Could not save %s setting for account %s: %s", strings.ToUpper(params[1]), client.account.Name, err.Error()))
+		return
+	}
+	if enabled {
+		client.NickServNotice(enabledMessage)
+	} else {
+		client.NickServNotice(disabledMessage)
+	}
+}
+
+// nickservRecover handles NS GHOST, NS REGAIN and NS RELEASE, which let users get back
+// nicks that belong to their account (their account name, and the nicks of their other
+// sessions) after a netsplit or a dropped connection leaves a ghost behind.
+func (server *Server) nickservRecover(client *Client, command string, params []string) {
+	if client.account == &NoAccount {
+		client.NickServNotice(fmt.Sprintf(client.t("You must be logged into an account to use %s"), strings.ToUpper(command)))
+		return
+	}
+
+	// the nick defaults to the account name
+	nick := client.account.Name
+	if 1 < len(params) {
+		nick = params[1]
+	}
+	casefoldedNick, err := CasefoldName(nick)
+	if err != nil {
+		client.NickServNotice(client.t("Invalid nickname"))
+		return
+	}
+	casefoldedAccount, _ := CasefoldName(client.account.Name)
+
+	if command == "release" {
+		if server.nickHolds.Release(casefoldedNick, casefoldedAccount) {
+			client.NickServNotice(fmt.Sprintf(client.t("%s is no longer being held for you"), nick))
+		} else {
+			client.NickServNotice(fmt.Sprintf(client.t("%s isn't being held for you"), nick))
+		}
+		return
+	}
+
+	target := server.clients.Get(casefoldedNick)
+	if target == client {
+		client.NickServNotice(client.t("You're already using that nickname"))
+		return
+	}
+	if casefoldedNick != casefoldedAccount && (target == nil || target.account != client.account) {
+		client.NickServNotice(client.t("That nickname doesn't belong to your account"))
+		return
+	}
+	if target == nil && command == "ghost" {
+		client.NickServNotice(client.t("No one is using that nickname"))
+		return
+	}
+
+	if target != nil {
+		target.Quit(fmt.Sprintf("GHOST command used by %s", client.nick))
+		target.destroy()
+		server.logger.Info("nickserv", fmt.Sprintf("Client %s used %s to disconnect %s", client.nick, strings.ToUpper(command), nick))
+	}
+
+	if command == "ghost" {
+		server.nickHolds.Hold(casefoldedNick, casefoldedAccount, nickHoldDuration)
+		client.NickServNotice(fmt.Sprintf(client.t("%s has been disconnected, and the nickname will be held for you for %s"), nick, nickHoldDuration.String()))
+		return
+	}
+
+	// regain
+	server.nickHolds.Release(casefoldedNick, casefoldedAccount)
+	err = client.ChangeNickname(nick)
+	if err != nil {
+		client.NickServNotice(fmt.Sprintf(client.t("Could not change your nickname: %s"), err.Error()))
+		return
+	}
+	client.alertMonitors()
+}
+
+// nickservSetWebhook handles NS SET WEBHOOK, which sets (or with OFF, removes) the URL
+// that push notifications for the client's account are sent to.
+func (server *Server) nickservSetWebhook(client *Client, webhook string) {
+	config := server.Config().Accounts.Push
+	if !config.Enabled {
+		client.NickServNotice(client.t("Push notifications are not enabled on this server"))
+		return
+	}
+	if strings.ToLower(webhook) == "off" {
+		webhook = ""
+	} else {
+		err := checkWebhook(webhook, config.AllowHTTP)
+		if err != nil {
+			client.NickServNotice(client.t(err.Error()))
+			return
+		}
+	}
+
+	err := server.setAccountWebhook(client.account, webhook)
+	if err != nil {
+		client.NickServNotice(client.t("Could not save your settings"))
+		server.logger.Error("nickserv", fmt.Sprintf("Could not save WEBHOOK setting for account %s: %s", client.account.Name, err.Error()))
+		return
+	}
+	if webhook == "" {
+		client.NickServNotice(client.t("Private messages will no longer be pushed to a webhook"))
+	} else {
+		client.NickServNotice(client.t("Private messages sent while you're not connected will now be pushed to your webhook"))
+	}
 }
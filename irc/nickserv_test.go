@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestNickServLogoutRequireSasl(t *testing.T) {
+	server := newTestServer()
+	server.config = &Config{}
+	server.config.Accounts.RequireSasl.Enabled = true
+	server.accounts = make(map[string]*ClientAccount)
+	server.snomasks = NewSnoManager()
+
+	client := newTestClient(server, "alice")
+	account := &ClientAccount{Name: "alice"}
+	client.LoginToAccount(account)
+	sentLines(client)
+
+	server.nickservLogout(client)
+	lines := sentLines(client)
+	if client.account != account {
+		t.Error("expected clients who had to use SASL to stay logged in")
+	}
+	if len(lines) != 1 || !strings.Contains(lines[0], "You can't log out") {
+		t.Errorf("expected the client to be told they can't log out, got %q", lines)
+	}
+
+	server.config.Accounts.RequireSasl.Enabled = false
+	server.nickservLogout(client)
+	if client.account != &NoAccount {
+		t.Error("expected the client to be logged out when SASL isn't required")
+	}
+}
+
+func TestNickServInfoDoesntLoadAccount(t *testing.T) {
+	server := newTestServer()
+	server.accounts = make(map[string]*ClientAccount)
+	server.store, _ = buntdb.Open(":memory:")
+	server.store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyAccountVerified, "bob"), "1", nil)
+		tx.Set(fmt.Sprintf(keyAccountName, "bob"), "Bob", nil)
+		return nil
+	})
+
+	client := newTestClient(server, "alice")
+	server.nickservInfo(client, []string{"INFO", "bob"})
+	lines := sentLines(client)
+	if len(lines) == 0 || !strings.Contains(lines[0], "Information on account Bob") {
+		t.Errorf("expected info on Bob, got %q", lines)
+	}
+	if len(server.accounts) != 0 {
+		t.Error("expected INFO not to load the account")
+	}
+}
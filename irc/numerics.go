@@ -38,6 +38,7 @@ const (
 	RPL_SERVLISTEND                 = "235"
 	RPL_STATSUPTIME                 = "242"
 	RPL_STATSOLINE                  = "243"
+	RPL_STATSCONN                   = "250"
 	RPL_LUSERCLIENT                 = "251"
 	RPL_LUSEROP                     = "252"
 	RPL_LUSERUNKNOWN                = "253"
@@ -50,7 +51,11 @@ const (
 	RPL_TRACELOG                    = "261"
 	RPL_TRACEEND                    = "262"
 	RPL_TRYAGAIN                    = "263"
+	RPL_LOCALUSERS                  = "265"
+	RPL_GLOBALUSERS                 = "266"
 	RPL_WHOISCERTFP                 = "276"
+	RPL_ACCEPTLIST                  = "281"
+	RPL_ENDOFACCEPT                 = "282"
 	RPL_AWAY                        = "301"
 	RPL_USERHOST                    = "302"
 	RPL_ISON                        = "303"
@@ -72,9 +77,13 @@ const (
 	RPL_NOTOPIC                     = "331"
 	RPL_TOPIC                       = "332"
 	RPL_TOPICTIME                   = "333"
+	RPL_WHOISBOT                    = "335"
+	RPL_INVLIST                     = "336"
+	RPL_ENDOFINVLIST                = "337"
 	RPL_WHOISACTUALLY               = "338"
 	RPL_INVITING                    = "341"
 	RPL_SUMMONING                   = "342"
+	RPL_WHOISCOUNTRY                = "344"
 	RPL_INVITELIST                  = "346"
 	RPL_ENDOFINVITELIST             = "347"
 	RPL_EXCEPTLIST                  = "348"
@@ -101,6 +110,7 @@ const (
 	RPL_USERS                       = "393"
 	RPL_ENDOFUSERS                  = "394"
 	RPL_NOUSERS                     = "395"
+	RPL_HOSTHIDDEN                  = "396"
 	ERR_UNKNOWNERROR                = "400"
 	ERR_NOSUCHNICK                  = "401"
 	ERR_NOSUCHSERVER                = "402"
@@ -126,6 +136,7 @@ const (
 	ERR_NICKNAMEINUSE               = "433"
 	ERR_NICKCOLLISION               = "436"
 	ERR_UNAVAILRESOURCE             = "437"
+	ERR_NICKTOOFAST                 = "438"
 	ERR_REG_UNAVAILABLE             = "440"
 	ERR_USERNOTINCHANNEL            = "441"
 	ERR_NOTONCHANNEL                = "442"
@@ -134,6 +145,9 @@ const (
 	ERR_SUMMONDISABLED              = "445"
 	ERR_USERSDISABLED               = "446"
 	ERR_NOTREGISTERED               = "451"
+	ERR_ACCEPTFULL                  = "456"
+	ERR_ACCEPTEXIST                 = "457"
+	ERR_ACCEPTNOT                   = "458"
 	ERR_NEEDMOREPARAMS              = "461"
 	ERR_ALREADYREGISTRED            = "462"
 	ERR_NOPERMFORHOST               = "463"
@@ -160,9 +174,13 @@ const (
 	ERR_HELPNOTFOUND                = "524"
 	ERR_CANNOTSENDRP                = "573"
 	RPL_WHOISSECURE                 = "671"
+	RPL_YOURLANGUAGESARE            = "687"
 	RPL_HELPSTART                   = "704"
 	RPL_HELPTXT                     = "705"
 	RPL_ENDOFHELP                   = "706"
+	ERR_TARGUMODEG                  = "716"
+	RPL_TARGNOTIFY                  = "717"
+	RPL_UMODEGMSG                   = "718"
 	ERR_NOPRIVS                     = "723"
 	RPL_MONONLINE                   = "730"
 	RPL_MONOFFLINE                  = "731"
@@ -187,4 +205,6 @@ const (
 	RPL_REG_VERIFICATION_REQUIRED   = "927"
 	ERR_REG_INVALID_CRED_TYPE       = "928"
 	ERR_REG_INVALID_CALLBACK        = "929"
+	ERR_TOOMANYLANGUAGES            = "981"
+	ERR_NOLANGUAGE                  = "982"
 )
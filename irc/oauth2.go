@@ -0,0 +1,638 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // for the SHA-256 hashes used by RS256 and ES256
+	_ "crypto/sha512" // for the SHA-384 and SHA-512 hashes used by RS384, RS512 and ES384
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// keyOAuth2ToAccount links an issuer and subject to the account they log into.
+	keyOAuth2ToAccount = "account.creds.oauth2 %s %s"
+)
+
+// these are the ways we can check bearer tokens
+const (
+	// OAuth2ValidateJWT checks the token's signature against the issuer's keys,
+	// so it doesn't need to ask the issuer about each login.
+	OAuth2ValidateJWT = "jwt"
+	// OAuth2ValidateUserinfo asks the issuer's userinfo endpoint about the token,
+	// which works for tokens that aren't JWTs.
+	OAuth2ValidateUserinfo = "userinfo"
+)
+
+var (
+	errOAuth2BadConfig    = errors.New("OAuth2 needs an issuer")
+	errOAuth2NoClientID   = errors.New("OAuth2 needs a client-id, so we can check tokens are meant for us")
+	errOAuth2BadValidate  = errors.New("OAuth2 validate-by must be jwt or userinfo")
+	errOAuth2BadResponse  = errors.New("OAuth2 issuer returned an error")
+	errOAuth2BadToken     = errors.New("Token is not valid")
+	errOAuth2UnknownKey   = errors.New("Token is signed with a key we don't know")
+	errOAuth2NoAccount    = errors.New("Token doesn't name an account")
+	errOAuth2NoSubject    = errors.New("Token doesn't have a subject")
+	errOAuth2AccountTaken = errors.New("Account name is already taken")
+	errOAuth2BadBearerMsg = errors.New("Invalid OAUTHBEARER message")
+
+	// oauth2Algorithms are the JWT signing algorithms we accept, and the hashes they use.
+	oauth2Algorithms = map[string]crypto.Hash{
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+		"ES256": crypto.SHA256,
+		"ES384": crypto.SHA384,
+	}
+)
+
+// OAuth2Config controls logging in with bearer tokens from an OpenID Connect issuer,
+// using the OAUTHBEARER SASL mechanism.
+type OAuth2Config struct {
+	Enabled bool
+	Issuer  string
+	// ClientID is the audience tokens must be meant for.
+	ClientID string `yaml:"client-id"`
+	// AccountClaim is the token claim that holds the name to give accounts we create.
+	// Accounts are linked to the token's subject, so this is only used to name them.
+	AccountClaim  string `yaml:"account-claim"`
+	ValidateBy    string `yaml:"validate-by"`
+	Autocreate    bool
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+}
+
+// parse checks the config and fills in its defaults.
+func (conf *OAuth2Config) parse() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Issuer == "" {
+		return errOAuth2BadConfig
+	}
+	if conf.ClientID == "" {
+		return errOAuth2NoClientID
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+	err := checkWebhook(conf.Issuer, false)
+	if err != nil {
+		return err
+	}
+	if conf.AccountClaim == "" {
+		conf.AccountClaim = "preferred_username"
+	}
+	if conf.ValidateBy == "" {
+		conf.ValidateBy = OAuth2ValidateJWT
+	}
+	if conf.ValidateBy != OAuth2ValidateJWT && conf.ValidateBy != OAuth2ValidateUserinfo {
+		return errOAuth2BadValidate
+	}
+	conf.Timeout = defaultOAuth2Timeout
+	if conf.TimeoutString != "" {
+		conf.Timeout, err = time.ParseDuration(conf.TimeoutString)
+		if err != nil {
+			return fmt.Errorf("Could not parse timeout: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// oidcDiscovery is the part of the issuer's discovery document that we use.
+type oidcDiscovery struct {
+	Issuer           string `json:"issuer"`
+	JWKSURI          string `json:"jwks_uri"`
+	UserinfoEndpoint string `json:"userinfo_endpoint"`
+}
+
+// jsonWebKey is a single public key from the issuer's JWKS.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key as one we can check signatures with.
+func (key jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("Unknown curve %s", key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown key type %s", key.Kty)
+}
+
+// OAuth2Identity is who a bearer token says its bearer is.
+type OAuth2Identity struct {
+	// Issuer and Subject identify the user at the issuer, and never change.
+	Issuer  string
+	Subject string
+	// Account is the account name the user goes by at the issuer, which they may be
+	// able to change, so it's only used to name accounts we create for them.
+	Account string
+}
+
+// OAuth2Provider checks bearer tokens against our OpenID Connect issuer. It caches
+// the issuer's discovery document and signing keys, but doesn't hold its lock while
+// fetching them, so a slow issuer doesn't hold up every other login.
+type OAuth2Provider struct {
+	sync.Mutex
+
+	config    OAuth2Config
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	// fetched is when we last fetched the issuer's keys, so tokens signed with
+	// unknown keys can't make us fetch them over and over
+	fetched time.Time
+	logger  *logger.Manager
+}
+
+// NewOAuth2Provider returns a new OAuth2Provider for the given config.
+func NewOAuth2Provider(config OAuth2Config, logger *logger.Manager) *OAuth2Provider {
+	return &OAuth2Provider{
+		config: config,
+		logger: logger,
+	}
+}
+
+// SetConfig changes our config, forgetting what we know about the old issuer.
+func (provider *OAuth2Provider) SetConfig(config OAuth2Config) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	if provider.config.Issuer != config.Issuer {
+		provider.discovery = nil
+		provider.keys = nil
+		provider.fetched = time.Time{}
+	}
+	provider.config = config
+}
+
+// Enabled returns true if bearer tokens can be used to log in.
+func (provider *OAuth2Provider) Enabled() bool {
+	provider.Lock()
+	defer provider.Unlock()
+	return provider.config.Enabled
+}
+
+// getOAuth2JSON fetches the given URL and parses its body as JSON. If token is given,
+// it's sent as a bearer token.
+func getOAuth2JSON(url string, token string, timeout time.Duration, result interface{}) error {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	httpClient := http.Client{
+		Timeout: timeout,
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || 299 < response.StatusCode {
+		return errOAuth2BadResponse
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// getDiscovery returns the given issuer's discovery document, fetching it if we don't
+// have it yet.
+func (provider *OAuth2Provider) getDiscovery(config OAuth2Config) (*oidcDiscovery, error) {
+	provider.Lock()
+	cached := provider.discovery
+	provider.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var discovery oidcDiscovery
+	err := getOAuth2JSON(config.Issuer+"/.well-known/openid-configuration", "", config.Timeout, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch discovery document: %s", err.Error())
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != config.Issuer {
+		return nil, fmt.Errorf("Discovery document is for issuer %s", discovery.Issuer)
+	}
+
+	provider.Lock()
+	// we could've been rehashed to a different issuer while fetching this
+	if provider.config.Issuer == config.Issuer {
+		provider.discovery = &discovery
+	}
+	provider.Unlock()
+	return &discovery, nil
+}
+
+// getKey returns the given issuer's signing key with the given ID, fetching the
+// issuer's keys again if we don't know it.
+func (provider *OAuth2Provider) getKey(config OAuth2Config, kid string) (crypto.PublicKey, error) {
+	provider.Lock()
+	key, exists := provider.keys[kid]
+	// only one login gets to fetch the keys again, the others just fail
+	refetch := !exists && oauth2KeyRefetchDelay <= time.Since(provider.fetched)
+	if refetch {
+		provider.fetched = time.Now()
+	}
+	provider.Unlock()
+	if exists {
+		return key, nil
+	}
+	if !refetch {
+		return nil, errOAuth2UnknownKey
+	}
+
+	discovery, err := provider.getDiscovery(config)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = getOAuth2JSON(discovery.JWKSURI, "", config.Timeout, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch signing keys: %s", err.Error())
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, webKey := range jwks.Keys {
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+		publicKey, err := webKey.publicKey()
+		if err != nil {
+			provider.logger.Debug("accounts", fmt.Sprintf("Skipping OAuth2 signing key %s: %s", webKey.Kid, err.Error()))
+			continue
+		}
+		keys[webKey.Kid] = publicKey
+	}
+
+	provider.Lock()
+	if provider.config.Issuer == config.Issuer {
+		provider.keys = keys
+	}
+	provider.Unlock()
+
+	key, exists = keys[kid]
+	if !exists {
+		return nil, errOAuth2UnknownKey
+	}
+	return key, nil
+}
+
+// verifyJWT checks the token's signature and returns its claims.
+func (provider *OAuth2Provider) verifyJWT(config OAuth2Config, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errOAuth2BadToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, errOAuth2BadToken
+	}
+	hash, known := oauth2Algorithms[header.Alg]
+	if !known {
+		return nil, errOAuth2BadToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errOAuth2BadToken
+	}
+
+	key, err := provider.getKey(config, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(header.Alg, "RS") || rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return nil, errOAuth2BadToken
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(header.Alg, "ES") || len(signature) != 2*size {
+			return nil, errOAuth2BadToken
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return nil, errOAuth2BadToken
+		}
+	default:
+		return nil, errOAuth2BadToken
+	}
+
+	var claims map[string]interface{}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, errOAuth2BadToken
+	}
+	return claims, checkOAuth2Claims(config, claims)
+}
+
+// checkUserinfo makes sure the token's meant for us, then asks the issuer's userinfo
+// endpoint who it's for and returns the answer. The issuer accepting the token means
+// its claims can be trusted without checking its signature, but we still need to read
+// its audience, so the token has to be a JWT.
+func (provider *OAuth2Provider) checkUserinfo(config OAuth2Config, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errOAuth2BadToken
+	}
+	var tokenClaims map[string]interface{}
+	err := decodeJWTPart(parts[1], &tokenClaims)
+	if err != nil {
+		return nil, errOAuth2BadToken
+	}
+	err = checkOAuth2Claims(config, tokenClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	discovery, err := provider.getDiscovery(config)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	err = getOAuth2JSON(discovery.UserinfoEndpoint, token, config.Timeout, &claims)
+	if err != nil {
+		return nil, err
+	}
+	// the answer has to be about the user the token was given to
+	if subject, hasSubject := tokenClaims["sub"]; hasSubject && subject != claims["sub"] {
+		return nil, errOAuth2BadToken
+	}
+	return claims, nil
+}
+
+// decodeJWTPart decodes a base64url-encoded part of a JWT.
+func decodeJWTPart(part string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
+
+// checkOAuth2Claims makes sure a verified token is from our issuer, for us, and current.
+func checkOAuth2Claims(config OAuth2Config, claims map[string]interface{}) error {
+	issuer, _ := claims["iss"].(string)
+	if strings.TrimSuffix(issuer, "/") != config.Issuer {
+		return errOAuth2BadToken
+	}
+
+	var audiences []interface{}
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []interface{}{aud}
+	case []interface{}:
+		audiences = aud
+	}
+	found := false
+	for _, audience := range audiences {
+		if audience == config.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errOAuth2BadToken
+	}
+
+	now := time.Now()
+	expires, hasExpiry := claimTime(claims["exp"])
+	if !hasExpiry || expires.Add(oauth2ClockSkew).Before(now) {
+		return errOAuth2BadToken
+	}
+	notBefore, hasNotBefore := claimTime(claims["nbf"])
+	if hasNotBefore && now.Add(oauth2ClockSkew).Before(notBefore) {
+		return errOAuth2BadToken
+	}
+	return nil
+}
+
+// claimTime returns the time held by a numeric JWT claim such as exp.
+func claimTime(claim interface{}) (time.Time, bool) {
+	number, isNumber := claim.(json.Number)
+	if !isNumber {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// Check returns who the given bearer token says its bearer is.
+func (provider *OAuth2Provider) Check(token string) (OAuth2Identity, error) {
+	provider.Lock()
+	config := provider.config
+	provider.Unlock()
+
+	var claims map[string]interface{}
+	var err error
+	if config.ValidateBy == OAuth2ValidateUserinfo {
+		claims, err = provider.checkUserinfo(config, token)
+	} else {
+		claims, err = provider.verifyJWT(config, token)
+	}
+	if err != nil {
+		return OAuth2Identity{}, err
+	}
+
+	identity := OAuth2Identity{
+		Issuer: config.Issuer,
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Account, _ = claims[config.AccountClaim].(string)
+	if identity.Subject == "" {
+		return OAuth2Identity{}, errOAuth2NoSubject
+	}
+	if identity.Account == "" {
+		return OAuth2Identity{}, errOAuth2NoAccount
+	}
+	return identity, nil
+}
+
+// loadOAuth2Account returns the account linked to the given identity's issuer and
+// subject. If there isn't one and autocreate is true, it creates an account named
+// after the identity and links it. Accounts that already exist are never linked,
+// since anyone who can pick their own name at the issuer could take them over.
+func (server *Server) loadOAuth2Account(identity OAuth2Identity, autocreate bool) (*ClientAccount, error) {
+	linkKey := fmt.Sprintf(keyOAuth2ToAccount, identity.Issuer, identity.Subject)
+	var account *ClientAccount
+	err := server.store.Update(func(tx *buntdb.Tx) error {
+		accountKey, err := tx.Get(linkKey)
+		if err == buntdb.ErrNotFound {
+			if !autocreate {
+				return errAccountDoesNotExist
+			}
+			accountKey, err = CasefoldName(identity.Account)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Get(fmt.Sprintf(keyAccountExists, accountKey))
+			if err == nil {
+				return errOAuth2AccountTaken
+			}
+			err = createExternalAccount(tx, accountKey, identity.Account)
+			if err != nil {
+				return err
+			}
+			tx.Set(linkKey, accountKey, nil)
+			server.accountSkeletons.Add(accountKey)
+			server.logger.Info("accounts", fmt.Sprintf("Created account %s from OAuth2", identity.Account))
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Get(fmt.Sprintf(keyAccountVerified, accountKey))
+		if err != nil {
+			return errSaslFail
+		}
+
+		account = loadAccount(server, tx, accountKey)
+		return nil
+	})
+	return account, err
+}
+
+// parseOAuthBearer returns the authzid and bearer token from an OAUTHBEARER
+// client response (RFC 7628), which looks like this:
+//
+//	n,a=dan,\x01host=irc.example.com\x01auth=Bearer <token>\x01\x01
+func parseOAuthBearer(value []byte) (authzid string, token string, err error) {
+	fields := strings.Split(string(value), "\x01")
+	if len(fields) < 3 || fields[len(fields)-1] != "" || fields[len(fields)-2] != "" {
+		return "", "", errOAuth2BadBearerMsg
+	}
+
+	gs2Header := strings.Split(fields[0], ",")
+	if len(gs2Header) != 3 || (gs2Header[0] != "n" && gs2Header[0] != "y") {
+		return "", "", errOAuth2BadBearerMsg
+	}
+	if gs2Header[1] != "" {
+		if !strings.HasPrefix(gs2Header[1], "a=") {
+			return "", "", errOAuth2BadBearerMsg
+		}
+		authzid = gs2Header[1][2:]
+	}
+
+	for _, field := range fields[1 : len(fields)-2] {
+		if strings.HasPrefix(field, "auth=") {
+			auth := strings.SplitN(field[5:], " ", 2)
+			if len(auth) == 2 && strings.ToLower(auth[0]) == "bearer" {
+				token = auth[1]
+			}
+		}
+	}
+	if token == "" {
+		return "", "", errOAuth2BadBearerMsg
+	}
+	return authzid, token, nil
+}
+
+// authOAuthBearerHandler parses the SASL OAUTHBEARER mechanism.
+func authOAuthBearerHandler(server *Server, client *Client, mechanism string, value []byte) bool {
+	authzid, token, err := parseOAuthBearer(value)
+	if err != nil {
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Invalid auth blob")
+		return false
+	}
+
+	identity, err := server.oauth2.Check(token)
+	if err != nil {
+		server.logger.Debug("accounts", fmt.Sprintf("OAuth2 token rejected: %s", err.Error()))
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
+		return false
+	}
+
+	account, err := server.loadOAuth2Account(identity, server.Config().Accounts.OAuth2.Autocreate)
+	if err == errOAuth2AccountTaken {
+		server.logger.Info("accounts", fmt.Sprintf("OAuth2 user %s can't have an account created, the name %s is taken", identity.Subject, identity.Account))
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Account name is already taken")
+		return false
+	} else if err != nil {
+		client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed")
+		return false
+	}
+	if authzid != "" {
+		authzidKey, err := CasefoldName(authzid)
+		accountKey, _ := CasefoldName(account.Name)
+		if err != nil || authzidKey != accountKey {
+			client.Send(nil, server.name, ERR_SASLFAIL, client.nick, "SASL authentication failed: Token is for a different account")
+			return false
+		}
+	}
+
+	client.LoginToAccount(account)
+	client.successfulSaslAuth()
+	return false
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const testOAuth2Issuer = "https://sso.example.com/realms/irc"
+
+// makeTestJWT returns a JWT with the given claims, signed with the given key.
+func makeTestJWT(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hasher := oauth2Algorithms[alg].New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, oauth2Algorithms[alg], digest)
+	case *ecdsa.PrivateKey:
+		r, s, signErr := ecdsa.Sign(rand.Reader, key, digest)
+		err = signErr
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	if err != nil {
+		t.Fatalf("could not sign test token: %s", err.Error())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testOAuth2Claims(account string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                testOAuth2Issuer,
+		"aud":                []string{"oragono", "other"},
+		"sub":                "f81d4fae-7dec-11d0-a765-00a0c91e6bf6",
+		"preferred_username": account,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestOAuth2JWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := OAuth2Config{
+		Enabled:  true,
+		Issuer:   testOAuth2Issuer,
+		ClientID: "oragono",
+	}
+	err = config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	logman, _ := logger.NewManager()
+	provider := NewOAuth2Provider(config, logman)
+	// pretend we've just fetched the issuer's keys, so we don't try to fetch them
+	provider.keys = map[string]crypto.PublicKey{
+		"rsa": &rsaKey.PublicKey,
+		"ec":  &ecKey.PublicKey,
+	}
+	provider.fetched = time.Now()
+
+	identity, err := provider.Check(makeTestJWT(t, "RS256", "rsa", rsaKey, testOAuth2Claims("dan")))
+	if err != nil || identity.Account != "dan" || identity.Subject != "f81d4fae-7dec-11d0-a765-00a0c91e6bf6" || identity.Issuer != testOAuth2Issuer {
+		t.Errorf("expected RS256 token to be for dan, got %+v %v", identity, err)
+	}
+	identity, err = provider.Check(makeTestJWT(t, "ES256", "ec", ecKey, testOAuth2Claims("shivaram")))
+	if err != nil || identity.Account != "shivaram" {
+		t.Errorf("expected ES256 token to be for shivaram, got %+v %v", identity, err)
+	}
+
+	expired := testOAuth2Claims("dan")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongIssuer := testOAuth2Claims("dan")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := testOAuth2Claims("dan")
+	wrongAudience["aud"] = "someone-else"
+	notYet := testOAuth2Claims("dan")
+	notYet["nbf"] = time.Now().Add(time.Hour).Unix()
+	noAudience := testOAuth2Claims("dan")
+	delete(noAudience, "aud")
+	noSubject := testOAuth2Claims("dan")
+	delete(noSubject, "sub")
+	noAccount := testOAuth2Claims("")
+
+	bad := map[string]string{
+		"expired":        makeTestJWT(t, "RS256", "rsa", rsaKey, expired),
+		"wrong issuer":   makeTestJWT(t, "RS256", "rsa", rsaKey, wrongIssuer),
+		"wrong audience": makeTestJWT(t, "RS256", "rsa", rsaKey, wrongAudience),
+		"not yet valid":  makeTestJWT(t, "RS256", "rsa", rsaKey, notYet),
+		"no audience":    makeTestJWT(t, "RS256", "rsa", rsaKey, noAudience),
+		"no subject":     makeTestJWT(t, "RS256", "rsa", rsaKey, noSubject),
+		"no account":     makeTestJWT(t, "RS256", "rsa", rsaKey, noAccount),
+		"wrong key":      makeTestJWT(t, "RS256", "rsa", otherKey, testOAuth2Claims("dan")),
+		"unknown key":    makeTestJWT(t, "RS256", "nonexistent", rsaKey, testOAuth2Claims("dan")),
+		"key confusion":  makeTestJWT(t, "ES256", "rsa", ecKey, testOAuth2Claims("dan")),
+		"not a jwt":      "opaque-token",
+	}
+	for name, token := range bad {
+		identity, err := provider.Check(token)
+		if err == nil {
+			t.Errorf("expected %s token to be rejected, it was accepted for %+v", name, identity)
+		}
+	}
+}
+
+func TestOAuth2Userinfo(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goodToken, otherAudienceToken string
+
+	var issuer *httptest.Server
+	issuer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcDiscovery{
+				Issuer:           issuer.URL,
+				UserinfoEndpoint: issuer.URL + "/userinfo",
+			})
+		case "/userinfo":
+			authorization := r.Header.Get("Authorization")
+			if authorization != "Bearer "+goodToken && authorization != "Bearer "+otherAudienceToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"sub": "1234", "preferred_username": "dan"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer issuer.Close()
+
+	// trust the test issuer's certificate
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = issuer.Client().Transport
+	defer func() {
+		http.DefaultTransport = defaultTransport
+	}()
+
+	config := OAuth2Config{
+		Enabled:    true,
+		Issuer:     issuer.URL,
+		ClientID:   "oragono",
+		ValidateBy: OAuth2ValidateUserinfo,
+	}
+	err = config.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+	logman, _ := logger.NewManager()
+	provider := NewOAuth2Provider(config, logman)
+
+	// the issuer checks these tokens, so they don't need to be signed with keys we know
+	claims := map[string]interface{}{
+		"iss": issuer.URL,
+		"aud": "oragono",
+		"sub": "1234",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	goodToken = makeTestJWT(t, "RS256", "unknown", key, claims)
+	claims["aud"] = "someone-else"
+	otherAudienceToken = makeTestJWT(t, "RS256", "unknown", key, claims)
+
+	identity, err := provider.Check(goodToken)
+	if err != nil || identity.Account != "dan" || identity.Subject != "1234" {
+		t.Errorf("expected good token to be for dan, got %+v %v", identity, err)
+	}
+	_, err = provider.Check(otherAudienceToken)
+	if err == nil {
+		t.Error("expected token meant for someone else to be rejected, even though the issuer accepts it")
+	}
+	_, err = provider.Check("bad-token")
+	if err == nil {
+		t.Error("expected bad token to be rejected")
+	}
+}
+
+func TestOAuth2AccountLinks(t *testing.T) {
+	server := newTestServer()
+	server.store, _ = buntdb.Open(":memory:")
+	defer server.store.Close()
+	server.accounts = make(map[string]*ClientAccount)
+	server.accountSkeletons = NewSkeletons()
+
+	// a local account that someone at the issuer might try to name themselves after
+	server.store.Update(func(tx *buntdb.Tx) error {
+		return createExternalAccount(tx, "alice", "alice")
+	})
+
+	dan := OAuth2Identity{Issuer: testOAuth2Issuer, Subject: "1234", Account: "dan"}
+	account, err := server.loadOAuth2Account(dan, true)
+	if err != nil || account.Name != "dan" {
+		t.Fatalf("expected account dan to be created, got %+v %v", account, err)
+	}
+
+	// renaming themselves at the issuer doesn't change which account they log into
+	dan.Account = "alice"
+	account, err = server.loadOAuth2Account(dan, true)
+	if err != nil || account.Name != "dan" {
+		t.Errorf("expected the subject to still log into dan, got %+v %v", account, err)
+	}
+
+	mallory := OAuth2Identity{Issuer: testOAuth2Issuer, Subject: "5678", Account: "alice"}
+	_, err = server.loadOAuth2Account(mallory, true)
+	if err != errOAuth2AccountTaken {
+		t.Errorf("expected a new subject not to be linked to an existing account, got %v", err)
+	}
+	mallory.Account = "dan"
+	_, err = server.loadOAuth2Account(mallory, true)
+	if err != errOAuth2AccountTaken {
+		t.Errorf("expected a new subject not to be linked to another subject's account, got %v", err)
+	}
+
+	// the same subject at a different issuer is a different user
+	otherIssuer := OAuth2Identity{Issuer: "https://other.example.com", Subject: "1234", Account: "carol"}
+	_, err = server.loadOAuth2Account(otherIssuer, false)
+	if err != errAccountDoesNotExist {
+		t.Errorf("expected an unlinked subject not to log in without autocreate, got %v", err)
+	}
+}
+
+func TestParseOAuthBearer(t *testing.T) {
+	authzid, token, err := parseOAuthBearer([]byte("n,a=dan,\x01host=irc.example.com\x01port=6697\x01auth=Bearer abc.def.ghi\x01\x01"))
+	if err != nil || authzid != "dan" || token != "abc.def.ghi" {
+		t.Errorf("could not parse OAUTHBEARER message, got %q %q %v", authzid, token, err)
+	}
+	authzid, token, err = parseOAuthBearer([]byte("n,,\x01auth=bearer xyz\x01\x01"))
+	if err != nil || authzid != "" || token != "xyz" {
+		t.Errorf("could not parse OAUTHBEARER message without authzid, got %q %q %v", authzid, token, err)
+	}
+
+	bad := []string{
+		"",
+		"n,,\x01\x01",
+		"n,,\x01auth=Basic xyz\x01\x01",
+		"n,,\x01auth=Bearer xyz\x01",
+		"p=tls-unique,,\x01auth=Bearer xyz\x01\x01",
+		"n,dan,\x01auth=Bearer xyz\x01\x01",
+	}
+	for _, message := range bad {
+		_, _, err := parseOAuthBearer([]byte(message))
+		if err == nil {
+			t.Errorf("expected OAUTHBEARER message %q to be rejected", message)
+		}
+	}
+}
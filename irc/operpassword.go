@@ -0,0 +1,77 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// keyOperPassword stores an oper's password hash, rehashed with our current settings,
+	// along with the hash from the config that it replaces.
+	keyOperPassword = "oper.password %s"
+)
+
+// Passwords returns the password manager that account passwords are hashed with.
+func (server *Server) Passwords() *PasswordManager {
+	server.passwordsMutex.RLock()
+	defer server.passwordsMutex.RUnlock()
+	return server.passwords
+}
+
+// Oper returns the config of the oper with the given (casefolded) name.
+func (server *Server) Oper(name string) (oper Oper, exists bool) {
+	server.passwordsMutex.RLock()
+	defer server.passwordsMutex.RUnlock()
+	oper, exists = server.operators[name]
+	return
+}
+
+// operPasswordHash returns the hash to check the given oper's password against. We can't
+// rewrite the config, so when the password hashing settings change, opers' passwords are
+// rehashed into the datastore as they oper up, and used until their hash in the config
+// is replaced.
+func (server *Server) operPasswordHash(name string, oper Oper) (hash []byte) {
+	server.store.View(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(fmt.Sprintf(keyOperPassword, name))
+		if err != nil {
+			return nil
+		}
+		parts := strings.SplitN(value, " ", 2)
+		if len(parts) == 2 && parts[0] == base64.StdEncoding.EncodeToString(oper.Pass) {
+			hash, _ = base64.StdEncoding.DecodeString(parts[1])
+		}
+		return nil
+	})
+	if hash == nil {
+		return oper.Pass
+	}
+	return hash
+}
+
+// migrateOperPassword rehashes the given oper's password with our current settings, if
+// the hash we checked it against is out of date.
+func (server *Server) migrateOperPassword(name string, oper Oper, hash []byte, password string) {
+	hashing := server.Config().PasswordHashing
+	if !passwordHashOutdated(hashing, hash) {
+		return
+	}
+	newHash, err := hashPassword(hashing, []byte(password))
+	if err == nil {
+		err = server.store.Update(func(tx *buntdb.Tx) error {
+			value := fmt.Sprintf("%s %s", base64.StdEncoding.EncodeToString(oper.Pass), base64.StdEncoding.EncodeToString(newHash))
+			_, _, err := tx.Set(fmt.Sprintf(keyOperPassword, name), value, nil)
+			return err
+		})
+	}
+	if err != nil {
+		server.logger.Error("opers", fmt.Sprintf("Could not rehash password for oper %s: %s", name, err.Error()))
+		return
+	}
+	server.logger.Info("opers", fmt.Sprintf("Rehashed password for oper %s with the current password-hashing settings", name))
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// OpFloodConfig limits how quickly channel members can kick and ban, to slow down
+// scripted mass kicks and channel takeovers.
+type OpFloodConfig struct {
+	Enabled bool
+	// Limit is how many kicks and bans each member can make in a channel in the window.
+	Limit        int
+	WindowString string        `yaml:"window"`
+	Window       time.Duration `yaml:"window-real"`
+	// AlertLimit is how many kicks and bans a member can try in a channel in the window,
+	// including the ones we've refused, before opers are alerted.
+	AlertLimit int `yaml:"alert-limit"`
+}
+
+func (conf *OpFloodConfig) parse() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.WindowString == "" {
+		conf.WindowString = "1m"
+	}
+	conf.Window, err = custime.ParseDuration(conf.WindowString)
+	if err != nil {
+		return fmt.Errorf("Could not parse window: %s", err.Error())
+	}
+	if conf.Limit < 1 {
+		return fmt.Errorf("limit must be at least 1")
+	}
+	if conf.AlertLimit != 0 && conf.AlertLimit < conf.Limit {
+		return fmt.Errorf("alert-limit can't be lower than limit")
+	}
+	return nil
+}
+
+// checkOpAction records a kick or ban that the client is trying to make in the given
+// channel, and returns true if they're allowed to make it.
+func (server *Server) checkOpAction(client *Client, channel *Channel, action string) bool {
+	config := server.Config().Channels.OpFlood
+	if !config.Enabled || client.HasMode(Operator) {
+		return true
+	}
+
+	now := time.Now()
+	actions := client.opActions[channel.nameCasefolded]
+	if actions == nil {
+		// only new channels make the map grow, so this is when we clear out the ones
+		// that haven't seen any kicks or bans lately (including channels we've left)
+		for channelKey, channelActions := range client.opActions {
+			if channelActions.Empty(now, config.Window) {
+				delete(client.opActions, channelKey)
+			}
+		}
+		actions = &eventWindow{}
+		client.opActions[channel.nameCasefolded] = actions
+	}
+
+	count := actions.Add(now, config.Window)
+	if count == config.AlertLimit {
+		server.logger.Warning("channels", fmt.Sprintf("Client %s tried to make %d kicks and bans in %s within %s", client.nickMaskString, count, channel.name, config.Window.String()))
+		server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("$c[grey][$r%s$c[grey]] tried to make %d kicks and bans in $c[grey][$r%s$c[grey]] within %s, their last was a %s"), client.nickMaskString, count, channel.name, config.Window.String(), action))
+	}
+	return count <= config.Limit
+}
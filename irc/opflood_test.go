@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+func TestCheckOpAction(t *testing.T) {
+	logman, _ := logger.NewManager()
+	server := &Server{
+		config:   &Config{},
+		logger:   logman,
+		snomasks: NewSnoManager(),
+	}
+	server.config.Channels.OpFlood = OpFloodConfig{
+		Enabled:    true,
+		Limit:      2,
+		AlertLimit: 4,
+	}
+	err := server.config.Channels.OpFlood.parse()
+	if err != nil {
+		t.Fatalf("could not parse config: %s", err.Error())
+	}
+
+	client := &Client{
+		flags:          map[Mode]bool{},
+		nickMaskString: "dan!~d@localhost",
+		opActions:      make(map[string]*eventWindow),
+	}
+	oper := &Client{
+		flags:     map[Mode]bool{Operator: true},
+		opActions: make(map[string]*eventWindow),
+	}
+	channel := &Channel{name: "#test", nameCasefolded: "#test"}
+	other := &Channel{name: "#other", nameCasefolded: "#other"}
+
+	for i, expected := range []bool{true, true, false, false, false} {
+		if server.checkOpAction(client, channel, "KICK") != expected {
+			t.Errorf("expected action %d to give %t", i+1, expected)
+		}
+		if !server.checkOpAction(oper, channel, "KICK") {
+			t.Error("expected opers to not be limited")
+		}
+	}
+	if !server.checkOpAction(client, other, "ban") {
+		t.Error("expected each channel to have its own limit")
+	}
+
+	recent := server.snomasks.Recent()
+	if len(recent) != 1 || recent[0].Mask != sno.LocalChannels {
+		t.Errorf("expected one alert, got %v", recent)
+	}
+
+	// channels whose windows have passed are forgotten once another channel is used
+	server.config.Channels.OpFlood.Window = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	server.checkOpAction(client, &Channel{name: "#new", nameCasefolded: "#new"}, "KICK")
+	if len(client.opActions) != 1 || client.opActions["#new"] == nil {
+		t.Errorf("expected old channels to be pruned, got %v", client.opActions)
+	}
+}
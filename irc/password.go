@@ -4,28 +4,61 @@
 package irc
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	// ErrEmptyPassword means that an empty password was given.
 	ErrEmptyPassword = errors.New("empty password")
+
+	errBadArgon2Hash = errors.New("invalid argon2id password hash")
+)
+
+const (
+	passwordAlgorithmBcrypt   = "bcrypt"
+	passwordAlgorithmArgon2id = "argon2id"
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+	// argon2MaxMemory (in KiB) and argon2MaxTime stop a hash from taking up too much of
+	// the server's memory and time, whether it's from our config or the datastore.
+	argon2MaxMemory = 1024 * 1024
+	argon2MaxTime   = 16
 )
 
+// argon2idPrefix starts every argon2id hash we make. They're stored in the same
+// format as other argon2 implementations use, so they can be told apart from bcrypt ones:
+//
+//	$argon2id$v=19$m=<memory in KiB>,t=<time>,p=<threads>$<salt>$<key>
+var argon2idPrefix = []byte("$argon2id$")
+
+// argon2Params are the settings used to make an argon2id hash.
+type argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
 // GenerateEncodedPassword returns an encrypted password, encoded into a string with base64.
-func GenerateEncodedPassword(passwd string) (encoded string, err error) {
+func GenerateEncodedPassword(passwd string, hashing PasswordHashingConfig) (encoded string, err error) {
 	if passwd == "" {
 		err = ErrEmptyPassword
 		return
 	}
-	bcrypted, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.MinCost)
+	hashed, err := hashPassword(hashing, []byte(passwd))
 	if err != nil {
 		return
 	}
-	encoded = base64.StdEncoding.EncodeToString(bcrypted)
+	encoded = base64.StdEncoding.EncodeToString(hashed)
 	return
 }
 
@@ -41,5 +74,89 @@ func DecodePasswordHash(encoded string) (decoded []byte, err error) {
 
 // ComparePassword compares a given password with the given hash.
 func ComparePassword(hash, password []byte) error {
+	if bytes.HasPrefix(hash, argon2idPrefix) {
+		params, salt, key, err := parseArgon2idHash(hash)
+		if err != nil {
+			return err
+		}
+		newKey := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, newKey) != 1 {
+			return bcrypt.ErrMismatchedHashAndPassword
+		}
+		return nil
+	}
 	return bcrypt.CompareHashAndPassword(hash, password)
 }
+
+// checkPasswordHashBytes returns an error if the given hash isn't one we can compare passwords against.
+func checkPasswordHashBytes(hash []byte) error {
+	if bytes.HasPrefix(hash, argon2idPrefix) {
+		_, _, _, err := parseArgon2idHash(hash)
+		return err
+	}
+	_, err := bcrypt.Cost(hash)
+	return err
+}
+
+// hashPassword hashes the given password with the given settings.
+func hashPassword(hashing PasswordHashingConfig, password []byte) ([]byte, error) {
+	if hashing.Algorithm != passwordAlgorithmArgon2id {
+		return bcrypt.GenerateFromPassword(password, hashing.BcryptCost)
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	params := hashing.argon2Params()
+	key := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, argon2KeyLen)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Time, params.Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// passwordHashOutdated returns true if the given hash wasn't made with the given
+// settings, meaning the password should be hashed again next time we see it.
+func passwordHashOutdated(hashing PasswordHashingConfig, hash []byte) bool {
+	if bytes.HasPrefix(hash, argon2idPrefix) {
+		if hashing.Algorithm != passwordAlgorithmArgon2id {
+			return true
+		}
+		params, _, _, err := parseArgon2idHash(hash)
+		return err != nil || params != hashing.argon2Params()
+	}
+
+	if hashing.Algorithm != passwordAlgorithmBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != hashing.BcryptCost
+}
+
+// parseArgon2idHash returns the parts of the given argon2id hash.
+func parseArgon2idHash(hash []byte) (params argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != passwordAlgorithmArgon2id {
+		return params, nil, nil, errBadArgon2Hash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errBadArgon2Hash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Time < 1 || argon2MaxTime < params.Time || params.Threads < 1 || argon2MaxMemory < params.Memory {
+		return params, nil, nil, errBadArgon2Hash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < 1 {
+		return params, nil, nil, errBadArgon2Hash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 1 {
+		return params, nil, nil, errBadArgon2Hash
+	}
+	return params, salt, key, nil
+}
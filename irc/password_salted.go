@@ -5,8 +5,6 @@ package irc
 
 import (
 	"crypto/rand"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 const newSaltLen = 30
@@ -27,13 +25,16 @@ func NewSalt() ([]byte, error) {
 
 // PasswordManager supports the hashing and comparing of passwords with the given salt.
 type PasswordManager struct {
-	salt []byte
+	salt    []byte
+	hashing PasswordHashingConfig
 }
 
-// NewPasswordManager returns a new PasswordManager with the given salt.
-func NewPasswordManager(salt []byte) PasswordManager {
+// NewPasswordManager returns a new PasswordManager with the given salt, which hashes
+// new passwords with the given settings.
+func NewPasswordManager(salt []byte, hashing PasswordHashingConfig) PasswordManager {
 	var pwm PasswordManager
 	pwm.salt = salt
+	pwm.hashing = hashing
 	return pwm
 }
 
@@ -51,12 +52,17 @@ func (pwm *PasswordManager) assemblePassword(specialSalt []byte, password string
 // GenerateFromPassword encrypts the given password.
 func (pwm *PasswordManager) GenerateFromPassword(specialSalt []byte, password string) ([]byte, error) {
 	assembledPasswordBytes := pwm.assemblePassword(specialSalt, password)
-	return bcrypt.GenerateFromPassword(assembledPasswordBytes, defaultPasswordCost)
+	return hashPassword(pwm.hashing, assembledPasswordBytes)
 }
 
 // CompareHashAndPassword compares a hashed password with its possible plaintext equivalent.
 // Returns nil on success, or an error on failure.
 func (pwm *PasswordManager) CompareHashAndPassword(hashedPassword []byte, specialSalt []byte, password string) error {
 	assembledPasswordBytes := pwm.assemblePassword(specialSalt, password)
-	return bcrypt.CompareHashAndPassword(hashedPassword, assembledPasswordBytes)
+	return ComparePassword(hashedPassword, assembledPasswordBytes)
+}
+
+// Outdated returns true if the given hash wasn't made with our current settings.
+func (pwm *PasswordManager) Outdated(hashedPassword []byte) bool {
+	return passwordHashOutdated(pwm.hashing, hashedPassword)
 }
@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	bcryptConf := PasswordHashingConfig{Algorithm: "bcrypt", BcryptCost: 4}
+	argonConf := PasswordHashingConfig{Algorithm: "argon2id"}
+	argonConf.Argon2.MemoryString = "64K"
+	for _, conf := range []*PasswordHashingConfig{&bcryptConf, &argonConf} {
+		if err := conf.parse(); err != nil {
+			t.Fatalf("parsing %s settings: %s", conf.Algorithm, err.Error())
+		}
+	}
+
+	for _, conf := range []PasswordHashingConfig{bcryptConf, argonConf} {
+		hash, err := hashPassword(conf, []byte("hunter2"))
+		if err != nil {
+			t.Fatalf("hashing with %s: %s", conf.Algorithm, err.Error())
+		}
+		if err := checkPasswordHashBytes(hash); err != nil {
+			t.Errorf("%s hash %s isn't valid: %s", conf.Algorithm, hash, err.Error())
+		}
+		if err := ComparePassword(hash, []byte("hunter2")); err != nil {
+			t.Errorf("%s hash didn't match its password: %s", conf.Algorithm, err.Error())
+		}
+		if ComparePassword(hash, []byte("hunter3")) == nil {
+			t.Errorf("%s hash matched the wrong password", conf.Algorithm)
+		}
+		if passwordHashOutdated(conf, hash) {
+			t.Errorf("%s hash is outdated with the settings that made it", conf.Algorithm)
+		}
+	}
+
+	bcryptHash, _ := hashPassword(bcryptConf, []byte("hunter2"))
+	if !passwordHashOutdated(argonConf, bcryptHash) {
+		t.Error("bcrypt hash isn't outdated when we use argon2id")
+	}
+	bcryptConf.BcryptCost = 5
+	if !passwordHashOutdated(bcryptConf, bcryptHash) {
+		t.Error("bcrypt hash isn't outdated after the cost changed")
+	}
+	argonHash, _ := hashPassword(argonConf, []byte("hunter2"))
+	argonConf.Argon2.Time = 2
+	if !passwordHashOutdated(argonConf, argonHash) {
+		t.Error("argon2id hash isn't outdated after the time changed")
+	}
+
+	for _, hash := range []string{"$argon2id$v=19$m=64,t=1,p=1$$", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64,t=1000,p=1$c2FsdA$a2V5"} {
+		if checkPasswordHashBytes([]byte(hash)) == nil {
+			t.Errorf("bad hash %s was accepted", hash)
+		}
+	}
+
+	hugeConf := PasswordHashingConfig{Algorithm: "argon2id"}
+	hugeConf.Argon2.MemoryString = "2G"
+	if hugeConf.parse() == nil {
+		t.Error("argon2 memory over the limit was accepted")
+	}
+}
+
+func TestOperPasswordMigration(t *testing.T) {
+	server := newTestServer()
+	server.store, _ = buntdb.Open(":memory:")
+	defer server.store.Close()
+	server.config = &Config{}
+	server.config.PasswordHashing = PasswordHashingConfig{Algorithm: "bcrypt", BcryptCost: 4}
+
+	oldHash, _ := hashPassword(PasswordHashingConfig{Algorithm: "bcrypt", BcryptCost: 5}, []byte("hunter2"))
+	oper := Oper{Pass: oldHash}
+	server.migrateOperPassword("dan", oper, oldHash, "hunter2")
+
+	hash := server.operPasswordHash("dan", oper)
+	if passwordHashOutdated(server.config.PasswordHashing, hash) {
+		t.Error("oper password wasn't rehashed with the current settings")
+	}
+	if ComparePassword(hash, []byte("hunter2")) != nil {
+		t.Error("rehashed oper password doesn't match")
+	}
+
+	// a new hash in the config takes over from the one we stored
+	newHash, _ := hashPassword(server.config.PasswordHashing, []byte("hunter3"))
+	if string(server.operPasswordHash("dan", Oper{Pass: newHash})) != string(newHash) {
+		t.Error("oper's new hash from the config wasn't used")
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// PushWorkers is how many push notifications we send at once.
+	PushWorkers = 4
+	// PushQueueLength is how many push notifications can be waiting for a worker
+	// before we start dropping new ones.
+	PushQueueLength = 256
+
+	// maxPushMentions is how many accounts one channel message can be pushed to.
+	maxPushMentions = 4
+)
+
+var (
+	errPushBadURL      = errors.New("Webhook must be an absolute http or https URL")
+	errPushNeedsHTTPS  = errors.New("Webhook must use https")
+	errPushQueueFull   = errors.New("Push queue is full")
+	errPushBadResponse = errors.New("Webhook returned an error")
+	errPushRedirect    = errors.New("Webhook tried to redirect us")
+	errPushPrivateAddr = errors.New("Webhook is on a private or loopback address")
+
+	// pushPrivateNets are the networks webhooks can't be on unless allow-private is set,
+	// so users can't get us to poke at things that are only reachable from the server.
+	pushPrivateNets, _ = parseNetList([]string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+	})
+)
+
+// PushNotification is what we POST (as JSON) to an account's webhook.
+type PushNotification struct {
+	Account     string    `json:"account"`
+	Type        string    `json:"type"`
+	From        string    `json:"from"`
+	FromAccount string    `json:"from-account,omitempty"`
+	Target      string    `json:"target"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+// pushJob is a push notification waiting to be sent by one of our workers.
+type pushJob struct {
+	webhook      string
+	timeout      time.Duration
+	allowPrivate bool
+	notification PushNotification
+}
+
+// PushPool is a bounded pool of workers that send push notifications to webhooks,
+// so slow webhooks can't eat all our goroutines.
+type PushPool struct {
+	jobs   chan pushJob
+	logger *logger.Manager
+}
+
+// NewPushPool returns a new PushPool, with its workers already running.
+func NewPushPool(workers int, queueLength int, logger *logger.Manager) *PushPool {
+	pool := &PushPool{
+		jobs:   make(chan pushJob, queueLength),
+		logger: logger,
+	}
+	for i := 0; i < workers; i++ {
+		go pool.runWorker()
+	}
+	return pool
+}
+
+// runWorker sends push notifications until our job queue is closed.
+func (pool *PushPool) runWorker() {
+	for job := range pool.jobs {
+		err := sendPushNotification(job)
+		if err != nil {
+			pool.logger.Warning("push", fmt.Sprintf("Could not send push notification for account %s: %s", job.notification.Account, err.Error()))
+		}
+	}
+}
+
+// Push queues the given notification to be sent to the webhook.
+func (pool *PushPool) Push(webhook string, config PushConfig, notification PushNotification) error {
+	select {
+	case pool.jobs <- pushJob{webhook: webhook, timeout: config.Timeout, allowPrivate: config.AllowPrivate, notification: notification}:
+		return nil
+	default:
+		return errPushQueueFull
+	}
+}
+
+// sendPushNotification POSTs a notification to its webhook.
+func sendPushNotification(job pushJob) error {
+	body, err := json.Marshal(job.notification)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{
+		Timeout: job.timeout,
+	}
+	dialContext := dialer.DialContext
+	if !job.allowPrivate {
+		dialContext = dialPublic(dialer)
+	}
+	httpClient := http.Client{
+		Timeout: job.timeout,
+		Transport: &http.Transport{
+			DialContext:       dialContext,
+			DisableKeepAlives: true,
+		},
+		// redirects could send us anywhere, so treat them as errors
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errPushRedirect
+		},
+	}
+	response, err := httpClient.Post(job.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || 299 < response.StatusCode {
+		return errPushBadResponse
+	}
+	return nil
+}
+
+// dialPublic returns a DialContext func that won't connect to private and loopback
+// addresses. It looks the host up itself and dials the addresses it's checked, so the
+// answer can't change between the check and the connection.
+func dialPublic(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		err = errPushPrivateAddr
+		for _, addr := range addrs {
+			checkedAddress := net.JoinHostPort(addr.IP.String(), port)
+			if refusePrivateAddr(checkedAddress) != nil {
+				continue
+			}
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, checkedAddress)
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// refusePrivateAddr stops us connecting to private and loopback addresses.
+func refusePrivateAddr(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil || ipInNets(ip, pushPrivateNets) {
+		return errPushPrivateAddr
+	}
+	return nil
+}
+
+// checkWebhook makes sure the given webhook URL can be used.
+func checkWebhook(webhook string, allowHTTP bool) error {
+	parsed, err := url.Parse(webhook)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return errPushBadURL
+	}
+	if parsed.Scheme == "http" && !allowHTTP {
+		return errPushNeedsHTTPS
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errPushBadURL
+	}
+	return nil
+}
+
+// pushAccount is an account that no clients are logged into, and where to push
+// notifications for it.
+type pushAccount struct {
+	name     string
+	webhook  string
+	autoJoin []string
+}
+
+// loadPushAccount returns the account with the given casefolded name if it has a webhook,
+// no clients are logged into it, and its +R and +g settings let the sender reach it.
+func (server *Server) loadPushAccount(sender *Client, accountKey string) (account pushAccount, ok bool) {
+	if 0 < len(server.loggedInClients(accountKey)) {
+		return account, false
+	}
+
+	var accepted, autoJoin string
+	var regOnly, callerID bool
+	server.store.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(fmt.Sprintf(keyAccountVerified, accountKey))
+		if err != nil {
+			return err
+		}
+		account.name, _ = tx.Get(fmt.Sprintf(keyAccountName, accountKey))
+		account.webhook, _ = tx.Get(fmt.Sprintf(keyAccountWebhook, accountKey))
+		_, err = tx.Get(fmt.Sprintf(keyAccountRegOnly, accountKey))
+		regOnly = err == nil
+		_, err = tx.Get(fmt.Sprintf(keyAccountCallerID, accountKey))
+		callerID = err == nil
+		accepted, _ = tx.Get(fmt.Sprintf(keyAccountAccept, accountKey))
+		autoJoin, _ = tx.Get(fmt.Sprintf(keyAccountAutoJoin, accountKey))
+		return nil
+	})
+	if account.webhook == "" || (regOnly && sender.account == &NoAccount) {
+		return account, false
+	}
+	if callerID && !pushAccepted(sender, strings.Fields(accepted)) {
+		return account, false
+	}
+	account.autoJoin = strings.Fields(autoJoin)
+	return account, true
+}
+
+// newPushNotification returns a notification of the given message for the given account.
+func newPushNotification(sender *Client, account pushAccount, notificationType, target, message string) PushNotification {
+	notification := PushNotification{
+		Account: account.name,
+		Type:    notificationType,
+		From:    sender.nickMaskString,
+		Target:  target,
+		Message: message,
+		Time:    time.Now().UTC(),
+	}
+	if sender.account != &NoAccount {
+		notification.FromAccount = sender.account.Name
+	}
+	return notification
+}
+
+// pushToAccount sends a private message to the webhook of the account with the given
+// name, if no clients are logged into it. It returns true if it's dealt with the
+// message, either by passing it on or by telling the sender why it couldn't.
+func (server *Server) pushToAccount(sender *Client, name string, message string) bool {
+	config := server.Config().Accounts.Push
+	if !config.Enabled {
+		return false
+	}
+	accountKey, err := CasefoldName(name)
+	if err != nil {
+		return false
+	}
+	account, ok := server.loadPushAccount(sender, accountKey)
+	if !ok {
+		return false
+	}
+	if !server.checkMessageHook(sender, "PRIVMSG", account.name, message) {
+		return true
+	}
+
+	notification := newPushNotification(sender, account, "privmsg", account.name, message)
+	err = server.pushes.Push(account.webhook, config, notification)
+	if err != nil {
+		server.logger.Warning("push", fmt.Sprintf("Could not push message for account %s: %s", account.name, err.Error()))
+		return false
+	}
+	sender.Send(nil, server.name, "NOTICE", sender.nick, fmt.Sprintf(sender.t("%s is not connected, but your message has been passed on to them"), account.name))
+	return true
+}
+
+// pushMentions sends a channel message to the webhooks of the accounts it mentions by
+// name, if no clients are logged into them and they auto-join the channel.
+func (server *Server) pushMentions(sender *Client, channel *Channel, message string) {
+	config := server.Config().Accounts.Push
+	if !config.Enabled {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var pushed int
+	for _, word := range strings.Fields(message) {
+		// allow for "nick: hi", "hi @nick!" and so on
+		accountKey, err := CasefoldName(strings.TrimLeft(strings.TrimRight(word, ":,.!?;"), "@"))
+		if err != nil || seen[accountKey] {
+			continue
+		}
+		seen[accountKey] = true
+
+		account, ok := server.loadPushAccount(sender, accountKey)
+		if !ok || !pushAutoJoins(account.autoJoin, channel.nameCasefolded) {
+			continue
+		}
+		notification := newPushNotification(sender, account, "mention", channel.name, message)
+		err = server.pushes.Push(account.webhook, config, notification)
+		if err != nil {
+			server.logger.Warning("push", fmt.Sprintf("Could not push mention for account %s: %s", account.name, err.Error()))
+			return
+		}
+		pushed++
+		if maxPushMentions <= pushed {
+			return
+		}
+	}
+}
+
+// pushAutoJoins returns true if the given auto-join list includes the given channel.
+func pushAutoJoins(autoJoin []string, channelKey string) bool {
+	for _, name := range autoJoin {
+		casefoldedName, err := CasefoldChannel(name)
+		if err == nil && casefoldedName == channelKey {
+			return true
+		}
+	}
+	return false
+}
+
+// pushAccepted returns true if the given client can get through an offline account's
+// caller ID (+g), going by the accounts on its saved ACCEPT list.
+func pushAccepted(sender *Client, accepted []string) bool {
+	if sender.HasMode(Operator) {
+		return true
+	}
+	if sender.account == &NoAccount {
+		return false
+	}
+	senderKey, err := CasefoldName(sender.account.Name)
+	if err != nil {
+		return false
+	}
+	for _, name := range accepted {
+		if name == senderKey {
+			return true
+		}
+	}
+	return false
+}
+
+// setAccountWebhook sets the webhook that the given account's push notifications are
+// sent to, or removes it if webhook is empty.
+func (server *Server) setAccountWebhook(account *ClientAccount, webhook string) error {
+	accountKey, err := CasefoldName(account.Name)
+	if err != nil {
+		return err
+	}
+	return server.store.Update(func(tx *buntdb.Tx) error {
+		key := fmt.Sprintf(keyAccountWebhook, accountKey)
+		if webhook == "" {
+			tx.Delete(key)
+			return nil
+		}
+		_, _, err := tx.Set(key, webhook, nil)
+		return err
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestRefusePrivateAddr(t *testing.T) {
+	testCases := []struct {
+		address string
+		refused bool
+	}{
+		{"127.0.0.1:80", true},
+		{"10.1.2.3:443", true},
+		{"192.168.0.1:443", true},
+		{"169.254.169.254:80", true},
+		{"[::1]:443", true},
+		{"[::ffff:127.0.0.1]:443", true},
+		{"[fd00::1]:443", true},
+		{"8.8.8.8:443", false},
+		{"[2001:4860:4860::8888]:443", false},
+	}
+
+	for _, tt := range testCases {
+		err := refusePrivateAddr(tt.address)
+		if (err != nil) != tt.refused {
+			t.Errorf("expected %s to be refused: %t, got %v", tt.address, tt.refused, err)
+		}
+	}
+}
+
+func TestPushRefusesRedirectsAndPrivateAddrs(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirector.Close()
+
+	job := pushJob{webhook: target.URL, timeout: time.Second}
+	if err := sendPushNotification(job); err == nil {
+		t.Error("expected a webhook on a loopback address to be refused")
+	}
+	job.webhook = strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	if err := sendPushNotification(job); err == nil {
+		t.Error("expected a webhook whose host resolves to a loopback address to be refused")
+	}
+	job.webhook = target.URL
+	job.allowPrivate = true
+	if err := sendPushNotification(job); err != nil {
+		t.Errorf("expected a webhook on a loopback address to work with allow-private, got %v", err)
+	}
+	job.webhook = redirector.URL
+	if err := sendPushNotification(job); err == nil {
+		t.Error("expected a webhook that redirects to be refused")
+	}
+}
+
+func TestPushAccepted(t *testing.T) {
+	server := newTestServer()
+	sender := newTestClient(server, "alice")
+	if pushAccepted(sender, []string{"alice"}) {
+		t.Error("expected clients who aren't logged in not to be accepted")
+	}
+	sender.account = &ClientAccount{Name: "Alice"}
+	if !pushAccepted(sender, []string{"bob", "alice"}) {
+		t.Error("expected accounts on the accept list to be accepted")
+	}
+	if pushAccepted(sender, []string{"bob"}) {
+		t.Error("expected accounts not on the accept list not to be accepted")
+	}
+	sender.SetMode(Operator, true)
+	if !pushAccepted(sender, nil) {
+		t.Error("expected opers to be accepted")
+	}
+}
+
+func TestPushMentions(t *testing.T) {
+	server := newTestServer()
+	server.accounts = make(map[string]*ClientAccount)
+	server.config.Accounts.Push.Enabled = true
+	server.pushes = &PushPool{jobs: make(chan pushJob, 8)}
+	server.store.Update(func(tx *buntdb.Tx) error {
+		for _, name := range []string{"bob", "carol"} {
+			tx.Set(fmt.Sprintf(keyAccountVerified, name), "1", nil)
+			tx.Set(fmt.Sprintf(keyAccountName, name), name, nil)
+			tx.Set(fmt.Sprintf(keyAccountWebhook, name), "https://push.example.com/"+name, nil)
+		}
+		tx.Set(fmt.Sprintf(keyAccountAutoJoin, "bob"), "#Test #other", nil)
+		return nil
+	})
+	channel := NewChannel(server, "#test", false)
+	sender := newTestClient(server, "alice")
+
+	server.pushMentions(sender, channel, "bob: hi, and carol too")
+	select {
+	case job := <-server.pushes.jobs:
+		if job.notification.Account != "bob" || job.notification.Type != "mention" || job.notification.Target != "#test" {
+			t.Errorf("unexpected push notification %+v", job.notification)
+		}
+	default:
+		t.Fatal("expected a mention of bob to be pushed")
+	}
+	// carol doesn't auto-join the channel, so nothing should be pushed to them
+	if len(server.pushes.jobs) != 0 {
+		t.Errorf("expected only one push notification, got %d more", len(server.pushes.jobs))
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// urlRegexp matches the URLs that quit-filter's block-urls option blocks.
+var urlRegexp = regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://|\bwww\.)[^\s]+`)
+
+// parseSpamfilters compiles the quit-filter spamfilters.
+func (conf *QuitFilterConfig) parseSpamfilters() error {
+	conf.Spamfilters = nil
+	for _, raw := range conf.RawSpamfilters {
+		filter, err := regexp.Compile(raw)
+		if err != nil {
+			return fmt.Errorf("Could not parse quit-filter spamfilter [%s]: %s", raw, err.Error())
+		}
+		conf.Spamfilters = append(conf.Spamfilters, filter)
+	}
+	return nil
+}
+
+// Filter returns the given QUIT or PART message with our filters applied. Messages
+// that are blocked are swapped for the replacement message.
+func (conf *QuitFilterConfig) Filter(message string) string {
+	// match against the stripped message, so formatting can't be used to get around
+	// the filters
+	stripped := stripIRCFormatting(message)
+	if conf.BlockURLs && urlRegexp.MatchString(stripped) {
+		return conf.Replacement
+	}
+	for _, filter := range conf.Spamfilters {
+		if filter.MatchString(stripped) {
+			return conf.Replacement
+		}
+	}
+
+	if conf.StripFormatting {
+		return stripped
+	}
+	return message
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestQuitFilter(t *testing.T) {
+	conf := QuitFilterConfig{
+		StripFormatting: true,
+		BlockURLs:       true,
+		RawSpamfilters:  []string{"(?i)join #spam"},
+		Replacement:     "Client quit",
+	}
+	err := conf.parseSpamfilters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]string{
+		"goodbye":                           "goodbye",
+		"\x02bold\x02 goodbye":              "bold goodbye",
+		"see https://example.com/spam":      "Client quit",
+		"visit www.example.com":             "Client quit",
+		"\x02ht\x02tp://example.com":        "Client quit",
+		"JOIN #SPAM now":                    "Client quit",
+		"j\x03oin #spam":                    "Client quit",
+		"the www is big, 3.5 stars at most": "the www is big, 3.5 stars at most",
+	}
+	for input, expected := range testCases {
+		result := conf.Filter(input)
+		if result != expected {
+			t.Errorf("filtering %q: expected %q, got %q", input, expected, result)
+		}
+	}
+
+	conf.RawSpamfilters = []string{"("}
+	if conf.parseSpamfilters() == nil {
+		t.Error("expected an invalid spamfilter to fail")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+// regOnlyBlocks returns true if our +R mode stops the given client from messaging us,
+// because they're not logged into an account. If notify is true, they're told why.
+func (client *Client) regOnlyBlocks(sender *Client, notify bool) bool {
+	if !client.HasMode(UserRegisteredOnly) || client == sender || sender.account != &NoAccount || sender.HasMode(Operator) || client.Accepts(sender) {
+		return false
+	}
+	if notify {
+		sender.Fail("PRIVMSG", "NEED_REGISTERED_ACCOUNT", sender.t("You must be logged into an account to message this user"), client.nick)
+	}
+	return true
+}
+
+// setRegOnly sets or unsets user mode +R, telling the client if they've registered.
+func (client *Client) setRegOnly(enabled bool) {
+	client.setModeAndTell(UserRegisteredOnly, enabled)
+}
+
+// setModeAndTell sets or unsets the given user mode, telling the client if they've registered.
+func (client *Client) setModeAndTell(mode Mode, enabled bool) {
+	if !client.SetMode(mode, enabled) {
+		return
+	}
+	change := ModeChange{
+		mode: mode,
+		op:   Add,
+	}
+	if !enabled {
+		change.op = Remove
+	}
+	if client.registered {
+		client.Send(nil, client.nickMaskString, "MODE", client.nick, change.String())
+	}
+}
+
+// setAccountRegOnly sets whether the given account only takes private messages from other
+// accounts, and updates user mode +R on every client logged into it.
+func (server *Server) setAccountRegOnly(account *ClientAccount, enabled bool) error {
+	err := server.saveAccountSetting(account, keyAccountRegOnly, enabled)
+	if err != nil {
+		return err
+	}
+
+	account.RegOnly = enabled
+	for _, client := range server.accountClients(account) {
+		client.setRegOnly(enabled)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+var (
+	errRequireSaslNoAuth = errors.New("SASL is required for some connections, but account authentication is disabled")
+)
+
+// ipInNets returns true if the given IP is in one of the given networks.
+func ipInNets(ip net.IP, nets []net.IPNet) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetList parses the given list of IPs and CIDR networks. Single IPs are
+// returned as networks that only contain that IP.
+func parseNetList(list []string) ([]net.IPNet, error) {
+	var nets []net.IPNet
+	for _, entry := range list {
+		ipaddr := net.ParseIP(entry)
+		if ipaddr != nil {
+			bits := 128
+			if ipaddr.To4() != nil {
+				ipaddr = ipaddr.To4()
+				bits = 32
+			}
+			nets = append(nets, net.IPNet{IP: ipaddr, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse IP/network [%s]", entry)
+		}
+		nets = append(nets, *network)
+	}
+	return nets, nil
+}
+
+// Required returns true if a client connecting from the given IP to the given
+// listener has to log in with SASL before they can register.
+func (conf *RequireSaslConfig) Required(listener string, ip net.IP) bool {
+	if ip != nil && ipInNets(ip, conf.Exempted) {
+		return false
+	}
+	if conf.Enabled {
+		return true
+	}
+	for _, addr := range conf.Listeners {
+		if addr == listener {
+			return true
+		}
+	}
+	return ip != nil && ipInNets(ip, conf.Networks)
+}
+
+// checkRequireSasl disconnects the client if they have to log in before registering
+// and haven't. It returns true if the client can continue registering.
+func (server *Server) checkRequireSasl(client *Client) bool {
+	if client.account != &NoAccount || !server.Config().Accounts.RequireSasl.Required(client.listener, client.IP()) {
+		return true
+	}
+
+	// lines that are still queued get dropped when we're destroyed, so send these last
+	message := client.t("You must log in with SASL to connect to this server")
+	failMsg := ircmsg.MakeMessage(nil, server.name, "FAIL", "*", "ACCOUNT_REQUIRED", message)
+	failLine, _ := failMsg.Line()
+	errorMsg := ircmsg.MakeMessage(nil, "", "ERROR", message)
+	errorLine, _ := errorMsg.Line()
+	client.QuitWithFinalData(failLine + errorLine)
+	client.destroy()
+	return false
+}
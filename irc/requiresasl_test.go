@@ -0,0 +1,56 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRequireSasl(t *testing.T) {
+	networks, err := parseNetList([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exempted, err := parseNetList([]string{"10.1.2.3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf := RequireSaslConfig{
+		Listeners: []string{":6697"},
+		Networks:  networks,
+		Exempted:  exempted,
+	}
+
+	testCases := []struct {
+		listener string
+		ip       string
+		required bool
+	}{
+		{":6667", "192.168.0.1", false},
+		{":6697", "192.168.0.1", true},
+		{":6667", "10.9.9.9", true},
+		{":6667", "2001:db8::1", true},
+		{":6667", "2001:db8::2", false},
+		{":6697", "10.1.2.3", false},
+	}
+	for _, tt := range testCases {
+		if conf.Required(tt.listener, net.ParseIP(tt.ip)) != tt.required {
+			t.Errorf("expected %s on %s to need SASL: %v", tt.ip, tt.listener, tt.required)
+		}
+	}
+
+	conf.Enabled = true
+	if !conf.Required(":6667", net.ParseIP("192.168.0.1")) {
+		t.Error("expected everyone to need SASL when enabled")
+	}
+	if conf.Required(":6667", net.ParseIP("10.1.2.3")) {
+		t.Error("expected exempted IPs to never need SASL")
+	}
+
+	_, err = parseNetList([]string{"not-an-ip"})
+	if err == nil {
+		t.Error("expected an invalid network to fail")
+	}
+}
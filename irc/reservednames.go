@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ReservedNamesConfig lists nicknames and channel names that regular clients can't use,
+// such as the names of services, staff, or the network itself.
+type ReservedNamesConfig struct {
+	RawNicknames []string         `yaml:"nicknames"`
+	Nicknames    []*regexp.Regexp `yaml:"nicknames-real"`
+	RawChannels  []string         `yaml:"channels"`
+	Channels     []*regexp.Regexp `yaml:"channels-real"`
+	// ExemptOpers lets operators use reserved names.
+	ExemptOpers bool `yaml:"exempt-opers"`
+}
+
+// parse compiles the reserved name patterns.
+func (conf *ReservedNamesConfig) parse() (err error) {
+	conf.Nicknames, err = compileNamePatterns(conf.RawNicknames)
+	if err != nil {
+		return fmt.Errorf("Could not parse nicknames: %s", err.Error())
+	}
+	conf.Channels, err = compileNamePatterns(conf.RawChannels)
+	if err != nil {
+		return fmt.Errorf("Could not parse channels: %s", err.Error())
+	}
+	return nil
+}
+
+// compileNamePatterns compiles the given name patterns, which are either globs (using
+// * and ?) or regexes written between slashes, like /^staff-[0-9]+$/. They match names
+// without caring about case.
+func compileNamePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		var expr string
+		if 2 < len(pattern) && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		} else {
+			expr = regexp.QuoteMeta(pattern)
+			expr = strings.Replace(expr, `\*`, ".*", -1)
+			expr = strings.Replace(expr, `\?`, ".", -1)
+			expr = "^" + expr + "$"
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] is not valid: %s", pattern, err.Error())
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesNamePattern returns true if the name matches any of the given patterns.
+func matchesNamePattern(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReservedNickname returns true if the given client can't use the given nickname.
+func (server *Server) IsReservedNickname(client *Client, nickname string) bool {
+	config := server.Config().ReservedNames
+	if config.ExemptOpers && client.HasMode(Operator) {
+		return false
+	}
+	return matchesNamePattern(config.Nicknames, nickname)
+}
+
+// IsReservedChannel returns true if the given client can't use the given channel name.
+func (server *Server) IsReservedChannel(client *Client, name string) bool {
+	config := server.Config().ReservedNames
+	if config.ExemptOpers && client.HasMode(Operator) {
+		return false
+	}
+	return matchesNamePattern(config.Channels, name)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestReservedNamePatterns(t *testing.T) {
+	patterns, err := compileNamePatterns([]string{"*serv", "staff-?", "/^oragono[0-9]*$/", "#opers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"nickserv":  true,
+		"NickServ":  true,
+		"operserv":  true,
+		"servant":   false,
+		"staff-1":   true,
+		"staff-12":  false,
+		"oragono":   true,
+		"Oragono42": true,
+		"oragonos":  false,
+		"#opers":    true,
+		"#operss":   false,
+		"alice":     false,
+	}
+	for name, expected := range tests {
+		if matchesNamePattern(patterns, name) != expected {
+			t.Errorf("Reserved name matching for %s should be %t", name, expected)
+		}
+	}
+
+	_, err = compileNamePatterns([]string{"/[/"})
+	if err == nil {
+		t.Error("Invalid regex should fail to compile")
+	}
+}
@@ -31,9 +31,10 @@ type restInfoResp struct {
 }
 
 type restStatusResp struct {
-	Clients  int `json:"clients"`
-	Opers    int `json:"opers"`
-	Channels int `json:"channels"`
+	Clients  int        `json:"clients"`
+	Opers    int        `json:"opers"`
+	Channels int        `json:"channels"`
+	Lusers   LUserStats `json:"lusers"`
 }
 
 type restXLinesResp struct {
@@ -61,7 +62,7 @@ func restInfo(w http.ResponseWriter, r *http.Request) {
 	rs := restInfoResp{
 		Version:     SemVer,
 		ServerName:  restAPIServer.name,
-		NetworkName: restAPIServer.networkName,
+		NetworkName: restAPIServer.Config().Network.Name,
 	}
 	b, err := json.Marshal(rs)
 	if err != nil {
@@ -72,10 +73,12 @@ func restInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 func restStatus(w http.ResponseWriter, r *http.Request) {
+	lusers := restAPIServer.LUserStats()
 	rs := restStatusResp{
-		Clients:  restAPIServer.clients.Count(),
-		Opers:    len(restAPIServer.operators),
-		Channels: restAPIServer.channels.Len(),
+		Clients:  lusers.Users,
+		Opers:    lusers.Opers,
+		Channels: lusers.Channels,
+		Lusers:   lusers,
 	}
 	b, err := json.Marshal(rs)
 	if err != nil {
@@ -85,6 +88,15 @@ func restStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func restStats(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(restAPIServer.Stats())
+	if err != nil {
+		fmt.Fprintln(w, restErr)
+	} else {
+		fmt.Fprintln(w, string(b))
+	}
+}
+
 func restGetXLines(w http.ResponseWriter, r *http.Request) {
 	rs := restXLinesResp{
 		DLines: restAPIServer.dlines.AllBans(),
@@ -117,11 +129,7 @@ func restGetAccounts(w http.ResponseWriter, r *http.Request) {
 			regTimeInt, _ := strconv.ParseInt(regTimeStr, 10, 64)
 			regTime := time.Unix(regTimeInt, 0)
 
-			var clients int
-			acct := restAPIServer.accounts[key]
-			if acct != nil {
-				clients = len(acct.Clients)
-			}
+			clients := len(restAPIServer.loggedInClients(key))
 
 			if verified {
 				rs.Verified[key] = restAcct{
@@ -177,6 +185,7 @@ func (s *Server) startRestAPI() {
 	rg := r.Methods("GET").Subrouter()
 	rg.HandleFunc("/info", restInfo)
 	rg.HandleFunc("/status", restStatus)
+	rg.HandleFunc("/stats", restStats)
 	rg.HandleFunc("/xlines", restGetXLines)
 	rg.HandleFunc("/accounts", restGetAccounts)
 
@@ -184,6 +193,11 @@ func (s *Server) startRestAPI() {
 	rp := r.Methods("POST").Subrouter()
 	rp.HandleFunc("/rehash", restRehash)
 
+	// oper dashboard
+	if s.restAPI.Dashboard.Enabled {
+		s.startDashboard(r)
+	}
+
 	// start api
 	go http.ListenAndServe(s.restAPI.Listen, r)
 }
@@ -5,8 +5,10 @@ package irc
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
 )
 
 const (
@@ -14,13 +16,92 @@ const (
 	sceneNickMask = "=Scene=!%s@npc.fakeuser.invalid"
 )
 
+// RoleplaySettings are a channel's roleplaying settings.
+type RoleplaySettings struct {
+	// Sources are the masks that NPC names must match. If there are none, any valid
+	// nickname can be used.
+	Sources []string
+	// NPCLevel is the lowest channel privilege that can use NPC and NPCA. If it's
+	// empty, anyone who can speak in the channel can use them.
+	NPCLevel Mode
+	// History is true if roleplay messages are stored in the channel's history.
+	History bool
+}
+
+// roleplayLevels are the names of the channel privileges that NPCLevel can be set to.
+var roleplayLevels = map[string]Mode{
+	"all":     Mode(0),
+	"voice":   Voice,
+	"halfop":  Halfop,
+	"op":      ChannelOperator,
+	"admin":   ChannelAdmin,
+	"founder": ChannelFounder,
+}
+
+// roleplayLevelName returns the name of the given NPCLevel.
+func roleplayLevelName(level Mode) string {
+	for name, mode := range roleplayLevels {
+		if mode == level {
+			return name
+		}
+	}
+	return "all"
+}
+
+// RoleplaySettings returns the channel's roleplaying settings.
+func (channel *Channel) RoleplaySettings() RoleplaySettings {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+	return channel.roleplay
+}
+
+// SetRoleplaySettings changes the channel's roleplaying settings.
+func (channel *Channel) SetRoleplaySettings(settings RoleplaySettings) {
+	channel.membersMutex.Lock()
+	defer channel.membersMutex.Unlock()
+	channel.setRoleplaySettingsNoMutex(settings)
+}
+
+func (channel *Channel) setRoleplaySettingsNoMutex(settings RoleplaySettings) {
+	// requires Lock()
+	sources := NewUserMaskSet()
+	for _, source := range settings.Sources {
+		sources.Add(source)
+	}
+	channel.roleplay = settings
+	channel.roleplaySources = sources
+}
+
+// checkNPC returns true if the client can send an NPC message with the given source
+// name to this channel, otherwise it tells them why they can't.
+func (channel *Channel) checkNPC(client *Client, fakeSource string) bool {
+	channel.membersMutex.RLock()
+	level := channel.roleplay.NPCLevel
+	sources := channel.roleplaySources
+	hasLevel := level == Mode(0) || channel.clientIsAtLeastNoMutex(client, level)
+	channel.membersMutex.RUnlock()
+
+	if !hasLevel {
+		client.Send(nil, client.server.name, ERR_CANNOTSENDRP, channel.name, client.t("You don't have a high enough channel privilege to use NPC here"))
+		return false
+	}
+	if sources != nil && 0 < len(sources.masks) {
+		casefoldedSource, _ := CasefoldName(fakeSource)
+		if !sources.Match(casefoldedSource) {
+			client.Send(nil, client.server.name, ERR_CANNOTSENDRP, channel.name, client.t("That NPC name isn't allowed in this channel"))
+			return false
+		}
+	}
+	return true
+}
+
 // SCENE <target> <text to be sent>
 func sceneHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	target := msg.Params[0]
 	message := msg.Params[1]
 	sourceString := fmt.Sprintf(sceneNickMask, client.nick)
 
-	sendRoleplayMessage(server, client, sourceString, target, false, message)
+	sendRoleplayMessage(server, client, "SCENE", sourceString, "", target, false, message)
 
 	return false
 }
@@ -39,7 +120,7 @@ func npcHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	sourceString := fmt.Sprintf(npcNickMask, fakeSource, client.nick)
 
-	sendRoleplayMessage(server, client, sourceString, target, false, message)
+	sendRoleplayMessage(server, client, "NPC", sourceString, fakeSource, target, false, message)
 
 	return false
 }
@@ -57,12 +138,20 @@ func npcaHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		return false
 	}
 
-	sendRoleplayMessage(server, client, sourceString, target, true, message)
+	sendRoleplayMessage(server, client, "NPCA", sourceString, fakeSource, target, true, message)
 
 	return false
 }
 
-func sendRoleplayMessage(server *Server, client *Client, source string, targetString string, isAction bool, message string) {
+// sendRoleplayMessage sends a roleplay message from the given source. command is the
+// roleplay command being used, and fakeSource is the NPC's name, or empty for scene
+// messages.
+func sendRoleplayMessage(server *Server, client *Client, command string, source string, fakeSource string, targetString string, isAction bool, message string) {
+	if !server.Config().Roleplay.Enabled {
+		client.Send(nil, server.name, ERR_CANNOTSENDRP, targetString, client.t("Roleplaying commands are disabled on this server"))
+		return
+	}
+
 	if isAction {
 		message = fmt.Sprintf("\x01ACTION %s (%s)\x01", message, client.nick)
 	} else {
@@ -73,12 +162,12 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 	if cerr == nil {
 		channel := server.channels.Get(target)
 		if channel == nil {
-			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, "No such channel")
+			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, client.t("No such channel"))
 			return
 		}
 
 		if !channel.CanSpeak(client) {
-			client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+			client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 			return
 		}
 
@@ -87,6 +176,20 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 			return
 		}
 
+		if fakeSource != "" && !channel.checkNPC(client, fakeSource) {
+			return
+		}
+
+		var allowed bool
+		message, allowed = channel.FilterMessage(client, message)
+		if !allowed {
+			client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Your message was blocked by the channel's word filter"))
+			return
+		}
+		if !server.checkMessageHook(client, command, channel.name, message) {
+			return
+		}
+
 		channel.membersMutex.RLock()
 		for member := range channel.members {
 			if member == client && !client.capabilities[EchoMessage] {
@@ -94,27 +197,57 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 			}
 			member.Send(nil, source, "PRIVMSG", channel.name, message)
 		}
+		saveHistory := channel.roleplay.History
 		channel.membersMutex.RUnlock()
+
+		if saveHistory {
+			channel.history.Add(history.Item{
+				Type:        history.Privmsg,
+				Nick:        source,
+				AccountName: client.account.Name,
+				Msgid:       server.generateMessageID(),
+				Message:     message,
+			})
+		}
 	} else {
 		target, err := CasefoldName(targetString)
 		user := server.clients.Get(target)
 		if err != nil || user == nil {
-			client.Send(nil, server.name, ERR_NOSUCHNICK, target, "No such nick")
+			client.Send(nil, server.name, ERR_NOSUCHNICK, target, client.t("No such nick"))
 			return
 		}
 
-		if !user.flags[UserRoleplaying] {
+		if !user.HasMode(UserRoleplaying) {
 			client.Send(nil, client.server.name, ERR_CANNOTSENDRP, user.nick, "User doesn't have roleplaying mode enabled")
 			return
 		}
+		if !server.checkMessageHook(client, command, user.nick, message) {
+			return
+		}
 
 		user.Send(nil, source, "PRIVMSG", user.nick, message)
 		if client.capabilities[EchoMessage] {
 			client.Send(nil, source, "PRIVMSG", user.nick, message)
 		}
-		if user.flags[Away] {
+		if isAway, awayMessage := user.Away(); isAway {
 			//TODO(dan): possibly implement cooldown of away notifications to users
-			client.Send(nil, server.name, RPL_AWAY, user.nick, user.awayMessage)
+			client.Send(nil, server.name, RPL_AWAY, user.nick, awayMessage)
+		}
+	}
+}
+
+// parseRoleplaySources parses a comma-separated list of NPC name masks, where * on its
+// own allows any name.
+func parseRoleplaySources(value string) []string {
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		source = strings.TrimSpace(source)
+		if source == "*" {
+			return nil
+		}
+		if source != "" {
+			sources = append(sources, source)
 		}
 	}
+	return sources
 }
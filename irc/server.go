@@ -6,25 +6,30 @@
 package irc
 
 import (
-	"bufio"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/goshuirc/irc-go/ircmatch"
 	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/geoip"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/languages"
 	"github.com/oragono/oragono/irc/logger"
 	"github.com/oragono/oragono/irc/sno"
 	"github.com/tidwall/buntdb"
@@ -32,18 +37,22 @@ import (
 
 var (
 	// common error responses
-	tooManyClientsMsg, _   = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "Too many clients from your network")}[0]).Line()
-	couldNotParseIPMsg, _  = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "Unable to parse your IP address")}[0]).Line()
-	bannedFromServerMsg, _ = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "You are banned from this server (%s)")}[0]).Line()
+	tooManyClientsMsg, _      = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "Too many clients from your network")}[0]).Line()
+	tooManyUnregisteredMsg, _ = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "Too many unregistered connections from your address")}[0]).Line()
+	couldNotParseIPMsg, _     = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "Unable to parse your IP address")}[0]).Line()
+	bannedFromServerMsg, _    = (&[]ircmsg.IrcMessage{ircmsg.MakeMessage(nil, "", "ERROR", "You are banned from this server (%s)")}[0]).Line()
 
 	errDbOutOfDate = errors.New("Database schema is old")
 )
 
 // Limits holds the maximum limits for various things such as topic lengths.
 type Limits struct {
+	AcceptEntries  int
 	AwayLen        int
 	ChannelLen     int
 	KickLen        int
+	MaxChannels    int
+	MaxTargets     int
 	MonitorEntries int
 	NickLen        int
 	TopicLen       int
@@ -61,6 +70,9 @@ type LineLenLimits struct {
 type ListenerInterface struct {
 	Listener net.Listener
 	Events   chan ListenerEvent
+
+	// rawListener is the underlying TCP listener, used to hand it off when upgrading
+	rawListener net.Listener
 }
 
 const (
@@ -77,54 +89,97 @@ type ListenerEventType int
 type ListenerEvent struct {
 	Type      ListenerEventType
 	NewConfig *tls.Config
+	// NewWebSocketConfig is set if the listener now takes WebSocket connections.
+	NewWebSocketConfig *WebSocketListenConfig
 }
 
 // Server is the main Oragono server.
 type Server struct {
+	abuseReports                 *AbuseReporter
 	accountAuthenticationEnabled bool
+	acceptedClientsMutex         sync.RWMutex // protects the ACCEPT entries of clients that weren't logged in
 	accountRegistration          *AccountRegistration
 	accounts                     map[string]*ClientAccount
+	accountsMutex                sync.RWMutex // protects accounts, and the Clients of each account
+	accountSkeletons             *Skeletons
+	channelSkeletons             *Skeletons
+	broadcasts                   *BroadcastPool
 	channelRegistrationEnabled   bool
 	channels                     ChannelNameMap
 	channelJoinPartMutex         sync.Mutex // used when joining/parting channels to prevent stomping over each others' access and all
-	checkIdent                   bool
+	aliases                      map[string]*CommandAlias
+	aliasesMutex                 sync.RWMutex
 	clients                      *ClientLookupSet
+	commandStats                 commandStats
 	commands                     chan Command
+	config                       *Config
+	configMutex                  sync.RWMutex // protects config and the settings derived from it, which rehashes and CONFIG SET replace
 	configFilename               string
+	configOverrides              map[string]string
 	connectionLimits             *ConnectionLimits
 	connectionLimitsMutex        sync.Mutex // used when affecting the connection limiter, to make sure rehashing doesn't make things go out-of-whack
 	connectionThrottle           *ConnectionThrottle
 	connectionThrottleMutex      sync.Mutex // used when affecting the connection limiter, to make sure rehashing doesn't make things go out-of-whack
+	conversations                *ConversationHistory
+	externalAuthMutex            sync.Mutex // protects externalAuthRunning
+	externalAuthRunning          int
 	ctime                        time.Time
 	currentOpers                 map[*Client]bool
+	currentOpersMutex            sync.Mutex
+	defaultChannelModes          Modes
+	defaultUserModes             Modes
 	dlines                       *DLineManager
+	dumpSignal                   chan os.Signal
+	geoip                        *geoip.Reader
+	geoipMutex                   sync.RWMutex
+	healthChecks                 chan chan bool
+	healthProbeCache             healthProbeCache
+	help                         *HelpManager
+	hooks                        *Hooks
+	inheritedListeners           map[string]net.Listener
 	isupport                     *ISupportList
 	klines                       *KLineManager
+	languages                    *languages.Manager
+	languagesMutex               sync.RWMutex
 	limits                       Limits
 	listenerEventActMutex        sync.Mutex
 	listeners                    map[string]ListenerInterface
 	listenerUpdateMutex          sync.Mutex
 	logger                       *logger.Manager
+	loginThrottle                *LoginThrottle
+	channelKeyThrottle           *LoginThrottle
+	lookups                      *LookupPool
+	pushes                       *PushPool
 	MaxSendQBytes                uint64
 	monitoring                   map[string][]*Client
 	motdLines                    []string
+	motdMutex                    sync.RWMutex
 	name                         string
 	nameCasefolded               string
-	networkName                  string
 	newConns                     chan clientConn
+	nickHolds                    *NickHolds
+	oauth2                       *OAuth2Provider
 	operators                    map[string]Oper
 	operclasses                  map[string]OperClass
 	password                     []byte
 	passwords                    *PasswordManager
+	passwordsMutex               sync.RWMutex // protects passwords and operators, which rehashes replace
 	registeredChannels           map[string]*RegisteredChannel
 	registeredChannelsMutex      sync.RWMutex
 	rehashMutex                  sync.Mutex
 	rehashSignal                 chan os.Signal
 	restAPI                      *RestAPIConfig
+	shutdownRequests             chan string
 	signals                      chan os.Signal
 	snomasks                     *SnoManager
 	store                        *buntdb.DB
 	stsEnabled                   bool
+	tlsHandshakes                *TLSHandshakeLimiter
+	unregisteredLimits           *UnregisteredLimits
+	upgradeRelease               *os.File
+	upgradeSignal                chan os.Signal
+	userRecords                  userRecordsManager
+	webhooks                     *Webhooks
 	whoWas                       *WhoWasList
 }
 
@@ -138,8 +193,9 @@ var (
 )
 
 type clientConn struct {
-	Conn  net.Conn
-	IsTLS bool
+	Conn     net.Conn
+	IsTLS    bool
+	Listener string
 }
 
 // NewServer returns a new Oragono server.
@@ -156,10 +212,10 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 			return nil, fmt.Errorf("Help entry does not exist for command %s", name)
 		}
 	}
-	// generate help indexes
-	HelpIndex = GenerateHelpIndex(false)
-	HelpIndexOpers = GenerateHelpIndex(true)
-
+	err = checkServiceHelp()
+	if err != nil {
+		return nil, err
+	}
 	if config.Accounts.AuthenticationEnabled {
 		SupportedCapabilities[SASL] = true
 	}
@@ -191,24 +247,45 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 	if err != nil {
 		return nil, fmt.Errorf("Error loading connection throttler: %s", err.Error())
 	}
+	geoipReader, err := openGeoIP(config.Server.GeoIP)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading GeoIP databases: %s", err.Error())
+	}
 
 	server := &Server{
+		abuseReports:                 NewAbuseReporter(config.AbuseReports, logger),
 		accountAuthenticationEnabled: config.Accounts.AuthenticationEnabled,
 		accounts:                     make(map[string]*ClientAccount),
+		accountSkeletons:             NewSkeletons(),
+		channelSkeletons:             NewSkeletons(),
+		aliases:                      config.Aliases,
+		broadcasts:                   NewBroadcastPool(BroadcastWorkers),
 		channelRegistrationEnabled:   config.Channels.Registration.Enabled,
+		defaultChannelModes:          config.Channels.DefaultModes,
+		defaultUserModes:             config.Server.DefaultUserModes,
 		channels:                     *NewChannelNameMap(),
-		checkIdent:                   config.Server.CheckIdent,
 		clients:                      NewClientLookupSet(),
 		commands:                     make(chan Command),
+		config:                       config,
 		configFilename:               configFilename,
+		configOverrides:              make(map[string]string),
 		connectionLimits:             connectionLimits,
 		connectionThrottle:           connectionThrottle,
+		conversations:                NewConversationHistory(config.ConversationLength(), config.History.ConversationsPerAccount),
 		ctime:                        time.Now(),
 		currentOpers:                 make(map[*Client]bool),
+		dumpSignal:                   make(chan os.Signal, 1),
+		geoip:                        geoipReader,
+		healthChecks:                 make(chan chan bool),
+		help:                         NewHelpManager(),
+		hooks:                        NewHooks(config.Hooks, logger),
 		limits: Limits{
+			AcceptEntries:  int(config.Limits.AcceptEntries),
 			AwayLen:        int(config.Limits.AwayLen),
 			ChannelLen:     int(config.Limits.ChannelLen),
 			KickLen:        int(config.Limits.KickLen),
+			MaxChannels:    int(config.Limits.MaxChannels),
+			MaxTargets:     int(config.Limits.MaxTargets),
 			MonitorEntries: int(config.Limits.MonitorEntries),
 			NickLen:        int(config.Limits.NickLen),
 			TopicLen:       int(config.Limits.TopicLen),
@@ -218,24 +295,43 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 				Rest: config.Limits.LineLen.Rest,
 			},
 		},
+		languages:          languages.NewManager(config.Languages.Default, config.Languages.Data),
 		listeners:          make(map[string]ListenerInterface),
 		logger:             logger,
+		loginThrottle:      NewLoginThrottle(config.Accounts.LoginThrottling),
+		channelKeyThrottle: newChannelKeyThrottle(),
+		lookups:            NewLookupPool(LookupWorkers, LookupQueueLength),
 		MaxSendQBytes:      config.Server.MaxSendQBytes,
 		monitoring:         make(map[string][]*Client),
 		name:               config.Server.Name,
 		nameCasefolded:     casefoldedName,
-		networkName:        config.Network.Name,
 		newConns:           make(chan clientConn),
+		nickHolds:          NewNickHolds(),
+		oauth2:             NewOAuth2Provider(config.Accounts.OAuth2, logger),
 		operators:          opers,
 		operclasses:        *operClasses,
+		pushes:             NewPushPool(PushWorkers, PushQueueLength, logger),
 		registeredChannels: make(map[string]*RegisteredChannel),
 		rehashSignal:       make(chan os.Signal, 1),
 		restAPI:            &config.Server.RestAPI,
+		shutdownRequests:   make(chan string, 1),
 		signals:            make(chan os.Signal, len(ServerExitSignals)),
 		snomasks:           NewSnoManager(),
 		stsEnabled:         config.Server.STS.Enabled,
+		tlsHandshakes:      NewTLSHandshakeLimiter(MaxConcurrentTLSHandshakes),
+		unregisteredLimits: NewUnregisteredLimits(config.Server.Unregistered.MaxPerIP),
+		upgradeSignal:      make(chan os.Signal, 1),
+		webhooks:           NewWebhooks(config.Webhooks, logger),
 		whoWas:             NewWhoWasList(config.Limits.WhowasEntries),
 	}
+	server.snomasks.onSend = server.sendSnomaskWebhook
+	server.help.Rebuild(config, server.languages)
+
+	// if we're being upgraded, wait for the old process to close the datastore first
+	err = waitForUpgrade()
+	if err != nil {
+		return nil, err
+	}
 
 	// open data store
 	server.logger.Debug("startup", "Opening datastore")
@@ -265,6 +361,15 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 	server.loadDLines()
 	server.loadKLines()
 
+	// load account and registered channel names, to find lookalikes of them
+	server.logger.Debug("startup", "Loading account and channel skeletons")
+	server.loadAccountSkeletons()
+	server.loadChannelSkeletons()
+
+	// load the most users we've had at once
+	server.logger.Debug("startup", "Loading user records")
+	server.loadUserRecords()
+
 	// load password manager
 	server.logger.Debug("startup", "Loading passwords")
 	err = server.store.View(func(tx *buntdb.Tx) error {
@@ -278,7 +383,7 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 			return err
 		}
 
-		pwm := NewPasswordManager(salt)
+		pwm := NewPasswordManager(salt, config.PasswordHashing)
 		server.passwords = &pwm
 		return nil
 	})
@@ -287,34 +392,33 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 	}
 
 	server.logger.Debug("startup", "Loading MOTD")
-	if config.Server.MOTD != "" {
-		file, err := os.Open(config.Server.MOTD)
-		if err == nil {
-			defer file.Close()
-
-			reader := bufio.NewReader(file)
-			for {
-				line, err := reader.ReadString('\n')
-				if err != nil {
-					break
-				}
-				line = strings.TrimRight(line, "\r\n")
-				// "- " is the required prefix for MOTD, we just add it here to make
-				// bursting it out to clients easier
-				line = fmt.Sprintf("- %s", line)
-
-				server.motdLines = append(server.motdLines, line)
-			}
-		}
-	}
+	server.loadMOTD(config)
 
 	if config.Server.Password != "" {
 		server.password = config.Server.PasswordBytes()
 	}
 
+	// if we've been exec'd by an older version of ourselves, use the listeners it gave us
+	server.inheritedListeners, err = inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+
 	tlsListeners := config.TLSListeners()
 	for _, addr := range config.Server.Listen {
-		server.createListener(addr, tlsListeners)
+		// use the listener handed over to us if we're being upgraded
+		listener := server.inheritedListeners[addr]
+		delete(server.inheritedListeners, addr)
+		err = server.createListener(addr, tlsListeners, config.Server.WebSocketListeners, listener)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// close any handed-over listeners that we no longer want
+	for addr, listener := range server.inheritedListeners {
+		listener.Close()
+		delete(server.inheritedListeners, addr)
 	}
 
 	if len(tlsListeners) == 0 {
@@ -331,73 +435,158 @@ func NewServer(configFilename string, config *Config, logger *logger.Manager) (*
 		server.logger.Warning("startup", "Port 6697 is the standard TLS port for IRC. You should (also) expose port 6697 as a TLS port to ensure clients can connect securely")
 	}
 
-	if config.Server.Wslisten != "" {
-		server.wslisten(config.Server.Wslisten, config.Server.TLSListeners)
-	}
-
 	// registration
 	accountReg := NewAccountRegistration(config.Accounts.Registration)
 	server.accountRegistration = &accountReg
 
-	// Attempt to clean up when receiving these signals.
-	signal.Notify(server.signals, ServerExitSignals...)
-	signal.Notify(server.rehashSignal, syscall.SIGHUP)
-
 	server.setISupport()
 
+	if config.Server.HealthCheck.Enabled {
+		server.startHealthCheck(config.Server.HealthCheck.Listen)
+	}
+
 	// start API if enabled
 	if server.restAPI.Enabled {
 		logger.Info("startup", "server", fmt.Sprintf("%s rest API started on %s.", server.name, server.restAPI.Listen))
 		server.startRestAPI()
 	}
 
+	// expire registered channels that aren't being used
+	go server.channelExpiryLoop()
+
 	return server, nil
 }
 
+// Config returns the config we're currently running with.
+func (server *Server) Config() *Config {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.config
+}
+
+// Limits returns our current limits.
+func (server *Server) Limits() Limits {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.limits
+}
+
+// AccountRegistration returns our current account registration settings.
+func (server *Server) AccountRegistration() *AccountRegistration {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.accountRegistration
+}
+
+// ChannelRegistrationEnabled returns whether channels can currently be registered.
+func (server *Server) ChannelRegistrationEnabled() bool {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.channelRegistrationEnabled
+}
+
+// DefaultChannelModes returns the modes new channels currently get.
+func (server *Server) DefaultChannelModes() Modes {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.defaultChannelModes
+}
+
+// DefaultUserModes returns the modes new clients currently get.
+func (server *Server) DefaultUserModes() Modes {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.defaultUserModes
+}
+
+// ISupport returns our current RPL_ISUPPORT tokens.
+func (server *Server) ISupport() *ISupportList {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.isupport
+}
+
 // setISupport sets up our RPL_ISUPPORT reply.
 func (server *Server) setISupport() {
-	maxTargetsString := strconv.Itoa(maxTargets)
+	server.isupport = generateISupport(server.config, server.limits, server.accountRegistration, server.Languages())
+}
+
+// generateISupport returns the RPL_ISUPPORT tokens for the given config, limits, registration
+// config and languages. Its reply gets cached, so it only needs to be called when these change.
+func generateISupport(config *Config, limits Limits, accountRegistration *AccountRegistration, langManager *languages.Manager) *ISupportList {
+	maxTargetsString := strconv.Itoa(limits.MaxTargets)
 
 	// add RPL_ISUPPORT tokens
-	server.isupport = NewISupportList()
-	server.isupport.Add("AWAYLEN", strconv.Itoa(server.limits.AwayLen))
-	server.isupport.Add("CASEMAPPING", casemappingName)
-	server.isupport.Add("CHANMODES", strings.Join([]string{Modes{BanMask, ExceptMask, InviteMask}.String(), "", Modes{UserLimit, Key}.String(), Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret}.String()}, ","))
-	server.isupport.Add("CHANNELLEN", strconv.Itoa(server.limits.ChannelLen))
-	server.isupport.Add("CHANTYPES", "#")
-	server.isupport.Add("ELIST", "U")
-	server.isupport.Add("EXCEPTS", "")
-	server.isupport.Add("INVEX", "")
-	server.isupport.Add("KICKLEN", strconv.Itoa(server.limits.KickLen))
-	server.isupport.Add("MAXLIST", fmt.Sprintf("beI:%s", strconv.Itoa(server.limits.ChanListModes)))
-	server.isupport.Add("MAXTARGETS", maxTargetsString)
-	server.isupport.Add("MODES", "")
-	server.isupport.Add("MONITOR", strconv.Itoa(server.limits.MonitorEntries))
-	server.isupport.Add("NETWORK", server.networkName)
-	server.isupport.Add("NICKLEN", strconv.Itoa(server.limits.NickLen))
-	server.isupport.Add("PREFIX", "(qaohv)~&@%+")
-	server.isupport.Add("RPCHAN", "E")
-	server.isupport.Add("RPUSER", "E")
-	server.isupport.Add("STATUSMSG", "~&@%+")
-	server.isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:1,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:", maxTargetsString, maxTargetsString, maxTargetsString))
-	server.isupport.Add("TOPICLEN", strconv.Itoa(server.limits.TopicLen))
+	isupport := NewISupportList()
+	isupport.Add("AWAYLEN", strconv.Itoa(limits.AwayLen))
+	isupport.Add("BOT", Bot.String())
+	isupport.Add("CALLERID", CallerID.String())
+	isupport.Add("CASEMAPPING", casemappingName)
+	if 0 < limits.MaxChannels {
+		isupport.Add("CHANLIMIT", fmt.Sprintf("#:%d", limits.MaxChannels))
+	}
+	isupport.Add("CHANMODES", strings.Join([]string{Modes{BanMask, ExceptMask, InviteMask}.String(), "", Modes{UserLimit, Key}.String(), Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret}.String()}, ","))
+	isupport.Add("CHANNELLEN", strconv.Itoa(limits.ChannelLen))
+	isupport.Add("CHANTYPES", "#")
+	if config.History.Enabled {
+		isupport.Add("CHATHISTORY", strconv.Itoa(config.History.ChathistoryMax))
+	}
+	clientTagDeny := config.Server.ClientTags.ISupportValue()
+	if clientTagDeny != "" {
+		isupport.Add("CLIENTTAGDENY", clientTagDeny)
+	}
+	isupport.Add("CNOTICE", "")
+	isupport.Add("CPRIVMSG", "")
+	isupport.Add("ELIST", "U")
+	isupport.Add("EXCEPTS", "")
+	isupport.Add("EXTBAN", extbanPrefix+","+extbanTypes)
+	isupport.Add("INVEX", "")
+	isupport.Add("KICKLEN", strconv.Itoa(limits.KickLen))
+	if 1 < langManager.Count() {
+		isupport.Add("LANGUAGE", fmt.Sprintf("%d,%s", langManager.Count(), strings.Join(langManager.Codes(), ",")))
+	}
+	isupport.Add("MAXLIST", fmt.Sprintf("beI:%s", strconv.Itoa(limits.ChanListModes)))
+	isupport.Add("MAXTARGETS", maxTargetsString)
+	isupport.Add("MODES", "")
+	isupport.Add("MONITOR", strconv.Itoa(limits.MonitorEntries))
+	isupport.Add("NETWORK", config.Network.Name)
+	isupport.Add("NICKLEN", strconv.Itoa(limits.NickLen))
+	isupport.Add("PREFIX", "(qaohv)~&@%+")
+	if config.Roleplay.Enabled {
+		isupport.Add("RPCHAN", "E")
+		isupport.Add("RPUSER", "E")
+	}
+	isupport.Add("STATUSMSG", "~&@%+")
+	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:1,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:", maxTargetsString, maxTargetsString, maxTargetsString))
+	isupport.Add("TOPICLEN", strconv.Itoa(limits.TopicLen))
+	isupport.AddNoValue("WALLCHOPS")
 
 	// account registration
-	if server.accountRegistration.Enabled {
+	if accountRegistration.Enabled {
 		// 'none' isn't shown in the REGCALLBACKS vars
 		var enabledCallbacks []string
-		for _, name := range server.accountRegistration.EnabledCallbacks {
+		for _, name := range accountRegistration.EnabledCallbacks {
 			if name != "*" {
 				enabledCallbacks = append(enabledCallbacks, name)
 			}
 		}
 
-		server.isupport.Add("REGCOMMANDS", "CREATE,VERIFY")
-		server.isupport.Add("REGCALLBACKS", strings.Join(enabledCallbacks, ","))
-		server.isupport.Add("REGCREDTYPES", "passphrase,certfp")
+		isupport.Add("REGCOMMANDS", "CREATE,VERIFY")
+		isupport.Add("REGCALLBACKS", strings.Join(enabledCallbacks, ","))
+		isupport.Add("REGCREDTYPES", "passphrase,certfp")
+	}
+
+	// custom tokens from the config
+	for name, value := range config.Server.ISupport {
+		if value == nil {
+			isupport.AddNoValue(name)
+		} else {
+			isupport.Add(name, *value)
+		}
 	}
 
-	server.isupport.RegenerateCachedReply()
+	isupport.RegenerateCachedReply()
+	return isupport
 }
 
 func loadChannelList(channel *Channel, list string, maskMode Mode) {
@@ -407,18 +596,75 @@ func loadChannelList(channel *Channel, list string, maskMode Mode) {
 	channel.lists[maskMode].AddAll(strings.Split(list, " "))
 }
 
-// Shutdown shuts down the server.
-func (server *Server) Shutdown() {
+// Shutdown gracefully shuts down the server. Clients are told about it, we stop accepting
+// new connections, and remaining clients are disconnected after the drain period.
+func (server *Server) Shutdown(reason string) {
+	config := server.Config()
+	message := config.Server.Shutdown.Message
+	if reason != "" {
+		message = fmt.Sprintf("%s (%s)", message, reason)
+	}
+	server.logger.Info("shutdown", fmt.Sprintf("Shutting down: %s", message))
+	sdNotify("STOPPING=1")
+
+	// SIGHUP rehashes happen in the background, so wait for any that's going on and
+	// stop new ones from binding listeners or touching the datastore after we close them
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
 	//TODO(dan): Make sure we disallow new nicks
-	server.clients.ByNickMutex.RLock()
-	for _, client := range server.clients.ByNick {
-		client.Notice("Server is shutting down")
+	for client := range server.clients.All() {
+		client.Notice(message)
+	}
+
+	// stop accepting new connections
+	server.listenerEventActMutex.Lock()
+	for _, listener := range server.listeners {
+		listener.Events <- ListenerEvent{
+			Type: DestroyListener,
+		}
+		listener.Listener.Close()
+	}
+	server.listenerEventActMutex.Unlock()
+
+	// give clients a little while to disconnect by themselves
+	if 0 < config.Server.Shutdown.DrainTime {
+		time.Sleep(config.Server.Shutdown.DrainTime)
+	}
+
+	// disconnect everyone that's left
+	remaining := server.clients.All()
+	for client := range remaining {
+		client.Quit(message)
+		client.destroy()
+	}
+	if 0 < len(remaining) {
+		// let the final QUIT and ERROR lines make it out
+		time.Sleep(time.Second)
 	}
-	server.clients.ByNickMutex.RUnlock()
 
+	server.hooks.Stop()
+	server.sendWebhook(WebhookShutdown, message, nil)
+	server.webhooks.Wait(webhookShutdownWait)
 	if err := server.store.Close(); err != nil {
 		server.logger.Error("shutdown", fmt.Sprintln("Could not close datastore:", err))
 	}
+	server.releaseUpgrade()
+}
+
+// HandleSignals makes the server shut down, rehash, upgrade and dump its state when it
+// gets the matching signals. Servers run inside other programs (like our tests) leave
+// this out, so they don't take over the process's signals.
+func (server *Server) HandleSignals() {
+	// Attempt to clean up when receiving these signals.
+	signal.Notify(server.signals, ServerExitSignals...)
+	signal.Notify(server.rehashSignal, syscall.SIGHUP)
+	if 0 < len(ServerUpgradeSignals) {
+		signal.Notify(server.upgradeSignal, ServerUpgradeSignals...)
+	}
+	if 0 < len(ServerDumpSignals) {
+		signal.Notify(server.dumpSignal, ServerDumpSignals...)
+	}
 }
 
 // Run starts the server.
@@ -426,20 +672,73 @@ func (server *Server) Run() {
 	// defer closing db/store
 	defer server.store.Close()
 
+	// our listeners are up, so let systemd know we're ready
+	err := sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
+	if err != nil {
+		server.logger.Warning("startup", fmt.Sprintf("Could not notify systemd that we're ready: %s", err.Error()))
+	}
+	server.sendWebhook(WebhookStartup, fmt.Sprintf("Server %s started", server.name), nil)
+
+	// pet the systemd watchdog from this loop, so it restarts us if we stop handling events
+	var watchdog <-chan time.Time
+	if interval := sdWatchdogInterval(); interval != 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
 	done := false
 	for !done {
 		select {
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+
+		case answer := <-server.healthChecks:
+			answer <- true
+
 		case <-server.signals:
-			server.Shutdown()
+			server.Shutdown("")
 			done = true
 
-		case <-server.rehashSignal:
-			server.logger.Info("rehash", "Rehashing due to SIGHUP")
-			err := server.rehash()
+		case reason := <-server.shutdownRequests:
+			server.Shutdown(reason)
+			done = true
+
+		case <-server.upgradeSignal:
+			server.logger.Info("upgrade", "Upgrading due to signal")
+			err := server.upgrade()
 			if err != nil {
-				server.logger.Error("rehash", fmt.Sprintln("Failed to rehash:", err.Error()))
+				server.logger.Error("upgrade", fmt.Sprintln("Failed to upgrade:", err.Error()))
+			} else {
+				// the new process is accepting connections now, so let our clients move over to it
+				server.Shutdown("Server is being upgraded, please reconnect")
+				done = true
 			}
 
+		case <-server.rehashSignal:
+			// rehash in the background so we keep accepting connections while it happens
+			go func() {
+				server.logger.Info("rehash", "Rehashing due to SIGHUP")
+				err := server.rehash()
+				if err != nil {
+					server.logger.Error("rehash", fmt.Sprintln("Failed to rehash:", err.Error()))
+					server.snomasks.Send(sno.LocalOpers, fmt.Sprintf("Failed to rehash due to SIGHUP: %s", err.Error()))
+				} else {
+					server.snomasks.Send(sno.LocalOpers, "Rehashed due to SIGHUP")
+				}
+			}()
+
+		case <-server.dumpSignal:
+			// the server may be stuck, so don't let the dump hold up anything else
+			go func() {
+				filename, err := server.writeStateDump()
+				if err != nil {
+					server.logger.Error("server", fmt.Sprintln("Failed to write state dump:", err.Error()))
+				} else {
+					server.logger.Info("server", fmt.Sprintf("Wrote state dump to %s", filename))
+				}
+			}()
+
 		case conn := <-server.newConns:
 			// check connection limits
 			ipaddr := net.ParseIP(IPString(conn.Conn.RemoteAddr()))
@@ -461,8 +760,9 @@ func (server *Server) Run() {
 			}
 
 			// check connection limits
+			location := server.lookupLocation(ipaddr)
 			server.connectionLimitsMutex.Lock()
-			err := server.connectionLimits.AddClient(ipaddr, false)
+			err := server.connectionLimits.AddClient(ipaddr, location, false)
 			server.connectionLimitsMutex.Unlock()
 			if err != nil {
 				// too many connections from one client, tell the client and close the connection
@@ -474,7 +774,7 @@ func (server *Server) Run() {
 
 			// check connection throttle
 			server.connectionThrottleMutex.Lock()
-			err = server.connectionThrottle.AddClient(ipaddr)
+			err = server.connectionThrottle.AddClient(ipaddr, location)
 			server.connectionThrottleMutex.Unlock()
 			if err != nil {
 				// too many connections too quickly from client, tell them and close the connection
@@ -493,10 +793,23 @@ func (server *Server) Run() {
 				continue
 			}
 
+			// check unregistered connection limits
+			err = server.unregisteredLimits.Add(ipaddr)
+			if err != nil {
+				conn.Conn.Write([]byte(tooManyUnregisteredMsg))
+				conn.Conn.Close()
+
+				server.connectionLimitsMutex.Lock()
+				server.connectionLimits.RemoveClient(ipaddr)
+				server.connectionLimitsMutex.Unlock()
+				continue
+			}
+
 			server.logger.Debug("localconnect-ip", fmt.Sprintf("Client connecting from %v", ipaddr))
+			server.connectionReceived()
 			// prolly don't need to alert snomasks on this, only on connection reg
 
-			go NewClient(server, conn.Conn, conn.IsTLS)
+			go server.handshakeAndCreateClient(conn, ipaddr, location)
 			continue
 		}
 	}
@@ -506,23 +819,50 @@ func (server *Server) Run() {
 // IRC protocol listeners
 //
 
-// createListener starts the given listeners.
-func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config) {
+// handshakeAndCreateClient completes the TLS handshake for the given connection (if
+// required) and then creates the new client.
+func (server *Server) handshakeAndCreateClient(conn clientConn, ipaddr net.IP, location geoip.Record) {
+	if conn.IsTLS {
+		err := server.tlsHandshakes.Handshake(conn.Conn)
+		if err != nil {
+			server.logger.Debug("localconnect-ip", fmt.Sprintf("TLS handshake from %v failed: %s", ipaddr, err.Error()))
+			conn.Conn.Close()
+
+			server.connectionLimitsMutex.Lock()
+			server.connectionLimits.RemoveClient(ipaddr)
+			server.connectionLimitsMutex.Unlock()
+			server.unregisteredLimits.Remove(ipaddr)
+			return
+		}
+	}
+
+	NewClient(server, conn.Conn, conn.IsTLS, conn.Listener, location)
+}
+
+// createListener starts the given listener. If listener is nil, we bind the address ourselves.
+func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config, wsMap map[string]*WebSocketListenConfig, listener net.Listener) error {
 	config, listenTLS := tlsMap[addr]
+	wsConfig := wsMap[addr]
 
+	server.listenerUpdateMutex.Lock()
 	_, alreadyExists := server.listeners[addr]
+	server.listenerUpdateMutex.Unlock()
 	if alreadyExists {
-		log.Fatal(server, "listener already exists:", addr)
+		return fmt.Errorf("Listener already exists: %s", addr)
 	}
 
 	// make listener event channel
 	listenerEventChannel := make(chan ListenerEvent, 1)
 
 	// make listener
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatal(server, "listen error: ", err)
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("Could not listen on %s: %s", addr, err.Error())
+		}
 	}
+	rawListener := listener
 
 	tlsString := "plaintext"
 	if listenTLS {
@@ -530,13 +870,20 @@ func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config)
 		listener = tls.NewListener(listener, config)
 		tlsString = "TLS"
 	}
+	if wsConfig != nil {
+		listener = newWebSocketListener(server, listener, addr, wsConfig)
+		tlsString += " WebSockets"
+	}
 
 	// throw our details to the server so we can be modified/killed later
 	li := ListenerInterface{
-		Events:   listenerEventChannel,
-		Listener: listener,
+		Events:      listenerEventChannel,
+		Listener:    listener,
+		rawListener: rawListener,
 	}
+	server.listenerUpdateMutex.Lock()
 	server.listeners[addr] = li
+	server.listenerUpdateMutex.Unlock()
 
 	// start listening
 	server.logger.Info("listeners", fmt.Sprintf("listening on %s using %s.", addr, tlsString))
@@ -548,15 +895,16 @@ func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config)
 
 			if err == nil {
 				newConn := clientConn{
-					Conn:  conn,
-					IsTLS: listenTLS,
+					Conn:     conn,
+					IsTLS:    listenTLS,
+					Listener: addr,
 				}
 
 				server.newConns <- newConn
 			}
 
 			select {
-			case event := <-server.listeners[addr].Events:
+			case event := <-listenerEventChannel:
 				// this is used to confirm that whoever passed us this event has closed the existing listener correctly (in an attempt to get us to notice the event).
 				// this is required to keep REHASH from having a very small race possibility of killing the primary listener
 				server.listenerEventActMutex.Lock()
@@ -573,19 +921,31 @@ func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config)
 					// make new listener
 					listener, err = net.Listen("tcp", addr)
 					if err != nil {
-						log.Fatal(server, "listen error: ", err)
+						// give up on this address rather than taking the whole server down
+						server.logger.Error("listeners", fmt.Sprintf("Could not re-listen on %s, closing listener: %s", addr, err.Error()))
+						server.listenerUpdateMutex.Lock()
+						delete(server.listeners, addr)
+						server.listenerUpdateMutex.Unlock()
+						return
 					}
+					rawListener = listener
 
 					tlsString := "plaintext"
-					if event.NewConfig != nil {
+					listenTLS = event.NewConfig != nil
+					if listenTLS {
 						config = event.NewConfig
 						config.ClientAuth = tls.RequestClientCert
 						listener = tls.NewListener(listener, config)
 						tlsString = "TLS"
 					}
+					if event.NewWebSocketConfig != nil {
+						listener = newWebSocketListener(server, listener, addr, event.NewWebSocketConfig)
+						tlsString += " WebSockets"
+					}
 
 					// update server ListenerInterface
 					li.Listener = listener
+					li.rawListener = rawListener
 					server.listenerUpdateMutex.Lock()
 					server.listeners[addr] = li
 					server.listenerUpdateMutex.Unlock()
@@ -598,57 +958,8 @@ func (server *Server) createListener(addr string, tlsMap map[string]*tls.Config)
 			}
 		}
 	}()
-}
 
-//
-// websocket listen goroutine
-//
-
-func (server *Server) wslisten(addr string, tlsMap map[string]*TLSListenConfig) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			server.logger.Error("ws", addr, fmt.Sprintf("%s method not allowed", r.Method))
-			return
-		}
-
-		// We don't have any subprotocols, so if someone attempts to `new
-		// WebSocket(server, "subprotocol")` they'll break here, instead of
-		// getting the default, ambiguous, response from gorilla.
-		if v, ok := r.Header["Sec-Websocket-Protocol"]; ok {
-			http.Error(w, fmt.Sprintf("WebSocket subprocotols (e.g. %s) not supported", v), 400)
-		}
-
-		ws, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			server.logger.Error("ws", addr, fmt.Sprintf("%s websocket upgrade error: %s", server.name, err))
-			return
-		}
-
-		newConn := clientConn{
-			Conn:  WSContainer{ws},
-			IsTLS: false, //TODO(dan): track TLS or not here properly
-		}
-		server.newConns <- newConn
-	})
-	go func() {
-		config, listenTLS := tlsMap[addr]
-
-		tlsString := "plaintext"
-		var err error
-		if listenTLS {
-			tlsString = "TLS"
-		}
-		server.logger.Info("listeners", fmt.Sprintf("websocket listening on %s using %s.", addr, tlsString))
-
-		if listenTLS {
-			err = http.ListenAndServeTLS(addr, config.Cert, config.Key, nil)
-		} else {
-			err = http.ListenAndServe(addr, nil)
-		}
-		if err != nil {
-			server.logger.Error("listeners", fmt.Sprintf("listenAndServe error [%s]: %s", tlsString, err))
-		}
-	}()
+	return nil
 }
 
 // generateMessageID returns a network-unique message ID.
@@ -673,17 +984,42 @@ func (server *Server) tryRegister(c *Client) {
 		if info.Time != nil {
 			reason += fmt.Sprintf(" [%s]", info.Time.Duration.String())
 		}
-		c.Send(nil, "", "ERROR", fmt.Sprintf("You are banned from this server (%s)", reason))
-		c.quitMessageSent = true
+		errorMsg := ircmsg.MakeMessage(nil, "", "ERROR", fmt.Sprintf("You are banned from this server (%s)", reason))
+		errorLine, _ := errorMsg.Line()
+		c.QuitWithFinalData(errorLine)
 		c.destroy()
 		return
 	}
 
+	// log in with their certificate if they haven't already used SASL
+	if server.Config().Accounts.AuthenticationEnabled && server.Config().Accounts.CertfpAutoLogin && c.account == &NoAccount && c.certfp != "" {
+		err := c.loginByCertfp()
+		if err == nil {
+			c.Send(nil, server.name, RPL_LOGGEDIN, c.nick, c.nickMaskString, c.account.Name, fmt.Sprintf(c.t("You are now logged in as %s"), c.account.Name))
+		}
+	}
+
+	if !server.checkRequireSasl(c) {
+		return
+	}
+	if !server.checkRegisterHook(c) {
+		return
+	}
+
 	// continue registration
-	server.logger.Debug("localconnect", fmt.Sprintf("Client registered [%s] [u:%s] [r:%s]", c.nick, c.username, c.realname))
-	server.snomasks.Send(sno.LocalConnects, fmt.Sprintf(ircfmt.Unescape("Client registered $c[grey][$r%s$c[grey]] [u:$r%s$c[grey]] [h:$r%s$c[grey]] [r:$r%s$c[grey]]"), c.nick, c.username, c.rawHostname, c.realname))
+	server.logger.LogFields(logger.LogDebug, "localconnect", c.logFields, fmt.Sprintf("Client registered [%s] [u:%s] [r:%s]", c.nick, c.username, c.realname))
+	if c.location.IsEmpty() {
+		server.snomasks.Send(sno.LocalConnects, fmt.Sprintf(ircfmt.Unescape("Client registered $c[grey][$r%s$c[grey]] [u:$r%s$c[grey]] [h:$r%s$c[grey]] [r:$r%s$c[grey]]"), c.nick, c.username, c.rawHostname, c.realname))
+	} else {
+		server.snomasks.Send(sno.LocalConnects, fmt.Sprintf(ircfmt.Unescape("Client registered $c[grey][$r%s$c[grey]] [u:$r%s$c[grey]] [h:$r%s$c[grey]] [r:$r%s$c[grey]] [geo:$r%s$c[grey]]"), c.nick, c.username, c.rawHostname, c.realname, c.location.String()))
+	}
 	c.Register()
 
+	// apply default user modes
+	for _, mode := range server.DefaultUserModes() {
+		c.SetMode(mode, true)
+	}
+
 	// send welcome text
 	//NOTE(dan): we specifically use the NICK here instead of the nickmask
 	// see http://modern.ircdocs.horse/#rplwelcome-001 for details on why we avoid using the nickmask
@@ -693,31 +1029,49 @@ func (server *Server) tryRegister(c *Client) {
 	//TODO(dan): Look at adding last optional [<channel modes with a parameter>] parameter
 	c.Send(nil, server.name, RPL_MYINFO, c.nick, server.name, Ver, supportedUserModesString, supportedChannelModesString)
 	c.RplISupport()
+	c.RplLusers()
 	server.MOTD(c)
 	c.Send(nil, c.nickMaskString, RPL_UMODEIS, c.nick, c.ModeString())
+	c.resetAutoAway()
 	if server.logger.DumpingRawInOut {
 		c.Notice("This server is in debug mode and is logging all user I/O. If you do not wish for everything you send to be readable by the server owner(s), please disconnect.")
 	}
+	server.autoJoinOnConnect(c)
+	server.sendStoredChannelExpiryWarnings(c)
 }
 
 // MOTD serves the Message of the Day.
 func (server *Server) MOTD(client *Client) {
+	server.motdMutex.RLock()
+	defer server.motdMutex.RUnlock()
+
 	if len(server.motdLines) < 1 {
-		client.Send(nil, server.name, ERR_NOMOTD, client.nick, "MOTD File is missing")
+		client.Send(nil, server.name, ERR_NOMOTD, client.nick, client.t("MOTD File is missing"))
 		return
 	}
 
-	client.Send(nil, server.name, RPL_MOTDSTART, client.nick, fmt.Sprintf("- %s Message of the day - ", server.name))
+	client.Send(nil, server.name, RPL_MOTDSTART, client.nick, fmt.Sprintf(client.t("- %s Message of the day - "), server.name))
 	for _, line := range server.motdLines {
 		client.Send(nil, server.name, RPL_MOTD, client.nick, line)
 	}
-	client.Send(nil, server.name, RPL_ENDOFMOTD, client.nick, "End of MOTD command")
+	client.Send(nil, server.name, RPL_ENDOFMOTD, client.nick, client.t("End of MOTD command"))
 }
 
 //
 // registration commands
 //
 
+// connectionPassword returns the password that clients connecting to the given listener
+// need to send with PASS, or nil if they don't need one. Listeners with their own
+// password use it instead of the server password.
+func (server *Server) connectionPassword(listener string) []byte {
+	hash, exists := server.Config().Server.ListenerHashes[listener]
+	if exists {
+		return hash
+	}
+	return server.password
+}
+
 // PASS <password>
 func passHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	if client.registered {
@@ -726,15 +1080,16 @@ func passHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	}
 
 	// if no password exists, skip checking
-	if len(server.password) == 0 {
+	serverPassword := server.connectionPassword(client.listener)
+	if len(serverPassword) == 0 {
 		client.authorized = true
 		return false
 	}
 
 	// check the provided password
 	password := []byte(msg.Params[0])
-	if ComparePassword(server.password, password) != nil {
-		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, "Password incorrect")
+	if ComparePassword(serverPassword, password) != nil {
+		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, client.t("Password incorrect"))
 		client.Send(nil, server.name, "ERROR", "Password incorrect")
 		return true
 	}
@@ -784,7 +1139,11 @@ func userHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 func quitHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	reason := "Quit"
 	if len(msg.Params) > 0 {
-		reason += ": " + msg.Params[0]
+		server.checkDroneSignature(client, msg.Params[0])
+		message := server.Config().Server.QuitFilter.Filter(msg.Params[0])
+		if message != "" {
+			reason += ": " + message
+		}
 	}
 	client.Quit(reason)
 	return true
@@ -832,7 +1191,7 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 	channel := server.channels.Chans[casefoldedOldName]
 	if channel == nil {
-		client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, oldName, "No such channel")
+		client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, oldName, client.t("No such channel"))
 		return false
 	}
 
@@ -858,6 +1217,11 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "RENAME", newName, "New channel name is in use")
 		return false
 	}
+	lookalike, err := server.checkChannelConfusable(casefoldedNewName)
+	if err != nil || (lookalike != "" && lookalike != casefoldedOldName) {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "RENAME", newName, client.t("New channel name is too similar to an existing channel, or mixes scripts"))
+		return false
+	}
 
 	var canEdit bool
 	server.store.Update(func(tx *buntdb.Tx) error {
@@ -881,6 +1245,8 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// perform the channel rename
 	server.channels.Chans[casefoldedOldName] = nil
 	server.channels.Chans[casefoldedNewName] = channel
+	server.channels.skeletons.Remove(casefoldedOldName)
+	server.channels.skeletons.Add(casefoldedNewName)
 
 	channel.name = strings.TrimSpace(msg.Params[1])
 	channel.nameCasefolded = casefoldedNewName
@@ -903,7 +1269,7 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// send RENAME messages
 	for mcl := range channel.members {
 		if mcl.capabilities[Rename] {
-			mcl.Send(nil, client.nickMaskString, "RENAME", oldName, newName, reason)
+			mcl.SendFromClient("", client, nil, "RENAME", oldName, newName, reason)
 		} else {
 			mcl.Send(nil, mcl.nickMaskString, "PART", oldName, fmt.Sprintf("Channel renamed: %s", reason))
 			if mcl.capabilities[ExtendedJoin] {
@@ -936,26 +1302,94 @@ func joinHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		keys = strings.Split(msg.Params[1], ",")
 	}
 
+	// hooks can take a while to answer, so ask them before we lock anything
+	denied := make(map[int]bool)
+	if server.hooks.Has(HookJoin) {
+		for i, name := range channels {
+			allowed, reason := server.checkHook(client, HookRequest{
+				Event:   HookJoin,
+				Channel: name,
+			})
+			if !allowed {
+				client.Fail("JOIN", "DENIED", reason, name)
+				denied[i] = true
+			}
+		}
+	}
+
+	// keys of registered channels are hashed, which are slow to check, so check those
+	// before we lock anything too
+	checkedKeyHashes := make(map[int][]byte)
+	for i, name := range channels {
+		casefoldedName, err := CasefoldChannel(name)
+		if denied[i] || err != nil || len(keys) <= i || keys[i] == "" {
+			continue
+		}
+		keyHash := server.channelKeyHash(casefoldedName)
+		if keyHash != nil && server.checkChannelKey(client, keyHash, keys[i]) {
+			checkedKeyHashes[i] = keyHash
+		}
+	}
+
 	// get lock
 	server.channelJoinPartMutex.Lock()
 	defer server.channelJoinPartMutex.Unlock()
 
 	for i, name := range channels {
+		if denied[i] {
+			continue
+		}
 		casefoldedName, err := CasefoldChannel(name)
 		if err != nil {
 			if len(name) > 0 {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, name, "No such channel")
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, name, client.t("No such channel"))
 			}
 			continue
 		}
 
+		if server.IsReservedChannel(client, casefoldedName) {
+			client.Send(nil, server.name, ERR_BADCHANMASK, client.nick, name, client.t("Channel name is reserved"))
+			continue
+		}
+
 		channel := server.channels.Get(casefoldedName)
+
+		// joining a channel we're already in doesn't count against our limit
+		maxChannels := client.maxChannels()
+		if 0 < maxChannels && maxChannels <= len(client.channels) && (channel == nil || !client.channels[channel]) {
+			client.Send(nil, server.name, ERR_TOOMANYCHANNELS, client.nick, name, "You have joined too many channels")
+			continue
+		}
+
 		if channel == nil {
-			if len(casefoldedName) > server.limits.ChannelLen {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, name, "No such channel")
+			if len(casefoldedName) > server.Limits().ChannelLen {
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, name, client.t("No such channel"))
 				continue
 			}
+
+			// stop lookalikes of existing channels from being made
+			lookalike, err := server.checkChannelConfusable(casefoldedName)
+			if err != nil {
+				client.Send(nil, server.name, ERR_BADCHANMASK, client.nick, name, client.t("Channel name mixes characters from different scripts"))
+				continue
+			} else if lookalike != "" && server.Config().Channels.Confusables.Action == "redirect" {
+				channel = server.channels.Get(lookalike)
+				if channel == nil {
+					// the registration gives the channel its proper name when we join
+					name = lookalike
+				}
+			} else if lookalike != "" {
+				client.Send(nil, server.name, ERR_BADCHANMASK, client.nick, name, client.t("Channel name is too similar to an existing channel"))
+				continue
+			}
+		}
+
+		if channel == nil {
 			channel = NewChannel(server, name, true)
+			server.sendWebhook(WebhookChannelCreate, fmt.Sprintf("Channel %s created by %s", name, client.nickMaskString), map[string]string{
+				"channel": name,
+				"nick":    client.nick,
+			})
 		}
 
 		var key string
@@ -963,7 +1397,7 @@ func joinHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			key = keys[i]
 		}
 
-		channel.Join(client, key)
+		channel.Join(client, key, checkedKeyHashes[i])
 	}
 	return false
 }
@@ -973,7 +1407,7 @@ func partHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	channels := strings.Split(msg.Params[0], ",")
 	var reason string //TODO(dan): if this isn't supplied here, make sure the param doesn't exist in the PART message sent to other users
 	if len(msg.Params) > 1 {
-		reason = msg.Params[1]
+		reason = server.Config().Server.QuitFilter.Filter(msg.Params[1])
 	}
 
 	// get lock
@@ -986,7 +1420,7 @@ func partHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 		if err != nil || channel == nil {
 			if len(chname) > 0 {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, "No such channel")
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, client.t("No such channel"))
 			}
 			continue
 		}
@@ -1002,7 +1436,7 @@ func topicHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	channel := server.channels.Get(name)
 	if err != nil || channel == nil {
 		if len(msg.Params[0]) > 0 {
-			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[0], "No such channel")
+			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[0], client.t("No such channel"))
 		}
 		return false
 	}
@@ -1028,11 +1462,11 @@ func wordWrap(text string, lineWidth int) []string {
 			lines = append(lines, cacheLine)
 			cacheWord = ""
 			cacheLine = ""
-		} else if (char == ' ' || char == '-') && len(cacheLine)+len(cacheWord)+1 < lineWidth {
+		} else if (char == ' ' || char == '-') && len(cacheLine)+len(cacheWord)+utf8.RuneLen(char) < lineWidth {
 			// natural word boundary
 			cacheLine += cacheWord + string(char)
 			cacheWord = ""
-		} else if lineWidth <= len(cacheLine)+len(cacheWord)+1 {
+		} else if lineWidth <= len(cacheLine)+len(cacheWord)+utf8.RuneLen(char) {
 			// time to wrap to next line
 			if len(cacheLine) < (lineWidth / 2) {
 				// this word takes up more than half a line... just split in the middle of the word
@@ -1058,40 +1492,86 @@ func wordWrap(text string, lineWidth int) []string {
 	return lines
 }
 
-// SplitMessage represents a message that's been split for sending.
+// SplitMessage represents a message that's split for sending, as each recipient's
+// line length requires.
 type SplitMessage struct {
-	For512     []string
 	ForMaxLine string
+	// wrapped maps width -> the message wrapped to that width, so relaying a message to
+	// lots of recipients only wraps it once for each line length they use. it's shared
+	// between copies, and isn't safe to use from more than one goroutine.
+	wrapped map[int][]string
 }
 
-func (server *Server) splitMessage(original string, origIs512 bool) SplitMessage {
-	var newSplit SplitMessage
-
-	newSplit.ForMaxLine = original
+func (server *Server) splitMessage(original string) SplitMessage {
+	return SplitMessage{
+		ForMaxLine: original,
+		wrapped:    make(map[int][]string),
+	}
+}
 
-	if !origIs512 {
-		newSplit.For512 = wordWrap(original, 400)
-	} else {
-		newSplit.For512 = []string{original}
+// Lines returns the message split into lines of at most width bytes, breaking at word
+// boundaries where possible so the full text is kept.
+func (message *SplitMessage) Lines(width int) []string {
+	if len(message.ForMaxLine) <= width {
+		return []string{message.ForMaxLine}
+	}
+	// don't wrap into uselessly short (or empty) lines if the overhead is huge
+	if width < minSplitLineLen {
+		width = minSplitLineLen
 	}
+	lines, exists := message.wrapped[width]
+	if !exists {
+		lines = wordWrap(message.ForMaxLine, width)
+		if message.wrapped != nil {
+			message.wrapped[width] = lines
+		}
+	}
+	return lines
+}
 
-	return newSplit
+// messageTargets splits the given comma-separated PRIVMSG, NOTICE or TAGMSG targets,
+// dropping any that are given more than once and any past our MAXTARGETS limit. If
+// notify is true, the client's told when targets are dropped for being over the limit.
+func (server *Server) messageTargets(client *Client, command, targetList string, notify bool) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, target := range strings.Split(targetList, ",") {
+		casefoldedTarget, err := Casefold(target)
+		if err != nil {
+			casefoldedTarget = target
+		}
+		if seen[casefoldedTarget] {
+			continue
+		}
+		seen[casefoldedTarget] = true
+
+		if server.Limits().MaxTargets <= len(targets) {
+			if notify {
+				client.Send(nil, server.name, ERR_TOOMANYTARGETS, client.nick, target, fmt.Sprintf(client.t("Too many targets, %s can only be sent to %d at once"), command, server.Limits().MaxTargets))
+			}
+			break
+		}
+		targets = append(targets, target)
+	}
+	return targets
 }
 
 // PRIVMSG <target>{,<target>} <message>
 func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	clientOnlyTags := GetClientOnlyTags(msg.Tags)
-	targets := strings.Split(msg.Params[0], ",")
+	clientOnlyTags := server.Config().Server.ClientTags.FilterTags(msg.Tags)
+	storedTags := server.Config().Server.ClientTags.StoredTags(clientOnlyTags)
+	targets := server.messageTargets(client, "PRIVMSG", msg.Params[0], true)
 	message := msg.Params[1]
+	server.checkDroneSignature(client, message)
+	// CTCP floods are dropped silently, the same as if their targets were ignoring them
+	if !server.checkCTCP(client, message) {
+		return false
+	}
 
 	// split privmsg
-	splitMsg := server.splitMessage(message, !client.capabilities[MaxLine])
+	splitMsg := server.splitMessage(message)
 
-	for i, targetString := range targets {
-		// max of four targets per privmsg
-		if i > maxTargets-1 {
-			break
-		}
+	for _, targetString := range targets {
 		prefixes, targetString := SplitChannelMembershipPrefixes(targetString)
 		lowestPrefix := GetLowestChannelModePrefix(prefixes)
 
@@ -1104,31 +1584,59 @@ func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 		if err == nil {
 			channel := server.channels.Get(target)
 			if channel == nil {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, "No such channel")
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, client.t("No such channel"))
 				continue
 			}
 			if !channel.CanSpeak(client) {
-				client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+				client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
+				continue
+			}
+			filtered, allowed := channel.FilterMessage(client, message)
+			if !allowed {
+				client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Your message was blocked by the channel's word filter"))
 				continue
 			}
+			if !server.checkMessageHook(client, "PRIVMSG", channel.name, filtered) {
+				continue
+			}
+			channelMsg := splitMsg
+			if filtered != message {
+				channelMsg = server.splitMessage(filtered)
+			}
 			msgid := server.generateMessageID()
-			channel.SplitPrivMsg(msgid, lowestPrefix, clientOnlyTags, client, splitMsg)
+			channel.SplitPrivMsg(msgid, lowestPrefix, clientOnlyTags, client, channelMsg)
+			if lowestPrefix == nil {
+				server.pushMentions(client, channel, filtered)
+			}
+			server.runFantasy(client, channel, message)
 		} else {
 			target, err = CasefoldName(targetString)
 			if target == "chanserv" {
 				server.chanservReceivePrivmsg(client, message)
 				continue
+			} else if target == "hostserv" {
+				server.hostservReceivePrivmsg(client, message)
+				continue
 			} else if target == "nickserv" {
 				server.nickservReceivePrivmsg(client, message)
 				continue
 			}
 			user := server.clients.Get(target)
+			if err == nil && user == nil && server.pushToAccount(client, targetString, message) {
+				continue
+			}
 			if err != nil || user == nil {
 				if len(target) > 0 {
-					client.Send(nil, server.name, ERR_NOSUCHNICK, target, "No such nick")
+					client.Send(nil, server.name, ERR_NOSUCHNICK, target, client.t("No such nick"))
 				}
 				continue
 			}
+			if user.callerIDBlocks(client, true) || user.regOnlyBlocks(client, true) {
+				continue
+			}
+			if !server.checkMessageHook(client, "PRIVMSG", user.nick, message) {
+				continue
+			}
 			if !user.capabilities[MessageTags] {
 				clientOnlyTags = nil
 			}
@@ -1137,9 +1645,11 @@ func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 			if client.capabilities[EchoMessage] {
 				client.SendSplitMsgFromClient(msgid, client, clientOnlyTags, "PRIVMSG", user.nick, splitMsg)
 			}
-			if user.flags[Away] {
+			client.sendSelfMessage(msgid, clientOnlyTags, "PRIVMSG", user, &splitMsg)
+			client.recordHistory(user, history.Privmsg, msgid, message, storedTags)
+			if isAway, awayMessage := user.Away(); isAway {
 				//TODO(dan): possibly implement cooldown of away notifications to users
-				client.Send(nil, server.name, RPL_AWAY, user.nick, user.awayMessage)
+				client.Send(nil, server.name, RPL_AWAY, user.nick, awayMessage)
 			}
 		}
 	}
@@ -1148,19 +1658,16 @@ func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 
 // TAGMSG <target>{,<target>}
 func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	clientOnlyTags := GetClientOnlyTags(msg.Tags)
+	clientOnlyTags := server.Config().Server.ClientTags.FilterTags(msg.Tags)
 	// no client-only tags, so we can drop it
 	if clientOnlyTags == nil {
 		return false
 	}
+	storedTags := server.Config().Server.ClientTags.StoredTags(clientOnlyTags)
 
-	targets := strings.Split(msg.Params[0], ",")
+	targets := server.messageTargets(client, "TAGMSG", msg.Params[0], true)
 
-	for i, targetString := range targets {
-		// max of four targets per privmsg
-		if i > maxTargets-1 {
-			break
-		}
+	for _, targetString := range targets {
 		prefixes, targetString := SplitChannelMembershipPrefixes(targetString)
 		lowestPrefix := GetLowestChannelModePrefix(prefixes)
 
@@ -1173,11 +1680,14 @@ func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		if err == nil {
 			channel := server.channels.Get(target)
 			if channel == nil {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, "No such channel")
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, targetString, client.t("No such channel"))
 				continue
 			}
 			if !channel.CanSpeak(client) {
-				client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, "Cannot send to channel")
+				client.Send(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
+				continue
+			}
+			if !server.checkMessageHook(client, "TAGMSG", channel.name, "") {
 				continue
 			}
 			msgid := server.generateMessageID()
@@ -1188,44 +1698,52 @@ func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			user := server.clients.Get(target)
 			if err != nil || user == nil {
 				if len(target) > 0 {
-					client.Send(nil, server.name, ERR_NOSUCHNICK, target, "No such nick")
+					client.Send(nil, server.name, ERR_NOSUCHNICK, target, client.t("No such nick"))
 				}
 				continue
 			}
 			msgid := server.generateMessageID()
 
 			// end user can't receive tagmsgs
-			if !user.capabilities[MessageTags] {
+			if !user.capabilities[MessageTags] || user.callerIDBlocks(client, false) || user.regOnlyBlocks(client, false) {
+				continue
+			}
+			if !server.checkMessageHook(client, "TAGMSG", user.nick, "") {
 				continue
 			}
 			user.SendFromClient(msgid, client, clientOnlyTags, "TAGMSG", user.nick)
 			if client.capabilities[EchoMessage] {
 				client.SendFromClient(msgid, client, clientOnlyTags, "TAGMSG", user.nick)
 			}
-			if user.flags[Away] {
+			client.sendSelfMessage(msgid, clientOnlyTags, "TAGMSG", user, nil)
+			if storedTags != nil {
+				client.recordHistory(user, history.Tagmsg, msgid, "", storedTags)
+			}
+			if isAway, awayMessage := user.Away(); isAway {
 				//TODO(dan): possibly implement cooldown of away notifications to users
-				client.Send(nil, server.name, RPL_AWAY, user.nick, user.awayMessage)
+				client.Send(nil, server.name, RPL_AWAY, user.nick, awayMessage)
 			}
 		}
 	}
 	return false
 }
 
-// WhoisChannelsNames returns the common channel names between two users.
-func (client *Client) WhoisChannelsNames(target *Client) []string {
-	isMultiPrefix := target.capabilities[MultiPrefix]
+// WhoisChannelsNames returns the names of this client's channels that the given
+// requester can see, following the whois-channels config.
+func (client *Client) WhoisChannelsNames(requester *Client) []string {
+	config := client.server.Config().Server.WhoisChannels
+	isMultiPrefix := requester.capabilities[MultiPrefix]
+	seesAll := client == requester || (requester.HasMode(Operator) && !config.HideFromOpers)
+
 	var chstrs []string
-	index := 0
 	for channel := range client.channels {
 		channel.membersMutex.RLock()
-		defer channel.membersMutex.RUnlock()
-
-		// channel is secret and the target can't see it
-		if !target.flags[Operator] && channel.flags[Secret] && !channel.members.Has(target) {
-			continue
+		shared := channel.members.Has(requester)
+		visible := seesAll || shared || (!config.SharedOnly && (config.ShowSecret || !channel.flags[Secret]))
+		if visible {
+			chstrs = append(chstrs, channel.members[client].Prefixes(isMultiPrefix)+channel.name)
 		}
-		chstrs = append(chstrs, channel.members[client].Prefixes(isMultiPrefix)+channel.name)
-		index++
+		channel.membersMutex.RUnlock()
 	}
 	return chstrs
 }
@@ -1247,17 +1765,17 @@ func whoisHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		return false
 	}
 
-	if client.flags[Operator] {
+	if client.HasMode(Operator) {
 		masks := strings.Split(masksString, ",")
 		for _, mask := range masks {
 			casefoldedMask, err := Casefold(mask)
 			if err != nil {
-				client.Send(nil, client.server.name, ERR_NOSUCHNICK, mask, "No such nick")
+				client.Send(nil, client.server.name, ERR_NOSUCHNICK, mask, client.t("No such nick"))
 				continue
 			}
 			matches := server.clients.FindAll(casefoldedMask)
 			if len(matches) == 0 {
-				client.Send(nil, client.server.name, ERR_NOSUCHNICK, mask, "No such nick")
+				client.Send(nil, client.server.name, ERR_NOSUCHNICK, mask, client.t("No such nick"))
 				continue
 			}
 			for mclient := range matches {
@@ -1269,36 +1787,49 @@ func whoisHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		casefoldedMask, err := Casefold(strings.Split(masksString, ",")[0])
 		mclient := server.clients.Get(casefoldedMask)
 		if err != nil || mclient == nil {
-			client.Send(nil, client.server.name, ERR_NOSUCHNICK, masksString, "No such nick")
+			client.Send(nil, client.server.name, ERR_NOSUCHNICK, masksString, client.t("No such nick"))
 			// fall through, ENDOFWHOIS is always sent
 		} else {
 			client.getWhoisOf(mclient)
 		}
 	}
-	client.Send(nil, server.name, RPL_ENDOFWHOIS, client.nick, masksString, "End of /WHOIS list")
+	client.Send(nil, server.name, RPL_ENDOFWHOIS, client.nick, masksString, client.t("End of /WHOIS list"))
 	return false
 }
 
 func (client *Client) getWhoisOf(target *Client) {
 	client.Send(nil, client.server.name, RPL_WHOISUSER, client.nick, target.nick, target.username, target.hostname, "*", target.realname)
 
-	whoischannels := client.WhoisChannelsNames(target)
+	whoischannels := target.WhoisChannelsNames(client)
 	if whoischannels != nil {
 		client.Send(nil, client.server.name, RPL_WHOISCHANNELS, client.nick, target.nick, strings.Join(whoischannels, " "))
 	}
 	if target.class != nil {
 		client.Send(nil, client.server.name, RPL_WHOISOPERATOR, client.nick, target.nick, target.whoisLine)
 	}
-	if client.flags[Operator] || client == target {
+	if client.HasMode(Operator) || client == target {
 		client.Send(nil, client.server.name, RPL_WHOISACTUALLY, client.nick, target.nick, fmt.Sprintf("%s@%s", target.username, LookupHostname(target.IPString())), target.IPString(), "Actual user@host, Actual IP")
+		if !target.location.IsEmpty() {
+			countryCode := target.location.CountryCode
+			if countryCode == "" {
+				countryCode = "*"
+			}
+			client.Send(nil, client.server.name, RPL_WHOISCOUNTRY, client.nick, target.nick, countryCode, fmt.Sprintf(client.t("is connecting from %s"), target.location.Description()))
+		}
+	}
+	if target.HasMode(Bot) {
+		client.Send(nil, client.server.name, RPL_WHOISBOT, client.nick, target.nick, "is a bot")
 	}
-	if target.flags[TLS] {
+	if target.HasMode(TLS) {
 		client.Send(nil, client.server.name, RPL_WHOISSECURE, client.nick, target.nick, "is using a secure connection")
 	}
-	if target.certfp != "" && (client.flags[Operator] || client == target) {
+	if target.certfp != "" && (client.HasMode(Operator) || client == target) {
 		client.Send(nil, client.server.name, RPL_WHOISCERTFP, client.nick, target.nick, fmt.Sprintf("has client certificate fingerprint %s", target.certfp))
 	}
-	client.Send(nil, client.server.name, RPL_WHOISIDLE, client.nick, target.nick, strconv.FormatUint(target.IdleSeconds(), 10), strconv.FormatInt(target.SignonTime(), 10), "seconds idle, signon time")
+	// some users consider their idle time private, opers can always see it
+	if !target.account.HideIdle || client.HasMode(Operator) || client == target {
+		client.Send(nil, client.server.name, RPL_WHOISIDLE, client.nick, target.nick, strconv.FormatUint(target.IdleSeconds(), 10), strconv.FormatInt(target.SignonTime(), 10), "seconds idle, signon time")
+	}
 }
 
 // RplWhoReplyNoMutex returns the WHO reply between one user and another channel/user.
@@ -1306,31 +1837,55 @@ func (client *Client) getWhoisOf(target *Client) {
 // :<hopcount> <real name>
 func (target *Client) RplWhoReplyNoMutex(channel *Channel, client *Client) {
 	channelName := "*"
+	var prefixes string
+
+	if channel != nil {
+		prefixes = channel.members[client].Prefixes(target.capabilities[MultiPrefix])
+		channelName = channel.name
+	}
+	target.rplWhoReply(channelName, client, prefixes)
+}
+
+// rplWhoReply sends a single WHO reply, using the given (already-calculated) channel prefixes.
+func (target *Client) rplWhoReply(channelName string, client *Client, prefixes string) {
 	flags := ""
 
-	if client.flags[Away] {
+	if client.HasMode(Away) {
 		flags = "G"
 	} else {
 		flags = "H"
 	}
-	if client.flags[Operator] {
+	if client.HasMode(Operator) {
 		flags += "*"
 	}
-
-	if channel != nil {
-		flags += channel.members[client].Prefixes(target.capabilities[MultiPrefix])
-		channelName = channel.name
+	flags += prefixes
+	if client.HasMode(Bot) {
+		flags += "B"
 	}
+
 	target.Send(nil, target.server.name, RPL_WHOREPLY, target.nick, channelName, client.username, client.hostname, client.server.name, client.nick, flags, strconv.Itoa(client.hops)+" "+client.realname)
 }
 
+// whoChannel sends WHO replies for the given channel. The member list is copied out
+// first so we don't hold the channel lock while sending replies for huge channels.
 func whoChannel(client *Client, channel *Channel, friends ClientSet) {
+	isMultiPrefix := client.capabilities[MultiPrefix]
+
 	channel.membersMutex.RLock()
-	defer channel.membersMutex.RUnlock()
+	members := make([]*Client, 0, len(channel.members))
+	prefixes := make([]string, 0, len(channel.members))
+	for member, modes := range channel.members {
+		members = append(members, member)
+		prefixes = append(prefixes, modes.Prefixes(isMultiPrefix))
+	}
+	channel.membersMutex.RUnlock()
 
-	for member := range channel.members {
-		if !client.flags[Invisible] || friends[client] {
-			client.RplWhoReplyNoMutex(channel, member)
+	for i, member := range members {
+		if 0 < i && i%memberReplyChunkSize == 0 {
+			runtime.Gosched()
+		}
+		if !client.HasMode(Invisible) || friends[client] {
+			client.rplWhoReply(channel.name, member, prefixes[i])
 		}
 	}
 }
@@ -1356,26 +1911,33 @@ func whoHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	//	operatorOnly = true
 	//}
 
+	// with hide-who, non-opers only see the channels they're in and the users they know
+	hideWho := server.Config().Server.UserCountPrivacy.HideWho && !client.HasMode(Operator)
+
 	if mask == "" {
-		server.channels.ChansLock.RLock()
-		for _, channel := range server.channels.Chans {
+		for _, channel := range server.channels.All() {
+			if hideWho && !channel.HasClient(client) {
+				continue
+			}
 			whoChannel(client, channel, friends)
 		}
-		server.channels.ChansLock.RUnlock()
 	} else if mask[0] == '#' {
 		// TODO implement wildcard matching
 		//TODO(dan): ^ only for opers
 		channel := server.channels.Get(mask)
-		if channel != nil {
+		if channel != nil && (!hideWho || channel.HasClient(client)) {
 			whoChannel(client, channel, friends)
 		}
 	} else {
 		for mclient := range server.clients.FindAll(mask) {
+			if hideWho && !friends[mclient] {
+				continue
+			}
 			client.RplWhoReplyNoMutex(nil, mclient)
 		}
 	}
 
-	client.Send(nil, server.name, RPL_ENDOFWHO, client.nick, mask, "End of WHO list")
+	client.Send(nil, server.name, RPL_ENDOFWHO, client.nick, mask, client.t("End of WHO list"))
 	return false
 }
 
@@ -1383,43 +1945,44 @@ func whoHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	name, err := CasefoldName(msg.Params[0])
 	if err != nil {
-		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, "Password incorrect")
+		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, client.t("Password incorrect"))
 		return true
 	}
-	if client.flags[Operator] == true {
+	if client.HasMode(Operator) {
 		client.Send(nil, server.name, ERR_UNKNOWNERROR, "OPER", "You're already opered-up!")
 		return false
 	}
-	hash := server.operators[name].Pass
+	oper, exists := server.Oper(name)
+	hash := server.operPasswordHash(name, oper)
 	password := []byte(msg.Params[1])
 
 	err = ComparePassword(hash, password)
 
-	if (hash == nil) || (err != nil) {
-		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, "Password incorrect")
+	if !exists || (hash == nil) || (err != nil) {
+		client.Send(nil, server.name, ERR_PASSWDMISMATCH, client.nick, client.t("Password incorrect"))
 		return true
 	}
 
-	client.flags[Operator] = true
+	// rehash the password if our hashing settings have changed since it was made
+	server.migrateOperPassword(name, oper, hash, msg.Params[1])
+
+	client.SetMode(Operator, true)
 	client.operName = name
-	client.class = server.operators[name].Class
+	client.class = oper.Class
+	server.currentOpersMutex.Lock()
 	server.currentOpers[client] = true
-	client.whoisLine = server.operators[name].WhoisLine
+	server.currentOpersMutex.Unlock()
+	client.whoisLine = oper.WhoisLine
 
 	// push new vhost if one is set
-	if len(server.operators[name].Vhost) > 0 {
-		for fClient := range client.Friends(ChgHost) {
-			fClient.SendFromClient("", client, nil, "CHGHOST", client.username, server.operators[name].Vhost)
-		}
-		// CHGHOST requires prefix nickmask to have original hostname, so do that before updating nickmask
-		client.vhost = server.operators[name].Vhost
-		client.updateNickMask()
+	if len(oper.Vhost) > 0 {
+		client.setVHost(oper.Vhost)
 	}
 
 	// set new modes
 	var applied ModeChanges
-	if 0 < len(server.operators[name].Modes) {
-		modeChanges, unknownChanges := ParseUserModeChanges(strings.Split(server.operators[name].Modes, " ")...)
+	if 0 < len(oper.Modes) {
+		modeChanges, unknownChanges := ParseUserModeChanges(strings.Split(oper.Modes, " ")...)
 		applied = client.applyUserModeChanges(true, modeChanges)
 		if 0 < len(unknownChanges) {
 			var runes string
@@ -1442,54 +2005,186 @@ func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	return false
 }
 
-// rehash reloads the config and applies the changes from the config file.
-func (server *Server) rehash() error {
-	server.logger.Debug("rehash", "Starting rehash")
-
-	// only let one REHASH go on at a time
-	server.rehashMutex.Lock()
-	defer server.rehashMutex.Unlock()
-
-	server.logger.Debug("rehash", "Got rehash lock")
+// rehashState is a new config and all the state derived from it. It's built up off to
+// the side while rehashing, and then swapped in all at once.
+type rehashState struct {
+	config              *Config
+	accountRegistration *AccountRegistration
+	connectionLimits    *ConnectionLimits
+	connectionThrottle  *ConnectionThrottle
+	geoip               *geoip.Reader
+	isupport            *ISupportList
+	languages           *languages.Manager
+	limits              Limits
+	newListeners        map[string]net.Listener
+	operators           map[string]Oper
+	operclasses         map[string]OperClass
+}
 
+// prepareRehash loads the config file and builds the new state from it, without touching
+// any of our current state. If this fails, the current config stays in place untouched.
+func (server *Server) prepareRehash() (*rehashState, error) {
 	config, err := LoadConfig(server.configFilename)
 
 	if err != nil {
-		return fmt.Errorf("Error rehashing config file config: %s", err.Error())
+		return nil, fmt.Errorf("Error rehashing config file config: %s", err.Error())
 	}
 
 	// line lengths cannot be changed after launching the server
-	if server.limits.LineLen.Tags != config.Limits.LineLen.Tags || server.limits.LineLen.Rest != config.Limits.LineLen.Rest {
-		return fmt.Errorf("Maximum line length (linelen) cannot be changed after launching the server, rehash aborted")
+	oldLimits := server.Limits()
+	if oldLimits.LineLen.Tags != config.Limits.LineLen.Tags || oldLimits.LineLen.Rest != config.Limits.LineLen.Rest {
+		return nil, fmt.Errorf("Maximum line length (linelen) cannot be changed after launching the server, rehash aborted")
 	}
 
 	// confirm connectionLimits are fine
 	connectionLimits, err := NewConnectionLimits(config.Server.ConnectionLimits)
 	if err != nil {
-		return fmt.Errorf("Error rehashing config file connection-limits: %s", err.Error())
+		return nil, fmt.Errorf("Error rehashing config file connection-limits: %s", err.Error())
 	}
 
 	// confirm connectionThrottler is fine
 	connectionThrottle, err := NewConnectionThrottle(config.Server.ConnectionThrottle)
 	if err != nil {
-		return fmt.Errorf("Error rehashing config file connection-throttle: %s", err.Error())
+		return nil, fmt.Errorf("Error rehashing config file connection-throttle: %s", err.Error())
+	}
+
+	// confirm the GeoIP databases can be read
+	geoipReader, err := openGeoIP(config.Server.GeoIP)
+	if err != nil {
+		return nil, fmt.Errorf("Error rehashing config file geoip: %s", err.Error())
 	}
 
 	// confirm operator stuff all exists and is fine
 	operclasses, err := config.OperatorClasses()
 	if err != nil {
-		return fmt.Errorf("Error rehashing config file operclasses: %s", err.Error())
+		return nil, fmt.Errorf("Error rehashing config file operclasses: %s", err.Error())
 	}
 	opers, err := config.Operators(operclasses)
 	if err != nil {
-		return fmt.Errorf("Error rehashing config file opers: %s", err.Error())
+		return nil, fmt.Errorf("Error rehashing config file opers: %s", err.Error())
 	}
+	server.currentOpersMutex.Lock()
 	for client := range server.currentOpers {
 		_, exists := opers[client.operName]
 		if !exists {
-			return fmt.Errorf("Oper [%s] no longer exists (used by client [%s])", client.operName, client.nickMaskString)
+			server.currentOpersMutex.Unlock()
+			return nil, fmt.Errorf("Oper [%s] no longer exists (used by client [%s])", client.operName, client.nickMaskString)
 		}
 	}
+	server.currentOpersMutex.Unlock()
+
+	// server options
+	limits := Limits{
+		AcceptEntries:  int(config.Limits.AcceptEntries),
+		AwayLen:        int(config.Limits.AwayLen),
+		ChannelLen:     int(config.Limits.ChannelLen),
+		KickLen:        int(config.Limits.KickLen),
+		MaxChannels:    int(config.Limits.MaxChannels),
+		MaxTargets:     int(config.Limits.MaxTargets),
+		MonitorEntries: int(config.Limits.MonitorEntries),
+		NickLen:        int(config.Limits.NickLen),
+		TopicLen:       int(config.Limits.TopicLen),
+		ChanListModes:  int(config.Limits.ChanListModes),
+		LineLen: LineLenLimits{
+			Tags: config.Limits.LineLen.Tags,
+			Rest: config.Limits.LineLen.Rest,
+		},
+	}
+
+	// registration
+	accountReg := NewAccountRegistration(config.Accounts.Registration)
+
+	langManager := languages.NewManager(config.Languages.Default, config.Languages.Data)
+
+	// bind any new listeners now, so that a port we can't use aborts the rehash before
+	// anything else has been changed
+	newListeners := make(map[string]net.Listener)
+	server.listenerUpdateMutex.Lock()
+	for _, addr := range config.Server.Listen {
+		_, exists := server.listeners[addr]
+		_, alreadyBound := newListeners[addr]
+		if exists || alreadyBound {
+			continue
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			server.listenerUpdateMutex.Unlock()
+			for _, listener := range newListeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("Error rehashing config file listeners: Could not listen on %s: %s", addr, err.Error())
+		}
+		newListeners[addr] = listener
+	}
+	server.listenerUpdateMutex.Unlock()
+
+	return &rehashState{
+		config:              config,
+		accountRegistration: &accountReg,
+		connectionLimits:    connectionLimits,
+		connectionThrottle:  connectionThrottle,
+		geoip:               geoipReader,
+		isupport:            generateISupport(config, limits, &accountReg, langManager),
+		languages:           langManager,
+		limits:              limits,
+		newListeners:        newListeners,
+		operators:           opers,
+		operclasses:         *operclasses,
+	}, nil
+}
+
+// rehash reloads the config and applies the changes from the config file.
+func (server *Server) rehash() error {
+	server.logger.Debug("rehash", "Starting rehash")
+
+	// only let one REHASH go on at a time
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	server.logger.Debug("rehash", "Got rehash lock")
+
+	// let systemd know, so `systemctl reload` waits for us to finish
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	state, err := server.prepareRehash()
+	if err != nil {
+		return err
+	}
+
+	server.applyRehash(state)
+	return nil
+}
+
+// applyRehash swaps in the given (already-validated) rehash state, and lets our clients
+// and listeners know about whatever changed.
+func (server *Server) applyRehash(state *rehashState) {
+	config := state.config
+
+	// swap in the new config and everything derived from it at once, so nobody sees
+	// a mix of the old and new settings
+	server.configMutex.Lock()
+	oldISupportList := server.isupport
+	server.config = config
+	server.configOverrides = make(map[string]string)
+	server.limits = state.limits
+	server.operclasses = state.operclasses
+	server.accountRegistration = state.accountRegistration
+	server.channelRegistrationEnabled = config.Channels.Registration.Enabled
+	server.defaultChannelModes = config.Channels.DefaultModes
+	server.defaultUserModes = config.Server.DefaultUserModes
+	server.isupport = state.isupport
+	server.configMutex.Unlock()
+
+	connectionLimits := state.connectionLimits
+	connectionThrottle := state.connectionThrottle
+
+	server.geoipMutex.Lock()
+	oldGeoIP := server.geoip
+	server.geoip = state.geoip
+	server.geoipMutex.Unlock()
+	// nobody can be looking anything up in the old databases now
+	oldGeoIP.Close()
 
 	// apply new connectionlimits
 	server.connectionLimitsMutex.Lock()
@@ -1501,7 +2196,7 @@ func (server *Server) rehash() error {
 	for _, client := range server.clients.ByNick {
 		ipaddr := client.IP()
 		if ipaddr != nil {
-			server.connectionLimits.AddClient(ipaddr, true)
+			server.connectionLimits.AddClient(ipaddr, client.location, true)
 		}
 	}
 	server.clients.ByNickMutex.RUnlock()
@@ -1526,6 +2221,13 @@ func (server *Server) rehash() error {
 	}
 	server.accountAuthenticationEnabled = config.Accounts.AuthenticationEnabled
 
+	// new passwords are hashed with the new settings, older ones get rehashed as people log in
+	server.passwordsMutex.Lock()
+	passwords := NewPasswordManager(server.passwords.salt, config.PasswordHashing)
+	server.passwords = &passwords
+	server.operators = state.operators
+	server.passwordsMutex.Unlock()
+
 	// STS
 	stsValue := config.Server.STS.Value()
 	var stsDisabled bool
@@ -1593,28 +2295,29 @@ func (server *Server) rehash() error {
 	}
 
 	// set server options
-	lineLenConfig := LineLenLimits{
-		Tags: config.Limits.LineLen.Tags,
-		Rest: config.Limits.LineLen.Rest,
-	}
-	server.limits = Limits{
-		AwayLen:        int(config.Limits.AwayLen),
-		ChannelLen:     int(config.Limits.ChannelLen),
-		KickLen:        int(config.Limits.KickLen),
-		MonitorEntries: int(config.Limits.MonitorEntries),
-		NickLen:        int(config.Limits.NickLen),
-		TopicLen:       int(config.Limits.TopicLen),
-		ChanListModes:  int(config.Limits.ChanListModes),
-		LineLen:        lineLenConfig,
-	}
-	server.operclasses = *operclasses
-	server.operators = opers
-	server.checkIdent = config.Server.CheckIdent
-
-	// registration
-	accountReg := NewAccountRegistration(config.Accounts.Registration)
-	server.accountRegistration = &accountReg
-	server.channelRegistrationEnabled = config.Channels.Registration.Enabled
+	server.unregisteredLimits.SetMax(config.Server.Unregistered.MaxPerIP)
+	server.conversations.SetLength(config.ConversationLength(), config.History.ConversationsPerAccount)
+	server.hooks.SetConfig(config.Hooks)
+	server.loginThrottle.SetConfig(config.Accounts.LoginThrottling)
+	server.webhooks.SetConfig(config.Webhooks)
+	server.oauth2.SetConfig(config.Accounts.OAuth2)
+	server.abuseReports.SetConfig(config.AbuseReports)
+
+	// languages
+	server.languagesMutex.Lock()
+	server.languages = state.languages
+	server.languagesMutex.Unlock()
+
+	// aliases
+	server.aliasesMutex.Lock()
+	server.aliases = config.Aliases
+	server.aliasesMutex.Unlock()
+
+	// aliases and help topics can change, and so can the languages our help index is in
+	server.help.Rebuild(config, state.languages)
+
+	// reload the motd
+	server.loadMOTD(config)
 
 	// set new sendqueue size
 	if config.Server.MaxSendQBytes != server.MaxSendQBytes {
@@ -1628,12 +2331,10 @@ func (server *Server) rehash() error {
 		server.clients.ByNickMutex.RUnlock()
 	}
 
-	// set RPL_ISUPPORT
-	oldISupportList := server.isupport
-	server.setISupport()
-	newISupportReplies := oldISupportList.GetDifference(server.isupport)
+	// let clients know about any RPL_ISUPPORT changes
+	newISupportReplies := oldISupportList.GetDifference(state.isupport)
 
-	// push new info to all of our clients
+	// push new info to all of our clients, if anything's changed
 	server.clients.ByNickMutex.RLock()
 	for _, sClient := range server.clients.ByNick {
 		for _, tokenline := range newISupportReplies {
@@ -1645,7 +2346,14 @@ func (server *Server) rehash() error {
 
 	// destroy old listeners
 	tlsListeners := config.TLSListeners()
-	for addr := range server.listeners {
+	server.listenerUpdateMutex.Lock()
+	oldListeners := make(map[string]ListenerInterface, len(server.listeners))
+	for addr, li := range server.listeners {
+		oldListeners[addr] = li
+	}
+	server.listenerUpdateMutex.Unlock()
+
+	for addr, li := range oldListeners {
 		var exists bool
 		for _, newaddr := range config.Server.Listen {
 			if newaddr == addr {
@@ -1657,31 +2365,35 @@ func (server *Server) rehash() error {
 		server.listenerEventActMutex.Lock()
 		if exists {
 			// update old listener
-			server.listeners[addr].Events <- ListenerEvent{
-				Type:      UpdateListener,
-				NewConfig: tlsListeners[addr],
+			li.Events <- ListenerEvent{
+				Type:               UpdateListener,
+				NewConfig:          tlsListeners[addr],
+				NewWebSocketConfig: config.Server.WebSocketListeners[addr],
 			}
 		} else {
 			// destroy nonexistent listener
-			server.listeners[addr].Events <- ListenerEvent{
+			li.Events <- ListenerEvent{
 				Type: DestroyListener,
 			}
+			server.listenerUpdateMutex.Lock()
+			delete(server.listeners, addr)
+			server.listenerUpdateMutex.Unlock()
+			server.logger.Info("listeners", fmt.Sprintf("stopped listening on %s.", addr))
 		}
 		// force listener to apply the event right away
-		server.listeners[addr].Listener.Close()
+		li.Listener.Close()
 
 		server.listenerEventActMutex.Unlock()
 	}
 
-	for _, newaddr := range config.Server.Listen {
-		_, exists := server.listeners[newaddr]
-		if !exists {
-			// make new listener
-			server.createListener(newaddr, tlsListeners)
+	// start the listeners we bound while preparing the rehash
+	for addr, listener := range state.newListeners {
+		err := server.createListener(addr, tlsListeners, config.Server.WebSocketListeners, listener)
+		if err != nil {
+			listener.Close()
+			server.logger.Error("listeners", err.Error())
 		}
 	}
-
-	return nil
 }
 
 // REHASH
@@ -1698,6 +2410,78 @@ func rehashHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	return false
 }
 
+// SHUTDOWN [reason]
+func shutdownHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	reason := strings.Join(msg.Params, " ")
+	server.logger.Info("shutdown", fmt.Sprintf("SHUTDOWN command used by %s", client.nick))
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] is shutting down the server"), client.nickMaskString))
+
+	if !server.RequestShutdown(reason) {
+		client.Notice("The server is already shutting down")
+	}
+	return false
+}
+
+// RequestShutdown asks the server to shut down gracefully with the given reason. It
+// returns false if the server is already shutting down.
+func (server *Server) RequestShutdown(reason string) bool {
+	select {
+	case server.shutdownRequests <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// LOGLEVEL [<type> [<level>|DEFAULT]]
+func loglevelHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if len(msg.Params) == 0 {
+		overrides := server.logger.LevelOverrides()
+		if len(overrides) == 0 {
+			client.Notice("No log levels are currently overridden")
+			return false
+		}
+		var logTypes []string
+		for logType := range overrides {
+			logTypes = append(logTypes, logType)
+		}
+		sort.Strings(logTypes)
+		for _, logType := range logTypes {
+			client.Notice(fmt.Sprintf("Logging %s at level %s", logType, logger.LogLevelDisplayNames[overrides[logType]]))
+		}
+		return false
+	}
+
+	logType := strings.ToLower(msg.Params[0])
+	if len(msg.Params) < 2 {
+		level, exists := server.logger.LevelOverrides()[logType]
+		if exists {
+			client.Notice(fmt.Sprintf("Logging %s at level %s", logType, logger.LogLevelDisplayNames[level]))
+		} else {
+			client.Notice(fmt.Sprintf("Logging %s at the configured levels", logType))
+		}
+		return false
+	}
+
+	levelName := strings.ToLower(msg.Params[1])
+	if levelName == "default" {
+		server.logger.RemoveLevelOverride(logType)
+		client.Notice(fmt.Sprintf("Logging %s at the configured levels", logType))
+		server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] reset the log level for $c[grey][$r%s$c[grey]]"), client.nickMaskString, logType))
+		return false
+	}
+
+	level, exists := logger.LogLevelNames[levelName]
+	if !exists {
+		client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "LOGLEVEL", "Unknown log level")
+		return false
+	}
+	server.logger.SetLevelOverride(logType, level)
+	client.Notice(fmt.Sprintf("Logging %s at level %s", logType, logger.LogLevelDisplayNames[level]))
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] set the log level for $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), client.nickMaskString, logType, logger.LogLevelDisplayNames[level]))
+	return false
+}
+
 // AWAY [<message>]
 func awayHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	var isAway bool
@@ -1705,42 +2489,9 @@ func awayHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	if len(msg.Params) > 0 {
 		isAway = true
 		text = msg.Params[0]
-		if len(text) > server.limits.AwayLen {
-			text = text[:server.limits.AwayLen]
-		}
-	}
-
-	if isAway {
-		client.flags[Away] = true
-	} else {
-		delete(client.flags, Away)
-	}
-	client.awayMessage = text
-
-	var op ModeOp
-	if client.flags[Away] {
-		op = Add
-		client.Send(nil, server.name, RPL_NOWAWAY, client.nick, "You have been marked as being away")
-	} else {
-		op = Remove
-		client.Send(nil, server.name, RPL_UNAWAY, client.nick, "You are no longer marked as being away")
-	}
-	//TODO(dan): Should this be sent automagically as part of setting the flag/mode?
-	modech := ModeChanges{ModeChange{
-		mode: Away,
-		op:   op,
-	}}
-	client.Send(nil, server.name, "MODE", client.nick, client.nick, modech.String())
-
-	// dispatch away-notify
-	for friend := range client.Friends(AwayNotify) {
-		if client.flags[Away] {
-			friend.SendFromClient("", client, nil, "AWAY", client.awayMessage)
-		} else {
-			friend.SendFromClient("", client, nil, "AWAY")
-		}
 	}
 
+	client.setAway(isAway, text)
 	return false
 }
 
@@ -1765,13 +2516,39 @@ func isonHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	return false
 }
 
-// MOTD [<target>]
+// RELOADMOTD
+func reloadmotdHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	server.rehashMutex.Lock()
+	server.loadMOTD(server.Config())
+	server.rehashMutex.Unlock()
+
+	server.logger.Info("motd", fmt.Sprintf("MOTD reloaded by %s", client.nick))
+	client.Notice("MOTD reloaded")
+	return false
+}
+
+// isTargetServer checks the [<server>] parameter of commands like MOTD and TIME, which
+// can be a server name or mask, or the nickname of a client on the server. It returns
+// true if the command is for us, otherwise it tells the client there's no such server.
+//TODO(dan): forward commands for other servers from here, once we can link to them.
+func (server *Server) isTargetServer(client *Client, target string) bool {
+	if ircmatch.MakeMatch(strings.ToLower(target)).Match(server.nameCasefolded) {
+		return true
+	}
+	// we don't link to other servers yet, so everyone is connected to us
+	casefoldedTarget, err := CasefoldName(target)
+	if err == nil && server.clients.Get(casefoldedTarget) != nil {
+		return true
+	}
+	client.Send(nil, server.name, ERR_NOSUCHSERVER, client.nick, target, client.t("No such server"))
+	return false
+}
+
+// MOTD [<server>]
 func motdHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	//TODO(dan): hook this up when we have multiple servers I guess???
-	//var target string
-	//if len(msg.Params) > 0 {
-	//	target = msg.Params[0]
-	//}
+	if len(msg.Params) > 0 && !server.isTargetServer(client, msg.Params[0]) {
+		return false
+	}
 
 	server.MOTD(client)
 	return false
@@ -1779,18 +2556,21 @@ func motdHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 // NOTICE <target>{,<target>} <message>
 func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	clientOnlyTags := GetClientOnlyTags(msg.Tags)
-	targets := strings.Split(msg.Params[0], ",")
+	clientOnlyTags := server.Config().Server.ClientTags.FilterTags(msg.Tags)
+	storedTags := server.Config().Server.ClientTags.StoredTags(clientOnlyTags)
+	// notices never get error replies
+	targets := server.messageTargets(client, "NOTICE", msg.Params[0], false)
 	message := msg.Params[1]
+	server.checkDroneSignature(client, message)
+	// CTCP floods are dropped silently, the same as if their targets were ignoring them
+	if !server.checkCTCP(client, message) {
+		return false
+	}
 
 	// split privmsg
-	splitMsg := server.splitMessage(message, !client.capabilities[MaxLine])
+	splitMsg := server.splitMessage(message)
 
-	for i, targetString := range targets {
-		// max of four targets per privmsg
-		if i > maxTargets-1 {
-			break
-		}
+	for _, targetString := range targets {
 		prefixes, targetString := SplitChannelMembershipPrefixes(targetString)
 		lowestPrefix := GetLowestChannelModePrefix(prefixes)
 
@@ -1805,8 +2585,19 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 				// errors silently ignored with NOTICE as per RFC
 				continue
 			}
+			filtered, allowed := channel.FilterMessage(client, message)
+			if !allowed {
+				continue
+			}
+			if !server.checkMessageHook(client, "NOTICE", channel.name, filtered) {
+				continue
+			}
+			channelMsg := splitMsg
+			if filtered != message {
+				channelMsg = server.splitMessage(filtered)
+			}
 			msgid := server.generateMessageID()
-			channel.SplitNotice(msgid, lowestPrefix, clientOnlyTags, client, splitMsg)
+			channel.SplitNotice(msgid, lowestPrefix, clientOnlyTags, client, channelMsg)
 		} else {
 			target, err := CasefoldName(targetString)
 			if err != nil {
@@ -1815,6 +2606,9 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			if target == "chanserv" {
 				server.chanservReceiveNotice(client, message)
 				continue
+			} else if target == "hostserv" {
+				server.hostservReceiveNotice(client, message)
+				continue
 			} else if target == "nickserv" {
 				server.nickservReceiveNotice(client, message)
 				continue
@@ -1825,6 +2619,12 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 				// errors silently ignored with NOTICE as per RFC
 				continue
 			}
+			if user.callerIDBlocks(client, false) || user.regOnlyBlocks(client, false) {
+				continue
+			}
+			if !server.checkMessageHook(client, "NOTICE", user.nick, message) {
+				continue
+			}
 			if !user.capabilities[MessageTags] {
 				clientOnlyTags = nil
 			}
@@ -1833,17 +2633,80 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			if client.capabilities[EchoMessage] {
 				client.SendSplitMsgFromClient(msgid, client, clientOnlyTags, "NOTICE", user.nick, splitMsg)
 			}
+			client.sendSelfMessage(msgid, clientOnlyTags, "NOTICE", user, &splitMsg)
+			client.recordHistory(user, history.Notice, msgid, message, storedTags)
 		}
 	}
 	return false
 }
 
+// CPRIVMSG <nickname> <channel> <message>
+// CNOTICE <nickname> <channel> <message>
+func cmessageHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	clientOnlyTags := server.Config().Server.ClientTags.FilterTags(msg.Tags)
+	storedTags := server.Config().Server.ClientTags.StoredTags(clientOnlyTags)
+	command := strings.TrimPrefix(msg.Command, "C")
+	message := msg.Params[2]
+	server.checkDroneSignature(client, message)
+	// CTCP floods are dropped silently, the same as if their targets were ignoring them
+	if !server.checkCTCP(client, message) {
+		return false
+	}
+
+	target, err := CasefoldName(msg.Params[0])
+	user := server.clients.Get(target)
+	if err != nil || user == nil {
+		client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, msg.Params[0], client.t("No such nick"))
+		return false
+	}
+	chname, err := CasefoldChannel(msg.Params[1])
+	channel := server.channels.Get(chname)
+	if err != nil || channel == nil {
+		client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[1], client.t("No such channel"))
+		return false
+	}
+
+	// only voiced users and above can use these, to message people in their channel. in
+	// return, the target's +g and +R don't stop them
+	if !channel.ClientIsAtLeast(client, Voice) {
+		client.Send(nil, server.name, ERR_CHANOPRIVSNEEDED, client.nick, channel.name, client.t("You're not voiced or a channel operator"))
+		return false
+	}
+	if !channel.HasClient(user) {
+		client.Send(nil, server.name, ERR_USERNOTINCHANNEL, client.nick, user.nick, channel.name, client.t("They aren't on that channel"))
+		return false
+	}
+	if !server.checkMessageHook(client, msg.Command, user.nick, message) {
+		return false
+	}
+
+	splitMsg := server.splitMessage(message)
+	if !user.capabilities[MessageTags] {
+		clientOnlyTags = nil
+	}
+	msgid := server.generateMessageID()
+	user.SendSplitMsgFromClient(msgid, client, clientOnlyTags, command, user.nick, splitMsg)
+	if client.capabilities[EchoMessage] {
+		client.SendSplitMsgFromClient(msgid, client, clientOnlyTags, command, user.nick, splitMsg)
+	}
+	client.sendSelfMessage(msgid, clientOnlyTags, command, user, &splitMsg)
+	if command == "PRIVMSG" {
+		client.recordHistory(user, history.Privmsg, msgid, message, storedTags)
+		if isAway, awayMessage := user.Away(); isAway {
+			client.Send(nil, server.name, RPL_AWAY, user.nick, awayMessage)
+		}
+	} else {
+		client.recordHistory(user, history.Notice, msgid, message, storedTags)
+	}
+	return false
+}
+
 // KICK <channel>{,<channel>} <user>{,<user>} [<comment>]
 func kickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	channels := strings.Split(msg.Params[0], ",")
 	users := strings.Split(msg.Params[1], ",")
 	if (len(channels) != len(users)) && (len(users) != 1) {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, "KICK", "Not enough parameters")
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, "KICK", client.t("Not enough parameters"))
 		return false
 	}
 
@@ -1866,14 +2729,14 @@ func kickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		casefoldedChname, err := CasefoldChannel(chname)
 		channel := server.channels.Get(casefoldedChname)
 		if err != nil || channel == nil {
-			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, "No such channel")
+			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, client.t("No such channel"))
 			continue
 		}
 
 		casefoldedNickname, err := CasefoldName(nickname)
 		target := server.clients.Get(casefoldedNickname)
 		if err != nil || target == nil {
-			client.Send(nil, server.name, ERR_NOSUCHNICK, nickname, "No such nick")
+			client.Send(nil, server.name, ERR_NOSUCHNICK, nickname, client.t("No such nick"))
 			continue
 		}
 
@@ -1898,13 +2761,15 @@ func kickHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			}
 		}
 
-		if hasPrivs {
+		if hasPrivs && !server.checkOpAction(client, channel, "KICK") {
+			client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "KICK", fmt.Sprintf(client.t("You're kicking too quickly in %s, slow down"), channel.name))
+		} else if hasPrivs {
 			if comment == "" {
 				comment = nickname
 			}
 			channel.kickNoMutex(client, target, comment)
 		} else {
-			client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, chname, "You're not a channel operator")
+			client.Send(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.nick, chname, client.t("You're not a channel operator"))
 		}
 
 		channel.membersMutex.Unlock()
@@ -1918,6 +2783,8 @@ type elistMatcher struct {
 	MinClients       int
 	MaxClientsActive bool
 	MaxClients       int
+	// TopicMasks are the masks that the channel's topic must match.
+	TopicMasks []*regexp.Regexp
 }
 
 // Matches checks whether the given channel matches our matches.
@@ -1937,9 +2804,32 @@ func (matcher *elistMatcher) Matches(channel *Channel) bool {
 		}
 	}
 
+	if 0 < len(matcher.TopicMasks) {
+		topic := stripIRCFormatting(channel.topic)
+		for _, mask := range matcher.TopicMasks {
+			if !mask.MatchString(topic) {
+				return false
+			}
+		}
+	}
+
 	return true
 }
 
+// compileTopicMask compiles a LIST T: condition. Masks without wildcards match
+// anywhere in the topic, like a plain search.
+func compileTopicMask(mask string) (*regexp.Regexp, error) {
+	isRegex := 2 < len(mask) && strings.HasPrefix(mask, "/") && strings.HasSuffix(mask, "/")
+	if !isRegex && !strings.ContainsAny(mask, "*?") {
+		mask = "*" + mask + "*"
+	}
+	masks, err := compileNamePatterns([]string{mask})
+	if err != nil {
+		return nil, err
+	}
+	return masks[0], nil
+}
+
 // LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
 func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	// get channels
@@ -1979,12 +2869,26 @@ func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			matcher.MinClientsActive = true
 			matcher.MinClients = val + 1 // +1 because > means more than the given number
 		}
+		if 2 < len(param) && strings.ToUpper(param[:2]) == "T:" {
+			mask, err := compileTopicMask(param[2:])
+			if err != nil {
+				client.Send(nil, server.name, ERR_UNKNOWNERROR, client.nick, "LIST", fmt.Sprintf(client.t("Invalid topic mask: %s"), err.Error()))
+				client.Send(nil, server.name, RPL_LISTEND, client.nick, client.t("End of LIST"))
+				return false
+			}
+			matcher.TopicMasks = append(matcher.TopicMasks, mask)
+		}
+	}
+	// searching by user count would give away the counts we're hiding
+	if !client.HasMode(Operator) && server.Config().Server.UserCountPrivacy.List != "show" {
+		matcher.MinClientsActive = false
+		matcher.MaxClientsActive = false
 	}
 
 	if len(channels) == 0 {
 		server.channels.ChansLock.RLock()
 		for _, channel := range server.channels.Chans {
-			if !client.flags[Operator] && channel.flags[Secret] {
+			if !client.HasMode(Operator) && channel.flags[Secret] {
 				continue
 			}
 			if matcher.Matches(channel) {
@@ -1994,16 +2898,16 @@ func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		server.channels.ChansLock.RUnlock()
 	} else {
 		// limit regular users to only listing one channel
-		if !client.flags[Operator] {
+		if !client.HasMode(Operator) {
 			channels = channels[:1]
 		}
 
 		for _, chname := range channels {
 			casefoldedChname, err := CasefoldChannel(chname)
 			channel := server.channels.Get(casefoldedChname)
-			if err != nil || channel == nil || (!client.flags[Operator] && channel.flags[Secret]) {
+			if err != nil || channel == nil || (!client.HasMode(Operator) && channel.flags[Secret]) {
 				if len(chname) > 0 {
-					client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, "No such channel")
+					client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, client.t("No such channel"))
 				}
 				continue
 			}
@@ -2012,7 +2916,7 @@ func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			}
 		}
 	}
-	client.Send(nil, server.name, RPL_LISTEND, client.nick, "End of LIST")
+	client.Send(nil, server.name, RPL_LISTEND, client.nick, client.t("End of LIST"))
 	return false
 }
 
@@ -2023,14 +2927,16 @@ func (target *Client) RplList(channel *Channel) {
 
 	// get the correct number of channel members
 	var memberCount int
-	if target.flags[Operator] || channel.members.Has(target) {
+	if target.HasMode(Operator) || channel.members.Has(target) {
 		memberCount = len(channel.members)
 	} else {
 		for member := range channel.members {
-			if !member.flags[Invisible] {
+			if !member.HasMode(Invisible) {
 				memberCount++
 			}
 		}
+		privacy := target.server.Config().Server.UserCountPrivacy
+		memberCount = privacy.privateCount(privacy.List, memberCount)
 	}
 
 	target.Send(nil, target.server.name, RPL_LIST, target.nick, channel.name, strconv.Itoa(memberCount), channel.topic)
@@ -2048,16 +2954,14 @@ func namesHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	//}
 
 	if len(channels) == 0 {
-		server.channels.ChansLock.RLock()
-		for _, channel := range server.channels.Chans {
+		for _, channel := range server.channels.All() {
 			channel.Names(client)
 		}
-		server.channels.ChansLock.RUnlock()
 		return false
 	}
 
 	// limit regular users to only listing one channel
-	if !client.flags[Operator] {
+	if !client.HasMode(Operator) {
 		channels = channels[:1]
 	}
 
@@ -2066,7 +2970,7 @@ func namesHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		channel := server.channels.Get(casefoldedChname)
 		if err != nil || channel == nil {
 			if len(chname) > 0 {
-				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, "No such channel")
+				client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, chname, client.t("No such channel"))
 			}
 			continue
 		}
@@ -2077,13 +2981,7 @@ func namesHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 // VERSION [<server>]
 func versionHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	var target string
-	if len(msg.Params) > 0 {
-		target = msg.Params[0]
-	}
-	casefoldedTarget, err := Casefold(target)
-	if target != "" && (err != nil || casefoldedTarget != server.nameCasefolded) {
-		client.Send(nil, server.name, ERR_NOSUCHSERVER, client.nick, target, "No such server")
+	if len(msg.Params) > 0 && !server.isTargetServer(client, msg.Params[0]) {
 		return false
 	}
 
@@ -2092,15 +2990,78 @@ func versionHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 	return false
 }
 
-// INVITE <nickname> <channel>
+// infoCredits are the credits we list in INFO replies.
+var infoCredits = []string{
+	"Oragono is a fork of the Ergonomadic IRC daemon <3",
+	"",
+	"Jeremy Latt, creator of Ergonomadic",
+	"Edmund Huber, maintainer of Ergonomadic",
+	"Niels Freier, added WebSocket support to Ergonomadic",
+	"Daniel Oaks, maintainer of Oragono",
+}
+
+// ADMIN [<server>]
+func adminHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if len(msg.Params) > 0 && !server.isTargetServer(client, msg.Params[0]) {
+		return false
+	}
+
+	admin := server.Config().Admin
+	if admin.Name == "" && admin.Location == "" && admin.Email == "" {
+		client.Send(nil, server.name, ERR_NOADMININFO, client.nick, server.name, "No administrative info available")
+		return false
+	}
+
+	client.Send(nil, server.name, RPL_ADMINME, client.nick, server.name, "Administrative info")
+	client.Send(nil, server.name, RPL_ADMINLOC1, client.nick, admin.Location)
+	client.Send(nil, server.name, RPL_ADMINLOC2, client.nick, admin.Name)
+	client.Send(nil, server.name, RPL_ADMINEMAIL, client.nick, admin.Email)
+	return false
+}
+
+// INFO [<server>]
+func infoHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	if len(msg.Params) > 0 && !server.isTargetServer(client, msg.Params[0]) {
+		return false
+	}
+
+	client.Send(nil, server.name, RPL_INFO, client.nick, fmt.Sprintf("%s, running on %s", Ver, server.name))
+	if server.Config().Network.Description != "" {
+		client.Send(nil, server.name, RPL_INFO, client.nick, "")
+		for _, line := range strings.Split(strings.TrimSpace(server.Config().Network.Description), "\n") {
+			client.Send(nil, server.name, RPL_INFO, client.nick, line)
+		}
+	}
+	client.Send(nil, server.name, RPL_INFO, client.nick, "")
+	for _, line := range infoCredits {
+		client.Send(nil, server.name, RPL_INFO, client.nick, line)
+	}
+	client.Send(nil, server.name, RPL_ENDOFINFO, client.nick, "End of INFO")
+	return false
+}
+
+// INVITE [<nickname> <channel>]
 func inviteHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	// with no params, list the invites we haven't used yet
+	if len(msg.Params) == 0 {
+		for _, invite := range client.invites.List() {
+			client.Send(nil, server.name, RPL_INVLIST, client.nick, invite.Channel)
+		}
+		client.Send(nil, server.name, RPL_ENDOFINVLIST, client.nick, "End of /INVITE list")
+		return false
+	}
+	if len(msg.Params) < 2 {
+		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+
 	nickname := msg.Params[0]
 	channelName := msg.Params[1]
 
 	casefoldedNickname, err := CasefoldName(nickname)
 	target := server.clients.Get(casefoldedNickname)
 	if err != nil || target == nil {
-		client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, nickname, "No such nick")
+		client.Send(nil, server.name, ERR_NOSUCHNICK, client.nick, nickname, client.t("No such nick"))
 		return false
 	}
 
@@ -2108,7 +3069,7 @@ func inviteHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	channel := server.channels.Get(casefoldedChannelName)
 	if err != nil || channel == nil {
 		client.Send(nil, server.name, RPL_INVITING, client.nick, target.nick, channelName)
-		target.Send(nil, client.nickMaskString, "INVITE", target.nick, channel.name)
+		target.SendFromClient("", client, nil, "INVITE", target.nick, channelName)
 		return true
 	}
 
@@ -2118,13 +3079,7 @@ func inviteHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 // TIME [<server>]
 func timeHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	var target string
-	if len(msg.Params) > 0 {
-		target = msg.Params[0]
-	}
-	casefoldedTarget, err := Casefold(target)
-	if (target != "") && err != nil || (casefoldedTarget != server.nameCasefolded) {
-		client.Send(nil, server.name, ERR_NOSUCHSERVER, client.nick, target, "No such server")
+	if len(msg.Params) > 0 && !server.isTargetServer(client, msg.Params[0]) {
 		return false
 	}
 	client.Send(nil, server.name, RPL_TIME, client.nick, server.name, time.Now().Format(time.RFC1123))
@@ -2142,7 +3097,7 @@ func killHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 	casefoldedNickname, err := CasefoldName(nickname)
 	target := server.clients.Get(casefoldedNickname)
 	if err != nil || target == nil {
-		client.Send(nil, client.server.name, ERR_NOSUCHNICK, nickname, "No such nick")
+		client.Send(nil, client.server.name, ERR_NOSUCHNICK, nickname, client.t("No such nick"))
 		return false
 	}
 
@@ -2172,7 +3127,7 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 		results := server.whoWas.Find(nickname, count)
 		if len(results) == 0 {
 			if len(nickname) > 0 {
-				client.Send(nil, server.name, ERR_WASNOSUCHNICK, client.nick, nickname, "There was no such nickname")
+				client.Send(nil, server.name, ERR_WASNOSUCHNICK, client.nick, nickname, client.t("There was no such nickname"))
 			}
 		} else {
 			for _, whoWas := range results {
@@ -2180,7 +3135,7 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 			}
 		}
 		if len(nickname) > 0 {
-			client.Send(nil, server.name, RPL_ENDOFWHOWAS, client.nick, nickname, "End of WHOWAS")
+			client.Send(nil, server.name, RPL_ENDOFWHOWAS, client.nick, nickname, client.t("End of WHOWAS"))
 		}
 	}
 	return false
@@ -2188,25 +3143,8 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
 
 // LUSERS [<mask> [<server>]]
 func lusersHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
-	//TODO(vegax87) Fix network statistics and additional parameters
-	var totalcount, invisiblecount, opercount int
-
-	server.clients.ByNickMutex.RLock()
-	defer server.clients.ByNickMutex.RUnlock()
-
-	for _, onlineusers := range server.clients.ByNick {
-		totalcount++
-		if onlineusers.flags[Invisible] {
-			invisiblecount++
-		}
-		if onlineusers.flags[Operator] {
-			opercount++
-		}
-	}
-	client.Send(nil, server.name, RPL_LUSERCLIENT, client.nick, fmt.Sprintf("There are %d users and %d invisible on %d server(s)", totalcount, invisiblecount, 1))
-	client.Send(nil, server.name, RPL_LUSEROP, client.nick, fmt.Sprintf("%d IRC Operators online", opercount))
-	client.Send(nil, server.name, RPL_LUSERCHANNELS, client.nick, fmt.Sprintf("%d channels formed", server.channels.Len()))
-	client.Send(nil, server.name, RPL_LUSERME, client.nick, fmt.Sprintf("I have %d clients and %d servers", totalcount, 1))
+	//TODO(vegax87) additional parameters
+	client.RplLusers()
 	return false
 }
 
@@ -2222,7 +3160,7 @@ func userhostHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 		casefoldedNickname, err := CasefoldName(nickname)
 		target := server.clients.Get(casefoldedNickname)
 		if err != nil || target == nil {
-			client.Send(nil, client.server.name, ERR_NOSUCHNICK, nickname, "No such nick")
+			client.Send(nil, client.server.name, ERR_NOSUCHNICK, nickname, client.t("No such nick"))
 			return false
 		}
 		if returnedNicks[casefoldedNickname] {
@@ -2234,10 +3172,10 @@ func userhostHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool
 
 		var isOper, isAway string
 
-		if target.flags[Operator] {
+		if target.HasMode(Operator) {
 			isOper = "*"
 		}
-		if target.flags[Away] {
+		if target.HasMode(Away) {
 			isAway = "-"
 		} else {
 			isAway = "+"
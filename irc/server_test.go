@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+func TestSplitMessageLines(t *testing.T) {
+	short := SplitMessage{ForMaxLine: "hello there"}
+	lines := short.Lines(400)
+	if len(lines) != 1 || lines[0] != "hello there" {
+		t.Errorf("short message shouldn't be split, got %q", lines)
+	}
+
+	words := strings.Repeat("lorem ipsum dolor ", 60)
+	long := SplitMessage{ForMaxLine: words}
+	lines = long.Lines(300)
+	if len(lines) < 4 {
+		t.Errorf("expected the message to be split into at least 4 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if 300 < len(line) {
+			t.Errorf("line is %d bytes, over the 300 byte width", len(line))
+		}
+		if !strings.HasSuffix(line, " ") && line != lines[len(lines)-1] {
+			t.Errorf("line was split mid-word: %q", line)
+		}
+	}
+	if strings.Join(lines, "") != words {
+		t.Error("splitting lost some of the message")
+	}
+
+	// multibyte characters shouldn't push lines over the width
+	wide := SplitMessage{ForMaxLine: strings.Repeat("ü", 300)}
+	lines = wide.Lines(minSplitLineLen)
+	for _, line := range lines {
+		if minSplitLineLen < len(line) {
+			t.Errorf("line is %d bytes, over the %d byte width", len(line), minSplitLineLen)
+		}
+	}
+	if strings.Join(lines, "") != wide.ForMaxLine {
+		t.Error("splitting lost some of the message")
+	}
+
+	// huge prefixes don't wrap messages into tiny lines
+	lines = long.Lines(5)
+	for _, line := range lines[:len(lines)-1] {
+		if len(line) < minSplitLineLen/2 {
+			t.Errorf("line is only %d bytes", len(line))
+		}
+	}
+
+	// relaying to lots of recipients only wraps the message once per width
+	server := newTestServer()
+	relayed := server.splitMessage(words)
+	for _, width := range []int{300, 300, 400, 300} {
+		relayed.Lines(width)
+	}
+	if len(relayed.wrapped) != 2 {
+		t.Errorf("expected the message to be wrapped to 2 widths, got %d", len(relayed.wrapped))
+	}
+}
+
+func TestCompileTopicMask(t *testing.T) {
+	testCases := []struct {
+		mask    string
+		topic   string
+		matches bool
+	}{
+		{"linux", "All about Linux kernels", true},
+		{"linux", "All about BSD", false},
+		{"linux*", "All about Linux", false},
+		{"*about linux", "All about Linux", true},
+		{"/^all .* l[iu]nux$/", "All about Lunux", true},
+		{"/^linux/", "All about Linux", false},
+	}
+	for _, testCase := range testCases {
+		mask, err := compileTopicMask(testCase.mask)
+		if err != nil {
+			t.Errorf("couldn't compile %s: %s", testCase.mask, err.Error())
+			continue
+		}
+		if mask.MatchString(testCase.topic) != testCase.matches {
+			t.Errorf("expected %s matching [%s] to be %t", testCase.mask, testCase.topic, testCase.matches)
+		}
+	}
+}
+
+func TestListRejectsBadTopicMask(t *testing.T) {
+	server := newTestServer()
+	client := newTestClient(server, "dan")
+
+	listHandler(server, client, ircmsg.MakeMessage(nil, "", "LIST", "T:/[/"))
+	lines := sentLines(client)
+	if len(lines) != 2 || !strings.Contains(lines[0], " 400 dan LIST :Invalid topic mask") || !strings.Contains(lines[1], " 323 dan ") {
+		t.Errorf("expected the bad topic mask to be reported, got %q", lines)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// serviceAliases are the short names of our services.
+	serviceAliases = map[string]string{
+		"cs": "chanserv",
+		"hs": "hostserv",
+		"ns": "nickserv",
+	}
+
+	// serviceCommands are the subcommands of each service. Each of them has a help
+	// entry named after the service and subcommand, like "nickserv identify".
+	serviceCommands = map[string][]string{
+		"chanserv": {"ban", "info", "register", "set"},
+		"hostserv": {"del", "off", "on", "set"},
+		"nickserv": {"cert", "ghost", "identify", "info", "logout", "regain", "release", "set"},
+	}
+)
+
+// checkServiceHelp returns an error if any services subcommand is missing a help entry.
+func checkServiceHelp() error {
+	for service, commands := range serviceCommands {
+		for _, command := range commands {
+			_, exists := Help[service+" "+command]
+			if !exists {
+				return fmt.Errorf("Help entry does not exist for %s command %s", service, strings.ToUpper(command))
+			}
+		}
+	}
+	return nil
+}
+
+// serviceHelp handles the HELP subcommand of the given service, sending help on the
+// service or one of its subcommands with the given notice function.
+func (client *Client) serviceHelp(service string, params []string, notice func(string)) {
+	name := service
+	if 1 < len(params) {
+		name = service + " " + strings.ToLower(params[1])
+	}
+	entry, exists := Help[name]
+	if !exists || (entry.oper && !client.HasMode(Operator)) {
+		notice(fmt.Sprintf(client.t("No help available for %s"), strings.ToUpper(params[1])))
+		return
+	}
+	for _, line := range strings.Split(client.helpText(name, entry.text), "\n") {
+		// some clients drop empty notices
+		if line == "" {
+			line = " "
+		}
+		notice(line)
+	}
+}
+
+// serviceInfoLine is one line of the output of a services INFO command.
+type serviceInfoLine struct {
+	// name is shown in the left column. Lines without a name continue the line above.
+	name  string
+	value string
+}
+
+// formatServiceInfo lays out the output of a services INFO command: the header, the
+// given lines with their names lined up in a column, then the footer. Lines without a
+// value are skipped.
+func formatServiceInfo(header string, lines []serviceInfoLine, footer string) []string {
+	var width int
+	for _, line := range lines {
+		length := utf8.RuneCountInString(line.name)
+		if line.value != "" && width < length {
+			width = length
+		}
+	}
+
+	output := []string{header}
+	for _, line := range lines {
+		if line.value == "" {
+			continue
+		}
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(line.name))
+		if line.name == "" {
+			output = append(output, fmt.Sprintf("  %s   %s", padding, line.value))
+		} else {
+			output = append(output, fmt.Sprintf("  %s%s : %s", line.name, padding, line.value))
+		}
+	}
+	return append(output, footer)
+}
+
+// serviceInfoList returns lines that show the given list, one entry per line.
+func serviceInfoList(name string, entries []string) []serviceInfoLine {
+	var lines []serviceInfoLine
+	for i, entry := range entries {
+		line := serviceInfoLine{value: entry}
+		if i == 0 {
+			line.name = name
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// serviceInfoTime formats the given time for a services INFO command, or returns an
+// empty string (skipping the line) if it isn't set.
+func serviceInfoTime(t time.Time) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return ""
+	}
+	return t.Format(time.RFC1123)
+}
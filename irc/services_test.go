@@ -0,0 +1,58 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatServiceInfo(t *testing.T) {
+	lines := []serviceInfoLine{
+		{"Channel", "#test"},
+		{"Topic", ""},
+		{"Founder", "dan"},
+	}
+	lines = append(lines, serviceInfoList("Bans", []string{"a!*@*", "b!*@*"})...)
+	lines = append(lines, serviceInfoList("Exceptions", nil)...)
+
+	expected := []string{
+		"Information on channel #test:",
+		"  Channel : #test",
+		"  Founder : dan",
+		"  Bans    : a!*@*",
+		"            b!*@*",
+		"End of info",
+	}
+	output := formatServiceInfo("Information on channel #test:", lines, "End of info")
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("unexpected output:\n%#v\nexpected:\n%#v", output, expected)
+	}
+}
+
+func TestServiceHelp(t *testing.T) {
+	err := checkServiceHelp()
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	for name, entry := range Help {
+		if entry.helpType != ServiceHelpEntry {
+			continue
+		}
+		fields := strings.Fields(name)
+		if len(fields) != 2 {
+			t.Errorf("services help entry %s should be named after a service and subcommand", name)
+			continue
+		}
+		var listed bool
+		for _, command := range serviceCommands[fields[0]] {
+			listed = listed || command == fields[1]
+		}
+		if !listed {
+			t.Errorf("services help entry %s isn't listed in serviceCommands", name)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build !windows
+// +build !windows
+
+package irc
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// ServerUpgradeSignals are the signals that make the server upgrade itself.
+	ServerUpgradeSignals = []os.Signal{
+		syscall.SIGUSR2,
+	}
+
+	// ServerDumpSignals are the signals that make the server write out a state dump.
+	ServerDumpSignals = []os.Signal{
+		syscall.SIGUSR1,
+	}
+)
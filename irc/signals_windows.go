@@ -0,0 +1,17 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"os"
+)
+
+var (
+	// ServerUpgradeSignals are the signals that make the server upgrade itself. Windows
+	// doesn't have a spare signal we can use for this.
+	ServerUpgradeSignals = []os.Signal{}
+
+	// ServerDumpSignals are the signals that make the server write out a state dump.
+	ServerDumpSignals = []os.Signal{}
+)
@@ -8,6 +8,7 @@ type Mask rune
 const (
 	LocalAccouncements Mask = 'a'
 	LocalConnects      Mask = 'c'
+	LocalFloods        Mask = 'f'
 	LocalChannels      Mask = 'j'
 	LocalKills         Mask = 'k'
 	LocalNicks         Mask = 'n'
@@ -23,6 +24,7 @@ var (
 	NoticeMaskNames = map[Mask]string{
 		LocalAccouncements: "ANNOUNCEMENT",
 		LocalConnects:      "CONNECT",
+		LocalFloods:        "FLOOD",
 		LocalChannels:      "CHANNEL",
 		LocalKills:         "KILL",
 		LocalNicks:         "NICK",
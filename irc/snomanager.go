@@ -3,6 +3,7 @@ package irc
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/oragono/oragono/irc/sno"
@@ -12,6 +13,20 @@ import (
 type SnoManager struct {
 	sendListMutex sync.RWMutex
 	sendLists     map[sno.Mask]map[*Client]bool
+	// onSend is called with every snomask we send, even if nobody's signed up for it.
+	// It's set before the server starts, so it doesn't need locking.
+	onSend func(mask sno.Mask, content string)
+
+	recentMutex sync.Mutex
+	// recent holds the last snomasks we've sent, oldest first.
+	recent []SnoNotice
+}
+
+// SnoNotice is a snomask we've sent.
+type SnoNotice struct {
+	Time    time.Time
+	Mask    sno.Mask
+	Content string
 }
 
 // NewSnoManager returns a new SnoManager
@@ -77,6 +92,22 @@ func (m *SnoManager) RemoveClient(client *Client) {
 
 // Send sends the given snomask to all users signed up for it.
 func (m *SnoManager) Send(mask sno.Mask, content string) {
+	if m.onSend != nil {
+		m.onSend(mask, content)
+	}
+
+	m.recentMutex.Lock()
+	if len(m.recent) == maxRecentSnomasks {
+		copy(m.recent, m.recent[1:])
+		m.recent = m.recent[:len(m.recent)-1]
+	}
+	m.recent = append(m.recent, SnoNotice{
+		Time:    time.Now(),
+		Mask:    mask,
+		Content: content,
+	})
+	m.recentMutex.Unlock()
+
 	m.sendListMutex.RLock()
 	defer m.sendListMutex.RUnlock()
 
@@ -99,6 +130,18 @@ func (m *SnoManager) Send(mask sno.Mask, content string) {
 	}
 }
 
+// Recent returns the last snomasks we've sent, newest first.
+func (m *SnoManager) Recent() []SnoNotice {
+	m.recentMutex.Lock()
+	defer m.recentMutex.Unlock()
+
+	recent := make([]SnoNotice, len(m.recent))
+	for i, notice := range m.recent {
+		recent[len(recent)-1-i] = notice
+	}
+	return recent
+}
+
 // String returns the snomasks currently enabled.
 func (m *SnoManager) String(client *Client) string {
 	m.sendListMutex.RLock()
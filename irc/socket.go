@@ -17,12 +17,63 @@ import (
 	"time"
 )
 
+const (
+	// MaxConcurrentTLSHandshakes is how many TLS handshakes can be in progress at once.
+	MaxConcurrentTLSHandshakes = 64
+)
+
 var (
-	errNotTLS           = errors.New("Not a TLS connection")
-	errNoPeerCerts      = errors.New("Client did not provide a certificate")
-	handshakeTimeout, _ = time.ParseDuration("5s")
+	errNotTLS              = errors.New("Not a TLS connection")
+	errNoPeerCerts         = errors.New("Client did not provide a certificate")
+	errTooManyTLSHandshake = errors.New("Too many TLS handshakes in progress")
+	handshakeTimeout, _    = time.ParseDuration("5s")
 )
 
+// TLSHandshakeLimiter limits how many TLS handshakes can be in progress at once, so
+// floods of slow TLS connections can't pin our CPU or hold onto file descriptors.
+type TLSHandshakeLimiter struct {
+	inProgress chan bool
+}
+
+// NewTLSHandshakeLimiter returns a new TLSHandshakeLimiter allowing max concurrent handshakes.
+func NewTLSHandshakeLimiter(max int) *TLSHandshakeLimiter {
+	return &TLSHandshakeLimiter{
+		inProgress: make(chan bool, max),
+	}
+}
+
+// Handshake performs the TLS handshake on the given connection (if it's a TLS one). This
+// fails right away if too many handshakes are already in progress, and times out after
+// a few seconds.
+func (limiter *TLSHandshakeLimiter) Handshake(conn net.Conn) error {
+	tlsConn, isTLS := tlsConnOf(conn)
+	if !isTLS {
+		return nil
+	}
+
+	select {
+	case limiter.inProgress <- true:
+		defer func() { <-limiter.inProgress }()
+	default:
+		return errTooManyTLSHandshake
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	return err
+}
+
+// tlsConnOf returns the given connection as a TLS one, if it is. Connections that wrap
+// another (like WebSocket ones) expose it with NetConn.
+func tlsConnOf(conn net.Conn) (*tls.Conn, bool) {
+	if wrapper, isWrapper := conn.(interface{ NetConn() net.Conn }); isWrapper {
+		conn = wrapper.NetConn()
+	}
+	tlsConn, isTLS := conn.(*tls.Conn)
+	return tlsConn, isTLS
+}
+
 // Socket represents an IRC socket.
 type Socket struct {
 	conn   net.Conn
@@ -66,7 +117,7 @@ func (socket *Socket) Close() {
 
 // CertFP returns the fingerprint of the certificate provided by the client.
 func (socket *Socket) CertFP() (string, error) {
-	var tlsConn, isTLS = socket.conn.(*tls.Conn)
+	var tlsConn, isTLS = tlsConnOf(socket.conn)
 	if !isTLS {
 		return "", errNotTLS
 	}
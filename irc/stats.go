@@ -0,0 +1,104 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// commandStats counts how many times each command has been used since we started up.
+type commandStats struct {
+	sync.Mutex
+	counts map[string]uint64
+}
+
+// Add counts a use of the given command.
+func (stats *commandStats) Add(command string) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	if stats.counts == nil {
+		stats.counts = make(map[string]uint64)
+	}
+	stats.counts[command]++
+}
+
+// Counts returns how many times each command has been used.
+func (stats *commandStats) Counts() map[string]uint64 {
+	stats.Lock()
+	defer stats.Unlock()
+
+	counts := make(map[string]uint64, len(stats.counts))
+	for command, count := range stats.counts {
+		counts[command] = count
+	}
+	return counts
+}
+
+// ConnectionStats are the counts of our current connections.
+type ConnectionStats struct {
+	Total     int            `json:"total"`
+	TLS       int            `json:"tls"`
+	Plaintext int            `json:"plaintext"`
+	Listeners map[string]int `json:"listeners"`
+}
+
+// MemoryStats describe how much memory we're using.
+type MemoryStats struct {
+	Alloc       uint64 `json:"alloc"`
+	TotalAlloc  uint64 `json:"total-alloc"`
+	Sys         uint64 `json:"sys"`
+	HeapObjects uint64 `json:"heap-objects"`
+	NumGC       uint32 `json:"num-gc"`
+	Goroutines  int    `json:"goroutines"`
+}
+
+// ServerStats are the statistics we give to dashboards and monitoring tools.
+type ServerStats struct {
+	StartedAt   time.Time         `json:"started-at"`
+	Uptime      float64           `json:"uptime"`
+	Lusers      LUserStats        `json:"lusers"`
+	Connections ConnectionStats   `json:"connections"`
+	Commands    map[string]uint64 `json:"commands"`
+	Memory      MemoryStats       `json:"memory"`
+}
+
+// Stats returns our current statistics.
+func (server *Server) Stats() ServerStats {
+	stats := ServerStats{
+		StartedAt: server.ctime,
+		Uptime:    time.Since(server.ctime).Seconds(),
+		Lusers:    server.LUserStats(),
+		Commands:  server.commandStats.Counts(),
+		Connections: ConnectionStats{
+			Listeners: make(map[string]int),
+		},
+	}
+
+	server.clients.ByNickMutex.RLock()
+	for _, client := range server.clients.ByNick {
+		stats.Connections.Total++
+		if client.HasMode(TLS) {
+			stats.Connections.TLS++
+		} else {
+			stats.Connections.Plaintext++
+		}
+		stats.Connections.Listeners[client.listener]++
+	}
+	server.clients.ByNickMutex.RUnlock()
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats.Memory = MemoryStats{
+		Alloc:       memStats.Alloc,
+		TotalAlloc:  memStats.TotalAlloc,
+		Sys:         memStats.Sys,
+		HeapObjects: memStats.HeapObjects,
+		NumGC:       memStats.NumGC,
+		Goroutines:  runtime.NumGoroutine(),
+	}
+	return stats
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestServerStats(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatalf("could not open datastore: %s", err.Error())
+	}
+	server := &Server{
+		clients:            NewClientLookupSet(),
+		channels:           *NewChannelNameMap(),
+		ctime:              time.Now().Add(-time.Minute),
+		store:              store,
+		unregisteredLimits: NewUnregisteredLimits(0),
+	}
+	server.clients.ByNick["alice"] = &Client{registered: true, listener: ":6697", flags: map[Mode]bool{TLS: true}}
+	server.clients.ByNick["bob"] = &Client{registered: true, listener: ":6667", flags: map[Mode]bool{}}
+	server.clients.ByNick["carol"] = &Client{registered: true, listener: ":6667", flags: map[Mode]bool{}}
+
+	server.commandStats.Add("PRIVMSG")
+	server.commandStats.Add("PRIVMSG")
+	server.commandStats.Add("JOIN")
+
+	stats := server.Stats()
+	if stats.Commands["PRIVMSG"] != 2 || stats.Commands["JOIN"] != 1 || len(stats.Commands) != 2 {
+		t.Errorf("unexpected command counts: %v", stats.Commands)
+	}
+	connections := stats.Connections
+	if connections.Total != 3 || connections.TLS != 1 || connections.Plaintext != 2 || connections.Listeners[":6667"] != 2 || connections.Listeners[":6697"] != 1 {
+		t.Errorf("unexpected connection counts: %+v", connections)
+	}
+	if stats.Uptime < 60 || stats.Memory.Sys == 0 || stats.Memory.Goroutines == 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
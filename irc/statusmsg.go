@@ -0,0 +1,78 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+)
+
+// statusMsgTarget returns the target that a message to the given channel is relayed
+// with. STATUSMSG keeps its prefix, so recipients can tell it apart from the channel.
+func statusMsgTarget(channelName string, minPrefix *Mode) string {
+	if minPrefix == nil {
+		return channelName
+	}
+	return ChannelModePrefixes[*minPrefix] + channelName
+}
+
+// statusMsgPrefix returns the prefix stored in the history for a message sent to the
+// given lowest prefix mode, or an empty string if the message was sent to everyone.
+func statusMsgPrefix(minPrefix *Mode) string {
+	if minPrefix == nil {
+		return ""
+	}
+	return ChannelModePrefixes[*minPrefix]
+}
+
+// canSeeHistoryItemNoMutex returns true if the client can see the given history item,
+// which they can't if it's a STATUSMSG sent to a higher privilege than theirs.
+func (channel *Channel) canSeeHistoryItemNoMutex(client *Client, item history.Item) bool {
+	if item.MinPrefix == "" {
+		return true
+	}
+	for mode, prefix := range ChannelModePrefixes {
+		if prefix == item.MinPrefix {
+			return channel.clientIsAtLeastNoMutex(client, mode)
+		}
+	}
+	return false
+}
+
+// VisibleHistory returns the given history items, leaving out the ones the client
+// can't see.
+func (channel *Channel) VisibleHistory(client *Client, items []history.Item) []history.Item {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+
+	var visible []history.Item
+	for _, item := range items {
+		if channel.canSeeHistoryItemNoMutex(client, item) {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
+
+// WALLCHOPS <channel> <message>
+func wallchopsHandler(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	// WALLCHOPS is a NOTICE to the channel's ops, as STATUSMSG does it, so each of the
+	// targets needs the prefix
+	var targets []string
+	for _, target := range strings.Split(msg.Params[0], ",") {
+		if _, err := CasefoldChannel(target); err != nil {
+			client.Send(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, target, client.t("No such channel"))
+			continue
+		}
+		targets = append(targets, ChannelModePrefixes[ChannelOperator]+target)
+	}
+	if len(targets) == 0 {
+		return false
+	}
+	msg.Command = "NOTICE"
+	msg.Params = []string{strings.Join(targets, ","), msg.Params[1]}
+	return noticeHandler(server, client, msg)
+}
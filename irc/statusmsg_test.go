@@ -0,0 +1,92 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+)
+
+func TestStatusMsgTarget(t *testing.T) {
+	if statusMsgTarget("#chan", nil) != "#chan" {
+		t.Error("messages to everyone should be relayed to the channel")
+	}
+	if statusMsgTarget("#chan", &ChannelOperator) != "@#chan" {
+		t.Error("STATUSMSG should be relayed with its prefix")
+	}
+	if statusMsgTarget("#chan", &Voice) != "+#chan" {
+		t.Error("STATUSMSG should be relayed with its prefix")
+	}
+}
+
+func TestVisibleHistory(t *testing.T) {
+	op, voiced, user := &Client{}, &Client{}, &Client{}
+	channel := &Channel{members: make(MemberSet)}
+	for _, member := range []*Client{op, voiced, user} {
+		channel.members.Add(member)
+	}
+	channel.members[op][ChannelOperator] = true
+	channel.members[voiced][Voice] = true
+
+	items := []history.Item{
+		{Msgid: "everyone"},
+		{Msgid: "voiced", MinPrefix: statusMsgPrefix(&Voice)},
+		{Msgid: "ops", MinPrefix: statusMsgPrefix(&ChannelOperator)},
+	}
+	var tests = []struct {
+		client *Client
+		seen   int
+	}{
+		{op, 3},
+		{voiced, 2},
+		{user, 1},
+	}
+	for i, test := range tests {
+		visible := channel.VisibleHistory(test.client, items)
+		if len(visible) != test.seen {
+			t.Errorf("client %d should see %d items, saw %d", i, test.seen, len(visible))
+		}
+	}
+}
+
+func TestWallchopsTargets(t *testing.T) {
+	server := newTestServer()
+	server.config = &Config{}
+	server.hooks = NewHooks(nil, server.logger)
+	server.abuseReports = NewAbuseReporter(AbuseReportsConfig{}, server.logger)
+	server.clients = NewClientLookupSet()
+	server.limits.MaxTargets = 4
+	sender := newTestClient(server, "sender")
+	sender.history = history.NewHistoryBuffer(0)
+	var ops, members []*Client
+	for _, name := range []string{"#a", "#b"} {
+		channel := NewChannel(server, name, false)
+		op := newTestClient(server, "op"+name[1:])
+		member := newTestClient(server, "member"+name[1:])
+		for _, client := range []*Client{sender, op, member} {
+			channel.members.Add(client)
+		}
+		channel.members[op][ChannelOperator] = true
+		ops = append(ops, op)
+		members = append(members, member)
+	}
+
+	wallchopsHandler(server, sender, ircmsg.MakeMessage(nil, "", "WALLCHOPS", "#a,#b,alice", "ops only"))
+	for _, op := range ops {
+		if lines := sentLines(op); len(lines) != 1 || !strings.Contains(lines[0], "NOTICE @#") {
+			t.Errorf("expected %s to get the notice, got %q", op.nick, lines)
+		}
+	}
+	for _, member := range members {
+		if lines := sentLines(member); len(lines) != 0 {
+			t.Errorf("expected %s not to get the notice, got %q", member.nick, lines)
+		}
+	}
+	if lines := sentLines(sender); len(lines) != 1 || !strings.Contains(lines[0], " 403 sender alice ") {
+		t.Errorf("expected non-channel targets to be refused, got %q", lines)
+	}
+}
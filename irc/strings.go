@@ -6,14 +6,21 @@
 package irc
 
 import (
+	"bytes"
 	"errors"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"golang.org/x/text/secure/precis"
 )
 
 const (
 	casemappingName = "rfc7613"
+
+	// casefoldCacheSize is the maximum number of entries kept in the casefold cache
+	// before it gets flushed.
+	casefoldCacheSize = 8192
 )
 
 var (
@@ -21,23 +28,78 @@ var (
 	errEmpty            = errors.New("String is empty")
 )
 
+// casefoldResult is a cached result of casefolding a string.
+type casefoldResult struct {
+	folded string
+	err    error
+}
+
+// casefoldCache caches casefolding results, since the same nicks and channel names
+// get casefolded over and over again and PRECIS casefolding isn't cheap.
+type casefoldCache struct {
+	sync.RWMutex
+	results map[string]casefoldResult
+	size    int
+}
+
+// newCasefoldCache returns a new casefoldCache that holds up to size entries.
+func newCasefoldCache(size int) *casefoldCache {
+	return &casefoldCache{
+		results: make(map[string]casefoldResult),
+		size:    size,
+	}
+}
+
+// Get returns the casefolded version of str, using a cached result if we have one.
+func (cache *casefoldCache) Get(str string) (string, error) {
+	cache.RLock()
+	result, exists := cache.results[str]
+	cache.RUnlock()
+	if exists {
+		return result.folded, result.err
+	}
+
+	folded, err := precis.UsernameCaseMapped.CompareKey(str)
+
+	cache.Lock()
+	// just flush everything when we fill up, entries for active clients and
+	// channels will be re-added quickly enough
+	if cache.size <= len(cache.results) {
+		cache.results = make(map[string]casefoldResult)
+	}
+	cache.results[str] = casefoldResult{
+		folded: folded,
+		err:    err,
+	}
+	cache.Unlock()
+
+	return folded, err
+}
+
+var casefoldResults = newCasefoldCache(casefoldCacheSize)
+
 // Casefold returns a casefolded string, without doing any name or channel character checks.
 func Casefold(str string) (string, error) {
-	return precis.UsernameCaseMapped.CompareKey(str)
+	return casefoldResults.Get(str)
 }
 
 // CasefoldChannel returns a casefolded version of a channel name.
 func CasefoldChannel(name string) (string, error) {
-	lowered, err := Casefold(name)
-
-	if err != nil {
-		return "", err
-	} else if len(lowered) == 0 {
+	if len(name) == 0 {
 		return "", errEmpty
+	} else if name[0] != '#' {
+		return "", errInvalidCharacter
 	}
 
-	if lowered[0] != '#' {
-		return "", errInvalidCharacter
+	// the rest of the name is casefolded by itself, since PRECIS' bidi rule rejects
+	// names that start with a # and contain non-ASCII characters
+	lowered := "#"
+	if 1 < len(name) {
+		rest, err := Casefold(name[1:])
+		if err != nil {
+			return "", err
+		}
+		lowered += rest
 	}
 
 	// space can't be used
@@ -48,7 +110,33 @@ func CasefoldChannel(name string) (string, error) {
 		return "", errInvalidCharacter
 	}
 
-	return lowered, err
+	return lowered, nil
+}
+
+// truncateUTF8 shortens the string to at most the given number of bytes, without
+// cutting a multi-byte character in half.
+func truncateUTF8(str string, length int) string {
+	if len(str) <= length {
+		return str
+	}
+	for 0 < length && !utf8.RuneStart(str[length]) {
+		length--
+	}
+	return str[:length]
+}
+
+// toValidUTF8 replaces the invalid bytes in the given string with the Unicode replacement
+// character, like strings.ToValidUTF8 (which needs a newer Go than we support).
+func toValidUTF8(str string) string {
+	if utf8.ValidString(str) {
+		return str
+	}
+	var buf bytes.Buffer
+	for _, r := range str {
+		// invalid bytes come out of range as utf8.RuneError, one at a time
+		buf.WriteRune(r)
+	}
+	return buf.String()
 }
 
 // CasefoldName returns a casefolded version of a nick/user name.
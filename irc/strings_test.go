@@ -39,6 +39,14 @@ func TestCasefoldChannel(t *testing.T) {
 			channel: "#",
 			folded:  "#",
 		},
+		{
+			channel: "#Оragono",
+			folded:  "#оragono",
+		},
+		{
+			channel: "#cafe\u0301",
+			folded:  "#caf\u00e9",
+		},
 	}
 
 	for _, errCase := range []string{
@@ -103,3 +111,67 @@ func TestCasefoldName(t *testing.T) {
 		})
 	}
 }
+
+func TestCasefoldCache(t *testing.T) {
+	cache := newCasefoldCache(2)
+
+	for i := 0; i < 2; i++ {
+		res, err := cache.Get("FooBar")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if res != "foobar" {
+			t.Errorf("expected FooBar to be foobar, got %v", res)
+		}
+	}
+
+	cache.Get("one")
+	cache.Get("two")
+	if len(cache.results) > 2 {
+		t.Errorf("expected cache to hold at most 2 entries, has %d", len(cache.results))
+	}
+
+	res, err := cache.Get("TWO")
+	if err != nil || res != "two" {
+		t.Errorf("expected TWO to be two, got %v (%v)", res, err)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	testCases := []struct {
+		str       string
+		length    int
+		truncated string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"h\u00e9llo", 2, "h"},
+		{"h\u00e9llo", 3, "h\u00e9"},
+		{"\u2603\u2603", 4, "\u2603"},
+		{"\u2603", 1, ""},
+	}
+	for _, tt := range testCases {
+		res := truncateUTF8(tt.str, tt.length)
+		if res != tt.truncated {
+			t.Errorf("expected %q truncated to %d to be %q, got %q", tt.str, tt.length, tt.truncated, res)
+		}
+	}
+}
+
+func TestToValidUTF8(t *testing.T) {
+	testCases := []struct {
+		str   string
+		valid string
+	}{
+		{"hello", "hello"},
+		{"h\u00e9llo \ufffd", "h\u00e9llo \ufffd"},
+		{"bad\xffbyte", "bad\ufffdbyte"},
+		{"cut \xe2\x98", "cut \ufffd\ufffd"},
+	}
+	for _, tt := range testCases {
+		if res := toValidUTF8(tt.str); res != tt.valid {
+			t.Errorf("expected %q to be made into %q, got %q", tt.str, tt.valid, res)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// sdNotifySocketEnv is where systemd tells Type=notify services to send their state.
+	sdNotifySocketEnv = "NOTIFY_SOCKET"
+	// sdWatchdogUsecEnv and sdWatchdogPIDEnv tell us whether (and how often) we need to
+	// pet the systemd watchdog.
+	sdWatchdogUsecEnv = "WATCHDOG_USEC"
+	sdWatchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// sdNotify sends the given state (such as "READY=1") to systemd. If we weren't started
+// by systemd as a Type=notify service, it does nothing.
+func sdNotify(state string) error {
+	socketName := os.Getenv(sdNotifySocketEnv)
+	if socketName == "" {
+		return nil
+	}
+	// abstract sockets are given with a leading @
+	if socketName[0] == '@' {
+		socketName = "\x00" + socketName[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketName, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns how often we should pet the systemd watchdog, or 0 if it's
+// not enabled for us.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(sdWatchdogUsecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// the watchdog may be meant for another process, such as the one we upgraded from
+	pid := os.Getenv(sdWatchdogPIDEnv)
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	// pet it twice as often as it needs, so a slow tick doesn't get us killed
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/logger"
+)
+
+var (
+	errInvalidDuration = errors.New("Invalid duration")
+)
+
+// parseTimedMask splits a list mode argument like *!*@example.com,24h into its mask and
+// how long it's set for. Masks and nicks can't contain commas, so anything after the
+// last one is the duration. Masks without a duration are set until they're removed.
+func parseTimedMask(arg string) (mask string, duration time.Duration, err error) {
+	sep := strings.LastIndex(arg, ",")
+	if sep == -1 {
+		return arg, 0, nil
+	}
+	duration, err = custime.ParseDuration(arg[sep+1:])
+	if err != nil || duration <= 0 {
+		return "", 0, errInvalidDuration
+	}
+	return arg[:sep], duration, nil
+}
+
+// scheduleListExpiryNoMutex sets the channel's timer to remove its list entries when the
+// first of them expires, so only channels with timed entries are ever looked at.
+func (channel *Channel) scheduleListExpiryNoMutex() {
+	if channel.listExpiryTimer != nil {
+		channel.listExpiryTimer.Stop()
+		channel.listExpiryTimer = nil
+	}
+	var next time.Time
+	for _, mode := range listModes {
+		expires := channel.lists[mode].NextExpiry()
+		if !expires.IsZero() && (next.IsZero() || expires.Before(next)) {
+			next = expires
+		}
+	}
+	if !next.IsZero() {
+		channel.listExpiryTimer = time.AfterFunc(time.Until(next), channel.expireTimedModes)
+	}
+}
+
+// expireTimedModes is run by the channel's timer when its first list entry expires.
+func (channel *Channel) expireTimedModes() {
+	// the channel's been emptied and replaced since the timer was set
+	if channel.server.channels.Get(channel.nameCasefolded) != channel {
+		return
+	}
+	channel.server.expireChannelTimedModes(channel, time.Now())
+}
+
+// expireChannelTimedModes removes the list entries of the given channel that have expired
+// by the given time, tells the channel's members, and waits for the next to expire.
+func (server *Server) expireChannelTimedModes(channel *Channel, now time.Time) {
+	channel.membersMutex.Lock()
+	defer channel.membersMutex.Unlock()
+	defer channel.scheduleListExpiryNoMutex()
+
+	var removed ModeChanges
+	for _, mode := range listModes {
+		list := channel.lists[mode]
+		for _, mask := range list.Expired(now) {
+			list.Remove(mask)
+			removed = append(removed, ModeChange{
+				mode: mode,
+				op:   Remove,
+				arg:  mask,
+			})
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	channel.sendModeChangesNoMutex(func(member *Client, args []string) {
+		member.Send(nil, server.name, "MODE", args...)
+	}, removed)
+	server.saveChannelListsNoMutex(channel)
+	server.logger.Info("channels", fmt.Sprintf("Expired %s in channel %s", removed.String(), channel.name))
+}
+
+// chanservBan handles CS BAN <channel> <ADD|DEL|LIST> [mask] [duration], which bans
+// masks (optionally for a limited time), unbans them, and lists the channel's bans.
+func (server *Server) chanservBan(client *Client, params []string) {
+	if len(params) < 2 {
+		client.ChanServNotice(client.t("Syntax: BAN <channel> <ADD|DEL|LIST> [mask] [duration]"))
+		return
+	}
+
+	channelKey, err := CasefoldChannel(params[0])
+	channel := server.channels.Get(channelKey)
+	if err != nil || channel == nil {
+		client.ChanServNotice(client.t("No such channel"))
+		return
+	}
+	if !channel.ClientIsAtLeast(client, ChannelOperator) {
+		client.ChanServNotice(client.t("You must be an oper on the channel to change its bans"))
+		return
+	}
+
+	subcommand := strings.ToLower(params[1])
+	if subcommand == "list" {
+		server.chanservBanList(client, channel)
+		return
+	}
+	if len(params) < 3 || (subcommand != "add" && subcommand != "del") {
+		client.ChanServNotice(client.t("Syntax: BAN <channel> <ADD|DEL|LIST> [mask] [duration]"))
+		return
+	}
+
+	mask := fantasyBanMask(params[2])
+	casefoldedMask, err := Casefold(mask)
+	if err != nil {
+		client.ChanServNotice(client.t("Invalid mask"))
+		return
+	}
+
+	existed, _ := channel.MaskInfo(BanMask, casefoldedMask)
+	if subcommand == "del" && !existed {
+		client.ChanServNotice(fmt.Sprintf(client.t("%[1]s is not banned in %[2]s"), mask, channel.name))
+		return
+	}
+
+	// the bans are changed with MODE, so they're checked and sent out the same way
+	modeParams := []string{channel.name, "-b", mask}
+	if subcommand == "add" {
+		modeParams[1] = "+b"
+		if 3 < len(params) {
+			duration, err := custime.ParseDuration(params[3])
+			if err != nil || duration <= 0 {
+				client.ChanServNotice(client.t(errInvalidDuration.Error()))
+				return
+			}
+			modeParams[2] = mask + "," + params[3]
+		}
+	}
+	cmodeHandler(server, client, ircmsg.MakeMessage(nil, client.nickMaskString, "MODE", modeParams...))
+
+	exists, info := channel.MaskInfo(BanMask, casefoldedMask)
+	if subcommand == "del" && !exists {
+		client.ChanServNotice(fmt.Sprintf(client.t("Unbanned %[1]s in %[2]s"), mask, channel.name))
+		server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s unbanned %s in channel %s", client.nick, mask, channel.name))
+	} else if subcommand == "add" && exists {
+		if info.Expires.IsZero() {
+			client.ChanServNotice(fmt.Sprintf(client.t("Banned %[1]s in %[2]s"), mask, channel.name))
+		} else {
+			client.ChanServNotice(fmt.Sprintf(client.t("Banned %[1]s in %[2]s until %[3]s"), mask, channel.name, info.Expires.Format(time.RFC1123)))
+		}
+		server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s banned %s in channel %s", client.nick, mask, channel.name))
+	}
+}
+
+// chanservBanList shows the client the given channel's bans, and when they expire.
+func (server *Server) chanservBanList(client *Client, channel *Channel) {
+	channel.membersMutex.RLock()
+	list := channel.lists[BanMask]
+	var lines []string
+	for mask := range list.masks {
+		expires := list.Info(mask).Expires
+		if expires.IsZero() {
+			lines = append(lines, mask)
+		} else {
+			lines = append(lines, fmt.Sprintf(client.t("%[1]s (expires in %[2]s)"), mask, timeLeft(expires).String()))
+		}
+	}
+	channel.membersMutex.RUnlock()
+	sort.Strings(lines)
+
+	client.ChanServNotice(fmt.Sprintf(client.t("Bans in %s:"), channel.name))
+	for _, line := range lines {
+		client.ChanServNotice(line)
+	}
+	client.ChanServNotice(client.t("End of bans"))
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+func TestParseTimedMask(t *testing.T) {
+	var tests = []struct {
+		arg      string
+		mask     string
+		duration time.Duration
+		err      error
+	}{
+		{"*!*@example.com", "*!*@example.com", 0, nil},
+		{"*!*@example.com,24h", "*!*@example.com", 24 * time.Hour, nil},
+		{"$a:dan,1d", "$a:dan", 24 * time.Hour, nil},
+		{"*!*@2001:db8::1,90m", "*!*@2001:db8::1", 90 * time.Minute, nil},
+		{"*!*@example.com,", "", 0, errInvalidDuration},
+		{"*!*@example.com,soon", "", 0, errInvalidDuration},
+		{"*!*@example.com,-1h", "", 0, errInvalidDuration},
+	}
+	for _, test := range tests {
+		mask, duration, err := parseTimedMask(test.arg)
+		if mask != test.mask || duration != test.duration || err != test.err {
+			t.Errorf("expected %s to parse as %s for %s (%v), got %s for %s (%v)", test.arg, test.mask, test.duration, test.err, mask, duration, err)
+		}
+	}
+}
+
+func TestExpireTimedModes(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatalf("could not open datastore: %s", err.Error())
+	}
+	logman, _ := logger.NewManager()
+	server := &Server{
+		channels:           *NewChannelNameMap(),
+		channelSkeletons:   NewSkeletons(),
+		config:             &Config{},
+		logger:             logman,
+		registeredChannels: make(map[string]*RegisteredChannel),
+		store:              store,
+	}
+	server.store.Update(func(tx *buntdb.Tx) error {
+		server.saveChannelNoMutex(tx, "#test", RegisteredChannel{Name: "#test"})
+		return nil
+	})
+
+	channel := NewChannel(server, "#test", false)
+	now := time.Now()
+	bans := channel.lists[BanMask]
+	bans.Add("*!*@permanent")
+	bans.Add("*!*@expired")
+	bans.SetInfo("*!*@expired", MaskInfo{Expires: now.Add(-time.Second)})
+	bans.Add("*!*@later")
+	bans.SetInfo("*!*@later", MaskInfo{Expires: now.Add(time.Hour)})
+	channel.lists[InviteMask].Add("*!*@invited")
+	channel.lists[InviteMask].SetInfo("*!*@invited", MaskInfo{Expires: now})
+
+	server.expireChannelTimedModes(channel, now)
+	if channel.listExpiryTimer == nil {
+		t.Error("expected a timer to be set for the later ban")
+	}
+
+	if !bans.masks["*!*@permanent"] || !bans.masks["*!*@later"] || bans.masks["*!*@expired"] || channel.lists[InviteMask].masks["*!*@invited"] {
+		t.Errorf("expected only the expired entries to be removed, got bans [%s] and invites [%s]", bans.String(), channel.lists[InviteMask].String())
+	}
+
+	// the remaining entries and their expiry are saved, so they're restored on restart
+	chanReg := server.registeredChannels["#test"]
+	if len(chanReg.Banlist) != 2 || len(chanReg.Invitelist) != 0 {
+		t.Errorf("expected the saved lists to be updated, got %v and %v", chanReg.Banlist, chanReg.Invitelist)
+	}
+	server.registeredChannels = make(map[string]*RegisteredChannel)
+	server.store.View(func(tx *buntdb.Tx) error {
+		chanReg = server.loadChannelNoMutex(tx, "#test")
+		return nil
+	})
+	expires := chanReg.ListInfo["b"]["*!*@later"].Expires
+	if len(chanReg.ListInfo["b"]) != 1 || expires.Unix() != now.Add(time.Hour).Unix() {
+		t.Errorf("expected the later ban's expiry to be saved, got %v", chanReg.ListInfo)
+	}
+}
+
+func TestListExpiryTimer(t *testing.T) {
+	server := newTestServer()
+	channel := NewChannel(server, "#test", false)
+	channel.scheduleListExpiryNoMutex()
+	if channel.listExpiryTimer != nil {
+		t.Error("expected no timer for a channel without timed entries")
+	}
+
+	bans := channel.lists[BanMask]
+	bans.Add("*!*@soon")
+	bans.SetInfo("*!*@soon", MaskInfo{Expires: time.Now().Add(10 * time.Millisecond)})
+	channel.membersMutex.Lock()
+	channel.scheduleListExpiryNoMutex()
+	channel.membersMutex.Unlock()
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if exists, _ := channel.MaskInfo(BanMask, "*!*@soon"); !exists {
+			return
+		}
+	}
+	t.Error("expected the timed ban to be removed when it expired")
+}
@@ -15,12 +15,15 @@ import (
 type ChannelNameMap struct {
 	ChansLock sync.RWMutex
 	Chans     map[string]*Channel
+	// skeletons holds the skeletons of our channels' names, to find lookalikes of them
+	skeletons *Skeletons
 }
 
 // NewChannelNameMap returns a new ChannelNameMap.
 func NewChannelNameMap() *ChannelNameMap {
 	var channels ChannelNameMap
 	channels.Chans = make(map[string]*Channel)
+	channels.skeletons = NewSkeletons()
 	return &channels
 }
 
@@ -43,6 +46,7 @@ func (channels *ChannelNameMap) Add(channel *Channel) error {
 		return fmt.Errorf("%s: already set", channel.name)
 	}
 	channels.Chans[channel.nameCasefolded] = channel
+	channels.skeletons.Add(channel.nameCasefolded)
 	return nil
 }
 
@@ -54,9 +58,22 @@ func (channels *ChannelNameMap) Remove(channel *Channel) error {
 		return fmt.Errorf("%s: mismatch", channel.name)
 	}
 	delete(channels.Chans, channel.nameCasefolded)
+	channels.skeletons.Remove(channel.nameCasefolded)
 	return nil
 }
 
+// All returns a snapshot of all our channels, so callers don't need to hold our lock
+// while doing something slow with each of them.
+func (channels *ChannelNameMap) All() []*Channel {
+	channels.ChansLock.RLock()
+	defer channels.ChansLock.RUnlock()
+	all := make([]*Channel, 0, len(channels.Chans))
+	for _, channel := range channels.Chans {
+		all = append(all, channel)
+	}
+	return all
+}
+
 // Len returns how many channels we have.
 func (channels *ChannelNameMap) Len() int {
 	channels.ChansLock.RLock()
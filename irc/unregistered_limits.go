@@ -0,0 +1,89 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	errTooManyUnregistered = errors.New("Too many unregistered connections from IP")
+)
+
+// UnregisteredLimits keeps track of how many connections each IP has that haven't
+// finished registering yet, so a single IP can't tie up all our connection slots.
+type UnregisteredLimits struct {
+	sync.Mutex
+
+	// maxPerIP is the maximum number of unregistered connections per IP, 0 meaning unlimited
+	maxPerIP int
+	// population holds IP -> count of unregistered connections from there
+	population map[string]int
+}
+
+// NewUnregisteredLimits returns a new UnregisteredLimits.
+func NewUnregisteredLimits(maxPerIP int) *UnregisteredLimits {
+	return &UnregisteredLimits{
+		maxPerIP:   maxPerIP,
+		population: make(map[string]int),
+	}
+}
+
+// Max returns the maximum number of unregistered connections per IP.
+func (ul *UnregisteredLimits) Max() int {
+	ul.Lock()
+	defer ul.Unlock()
+
+	return ul.maxPerIP
+}
+
+// SetMax sets the maximum number of unregistered connections per IP.
+func (ul *UnregisteredLimits) SetMax(maxPerIP int) {
+	ul.Lock()
+	defer ul.Unlock()
+
+	ul.maxPerIP = maxPerIP
+}
+
+// Count returns how many unregistered connections there are.
+func (ul *UnregisteredLimits) Count() int {
+	ul.Lock()
+	defer ul.Unlock()
+
+	var count int
+	for _, population := range ul.population {
+		count += population
+	}
+	return count
+}
+
+// Add adds an unregistered connection from the given IP if possible. If we can't,
+// returns an error instead.
+func (ul *UnregisteredLimits) Add(addr net.IP) error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	addrString := addr.String()
+	if 0 < ul.maxPerIP && ul.maxPerIP <= ul.population[addrString] {
+		return errTooManyUnregistered
+	}
+
+	ul.population[addrString]++
+	return nil
+}
+
+// Remove removes an unregistered connection from the given IP, either because it's
+// registered or because it's gone away.
+func (ul *UnregisteredLimits) Remove(addr net.IP) {
+	ul.Lock()
+	defer ul.Unlock()
+
+	addrString := addr.String()
+	ul.population[addrString]--
+	if ul.population[addrString] < 1 {
+		delete(ul.population, addrString)
+	}
+}
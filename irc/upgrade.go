@@ -0,0 +1,203 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// listenerFDsEnv is the environment variable we use to tell a newly-exec'd copy of
+	// ourselves which listening sockets we've handed over to it, and for which addresses.
+	listenerFDsEnv = "ORAGONO_LISTENER_FDS"
+	// upgradeFDsEnv is the environment variable we use to tell a newly-exec'd copy of
+	// ourselves which file descriptors to use for the upgrade handshake, as "ready,release".
+	upgradeFDsEnv = "ORAGONO_UPGRADE_FDS"
+	// firstInheritedFD is the first file descriptor passed to exec'd processes in ExtraFiles.
+	firstInheritedFD = 3
+	// upgradeReadyTimeout is how long we wait for a newly-exec'd copy of ourselves to say
+	// it's ready to take over, before giving up on the upgrade.
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+var (
+	errListenerNotTCP  = errors.New("Listener is not a TCP listener")
+	errUpgradeNotReady = errors.New("New process exited before it was ready")
+	errUpgradeTimedOut = errors.New("Timed out waiting for new process to be ready")
+)
+
+// inheritedListeners returns the listening sockets handed to us by the process that
+// exec'd us (if any), keyed by address.
+func inheritedListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+
+	value := os.Getenv(listenerFDsEnv)
+	if value == "" {
+		return listeners, nil
+	}
+	// don't pass these on to anything we exec ourselves
+	os.Unsetenv(listenerFDsEnv)
+
+	for i, addr := range strings.Split(value, ",") {
+		file := os.NewFile(uintptr(firstInheritedFD+i), addr)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not use inherited listener for %s: %s", addr, err.Error())
+		}
+		listeners[addr] = listener
+	}
+
+	return listeners, nil
+}
+
+// waitForUpgrade tells the process that exec'd us (if any) that we've started up, and
+// then waits for it to shut down, so that we don't both have the datastore open at once.
+func waitForUpgrade() error {
+	value := os.Getenv(upgradeFDsEnv)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(upgradeFDsEnv)
+
+	var readyFD, releaseFD int
+	_, err := fmt.Sscanf(value, "%d,%d", &readyFD, &releaseFD)
+	if err != nil {
+		return fmt.Errorf("Could not parse upgrade handshake descriptors [%s]: %s", value, err.Error())
+	}
+	ready := os.NewFile(uintptr(readyFD), "upgrade-ready")
+	release := os.NewFile(uintptr(releaseFD), "upgrade-release")
+	defer release.Close()
+
+	_, err = ready.Write([]byte{1})
+	ready.Close()
+	if err != nil {
+		return fmt.Errorf("Could not tell the old process we're ready: %s", err.Error())
+	}
+
+	// the old process closes its end once it's closed the datastore, or when it exits
+	io.Copy(ioutil.Discard, release)
+	return nil
+}
+
+// upgrade starts a new copy of our binary with the same arguments, handing it our
+// listening sockets so that it can start accepting connections straight away. It returns
+// once the new process is ready to take over, and the new process waits for us to close
+// the datastore (with releaseUpgrade) before it opens it.
+func (server *Server) upgrade() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	// don't let a rehash add or remove listeners while we're handing them over
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	server.listenerUpdateMutex.Lock()
+	for addr, li := range server.listeners {
+		tcpListener, isTCP := li.rawListener.(*net.TCPListener)
+		if !isTCP {
+			server.listenerUpdateMutex.Unlock()
+			return errListenerNotTCP
+		}
+		file, err := tcpListener.File()
+		if err != nil {
+			server.listenerUpdateMutex.Unlock()
+			return fmt.Errorf("Could not get socket for listener %s: %s", addr, err.Error())
+		}
+		addrs = append(addrs, addr)
+		files = append(files, file)
+	}
+	server.listenerUpdateMutex.Unlock()
+
+	// the new process says it's ready on one pipe, and waits for us to close the other
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("Could not create upgrade handshake pipe: %s", err.Error())
+	}
+	defer readyReader.Close()
+	releaseReader, releaseWriter, err := os.Pipe()
+	if err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("Could not create upgrade handshake pipe: %s", err.Error())
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", listenerFDsEnv, strings.Join(addrs, ",")),
+		fmt.Sprintf("%s=%d,%d", upgradeFDsEnv, firstInheritedFD+len(files), firstInheritedFD+len(files)+1),
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyWriter, releaseReader)
+
+	err = cmd.Start()
+	// only the new process should have these ends open, so we see it exiting
+	readyWriter.Close()
+	releaseReader.Close()
+	if err != nil {
+		releaseWriter.Close()
+		return fmt.Errorf("Could not start new process: %s", err.Error())
+	}
+
+	server.logger.Info("upgrade", fmt.Sprintf("Started new process [%d], handed over %d listener(s)", cmd.Process.Pid, len(addrs)))
+
+	readyResult := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		if err == io.EOF {
+			err = errUpgradeNotReady
+		}
+		readyResult <- err
+	}()
+	select {
+	case err = <-readyResult:
+	case <-time.After(upgradeReadyTimeout):
+		err = errUpgradeTimedOut
+	}
+	if err != nil {
+		// it hasn't touched the datastore yet, so we can keep going as if nothing happened
+		releaseWriter.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("New process [%d] didn't start properly: %s", cmd.Process.Pid, err.Error())
+	}
+	server.upgradeRelease = releaseWriter
+
+	server.logger.Info("upgrade", fmt.Sprintf("New process [%d] is ready to take over", cmd.Process.Pid))
+
+	// if systemd is supervising us, the new process is the one to watch from now on
+	err = sdNotify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+	if err != nil {
+		server.logger.Warning("upgrade", fmt.Sprintf("Could not tell systemd about the new process: %s", err.Error()))
+	}
+	return nil
+}
+
+// releaseUpgrade lets the process we've upgraded to (if any) carry on starting up. It's
+// called once we've closed the datastore.
+func (server *Server) releaseUpgrade() {
+	if server.upgradeRelease != nil {
+		server.upgradeRelease.Close()
+		server.upgradeRelease = nil
+	}
+}
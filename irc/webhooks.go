@@ -0,0 +1,339 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+var (
+	errWebhookBadResponse = errors.New("Webhook answered with an error status")
+)
+
+// these are the events webhooks can be sent for
+const (
+	// WebhookOper is an alert sent to opers (the o snomask).
+	WebhookOper = "oper"
+	// WebhookAccountRegister is a new account being registered.
+	WebhookAccountRegister = "account-register"
+	// WebhookChannelCreate is a new channel being created.
+	WebhookChannelCreate = "channel-create"
+	// WebhookXline is a D-Line or K-Line being added or removed (the x snomask).
+	WebhookXline = "xline"
+	// WebhookStartup is the server starting.
+	WebhookStartup = "startup"
+	// WebhookShutdown is the server shutting down.
+	WebhookShutdown = "shutdown"
+)
+
+const (
+	// WebhookWorkers is how many webhooks we send at once.
+	WebhookWorkers = 2
+	// WebhookQueueLength is how many webhooks can be waiting for a worker before we
+	// start dropping new ones.
+	WebhookQueueLength = 256
+)
+
+var (
+	webhookEvents = map[string]bool{
+		WebhookOper:            true,
+		WebhookAccountRegister: true,
+		WebhookChannelCreate:   true,
+		WebhookXline:           true,
+		WebhookStartup:         true,
+		WebhookShutdown:        true,
+	}
+
+	// webhookTemplateFuncs are the extra functions webhook templates can use.
+	webhookTemplateFuncs = template.FuncMap{
+		// json writes the given value as JSON, so strings are quoted and escaped properly
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}
+)
+
+// WebhookConfig is a URL we POST to when the given events happen.
+type WebhookConfig struct {
+	URL           string
+	Events        []string
+	Headers       map[string]string
+	RawTemplate   string        `yaml:"template"`
+	TimeoutString string        `yaml:"timeout"`
+	Timeout       time.Duration `yaml:"timeout-real"`
+	Retries       int
+	template      *template.Template
+}
+
+// parseWebhooks checks the webhooks in the config and fills in their defaults.
+func (conf *Config) parseWebhooks() error {
+	for i := range conf.Webhooks {
+		webhook := &conf.Webhooks[i]
+		err := checkWebhook(webhook.URL, true)
+		if err != nil {
+			return fmt.Errorf("Webhook %s can't be used: %s", webhook.URL, err.Error())
+		}
+		if len(webhook.Events) == 0 {
+			return fmt.Errorf("Webhook %s isn't given any events", webhook.URL)
+		}
+		for _, event := range webhook.Events {
+			if !webhookEvents[event] {
+				return fmt.Errorf("Webhook %s is given unknown event %s", webhook.URL, event)
+			}
+		}
+		if webhook.RawTemplate != "" {
+			webhook.template, err = template.New(webhook.URL).Funcs(webhookTemplateFuncs).Parse(webhook.RawTemplate)
+			if err != nil {
+				return fmt.Errorf("Could not parse template of webhook %s: %s", webhook.URL, err.Error())
+			}
+		}
+		webhook.Timeout = defaultWebhookTimeout
+		if webhook.TimeoutString != "" {
+			webhook.Timeout, err = time.ParseDuration(webhook.TimeoutString)
+			if err != nil {
+				return fmt.Errorf("Could not parse timeout of webhook %s: %s", webhook.URL, err.Error())
+			}
+		}
+		if webhook.Retries < 0 {
+			return fmt.Errorf("Webhook %s can't have negative retries", webhook.URL)
+		}
+	}
+	return nil
+}
+
+// WebhookEvent is what we send to webhooks. Without a template it's sent as JSON,
+// and with one it's what the template is given.
+type WebhookEvent struct {
+	Event   string            `json:"event"`
+	Time    time.Time         `json:"time"`
+	Server  string            `json:"server"`
+	Network string            `json:"network"`
+	Message string            `json:"message"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// webhookJob is a webhook waiting to be sent by one of our workers.
+type webhookJob struct {
+	config  WebhookConfig
+	body    []byte
+	attempt int
+}
+
+// Webhooks sends our server events to the configured webhooks, retrying the ones
+// that fail with an increasing delay.
+type Webhooks struct {
+	sync.RWMutex
+
+	configs []WebhookConfig
+	jobs    chan webhookJob
+	// pending counts the jobs that are queued, being sent or waiting to be retried,
+	// and idle is closed when it drops back to zero. we don't use a WaitGroup for
+	// this, since jobs can be queued while we're being waited on
+	pendingMutex sync.Mutex
+	pending      int
+	idle         chan struct{}
+	// retryDelay is how long we wait before the first retry
+	retryDelay time.Duration
+	logger     *logger.Manager
+}
+
+// NewWebhooks returns a new Webhooks that sends to the given webhooks, with its
+// workers already running.
+func NewWebhooks(configs []WebhookConfig, logger *logger.Manager) *Webhooks {
+	webhooks := &Webhooks{
+		configs:    configs,
+		jobs:       make(chan webhookJob, WebhookQueueLength),
+		retryDelay: webhookRetryDelay,
+		logger:     logger,
+	}
+	for i := 0; i < WebhookWorkers; i++ {
+		go webhooks.runWorker()
+	}
+	return webhooks
+}
+
+// SetConfig changes the webhooks we send to.
+func (webhooks *Webhooks) SetConfig(configs []WebhookConfig) {
+	webhooks.Lock()
+	defer webhooks.Unlock()
+
+	webhooks.configs = configs
+}
+
+// Send sends the given event to every webhook that wants it.
+func (webhooks *Webhooks) Send(event WebhookEvent) {
+	webhooks.RLock()
+	configs := webhooks.configs
+	webhooks.RUnlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	for _, config := range configs {
+		wanted := false
+		for _, name := range config.Events {
+			if name == event.Event {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			continue
+		}
+
+		var body []byte
+		var err error
+		if config.template != nil {
+			var buf bytes.Buffer
+			err = config.template.Execute(&buf, event)
+			body = buf.Bytes()
+		} else {
+			body, err = json.Marshal(event)
+		}
+		if err != nil {
+			webhooks.logger.Warning("webhooks", fmt.Sprintf("Could not make %s event for webhook %s: %s", event.Event, config.URL, err.Error()))
+			continue
+		}
+
+		webhooks.queue(webhookJob{
+			config: config,
+			body:   body,
+		})
+	}
+}
+
+// addPending notes that a job's been queued.
+func (webhooks *Webhooks) addPending() {
+	webhooks.pendingMutex.Lock()
+	defer webhooks.pendingMutex.Unlock()
+
+	if webhooks.pending == 0 {
+		webhooks.idle = make(chan struct{})
+	}
+	webhooks.pending++
+}
+
+// donePending notes that a job's been sent, dropped or given up on.
+func (webhooks *Webhooks) donePending() {
+	webhooks.pendingMutex.Lock()
+	defer webhooks.pendingMutex.Unlock()
+
+	webhooks.pending--
+	if webhooks.pending == 0 {
+		close(webhooks.idle)
+	}
+}
+
+// queue adds the job to our queue, dropping it if the queue is full.
+func (webhooks *Webhooks) queue(job webhookJob) {
+	webhooks.addPending()
+	select {
+	case webhooks.jobs <- job:
+	default:
+		webhooks.donePending()
+		webhooks.logger.Warning("webhooks", fmt.Sprintf("Dropped event for webhook %s, our queue is full", job.config.URL))
+	}
+}
+
+// runWorker sends webhooks from our queue, and schedules the ones that fail to be retried.
+func (webhooks *Webhooks) runWorker() {
+	for job := range webhooks.jobs {
+		err := sendWebhook(job)
+		if err != nil && job.attempt < job.config.Retries {
+			delay := webhooks.retryDelay << uint(job.attempt)
+			webhooks.logger.Debug("webhooks", fmt.Sprintf("Could not send to webhook %s, trying again in %s: %s", job.config.URL, delay.String(), err.Error()))
+			job.attempt++
+			retry := job
+			time.AfterFunc(delay, func() {
+				webhooks.queue(retry)
+				webhooks.donePending()
+			})
+			continue
+		}
+		if err != nil {
+			webhooks.logger.Warning("webhooks", fmt.Sprintf("Could not send to webhook %s: %s", job.config.URL, err.Error()))
+		}
+		webhooks.donePending()
+	}
+}
+
+// Wait waits for up to the given time for our queued webhooks to be sent, such as
+// while we're shutting down.
+func (webhooks *Webhooks) Wait(timeout time.Duration) {
+	webhooks.pendingMutex.Lock()
+	pending := webhooks.pending
+	idle := webhooks.idle
+	webhooks.pendingMutex.Unlock()
+
+	if pending == 0 {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+}
+
+// sendWebhook POSTs the job's body to its webhook.
+func sendWebhook(job webhookJob) error {
+	request, err := http.NewRequest("POST", job.config.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range job.config.Headers {
+		request.Header.Set(name, value)
+	}
+	httpClient := http.Client{
+		Timeout: job.config.Timeout,
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || 299 < response.StatusCode {
+		return errWebhookBadResponse
+	}
+	return nil
+}
+
+// sendWebhook sends the given event to our webhooks.
+func (server *Server) sendWebhook(event string, message string, data map[string]string) {
+	server.webhooks.Send(WebhookEvent{
+		Event:   event,
+		Server:  server.name,
+		Network: server.Config().Network.Name,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// snomaskWebhooks are the webhook events sent for each snomask.
+var snomaskWebhooks = map[sno.Mask]string{
+	sno.LocalOpers: WebhookOper,
+	sno.LocalXline: WebhookXline,
+}
+
+// sendSnomaskWebhook sends the webhook event for the given snomask, if it has one.
+func (server *Server) sendSnomaskWebhook(mask sno.Mask, content string) {
+	event, exists := snomaskWebhooks[mask]
+	if exists {
+		server.sendWebhook(event, stripIRCFormatting(content), nil)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// webhookRecorder is a test webhook that fails the given number of requests, then
+// records the bodies of the ones after that.
+type webhookRecorder struct {
+	sync.Mutex
+	failures int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (recorder *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	recorder.Lock()
+	defer recorder.Unlock()
+	if 0 < recorder.failures {
+		recorder.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	recorder.bodies = append(recorder.bodies, string(body))
+	recorder.headers = append(recorder.headers, r.Header)
+}
+
+func TestWebhooks(t *testing.T) {
+	recorder := &webhookRecorder{failures: 1}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config := Config{
+		Webhooks: []WebhookConfig{
+			{
+				URL:     server.URL,
+				Events:  []string{WebhookChannelCreate},
+				Headers: map[string]string{"Authorization": "Bearer test"},
+				Retries: 1,
+			},
+			{
+				URL:         server.URL,
+				Events:      []string{WebhookOper},
+				RawTemplate: `{"text": {{json .Message}}}`,
+			},
+		},
+	}
+	err := config.parseWebhooks()
+	if err != nil {
+		t.Fatalf("could not parse webhooks: %s", err.Error())
+	}
+
+	logman, _ := logger.NewManager()
+	webhooks := NewWebhooks(config.Webhooks, logman)
+	webhooks.retryDelay = 10 * time.Millisecond
+
+	// the first attempt fails, so this is only recorded once it's retried
+	webhooks.Send(WebhookEvent{Event: WebhookChannelCreate, Message: "Channel #test created", Data: map[string]string{"channel": "#test"}})
+	webhooks.Wait(5 * time.Second)
+	webhooks.Send(WebhookEvent{Event: WebhookOper, Message: `Client "dan" opered up`})
+	// nobody wants this one
+	webhooks.Send(WebhookEvent{Event: WebhookStartup})
+	webhooks.Wait(5 * time.Second)
+
+	recorder.Lock()
+	defer recorder.Unlock()
+	if len(recorder.bodies) != 2 {
+		t.Fatalf("expected 2 webhooks to be sent, got %d: %v", len(recorder.bodies), recorder.bodies)
+	}
+
+	var event WebhookEvent
+	err = json.Unmarshal([]byte(recorder.bodies[0]), &event)
+	if err != nil {
+		t.Fatalf("could not parse default webhook body: %s", err.Error())
+	}
+	if event.Event != WebhookChannelCreate || event.Data["channel"] != "#test" || event.Time.IsZero() {
+		t.Errorf("unexpected default webhook body: %s", recorder.bodies[0])
+	}
+	if recorder.headers[0].Get("Authorization") != "Bearer test" {
+		t.Error("expected the configured header to be sent")
+	}
+
+	if recorder.bodies[1] != `{"text": "Client \"dan\" opered up"}` {
+		t.Errorf("unexpected templated webhook body: %s", recorder.bodies[1])
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	bad := []WebhookConfig{
+		{URL: "not a url", Events: []string{WebhookStartup}},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Events: []string{"nonexistent"}},
+		{URL: "https://example.com/hook", Events: []string{WebhookStartup}, RawTemplate: "{{"},
+		{URL: "https://example.com/hook", Events: []string{WebhookStartup}, Retries: -1},
+	}
+	for _, webhook := range bad {
+		config := Config{Webhooks: []WebhookConfig{webhook}}
+		if config.parseWebhooks() == nil {
+			t.Errorf("expected webhook %+v to be rejected", webhook)
+		}
+	}
+}
+
+func TestWebhooksWaitWhileSending(t *testing.T) {
+	server := httptest.NewServer(&webhookRecorder{})
+	defer server.Close()
+
+	logman, _ := logger.NewManager()
+	webhooks := NewWebhooks([]WebhookConfig{{URL: server.URL, Events: []string{WebhookOper}, Timeout: time.Second}}, logman)
+
+	// events can keep coming in while we're shutting down, and waiting shouldn't race with them
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				webhooks.Send(WebhookEvent{Event: WebhookOper})
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		webhooks.Wait(time.Millisecond)
+	}
+	wg.Wait()
+	webhooks.Wait(5 * time.Second)
+
+	webhooks.pendingMutex.Lock()
+	defer webhooks.pendingMutex.Unlock()
+	if webhooks.pending != 0 {
+		t.Errorf("expected every webhook to have been sent, %d are still pending", webhooks.pending)
+	}
+}
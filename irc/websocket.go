@@ -5,52 +5,201 @@
 package irc
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  2048,
-	WriteBufferSize: 2048,
-	// If a WS session contains sensitive information, and you choose to use
-	// cookies for authentication (during the HTTP(S) upgrade request), then
-	// you should check that Origin is a domain under your control. If it
-	// isn't, then it is possible for users of your site, visiting a naughty
-	// Origin, to have a WS opened using their credentials. See
-	// http://www.christian-schneider.net/CrossSiteWebSocketHijacking.html#main.
-	// We don't care about Origin because the (IRC) authentication is contained
-	// in the WS stream -- the WS session is not privileged when it is opened.
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
-// WSContainer holds the websocket.
+var (
+	errWebSocketListenerClosed = errors.New("WebSocket listener is closed")
+)
+
+const (
+	// webSocketReadHeaderTimeout is how long clients have to send their upgrade request.
+	webSocketReadHeaderTimeout = 10 * time.Second
+	// webSocketIdleTimeout is how long we keep HTTP connections open between requests,
+	// before they're upgraded.
+	webSocketIdleTimeout = 30 * time.Second
+)
+
+// WebSocketListenConfig is the config for a listener that takes WebSocket connections
+// from browser-based clients instead of plain IRC ones.
+type WebSocketListenConfig struct {
+	// AllowedOrigins are the origins (like https://example.com) that browsers can connect
+	// from. If it's empty, any origin can connect.
+	AllowedOrigins []string `yaml:"allowed-origins"`
+}
+
+// originAllowed returns true if browsers can connect to this listener from the given
+// origin. Connections without an origin aren't from browsers, so they're always allowed.
+func (conf *WebSocketListenConfig) originAllowed(origin string) bool {
+	if origin == "" || len(conf.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range conf.AllowedOrigins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WSContainer holds the websocket, and makes it look like a regular connection to our
+// Socket. Each WebSocket message holds one IRC line, without the CRLF.
 type WSContainer struct {
 	*websocket.Conn
+	// pending is the part of the last message we read that hasn't been returned yet
+	pending []byte
+}
+
+// Read reads new incoming messages, with a CRLF after each one.
+func (ws *WSContainer) Read(msg []byte) (int, error) {
+	for len(ws.pending) == 0 {
+		ty, bytes, err := ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		// other kinds of messages (like pings) are handled by the websocket library
+		if ty != websocket.TextMessage && ty != websocket.BinaryMessage {
+			continue
+		}
+		line := strings.TrimRight(string(bytes), "\r\n")
+		if line == "" {
+			continue
+		}
+		ws.pending = []byte(line + "\r\n")
+	}
+
+	n := copy(msg, ws.pending)
+	ws.pending = ws.pending[n:]
+	return n, nil
 }
 
-// Read reads new incoming messages.
-func (ws WSContainer) Read(msg []byte) (int, error) {
-	ty, bytes, err := ws.ReadMessage()
-	if ty == websocket.TextMessage {
-		n := copy(msg, []byte(string(bytes)+"\r\n\r\n"))
-		return n, err
+// Write writes lines out to the websocket, one message for each line.
+func (ws *WSContainer) Write(msg []byte) (int, error) {
+	for _, line := range strings.Split(string(msg), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		// text messages have to be valid UTF-8, or browsers close the connection
+		err := ws.WriteMessage(websocket.TextMessage, []byte(toValidUTF8(line)))
+		if err != nil {
+			return 0, err
+		}
 	}
-	// Binary, and other kinds of messages, are thrown away.
-	return 0, nil
+	return len(msg), nil
 }
 
-// Write writes lines out to the websocket.
-func (ws WSContainer) Write(msg []byte) (int, error) {
-	err := ws.WriteMessage(websocket.TextMessage, msg)
-	return len(msg), err
+// NetConn returns the connection the websocket is running over, so TLS details (like
+// client certificates) can be looked at.
+func (ws *WSContainer) NetConn() net.Conn {
+	return ws.UnderlyingConn()
 }
 
 // SetDeadline sets the read and write deadline on this websocket.
-func (ws WSContainer) SetDeadline(t time.Time) error {
+func (ws *WSContainer) SetDeadline(t time.Time) error {
 	if err := ws.SetWriteDeadline(t); err != nil {
 		return err
 	}
 	return ws.SetReadDeadline(t)
 }
+
+// webSocketListener is a net.Listener that serves WebSocket upgrades over HTTP on the
+// given listener, and returns each upgraded connection from Accept. This means our
+// listener code (TLS, rehashing, upgrades) treats it like any other listener.
+type webSocketListener struct {
+	net.Listener
+	conns  chan net.Conn
+	server *http.Server
+
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+// newWebSocketListener starts serving WebSocket connections on the given listener, which
+// should already be wrapped in TLS if it uses TLS.
+func newWebSocketListener(server *Server, listener net.Listener, addr string, config *WebSocketListenConfig) *webSocketListener {
+	wl := &webSocketListener{
+		Listener: listener,
+		conns:    make(chan net.Conn),
+		closed:   make(chan bool),
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  2048,
+		WriteBufferSize: 2048,
+		// If a WS session contains sensitive information, and you choose to use
+		// cookies for authentication (during the HTTP(S) upgrade request), then
+		// you should check that Origin is a domain under your control. If it
+		// isn't, then it is possible for users of your site, visiting a naughty
+		// Origin, to have a WS opened using their credentials. See
+		// http://www.christian-schneider.net/CrossSiteWebSocketHijacking.html#main.
+		// The (IRC) authentication is contained in the WS stream, so we only check
+		// Origin for networks that want to limit which sites can embed their clients.
+		CheckOrigin: func(r *http.Request) bool {
+			return config.originAllowed(r.Header.Get("Origin"))
+		},
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// We don't have any subprotocols, so if someone attempts to `new
+		// WebSocket(server, "subprotocol")` they'll break here, instead of
+		// getting the default, ambiguous, response from gorilla.
+		if v, ok := r.Header["Sec-Websocket-Protocol"]; ok {
+			http.Error(w, fmt.Sprintf("WebSocket subprocotols (e.g. %s) not supported", v), http.StatusBadRequest)
+			return
+		}
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			server.logger.Debug("listeners", fmt.Sprintf("WebSocket upgrade on %s failed: %s", addr, err.Error()))
+			return
+		}
+
+		select {
+		case wl.conns <- &WSContainer{Conn: ws}:
+		case <-wl.closed:
+			ws.Close()
+		}
+	}
+
+	// slow clients shouldn't be able to hold connections open before they're upgraded
+	wl.server = &http.Server{
+		Handler:           http.HandlerFunc(handler),
+		ReadHeaderTimeout: webSocketReadHeaderTimeout,
+		IdleTimeout:       webSocketIdleTimeout,
+	}
+	go wl.server.Serve(listener)
+	return wl
+}
+
+// Accept returns the next upgraded WebSocket connection.
+func (wl *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-wl.conns:
+		return conn, nil
+	case <-wl.closed:
+		return nil, errWebSocketListenerClosed
+	}
+}
+
+// Close stops the listener, and the HTTP server running on it. Connections that have
+// already been upgraded are left open.
+func (wl *webSocketListener) Close() error {
+	wl.closeOnce.Do(func() {
+		close(wl.closed)
+	})
+	return wl.server.Close()
+}
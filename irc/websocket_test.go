@@ -0,0 +1,86 @@
+// Copyright (c) 2018 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/oragono/oragono/irc/logger"
+)
+
+func TestWebSocketOrigins(t *testing.T) {
+	open := &WebSocketListenConfig{}
+	if !open.originAllowed("https://anywhere.example") {
+		t.Error("expected any origin to be allowed without an allowlist")
+	}
+
+	conf := &WebSocketListenConfig{AllowedOrigins: []string{"https://irc.example.com/"}}
+	if !conf.originAllowed("https://IRC.example.com") {
+		t.Error("expected an origin in the allowlist to be allowed")
+	}
+	if conf.originAllowed("https://evil.example") {
+		t.Error("expected an origin outside the allowlist to be refused")
+	}
+	if !conf.originAllowed("") {
+		t.Error("expected connections without an origin to be allowed")
+	}
+}
+
+func TestWebSocketListener(t *testing.T) {
+	logman, _ := logger.NewManager()
+	server := &Server{logger: logman}
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &WebSocketListenConfig{AllowedOrigins: []string{"https://irc.example.com"}}
+	listener := newWebSocketListener(server, rawListener, rawListener.Addr().String(), conf)
+	defer listener.Close()
+	url := "ws://" + rawListener.Addr().String() + "/"
+
+	_, _, err = websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil {
+		t.Error("expected connections from other origins to be refused")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://irc.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, isTCP := conn.(*WSContainer).NetConn().(*net.TCPConn); !isTCP {
+		t.Error("expected the websocket to expose the connection it's running over")
+	}
+	if _, isTLS := tlsConnOf(conn); isTLS {
+		t.Error("expected a plain websocket not to be TLS")
+	}
+
+	// each message is one line, whatever kind of message it is
+	ws.WriteMessage(websocket.TextMessage, []byte("NICK dan"))
+	ws.WriteMessage(websocket.BinaryMessage, []byte("USER d 0 * :Dan\r\n"))
+	reader := bufio.NewReaderSize(conn, 16)
+	for _, expected := range []string{"NICK dan\r\n", "USER d 0 * :Dan\r\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != expected {
+			t.Errorf("expected to read %q, got %q (%v)", expected, line, err)
+		}
+	}
+
+	// lines written together are sent as separate messages
+	conn.Write([]byte("PING :one\r\nPING :two\r\n"))
+	for _, expected := range []string{"PING :one", "PING :two"} {
+		ty, message, err := ws.ReadMessage()
+		if err != nil || ty != websocket.TextMessage || string(message) != expected {
+			t.Errorf("expected text message %q, got %q (%v)", expected, message, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// WordFilterBlock stops messages that match the filter from being sent.
+	WordFilterBlock = "block"
+	// WordFilterCensor replaces the parts of messages that match the filter with *s.
+	WordFilterCensor = "censor"
+)
+
+// WordFilterSettings are a channel's word filter, which chanops and above aren't
+// affected by.
+type WordFilterSettings struct {
+	// Patterns are words (which can use * and ?) or regexes between slashes.
+	Patterns []string
+	// Action is what we do to messages that match, WordFilterBlock or WordFilterCensor.
+	Action string
+}
+
+// compileWordFilter compiles the given word filter patterns. Words only match whole
+// words (where anything other than a letter or number separates words, in any script),
+// and neither words nor regexes care about case. The first group of each compiled
+// pattern is the part that gets censored.
+func compileWordFilter(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		var expr string
+		if 2 < len(pattern) && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = "(" + pattern[1:len(pattern)-1] + ")"
+		} else {
+			expr = regexp.QuoteMeta(pattern)
+			expr = strings.Replace(expr, `\*`, `\S*`, -1)
+			expr = strings.Replace(expr, `\?`, `\S`, -1)
+			expr = `(?:^|[^\p{L}\p{N}])(` + expr + `)(?:[^\p{L}\p{N}]|$)`
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] is not valid: %s", pattern, err.Error())
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// WordFilterSettings returns the channel's word filter settings.
+func (channel *Channel) WordFilterSettings() WordFilterSettings {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+	return channel.wordFilter
+}
+
+// SetWordFilterSettings changes the channel's word filter settings.
+func (channel *Channel) SetWordFilterSettings(settings WordFilterSettings) error {
+	channel.membersMutex.Lock()
+	defer channel.membersMutex.Unlock()
+	return channel.setWordFilterSettingsNoMutex(settings)
+}
+
+func (channel *Channel) setWordFilterSettingsNoMutex(settings WordFilterSettings) error {
+	// requires Lock()
+	patterns, err := compileWordFilter(settings.Patterns)
+	if err != nil {
+		return err
+	}
+	channel.wordFilter = settings
+	channel.wordFilterPatterns = patterns
+	return nil
+}
+
+// FilterMessage runs the given message through the channel's word filter. It returns
+// the message to send (which may be censored), or false if the message is blocked.
+func (channel *Channel) FilterMessage(client *Client, message string) (string, bool) {
+	channel.membersMutex.RLock()
+	defer channel.membersMutex.RUnlock()
+
+	if len(channel.wordFilterPatterns) == 0 || client.HasMode(Operator) || channel.clientIsAtLeastNoMutex(client, ChannelOperator) {
+		return message, true
+	}
+
+	// match against the stripped message, so formatting can't be used to get around it.
+	// censored messages lose their formatting too
+	stripped := stripIRCFormatting(message)
+	censored := stripped
+	for _, pattern := range channel.wordFilterPatterns {
+		if !pattern.MatchString(stripped) {
+			continue
+		}
+		if channel.wordFilter.Action != WordFilterCensor {
+			return "", false
+		}
+		censored = censorMatches(pattern, censored)
+	}
+
+	if censored != stripped {
+		return censored, true
+	}
+	return message, true
+}
+
+// censorMatches replaces the first group of each match of the given word filter pattern
+// with asterisks. Word boundaries are part of each match, so a word right after another
+// one is only found after the first is censored, which is why we go until nothing changes.
+func censorMatches(pattern *regexp.Regexp, message string) string {
+	for {
+		var buf bytes.Buffer
+		last := 0
+		for _, match := range pattern.FindAllStringSubmatchIndex(message, -1) {
+			start, end := match[2], match[3]
+			buf.WriteString(message[last:start])
+			buf.WriteString(strings.Repeat("*", utf8.RuneCountInString(message[start:end])))
+			last = end
+		}
+		buf.WriteString(message[last:])
+		if buf.String() == message {
+			return message
+		}
+		message = buf.String()
+	}
+}
+
+// chanservSetFilter handles CS SET <channel> FILTER [ADD|DEL|ACTION|CLEAR] [value],
+// which shows or changes the channel's word filter.
+func (server *Server) chanservSetFilter(client *Client, channel *Channel, params []string) {
+	settings := channel.WordFilterSettings()
+	if len(params) == 0 {
+		action := wordFilterActionName(settings)
+		if len(settings.Patterns) == 0 {
+			client.ChanServNotice(fmt.Sprintf(client.t("%s has no filtered words"), channel.name))
+		} else {
+			client.ChanServNotice(fmt.Sprintf(client.t("Filtered words in %[1]s (action %[2]s): %[3]s"), channel.name, strings.ToUpper(action), strings.Join(settings.Patterns, " ")))
+		}
+		return
+	}
+
+	if !channel.ClientIsAtLeast(client, ChannelFounder) {
+		client.ChanServNotice(client.t("You must be the channel founder to change its word filter"))
+		return
+	}
+	settings, changed := server.changeWordFilter(client, settings, params)
+	if !changed {
+		return
+	}
+	err := channel.SetWordFilterSettings(settings)
+	if err != nil {
+		client.ChanServNotice(fmt.Sprintf(client.t("Could not change the word filter: %s"), err.Error()))
+		return
+	}
+
+	// save the filter for registered channels
+	server.registeredChannelsMutex.Lock()
+	defer server.registeredChannelsMutex.Unlock()
+	server.store.Update(func(tx *buntdb.Tx) error {
+		chanInfo := server.loadChannelNoMutex(tx, channel.nameCasefolded)
+		if chanInfo == nil {
+			return nil
+		}
+		chanInfo.WordFilter = settings
+		server.saveChannelNoMutex(tx, channel.nameCasefolded, *chanInfo)
+		return nil
+	})
+
+	change := strings.Join(params, " ")
+	server.logger.LogFields(logger.LogInfo, "chanserv", client.logFields, fmt.Sprintf("Client %s changed the word filter on channel %s: %s", client.nick, channel.name, change))
+	client.ChanServNotice(fmt.Sprintf(client.t("Changed the word filter on %[1]s: %[2]s"), channel.name, change))
+}
+
+// changeWordFilter returns the given word filter settings with the change in params
+// applied, or false if the change isn't valid.
+func (server *Server) changeWordFilter(client *Client, settings WordFilterSettings, params []string) (WordFilterSettings, bool) {
+
+	var subcommand, value string
+	if 0 < len(params) {
+		subcommand = strings.ToLower(params[0])
+	}
+	if 1 < len(params) {
+		value = strings.Join(params[1:], " ")
+	}
+
+	switch subcommand {
+	case "add":
+		if value == "" {
+			break
+		}
+		for _, pattern := range settings.Patterns {
+			if strings.EqualFold(pattern, value) {
+				return settings, true
+			}
+		}
+		if maxWordFilterPatterns <= len(settings.Patterns) {
+			client.ChanServNotice(client.t("The channel's word filter is full"))
+			return settings, false
+		}
+		_, err := compileWordFilter([]string{value})
+		if err != nil {
+			client.ChanServNotice(fmt.Sprintf(client.t("Could not add to the word filter: %s"), err.Error()))
+			return settings, false
+		}
+		settings.Patterns = append(append([]string(nil), settings.Patterns...), value)
+		return settings, true
+	case "del":
+		if value == "" {
+			break
+		}
+		var patterns []string
+		for _, pattern := range settings.Patterns {
+			if !strings.EqualFold(pattern, value) {
+				patterns = append(patterns, pattern)
+			}
+		}
+		settings.Patterns = patterns
+		return settings, true
+	case "action":
+		value = strings.ToLower(value)
+		if value != WordFilterBlock && value != WordFilterCensor {
+			break
+		}
+		settings.Action = value
+		return settings, true
+	case "clear":
+		settings.Patterns = nil
+		return settings, true
+	}
+
+	client.ChanServNotice(client.t("Syntax: SET <channel> FILTER <ADD|DEL> <word|/regex/>, SET <channel> FILTER ACTION <BLOCK|CENSOR>, or SET <channel> FILTER CLEAR"))
+	return settings, false
+}
+
+// wordFilterActionName returns the name of the channel's word filter action.
+func wordFilterActionName(settings WordFilterSettings) string {
+	if settings.Action == WordFilterCensor {
+		return WordFilterCensor
+	}
+	return WordFilterBlock
+}
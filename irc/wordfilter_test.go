@@ -0,0 +1,68 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	channel := &Channel{
+		members: make(MemberSet),
+	}
+	client := &Client{
+		flags: make(map[Mode]bool),
+	}
+
+	err := channel.SetWordFilterSettings(WordFilterSettings{
+		Patterns: []string{"darn", "heck*", "/f[o0]+bar/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := []string{"oh darn", "DARN it", "what the heckity", "\x02da\x02rn", "f00bar", "(darn)", "ой darn"}
+	for _, message := range blocked {
+		_, allowed := channel.FilterMessage(client, message)
+		if allowed {
+			t.Errorf("Message [%s] should be blocked", message)
+		}
+	}
+	allowedMessages := []string{"darning socks", "check this", "foo bar", "darné", "мыdarn", "darn2"}
+	for _, message := range allowedMessages {
+		filtered, allowed := channel.FilterMessage(client, message)
+		if !allowed || filtered != message {
+			t.Errorf("Message [%s] should be allowed unchanged, got [%s]", message, filtered)
+		}
+	}
+
+	channel.SetWordFilterSettings(WordFilterSettings{
+		Patterns: []string{"darn", "heck*"},
+		Action:   WordFilterCensor,
+	})
+	censored := map[string]string{
+		"oh darn, heckity heck": "oh ****, ******* ****",
+		"darn darn darn":        "**** **** ****",
+		"darnédarn darn":        "darnédarn ****",
+		"\x02nice\x02 day":      "\x02nice\x02 day",
+		"\x02DARN\x02":          "****",
+	}
+	for message, expected := range censored {
+		filtered, allowed := channel.FilterMessage(client, message)
+		if !allowed || filtered != expected {
+			t.Errorf("Message [%s] should be censored to [%s], got [%s]", message, expected, filtered)
+		}
+	}
+
+	client.SetMode(Operator, true)
+	filtered, allowed := channel.FilterMessage(client, "oh darn")
+	if !allowed || filtered != "oh darn" {
+		t.Error("Opers shouldn't be filtered")
+	}
+
+	err = channel.SetWordFilterSettings(WordFilterSettings{Patterns: []string{"/[/"}})
+	if err == nil {
+		t.Error("Invalid regexes shouldn't be accepted")
+	}
+}
@@ -0,0 +1,70 @@
+# irctest controller for Oragono, see https://github.com/DanielOaks/irctest
+#
+# This runs Oragono from the environment (oragono run --env), so every test gets a fresh
+# datastore and the same predictable config. Use it from the irctest folder with:
+#
+#   PATH=/path/to/oragono:$PATH PYTHONPATH=/path/to/oragono/irctest python3 -m irctest oragono_controller
+#
+# or just run `make irctest` from the Oragono folder.
+
+import os
+import subprocess
+
+from irctest.basecontrollers import NotImplementedByController
+from irctest.basecontrollers import BaseServerController, DirectoryBasedController
+
+
+class OragonoController(BaseServerController, DirectoryBasedController):
+    software_name = 'Oragono'
+    supported_sasl_mechanisms = {'PLAIN'}
+
+    def kill_proc(self):
+        self.proc.kill()
+
+    def oragono(self, command, settings):
+        args = ['oragono', command, '--env', '--quiet']
+        for name, value in settings.items():
+            args += ['--set', '{}={}'.format(name, value)]
+        return args
+
+    def run(self, hostname, port, password=None,
+            valid_metadata_keys=None, invalid_metadata_keys=None):
+        if valid_metadata_keys or invalid_metadata_keys:
+            raise NotImplementedByController(
+                'Defining valid and invalid METADATA keys.')
+        assert self.proc is None
+        self.create_config()
+        self.port = port
+
+        settings = {
+            'server-name': 'My.Little.Server',
+            'listen': '{}:{}'.format(hostname, port),
+            'datastore': os.path.join(self.directory, 'ircd.db'),
+            'log-level': 'error',
+        }
+        if password is not None:
+            settings['password'] = password
+
+        # the datastore is created when we start, since we're running from the environment
+        self.proc = subprocess.Popen(self.oragono('run', settings))
+
+    def registerUser(self, case, username, password=None):
+        # accounts are registered over IRC, since that's what clients do
+        client = case.addClient(show_io=False)
+        case.sendLine(client, 'CAP LS 302')
+        case.sendLine(client, 'NICK registration_user')
+        case.sendLine(client, 'USER r e g :user')
+        case.sendLine(client, 'CAP END')
+        while case.getMessage(client).command != '001':
+            pass
+        list(case.getMessages(client))
+        case.sendLine(client, 'ACC REGISTER {} * passphrase :{}'.format(
+            username, password))
+        msg = case.getMessage(client)
+        assert msg.command == '920', msg
+        list(case.getMessages(client))
+        case.removeClient(client)
+
+
+def get_irctest_controller_class():
+    return OragonoController
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -25,15 +27,19 @@ func main() {
 	version := irc.SemVer
 	usage := `oragono.
 Usage:
-	oragono initdb [--conf <filename>] [--quiet]
+	oragono initdb [--conf <filename> | --env [--set <setting>]...] [--quiet]
 	oragono upgradedb [--conf <filename>] [--quiet]
 	oragono genpasswd [--conf <filename>] [--quiet]
-	oragono mkcerts [--conf <filename>] [--quiet]
-	oragono run [--conf <filename>] [--quiet]
+	oragono mkcerts [--conf <filename> | --env [--set <setting>]...] [--quiet]
+	oragono run [--conf <filename> | --env [--set <setting>]...] [--quiet]
+	oragono checkconf [--conf <filename> | --env [--set <setting>]...] [--quiet]
+	oragono service (install|uninstall|start|stop|status) [--conf <filename>]
 	oragono -h | --help
 	oragono --version
 Options:
 	--conf <filename>  Configuration file to use [default: ircd.yaml].
+	--env              Build the config from ORAGONO_* environment variables instead of a file.
+	--set <setting>    Set an environment setting, such as server-name=irc.example.com.
 	--quiet            Don't show startup/shutdown lines.
 	-h --help          Show this screen.
 	--version          Show version.`
@@ -41,22 +47,87 @@ Options:
 	arguments, _ := docopt.Parse(usage, nil, true, version, false)
 
 	configfile := arguments["--conf"].(string)
+
+	if arguments["--env"] == true {
+		configfile = irc.EnvConfigFilename
+		// settings given as flags work just like the environment variables
+		for _, setting := range arguments["--set"].([]string) {
+			parts := strings.SplitN(setting, "=", 2)
+			name := irc.EnvSettingName(parts[0])
+			if _, exists := irc.EnvSettings[strings.TrimPrefix(name, "ORAGONO_")]; !exists || len(parts) < 2 {
+				log.Fatal("Unknown setting: ", setting)
+			}
+			os.Setenv(name, parts[1])
+		}
+	}
+
+	if arguments["service"].(bool) {
+		var action string
+		for _, name := range []string{"install", "uninstall", "start", "stop", "status"} {
+			if arguments[name].(bool) {
+				action = name
+			}
+		}
+		// make sure the service will be able to start
+		if action == "install" {
+			_, err := irc.LoadConfig(configfile)
+			if err != nil {
+				log.Fatal("Config file did not load successfully:", err.Error())
+			}
+		}
+		err := serviceCommand(action, configfile)
+		if err != nil {
+			log.Fatal("Service error: ", err.Error())
+		}
+		return
+	}
+
+	if isWindowsService() {
+		// services are started in the system directory, so make paths in the config
+		// relative to the config file instead
+		err := os.Chdir(filepath.Dir(configfile))
+		if err != nil {
+			log.Fatal("Could not change to the config file's directory:", err.Error())
+		}
+	}
+
 	config, err := irc.LoadConfig(configfile)
 	if err != nil {
 		log.Fatal("Config file did not load successfully:", err.Error())
 	}
 
+	if arguments["checkconf"].(bool) {
+		errs := config.Check()
+		if len(errs) > 0 {
+			for _, err := range errs {
+				log.Println("config error:", err.Error())
+			}
+			log.Fatalf("Config file %s has %d error(s)", configfile, len(errs))
+		}
+		if !arguments["--quiet"].(bool) {
+			log.Println("config file is valid:", configfile)
+		}
+		return
+	}
+
 	// assemble separate log configs
 	var logConfigs []logger.Config
 	for _, lConfig := range config.Logging {
 		logConfigs = append(logConfigs, logger.Config{
-			MethodStdout:  lConfig.MethodStdout,
-			MethodStderr:  lConfig.MethodStderr,
-			MethodFile:    lConfig.MethodFile,
-			Filename:      lConfig.Filename,
-			Level:         lConfig.Level,
-			Types:         lConfig.Types,
-			ExcludedTypes: lConfig.ExcludedTypes,
+			MethodStdout:   lConfig.MethodStdout,
+			MethodStderr:   lConfig.MethodStderr,
+			MethodFile:     lConfig.MethodFile,
+			Filename:       lConfig.Filename,
+			MethodSyslog:   lConfig.MethodSyslog,
+			SyslogNetwork:  lConfig.SyslogNetwork,
+			SyslogAddress:  lConfig.SyslogAddress,
+			MethodJournald: lConfig.MethodJournald,
+			MethodEventlog: lConfig.MethodEventlog,
+			SystemTag:      lConfig.Tag,
+			JSON:           lConfig.FormatJSON,
+			Level:          lConfig.Level,
+			Types:          lConfig.Types,
+			ExcludedTypes:  lConfig.ExcludedTypes,
 		})
 	}
 
@@ -72,7 +143,7 @@ Options:
 			log.Fatal("Error reading password:", err.Error())
 		}
 		password := string(bytePassword)
-		encoded, err := irc.GenerateEncodedPassword(password)
+		encoded, err := irc.GenerateEncodedPassword(password, config.PasswordHashing)
 		if err != nil {
 			log.Fatal("encoding error:", err.Error())
 		}
@@ -130,15 +201,31 @@ Options:
 			logger.Warning("startup", "You are currently running an unreleased beta version of Oragono that may be unstable and could corrupt your database.\nIf you are running a production network, please download the latest build from https://oragono.io/downloads.html and run that instead.")
 		}
 
+		// there's no separate step to make the datastore when running from the environment
+		if configfile == irc.EnvConfigFilename {
+			if _, err := os.Stat(config.Datastore.Path); os.IsNotExist(err) {
+				irc.InitDB(config.Datastore.Path)
+				logger.Info("startup", fmt.Sprintf("Created datastore %s", config.Datastore.Path))
+			}
+		}
+
 		server, err := irc.NewServer(configfile, config, logger)
 		if err != nil {
 			logger.Error("startup", fmt.Sprintf("Could not load server: %s", err.Error()))
 			return
 		}
+		server.HandleSignals()
 		if !arguments["--quiet"].(bool) {
 			logger.Info("startup", "Server running")
 			defer logger.Info("shutdown", fmt.Sprintf("Oragono v%s exiting", irc.SemVer))
 		}
-		server.Run()
+		if isWindowsService() {
+			err = runService(server)
+			if err != nil {
+				logger.Error("shutdown", fmt.Sprintf("Service failed: %s", err.Error()))
+			}
+		} else {
+			server.Run()
+		}
 	}
 }
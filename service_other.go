@@ -0,0 +1,32 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/oragono/oragono/irc"
+)
+
+var (
+	errServiceUnsupported = errors.New("Running as a service is only supported on Windows, use your init system instead")
+)
+
+// isWindowsService returns true if we were started by the Windows service manager.
+func isWindowsService() bool {
+	return false
+}
+
+// runService runs the given server under the Windows service manager.
+func runService(server *irc.Server) error {
+	return errServiceUnsupported
+}
+
+// serviceCommand installs, uninstalls, starts, stops or shows the status of our service.
+func serviceCommand(action string, configfile string) error {
+	return errServiceUnsupported
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2017 Daniel Oaks <daniel@danieloaks.net>
+// released under the MIT license
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oragono/oragono/irc"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "oragono"
+	serviceDisplayName = "Oragono IRC server"
+	serviceDescription = "Oragono is a modern, experimental IRC server."
+)
+
+// isWindowsService returns true if we were started by the Windows service manager.
+func isWindowsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// oragonoService runs the server for the Windows service manager.
+type oragonoService struct {
+	server *irc.Server
+}
+
+// Execute runs the server, and shuts it down gracefully when the service manager asks us to stop.
+func (service *oragonoService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan struct{})
+	go func() {
+		service.server.Run()
+		close(done)
+	}()
+
+	running := svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	status <- running
+	for {
+		select {
+		case <-done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				service.server.RequestShutdown("")
+			}
+		}
+	}
+}
+
+// runService runs the given server under the Windows service manager.
+func runService(server *irc.Server) error {
+	return svc.Run(serviceName, &oragonoService{server: server})
+}
+
+// serviceCommand installs, uninstalls, starts, stops or shows the status of our service.
+func serviceCommand(action string, configfile string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("Could not connect to the service manager: %s", err.Error())
+	}
+	defer manager.Disconnect()
+
+	if action == "install" {
+		return installService(manager, configfile)
+	}
+
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("Could not open the %s service, is it installed? %s", serviceName, err.Error())
+	}
+	defer service.Close()
+
+	switch action {
+	case "uninstall":
+		err = service.Delete()
+		if err != nil {
+			return err
+		}
+		err = eventlog.Remove(serviceName)
+		if err != nil {
+			return fmt.Errorf("Service removed, but could not remove its event log source: %s", err.Error())
+		}
+		fmt.Println("Service uninstalled")
+
+	case "start":
+		err = service.Start()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Service started")
+
+	case "stop":
+		status, err := service.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		// give the server time to shut down gracefully
+		timeout := time.Now().Add(time.Minute)
+		for status.State != svc.Stopped {
+			if timeout.Before(time.Now()) {
+				return errors.New("Timed out waiting for the service to stop")
+			}
+			time.Sleep(300 * time.Millisecond)
+			status, err = service.Query()
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println("Service stopped")
+
+	case "status":
+		status, err := service.Query()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Service is", serviceStateNames[status.State])
+	}
+
+	return nil
+}
+
+// serviceStateNames are readable names for the states a service can be in.
+var serviceStateNames = map[svc.State]string{
+	svc.Stopped:         "stopped",
+	svc.StartPending:    "starting",
+	svc.StopPending:     "stopping",
+	svc.Running:         "running",
+	svc.ContinuePending: "continuing",
+	svc.PausePending:    "pausing",
+	svc.Paused:          "paused",
+}
+
+// installService installs our service, set to run this binary with the given config file.
+func installService(manager *mgr.Mgr, configfile string) error {
+	service, err := manager.OpenService(serviceName)
+	if err == nil {
+		service.Close()
+		return fmt.Errorf("The %s service is already installed", serviceName)
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// the service starts in the system directory, so it needs the full path
+	configPath, err := filepath.Abs(configfile)
+	if err != nil {
+		return err
+	}
+
+	service, err = manager.CreateService(serviceName, exePath, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, "run", "--conf", configPath)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		service.Delete()
+		return fmt.Errorf("Could not set up event log source: %s", err.Error())
+	}
+
+	fmt.Printf("Service installed as %s, using config file %s\n", serviceName, configPath)
+	return nil
+}